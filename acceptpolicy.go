@@ -0,0 +1,69 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+acceptTransferFrom 作为接收方是否接受 initiator 发起的 tokenAddress 交易.
+注意: MediatedTransfer 中的 Initiator 只有上一跳的签名, 发起方没有签名, 任何一跳都可以伪造,
+所以这不是安全控制, 只是过滤诚实节点发来的交易.
+*/
+/*
+ *	acceptTransferFrom : true if we as target accept transfers of tokenAddress from initiator.
+ *	Note: Initiator of MediatedTransfer is signed only by the previous hop, not by the initiator,
+ *	any hop can forge it, so this is not a security control, it only filters transfers from honest nodes.
+ */
+func (rs *Service) acceptTransferFrom(tokenAddress, initiator common.Address) bool {
+	return rs.dao.GetAcceptPolicy().Accept(tokenAddress, initiator)
+}
+
+func checkAcceptRule(r *models.AcceptRule) error {
+	switch r.Mode {
+	case models.AcceptModeAll, models.AcceptModeWhitelist, models.AcceptModeBlacklist:
+		return nil
+	}
+	return fmt.Errorf("unknown accept mode %q, must be %q, %q or empty", r.Mode, models.AcceptModeWhitelist, models.AcceptModeBlacklist)
+}
+
+/*
+SetAcceptPolicy 设置作为接收方接受哪些发起方的交易, 可以为整个节点设置, 也可以按 token 设置.
+被拒绝的交易不会发送 SecretRequest, 锁通过 AnnounceDisposed 直接还给上一跳, 交易记录的失败原因是 initiator_not_accepted.
+只对之后收到的交易有效, 直接通道转账没有锁, 不受影响.
+发起方地址没有经过认证, 见 acceptTransferFrom.
+*/
+/*
+ *	SetAcceptPolicy : set which initiators we accept transfers from as target, for the whole node or per token.
+ *	Refused transfers never get a SecretRequest, the lock is given back to previous hop by AnnounceDisposed,
+ *	and the transfer record fails with reason initiator_not_accepted.
+ *	It only affects transfers received later, direct transfers have no lock and are not affected.
+ *	The initiator is not authenticated, see acceptTransferFrom.
+ */
+func (r *API) SetAcceptPolicy(p *models.AcceptPolicy) (err error) {
+	if p.NodeRule == nil {
+		p.NodeRule = &models.AcceptRule{}
+	}
+	if p.TokenRuleMap == nil {
+		p.TokenRuleMap = make(map[common.Address]*models.AcceptRule)
+	}
+	if err = checkAcceptRule(p.NodeRule); err != nil {
+		return
+	}
+	for token, rule := range p.TokenRuleMap {
+		if rule == nil {
+			return fmt.Errorf("token %s has no accept rule", token.String())
+		}
+		if err = checkAcceptRule(rule); err != nil {
+			return fmt.Errorf("token %s %s", token.String(), err)
+		}
+	}
+	return r.Photon.dao.SaveAcceptPolicy(p)
+}
+
+//GetAcceptPolicy :
+func (r *API) GetAcceptPolicy() *models.AcceptPolicy {
+	return r.Photon.dao.GetAcceptPolicy()
+}
@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 
@@ -11,6 +10,8 @@ import (
 
 	"strings"
 
+	"sync"
+
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/network/helper"
 	"github.com/SmartMeshFoundation/Photon/network/rpc"
@@ -72,8 +73,12 @@ type Events struct {
 	client              *helper.SafeEthClient
 	pollPeriod          time.Duration      // 轮询周期,必须与公链出块间隔一致
 	stopChan            chan int           // has stopped?
+	recoverLock         sync.Mutex         // protects cancelRecover and stopped, Stop runs on another goroutine
+	cancelRecover       context.CancelFunc // stop reconnecting geth when events stop
+	stopped             bool               // no more reconnecting after Stop
 	txDone              map[eventID]uint64 // 该map记录最近30块内处理的events流水,用于事件去重
 	firstStart          bool               //保证ContractHistoryEventCompleteStateChange 只会发送一次
+	noGetLogs           bool               // 节点不支持 eth_getLogs, 改为逐块获取 receipt
 }
 
 //NewBlockChainEvents create BlockChainEvents
@@ -94,6 +99,12 @@ func (be *Events) Stop() {
 	if be.stopChan != nil {
 		close(be.stopChan)
 	}
+	be.recoverLock.Lock()
+	be.stopped = true
+	if be.cancelRecover != nil {
+		be.cancelRecover()
+	}
+	be.recoverLock.Unlock()
 	log.Info("Events stop ok...")
 }
 
@@ -155,7 +166,13 @@ func (be *Events) startAlarmTask() {
 			cancelFunc()
 			if be.stopChan != nil {
 				be.pollPeriod = 0
-				go be.client.RecoverDisconnect()
+				be.recoverLock.Lock()
+				if !be.stopped {
+					var recoverCtx context.Context
+					recoverCtx, be.cancelRecover = context.WithCancel(context.Background())
+					go be.client.RecoverDisconnectContext(recoverCtx)
+				}
+				be.recoverLock.Unlock()
 			}
 			return
 		}
@@ -271,10 +288,40 @@ func (be *Events) getLogsFromChain(fromBlock int64, toBlock int64) (logs []types
 		be.rpcModuleDependency.GetRegistryAddress(),
 		be.rpcModuleDependency.GetSecretRegistryAddress(),
 	}
-	logs, err = rpc.EventsGetInternal(
-		rpc.GetQueryConext(), contractAddresses, fromBlock, toBlock, be.client)
-	if err != nil {
-		return
+	if !be.noGetLogs {
+		logs, err = rpc.EventsGetInternal(
+			rpc.GetQueryConext(), contractAddresses, fromBlock, toBlock, be.client)
+		if !helper.IsMethodNotFound(err) {
+			return
+		}
+		log.Warn("eth_getLogs not supported by node, get events from receipts block by block")
+		be.noGetLogs = true
+	}
+	return be.getLogsFromReceipts(contractAddresses, fromBlock, toBlock)
+}
+
+/*
+getLogsFromReceipts 节点不支持 eth_getLogs 时, 逐块获取所有 receipt 中的 log, 只保留这些合约的.
+*/
+// getLogsFromReceipts : when the node doesn't serve eth_getLogs, logs of these contracts are picked from receipts block by block.
+func (be *Events) getLogsFromReceipts(contractAddresses []common.Address, fromBlock int64, toBlock int64) (logs []types.Log, err error) {
+	for n := fromBlock; n <= toBlock; n++ {
+		h, err := be.client.HeaderByNumber(rpc.GetQueryConext(), big.NewInt(n))
+		if err != nil {
+			return nil, err
+		}
+		blockLogs, err := be.client.GetBlockReceiptsAsLogs(rpc.GetQueryConext(), h.Hash())
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range blockLogs {
+			for _, addr := range contractAddresses {
+				if l.Address == addr {
+					logs = append(logs, l)
+					break
+				}
+			}
+		}
 	}
 	return
 }
@@ -451,23 +498,11 @@ func eventTokenNetworkCreated2StateChange(ev *contracts.TokensNetworkTokenNetwor
 	}
 }
 
-//注意与合约上计算方式保持完全一致.
-func calcChannelID(token, tokensNetwork, p1, p2 common.Address) common.Hash {
-	var channelID common.Hash
-	//log.Trace(fmt.Sprintf("p1=%s,p2=%s,tokennetwork=%s", p1.String(), p2.String(), tokenNetwork.String()))
-	if bytes.Compare(p1[:], p2[:]) < 0 {
-		channelID = utils.Sha3(p1[:], p2[:], token[:], tokensNetwork[:])
-	} else {
-		channelID = utils.Sha3(p2[:], p1[:], token[:], tokensNetwork[:])
-	}
-	return channelID
-}
-
 //eventChannelOpenAndDeposit2StateChange to statechange
 func eventChannelOpenAndDeposit2StateChange(ev *contracts.TokensNetworkChannelOpenedAndDeposit) (ch1 *mediatedtransfer.ContractNewChannelStateChange, ch2 *mediatedtransfer.ContractBalanceStateChange) {
 	ch1 = &mediatedtransfer.ContractNewChannelStateChange{
 		ChannelIdentifier: &contracts.ChannelUniqueID{
-			ChannelIdentifier: calcChannelID(ev.Token, ev.Raw.Address, ev.Participant, ev.Partner),
+			ChannelIdentifier: contracts.CalcChannelID(ev.Token, ev.Raw.Address, ev.Participant, ev.Partner),
 			OpenBlockNumber:   int64(ev.Raw.BlockNumber),
 		},
 		Participant1:  ev.Participant,
@@ -53,6 +53,10 @@ type EndState struct {
 //NewChannelEndState create EndState
 func NewChannelEndState(participantAddress common.Address, participantBalance *big.Int,
 	balanceProof *transfer.BalanceProofState, tree *mtree.Merkletree) *EndState {
+	if tree == nil || tree == mtree.EmptyTree {
+		//the tree is changed in place, EmptyTree is shared
+		tree = new(mtree.Merkletree)
+	}
 	c := &EndState{
 		Address:             participantAddress,
 		ContractBalance:     participantBalance,
@@ -186,6 +190,18 @@ func (node *EndState) getLockByHashlock(lockSecretHash common.Hash) *mtree.Lock
 	return nil
 }
 
+//lockSecretHashOfSecret find the lock whose secret is `secret` under the lock's own hash algorithm
+func (node *EndState) lockSecretHashOfSecret(secret common.Hash) (lockSecretHash common.Hash, found bool) {
+	for _, a := range utils.HashAlgorithms {
+		lockSecretHash = a.HashSecret(secret[:])
+		lock := node.getLockByHashlock(lockSecretHash)
+		if lock != nil && lock.MatchSecret(secret) {
+			return lockSecretHash, true
+		}
+	}
+	return utils.EmptyHash, false
+}
+
 //GetUnkownSecretLockByHashlock returns the hash corresponding Lock,nil if not found
 func (node *EndState) GetUnkownSecretLockByHashlock(lockSecretHash common.Hash) *mtree.Lock {
 	lock, ok := node.Lock2PendingLocks[lockSecretHash]
@@ -214,26 +230,32 @@ func (node *EndState) getSecretByLockSecretHash(lockSecretHash common.Hash) (loc
 computeMerkleRootWith Compute the resulting merkle root if the lock `include` is added in
        the tree.
 */
-func (node *EndState) computeMerkleRootWith(include *mtree.Lock) (tree *mtree.Merkletree, hash common.Hash) {
+func (node *EndState) computeMerkleRootWith(include *mtree.Lock) common.Hash {
 	if !node.IsKnown(include.LockSecretHash) {
-		tree := node.Tree.ComputeMerkleRootWith(include)
-		return tree, tree.MerkleRoot()
+		return node.Tree.RootWith(include)
 	}
-	return nil, node.Tree.MerkleRoot()
+	return node.Tree.MerkleRoot()
 }
 
 /*
  computeMerkleRootWithout Compute the resulting merkle root if the lock `without` is exclude from the tree
 */
-func (node *EndState) computeMerkleRootWithout(without *mtree.Lock) (*mtree.Merkletree, common.Hash, error) {
+func (node *EndState) computeMerkleRootWithout(without *mtree.Lock) (common.Hash, error) {
 	if !node.IsKnown(without.LockSecretHash) {
-		return nil, utils.EmptyHash, errUnknownLock
+		return utils.EmptyHash, errUnknownLock
 	}
-	newtree, err := node.Tree.ComputeMerkleRootWithout(without)
-	if err != nil {
-		return nil, utils.EmptyHash, err
+	return node.Tree.RootWithout(without.Hash())
+}
+
+/*
+removeLockFromTree 消息验证通过以后, 直接在树上移除锁, 不需要复制整棵树
+*/
+// removeLockFromTree : remove lock from the tree in place after the message is verified, the tree is not copied.
+func (node *EndState) removeLockFromTree(lock *mtree.Lock) {
+	if _, err := node.Tree.RemoveLock(lock.Hash()); err != nil {
+		//computeMerkleRootWithout has succeeded, so this never happens
+		panic(fmt.Sprintf("remove lock %s from tree of %s err %s", lock, utils.APex(node.Address), err))
 	}
-	return newtree, newtree.MerkleRoot(), nil
 }
 func (node *EndState) balanceProofRegisteredOnChain() bool {
 	b := node.BalanceProofState
@@ -283,11 +305,13 @@ this message may be sent out from this node or received from partner
 //	return node.registerRemoveLock(response, response.LockSecretHash)
 //}
 
-func (node *EndState) registerRemoveLock(msg encoding.EnvelopMessager, lockSecretHash common.Hash) error {
+func (node *EndState) registerRemoveLock(msg encoding.EnvelopMessager, lock *mtree.Lock) error {
 	if node.balanceProofRegisteredOnChain() {
 		return errBalanceProofAlreadyRegisteredOnChain
 	}
+	lockSecretHash := lock.LockSecretHash
 	balanceProof := transfer.NewBalanceProofStateFromEnvelopMessage(msg)
+	node.removeLockFromTree(lock)
 	node.BalanceProofState = balanceProof
 	delete(node.Lock2PendingLocks, lockSecretHash)
 	delete(node.Lock2UnclaimedLocks, lockSecretHash)
@@ -314,14 +338,18 @@ func (node *EndState) registerSecretMessage(unlock *encoding.UnLock) (err error)
 		return errBalanceProofAlreadyRegisteredOnChain
 	}
 	balanceProof := transfer.NewBalanceProofStateFromEnvelopMessage(unlock)
-	lockSecretHash := utils.ShaSecret(unlock.LockSecret[:])
+	lockSecretHash := unlock.LockSecretHash()
 	lock := node.getLockByHashlock(lockSecretHash)
 	if lock == nil {
 		err = fmt.Errorf(" receive unlock message,but has no related lockSecretHash,msg=%s", utils.StringInterface(unlock, 3))
 		log.Error(err.Error())
 		return err
 	}
-	newtree, newLocksroot, err := node.computeMerkleRootWithout(lock)
+	//the lock must be unlocked with the hash algorithm it was created with
+	if lock.HashAlgorithm != unlock.HashAlgorithm {
+		return fmt.Errorf("unlock with hash algorithm %s,but lock %s uses %s", unlock.HashAlgorithm, utils.HPex(lockSecretHash), lock.HashAlgorithm)
+	}
+	newLocksroot, err := node.computeMerkleRootWithout(lock)
 	if err != nil {
 		return err
 	}
@@ -343,7 +371,7 @@ func (node *EndState) registerSecretMessage(unlock *encoding.UnLock) (err error)
 		确保所有的信息都是正确的,才能更新状态
 	*/
 	// Verify messages are correct then update channel state.
-	node.Tree = newtree
+	node.removeLockFromTree(lock)
 	node.BalanceProofState = balanceProof
 	return nil
 }
@@ -379,7 +407,7 @@ func (node *EndState) registerMediatedMessage(mtr *encoding.MediatedTransfer) (e
 	if balanceProof.TransferAmount.Cmp(node.TransferAmount()) < 0 {
 		return fmt.Errorf("transfer amount decrease,now=%s, message=%s", node.TransferAmount(), mtr)
 	}
-	newtree, locksroot := node.computeMerkleRootWith(lock)
+	locksroot := node.computeMerkleRootWith(lock)
 	lockhashed := utils.Sha3(lock.AsBytes())
 	if balanceProof.LocksRoot != locksroot {
 		return &InvalidLocksRootError{
@@ -392,14 +420,14 @@ func (node *EndState) registerMediatedMessage(mtr *encoding.MediatedTransfer) (e
 		LockHash: lockhashed,
 	}
 	node.BalanceProofState = balanceProof
-	node.Tree = newtree
+	node.Tree.AddLock(lock)
 	return nil
 }
 
 /*
 TryRemoveHashLock try to remomve a expired hashlock
 */
-func (node *EndState) TryRemoveHashLock(lockSecretHash common.Hash, blockNumber int64, mustExpired bool) (lock *mtree.Lock, newtree *mtree.Merkletree, newlocksroot common.Hash, err error) {
+func (node *EndState) TryRemoveHashLock(lockSecretHash common.Hash, blockNumber int64, mustExpired bool) (lock *mtree.Lock, newlocksroot common.Hash, err error) {
 	//链上已经注册密码的锁是一定不能移除的,无论什么原因都不能移除此锁.除非是unlock消息
 	lock = node.GetUnkownSecretLockByHashlock(lockSecretHash)
 	if lock == nil {
@@ -410,10 +438,7 @@ func (node *EndState) TryRemoveHashLock(lockSecretHash common.Hash, blockNumber
 		err = fmt.Errorf("try to remove a lock which is not expired, expired=%d,currentBlockNumber=%d", lock.Expiration, blockNumber)
 		return
 	}
-	newtree, newlocksroot, err = node.computeMerkleRootWithout(lock)
-	if err != nil {
-		return
-	}
+	newlocksroot, err = node.computeMerkleRootWithout(lock)
 	return
 }
 
@@ -423,8 +448,8 @@ RegisterSecret register a secret(not secret message) so that it can be used in a
             This methods needs to be called once a `Secret` message is received
 */
 func (node *EndState) RegisterSecret(secret common.Hash) error {
-	hashlock := utils.ShaSecret(secret[:])
-	if !node.IsKnown(hashlock) {
+	hashlock, found := node.lockSecretHashOfSecret(secret)
+	if !found {
 		return errors.New("secret does not correspond to any hashlock")
 	}
 	if node.IsLocked(hashlock) {
@@ -28,7 +28,7 @@ ExternalState operation on nettingchannelcontract
 */
 type ExternalState struct {
 	funcRegisterChannelForHashlock FuncRegisterChannelForHashlock
-	TokenNetwork                   *rpc.TokenNetworkProxy
+	TokenNetwork                   rpc.TokenNetworkContract
 	auth                           *bind.TransactOpts
 	privKey                        *ecdsa.PrivateKey
 	Client                         *helper.SafeEthClient
@@ -38,11 +38,12 @@ type ExternalState struct {
 	MyAddress                      common.Address
 	PartnerAddress                 common.Address
 	db                             channeltype.Db
+	Invariant                      *InvariantConfig //how to check and report invariant violations, nil means defaults
 }
 
 //NewChannelExternalState create a new channel external state
 func NewChannelExternalState(fun FuncRegisterChannelForHashlock,
-	tokenNetwork *rpc.TokenNetworkProxy, channelIdentifier *contracts.ChannelUniqueID, privkey *ecdsa.PrivateKey, client *helper.SafeEthClient, db channeltype.Db, closedBlock int64, MyAddress, PartnerAddress common.Address) *ExternalState {
+	tokenNetwork rpc.TokenNetworkContract, channelIdentifier *contracts.ChannelUniqueID, privkey *ecdsa.PrivateKey, client *helper.SafeEthClient, db channeltype.Db, closedBlock int64, MyAddress, PartnerAddress common.Address) *ExternalState {
 	cs := &ExternalState{
 		funcRegisterChannelForHashlock: fun,
 		TokenNetwork:                   tokenNetwork,
@@ -99,7 +100,11 @@ func (e *ExternalState) Close(balanceProof *transfer.BalanceProofState) (result
 		MessageHash = balanceProof.MessageHash
 		Signature = balanceProof.Signature
 	}
-	result = e.TokenNetwork.CloseChannelAsync(e.PartnerAddress, TransferAmount, LocksRoot, Nonce, MessageHash, Signature)
+	result = utils.NewAsyncResult()
+	go func() {
+		//partner may close the channel first, then update its balance proof instead
+		result.Result <- rpc.CloseOrUpdate(e.TokenNetwork, e.MyAddress, e.PartnerAddress, TransferAmount, LocksRoot, Nonce, MessageHash, Signature)
+	}()
 	return
 }
 
@@ -112,20 +117,32 @@ func (e *ExternalState) UpdateTransfer(bp *transfer.BalanceProofState) (result *
 	}
 	log.Info(fmt.Sprintf("UpdateTransfer %s called ,BalanceProofState=%s",
 		utils.HPex(e.ChannelIdentifier.ChannelIdentifier), utils.StringInterface(bp, 3)))
-	result = e.TokenNetwork.UpdateBalanceProofAsync(e.PartnerAddress, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature)
+	result = utils.NewAsyncResult()
+	go func() {
+		//this balance proof may have been submitted already, e.g. by a monitor, no need to update again
+		_, balanceHash, _, err := e.TokenNetwork.GetChannelParticipantInfo(e.PartnerAddress, e.MyAddress)
+		if err == nil && utils.BalanceHashMatches(balanceHash, bp.TransferAmount, bp.LocksRoot) {
+			log.Info(fmt.Sprintf("UpdateTransfer %s skipped, balance hash on chain is up to date", utils.HPex(e.ChannelIdentifier.ChannelIdentifier)))
+			result.Result <- nil
+			return
+		}
+		result.Result <- <-e.TokenNetwork.UpdateBalanceProofAsync(e.PartnerAddress, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.MessageHash, bp.Signature).Result
+	}()
 	return
 }
 
 /*
 Unlock call withdraw function of contract
 调用者要确保不包含自己声明放弃过的锁
+locksroot 是对方在链上的 locksroot, 无法验证的 proof 不会提交.
 */
 /*
  *	Unlock : function to unlock.
  *
  *	Note that caller has to ensure that there aren't locks that claimed abandoned by him contained.
+ *	locksroot is partner's locksroot on chain, proofs which can't be verified against it are not submitted.
  */
-func (e *ExternalState) Unlock(unlockproofs []*channeltype.UnlockProof, argTransferdAmount *big.Int) (result *utils.AsyncResult) {
+func (e *ExternalState) Unlock(unlockproofs []*channeltype.UnlockProof, argTransferdAmount *big.Int, locksroot common.Hash) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
 	transferAmount := new(big.Int).Set(argTransferdAmount)
 	go func() {
@@ -136,6 +153,12 @@ func (e *ExternalState) Unlock(unlockproofs []*channeltype.UnlockProof, argTrans
 				log.Info(fmt.Sprintf("Unlock secret has been used %s  %s", e.ChannelIdentifier.String(), utils.HPex(proof.Lock.LockSecretHash)))
 				continue
 			}
+			if !mtree.VerifyProof(locksroot, proof.MerkleProof, proof.Lock.Hash()) {
+				log.Error(fmt.Sprintf("Unlock %s skipped, proof of lock %s doesn't match locksroot %s",
+					utils.HPex(e.ChannelIdentifier.ChannelIdentifier), proof.Lock, utils.HPex(locksroot)))
+				failed = true
+				continue
+			}
 			err := e.TokenNetwork.Unlock(e.PartnerAddress, transferAmount, proof.Lock, mtree.Proof2Bytes(proof.MerkleProof))
 			if err != nil {
 				failed = true
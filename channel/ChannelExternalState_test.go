@@ -0,0 +1,350 @@
+package channel
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type fakeCall struct {
+	method         string
+	partner        common.Address
+	transferAmount *big.Int
+	locksRoot      common.Hash
+	nonce          uint64
+	lock           *mtree.Lock
+}
+
+// fakeTokenNetwork records every contract call instead of sending tx
+type fakeTokenNetwork struct {
+	calls       []*fakeCall
+	unlockErr   map[common.Hash]error
+	balanceHash map[common.Address]common.Hash //balance hash on chain of participant
+	state       uint8                          //channel state on chain, 0 means not found
+	closeErr    error
+}
+
+func newFakeTokenNetwork() *fakeTokenNetwork {
+	return &fakeTokenNetwork{
+		unlockErr:   make(map[common.Hash]error),
+		balanceHash: make(map[common.Address]common.Hash),
+	}
+}
+
+func (f *fakeTokenNetwork) record(c *fakeCall) error {
+	f.calls = append(f.calls, c)
+	return nil
+}
+
+func (f *fakeTokenNetwork) GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error) {
+	if f.state == 0 {
+		return utils.EmptyHash, 0, 0, 0, 0, errors.New("not found")
+	}
+	return utils.EmptyHash, 0, 0, f.state, 0, nil
+}
+
+func (f *fakeTokenNetwork) GetChannelParticipantInfo(participant, partner common.Address) (deposit *big.Int, balanceHash common.Hash, nonce uint64, err error) {
+	h, ok := f.balanceHash[participant]
+	if !ok {
+		return nil, utils.EmptyHash, 0, errors.New("not found")
+	}
+	return big.NewInt(0), h, 0, nil
+}
+
+func (f *fakeTokenNetwork) CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
+	if f.closeErr != nil {
+		return f.closeErr
+	}
+	return f.record(&fakeCall{method: "CloseChannel", partner: partnerAddr, transferAmount: transferAmount, locksRoot: locksRoot, nonce: nonce})
+}
+
+func (f *fakeTokenNetwork) CloseChannelAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.CloseChannel(partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature))
+}
+
+func (f *fakeTokenNetwork) UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
+	return f.record(&fakeCall{method: "UpdateBalanceProof", partner: partnerAddr, transferAmount: transferAmount, locksRoot: locksRoot, nonce: nonce})
+}
+
+func (f *fakeTokenNetwork) UpdateBalanceProofAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.UpdateBalanceProof(partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature))
+}
+
+func (f *fakeTokenNetwork) Unlock(partnerAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte) (err error) {
+	if err = f.unlockErr[lock.LockSecretHash]; err != nil {
+		return
+	}
+	return f.record(&fakeCall{method: "Unlock", partner: partnerAddr, transferAmount: new(big.Int).Set(transferAmount), lock: lock})
+}
+
+func (f *fakeTokenNetwork) SettleChannel(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error) {
+	return f.record(&fakeCall{method: "SettleChannel", partner: p2Addr, transferAmount: p2Amount, locksRoot: p2Locksroot})
+}
+
+func (f *fakeTokenNetwork) SettleChannelAsync(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.SettleChannel(p1Addr, p2Addr, p1Amount, p2Amount, p1Locksroot, p2Locksroot))
+}
+
+func (f *fakeTokenNetwork) PunishObsoleteUnlock(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (err error) {
+	return f.record(&fakeCall{method: "PunishObsoleteUnlock", partner: cheater})
+}
+
+func (f *fakeTokenNetwork) PunishObsoleteUnlockAsync(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.PunishObsoleteUnlock(beneficiary, cheater, lockhash, extraHash, cheaterSignature))
+}
+
+func (f *fakeTokenNetwork) NewChannelAndDepositAsync(participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.record(&fakeCall{method: "NewChannelAndDeposit", partner: partnerAddress, transferAmount: amount}))
+}
+
+func (f *fakeTokenNetwork) CooperativeSettleAsync(p1Addr, p2Addr common.Address, p1Balance, p2Balance *big.Int, p1Signature, p2Signatue []byte) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.record(&fakeCall{method: "CooperativeSettle", partner: p2Addr, transferAmount: p2Balance}))
+}
+
+func (f *fakeTokenNetwork) WithdrawAsync(p1Addr, p2Addr common.Address, p1Balance, p1Withdraw *big.Int, p1Signature, p2Signature []byte) (result *utils.AsyncResult) {
+	return utils.NewAsyncResultWithError(f.record(&fakeCall{method: "Withdraw", partner: p2Addr, transferAmount: p1Withdraw}))
+}
+
+func makeFakeExternState(tn *fakeTokenNetwork) *ExternalState {
+	privkey, addr := utils.MakePrivateKeyAddress()
+	channelIdentifer := &contracts.ChannelUniqueID{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	}
+	return NewChannelExternalState(testFuncRegisterChannelForHashlock, tn, channelIdentifer,
+		privkey, nil, channeltype.NewMockChannelDb(), 0, addr, utils.NewRandomAddress())
+}
+
+func TestExternalStateCloseWithFakeContract(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	e := makeFakeExternState(tn)
+	bp := &transfer.BalanceProofState{
+		Nonce:          3,
+		TransferAmount: big.NewInt(10),
+		LocksRoot:      utils.NewRandomHash(),
+	}
+	err := <-e.Close(bp).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 || tn.calls[0].method != "CloseChannel" {
+		t.Fatalf("expect one close, got %v", tn.calls)
+	}
+	c := tn.calls[0]
+	if c.partner != e.PartnerAddress || c.nonce != 3 || c.transferAmount.Cmp(big.NewInt(10)) != 0 || c.locksRoot != bp.LocksRoot {
+		t.Errorf("close with wrong balance proof %v", c)
+	}
+	//close without balance proof
+	err = <-e.Close(nil).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c = tn.calls[1]; c.nonce != 0 || c.transferAmount.Sign() != 0 || c.locksRoot != utils.EmptyHash {
+		t.Errorf("close without balance proof should use empty one %v", c)
+	}
+	//already closed, contract should not be called
+	e.SetClosed(10)
+	err = <-e.Close(bp).Result
+	if err == nil {
+		t.Error("close a closed channel should fail")
+	}
+	if len(tn.calls) != 2 {
+		t.Errorf("contract called after channel closed")
+	}
+}
+
+//partner's close lands first, our close reverts
+func TestExternalStateCloseAfterPartnerClosed(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	tn.closeErr = errors.New("gas required exceeds allowance or always failing transaction")
+	tn.state = contracts.ChannelStateClosed
+	e := makeFakeExternState(tn)
+	bp := &transfer.BalanceProofState{
+		Nonce:          3,
+		TransferAmount: big.NewInt(10),
+		LocksRoot:      utils.NewRandomHash(),
+	}
+	err := <-e.Close(bp).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 || tn.calls[0].method != "UpdateBalanceProof" {
+		t.Fatalf("expect update instead of close, got %v", tn.calls)
+	}
+	if c := tn.calls[0]; c.partner != e.PartnerAddress || c.nonce != 3 || c.transferAmount.Cmp(big.NewInt(10)) != 0 || c.locksRoot != bp.LocksRoot {
+		t.Errorf("update with wrong balance proof %v", c)
+	}
+	//no balance proof of partner, nothing to update
+	err = <-e.Close(nil).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 {
+		t.Errorf("update without balance proof %v", tn.calls)
+	}
+	//channel still open, the error is not about close race
+	tn.state = contracts.ChannelStateOpened
+	err = <-e.Close(bp).Result
+	if err != tn.closeErr {
+		t.Errorf("expect original close error, got %v", err)
+	}
+	if rpc.ClassifyContractError(tn.closeErr, tn, e.MyAddress, e.PartnerAddress) != tn.closeErr {
+		t.Error("open channel should not be classified as closed")
+	}
+	tn.state = contracts.ChannelStateClosed
+	if rpc.ClassifyContractError(tn.closeErr, tn, e.MyAddress, e.PartnerAddress) != rpc.ErrChannelAlreadyClosed {
+		t.Error("closed channel should be classified as ErrChannelAlreadyClosed")
+	}
+}
+
+func TestExternalStateUpdateTransferWithFakeContract(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	e := makeFakeExternState(tn)
+	bp := &transfer.BalanceProofState{
+		Nonce:          3,
+		TransferAmount: big.NewInt(10),
+		LocksRoot:      utils.NewRandomHash(),
+	}
+	err := <-e.UpdateTransfer(bp).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 || tn.calls[0].method != "UpdateBalanceProof" || tn.calls[0].nonce != 3 {
+		t.Fatalf("expect one update, got %v", tn.calls)
+	}
+	//balance hash on chain is already up to date, skip
+	tn.balanceHash[e.PartnerAddress] = utils.BalanceHash(bp.TransferAmount, bp.LocksRoot)
+	err = <-e.UpdateTransfer(bp).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 {
+		t.Errorf("redundant update should be skipped")
+	}
+	bp.TransferAmount = big.NewInt(20)
+	err = <-e.UpdateTransfer(bp).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 2 {
+		t.Errorf("new balance proof should be updated")
+	}
+}
+
+func TestExternalStateSettleWithFakeContract(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	e := makeFakeExternState(tn)
+	err := <-e.Settle(big.NewInt(1), big.NewInt(2), utils.EmptyHash, utils.EmptyHash).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 || tn.calls[0].partner != e.PartnerAddress || tn.calls[0].transferAmount.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("settle with wrong args %v", tn.calls)
+	}
+	e.SetSettled(20)
+	err = <-e.Settle(big.NewInt(1), big.NewInt(2), utils.EmptyHash, utils.EmptyHash).Result
+	if err == nil {
+		t.Error("settle a settled channel should fail")
+	}
+	if len(tn.calls) != 1 {
+		t.Errorf("contract called after channel settled")
+	}
+}
+
+func TestExternalStateUnlockWithFakeContract(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	e := makeFakeExternState(tn)
+	var locks []*mtree.Lock
+	for i := 1; i <= 3; i++ {
+		locks = append(locks, &mtree.Lock{
+			Expiration:     int64(100 + i),
+			Amount:         big.NewInt(int64(i)),
+			LockSecretHash: utils.NewRandomHash(),
+		})
+	}
+	tree := mtree.NewMerkleTree(locks)
+	locksroot := tree.MerkleRoot()
+	var proofs []*channeltype.UnlockProof
+	for _, l := range locks {
+		proofs = append(proofs, ComputeProofForLock(l, tree))
+	}
+	//first lock has been unlocked before, must be skipped
+	e.db.UnlockThisLock(e.ChannelIdentifier.ChannelIdentifier, proofs[0].Lock.LockSecretHash)
+	err := <-e.Unlock(proofs, big.NewInt(10), locksroot).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 2 {
+		t.Fatalf("expect 2 unlock,got %d", len(tn.calls))
+	}
+	//transfer amount grows after every successful unlock
+	if tn.calls[0].lock != proofs[1].Lock || tn.calls[0].transferAmount.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("first unlock wrong %v", tn.calls[0])
+	}
+	if tn.calls[1].lock != proofs[2].Lock || tn.calls[1].transferAmount.Cmp(big.NewInt(12)) != 0 {
+		t.Errorf("second unlock wrong %v", tn.calls[1])
+	}
+	for _, p := range proofs {
+		if !e.db.IsThisLockHasUnlocked(e.ChannelIdentifier.ChannelIdentifier, p.Lock.LockSecretHash) {
+			t.Errorf("lock %s should be marked unlocked", utils.HPex(p.Lock.LockSecretHash))
+		}
+	}
+
+	//a failed unlock is reported and not marked
+	tn = newFakeTokenNetwork()
+	e = makeFakeExternState(tn)
+	tn.unlockErr[proofs[1].Lock.LockSecretHash] = errors.New("tx failed")
+	err = <-e.Unlock(proofs, big.NewInt(10), locksroot).Result
+	if err == nil {
+		t.Error("unlock should fail")
+	}
+	if e.db.IsThisLockHasUnlocked(e.ChannelIdentifier.ChannelIdentifier, proofs[1].Lock.LockSecretHash) {
+		t.Error("failed lock should not be marked unlocked")
+	}
+	if len(tn.calls) != 2 || tn.calls[1].transferAmount.Cmp(big.NewInt(11)) != 0 {
+		t.Errorf("failed unlock should not change transfer amount %v", tn.calls)
+	}
+
+	//proof doesn't match locksroot, never sent to contract
+	tn = newFakeTokenNetwork()
+	e = makeFakeExternState(tn)
+	err = <-e.Unlock(proofs, big.NewInt(10), utils.NewRandomHash()).Result
+	if err == nil {
+		t.Error("unlock with wrong locksroot should fail")
+	}
+	if len(tn.calls) != 0 {
+		t.Errorf("unverified proof submitted %v", tn.calls)
+	}
+}
+
+func TestChannelCooperativeSettleWithFakeContract(t *testing.T) {
+	tn := newFakeTokenNetwork()
+	e := makeFakeExternState(tn)
+	ourState := NewChannelEndState(e.MyAddress, big.NewInt(100), nil, mtree.EmptyTree)
+	partnerState := NewChannelEndState(e.PartnerAddress, big.NewInt(50), nil, mtree.EmptyTree)
+	c, err := NewChannel(ourState, partnerState, e, utils.NewRandomAddress(), &e.ChannelIdentifier, 7, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.CreateCooperativeSettleRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := encoding.NewSettleResponse(&encoding.SettleResponseData{SettleDataInMessage: s.SettleDataInMessage})
+	err = <-c.CooperativeSettleChannel(res).Result
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tn.calls) != 1 || tn.calls[0].method != "CooperativeSettle" || tn.calls[0].transferAmount.Cmp(big.NewInt(50)) != 0 {
+		t.Errorf("cooperative settle with wrong args %v", tn.calls)
+	}
+}
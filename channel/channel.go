@@ -31,6 +31,8 @@ type Channel struct {
 	SettleTimeout     int
 	feeCharger        fee.Charger //calc fee for each transfer?
 	State             channeltype.State
+	frozenErr         error                 //not nil when this channel violates invariants,see CheckInvariants
+	nonceGap          *channeltype.NonceGap //not nil when balance proofs of partner are missing, see NonceGap
 }
 
 /*
@@ -77,9 +79,15 @@ func (c *Channel) Distributable() *big.Int {
 /*
 CanTransfer  a closed channel and has no Balance channel cannot
 transfer tokens to partner.
+Neither can a channel missing balance proofs of partner, see NonceGap.
 */
 func (c *Channel) CanTransfer() bool {
-	return channeltype.CanTransferMap[c.State]
+	return c.CanReceiveTransfer() && c.nonceGap == nil
+}
+
+//CanReceiveTransfer whether partner can send transfers to us, a nonce gap doesn't matter, partner retransmits through it
+func (c *Channel) CanReceiveTransfer() bool {
+	return channeltype.CanTransferMap[c.State] && c.frozenErr == nil
 }
 
 /*
@@ -180,7 +188,7 @@ func (c *Channel) HandleBalanceProofUpdated(updatedParticipant common.Address, t
 	if updatedParticipant == c.OurState.Address {
 		unlockProofs := c.PartnerState.GetCanUnlockOnChainLocks()
 		if len(unlockProofs) > 0 {
-			result := c.ExternState.Unlock(unlockProofs, c.PartnerState.contractTransferAmount())
+			result := c.ExternState.Unlock(unlockProofs, c.PartnerState.contractTransferAmount(), c.PartnerState.contractLocksRoot())
 			go func() {
 				err := <-result.Result
 				if err != nil {
@@ -268,7 +276,7 @@ func (c *Channel) HandleClosed(closingAddress common.Address, transferredAmount
 	if closingAddress == c.OurState.Address {
 		unlockProofs := c.PartnerState.GetCanUnlockOnChainLocks()
 		if len(unlockProofs) > 0 {
-			result := c.ExternState.Unlock(unlockProofs, c.PartnerState.contractTransferAmount())
+			result := c.ExternState.Unlock(unlockProofs, c.PartnerState.contractTransferAmount(), c.PartnerState.contractLocksRoot())
 			go func() {
 				err := <-result.Result
 				if err != nil {
@@ -327,13 +335,14 @@ func (c *Channel) HandleWithdrawed(newOpenBlockNumber int64, participant1, parti
 	p1.BalanceProofState = transfer.NewEmptyBalanceProofState()
 	p1.Lock2PendingLocks = make(map[common.Hash]channeltype.PendingLock)
 	p1.Lock2UnclaimedLocks = make(map[common.Hash]channeltype.UnlockPartialProof)
-	p1.Tree = mtree.NewMerkleTree(nil)
+	p1.Tree = new(mtree.Merkletree)
 	p2.ContractBalance = participant2Balance
 	p2.BalanceProofState = transfer.NewEmptyBalanceProofState()
 	p2.Lock2PendingLocks = make(map[common.Hash]channeltype.PendingLock)
 	p2.Lock2UnclaimedLocks = make(map[common.Hash]channeltype.UnlockPartialProof)
-	p2.Tree = mtree.NewMerkleTree(nil)
-
+	p2.Tree = new(mtree.Merkletree)
+	//balance proofs of both sides are reset, nonce starts from 0 again
+	c.assertInvariants(0, 0)
 }
 
 /*
@@ -374,28 +383,27 @@ RegisterSecret Register a secret to this channel
             secret: The secret that releases a locked transfer.
 */
 func (c *Channel) RegisterSecret(secret common.Hash) error {
-	hashlock := utils.ShaSecret(secret[:])
-	ourKnown := c.OurState.IsKnown(hashlock)
-	partenerKnown := c.PartnerState.IsKnown(hashlock)
+	ourHashlock, ourKnown := c.OurState.lockSecretHashOfSecret(secret)
+	partnerHashlock, partenerKnown := c.PartnerState.lockSecretHashOfSecret(secret)
 	if !ourKnown && !partenerKnown {
-		return fmt.Errorf("secret doesn't correspond to a registered hashlock. hashlock %s token %s",
-			utils.Pex(hashlock[:]), utils.HPex(c.ChannelIdentifier.ChannelIdentifier))
+		return fmt.Errorf("secret doesn't correspond to a registered hashlock. secret %s token %s",
+			utils.HPex(secret), utils.HPex(c.ChannelIdentifier.ChannelIdentifier))
 	}
 	if ourKnown {
-		lock := c.OurState.getLockByHashlock(hashlock)
+		lock := c.OurState.getLockByHashlock(ourHashlock)
 		log.Debug(fmt.Sprintf("secret registered node=%s,from=%s,to=%s,token=%s,hashlock=%s, secret=%s, amount=%s",
 			utils.Pex(c.OurState.Address[:]), utils.Pex(c.OurState.Address[:]),
 			utils.Pex(c.PartnerState.Address[:]), utils.APex(c.TokenAddress),
-			utils.Pex(hashlock[:]), utils.Pex(secret[:]), lock.Amount))
+			utils.Pex(ourHashlock[:]), utils.Pex(secret[:]), lock.Amount))
 		err := c.OurState.RegisterSecret(secret)
 		return err
 	}
 	if partenerKnown {
-		lock := c.PartnerState.getLockByHashlock(hashlock)
+		lock := c.PartnerState.getLockByHashlock(partnerHashlock)
 		log.Debug(fmt.Sprintf("secret registered node=%s,from=%s,to=%s,token=%s,hashlock=%s, secret=%s, amount=%s",
 			utils.Pex(c.OurState.Address[:]), utils.Pex(c.PartnerState.Address[:]),
 			utils.Pex(c.OurState.Address[:]), utils.APex(c.TokenAddress),
-			utils.Pex(hashlock[:]), utils.Pex(secret[:]), lock.Amount))
+			utils.Pex(partnerHashlock[:]), utils.Pex(secret[:]), lock.Amount))
 		err := c.PartnerState.RegisterSecret(secret)
 		if err != nil {
 			return err
@@ -446,11 +454,11 @@ func (c *Channel) RegisterTransfer(blocknumber int64, tr encoding.EnvelopMessage
 	var err error
 	switch msg := tr.(type) {
 	case *encoding.MediatedTransfer:
-		err = c.registerMediatedTranser(msg, blocknumber)
+		err = c.updateBalanceProof(func() error { return c.registerMediatedTranser(msg, blocknumber) })
 	case *encoding.DirectTransfer:
-		err = c.registerDirectTransfer(msg, blocknumber)
+		err = c.updateBalanceProof(func() error { return c.registerDirectTransfer(msg, blocknumber) })
 	case *encoding.UnLock:
-		err = c.registerUnlock(msg, blocknumber)
+		err = c.updateBalanceProof(func() error { return c.registerUnlock(msg, blocknumber) })
 	case *encoding.AnnounceDisposedResponse:
 		err = c.RegisterAnnounceDisposedResponse(msg, blocknumber)
 	case *encoding.RemoveExpiredHashlockTransfer:
@@ -458,6 +466,9 @@ func (c *Channel) RegisterTransfer(blocknumber int64, tr encoding.EnvelopMessage
 	default:
 		return fmt.Errorf("receive unkonw transfer %s", tr)
 	}
+	if err == nil {
+		c.updateNonceGap()
+	}
 	return err
 }
 
@@ -487,6 +498,17 @@ func (c *Channel) PreCheckRecievedTransfer(tr encoding.EnvelopMessager) (fromSta
 			Strictly monotonic value used to order transfers. The nonce starts at 1
 	*/
 	isInvalidNonce := evMsg.Nonce < 1 || evMsg.Nonce != fromState.nonce()+1
+	/*
+		对方的 nonce 跳过了一些, 说明中间的消息丢了, 请求对方重发, 而不是一直拒绝
+	*/
+	// nonce of partner skips ahead, messages in between are lost, ask partner to retransmit instead of rejecting forever
+	if isInvalidNonce && fromState == c.PartnerState && evMsg.Nonce > fromState.nonce()+1 {
+		log.Info(fmt.Sprintf("nonce gap node=%s,from=%s,expected nonce=%d,nonce=%d",
+			utils.Pex(c.OurState.Address[:]), utils.Pex(fromState.Address[:]), fromState.nonce()+1, evMsg.Nonce))
+		c.registerNonceGap(evMsg.Nonce)
+		err = rerr.ErrNonceGap
+		return
+	}
 	//If a node data is damaged, then the channel will not work, so the data must not be damaged.
 	if isInvalidNonce {
 		/*
@@ -679,7 +701,7 @@ func (c *Channel) registerMediatedTranser(tr *encoding.MediatedTransfer, blockNu
 RegisterRemoveExpiredHashlockTransfer register a request to remove a expired hashlock and this hashlock must be sent out from the sender.
 */
 func (c *Channel) RegisterRemoveExpiredHashlockTransfer(tr *encoding.RemoveExpiredHashlockTransfer, blockNumber int64) (err error) {
-	return c.registerRemoveLock(tr, blockNumber, tr.LockSecretHash, true)
+	return c.updateBalanceProof(func() error { return c.registerRemoveLock(tr, blockNumber, tr.LockSecretHash, true) })
 }
 
 /*
@@ -692,7 +714,7 @@ RegisterAnnounceDisposedResponse 从我这里发出或者收到来自对方的an
  *		Note that everytime a participant receives message from his partner, he must verify the AnnounceDisposedTransfer he sent out beforehand.
  */
 func (c *Channel) RegisterAnnounceDisposedResponse(response *encoding.AnnounceDisposedResponse, blockNumber int64) (err error) {
-	return c.registerRemoveLock(response, blockNumber, response.LockSecretHash, false)
+	return c.updateBalanceProof(func() error { return c.registerRemoveLock(response, blockNumber, response.LockSecretHash, false) })
 }
 func (c *Channel) registerRemoveLock(messager encoding.EnvelopMessager, blockNumber int64, lockSecretHash common.Hash, mustExpired bool) (err error) {
 	if c.IsClosed() {
@@ -710,7 +732,7 @@ func (c *Channel) registerRemoveLock(messager encoding.EnvelopMessager, blockNum
 		err = errTransferAmountMismatch
 		return
 	}
-	_, newtree, newlocksroot, err := fromState.TryRemoveHashLock(lockSecretHash, blockNumber, mustExpired)
+	lock, newlocksroot, err := fromState.TryRemoveHashLock(lockSecretHash, blockNumber, mustExpired)
 	if err != nil {
 		return err
 	}
@@ -720,8 +742,7 @@ func (c *Channel) registerRemoveLock(messager encoding.EnvelopMessager, blockNum
 	if newlocksroot != msg.Locksroot {
 		return &InvalidLocksRootError{ExpectedLocksroot: newlocksroot, GotLocksroot: msg.Locksroot}
 	}
-	fromState.Tree = newtree
-	err = fromState.registerRemoveLock(messager, lockSecretHash)
+	err = fromState.registerRemoveLock(messager, lock)
 	if err == nil {
 		c.ExternState.db.RemoveLock(c.ChannelIdentifier.ChannelIdentifier, fromState.Address, lockSecretHash)
 	}
@@ -800,7 +821,7 @@ func (c *Channel) CreateMediatedTransfer(initiator, target common.Address, fee *
 		Expiration:     expiration,
 		LockSecretHash: lockSecretHash,
 	}
-	_, updatedLocksroot := from.computeMerkleRootWith(lock)
+	updatedLocksroot := from.computeMerkleRootWith(lock)
 	transferAmount := from.TransferAmount()
 	nonce := c.GetNextNonce()
 	bp := encoding.NewBalanceProof(nonce, transferAmount, updatedLocksroot, &c.ChannelIdentifier)
@@ -810,6 +831,9 @@ func (c *Channel) CreateMediatedTransfer(initiator, target common.Address, fee *
 
 //CreateUnlock creates  a unlock message
 func (c *Channel) CreateUnlock(lockSecretHash common.Hash) (tr *encoding.UnLock, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	if c.IsClosed() {
 		return nil, fmt.Errorf("balance proof cannot be changed when channel is closed")
 	}
@@ -818,7 +842,7 @@ func (c *Channel) CreateUnlock(lockSecretHash common.Hash) (tr *encoding.UnLock,
 	if err != nil {
 		return nil, fmt.Errorf("no such lock for lockSecretHash:%s", utils.HPex(lockSecretHash))
 	}
-	_, locksrootWithPendingLockRemoved, err := from.computeMerkleRootWithout(lock)
+	locksrootWithPendingLockRemoved, err := from.computeMerkleRootWithout(lock)
 	if err != nil {
 		return
 	}
@@ -826,6 +850,7 @@ func (c *Channel) CreateUnlock(lockSecretHash common.Hash) (tr *encoding.UnLock,
 	nonce := c.GetNextNonce()
 	bp := encoding.NewBalanceProof(nonce, transferAmount, locksrootWithPendingLockRemoved, &c.ChannelIdentifier)
 	tr = encoding.NewUnlock(bp, secret)
+	tr.HashAlgorithm = lock.HashAlgorithm
 	return
 }
 
@@ -833,10 +858,13 @@ func (c *Channel) CreateUnlock(lockSecretHash common.Hash) (tr *encoding.UnLock,
 CreateRemoveExpiredHashLockTransfer create this transfer to notify my patner that this hashlock is expired and i want to remove it .
 */
 func (c *Channel) CreateRemoveExpiredHashLockTransfer(lockSecretHash common.Hash, blockNumber int64) (tr *encoding.RemoveExpiredHashlockTransfer, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	if c.IsClosed() {
 		return nil, fmt.Errorf("balance proof cannot be changed when channel is closed")
 	}
-	_, _, newlocksroot, err := c.OurState.TryRemoveHashLock(lockSecretHash, blockNumber, true)
+	_, newlocksroot, err := c.OurState.TryRemoveHashLock(lockSecretHash, blockNumber, true)
 	if err != nil {
 		return
 	}
@@ -855,10 +883,13 @@ CreateAnnounceDisposedResponse 必须先收到对方的AnnouceDisposedTransfer,
  *	Note that a channel participant must first receive AnnounceDisposedTransfer, then he can
  */
 func (c *Channel) CreateAnnounceDisposedResponse(lockSecretHash common.Hash, blockNumber int64) (tr *encoding.AnnounceDisposedResponse, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	if c.IsClosed() {
 		return nil, fmt.Errorf("balance proof cannot be changed when channel is closed")
 	}
-	_, _, newlocksroot, err := c.OurState.TryRemoveHashLock(lockSecretHash, blockNumber, false)
+	_, newlocksroot, err := c.OurState.TryRemoveHashLock(lockSecretHash, blockNumber, false)
 	if err != nil {
 		return
 	}
@@ -877,7 +908,7 @@ CreateAnnouceDisposed  声明我放弃收到的某个锁
  *	Note that it claims that I have abandoned a lock.
  */
 func (c *Channel) CreateAnnouceDisposed(lockSecretHash common.Hash, blockNumber int64) (tr *encoding.AnnounceDisposed, err error) {
-	lock, _, _, err := c.PartnerState.TryRemoveHashLock(lockSecretHash, blockNumber, false)
+	lock, _, err := c.PartnerState.TryRemoveHashLock(lockSecretHash, blockNumber, false)
 	if err != nil {
 		return
 	}
@@ -953,6 +984,9 @@ CreateWithdrawRequest 一定要不持有任何锁,否则双方可能对金额分
  *	Note that there must not be any lock, or conflict will reside in token allocation.
  */
 func (c *Channel) CreateWithdrawRequest(withdrawAmount *big.Int) (w *encoding.WithdrawRequest, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	/*
 		withdraw 一旦发出去就只能关闭通道
 		无论是通过 withdraw 成功,造成通道关闭重开
@@ -1089,6 +1123,9 @@ CreateWithdrawResponse :
  * 	So withdraw and cooperative settle may both impact ongoing transfers which statemanager should deal with.
  */
 func (c *Channel) CreateWithdrawResponse(req *encoding.WithdrawRequest) (w *encoding.WithdrawResponse, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
 		len(c.OurState.Lock2PendingLocks) > 0 {
 		log.Warn(fmt.Sprintf("CreateWithdrawResponse ,but i'm sending transfer on road,these transfer should canceled immediately"))
@@ -1151,6 +1188,9 @@ CreateCooperativeSettleRequest 一定要不持有任何锁,否则双方可能对
  *	Note that there should be no lock, or both participants may have conflict with token allocation.
  */
 func (c *Channel) CreateCooperativeSettleRequest() (s *encoding.SettleRequest, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	/*
 		SettleRequest 一旦发出去就只能关闭通道
 		无论是通过 cooperative settle 成功,造成通道关闭重开
@@ -1224,6 +1264,9 @@ CreateCooperativeSettleResponse :
  * 	So withdraw and cooperative settle may both impact ongoing transfers, which statemanager should handle.
  */
 func (c *Channel) CreateCooperativeSettleResponse(req *encoding.SettleRequest) (res *encoding.SettleResponse, err error) {
+	if c.frozenErr != nil {
+		return nil, ErrChannelFrozen
+	}
 	if len(c.OurState.Lock2PendingLocks) > 0 ||
 		len(c.OurState.Lock2UnclaimedLocks) > 0 {
 		log.Warn(fmt.Sprintf("CreateCooperativeSettleResponse ,but i'm sending transfer on road,these transfer should canceled immediately"))
@@ -1512,6 +1555,8 @@ func NewChannelSerialization(c *Channel) *channeltype.Serialization {
 		PartnerBalanceProof:    c.PartnerState.BalanceProofState,
 		OurLeaves:              c.OurState.Tree.Leaves,
 		PartnerLeaves:          c.PartnerState.Tree.Leaves,
+		OurTree:                c.OurState.Tree.Encode(),
+		PartnerTree:            c.PartnerState.Tree.Encode(),
 		OurKnownSecrets:        ourSecrets,
 		PartnerKnownSecrets:    partnerSecrets,
 		State:                  c.State,
@@ -1520,6 +1565,8 @@ func NewChannelSerialization(c *Channel) *channeltype.Serialization {
 		PartnerContractBalance: c.PartnerState.ContractBalance,
 		ClosedBlock:            c.ExternState.ClosedBlock,
 		SettledBlock:           c.ExternState.SettledBlock,
+		PartnerNonceGap:        c.NonceGap(),
+		FrozenReason:           c.FrozenReason(),
 	}
 	return s
 }
@@ -66,7 +66,7 @@ func TestEndState(t *testing.T) {
 	}
 	lockHash := utils.Sha3(lock.AsBytes())
 	var transferedAmount = utils.BigInt0
-	_, locksroot := state2.computeMerkleRootWith(lock)
+	locksroot := state2.computeMerkleRootWith(lock)
 	/*
 		ChannelIdentifier   common.Hash
 			OpenBlockNumber     int64    //open blocknumber 和 channelIdentifier 一起作为通道的唯一标识
@@ -81,9 +81,21 @@ func TestEndState(t *testing.T) {
 		TransferAmount:    transferedAmount,
 		Locksroot:         locksroot,
 	}
+	/*
+		a transfer with wrong locksroot is refused and the tree is not changed
+	*/
+	badbp := *bp
+	badbp.Locksroot = utils.NewRandomHash()
+	badmtr := encoding.NewMediatedTransfer(&badbp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), utils.BigInt0)
+	badmtr.Sign(bcs.PrivKey, badmtr)
+	err := state1.registerMediatedMessage(badmtr)
+	_, ok := err.(*InvalidLocksRootError)
+	assert.True(t, ok)
+	assert.Equal(t, state1.Tree.MerkleRoot(), utils.EmptyHash)
+	assert.Empty(t, state1.Tree.Leaves)
 	mtr := encoding.NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), utils.BigInt0)
 	mtr.Sign(bcs.PrivKey, mtr)
-	err := state1.registerMediatedMessage(mtr)
+	err = state1.registerMediatedMessage(mtr)
 	if err != nil {
 		t.Error(err)
 		return
@@ -381,6 +393,55 @@ func TestPythonChannel(t *testing.T) {
 
 }
 
+func TestChannelUnlockWithHashAlgorithm(t *testing.T) {
+	tokenAddress := utils.NewRandomAddress()
+	privkey1, address1 := utils.MakePrivateKeyAddress()
+	address2 := utils.NewRandomAddress()
+	var blockNumber int64 = 10
+	ourState := NewChannelEndState(address1, big.NewInt(70), nil, mtree.EmptyTree)
+	partnerState := NewChannelEndState(address2, big.NewInt(110), nil, mtree.EmptyTree)
+	externState := makeExternState()
+	testchannel, _ := NewChannel(ourState, partnerState, externState, tokenAddress, &externState.ChannelIdentifier, 5, 15)
+
+	secret := utils.ShaSecret([]byte("test_keccak_channel"))
+	hashlock := utils.HashAlgorithmKeccak256.HashSecret(secret[:])
+	amount := big.NewInt(10)
+	mediatedTransfer, err := testchannel.CreateMediatedTransfer(address1, address2, utils.BigInt0, amount, blockNumber+10, hashlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediatedTransfer.HashAlgorithm = utils.HashAlgorithmKeccak256
+	mediatedTransfer.Sign(privkey1, mediatedTransfer)
+	err = testchannel.RegisterTransfer(blockNumber, mediatedTransfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testchannel.RegisterSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := testchannel.CreateUnlock(hashlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, unlock.HashAlgorithm, utils.HashAlgorithmKeccak256)
+	//the same secret claimed as sha256 must not unlock the keccak256 lock
+	unlock.HashAlgorithm = utils.HashAlgorithmSHA256
+	unlock.Sign(privkey1, unlock)
+	err = testchannel.RegisterTransfer(blockNumber, unlock)
+	assert.NotEqual(t, err, nil)
+	assert.EqualValues(t, testchannel.Locked(), amount)
+
+	unlock.HashAlgorithm = utils.HashAlgorithmKeccak256
+	unlock.Sign(privkey1, unlock)
+	err = testchannel.RegisterTransfer(blockNumber, unlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, testchannel.Locked(), utils.BigInt0)
+	assert.EqualValues(t, testchannel.TransferAmount(), amount)
+}
+
 //The nonce must increase with each new transfer.
 func TestChannelIncreaseNonceAndTransferedAmount(t *testing.T) {
 	tokenAddress := utils.NewRandomAddress()
@@ -769,12 +830,12 @@ func TestRemoveExpiredHashlock(t *testing.T) {
 		t.Error("cannot remove a hashlock which is not expired.")
 		return
 	}
-	_, _, _, err = testChannel.PartnerState.TryRemoveHashLock(rmtr.LockSecretHash, blockNumber, true)
+	_, _, err = testChannel.PartnerState.TryRemoveHashLock(rmtr.LockSecretHash, blockNumber, true)
 	if err == nil {
 		t.Error("cannot remove not expired hashlock")
 		return
 	}
-	_, _, locksroot, err := testChannel.PartnerState.TryRemoveHashLock(rmtr.LockSecretHash, expiration+params.ForkConfirmNumber+1, true)
+	_, locksroot, err := testChannel.PartnerState.TryRemoveHashLock(rmtr.LockSecretHash, expiration+params.ForkConfirmNumber+1, true)
 	if err != nil {
 		t.Errorf("can remove a expired hashlock err=%s", err)
 		return
@@ -1085,3 +1146,124 @@ func TestChannel_RegisterCooperativeSettleRequest(t *testing.T) {
 		return
 	}
 }
+
+func TestChannelFrozenOnInvariantViolation(t *testing.T) {
+	var violation error
+	ch0, ch1 := makePairChannel()
+	ch0.ExternState.Invariant = &InvariantConfig{
+		FullCheck:   true,
+		SnapshotDir: os.TempDir(),
+		OnViolation: func(c *Channel, err error) {
+			violation = err
+		},
+	}
+	directTransfer, err := ch0.CreateDirectTransfer(big10)
+	assert.Equal(t, err, nil)
+	directTransfer.Sign(ch0.ExternState.privKey, directTransfer)
+	err = ch0.RegisterTransfer(10, directTransfer)
+	assert.Equal(t, err, nil)
+	err = ch1.RegisterTransfer(10, directTransfer)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ch0.CheckInvariants(), nil)
+	assert.Equal(t, ch0.IsFrozen(), false)
+	/*
+		sent more than deposit
+	*/
+	ch0.OurState.ContractBalance = big.NewInt(5)
+	assert.NotEqual(t, ch0.CheckInvariants(), nil)
+	ch0.assertInvariants(ch0.OurState.nonce(), ch0.PartnerState.nonce())
+	assert.Equal(t, ch0.IsFrozen(), true)
+	assert.NotEqual(t, violation, nil)
+	assert.Equal(t, ch0.CanTransfer(), false)
+	_, err = ch0.CreateDirectTransfer(big.NewInt(1))
+	assert.NotEqual(t, err, nil)
+	err = ch0.RegisterTransfer(10, directTransfer)
+	assert.Equal(t, err, ErrChannelFrozen)
+	/*
+		balance proof updates which don't go through RegisterTransfer are refused too
+	*/
+	err = ch0.RegisterRemoveExpiredHashlockTransfer(&encoding.RemoveExpiredHashlockTransfer{}, 10)
+	assert.Equal(t, err, ErrChannelFrozen)
+	err = ch0.RegisterAnnounceDisposedResponse(&encoding.AnnounceDisposedResponse{}, 10)
+	assert.Equal(t, err, ErrChannelFrozen)
+	/*
+		the freeze is saved with the channel and restored after restart
+	*/
+	s := NewChannelSerialization(ch0)
+	assert.Equal(t, s.FrozenReason, violation.Error())
+	ch2, _ := makePairChannel()
+	ch2.RestoreFrozen(s.FrozenReason)
+	assert.Equal(t, ch2.IsFrozen(), true)
+	assert.Equal(t, ch2.CanTransfer(), false)
+	ch2.RestoreFrozen("")
+	assert.Equal(t, ch2.IsFrozen(), false)
+}
+
+func TestChannelNonceGap(t *testing.T) {
+	ch0, ch1 := makePairChannel()
+	var transfers []*encoding.DirectTransfer
+	for i := 0; i < 3; i++ {
+		tr, err := ch0.CreateDirectTransfer(big.NewInt(1))
+		assert.Equal(t, err, nil)
+		tr.Sign(ch0.ExternState.privKey, tr)
+		err = ch0.RegisterTransfer(10, tr)
+		assert.Equal(t, err, nil)
+		transfers = append(transfers, tr)
+	}
+	err := ch1.RegisterTransfer(10, transfers[0])
+	assert.Equal(t, err, nil)
+	/*
+		transfers[1] lost, transfers[2] arrives
+	*/
+	err = ch1.RegisterTransfer(10, transfers[2])
+	assert.Equal(t, err, rerr.ErrNonceGap)
+	assert.Equal(t, ch1.HasNonceGap(), true)
+	assert.Equal(t, ch1.CanTransfer(), false)
+	assert.Equal(t, ch1.CanReceiveTransfer(), true)
+	_, err = ch1.CreateDirectTransfer(big.NewInt(1))
+	assert.NotEqual(t, err, nil)
+	nonce, need := ch1.NeedResync()
+	assert.Equal(t, need, true)
+	assert.EqualValues(t, transfers[0].Nonce, nonce)
+	//retries of transfers[2] don't ask again
+	err = ch1.RegisterTransfer(10, transfers[2])
+	assert.Equal(t, err, rerr.ErrNonceGap)
+	_, need = ch1.NeedResync()
+	assert.Equal(t, need, false)
+	//the gap is saved, and requested again after restart
+	gap := NewChannelSerialization(ch1).PartnerNonceGap
+	if assert.NotNil(t, gap) {
+		assert.EqualValues(t, transfers[0].Nonce, gap.Nonce)
+		assert.EqualValues(t, transfers[2].Nonce, gap.Seen)
+		assert.Equal(t, gap.Requested, true)
+	}
+	ch1.RestoreNonceGap(gap)
+	assert.Equal(t, ch1.CanTransfer(), false)
+	nonce, need = ch1.NeedResync()
+	assert.Equal(t, need, true)
+	assert.EqualValues(t, transfers[0].Nonce, nonce)
+	//stale message is still invalid, not a gap
+	err = ch1.RegisterTransfer(10, transfers[0])
+	assert.NotEqual(t, err, nil)
+	assert.NotEqual(t, err, rerr.ErrNonceGap)
+	/*
+		transfers[1] retransmitted, gap resolved and transfers[2] accepted
+	*/
+	err = ch1.RegisterTransfer(10, transfers[1])
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ch1.HasNonceGap(), false)
+	assert.Equal(t, ch1.CanTransfer(), true)
+	assert.Nil(t, NewChannelSerialization(ch1).PartnerNonceGap)
+	err = ch1.RegisterTransfer(10, transfers[2])
+	assert.Equal(t, err, nil)
+	assertSyncedChannels(ch0, x.Sub(ch0.ContractBalance(), big.NewInt(3)), nil,
+		ch1, x.Add(ch1.ContractBalance(), big.NewInt(3)), nil, t)
+	//our own messages never make a gap
+	tr, err := ch0.CreateDirectTransfer(big.NewInt(1))
+	assert.Equal(t, err, nil)
+	tr.Nonce += 2
+	tr.Sign(ch0.ExternState.privKey, tr)
+	err = ch0.RegisterTransfer(10, tr)
+	assert.NotEqual(t, err, rerr.ErrNonceGap)
+	assert.Equal(t, ch0.HasNonceGap(), false)
+}
@@ -0,0 +1,101 @@
+package channeltype
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+//ChannelInfo deposits of a channel and amount locked by one participant, seen from this participant
+type ChannelInfo struct {
+	OwnDeposit     *big.Int //deposit of this participant on chain, withdrawals already deducted
+	PartnerDeposit *big.Int
+	OwnLocked      *big.Int //amount of locks this participant has sent but not yet unlocked or removed
+}
+
+//Capacity total tokens in the channel, both deposits
+func (info *ChannelInfo) Capacity() *big.Int {
+	return new(big.Int).Add(bigOrZero(info.OwnDeposit), bigOrZero(info.PartnerDeposit))
+}
+
+func bigOrZero(x *big.Int) *big.Int {
+	if x == nil {
+		return utils.BigInt0
+	}
+	return x
+}
+
+func transferAmountOf(bp *transfer.BalanceProofState) *big.Int {
+	if bp == nil || bp.TransferAmount == nil {
+		return utils.BigInt0
+	}
+	return bp.TransferAmount
+}
+
+//balance own deposit + received transfers - sent transfers, locks not considered
+func balance(ownDeposit *big.Int, ownBP, partnerBP *transfer.BalanceProofState) *big.Int {
+	x := new(big.Int).Sub(bigOrZero(ownDeposit), transferAmountOf(ownBP))
+	return x.Add(x, transferAmountOf(partnerBP))
+}
+
+/*
+AvailableBalance 计算一方当前可以发送的金额: 自己的押金 + 收到的转账 - 发出的转账 - 锁定的金额.
+ownBP 是自己签名给对方的 balance proof, partnerBP 是对方签名给自己的, nil 表示还没有交易.
+结果为负或者余额超过通道容量, 说明状态不一致, 返回错误而不是一个错误的余额.
+*/
+/*
+ *	AvailableBalance : tokens one participant can send right now,
+ *	own deposit + received transfers - sent transfers - locked.
+ *	ownBP is signed by this participant, partnerBP by the partner, nil means no transfer yet.
+ *	A negative result or a balance above the channel capacity means inconsistent state, so an error is returned
+ *	rather than a wrong balance.
+ */
+func AvailableBalance(channelInfo *ChannelInfo, ownBP, partnerBP *transfer.BalanceProofState) (*big.Int, error) {
+	if channelInfo == nil {
+		return nil, rerr.InvalidState("no channel info")
+	}
+	b := balance(channelInfo.OwnDeposit, ownBP, partnerBP)
+	if b.Sign() < 0 {
+		return nil, rerr.InvalidState(fmt.Sprintf("negative balance %s, deposit=%s,sent=%s,received=%s",
+			b, bigOrZero(channelInfo.OwnDeposit), transferAmountOf(ownBP), transferAmountOf(partnerBP)))
+	}
+	if capacity := channelInfo.Capacity(); b.Cmp(capacity) > 0 {
+		return nil, rerr.InvalidState(fmt.Sprintf("balance %s exceeds channel capacity %s", b, capacity))
+	}
+	locked := bigOrZero(channelInfo.OwnLocked)
+	if locked.Cmp(b) > 0 {
+		return nil, rerr.InvalidState(fmt.Sprintf("locked %s exceeds balance %s", locked, b))
+	}
+	return b.Sub(b, locked), nil
+}
+
+//ChannelInfo deposits and locked amount seen from us
+func (s *Serialization) ChannelInfo() *ChannelInfo {
+	return &ChannelInfo{
+		OwnDeposit:     s.OurContractBalance,
+		PartnerDeposit: s.PartnerContractBalance,
+		OwnLocked:      s.OurAmountLocked(),
+	}
+}
+
+//PartnerChannelInfo deposits and locked amount seen from partner
+func (s *Serialization) PartnerChannelInfo() *ChannelInfo {
+	return &ChannelInfo{
+		OwnDeposit:     s.PartnerContractBalance,
+		PartnerDeposit: s.OurContractBalance,
+		OwnLocked:      s.PartnerAmountLocked(),
+	}
+}
+
+//OurAvailableBalance tokens we can send right now
+func (s *Serialization) OurAvailableBalance() (*big.Int, error) {
+	return AvailableBalance(s.ChannelInfo(), s.OurBalanceProof, s.PartnerBalanceProof)
+}
+
+//PartnerAvailableBalance tokens partner can send to us right now
+func (s *Serialization) PartnerAvailableBalance() (*big.Int, error) {
+	return AvailableBalance(s.PartnerChannelInfo(), s.PartnerBalanceProof, s.OurBalanceProof)
+}
@@ -0,0 +1,92 @@
+package channeltype
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+func bpWithTransferAmount(amount int64) *transfer.BalanceProofState {
+	bp := transfer.NewEmptyBalanceProofState()
+	bp.TransferAmount = big.NewInt(amount)
+	return bp
+}
+
+func TestAvailableBalance(t *testing.T) {
+	cases := []struct {
+		name                   string
+		ownDeposit, partnerDep int64
+		locked                 int64
+		ownBP, partnerBP       *transfer.BalanceProofState
+		expect                 int64
+		expectErr              bool
+	}{
+		{"no transfer", 100, 50, 0, nil, nil, 100, false},
+		{"sent and received", 100, 50, 0, bpWithTransferAmount(30), bpWithTransferAmount(20), 90, false},
+		{"locked", 100, 50, 25, bpWithTransferAmount(30), nil, 45, false},
+		{"fully locked", 100, 50, 70, bpWithTransferAmount(30), nil, 0, false},
+		{"spent all received too", 100, 50, 0, bpWithTransferAmount(150), bpWithTransferAmount(50), 0, false},
+		{"empty balance proof", 100, 50, 0, transfer.NewEmptyBalanceProofState(), nil, 100, false},
+		{"sent more than owned", 100, 50, 0, bpWithTransferAmount(101), nil, 0, true},
+		{"locked more than balance", 100, 50, 71, bpWithTransferAmount(30), nil, 0, true},
+		{"received more than capacity", 100, 50, 0, nil, bpWithTransferAmount(51), 0, true},
+	}
+	for _, c := range cases {
+		info := &ChannelInfo{
+			OwnDeposit:     big.NewInt(c.ownDeposit),
+			PartnerDeposit: big.NewInt(c.partnerDep),
+			OwnLocked:      big.NewInt(c.locked),
+		}
+		available, err := AvailableBalance(info, c.ownBP, c.partnerBP)
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("%s: expect error, got %s", c.name, available)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if available.Cmp(big.NewInt(c.expect)) != 0 {
+			t.Errorf("%s: expect %d, got %s", c.name, c.expect, available)
+		}
+	}
+	if _, err := AvailableBalance(nil, nil, nil); err == nil {
+		t.Error("nil channel info must fail")
+	}
+}
+
+func TestSerializationAvailableBalance(t *testing.T) {
+	lock := &mtree.Lock{Expiration: 10, Amount: big.NewInt(15), LockSecretHash: utils.NewRandomHash()}
+	s := &Serialization{
+		OurContractBalance:     big.NewInt(100),
+		PartnerContractBalance: big.NewInt(50),
+		OurBalanceProof:        bpWithTransferAmount(30),
+		PartnerBalanceProof:    bpWithTransferAmount(20),
+		OurLeaves:              []*mtree.Lock{lock},
+	}
+	if s.ChannelInfo().Capacity().Cmp(big.NewInt(150)) != 0 {
+		t.Errorf("capacity %s", s.ChannelInfo().Capacity())
+	}
+	available, err := s.OurAvailableBalance()
+	if err != nil || available.Cmp(big.NewInt(75)) != 0 {
+		t.Errorf("our available %s, err %v", available, err)
+	}
+	// balance is not reduced by locks
+	if s.OurBalance().Cmp(big.NewInt(90)) != 0 {
+		t.Errorf("our balance %s", s.OurBalance())
+	}
+	available, err = s.PartnerAvailableBalance()
+	if err != nil || available.Cmp(big.NewInt(60)) != 0 {
+		t.Errorf("partner available %s, err %v", available, err)
+	}
+	// balances of both sides add up to the capacity
+	total := new(big.Int).Add(s.OurBalance(), s.PartnerBalance())
+	if total.Cmp(s.ChannelInfo().Capacity()) != 0 {
+		t.Errorf("balances %s not equal to capacity", total)
+	}
+}
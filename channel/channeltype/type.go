@@ -2,6 +2,7 @@ package channeltype
 
 import (
 	"encoding/gob"
+	"fmt"
 	"math/big"
 
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
@@ -43,6 +44,24 @@ type KnownSecret struct {
 	IsRegisteredOnChain bool //该密码是通过链上注册获知的还是通过普通的RevealSecret得知的. 如果是链上注册得知,那么一定是没有过期的.
 }
 
+/*
+NonceGap 收到对方的 balance proof 的 nonce 比期望的大, 说明中间的消息丢了.
+在对方重发缺失的消息之前, 不能发起新的交易, 否则双方对通道状态的理解会越来越远.
+*/
+/*
+ *	NonceGap : a balance proof of partner arrived with a nonce ahead of the expected one, messages in between are lost.
+ *	No new transfer can be initiated until partner retransmits them, otherwise both sides drift further apart.
+ */
+type NonceGap struct {
+	Nonce     uint64 //nonce of the last balance proof received from partner
+	Seen      uint64 //largest nonce received from partner
+	Requested bool   //a ResyncRequest has been sent for this gap
+}
+
+func (g *NonceGap) String() string {
+	return fmt.Sprintf("{nonce=%d,seen=%d,requested=%v}", g.Nonce, g.Seen, g.Requested)
+}
+
 // Serialization is the living channel in the database
 type Serialization struct {
 	ChannelIdentifier      *contracts.ChannelUniqueID
@@ -55,6 +74,8 @@ type Serialization struct {
 	PartnerBalanceProof    *transfer.BalanceProofState
 	OurLeaves              []*mtree.Lock
 	PartnerLeaves          []*mtree.Lock
+	OurTree                []byte //encoded by Merkletree.Encode, restore it instead of rebuilding from OurLeaves
+	PartnerTree            []byte
 	OurKnownSecrets        []*KnownSecret
 	PartnerKnownSecrets    []*KnownSecret
 	State                  State
@@ -63,6 +84,8 @@ type Serialization struct {
 	ClosedBlock            int64
 	SettledBlock           int64
 	SettleTimeout          int
+	PartnerNonceGap        *NonceGap //not nil when balance proofs of partner are missing
+	FrozenReason           string    //not empty when channel is frozen because of invariant violation
 }
 
 // GetKey : impl dao.KeyGetter
@@ -84,19 +107,10 @@ func (s *Serialization) TokenAddress() common.Address {
 func (s *Serialization) PartnerAddress() common.Address {
 	return common.BytesToAddress(s.PartnerAddressBytes)
 }
-func (s *Serialization) transferAmount(bp *transfer.BalanceProofState) *big.Int {
-	if bp != nil {
-		return bp.TransferAmount
-	}
-	return utils.BigInt0
-}
 
 //OurBalance our abalance
 func (s *Serialization) OurBalance() *big.Int {
-	x := new(big.Int)
-	x.Sub(s.OurContractBalance, s.transferAmount(s.OurBalanceProof))
-	x.Add(x, s.transferAmount(s.PartnerBalanceProof))
-	return x
+	return balance(s.OurContractBalance, s.OurBalanceProof, s.PartnerBalanceProof)
 }
 
 //OurAmountLocked sending token on road
@@ -119,10 +133,7 @@ func (s *Serialization) PartnerAmountLocked() *big.Int {
 
 //PartnerBalance partner's balance
 func (s *Serialization) PartnerBalance() *big.Int {
-	x := new(big.Int)
-	x.Sub(s.PartnerContractBalance, s.transferAmount(s.PartnerBalanceProof))
-	x.Add(x, s.transferAmount(s.OurBalanceProof))
-	return x
+	return balance(s.PartnerContractBalance, s.PartnerBalanceProof, s.OurBalanceProof)
 }
 
 //PartnerLock2UnclaimedLocks partner's lock and known secret
@@ -145,8 +156,11 @@ func (s *Serialization) PartnerLock2UnclaimedLocks() map[common.Hash]UnlockParti
 
 func (s *Serialization) getSecretHashMap(secrets []*KnownSecret) map[common.Hash]*KnownSecret {
 	m := make(map[common.Hash]*KnownSecret)
+	//secrets are only known after matching a lock under the lock's algorithm, so index them by every algorithm
 	for _, s := range secrets {
-		m[utils.ShaSecret(s.Secret[:])] = s
+		for _, a := range utils.HashAlgorithms {
+			m[a.HashSecret(s.Secret[:])] = s
+		}
 	}
 	return m
 }
@@ -0,0 +1,271 @@
+package channel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//InvariantConfig 通道状态检查的配置,由 photon service 设置到每个通道的 ExternalState 中
+/*
+ *	InvariantConfig : how channels check their invariants, photon service sets it into ExternalState of every channel.
+ *	nil means only cheap checks, snapshot goes to os.TempDir() and nobody is notified.
+ */
+type InvariantConfig struct {
+	FullCheck   bool                        //rebuild merkle tree from pending locks after every state transition, it's expensive, photon turns it on only in debug mode.
+	SnapshotDir string                      //where to dump diagnostic snapshot when a channel violates invariants, empty means os.TempDir()
+	OnViolation func(c *Channel, err error) //called after a channel has been frozen, channel cannot import notify, so photon service registers a callback here.
+}
+
+//ErrChannelFrozen 通道状态检查失败以后,不能再签署任何 balance proof
+/*
+ *	ErrChannelFrozen : channel state is corrupted, we must not sign any balance proof any more.
+ */
+var ErrChannelFrozen = errors.New("channel is frozen because of invariant violation")
+
+//InvariantError describes which invariant a channel violates
+type InvariantError struct {
+	Invariant string
+	Detail    string
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("channel invariant %s violated: %s", e.Invariant, e.Detail)
+}
+
+/*
+CheckInvariants 检查通道状态是否自洽:
+1. 双方可用余额都不能为负, 双方余额加上锁定金额等于双方押金(已经扣除 withdraw)
+2. locksroot 必须和 merkle tree 一致
+3. 同一个锁不能同时出现在双方的锁中
+InvariantConfig.FullCheck 打开时,还会用锁重新计算 merkle tree
+*/
+/*
+ *	CheckInvariants : verify channel state is self-consistent.
+ *		1. distributable of both sides is not negative, balances plus locked amounts equal deposits minus withdrawals.
+ *		2. locksroot equals to root of merkle tree.
+ *		3. a lock must not appear in both sides.
+ *	When InvariantConfig.FullCheck is on, merkle tree is rebuilt from pending and unclaimed locks too.
+ */
+func (c *Channel) CheckInvariants() error {
+	ourDistributable := c.OurState.Distributable(c.PartnerState)
+	partnerDistributable := c.PartnerState.Distributable(c.OurState)
+	if ourDistributable.Sign() < 0 || partnerDistributable.Sign() < 0 {
+		return &InvariantError{"balance", fmt.Sprintf("negative distributable, our=%s,partner=%s", ourDistributable, partnerDistributable)}
+	}
+	total := new(big.Int).Add(ourDistributable, partnerDistributable)
+	total.Add(total, c.OurState.amountLocked())
+	total.Add(total, c.PartnerState.amountLocked())
+	deposit := new(big.Int).Add(c.OurState.ContractBalance, c.PartnerState.ContractBalance)
+	if total.Cmp(deposit) != 0 {
+		return &InvariantError{"balance", fmt.Sprintf("balance and locked %s not equal to deposit %s", total, deposit)}
+	}
+	fullCheck := c.invariantConfig() != nil && c.invariantConfig().FullCheck
+	for _, node := range []*EndState{c.OurState, c.PartnerState} {
+		if err := node.checkInvariants(fullCheck); err != nil {
+			return err
+		}
+	}
+	for lockSecretHash := range c.OurState.Lock2PendingLocks {
+		if c.PartnerState.IsKnown(lockSecretHash) {
+			return &InvariantError{"locks", fmt.Sprintf("lock %s appears in both sides", utils.HPex(lockSecretHash))}
+		}
+	}
+	for lockSecretHash := range c.OurState.Lock2UnclaimedLocks {
+		if c.PartnerState.IsKnown(lockSecretHash) {
+			return &InvariantError{"locks", fmt.Sprintf("lock %s appears in both sides", utils.HPex(lockSecretHash))}
+		}
+	}
+	return nil
+}
+
+func (node *EndState) checkInvariants(fullCheck bool) error {
+	if node.Tree.MerkleRoot() != node.locksRoot() {
+		return &InvariantError{"locksroot", fmt.Sprintf("%s tree root %s, balance proof locksroot %s",
+			utils.APex(node.Address), utils.HPex(node.Tree.MerkleRoot()), utils.HPex(node.locksRoot()))}
+	}
+	if !fullCheck {
+		return nil
+	}
+	var leaves []*mtree.Lock
+	for _, l := range node.Lock2PendingLocks {
+		leaves = append(leaves, l.Lock)
+	}
+	for _, l := range node.Lock2UnclaimedLocks {
+		leaves = append(leaves, l.Lock)
+	}
+	if len(leaves) != len(node.Tree.Leaves) {
+		return &InvariantError{"locksroot", fmt.Sprintf("%s has %d locks,but tree has %d leaves",
+			utils.APex(node.Address), len(leaves), len(node.Tree.Leaves))}
+	}
+	root := mtree.NewMerkleTree(leaves).MerkleRoot()
+	if root != node.locksRoot() {
+		return &InvariantError{"locksroot", fmt.Sprintf("%s recomputed locksroot %s, balance proof locksroot %s",
+			utils.APex(node.Address), utils.HPex(root), utils.HPex(node.locksRoot()))}
+	}
+	return nil
+}
+
+func (c *Channel) invariantConfig() *InvariantConfig {
+	if c.ExternState == nil {
+		return nil
+	}
+	return c.ExternState.Invariant
+}
+
+/*
+updateBalanceProof 所有改变 balance proof 的操作都通过这里,通道冻结以后拒绝修改,修改以后检查通道状态
+*/
+/*
+ *	updateBalanceProof : every change of balance proof goes through here,
+ *	a frozen channel refuses it, and invariants are checked after a successful change.
+ */
+func (c *Channel) updateBalanceProof(update func() error) error {
+	if c.frozenErr != nil {
+		return ErrChannelFrozen
+	}
+	ourNonce, partnerNonce := c.OurState.nonce(), c.PartnerState.nonce()
+	err := update()
+	if err == nil {
+		c.assertInvariants(ourNonce, partnerNonce)
+	}
+	return err
+}
+
+//assertInvariants check invariants after a state transition, nonces before this transition are given.
+func (c *Channel) assertInvariants(ourNonce, partnerNonce uint64) {
+	err := c.CheckInvariants()
+	if err == nil && (c.OurState.nonce() < ourNonce || c.PartnerState.nonce() < partnerNonce) {
+		err = &InvariantError{"nonce", fmt.Sprintf("nonce decreased, our %d->%d,partner %d->%d",
+			ourNonce, c.OurState.nonce(), partnerNonce, c.PartnerState.nonce())}
+	}
+	if err != nil {
+		c.freeze(err)
+	}
+}
+
+/*
+freeze 通道状态已经错乱,不能再签署任何 balance proof, 保存现场以便分析, 并通知用户
+*/
+/*
+ *	freeze : channel state is corrupted, refuse to sign any balance proof,
+ *	dump a snapshot for diagnosis and notify user.
+ */
+func (c *Channel) freeze(err error) {
+	if c.frozenErr != nil {
+		return
+	}
+	c.frozenErr = err
+	log.Error(fmt.Sprintf("channel %s frozen, err=%s", c.ChannelIdentifier.String(), err))
+	file, err2 := c.dumpSnapshot(err)
+	if err2 != nil {
+		log.Error(fmt.Sprintf("dump snapshot of channel %s err %s", c.ChannelIdentifier.String(), err2))
+	} else {
+		log.Error(fmt.Sprintf("snapshot of channel %s saved to %s", c.ChannelIdentifier.String(), file))
+	}
+	if cfg := c.invariantConfig(); cfg != nil && cfg.OnViolation != nil {
+		cfg.OnViolation(c, err)
+	}
+}
+
+//IsFrozen returns true when this channel violates invariants and refuses to sign balance proof
+func (c *Channel) IsFrozen() bool {
+	return c.frozenErr != nil
+}
+
+//FrozenReason returns why this channel is frozen, empty when it is not
+func (c *Channel) FrozenReason() string {
+	if c.frozenErr == nil {
+		return ""
+	}
+	return c.frozenErr.Error()
+}
+
+//RestoreFrozen restore the freeze saved in db, a frozen channel stays frozen after restart
+func (c *Channel) RestoreFrozen(reason string) {
+	if reason == "" {
+		c.frozenErr = nil
+		return
+	}
+	c.frozenErr = errors.New(reason)
+}
+
+type endStateSnapshot struct {
+	Address           common.Address
+	ContractBalance   *big.Int
+	TransferAmount    *big.Int
+	Nonce             uint64
+	LocksRoot         common.Hash
+	TreeRoot          common.Hash
+	Leaves            []*mtree.Lock
+	PendingLocks      []common.Hash
+	UnclaimedLocks    []common.Hash
+	ContractLocksRoot common.Hash
+}
+
+type channelSnapshot struct {
+	Time              time.Time
+	Violation         string
+	ChannelIdentifier string
+	TokenAddress      common.Address
+	State             string
+	OurState          *endStateSnapshot
+	PartnerState      *endStateSnapshot
+}
+
+func newEndStateSnapshot(node *EndState) *endStateSnapshot {
+	s := &endStateSnapshot{
+		Address:           node.Address,
+		ContractBalance:   node.ContractBalance,
+		TransferAmount:    node.TransferAmount(),
+		Nonce:             node.nonce(),
+		LocksRoot:         node.locksRoot(),
+		TreeRoot:          node.Tree.MerkleRoot(),
+		Leaves:            node.Tree.Leaves,
+		ContractLocksRoot: node.contractLocksRoot(),
+	}
+	for h := range node.Lock2PendingLocks {
+		s.PendingLocks = append(s.PendingLocks, h)
+	}
+	for h := range node.Lock2UnclaimedLocks {
+		s.UnclaimedLocks = append(s.UnclaimedLocks, h)
+	}
+	return s
+}
+
+func (c *Channel) dumpSnapshot(violation error) (file string, err error) {
+	s := &channelSnapshot{
+		Time:              time.Now(),
+		Violation:         violation.Error(),
+		ChannelIdentifier: c.ChannelIdentifier.String(),
+		TokenAddress:      c.TokenAddress,
+		State:             c.State.String(),
+		OurState:          newEndStateSnapshot(c.OurState),
+		PartnerState:      newEndStateSnapshot(c.PartnerState),
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return
+	}
+	dir := ""
+	if cfg := c.invariantConfig(); cfg != nil {
+		dir = cfg.SnapshotDir
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file = filepath.Join(dir, fmt.Sprintf("channel-%s-%d.json",
+		utils.HPex(c.ChannelIdentifier.ChannelIdentifier), time.Now().Unix()))
+	err = ioutil.WriteFile(file, data, 0644)
+	return
+}
@@ -0,0 +1,81 @@
+package channel
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+)
+
+//registerNonceGap partner sent a balance proof with nonce, which is not the next one
+func (c *Channel) registerNonceGap(nonce uint64) {
+	if c.nonceGap == nil {
+		c.nonceGap = &channeltype.NonceGap{
+			Nonce: c.PartnerState.nonce(),
+			Seen:  nonce,
+		}
+		log.Warn(fmt.Sprintf("channel %s nonce gap %s, refuse new transfers until partner retransmits",
+			c.ChannelIdentifier.String(), c.nonceGap))
+		return
+	}
+	c.nonceGap.Nonce = c.PartnerState.nonce()
+	if nonce > c.nonceGap.Seen {
+		c.nonceGap.Seen = nonce
+	}
+}
+
+//updateNonceGap gap is resolved once the largest nonce seen is the next one of partner
+func (c *Channel) updateNonceGap() {
+	if c.nonceGap == nil {
+		return
+	}
+	if c.PartnerState.nonce()+1 >= c.nonceGap.Seen {
+		log.Info(fmt.Sprintf("channel %s nonce gap %s resolved, partner nonce=%d",
+			c.ChannelIdentifier.String(), c.nonceGap, c.PartnerState.nonce()))
+		c.nonceGap = nil
+		return
+	}
+	c.nonceGap.Nonce = c.PartnerState.nonce()
+}
+
+//HasNonceGap true if messages of partner are missing
+func (c *Channel) HasNonceGap() bool {
+	return c.nonceGap != nil
+}
+
+/*
+NeedResync 有没有解决的 nonce gap 并且还没有请求对方重发时返回 true 和对方最后一个 balance proof 的 nonce,
+同时标记为已经请求, 所以对方重复发送的消息不会引起多次请求.
+*/
+/*
+ *	NeedResync : returns true and nonce of the last balance proof of partner when there is an unresolved gap not requested yet,
+ *	the gap is marked requested, so retries of partner don't cause more requests.
+ */
+func (c *Channel) NeedResync() (nonce uint64, need bool) {
+	if c.nonceGap == nil || c.nonceGap.Requested {
+		return
+	}
+	c.nonceGap.Requested = true
+	return c.nonceGap.Nonce, true
+}
+
+//NonceGap copy of the unresolved gap, nil if there is none
+func (c *Channel) NonceGap() *channeltype.NonceGap {
+	if c.nonceGap == nil {
+		return nil
+	}
+	g := *c.nonceGap
+	return &g
+}
+
+//RestoreNonceGap restore the gap saved in db, ResyncRequest is sent again after restart, the last one may be lost
+func (c *Channel) RestoreNonceGap(g *channeltype.NonceGap) {
+	if g == nil {
+		c.nonceGap = nil
+		return
+	}
+	c.nonceGap = &channeltype.NonceGap{
+		Nonce: g.Nonce,
+		Seen:  g.Seen,
+	}
+}
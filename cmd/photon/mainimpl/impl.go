@@ -46,6 +46,8 @@ import (
 	ethutils "github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/exp"
 	"github.com/ethereum/go-ethereum/node"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -181,6 +183,85 @@ func StartMain() (*photon.API, error) {
 			Name:  "http-password",
 			Usage: "the password needed when call http api,only work with http-username",
 		},
+		cli.IntFlag{
+			Name:  "eth-circuit-breaker-threshold",
+			Usage: "stop eth rpc calls after this many consecutive connection errors until cool-down passes or geth reconnected, default 0 means disabled",
+		},
+		cli.IntFlag{
+			Name:  "eth-circuit-breaker-cooldown",
+			Usage: "seconds to wait before probing eth rpc again when circuit is open",
+			Value: int(params.DefaultEthCircuitBreakerCoolDown / time.Second),
+		},
+		cli.BoolFlag{
+			Name:  "eth-call-coalescing",
+			Usage: "concurrent identical contract calls share one eth rpc request,default is disabled",
+		},
+		cli.IntFlag{
+			Name:  "eth-rpc-call-timeout",
+			Usage: "seconds every eth rpc call waits at most, stuck calls are cancelled, default 0 means no limit",
+		},
+		cli.IntFlag{
+			Name:  "eth-receipt-poll-interval",
+			Usage: "milliseconds between queries of receipt while waiting for a tx to be mined, when new heads can't be subscribed",
+			Value: int(params.ReceiptPollInterval / time.Millisecond),
+		},
+		cli.IntFlag{
+			Name:  "eth-pending-tx-threshold",
+			Usage: "seconds a tx we sent can wait to be mined before it's reported as stuck in the log",
+			Value: int(helper.DefaultPendingThreshold / time.Second),
+		},
+		cli.StringSliceFlag{
+			Name:  "eth-rpc-fallback",
+			Usage: "backup eth rpc endpoints tried in order when eth-rpc-endpoint is not available,can be given multiple times",
+		},
+		cli.BoolFlag{
+			Name:  "eth-strict-chain-id",
+			Usage: "refuse eth-rpc-fallback endpoints whose network id differs from eth-rpc-endpoint",
+		},
+		cli.BoolFlag{
+			Name:  "metrics",
+			Usage: "record eth rpc connection metrics, served at /debug/metrics of the pprof server",
+		},
+		cli.IntFlag{
+			Name:  "transfer-idempotency-retention",
+			Usage: "seconds to keep identifiers of transfer requests for dedup",
+			Value: int(params.DefaultTransferIdempotencyRetention / time.Second),
+		},
+		cli.IntFlag{
+			Name:  "max-route-attempts",
+			Usage: "give up a transfer after this many routes refused it, default 0 means try every route",
+		},
+		cli.IntFlag{
+			Name:  "max-outbound-locks-per-channel",
+			Usage: "queue transfers we initiate when a channel has this many unresolved locks we initiated, 0 means no limit",
+			Value: params.DefaultMaxOutboundLocksPerChannel,
+		},
+		cli.StringFlag{
+			Name:  "transfer-amount-limits",
+			Usage: "bounds of amount of transfer requests, comma separated token:min:max in the smallest unit of the token, empty min or max means no bound",
+		},
+		cli.IntFlag{
+			Name:  "signature-cache-size",
+			Usage: "cache this many signers recovered from signatures, so rebroadcast balance proofs are verified cheaply, default 0 means no cache",
+		},
+		cli.Float64Flag{
+			Name:  "incoming-rate-limit",
+			Usage: "messages per second accepted from every partner, more are dropped, default 0 means no limit",
+		},
+		cli.IntFlag{
+			Name:  "incoming-rate-burst",
+			Usage: "messages accepted from a partner at once before incoming-rate-limit applies",
+			Value: params.DefaultIncomingRateBurst,
+		},
+		cli.IntFlag{
+			Name:  "incoming-ban-threshold",
+			Usage: "ban a partner when this many of its messages are dropped by incoming-rate-limit in a minute, default 0 means never ban",
+		},
+		cli.IntFlag{
+			Name:  "incoming-ban-duration",
+			Usage: "seconds to drop all messages of a banned partner",
+			Value: int(params.DefaultIncomingBanDuration / time.Second),
+		},
 		cli.StringFlag{
 			Name:  "db",
 			Usage: "use --db=gkv when need photon run with gkvdb,default db is boltdb,photon doesn't support change db type once db is created.",
@@ -221,6 +302,20 @@ func mainCtx(ctx *cli.Context) (err error) {
 		err = fmt.Errorf("cannot connect to geth :%s err=%s", cfg.EthRPCEndPoint, err)
 		err = nil
 	}
+	client.SetCircuitBreaker(helper.NewCircuitBreaker(ctx.Int("eth-circuit-breaker-threshold"),
+		time.Duration(ctx.Int("eth-circuit-breaker-cooldown"))*time.Second))
+	client.SetCallCoalescing(ctx.Bool("eth-call-coalescing"))
+	client.SetCallTimeout(time.Duration(ctx.Int("eth-rpc-call-timeout")) * time.Second)
+	if ctx.Int("eth-receipt-poll-interval") > 0 {
+		params.ReceiptPollInterval = time.Duration(ctx.Int("eth-receipt-poll-interval")) * time.Millisecond
+	}
+	client.SetFallbackURLs(ctx.StringSlice("eth-rpc-fallback"), ctx.Bool("eth-strict-chain-id"))
+	client.StartPendingTracker(time.Duration(ctx.Int("eth-pending-tx-threshold"))*time.Second, helper.DefaultPendingCheckInterval, nil)
+	if ctx.Bool("metrics") {
+		metrics.Enabled = true
+		client.SetMetricsProvider(helper.NewRegistryMetrics(metrics.DefaultRegistry))
+		exp.Exp(metrics.DefaultRegistry)
+	}
 	// open db
 	var dao models.Dao
 	if ctx.IsSet("db") && ctx.String("db") == "gkv" {
@@ -478,6 +573,29 @@ func config(ctx *cli.Context) (config *params.Config, err error) {
 		log.Info("fork-confirm enable...")
 		params.EnableForkConfirm = true
 	}
+	if ctx.Int("transfer-idempotency-retention") > 0 {
+		config.TransferIdempotencyRetention = time.Duration(ctx.Int("transfer-idempotency-retention")) * time.Second
+	}
+	if ctx.Int("max-route-attempts") > 0 {
+		config.MaxRouteAttempts = ctx.Int("max-route-attempts")
+	}
+	config.MaxOutboundLocksPerChannel = ctx.Int("max-outbound-locks-per-channel")
+	config.IncomingRateLimit = ctx.Float64("incoming-rate-limit")
+	config.IncomingRateBurst = ctx.Int("incoming-rate-burst")
+	config.IncomingBanThreshold = ctx.Int("incoming-ban-threshold")
+	config.IncomingBanDuration = time.Duration(ctx.Int("incoming-ban-duration")) * time.Second
+	if ctx.IsSet("transfer-amount-limits") {
+		config.TransferAmountLimits, err = params.ParseTransferAmountLimits(ctx.String("transfer-amount-limits"))
+		if err != nil {
+			return
+		}
+	}
+	if ctx.Int("signature-cache-size") > 0 {
+		err = utils.EnableSignatureCache(ctx.Int("signature-cache-size"))
+		if err != nil {
+			return
+		}
+	}
 	if ctx.IsSet("http-username") && ctx.IsSet("http-password") {
 		config.HTTPUsername = ctx.String("http-username")
 		config.HTTPPassword = ctx.String("http-password")
@@ -551,7 +669,17 @@ func getDefaultRegistryByEthClient(client *helper.SafeEthClient) (registryAddres
 		log.Error(err.Error())
 		return
 	}
-	registryAddress = params.GenesisBlockHashToDefaultRegistryAddress[genesisBlockHash]
+	registryAddress, ok := params.GenesisBlockHashToDefaultRegistryAddress[genesisBlockHash]
+	if ok {
+		return
+	}
+	//unknown genesis, try the deployment of this chain id
+	deployment, err := client.LookupDeployment(context.Background())
+	if err != nil {
+		log.Warn(fmt.Sprintf("no default registry for genesis %s : %s", genesisBlockHash.String(), err))
+		return registryAddress, nil
+	}
+	registryAddress = deployment.TokenNetworkRegistry
 	return
 }
 func getDefaultPFSByEthClient(client *helper.SafeEthClient) (pfs string, err error) {
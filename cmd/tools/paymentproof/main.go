@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/urfave/cli"
+)
+
+/*
+verify a payment proof exported by GET /api/1/transfers/:token/:target/:id/proof, no photon node or chain is needed
+*/
+func main() {
+	app := cli.NewApp()
+	app.Name = "paymentproof"
+	app.Usage = "verify a payment proof offline"
+	app.ArgsUsage = "<proof.json>"
+	app.Version = "0.1"
+	app.Action = mainctx
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func mainctx(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("usage: %s %s", ctx.App.Name, ctx.App.ArgsUsage)
+	}
+	data, err := ioutil.ReadFile(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	var proof models.PaymentProof
+	err = json.Unmarshal(data, &proof)
+	if err != nil {
+		return fmt.Errorf("invalid payment proof: %s", err)
+	}
+	fmt.Printf("transfer %s of token %s, %s from %s to %s\n", proof.LockSecretHash.String(), proof.TokenAddress.String(),
+		proof.Amount, proof.Initiator.String(), proof.Target.String())
+	fmt.Printf("proven on channel from %s to %s, chain id %s\n\n", proof.Payer.String(), proof.Payee.String(), proof.ChainID)
+	verdict := models.VerifyPaymentProof(&proof)
+	fmt.Print(verdict.String())
+	if !verdict.Valid {
+		os.Exit(1)
+	}
+	return nil
+}
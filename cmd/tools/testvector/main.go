@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/testvector"
+	"github.com/urfave/cli"
+)
+
+/*
+print merkle root, lock hashes, merkle proofs and the packed and hashed balance proof of test vectors,
+exactly as the TokensNetwork contract computes them, so clients in other languages can be checked against them.
+vectors with expected results are verified, --update writes computed results as expected ones.
+*/
+func main() {
+	app := cli.NewApp()
+	app.Name = "testvector"
+	app.Usage = "compute merkle roots and balance proof hashes of test vectors"
+	app.ArgsUsage = "<vectors.json>"
+	app.Version = "0.1"
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "update",
+			Usage: "write computed results into the file as expected results",
+		},
+	}
+	app.Action = mainctx
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func mainctx(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return fmt.Errorf("usage: %s %s", ctx.App.Name, ctx.App.ArgsUsage)
+	}
+	filename := ctx.Args().First()
+	vectors, err := testvector.Load(filename)
+	if err != nil {
+		return fmt.Errorf("invalid vectors: %s", err)
+	}
+	mismatch := false
+	for _, v := range vectors {
+		r, err := testvector.Compute(v)
+		if err != nil {
+			return err
+		}
+		printResult(v, r)
+		if ctx.Bool("update") {
+			v.Expected = r
+			continue
+		}
+		if v.Expected != nil {
+			if diffs := testvector.Diff(v.Expected, r); len(diffs) > 0 {
+				mismatch = true
+				for _, d := range diffs {
+					fmt.Printf("\tMISMATCH %s\n", d)
+				}
+			}
+		}
+		fmt.Println()
+	}
+	if ctx.Bool("update") {
+		return testvector.Save(filename, vectors)
+	}
+	if mismatch {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func printResult(v *testvector.Vector, r *testvector.Result) {
+	fmt.Printf("%s\n", v.Name)
+	for i, l := range v.Locks {
+		fmt.Printf("\tlock %d expiration=%d amount=%s secrethash=%s\n", i, l.Expiration, l.Amount, l.SecretHash().String())
+		fmt.Printf("\t\thash=%s\n\t\tproof=%s\n", r.LockHashes[i].String(), r.Proofs[i])
+	}
+	fmt.Printf("\tmerkle root=%s\n", r.MerkleRoot.String())
+	fmt.Printf("\tbalance hash=%s\n", r.BalanceHash)
+	fmt.Printf("\tbalance proof packed=%s\n", r.BalanceProofPacked)
+	fmt.Printf("\tbalance proof hash=%s\n", r.BalanceProofHash.String())
+}
@@ -243,7 +243,7 @@ func (w *withDraw) WithDrawOnChannel() {
 				break
 			}
 			unlockProofs2 := c.PartnerState.GetCanUnlockOnChainLocks()
-			result = c.ExternState.Unlock(unlockProofs2, c.PartnerState.TransferAmount())
+			result = c.ExternState.Unlock(unlockProofs2, c.PartnerState.TransferAmount(), c.PartnerState.Tree.MerkleRoot())
 			err = <-result.Result
 			if err != nil {
 				log.Error(err.Error())
@@ -72,6 +72,21 @@ const (
 	*/
 	// Respond Refund
 	AnnounceDisposedTransferResponseCmdID
+	/*
+		委托第三方节点监控通道
+	*/
+	// delegate a monitor node to watch channel
+	MonitorDelegateCmdID
+	/*
+		收款方签名的收据
+	*/
+	// receipt signed by target of a transfer
+	PaymentReceiptCmdID
+	/*
+		请求对方重发 balance proof
+	*/
+	// ask partner to retransmit balance proofs
+	ResyncRequestCmdID
 )
 
 const signatureLength = 65
@@ -82,6 +97,128 @@ func init() {
 
 var errPacketLength = errors.New("packet length error")
 
+//envelopLength length of a packed EnvelopMessage, signature included
+const envelopLength = 8 + 32 + 8 + 32 + 32 + signatureLength
+
+/*
+消息扩展字段: 后来增加的可选字段放在消息的固定部分之后, 没有扩展字段时不写任何内容, 消息和以前完全一样.
+有扩展字段时先写一个版本字节 extensionVersion, 再写一个 flags 字节说明有哪些字段, 然后按 flag 从低到高写各个字段.
+解析时不认识的版本或者 flag 都是错误, flags 为 0 也是错误, 所以一个消息只有一种编码.
+*/
+/*
+ *	Extensions of messages: optional fields added later follow the fixed part of a message,
+ *	nothing is written without them, so such messages are the same as before.
+ *	Otherwise a version byte extensionVersion is written, then a flags byte telling which fields follow,
+ *	then the fields in order of their flags from low to high.
+ *	Unknown versions or flags are errors when unpacking, so are flags of 0, so a message has only one encoding.
+ */
+const extensionVersion byte = 1
+
+const (
+	//extensionMetadata metadata and, for MediatedTransfer, signature of initiator on it
+	extensionMetadata byte = 1 << iota
+	//extensionHashAlgorithm one byte of hash algorithm of the lock, only for algorithms other than sha256
+	extensionHashAlgorithm
+)
+
+//packExtensionHeader writes version and flags, nothing if there is no extension
+func packExtensionHeader(buf *bytes.Buffer, flags byte) (err error) {
+	if flags == 0 {
+		return nil
+	}
+	err = buf.WriteByte(extensionVersion)
+	if err != nil {
+		return
+	}
+	return buf.WriteByte(flags)
+}
+
+//unpackExtensionHeader reads version and flags if buf has more than `left` bytes, `known` are flags the message may have
+func unpackExtensionHeader(buf *bytes.Buffer, left int, known byte) (flags byte, err error) {
+	if buf.Len() <= left {
+		return 0, nil
+	}
+	version, err := buf.ReadByte()
+	if err != nil {
+		return
+	}
+	if version != extensionVersion {
+		return 0, fmt.Errorf("unknown extension version %d", version)
+	}
+	flags, err = buf.ReadByte()
+	if err != nil {
+		return 0, errPacketLength
+	}
+	if flags == 0 || flags&^known != 0 {
+		return 0, fmt.Errorf("invalid extension flags %d", flags)
+	}
+	return
+}
+
+/*
+hash 算法是扩展字段 extensionHashAlgorithm, 只有非默认的 hash 算法才写入一个字节, 这样 sha256 锁的消息和以前完全一样.
+这个字节在签名之前, 受签名保护.
+*/
+// hash algorithm is extension extensionHashAlgorithm, only non-default algorithm is written as one byte,
+// so messages of sha256 locks are the same as before. The byte is before signature, so it's signed.
+
+//hashAlgorithmFlags extension flags of hash algorithm a, none for sha256
+func hashAlgorithmFlags(a utils.HashAlgorithm) byte {
+	if a == utils.HashAlgorithmSHA256 {
+		return 0
+	}
+	return extensionHashAlgorithm
+}
+
+//packHashAlgorithm writes the byte of extension extensionHashAlgorithm
+func packHashAlgorithm(buf *bytes.Buffer, a utils.HashAlgorithm) error {
+	return buf.WriteByte(byte(a))
+}
+
+//unpackHashAlgorithm reads what packHashAlgorithm writes
+func unpackHashAlgorithm(buf *bytes.Buffer) (a utils.HashAlgorithm, err error) {
+	b, err := buf.ReadByte()
+	if err != nil {
+		return a, errPacketLength
+	}
+	a = utils.HashAlgorithm(b)
+	//sha256 must be implicit, otherwise one message would have two encodings
+	if !a.IsValid() || a == utils.HashAlgorithmSHA256 {
+		return a, fmt.Errorf("invalid hash algorithm %d", b)
+	}
+	return a, nil
+}
+
+//packMetadata writes length (uvarint) and metadata
+func packMetadata(buf *bytes.Buffer, metadata []byte) (err error) {
+	var l [binary.MaxVarintLen64]byte
+	_, err = buf.Write(l[:binary.PutUvarint(l[:], uint64(len(metadata)))])
+	if err != nil {
+		return
+	}
+	_, err = buf.Write(metadata)
+	return
+}
+
+//unpackMetadata reads what packMetadata writes, length must be minimally encoded and no more than params.MaxTransferMetadataLen
+func unpackMetadata(buf *bytes.Buffer) (metadata []byte, err error) {
+	l := buf.Len()
+	n, err := binary.ReadUvarint(buf)
+	if err != nil {
+		return nil, errPacketLength
+	}
+	var minimal [binary.MaxVarintLen64]byte
+	if n == 0 || n > uint64(params.MaxTransferMetadataLen) || l-buf.Len() != binary.PutUvarint(minimal[:], n) {
+		return nil, fmt.Errorf("invalid metadata length %d", n)
+	}
+	if uint64(buf.Len()) < n {
+		return nil, errPacketLength
+	}
+	metadata = make([]byte, n)
+	_, err = buf.Read(metadata)
+	return
+}
+
 //MessagePacker serialize of a message
 type MessagePacker interface {
 	//pack message to byte array
@@ -187,6 +324,12 @@ func (t MessageType) String() string {
 		return "WithdrawRequest"
 	case WithdrawResponseCmdID:
 		return "WithdrawResponse"
+	case MonitorDelegateCmdID:
+		return "MonitorDelegate"
+	case PaymentReceiptCmdID:
+		return "PaymentReceipt"
+	case ResyncRequestCmdID:
+		return "ResyncRequest"
 	default:
 		return "<unknown>"
 	}
@@ -203,14 +346,41 @@ type Ack struct {
 	CmdStruct
 	Sender common.Address
 	Echo   common.Hash
+	//Capabilities messages the sender understands beyond the original protocol, 0 for old nodes
+	Capabilities uint32
 }
 
+/*
+消息能力:
+老节点不认识后来增加的消息, 不会回复 ack, 发送方会一直重发. 所以这些消息只发给声明了支持它们的节点.
+节点在每个 Ack 的末尾附加 ackExtensionVersion 和 Capabilities, 老节点解析 Ack 时忽略多余的字节, 不受影响.
+*/
+/*
+ *	Capabilities of messages:
+ *	old nodes don't know messages added later and never ack them, the sender would resend them forever,
+ *	so such messages are only sent to nodes declaring they support them.
+ *	A node appends ackExtensionVersion and Capabilities to every Ack, old nodes ignore extra bytes of Ack.
+ */
+const (
+	//CapabilityPaymentReceipt understands PaymentReceipt
+	CapabilityPaymentReceipt uint32 = 1 << iota
+	//CapabilityResync understands ResyncRequest
+	CapabilityResync
+)
+
+//LocalCapabilities capabilities of this node
+const LocalCapabilities = CapabilityPaymentReceipt | CapabilityResync
+
+//ackExtensionVersion version of fields appended to Ack, unknown versions are ignored
+const ackExtensionVersion byte = 1
+
 //NewAck create ack message
 func NewAck(sender common.Address, echo common.Hash) *Ack {
 	return &Ack{
-		CmdStruct: CmdStruct{CmdID: AckCmdID},
-		Sender:    sender,
-		Echo:      echo,
+		CmdStruct:    CmdStruct{CmdID: AckCmdID},
+		Sender:       sender,
+		Echo:         echo,
+		Capabilities: LocalCapabilities,
 	}
 }
 
@@ -221,6 +391,10 @@ func (ack *Ack) Pack() []byte {
 	err = binary.Write(buf, binary.LittleEndian, ack.CmdID)
 	_, err = buf.Write(ack.Sender[:])
 	_, err = buf.Write(ack.Echo[:])
+	if ack.Capabilities != 0 {
+		err = buf.WriteByte(ackExtensionVersion)
+		err = binary.Write(buf, binary.BigEndian, ack.Capabilities)
+	}
 	if err != nil {
 		log.Crit(fmt.Sprintf("Ack Pack err %s", err))
 	}
@@ -245,6 +419,15 @@ func (ack *Ack) UnPack(data []byte) error {
 	if n != len(ack.Echo) {
 		return errPacketLength
 	}
+	//old nodes append nothing
+	version, err := buf.ReadByte()
+	if err != nil || version != ackExtensionVersion {
+		return nil
+	}
+	err = binary.Read(buf, binary.BigEndian, &ack.Capabilities)
+	if err != nil {
+		return errPacketLength
+	}
 	return nil
 }
 func (ack *Ack) String() string {
@@ -306,16 +489,8 @@ func HashMessageWithoutSignature(pack MessagePacker) common.Hash {
 //VerifyMessage returns the sender of message if data is a valid SignedMessage
 func VerifyMessage(data []byte) (sender common.Address, err error) {
 	messageData := data[:len(data)-signatureLength]
-	signature := make([]byte, signatureLength)
-	copy(signature, data[len(data)-signatureLength:])
 	hash := utils.Sha3(messageData)
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
-	if err != nil {
-		return
-	}
-	sender = utils.PubkeyToAddress(pubkey)
-	return
+	return utils.Ecrecover(hash, data[len(data)-signatureLength:])
 }
 
 //Ping message
@@ -449,7 +624,8 @@ type RevealSecret struct {
 	SignedMessage
 	LockSecret     common.Hash
 	lockSecretHash common.Hash
-	Data           []byte // used to transfer custom message, length should < 256
+	Data           []byte              // used to transfer custom message, length should < 256
+	HashAlgorithm  utils.HashAlgorithm // hash algorithm of the lock, sha256 by default
 }
 
 //NewRevealSecret create RevealSecret
@@ -470,7 +646,7 @@ func CloneRevealSecret(rs *RevealSecret) *RevealSecret {
 //LockSecretHash return hash of secret
 func (rs *RevealSecret) LockSecretHash() common.Hash {
 	if rs.lockSecretHash == utils.EmptyHash {
-		rs.lockSecretHash = utils.ShaSecret(rs.LockSecret[:])
+		rs.lockSecretHash = rs.HashAlgorithm.HashSecret(rs.LockSecret[:])
 	}
 	return rs.lockSecretHash
 }
@@ -487,6 +663,11 @@ func (rs *RevealSecret) Pack() []byte {
 	if dataLen > 0 {
 		_, err = buf.Write(rs.Data)
 	}
+	flags := hashAlgorithmFlags(rs.HashAlgorithm)
+	err = packExtensionHeader(buf, flags)
+	if flags&extensionHashAlgorithm != 0 {
+		err = packHashAlgorithm(buf, rs.HashAlgorithm)
+	}
 	_, err = buf.Write(rs.Signature)
 	if err != nil {
 		log.Crit(fmt.Sprintf("RevealSecret Pack err %s", err))
@@ -520,6 +701,18 @@ func (rs *RevealSecret) UnPack(data []byte) error {
 			return errors.New("RevealSecret unpack data error")
 		}
 	}
+	flags, err := unpackExtensionHeader(buf, signatureLength, extensionHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	rs.HashAlgorithm = utils.HashAlgorithmSHA256
+	if flags&extensionHashAlgorithm != 0 {
+		rs.HashAlgorithm, err = unpackHashAlgorithm(buf)
+		if err != nil {
+			return err
+		}
+	}
+	rs.lockSecretHash = utils.EmptyHash
 	rs.Signature = make([]byte, signatureLength)
 	n, err := buf.Read(rs.Signature)
 	if err != nil {
@@ -557,6 +750,33 @@ func NewBalanceProof(nonce uint64, transferredAmount *big.Int, locksRoot common.
 	}
 }
 
+/*
+TransferDelta 计算两个连续的 balance proof 之间转了多少钱, 用于记账.
+newer 的 nonce 必须比 older 大, TransferAmount 只能增加, 减少说明对方违反了协议.
+*/
+/*
+ *	TransferDelta : how much value moved between two successive balance proofs, for accounting.
+ *	newer must have a higher nonce than older, and TransferAmount never decreases,
+ *	a negative delta means the partner violated the protocol.
+ */
+func TransferDelta(older, newer *BalanceProof) (*big.Int, error) {
+	if older == nil || newer == nil || older.TransferAmount == nil || newer.TransferAmount == nil {
+		return nil, errors.New("balance proof is empty")
+	}
+	if older.ChannelIdentifier != newer.ChannelIdentifier || older.OpenBlockNumber != newer.OpenBlockNumber {
+		return nil, fmt.Errorf("balance proofs belong to different channels %s-%d and %s-%d",
+			utils.HPex(older.ChannelIdentifier), older.OpenBlockNumber, utils.HPex(newer.ChannelIdentifier), newer.OpenBlockNumber)
+	}
+	if newer.Nonce <= older.Nonce {
+		return nil, fmt.Errorf("nonce of newer balance proof %d is not higher than %d", newer.Nonce, older.Nonce)
+	}
+	delta := new(big.Int).Sub(newer.TransferAmount, older.TransferAmount)
+	if delta.Sign() < 0 {
+		return nil, fmt.Errorf("transfer amount decreased from %s to %s", older.TransferAmount, newer.TransferAmount)
+	}
+	return delta, nil
+}
+
 //EnvelopMessage is general part of message that contains a new balanceproof
 type EnvelopMessage struct {
 	SignedMessage
@@ -569,17 +789,22 @@ func (m *EnvelopMessage) String() string {
 		utils.HPex(m.ChannelIdentifier), m.OpenBlockNumber, m.TransferAmount, utils.HPex(m.Locksroot), utils.APex2(m.Sender), len(m.Signature) != 0)
 }
 func (m *EnvelopMessage) signData(datahash common.Hash) []byte {
+	return m.BalanceProof.signData(datahash, params.ChainID)
+}
+
+//signData what the contract verifies for a balance proof on chain chainID, datahash is the hash of the message carrying it
+func (bp *BalanceProof) signData(datahash common.Hash, chainID *big.Int) []byte {
 	var err error
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractBalanceProofMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(m.TransferAmount))
-	_, err = buf.Write(m.Locksroot[:])
-	err = binary.Write(buf, binary.BigEndian, m.Nonce)
+	_, err = buf.Write(utils.BigIntTo32Bytes(bp.TransferAmount))
+	_, err = buf.Write(bp.Locksroot[:])
+	err = binary.Write(buf, binary.BigEndian, bp.Nonce)
 	_, err = buf.Write(datahash[:])
-	_, err = buf.Write(m.ChannelIdentifier[:])
-	err = binary.Write(buf, binary.BigEndian, m.OpenBlockNumber)
-	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
+	_, err = buf.Write(bp.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, bp.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(chainID))
 	if err != nil {
 		log.Error(fmt.Sprintf("signData err %s", err))
 	}
@@ -587,6 +812,35 @@ func (m *EnvelopMessage) signData(datahash common.Hash) []byte {
 	return dataToSign
 }
 
+//BalanceProofSignData packed balance proof which is hashed and signed, the same bytes the contract rebuilds on chain chainID, additionalHash is the hash of the message carrying it
+func BalanceProofSignData(bp *BalanceProof, additionalHash common.Hash, chainID *big.Int) []byte {
+	return bp.signData(additionalHash, chainID)
+}
+
+/*
+RecoverBalanceProofSigner 恢复 balance proof 的签名者, messageHash 是携带它的消息去掉签名以后的 hash.
+chainID 显式指定, 不依赖 params.ChainID, 离线验证其他链上的 balance proof 时使用.
+*/
+/*
+ *	RecoverBalanceProofSigner : recover the signer of a balance proof, messageHash is the hash of the message carrying it without signature.
+ *	chainID is explicit instead of params.ChainID, so balance proofs of any chain can be verified offline.
+ */
+func RecoverBalanceProofSigner(bp *BalanceProof, messageHash common.Hash, signature []byte, chainID *big.Int) (common.Address, error) {
+	if bp.TransferAmount == nil || chainID == nil {
+		return utils.EmptyAddress, errors.New("balance proof is empty")
+	}
+	return utils.Ecrecover(utils.Sha3(bp.signData(messageHash, chainID)), signature)
+}
+
+//RecoverEnvelopMessageSigner recover the signer of a packed envelop message on chain chainID, msg must be unpacked from data
+func RecoverEnvelopMessageSigner(msg EnvelopMessager, data []byte, chainID *big.Int) (common.Address, error) {
+	if len(data) <= signatureLength {
+		return utils.EmptyAddress, errPacketLength
+	}
+	env := msg.GetEnvelopMessage()
+	return RecoverBalanceProofSigner(&env.BalanceProof, utils.Sha3(data[:len(data)-signatureLength]), data[len(data)-signatureLength:], chainID)
+}
+
 /*
 Sign data=(once+transferamount+locksroot+channel+hash(data))
 */
@@ -613,12 +867,11 @@ func (m *EnvelopMessage) verifySignature(data []byte) error {
 	var signature = make([]byte, signatureLength)
 	copy(signature, data[len(data)-signatureLength:])
 	hash := utils.Sha3(datatosign)
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
+	sender, err := utils.Ecrecover(hash, signature)
 	if err != nil {
 		return err
 	}
-	m.Sender = utils.PubkeyToAddress(pubkey)
+	m.Sender = sender
 	return nil
 
 }
@@ -690,12 +943,13 @@ the other party to withdraw.
 */
 type UnLock struct {
 	EnvelopMessage
-	LockSecret common.Hash
+	LockSecret    common.Hash
+	HashAlgorithm utils.HashAlgorithm // hash algorithm of the lock, sha256 by default
 }
 
 //LockSecretHash is Hash of secret
 func (s *UnLock) LockSecretHash() common.Hash {
-	return utils.ShaSecret(s.LockSecret[:])
+	return s.HashAlgorithm.HashSecret(s.LockSecret[:])
 }
 
 //NewUnlock create Secret message
@@ -714,6 +968,11 @@ func (s *UnLock) Pack() []byte {
 	buf := new(bytes.Buffer)
 	err = binary.Write(buf, binary.LittleEndian, s.CmdID) //only one byte.
 	_, err = buf.Write(s.LockSecret[:])
+	flags := hashAlgorithmFlags(s.HashAlgorithm)
+	err = packExtensionHeader(buf, flags)
+	if flags&extensionHashAlgorithm != 0 {
+		err = packHashAlgorithm(buf, s.HashAlgorithm)
+	}
 	s.EnvelopMessage.pack(buf)
 	if err != nil {
 		log.Crit(fmt.Sprintf("UnLock Pack err %s", err))
@@ -732,6 +991,17 @@ func (s *UnLock) UnPack(data []byte) error {
 		return fmt.Errorf("Ack Secret cmdid should be  4,but get %d", t)
 	}
 	_, err = buf.Read(s.LockSecret[:])
+	flags, err := unpackExtensionHeader(buf, envelopLength, extensionHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	s.HashAlgorithm = utils.HashAlgorithmSHA256
+	if flags&extensionHashAlgorithm != 0 {
+		s.HashAlgorithm, err = unpackHashAlgorithm(buf)
+		if err != nil {
+			return err
+		}
+	}
 	err = s.EnvelopMessage.unpack(buf)
 	if err != nil {
 		return err
@@ -928,6 +1198,11 @@ type MediatedTransfer struct {
 	Target         common.Address
 	Initiator      common.Address
 	Fee            *big.Int
+	HashAlgorithm  utils.HashAlgorithm // hash algorithm of the lock, sha256 by default
+	//Metadata opaque data from initiator to target, e.g. an order id, at most params.MaxTransferMetadataLen bytes
+	Metadata []byte
+	//MetadataSignature signature of initiator on Metadata, mediators forward both unchanged, see SignMetadata
+	MetadataSignature []byte
 }
 
 //String is fmt.Stringer
@@ -947,6 +1222,7 @@ func NewMediatedTransfer(bp *BalanceProof, lock *mtree.Lock,
 		PaymentAmount:  lock.Amount,
 		LockSecretHash: lock.LockSecretHash,
 		Expiration:     lock.Expiration,
+		HashAlgorithm:  lock.HashAlgorithm,
 	}
 	p.CmdID = MediatedTransferCmdID
 	p.EnvelopMessage.fromBalanceProof(bp)
@@ -959,6 +1235,7 @@ func (m *MediatedTransfer) GetLock() *mtree.Lock {
 		Expiration:     m.Expiration,
 		Amount:         m.PaymentAmount,
 		LockSecretHash: m.LockSecretHash,
+		HashAlgorithm:  m.HashAlgorithm,
 	}
 }
 
@@ -975,6 +1252,18 @@ func (m *MediatedTransfer) Pack() []byte {
 	_, err = buf.Write(m.Target[:])
 	_, err = buf.Write(m.Initiator[:])
 	_, err = buf.Write(utils.BigIntTo32Bytes(m.Fee))
+	flags := hashAlgorithmFlags(m.HashAlgorithm)
+	if len(m.Metadata) > 0 {
+		flags |= extensionMetadata
+	}
+	err = packExtensionHeader(buf, flags)
+	if flags&extensionMetadata != 0 {
+		err = packMetadata(buf, m.Metadata)
+		_, err = buf.Write(m.MetadataSignature)
+	}
+	if flags&extensionHashAlgorithm != 0 {
+		err = packHashAlgorithm(buf, m.HashAlgorithm)
+	}
 	m.EnvelopMessage.pack(buf)
 	if err != nil {
 		log.Crit(fmt.Sprintf("MediatedTransfer Pack err %s", err))
@@ -982,6 +1271,87 @@ func (m *MediatedTransfer) Pack() []byte {
 	return buf.Bytes()
 }
 
+/*
+unpackExtensions metadata 只在有内容时才写入, 是扩展字段 extensionMetadata, 格式是长度(uvarint) + metadata + 发起方签名,
+之后是扩展字段 extensionHashAlgorithm. 没有 metadata 并且是 sha256 锁的消息和以前完全一样. left 是扩展字段之后的长度.
+*/
+// unpackExtensions : metadata is written only if it's not empty, as extension extensionMetadata,
+// which is length (uvarint) + metadata + signature of initiator, extension extensionHashAlgorithm follows,
+// so messages of sha256 locks without metadata are the same as before. left is the length after extensions.
+func (m *MediatedTransfer) unpackExtensions(buf *bytes.Buffer, left int) error {
+	m.HashAlgorithm = utils.HashAlgorithmSHA256
+	flags, err := unpackExtensionHeader(buf, left, extensionMetadata|extensionHashAlgorithm)
+	if err != nil {
+		return err
+	}
+	if flags&extensionMetadata != 0 {
+		m.Metadata, err = unpackMetadata(buf)
+		if err != nil {
+			return err
+		}
+		if buf.Len() < signatureLength+left {
+			return errPacketLength
+		}
+		m.MetadataSignature = make([]byte, signatureLength)
+		_, err = buf.Read(m.MetadataSignature)
+		if err != nil {
+			return err
+		}
+		err = m.VerifyMetadata()
+		if err != nil {
+			return err
+		}
+	}
+	if flags&extensionHashAlgorithm != 0 {
+		m.HashAlgorithm, err = unpackHashAlgorithm(buf)
+	}
+	return err
+}
+
+//metadataSignData what initiator signs for Metadata, it's bound to the transfer so it can't be moved to another one
+func (m *MediatedTransfer) metadataSignData() []byte {
+	buf := new(bytes.Buffer)
+	_, err := buf.Write([]byte("photon transfer metadata"))
+	_, err = buf.Write(m.LockSecretHash[:])
+	_, err = buf.Write(m.Target[:])
+	_, err = buf.Write(m.Metadata)
+	if err != nil {
+		log.Error(fmt.Sprintf("metadataSignData err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//SignMetadata initiator signs Metadata, must be called before Sign
+func (m *MediatedTransfer) SignMetadata(privKey *ecdsa.PrivateKey) (err error) {
+	if len(m.Metadata) > params.MaxTransferMetadataLen {
+		return fmt.Errorf("metadata too long, length must <= %d", params.MaxTransferMetadataLen)
+	}
+	if len(m.Metadata) == 0 {
+		m.MetadataSignature = nil
+		return nil
+	}
+	m.MetadataSignature, err = utils.SignData(privKey, m.metadataSignData())
+	return
+}
+
+//VerifyMetadata returns error if Metadata is not signed by Initiator, no metadata is fine
+func (m *MediatedTransfer) VerifyMetadata() error {
+	if len(m.Metadata) == 0 {
+		return nil
+	}
+	if len(m.MetadataSignature) != signatureLength {
+		return errors.New("metadata not signed")
+	}
+	signer, err := utils.Ecrecover(utils.Sha3(m.metadataSignData()), m.MetadataSignature)
+	if err != nil {
+		return err
+	}
+	if signer != m.Initiator {
+		return fmt.Errorf("metadata signed by %s, not initiator %s", utils.APex2(signer), utils.APex2(m.Initiator))
+	}
+	return nil
+}
+
 //UnPack is MessageUnPacker
 func (m *MediatedTransfer) UnPack(data []byte) error {
 	var t int32
@@ -1000,6 +1370,10 @@ func (m *MediatedTransfer) UnPack(data []byte) error {
 	_, err = buf.Read(m.Target[:])
 	_, err = buf.Read(m.Initiator[:])
 	m.Fee = utils.ReadBigInt(buf)
+	err = m.unpackExtensions(buf, envelopLength)
+	if err != nil {
+		return err
+	}
 	err = m.EnvelopMessage.unpack(buf)
 	if err != nil {
 		return err
@@ -1148,12 +1522,11 @@ func (m *AnnounceDisposed) verifySignature(data []byte) error {
 	var signature = make([]byte, signatureLength)
 	copy(signature, data[len(data)-signatureLength:])
 	hash := utils.Sha3(datatosign)
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
+	sender, err := utils.Ecrecover(hash, signature)
 	if err != nil {
 		return err
 	}
-	m.Sender = utils.PubkeyToAddress(pubkey)
+	m.Sender = sender
 	return nil
 
 }
@@ -1766,6 +2139,271 @@ func (m *SettleResponse) Sign(key *ecdsa.PrivateKey, msg MessagePacker) (err err
 	return
 }
 
+/*
+MonitorDelegate 委托第三方节点监控通道, 对方关闭通道以后由第三方调用 updateBalanceProofDelegate,unlockDelegate 以及 punish.
+Data 是委托需要的所有证据, Fee 是承诺给第三方的手续费, 整个消息由委托人签名.
+*/
+/*
+ *	MonitorDelegate : delegate a monitor node to watch channel, when partner closes channel,
+ *	monitor calls updateBalanceProofDelegate, unlockDelegate and punishObsoleteUnlock on chain.
+ *	Data contains all the proofs monitor needs, Fee is promised to monitor, the whole message is signed by delegator.
+ */
+type MonitorDelegate struct {
+	SignedMessage
+	ChannelIDInMessage
+	TokenAddress   common.Address
+	PartnerAddress common.Address
+	Fee            *big.Int
+	Data           []byte
+}
+
+//NewMonitorDelegate create MonitorDelegate message
+func NewMonitorDelegate(id *ChannelIDInMessage, token, partner common.Address, fee *big.Int, data []byte) *MonitorDelegate {
+	m := &MonitorDelegate{
+		ChannelIDInMessage: *id,
+		TokenAddress:       token,
+		PartnerAddress:     partner,
+		Fee:                fee,
+		Data:               data,
+	}
+	m.CmdID = MonitorDelegateCmdID
+	return m
+}
+
+func (m *MonitorDelegate) String() string {
+	return fmt.Sprintf("Message{type=MonitorDelegate Channel=%s-%d,token=%s,partner=%s,fee=%s,data len=%d,sender=%s}",
+		utils.HPex(m.ChannelIdentifier), m.OpenBlockNumber, utils.APex2(m.TokenAddress),
+		utils.APex2(m.PartnerAddress), m.Fee, len(m.Data), utils.APex2(m.Sender))
+}
+
+//Pack is MessagePacker
+func (m *MonitorDelegate) Pack() []byte {
+	var err error
+	buf := new(bytes.Buffer)
+	err = binary.Write(buf, binary.LittleEndian, m.CmdID)
+	_, err = buf.Write(m.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, m.OpenBlockNumber)
+	_, err = buf.Write(m.TokenAddress[:])
+	_, err = buf.Write(m.PartnerAddress[:])
+	_, err = buf.Write(utils.BigIntTo32Bytes(m.Fee))
+	err = binary.Write(buf, binary.BigEndian, uint32(len(m.Data)))
+	_, err = buf.Write(m.Data)
+	_, err = buf.Write(m.Signature)
+	if err != nil {
+		log.Crit(fmt.Sprintf("pack MonitorDelegate err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//UnPack is MessageUnPacker
+func (m *MonitorDelegate) UnPack(data []byte) error {
+	var t int32
+	var l uint32
+	var err error
+	m.CmdID = MonitorDelegateCmdID
+	buf := bytes.NewBuffer(data)
+	err = binary.Read(buf, binary.LittleEndian, &t)
+	if t != m.CmdID {
+		return fmt.Errorf("MonitorDelegate UnPack cmdid expect=%d,got=%d", MonitorDelegateCmdID, t)
+	}
+	_, err = buf.Read(m.ChannelIdentifier[:])
+	err = binary.Read(buf, binary.BigEndian, &m.OpenBlockNumber)
+	_, err = buf.Read(m.TokenAddress[:])
+	_, err = buf.Read(m.PartnerAddress[:])
+	m.Fee = utils.ReadBigInt(buf)
+	err = binary.Read(buf, binary.BigEndian, &l)
+	if err != nil || int(l) != buf.Len()-signatureLength {
+		return errPacketLength
+	}
+	m.Data = make([]byte, l)
+	_, err = buf.Read(m.Data)
+	m.Signature = make([]byte, signatureLength)
+	n, err := buf.Read(m.Signature)
+	if err != nil || n != signatureLength {
+		return fmt.Errorf("MonitorDelegate UnPack Signature err=%v,n=%d", err, n)
+	}
+	return m.SignedMessage.verifySignature(data)
+}
+
+/*
+PaymentReceipt 交易成功以后收款方发给发起方的收据, 由收款方签名, 任何第三方都可以通过签名验证收款方确实收到了这笔钱.
+LockSecretHash 唯一确定一笔交易, BlockNumber 是收款方确认收到时的块高.
+Metadata 是交易携带的 metadata(比如订单号), 是扩展字段, 没有时不编码, 收据的格式和以前一样.
+*/
+/*
+ *	PaymentReceipt : sent by target to initiator after a transfer succeeded, signed by target,
+ *	so anyone can check from the signature that target did receive the payment.
+ *	LockSecretHash identifies the transfer, BlockNumber is the block at which target got the payment.
+ *	Metadata is the metadata the transfer carried, e.g. an order id, it's an extension not encoded if empty,
+ *	so such receipts are the same as before.
+ */
+type PaymentReceipt struct {
+	SignedMessage
+	TokenAddress   common.Address
+	LockSecretHash common.Hash
+	Amount         *big.Int
+	Initiator      common.Address
+	BlockNumber    int64
+	Metadata       []byte
+}
+
+//NewPaymentReceipt create PaymentReceipt message
+func NewPaymentReceipt(token common.Address, lockSecretHash common.Hash, amount *big.Int, initiator common.Address, blockNumber int64) *PaymentReceipt {
+	m := &PaymentReceipt{
+		TokenAddress:   token,
+		LockSecretHash: lockSecretHash,
+		Amount:         new(big.Int).Set(amount),
+		Initiator:      initiator,
+		BlockNumber:    blockNumber,
+	}
+	m.CmdID = PaymentReceiptCmdID
+	return m
+}
+
+func (m *PaymentReceipt) String() string {
+	return fmt.Sprintf("Message{type=PaymentReceipt token=%s,lockSecretHash=%s,amount=%s,initiator=%s,block=%d,sender=%s}",
+		utils.APex2(m.TokenAddress), utils.HPex(m.LockSecretHash), m.Amount, utils.APex2(m.Initiator), m.BlockNumber, utils.APex2(m.Sender))
+}
+
+//Pack is MessagePacker
+func (m *PaymentReceipt) Pack() []byte {
+	var err error
+	buf := new(bytes.Buffer)
+	err = binary.Write(buf, binary.LittleEndian, m.CmdID)
+	_, err = buf.Write(m.TokenAddress[:])
+	_, err = buf.Write(m.LockSecretHash[:])
+	_, err = buf.Write(utils.BigIntTo32Bytes(m.Amount))
+	_, err = buf.Write(m.Initiator[:])
+	err = binary.Write(buf, binary.BigEndian, m.BlockNumber)
+	if len(m.Metadata) > 0 {
+		err = packExtensionHeader(buf, extensionMetadata)
+		err = packMetadata(buf, m.Metadata)
+	}
+	_, err = buf.Write(m.Signature)
+	if err != nil {
+		log.Crit(fmt.Sprintf("pack PaymentReceipt err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//UnPack is MessageUnPacker
+func (m *PaymentReceipt) UnPack(data []byte) error {
+	var t int32
+	var err error
+	m.CmdID = PaymentReceiptCmdID
+	buf := bytes.NewBuffer(data)
+	err = binary.Read(buf, binary.LittleEndian, &t)
+	if t != m.CmdID {
+		return fmt.Errorf("PaymentReceipt UnPack cmdid expect=%d,got=%d", PaymentReceiptCmdID, t)
+	}
+	_, err = buf.Read(m.TokenAddress[:])
+	_, err = buf.Read(m.LockSecretHash[:])
+	m.Amount = utils.ReadBigInt(buf)
+	_, err = buf.Read(m.Initiator[:])
+	err = binary.Read(buf, binary.BigEndian, &m.BlockNumber)
+	if err != nil || buf.Len() < signatureLength {
+		return errPacketLength
+	}
+	flags, err := unpackExtensionHeader(buf, signatureLength, extensionMetadata)
+	if err != nil {
+		return fmt.Errorf("PaymentReceipt %s", err)
+	}
+	if flags&extensionMetadata != 0 {
+		m.Metadata, err = unpackMetadata(buf)
+		if err != nil {
+			return fmt.Errorf("PaymentReceipt %s", err)
+		}
+	}
+	if buf.Len() != signatureLength {
+		return errPacketLength
+	}
+	m.Signature = make([]byte, signatureLength)
+	_, err = buf.Read(m.Signature)
+	if err != nil {
+		return err
+	}
+	return m.SignedMessage.verifySignature(data)
+}
+
+//VerifyPaymentReceipt decodes a packed PaymentReceipt, its Sender is the target recovered from the signature
+func VerifyPaymentReceipt(data []byte) (*PaymentReceipt, error) {
+	m := new(PaymentReceipt)
+	err := m.UnPack(data)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+/*
+ResyncRequest 收到的 balance proof 的 nonce 比期望的大, 说明对方的消息丢了, 请求对方重发 nonce 大于 Nonce 的消息.
+Nonce 是我们已经收到的对方最后一个 balance proof 的 nonce.
+*/
+/*
+ *	ResyncRequest : a balance proof arrived with a nonce ahead of the expected one, so messages of partner are lost,
+ *	ask partner to retransmit its messages with nonce greater than Nonce.
+ *	Nonce is the nonce of the last balance proof we have received from partner.
+ */
+type ResyncRequest struct {
+	SignedMessage
+	ChannelIDInMessage
+	Nonce uint64
+}
+
+//NewResyncRequest create ResyncRequest message
+func NewResyncRequest(id *ChannelIDInMessage, nonce uint64) *ResyncRequest {
+	m := &ResyncRequest{
+		ChannelIDInMessage: *id,
+		Nonce:              nonce,
+	}
+	m.CmdID = ResyncRequestCmdID
+	return m
+}
+
+func (m *ResyncRequest) String() string {
+	return fmt.Sprintf("Message{type=ResyncRequest Channel=%s-%d,nonce=%d,sender=%s}",
+		utils.HPex(m.ChannelIdentifier), m.OpenBlockNumber, m.Nonce, utils.APex2(m.Sender))
+}
+
+//Pack is MessagePacker
+func (m *ResyncRequest) Pack() []byte {
+	var err error
+	buf := new(bytes.Buffer)
+	err = binary.Write(buf, binary.LittleEndian, m.CmdID)
+	_, err = buf.Write(m.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, m.OpenBlockNumber)
+	err = binary.Write(buf, binary.BigEndian, m.Nonce)
+	_, err = buf.Write(m.Signature)
+	if err != nil {
+		log.Crit(fmt.Sprintf("pack ResyncRequest err %s", err))
+	}
+	return buf.Bytes()
+}
+
+//UnPack is MessageUnPacker
+func (m *ResyncRequest) UnPack(data []byte) error {
+	var t int32
+	var err error
+	m.CmdID = ResyncRequestCmdID
+	buf := bytes.NewBuffer(data)
+	err = binary.Read(buf, binary.LittleEndian, &t)
+	if t != m.CmdID {
+		return fmt.Errorf("ResyncRequest UnPack cmdid expect=%d,got=%d", ResyncRequestCmdID, t)
+	}
+	_, err = buf.Read(m.ChannelIdentifier[:])
+	err = binary.Read(buf, binary.BigEndian, &m.OpenBlockNumber)
+	err = binary.Read(buf, binary.BigEndian, &m.Nonce)
+	if err != nil || buf.Len() != signatureLength {
+		return errPacketLength
+	}
+	m.Signature = make([]byte, signatureLength)
+	_, err = buf.Read(m.Signature)
+	if err != nil {
+		return err
+	}
+	return m.SignedMessage.verifySignature(data)
+}
+
 //MessageMap contains all message can send and receive.
 //DirectTransfer has been deprecated
 var MessageMap = map[int]Messager{
@@ -1783,6 +2421,9 @@ var MessageMap = map[int]Messager{
 	WithdrawResponseCmdID:                 new(WithdrawResponse),
 	SettleRequestCmdID:                    new(SettleRequest),
 	SettleResponseCmdID:                   new(SettleResponse),
+	MonitorDelegateCmdID:                  new(MonitorDelegate),
+	PaymentReceiptCmdID:                   new(PaymentReceipt),
+	ResyncRequestCmdID:                    new(ResyncRequest),
 }
 
 func init() {
@@ -1801,4 +2442,7 @@ func init() {
 	gob.Register(&WithdrawResponse{})
 	gob.Register(&SettleRequest{})
 	gob.Register(&SettleResponse{})
+	gob.Register(&MonitorDelegate{})
+	gob.Register(&PaymentReceipt{})
+	gob.Register(&ResyncRequest{})
 }
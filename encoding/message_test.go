@@ -17,6 +17,8 @@ import (
 
 	"fmt"
 
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/davecgh/go-spew/spew"
@@ -189,6 +191,147 @@ func TestMediatedTransfer(t *testing.T) {
 	}
 }
 
+func TestMediatedTransferMetadata(t *testing.T) {
+	bp := &BalanceProof{
+		Nonce:             11,
+		ChannelIdentifier: utils.Sha3([]byte("123")),
+		TransferAmount:    big.NewInt(12),
+		OpenBlockNumber:   3,
+		Locksroot:         utils.EmptyHash,
+	}
+	lock := &mtree.Lock{
+		Amount:         big.NewInt(34),
+		Expiration:     4589895,
+		LockSecretHash: utils.ShaSecret([]byte("hashlock")),
+		HashAlgorithm:  utils.HashAlgorithmKeccak256,
+	}
+	initiatorKey, _ := crypto.GenerateKey()
+	initiator := crypto.PubkeyToAddress(initiatorKey.PublicKey)
+	target := utils.NewRandomAddress()
+	noMetadata := NewMediatedTransfer(bp, lock, target, initiator, big.NewInt(33))
+	noMetadata.Sign(GetTestPrivKey(), noMetadata)
+
+	m1 := NewMediatedTransfer(bp, lock, target, initiator, big.NewInt(33))
+	m1.Metadata = []byte("order-20181016-001")
+	assert.Nil(t, m1.SignMetadata(initiatorKey))
+	//the mediator signs the envelope
+	m1.Sign(GetTestPrivKey(), m1)
+	data := m1.Pack()
+	//extension header is already there for the hash algorithm, so only length, metadata and signature
+	assert.Equal(t, len(noMetadata.Pack())+1+len(m1.Metadata)+signatureLength, len(data))
+	m2 := new(MediatedTransfer)
+	if !assert.Nil(t, m2.UnPack(data)) {
+		return
+	}
+	assert.EqualValues(t, m1, m2)
+	assert.Equal(t, utils.HashAlgorithmKeccak256, m2.HashAlgorithm)
+
+	//a mediator forwarding changed metadata can't sign it for initiator
+	m3 := NewMediatedTransfer(bp, lock, target, initiator, big.NewInt(33))
+	m3.Metadata = []byte("order-20181016-002")
+	m3.MetadataSignature = m1.MetadataSignature
+	m3.Sign(GetTestPrivKey(), m3)
+	assert.NotNil(t, new(MediatedTransfer).UnPack(m3.Pack()))
+	m3 = NewMediatedTransfer(bp, lock, target, initiator, big.NewInt(33))
+	m3.Metadata = m1.Metadata
+	assert.Nil(t, m3.SignMetadata(GetTestPrivKey()))
+	m3.Sign(GetTestPrivKey(), m3)
+	assert.NotNil(t, new(MediatedTransfer).UnPack(m3.Pack()))
+
+	m4 := NewMediatedTransfer(bp, lock, target, initiator, big.NewInt(33))
+	m4.Metadata = make([]byte, 257)
+	assert.NotNil(t, m4.SignMetadata(initiatorKey))
+}
+
+func TestExtensionHeader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.Nil(t, packExtensionHeader(buf, 0))
+	assert.Equal(t, 0, buf.Len())
+	flags, err := unpackExtensionHeader(bytes.NewBuffer([]byte{1, 2}), 2, extensionMetadata)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, flags)
+	assert.Nil(t, packExtensionHeader(buf, extensionMetadata))
+	flags, err = unpackExtensionHeader(bytes.NewBuffer(buf.Bytes()), 0, extensionMetadata)
+	assert.Nil(t, err)
+	assert.Equal(t, extensionMetadata, flags)
+	//unknown version, unknown flag, no flag
+	_, err = unpackExtensionHeader(bytes.NewBuffer([]byte{extensionVersion + 1, extensionMetadata}), 0, extensionMetadata)
+	assert.NotNil(t, err)
+	_, err = unpackExtensionHeader(bytes.NewBuffer([]byte{extensionVersion, extensionMetadata << 1}), 0, extensionMetadata)
+	assert.NotNil(t, err)
+	_, err = unpackExtensionHeader(bytes.NewBuffer([]byte{extensionVersion, 0}), 0, extensionMetadata)
+	assert.NotNil(t, err)
+	//metadata length must be minimally encoded
+	_, err = unpackMetadata(bytes.NewBuffer([]byte{0x81, 0x00, 1}))
+	assert.NotNil(t, err)
+	md, err := unpackMetadata(bytes.NewBuffer([]byte{1, 7}))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{7}, md)
+}
+
+func TestMessagesWithHashAlgorithm(t *testing.T) {
+	bp := &BalanceProof{
+		Nonce:             11,
+		ChannelIdentifier: utils.Sha3([]byte("123")),
+		TransferAmount:    big.NewInt(12),
+		OpenBlockNumber:   3,
+		Locksroot:         utils.EmptyHash,
+	}
+	secret := utils.NewRandomHash()
+	lock := &mtree.Lock{
+		Amount:         big.NewInt(34),
+		Expiration:     4589895,
+		LockSecretHash: utils.HashAlgorithmSHA256.HashSecret(secret[:]),
+	}
+	// sha256 is implicit, message is the same as before
+	m1 := NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(33))
+	m1.Sign(GetTestPrivKey(), m1)
+	sha256Len := len(m1.Pack())
+	lock.HashAlgorithm = utils.HashAlgorithmKeccak256
+	lock.LockSecretHash = utils.HashAlgorithmKeccak256.HashSecret(secret[:])
+	m1 = NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(33))
+	m1.Sign(GetTestPrivKey(), m1)
+	data := m1.Pack()
+	//version, flags and algorithm
+	assert.Equal(t, sha256Len+3, len(data))
+	m2 := new(MediatedTransfer)
+	assert.Nil(t, m2.UnPack(data))
+	assert.Equal(t, utils.HashAlgorithmKeccak256, m2.HashAlgorithm)
+	assert.Equal(t, m1.Sender, m2.Sender)
+	assert.True(t, m2.GetLock().MatchSecret(secret))
+	// sha256 must never be written explicitly, and unknown algorithm is rejected
+	data[len(data)-envelopLength-1] = byte(utils.HashAlgorithmSHA256)
+	assert.NotNil(t, new(MediatedTransfer).UnPack(data), "sha256 must be implicit")
+	data[len(data)-envelopLength-1] = 7
+	assert.NotNil(t, new(MediatedTransfer).UnPack(data), "unknown algorithm")
+	data[len(data)-envelopLength-1] = byte(utils.HashAlgorithmKeccak256)
+	data[len(data)-envelopLength-2] = 0
+	assert.NotNil(t, new(MediatedTransfer).UnPack(data), "algorithm without flag")
+
+	rs1 := NewRevealSecret(secret)
+	rs1.HashAlgorithm = utils.HashAlgorithmKeccak256
+	rs1.Data = []byte("data")
+	rs1.Sign(GetTestPrivKey(), rs1)
+	rs2 := new(RevealSecret)
+	assert.Nil(t, rs2.UnPack(rs1.Pack()))
+	assert.Equal(t, lock.LockSecretHash, rs2.LockSecretHash())
+	assert.Equal(t, rs1.Sender, rs2.Sender)
+	// the same secret revealed with default algorithm is for another lock
+	rs3 := NewRevealSecret(secret)
+	rs3.Sign(GetTestPrivKey(), rs3)
+	rs4 := new(RevealSecret)
+	assert.Nil(t, rs4.UnPack(rs3.Pack()))
+	assert.NotEqual(t, lock.LockSecretHash, rs4.LockSecretHash())
+
+	u1 := NewUnlock(bp, secret)
+	u1.HashAlgorithm = utils.HashAlgorithmKeccak256
+	u1.Sign(GetTestPrivKey(), u1)
+	u2 := new(UnLock)
+	assert.Nil(t, u2.UnPack(u1.Pack()))
+	assert.Equal(t, utils.HashAlgorithmKeccak256, u2.HashAlgorithm)
+	assert.Equal(t, lock.LockSecretHash, u2.LockSecretHash())
+}
+
 func TestNewAnnounceDisposedTransfer(t *testing.T) {
 	bp := &AnnounceDisposedProof{
 		ChannelIDInMessage: ChannelIDInMessage{
@@ -259,6 +402,43 @@ func TestNewRevealSecret(t *testing.T) {
 	}
 }
 
+func TestTransferDelta(t *testing.T) {
+	channelID := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	older := NewBalanceProof(1, big.NewInt(10), utils.EmptyHash, channelID)
+	newer := NewBalanceProof(2, big.NewInt(25), utils.NewRandomHash(), channelID)
+	delta, err := TransferDelta(older, newer)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(15), delta)
+	// nonce must increase
+	_, err = TransferDelta(newer, older)
+	assert.NotNil(t, err)
+	// transfer amount never decreases
+	_, err = TransferDelta(older, NewBalanceProof(2, big.NewInt(5), utils.EmptyHash, channelID))
+	assert.NotNil(t, err)
+	// same channel only
+	_, err = TransferDelta(older, NewBalanceProof(2, big.NewInt(25), utils.EmptyHash, &contracts.ChannelUniqueID{ChannelIdentifier: channelID.ChannelIdentifier, OpenBlockNumber: 4}))
+	assert.NotNil(t, err)
+}
+
+func TestRecoverBalanceProofSigner(t *testing.T) {
+	channelID := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	m := NewUnlock(NewBalanceProof(2, big.NewInt(25), utils.NewRandomHash(), channelID), utils.NewRandomHash())
+	assert.Nil(t, m.Sign(GetTestPrivKey(), m))
+	data := m.Pack()
+	signer, err := RecoverEnvelopMessageSigner(m, data, params.ChainID)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Sender, signer)
+	// the same as what's kept in channel state
+	signer, err = RecoverBalanceProofSigner(&m.BalanceProof, HashMessageWithoutSignature(m), m.Signature, params.ChainID)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Sender, signer)
+	// signed for another chain
+	signer, _ = RecoverEnvelopMessageSigner(m, data, new(big.Int).Add(params.ChainID, big.NewInt(1)))
+	assert.NotEqual(t, m.Sender, signer)
+	_, err = RecoverEnvelopMessageSigner(m, data[:signatureLength], params.ChainID)
+	assert.NotNil(t, err)
+}
+
 func TestNewSecretRequest(t *testing.T) {
 	s1 := NewSecretRequest(utils.ShaSecret([]byte("xxx")), big.NewInt(506))
 	s1.Sign(GetTestPrivKey(), s1)
@@ -466,3 +646,109 @@ func TestBigInt(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+func TestNewMonitorDelegate(t *testing.T) {
+	id := &ChannelIDInMessage{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	}
+	s1 := NewMonitorDelegate(id, utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(20), []byte("{\"x\":1}"))
+	s1.Sign(GetTestPrivKey(), s1)
+	data := s1.Pack()
+	s2 := new(MonitorDelegate)
+	err := s2.UnPack(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, s1, s2)
+	//tampered data
+	data[len(data)-signatureLength-1] = '2'
+	s3 := new(MonitorDelegate)
+	err = s3.UnPack(data)
+	assert.True(t, err != nil || s3.Sender != s1.Sender)
+}
+
+func TestNewPaymentReceipt(t *testing.T) {
+	s1 := NewPaymentReceipt(utils.NewRandomAddress(), utils.NewRandomHash(), big.NewInt(30), utils.NewRandomAddress(), 1234)
+	s1.Sign(GetTestPrivKey(), s1)
+	data := s1.Pack()
+	s2, err := VerifyPaymentReceipt(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, s1, s2)
+	assert.Equal(t, crypto.PubkeyToAddress(GetTestPrivKey().PublicKey), s2.Sender)
+	//tampered amount
+	data[20+32+4+31] = 31
+	s3, err := VerifyPaymentReceipt(data)
+	assert.True(t, err != nil || s3.Sender != s1.Sender)
+	_, err = VerifyPaymentReceipt(data[:len(data)-1])
+	assert.NotNil(t, err)
+
+	s1 = NewPaymentReceipt(utils.NewRandomAddress(), utils.NewRandomHash(), big.NewInt(30), utils.NewRandomAddress(), 1234)
+	s1.Metadata = []byte("order-20181016-001")
+	s1.Sign(GetTestPrivKey(), s1)
+	data = s1.Pack()
+	s2, err = VerifyPaymentReceipt(data)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, s1, s2)
+	}
+	//tampered metadata
+	data[len(data)-signatureLength-1] ^= 1
+	s3, err = VerifyPaymentReceipt(data)
+	assert.True(t, err != nil || s3.Sender != s1.Sender)
+	_, err = VerifyPaymentReceipt(append(data[:len(data)-signatureLength-1:len(data)-signatureLength-1], data[len(data)-signatureLength:]...))
+	assert.NotNil(t, err)
+}
+
+func TestNewResyncRequest(t *testing.T) {
+	id := &ChannelIDInMessage{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	}
+	s1 := NewResyncRequest(id, 7)
+	s1.Sign(GetTestPrivKey(), s1)
+	data := s1.Pack()
+	s2 := new(ResyncRequest)
+	err := s2.UnPack(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, s1, s2)
+	//tampered nonce
+	data[4+32+8+7] = 8
+	s3 := new(ResyncRequest)
+	err = s3.UnPack(data)
+	assert.True(t, err != nil || s3.Sender != s1.Sender)
+	assert.NotNil(t, new(ResyncRequest).UnPack(data[:len(data)-1]))
+}
+
+func TestAckCapabilities(t *testing.T) {
+	a1 := NewAck(utils.NewRandomAddress(), utils.NewRandomHash())
+	data := a1.Pack()
+	a2 := new(Ack)
+	err := a2.UnPack(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, a1, a2)
+	assert.Equal(t, LocalCapabilities, a2.Capabilities)
+	//ack of old nodes
+	a3 := new(Ack)
+	err = a3.UnPack(data[:4+20+32])
+	if assert.Nil(t, err) {
+		assert.Equal(t, a1.Echo, a3.Echo)
+		assert.EqualValues(t, 0, a3.Capabilities)
+	}
+	//unknown extension version
+	a4 := new(Ack)
+	err = a4.UnPack(append(data[:4+20+32:4+20+32], ackExtensionVersion+1, 1, 2, 3, 4))
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, 0, a4.Capabilities)
+	}
+	assert.NotNil(t, new(Ack).UnPack(data[:len(data)-1]))
+}
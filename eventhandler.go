@@ -11,6 +11,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/notify"
 	"github.com/SmartMeshFoundation/Photon/transfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
@@ -32,11 +33,16 @@ func newStateMachineEventHandler(photon *Service) *stateMachineEventHandler {
 }
 
 /*
-dispatch it to all state managers and log generated events
+dispatch new block only to state managers whose locks need handling at this block,
+others will catch up the block number when they receive other state changes.
 */
-func (eh *stateMachineEventHandler) dispatchToAllTasks(st transfer.StateChange) {
-	for _, mgrs := range eh.photon.Transfer2StateManager {
-		eh.dispatch(mgrs, st)
+func (eh *stateMachineEventHandler) dispatchToExpiredTasks(st *transfer.BlockStateChange) {
+	q := eh.photon.expirationQueue
+	for _, mgr := range q.popDue(st.BlockNumber) {
+		//may be removed by events of state managers before it
+		if q.isScheduled(mgr) {
+			eh.dispatch(mgr, st)
+		}
 	}
 }
 
@@ -59,8 +65,28 @@ func (eh *stateMachineEventHandler) dispatchByPendingLocksInChannel(channel *cha
 }
 
 func (eh *stateMachineEventHandler) dispatch(stateManager *transfer.StateManager, stateChange transfer.StateChange) (events []transfer.Event) {
+	q := eh.photon.expirationQueue
+	blockNumber := eh.photon.GetBlockNumber()
+	st, isBlock := stateChange.(*transfer.BlockStateChange)
+	if isBlock {
+		blockNumber = st.BlockNumber
+	} else if q.isScheduled(stateManager) && q.knownBlock(stateManager) < blockNumber {
+		//state manager is not woken up by every block, let it know the latest block first
+		eh.dispatch(stateManager, &transfer.BlockStateChange{BlockNumber: blockNumber})
+		if !q.isScheduled(stateManager) {
+			//removed when handling the block
+			return
+		}
+	}
 	eh.updateStateManagerFromStateChange(stateManager, stateChange)
 	events = stateManager.Dispatch(stateChange)
+	wakeup := nextWakeup(stateManager, blockNumber)
+	if !isBlock && wakeup > blockNumber+1 {
+		//conditions changed by this state change, such as channel closed, are checked on next block
+		wakeup = blockNumber + 1
+	}
+	q.schedule(stateManager, wakeup)
+	q.setKnownBlock(stateManager, blockNumber)
 	for _, e := range events {
 		err := eh.OnEvent(e, stateManager)
 		if err != nil {
@@ -103,12 +129,13 @@ func (eh *stateMachineEventHandler) eventSendRevealSecret(event *mediatedtransfe
 	eh.photon.registerSecret(event.Secret)
 
 	revealMessage := encoding.NewRevealSecret(event.Secret)
+	revealMessage.HashAlgorithm, _ = utils.HashAlgorithmOf(event.Secret, event.LockSecretHash)
 	// 带上交易附加信息
 	revealMessage.Data = []byte(event.Data)
 	err = revealMessage.Sign(eh.photon.PrivateKey, revealMessage)
 	err = eh.photon.sendAsync(event.Receiver, revealMessage) //单独处理 reaveal secret
 	if err == nil {
-		eh.photon.dao.UpdateTransferStatus(event.Token, revealMessage.LockSecretHash(), models.TransferStatusCanNotCancel, fmt.Sprintf("RevealSecret 正在发送 target=%s", utils.APex2(event.Receiver)))
+		eh.photon.updateTransferStatus(event.Token, revealMessage.LockSecretHash(), models.TransferStatusCanNotCancel, fmt.Sprintf("RevealSecret 正在发送 target=%s", utils.APex2(event.Receiver)))
 	}
 	return err
 }
@@ -147,12 +174,25 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 	if err != nil {
 		return
 	}
+	mtr.HashAlgorithm = event.HashAlgorithm
+	mtr.Metadata = event.Metadata
+	if event.Initiator == eh.photon.NodeAddress {
+		err = mtr.SignMetadata(eh.photon.PrivateKey)
+		if err != nil {
+			return
+		}
+	} else {
+		mtr.MetadataSignature = event.MetadataSignature
+	}
 	//log.Trace(fmt.Sprintf("mtr=%s", utils.StringInterface(mtr, 5)))
 	err = mtr.Sign(eh.photon.PrivateKey, mtr)
 	err = ch.RegisterTransfer(eh.photon.GetBlockNumber(), mtr)
 	if err != nil {
 		return
 	}
+	if stateManager.Name == initiator.NameInitiatorTransition {
+		eh.photon.keepLockedTransferProof(event.Token, mtr, eh.photon.NodeAddress, receiver)
+	}
 	eh.photon.conditionQuit("EventSendMediatedTransferBefore")
 	if stateManager.LastReceivedMessage == nil {
 		if stateManager.Name != initiator.NameInitiatorTransition {
@@ -191,7 +231,10 @@ func (eh *stateMachineEventHandler) eventSendMediatedTransfer(event *mediatedtra
 	}
 	err = eh.photon.sendAsync(receiver, mtr)
 	if err == nil {
-		eh.photon.dao.UpdateTransferStatus(ch.TokenAddress, mtr.LockSecretHash, models.TransferStatusCanCancel, fmt.Sprintf("MediatedTransfer 正在发送 target=%s", utils.APex2(receiver)))
+		eh.photon.updateTransferStatus(ch.TokenAddress, mtr.LockSecretHash, models.TransferStatusCanCancel, fmt.Sprintf("MediatedTransfer 正在发送 target=%s", utils.APex2(receiver)))
+		if stateManager.Name == initiator.NameInitiatorTransition {
+			eh.photon.waitMediatedTransferAck(event.Token, mtr.LockSecretHash)
+		}
 	}
 	return
 }
@@ -210,11 +253,15 @@ func (eh *stateMachineEventHandler) eventSendUnlock(event *mediatedtransfer.Even
 	if err != nil {
 		return
 	}
+	before, merkleProof := eh.photon.paymentProofBeforeUnlock(event.Token, event.LockSecretHash, ch.OurState)
 	err = tr.Sign(eh.photon.PrivateKey, tr)
 	err = ch.RegisterTransfer(eh.photon.GetBlockNumber(), tr)
 	if err != nil {
 		return
 	}
+	if stateManager != nil && stateManager.Name == initiator.NameInitiatorTransition {
+		eh.photon.keepUnlockProof(event.Token, tr, before, merkleProof)
+	}
 	eh.photon.conditionQuit("EventSendUnlockBefore")
 	err = eh.photon.dao.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	err = eh.photon.sendAsync(receiver, tr)
@@ -291,23 +338,8 @@ func (eh *stateMachineEventHandler) eventSendAnnouncedDisposedResponse(event *me
 	return
 }
 func (eh *stateMachineEventHandler) eventContractSendRegisterSecret(event *mediatedtransfer.EventContractSendRegisterSecret) (err error) {
-	b, err := eh.photon.Chain.SecretRegistryProxy.IsSecretRegistered(event.Secret)
-	if err != nil {
-		return err
-	}
-	if b {
-		log.Info(fmt.Sprintf("Secret %s already registered", utils.HPex(event.Secret)))
-		return
-	}
-	result := eh.photon.Chain.SecretRegistryProxy.RegisterSecretAsync(event.Secret)
-	go func() {
-		var err error
-		err = <-result.Result
-		if err != nil {
-			log.Error(fmt.Sprintf("register secret on chain err %s,secret=%s you may lose your token because of this error",
-				err, event.Secret.String()))
-		}
-	}()
+	//secrets due at the same block are registered together, see Service.registerSecrets
+	eh.photon.secretRegistrar.add(event.Secret, event.LockExpiration, event.RevealTimeout)
 	return nil
 }
 func (eh *stateMachineEventHandler) eventWithdrawFailed(e2 *mediatedtransfer.EventWithdrawFailed, manager *transfer.StateManager) (err error) {
@@ -338,7 +370,7 @@ func (eh *stateMachineEventHandler) eventContractSendUnlock(e2 *mediatedtransfer
 		))
 		return
 	}
-	result := ch.ExternState.Unlock([]*channeltype.UnlockProof{p}, ch.PartnerState.BalanceProofState.ContractTransferAmount)
+	result := ch.ExternState.Unlock([]*channeltype.UnlockProof{p}, ch.PartnerState.BalanceProofState.ContractTransferAmount, ch.PartnerState.BalanceProofState.ContractLocksRoot)
 	go func() {
 		err := <-result.Result
 		if err != nil {
@@ -378,7 +410,7 @@ func (eh *stateMachineEventHandler) eventUnlockFailed(e2 *mediatedtransfer.Event
 	eh.photon.conditionQuit("EventRemoveExpiredHashlockTransferBefore")
 	err = eh.photon.dao.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
 	err = eh.photon.sendAsync(ch.PartnerState.Address, tr)
-	eh.photon.dao.UpdateTransferStatus(ch.TokenAddress, e2.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("交易超时失败 err=%s", e2.Reason))
+	eh.photon.updateTransferStatus(ch.TokenAddress, e2.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("交易超时失败 err=%s", e2.Reason))
 	return
 }
 
@@ -399,6 +431,7 @@ func (eh *stateMachineEventHandler) eventSaveFeeChargeRecord(e *mediatedtransfer
 
 func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *transfer.StateManager) (err error) {
 	var ch *channel.Channel
+	eh.updateTransferRecordByEvent(event, stateManager)
 	switch e2 := event.(type) {
 	case *mediatedtransfer.EventSendMediatedTransfer:
 		err = eh.eventSendMediatedTransfer(e2, stateManager)
@@ -433,7 +466,7 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		eh.photon.NotifyHandler.NotifySentTransfer(st)
 		eh.finishOneTransfer(event)
 	case *transfer.EventTransferSentFailed:
-		eh.photon.dao.UpdateTransferStatus(e2.Token, e2.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("交易失败 err=%s", e2.Reason))
+		eh.photon.updateTransferStatus(e2.Token, e2.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("交易失败 err=%s", e2.Reason))
 		eh.finishOneTransfer(event)
 	case *transfer.EventTransferReceivedSuccess:
 		ch, err = eh.photon.findChannelByIdentifier(e2.ChannelIdentifier)
@@ -447,6 +480,7 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		}
 		rt := eh.photon.dao.NewReceivedTransfer(eh.photon.GetBlockNumber(), e2.ChannelIdentifier, ch.ChannelIdentifier.OpenBlockNumber, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount, e2.LockSecretHash, e2.Data)
 		eh.photon.NotifyHandler.NotifyReceiveTransfer(rt)
+		eh.photon.sendPaymentReceipt(ch.TokenAddress, e2)
 	case *mediatedtransfer.EventUnlockSuccess:
 	case *mediatedtransfer.EventWithdrawFailed:
 		log.Error(fmt.Sprintf("EventWithdrawFailed hashlock=%s,reason=%s", utils.HPex(e2.LockSecretHash), e2.Reason))
@@ -465,7 +499,12 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 		eh.photon.conditionQuit("EventSendRemoveExpiredHashlockTransferAfter")
 	case *mediatedtransfer.EventContractSendRegisterSecret:
 		err = eh.eventContractSendRegisterSecret(e2)
+	case *mediatedtransfer.EventRelockTransfer:
+		eh.photon.relockTransfer(e2)
 	case *mediatedtransfer.EventRemoveStateManager:
+		if mgr := eh.photon.Transfer2StateManager[e2.Key]; mgr != nil {
+			eh.photon.expirationQueue.remove(mgr)
+		}
 		delete(eh.photon.Transfer2StateManager, e2.Key)
 	case *mediatedtransfer.EventSaveFeeChargeRecord:
 		err = eh.eventSaveFeeChargeRecord(e2)
@@ -497,6 +536,7 @@ func (eh *stateMachineEventHandler) finishOneTransfer(ev transfer.Event) {
 	}
 	if lockSecretHash != utils.EmptyHash {
 		smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
+		eh.photon.relocks.take(smkey)
 		r := eh.photon.Transfer2Result[smkey]
 		if r == nil { //restart after crash?
 			log.Error(fmt.Sprintf("transfer finished ,but have no relate results :%s", utils.StringInterface(ev, 2)))
@@ -558,6 +598,9 @@ func (eh *stateMachineEventHandler) handleChannelNew(st *mediatedtransfer.Contra
 			return nil
 		}
 		eh.photon.registerChannel(tokenAddress, partner, st.ChannelIdentifier, st.SettleTimeout)
+		if c = g.GetPartenerAddress2Channel(partner); c != nil {
+			eh.photon.publishChannelEvent(notify.EventChannelOpened, c, st.BlockNumber)
+		}
 		other := participant2
 		if other == eh.photon.NodeAddress {
 			other = participant1
@@ -580,7 +623,9 @@ func (eh *stateMachineEventHandler) handleBalance(st *mediatedtransfer.ContractB
 	if err != nil {
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 	}
+	eh.photon.publishChannelEvent(notify.EventChannelDeposit, ch, st.BlockNumber)
 	err = eh.photon.dao.UpdateChannelContractBalance(channel.NewChannelSerialization(ch))
+	eh.photon.autoTopUpDeposited(st)
 	return nil
 }
 
@@ -590,6 +635,7 @@ func (eh *stateMachineEventHandler) handleClosed(st *mediatedtransfer.ContractCl
 	ch, err := eh.photon.findChannelByIdentifier(channelIdentifier)
 	if err != nil {
 		//i'm not a participant
+		eh.photon.monitorChannelClosed(st)
 		// 如果不是自己参与的channel,移除路由中的path
 		token, p1, p2, err2 := eh.photon.dao.GetNonParticipantChannelByID(st.ChannelIdentifier)
 		if err2 != nil {
@@ -617,6 +663,17 @@ func (eh *stateMachineEventHandler) handleClosed(st *mediatedtransfer.ContractCl
 	if err != nil {
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 	}
+	eh.photon.publishChannelEvent(notify.EventChannelClosed, ch, st.ClosedBlock)
+	//we have submitted partner's balance proof, watch whether it's replaced
+	if st.ClosingAddress != eh.photon.NodeAddress && ch.PartnerState.BalanceProofState.Nonce > 0 {
+		eh.photon.updateWatcher.watch(&balanceProofWatch{
+			ChannelIdentifier: channelIdentifier,
+			TokenAddress:      ch.TokenAddress,
+			Participant:       ch.PartnerState.Address,
+			Partner:           ch.OurState.Address,
+			Nonce:             ch.PartnerState.BalanceProofState.Nonce,
+		})
+	}
 	err = eh.photon.dao.UpdateChannelState(channel.NewChannelSerialization(ch))
 	return err
 }
@@ -637,6 +694,7 @@ func (eh *stateMachineEventHandler) handleClosed(st *mediatedtransfer.ContractCl
  *	4. channel reference by statemanager
  */
 func (eh *stateMachineEventHandler) removeSettledChannel(ch *channel.Channel) error {
+	eh.photon.updateWatcher.unwatch(ch.ChannelIdentifier.ChannelIdentifier)
 	g := eh.photon.getChannelGraph(ch.ChannelIdentifier.ChannelIdentifier)
 	g.RemoveChannel(ch)
 	cs := channel.NewChannelSerialization(ch)
@@ -662,6 +720,7 @@ func (eh *stateMachineEventHandler) handleSettled(st *mediatedtransfer.ContractS
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 		return err
 	}
+	eh.photon.publishChannelEvent(notify.EventChannelSettled, ch, st.SettledBlock)
 	return eh.removeSettledChannel(ch)
 }
 
@@ -695,6 +754,7 @@ func (eh *stateMachineEventHandler) handleCooperativeSettled(st *mediatedtransfe
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 		return err
 	}
+	eh.photon.publishChannelEvent(notify.EventChannelSettled, ch, st.SettledBlock)
 	err = eh.removeSettledChannel(ch)
 	//if true {
 	//	g := eh.photon.getChannelGraph(ch.ChannelIdentifier.ChannelIdentifier)
@@ -743,6 +803,7 @@ func (eh *stateMachineEventHandler) handleUnlockOnChain(st *mediatedtransfer.Con
 	log.Trace(fmt.Sprintf("%s unlock event handle", utils.HPex(st.ChannelIdentifier)))
 	ch, err := eh.photon.findChannelByIdentifier(st.ChannelIdentifier)
 	if err != nil {
+		eh.photon.monitorUnlockOnChain(st)
 		return nil
 	}
 	err = eh.ChannelStateTransition(ch, st)
@@ -796,6 +857,8 @@ func (eh *stateMachineEventHandler) handleBalanceProofOnChain(st *mediatedtransf
 		return nil
 	}
 	err = eh.ChannelStateTransition(ch, st)
+	//query nonce on chain outside of main loop
+	go eh.photon.updateWatcher.handleBalanceProofUpdated(st)
 	err = eh.photon.dao.UpdateChannelState(channel.NewChannelSerialization(ch))
 	return err
 }
@@ -808,6 +871,11 @@ func (eh *stateMachineEventHandler) handleSecretRegisteredOnChain(st *mediatedtr
 	// 这里需要注册密码,否则unlock消息无法正常发送
 	// we need register secret here, otherwise we can not send unlock.
 	eh.photon.registerRevealedLockSecretHash(st.LockSecretHash, st.Secret, st.BlockNumber)
+	eh.photon.publishEvent(notify.EventSecretRevealed, utils.EmptyAddress, utils.EmptyHash, &secretRevealedEvent{
+		LockSecretHash: st.LockSecretHash,
+		Secret:         st.Secret,
+		BlockNumber:    st.BlockNumber,
+	})
 	//需要 disatch 给相关的 statemanager, 让他们处理未完成的交易.
 	// we need dispatch it to relevant statemanager, and let them handle incomplete transfers.
 	eh.dispatchBySecretHash(st.LockSecretHash, st)
@@ -815,7 +883,9 @@ func (eh *stateMachineEventHandler) handleSecretRegisteredOnChain(st *mediatedtr
 }
 
 func (eh *stateMachineEventHandler) handleBlockStateChange(st *transfer.BlockStateChange) error {
-	eh.dispatchToAllTasks(st)
+	eh.dispatchToExpiredTasks(st)
+	eh.photon.monitorNewBlock(st.BlockNumber)
+	eh.photon.autoTopUpNewBlock(st.BlockNumber)
 	//for _, cg := range eh.photon.Token2ChannelGraph {
 	//	for _, c := range cg.ChannelIdentifier2Channel {
 	//		err := eh.ChannelStateTransition(c, st)
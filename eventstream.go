@@ -0,0 +1,90 @@
+package photon
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//channelEvent data of channel events in event stream, what the channel is after the event
+type channelEvent struct {
+	ChannelIdentifier   common.Hash       `json:"channel_identifier"`
+	OpenBlockNumber     int64             `json:"open_block_number"`
+	PartnerAddress      common.Address    `json:"partner_address"`
+	TokenAddress        common.Address    `json:"token_address"`
+	Balance             *big.Int          `json:"balance"`
+	PartnerBalance      *big.Int          `json:"partner_balance"`
+	LockedAmount        *big.Int          `json:"locked_amount"`
+	PartnerLockedAmount *big.Int          `json:"partner_locked_amount"`
+	State               channeltype.State `json:"state"`
+	StateString         string            `json:"state_string"`
+	SettleTimeout       int               `json:"settle_timeout"`
+	BlockNumber         int64             `json:"block_number"` //block of the event on chain
+}
+
+//secretRevealedEvent data of EventSecretRevealed
+type secretRevealedEvent struct {
+	LockSecretHash common.Hash `json:"lock_secret_hash"`
+	Secret         common.Hash `json:"secret"`
+	BlockNumber    int64       `json:"block_number"`
+}
+
+//chainConnectionEvent data of EventChainConnection
+type chainConnectionEvent struct {
+	Connected   bool  `json:"connected"`
+	BlockNumber int64 `json:"block_number"` //latest block we know
+}
+
+//publishEvent publish to event stream of NotifyHandler, if there is one
+func (rs *Service) publishEvent(typ notify.EventType, tokenAddress common.Address, channelIdentifier common.Hash, data interface{}) {
+	if rs.NotifyHandler == nil {
+		return
+	}
+	rs.NotifyHandler.Events.Publish(typ, tokenAddress, channelIdentifier, data)
+}
+
+func (rs *Service) publishChannelEvent(typ notify.EventType, ch *channel.Channel, blockNumber int64) {
+	rs.publishEvent(typ, ch.TokenAddress, ch.ChannelIdentifier.ChannelIdentifier, &channelEvent{
+		ChannelIdentifier:   ch.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:     ch.ChannelIdentifier.OpenBlockNumber,
+		PartnerAddress:      ch.PartnerState.Address,
+		TokenAddress:        ch.TokenAddress,
+		Balance:             ch.Balance(),
+		PartnerBalance:      ch.PartnerBalance(),
+		LockedAmount:        ch.Locked(),
+		PartnerLockedAmount: ch.Outstanding(),
+		State:               ch.State,
+		StateString:         ch.State.String(),
+		SettleTimeout:       ch.SettleTimeout,
+		BlockNumber:         blockNumber,
+	})
+}
+
+//publishTransferEvent initiated when a transfer we initiate is created, succeeded or failed when it's finished
+func (rs *Service) publishTransferEvent(r *models.TransferRecord) {
+	var typ notify.EventType
+	switch {
+	case r.Phase == models.TransferPhaseSuccess:
+		typ = notify.EventTransferSucceeded
+	case r.Phase == models.TransferPhaseFailed:
+		typ = notify.EventTransferFailed
+	case r.Role == models.TransferRoleInitiator:
+		typ = notify.EventTransferInitiated
+	default:
+		return
+	}
+	rs.publishEvent(typ, r.TokenAddress, utils.EmptyHash, r)
+}
+
+func (rs *Service) publishChainConnectionEvent(s netshare.Status) {
+	rs.publishEvent(notify.EventChainConnection, utils.EmptyAddress, utils.EmptyHash, &chainConnectionEvent{
+		Connected:   s == netshare.Connected,
+		BlockNumber: rs.GetBlockNumber(),
+	})
+}
@@ -0,0 +1,58 @@
+package photon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishTransferEvents(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:           dao,
+		NotifyHandler: notify.NewNotifyHandler(),
+	}
+	sub := rs.NotifyHandler.Events.Subscribe(nil, 0)
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Phase:          models.TransferPhaseRouting,
+	})
+	e := <-sub.Events
+	assert.Equal(t, notify.EventTransferInitiated, e.Type)
+	assert.Equal(t, token, *e.TokenAddress)
+	r := &models.TransferRecord{}
+	assert.Nil(t, json.Unmarshal(e.Data, r))
+	assert.Equal(t, lockSecretHash, r.LockSecretHash)
+	// phases in between are not published
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseWaitingSecretRequest)
+	assert.Len(t, sub.Events, 0)
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseSuccess)
+	assert.Equal(t, notify.EventTransferSucceeded, (<-sub.Events).Type)
+
+	// we mediate a transfer
+	lockSecretHash = utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleMediator,
+		Phase:          models.TransferPhaseWaitingReveal,
+	})
+	assert.Len(t, sub.Events, 0)
+	rs.failTransferRecord(token, lockSecretHash, models.TransferFailureLockExpired, "expired")
+	e = <-sub.Events
+	assert.Equal(t, notify.EventTransferFailed, e.Type)
+	assert.EqualValues(t, 3, e.Sequence)
+	// finished records never change
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseSuccess)
+	assert.Len(t, sub.Events, 0)
+}
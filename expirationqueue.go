@@ -0,0 +1,230 @@
+package photon
+
+import (
+	"container/heap"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+)
+
+/*
+expirationQueue 锁过期的定时处理:
+以前每个块都要把 BlockStateChange 分发给所有的 StateManager, 交易多的时候非常慢.
+状态机在新块上的动作只和锁的 expiration 相关(该注册密码,锁过期,过期后可以移除),
+所以每个 StateManager 只需要在下一个相关的块被唤醒.
+用最小堆保存 (唤醒块数, StateManager), 每个 StateManager 最多一项, 移除 StateManager 时同时移除, 不会泄露.
+*/
+/*
+ *	expirationQueue : timer driven lock expiration.
+ *	BlockStateChange used to be dispatched to every StateManager on every block, which is slow with many transfers.
+ *	What state machines do on a new block depends only on expiration of locks (register secret, lock expired, remove expired lock),
+ *	so every StateManager only needs to be woken up at the next block relevant to it.
+ *	A min-heap of (wakeup block, StateManager) is kept, at most one entry for every StateManager,
+ *	and it's removed together with the StateManager, so nothing leaks.
+ */
+type expirationQueue struct {
+	h     expirationHeap
+	items map[*transfer.StateManager]*expirationItem
+	//confirmedBlock the highest block number ever seen, never goes back when block number is adjusted by reorg
+	confirmedBlock int64
+}
+
+type expirationItem struct {
+	manager *transfer.StateManager
+	wakeup  int64 //block number to wake up manager
+	known   int64 //latest block number dispatched to manager
+	index   int
+}
+
+type expirationHeap []*expirationItem
+
+func (h expirationHeap) Len() int { return len(h) }
+func (h expirationHeap) Less(i, j int) bool {
+	return h[i].wakeup < h[j].wakeup
+}
+func (h expirationHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *expirationHeap) Push(x interface{}) {
+	item := x.(*expirationItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *expirationHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+func newExpirationQueue() *expirationQueue {
+	return &expirationQueue{
+		items: make(map[*transfer.StateManager]*expirationItem),
+	}
+}
+
+//schedule wake up mgr at block `wakeup`, replaces the previous one of mgr
+func (q *expirationQueue) schedule(mgr *transfer.StateManager, wakeup int64) {
+	item, ok := q.items[mgr]
+	if ok {
+		item.wakeup = wakeup
+		if item.index >= 0 {
+			heap.Fix(&q.h, item.index)
+		} else {
+			heap.Push(&q.h, item)
+		}
+		return
+	}
+	item = &expirationItem{
+		manager: mgr,
+		wakeup:  wakeup,
+	}
+	q.items[mgr] = item
+	heap.Push(&q.h, item)
+}
+
+//remove mgr is removed
+func (q *expirationQueue) remove(mgr *transfer.StateManager) {
+	item, ok := q.items[mgr]
+	if !ok {
+		return
+	}
+	if item.index >= 0 {
+		heap.Remove(&q.h, item.index)
+	}
+	delete(q.items, mgr)
+}
+
+/*
+popDue 取出唤醒块数不大于 blockNumber 的 StateManager.
+分叉导致 blockNumber 变小时使用曾经见过的最大块数, 已经处理过的不会再次处理, 也不会遗漏.
+取出的 StateManager 在重新 schedule 之前不会再次被唤醒.
+*/
+/*
+ *	popDue : pop StateManagers to be woken up at or before blockNumber.
+ *	If blockNumber goes back because of reorg, the highest block number ever seen is used,
+ *	so nothing is dispatched twice or missed.
+ *	Popped StateManagers are not woken up again until rescheduled.
+ */
+func (q *expirationQueue) popDue(blockNumber int64) (mgrs []*transfer.StateManager) {
+	if blockNumber > q.confirmedBlock {
+		q.confirmedBlock = blockNumber
+	}
+	for q.h.Len() > 0 && q.h[0].wakeup <= q.confirmedBlock {
+		item := heap.Pop(&q.h).(*expirationItem)
+		mgrs = append(mgrs, item.manager)
+	}
+	return
+}
+
+//isScheduled false if mgr is removed or has never been dispatched
+func (q *expirationQueue) isScheduled(mgr *transfer.StateManager) bool {
+	_, ok := q.items[mgr]
+	return ok
+}
+
+//knownBlock latest block number dispatched to mgr, 0 if mgr is not in queue
+func (q *expirationQueue) knownBlock(mgr *transfer.StateManager) int64 {
+	if item, ok := q.items[mgr]; ok {
+		return item.known
+	}
+	return 0
+}
+
+func (q *expirationQueue) setKnownBlock(mgr *transfer.StateManager, blockNumber int64) {
+	if item, ok := q.items[mgr]; ok && item.known < blockNumber {
+		item.known = blockNumber
+	}
+}
+
+func (q *expirationQueue) len() int {
+	return len(q.items)
+}
+
+/*
+nextWakeup 根据 StateManager 中所有锁的 expiration 计算下一个需要处理的块.
+对每个锁, 下面几个块状态机会有动作:
+1. expiration-revealTimeout 到 expiration, 不能再安全等待, 需要注册密码.
+	各个状态机判断的边界不完全相同, 崩溃恢复以后也可能是在这个窗口中间启动的,
+	所以收到的锁在密码注册到链上之前, 窗口中的每一块都要唤醒, 否则可能错过注册密码而丢失这个锁.
+2. expiration+1, 锁过期
+3. expiration+ForkConfirmNumber+1, 考虑分叉以后可以移除过期的锁
+不认识的状态下一块就唤醒, 和以前一样.
+*/
+/*
+ *	nextWakeup : compute the next block to handle from expiration of all locks in mgr.
+ *	For every lock, state machines act on these blocks:
+ *	1. expiration-revealTimeout to expiration, not safe to wait anymore, secret should be registered.
+ *		State machines don't agree on the exact bounds, and a node recovering from crash may start in the middle of it,
+ *		so for a received lock every block of the window is woken up until the secret is registered on chain,
+ *		otherwise registering the secret may be missed and the lock lost.
+ *	2. expiration+1, lock expired.
+ *	3. expiration+ForkConfirmNumber+1, expired lock can be removed safely against fork.
+ *	Unknown states are woken up at next block, just like before.
+ */
+func nextWakeup(mgr *transfer.StateManager, blockNumber int64) int64 {
+	next := int64(-1)
+	candidate := func(b int64) {
+		if b > blockNumber && (next < 0 || b < next) {
+			next = b
+		}
+	}
+	add := func(expiration int64, revealTimeout int, registerSecret bool) {
+		revealBlock := expiration - int64(revealTimeout)
+		candidate(revealBlock)
+		if registerSecret && blockNumber >= revealBlock && blockNumber < expiration {
+			candidate(blockNumber + 1)
+		}
+		candidate(expiration + 1)
+		candidate(expiration + params.ForkConfirmNumber + 1)
+	}
+	switch s := mgr.CurrentState.(type) {
+	case *mediatedtransfer.InitiatorState:
+		if s.Transfer == nil || s.Route == nil {
+			return blockNumber + 1
+		}
+		add(s.Transfer.Expiration, s.Route.RevealTimeout(), false)
+		if s.Deadline > blockNumber && !s.DeadlineExceeded && (next < 0 || s.Deadline < next) {
+			next = s.Deadline
+		}
+	case *mediatedtransfer.MediatorState:
+		for _, p := range s.TransfersPair {
+			add(p.PayerTransfer.Expiration, p.PayerRoute.RevealTimeout(), true)
+			add(p.PayeeTransfer.Expiration, p.PayeeRoute.RevealTimeout(), false)
+		}
+	case *mediatedtransfer.TargetState:
+		if s.FromTransfer == nil || s.FromRoute == nil {
+			return blockNumber + 1
+		}
+		add(s.FromTransfer.Expiration, s.FromRoute.RevealTimeout(), true)
+	case *mediatedtransfer.CrashState:
+		for _, l := range s.SentLocks {
+			add(l.Lock.Expiration, l.Channel.RevealTimeout, false)
+		}
+		for _, l := range s.ReceivedLocks {
+			add(l.Lock.Expiration, l.Channel.RevealTimeout, !secretRegisteredOnChain(l))
+		}
+	default:
+		return blockNumber + 1
+	}
+	if next < 0 {
+		//every lock is long expired, only waiting for messages
+		next = blockNumber + 1
+	}
+	return next
+}
+
+//secretRegisteredOnChain secret of received lock l is registered on chain, nothing to do until it expires
+func secretRegisteredOnChain(l *mediatedtransfer.LockAndChannel) bool {
+	unlock, ok := l.Channel.PartnerState.Lock2UnclaimedLocks[l.Lock.LockSecretHash]
+	return ok && unlock.IsRegisteredOnChain
+}
+
+// heap.Interface must be satisfied
+var _ heap.Interface = &expirationHeap{}
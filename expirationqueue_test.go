@@ -0,0 +1,100 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpirationQueue(t *testing.T) {
+	q := newExpirationQueue()
+	m1 := &transfer.StateManager{Name: "m1"}
+	m2 := &transfer.StateManager{Name: "m2"}
+	m3 := &transfer.StateManager{Name: "m3"}
+	q.schedule(m1, 30)
+	q.schedule(m2, 10)
+	q.schedule(m3, 20)
+	assert.Empty(t, q.popDue(9))
+	assert.EqualValues(t, []*transfer.StateManager{m2}, q.popDue(10))
+	// popped, not woken up again until rescheduled
+	assert.Empty(t, q.popDue(11))
+	q.schedule(m2, 25)
+	// m3 removed early, e.g. secret revealed
+	q.remove(m3)
+	assert.False(t, q.isScheduled(m3))
+	assert.EqualValues(t, []*transfer.StateManager{m2}, q.popDue(26))
+	// reorg, block number goes back, nothing dispatched twice or missed
+	q.schedule(m2, 27)
+	assert.Empty(t, q.popDue(20))
+	assert.Empty(t, q.popDue(21))
+	assert.EqualValues(t, []*transfer.StateManager{m2}, q.popDue(27))
+	// reschedule to an earlier block
+	q.schedule(m1, 28)
+	assert.EqualValues(t, []*transfer.StateManager{m1}, q.popDue(28))
+	q.remove(m1)
+	q.remove(m2)
+	assert.Equal(t, 0, q.len())
+	assert.Equal(t, 0, q.h.Len())
+	// known block never goes back
+	q.schedule(m1, 40)
+	q.setKnownBlock(m1, 35)
+	q.setKnownBlock(m1, 33)
+	assert.EqualValues(t, 35, q.knownBlock(m1))
+}
+
+func TestNextWakeup(t *testing.T) {
+	mgr := &transfer.StateManager{}
+	// unknown state, next block, just like before
+	assert.EqualValues(t, 101, nextWakeup(mgr, 100))
+	mgr.CurrentState = &mediatedtransfer.TargetState{}
+	assert.EqualValues(t, 101, nextWakeup(mgr, 100))
+
+	ch := &channel.Channel{RevealTimeout: 10, PartnerState: channel.NewChannelEndState(utils.NewRandomAddress(), big.NewInt(0), nil, mtree.NewMerkleTree(nil))}
+	received := &mtree.Lock{Expiration: 300, LockSecretHash: utils.NewRandomHash()}
+	crash := &mediatedtransfer.CrashState{
+		SentLocks: []*mediatedtransfer.LockAndChannel{
+			{Lock: &mtree.Lock{Expiration: 200}, Channel: ch},
+		},
+		ReceivedLocks: []*mediatedtransfer.LockAndChannel{
+			{Lock: received, Channel: ch},
+		},
+	}
+	mgr.CurrentState = crash
+	// not safe to wait
+	assert.EqualValues(t, 190, nextWakeup(mgr, 100))
+	// expired
+	assert.EqualValues(t, 201, nextWakeup(mgr, 190))
+	// expired lock can be removed
+	assert.EqualValues(t, 201+params.ForkConfirmNumber, nextWakeup(mgr, 201))
+	// secret of the received lock must be registered, every block of reveal window until expiration
+	assert.EqualValues(t, 290, nextWakeup(mgr, 201+params.ForkConfirmNumber))
+	for b := int64(290); b < 300; b++ {
+		assert.EqualValues(t, b+1, nextWakeup(mgr, b))
+	}
+	assert.EqualValues(t, 301, nextWakeup(mgr, 300))
+	// restarted in the middle of reveal window
+	assert.EqualValues(t, 296, nextWakeup(mgr, 295))
+	// secret registered, nothing to do until expiration
+	ch.PartnerState.Lock2UnclaimedLocks[received.LockSecretHash] = channeltype.UnlockPartialProof{Lock: received, IsRegisteredOnChain: true}
+	assert.EqualValues(t, 301, nextWakeup(mgr, 295))
+	// every lock is long expired
+	assert.EqualValues(t, 1001, nextWakeup(mgr, 1000))
+
+	// target waits for secret in the whole reveal window
+	mgr.CurrentState = &mediatedtransfer.TargetState{
+		FromTransfer: &mediatedtransfer.LockedTransferState{Expiration: 300},
+		FromRoute:    route.NewState(ch),
+	}
+	assert.EqualValues(t, 290, nextWakeup(mgr, 100))
+	assert.EqualValues(t, 291, nextWakeup(mgr, 290))
+	assert.EqualValues(t, 300, nextWakeup(mgr, 299))
+}
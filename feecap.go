@@ -0,0 +1,45 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+)
+
+/*
+filterRoutesByMaxFee 去掉总手续费超过 maxFee 的路由, 一条都不剩时返回的错误里带上最便宜的手续费.
+不知道手续费(TotalFee 为 nil)的路由无法保证不超过上限, 也去掉.
+接收方 SecretRequest 中的金额必须等于 TargetAmount 发起方才会告诉它密码, 所以只要路由满足上限, 发起方最多多付 maxFee.
+*/
+/*
+ *	filterRoutesByMaxFee : drop routes whose total fee exceeds maxFee, if none is left, the error reports the cheapest fee.
+ *	Routes with unknown fee (nil TotalFee) cannot be proven under the cap, they are dropped too.
+ *	Initiator reveals the secret only when amount in SecretRequest of target equals TargetAmount,
+ *	so as long as the route is under the cap, initiator never pays more than maxFee on top of it.
+ */
+func filterRoutesByMaxFee(routes []*route.State, maxFee *big.Int) ([]*route.State, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route to check against max fee %s", maxFee)
+	}
+	var cheapest *big.Int
+	var result []*route.State
+	for _, r := range routes {
+		fee := r.TotalFee
+		if fee == nil {
+			continue
+		}
+		if fee.Cmp(maxFee) <= 0 {
+			result = append(result, r)
+		} else if cheapest == nil || fee.Cmp(cheapest) < 0 {
+			cheapest = fee
+		}
+	}
+	if len(result) == 0 {
+		if cheapest == nil {
+			return nil, fmt.Errorf("fee cap exceeded, max fee %s, no route reports its fee", maxFee)
+		}
+		return nil, fmt.Errorf("fee cap exceeded, max fee %s, cheapest fee %s", maxFee, cheapest)
+	}
+	return result, nil
+}
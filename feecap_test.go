@@ -0,0 +1,29 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterRoutesByMaxFee(t *testing.T) {
+	routes := []*route.State{
+		{TotalFee: big.NewInt(5)},
+		{TotalFee: big.NewInt(2)},
+		{TotalFee: big.NewInt(0)},
+		{},
+	}
+	r, err := filterRoutesByMaxFee(routes, big.NewInt(2))
+	assert.Nil(t, err)
+	//unknown fee is never under the cap
+	assert.EqualValues(t, []*route.State{routes[1], routes[2]}, r)
+
+	_, err = filterRoutesByMaxFee(routes[:2], big.NewInt(1))
+	assert.EqualError(t, err, "fee cap exceeded, max fee 1, cheapest fee 2")
+	_, err = filterRoutesByMaxFee(routes[3:], big.NewInt(1))
+	assert.EqualError(t, err, "fee cap exceeded, max fee 1, no route reports its fee")
+	_, err = filterRoutesByMaxFee(nil, big.NewInt(1))
+	assert.EqualError(t, err, "no route to check against max fee 1")
+}
@@ -65,6 +65,22 @@ func (fm *FeeModule) SetFeePolicy(fp *models.FeePolicy) (err error) {
 	if fp.ChannelFeeMap == nil {
 		return errors.New("ChannelFeeMap can not be nil")
 	}
+	err = verifyFeeSetting(fp.AccountFee)
+	if err != nil {
+		return fmt.Errorf("AccountFee %s", err)
+	}
+	for token, fs := range fp.TokenFeeMap {
+		err = verifyFeeSetting(fs)
+		if err != nil {
+			return fmt.Errorf("fee of token %s %s", token.String(), err)
+		}
+	}
+	for channelID, fs := range fp.ChannelFeeMap {
+		err = verifyFeeSetting(fs)
+		if err != nil {
+			return fmt.Errorf("fee of channel %s %s", channelID.String(), err)
+		}
+	}
 	fm.lock.Lock()
 	defer fm.lock.Unlock()
 	// set fee policy to pfs
@@ -116,6 +132,20 @@ func (fm *FeeModule) GetNodeChargeFee(nodeAddress, tokenAddress common.Address,
 	return calculateFee(fm.feePolicy.AccountFee, amount)
 }
 
+//verifyFeeSetting a fee setting without constant part or with negative value would break calculateFee
+func verifyFeeSetting(fs *models.FeeSetting) error {
+	if fs == nil {
+		return errors.New("can not be nil")
+	}
+	if fs.FeeConstant == nil || fs.FeeConstant.Sign() < 0 {
+		return errors.New("fee_constant must be a non-negative number")
+	}
+	if fs.FeePercent < 0 {
+		return errors.New("fee_percent can not be negative")
+	}
+	return nil
+}
+
 func calculateFee(feeSetting *models.FeeSetting, amount *big.Int) *big.Int {
 	fee := big.NewInt(0)
 	if feeSetting.FeePercent > 0 {
@@ -62,6 +62,31 @@ func TestFeeModule_Local(t *testing.T) {
 	}
 }
 
+func TestFeeModule_SetInvalidFeePolicy(t *testing.T) {
+	db, err := newTestStormDb()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	fm, err := NewFeeModule(db, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	fp := models.NewDefaultFeePolicy()
+	fp.AccountFee.FeeConstant = big.NewInt(-1)
+	assert.NotNil(t, fm.SetFeePolicy(fp))
+	fp = models.NewDefaultFeePolicy()
+	fp.TokenFeeMap[utils.NewRandomAddress()] = &models.FeeSetting{FeePercent: 100}
+	assert.NotNil(t, fm.SetFeePolicy(fp))
+	fp = models.NewDefaultFeePolicy()
+	fp.ChannelFeeMap[utils.NewRandomHash()] = &models.FeeSetting{FeeConstant: big.NewInt(1), FeePercent: -100}
+	assert.NotNil(t, fm.SetFeePolicy(fp))
+	// rejected policy never replaces the one in force
+	assert.EqualValues(t, 0, fm.feePolicy.AccountFee.FeeConstant.Int64())
+	assert.EqualValues(t, 1, fm.GetNodeChargeFee(utils.NewRandomAddress(), utils.NewRandomAddress(), big.NewInt(10000)).Int64())
+}
+
 func TestFeeModule_WithPFS(t *testing.T) {
 	if testing.Short() {
 		return
@@ -95,10 +95,20 @@ func (mh *photonMessageHandler) onMessage(msg encoding.SignedMessager, hash comm
 		err = mh.messageWithdrawRequest(m2)
 	case *encoding.WithdrawResponse:
 		err = mh.messageWithdrawResponse(m2)
+	case *encoding.MonitorDelegate:
+		err = mh.photon.handleMonitorDelegate(m2)
+	case *encoding.PaymentReceipt:
+		err = mh.photon.handlePaymentReceipt(m2)
+	case *encoding.ResyncRequest:
+		err = mh.photon.handleResyncRequest(m2)
 	default:
 		log.Error(fmt.Sprintf("photonMessageHandler unknown msg:%s", utils.StringInterface1(msg)))
 		return fmt.Errorf("unhandled message cmdid:%d", msg.Cmd())
 	}
+	if ev, ok := msg.(encoding.EnvelopMessager); ok && err == rerr.ErrNonceGap {
+		//no ack, partner keeps retrying this message until the missing ones are retransmitted
+		mh.photon.requestResync(ev.GetEnvelopMessage().ChannelIdentifier)
+	}
 	return err
 }
 
@@ -130,6 +140,7 @@ func (mh *photonMessageHandler) messageRevealSecret(msg *encoding.RevealSecret)
 	channels := mh.photon.findAllChannelsByLockSecretHash(msg.LockSecretHash())
 	for _, c := range channels {
 		mh.photon.dao.UpdateTransferStatusMessage(c.TokenAddress, msg.LockSecretHash(), fmt.Sprintf("收到 RevealSecret, from=%s", utils.APex2(msg.Sender)))
+		mh.photon.keepRevealSecretProof(c.TokenAddress, msg)
 	}
 	mh.photon.StateMachineEventHandler.dispatchBySecretHash(msg.LockSecretHash(), stateChange)
 	return nil
@@ -213,11 +224,13 @@ func (mh *photonMessageHandler) messageUnlock(msg *encoding.UnLock) error {
 	if !channeltype.CanDealUnlock[ch.State] {
 		return errors.New("received unlock msg,but channel cannot deal unlock, do nothing")
 	}
+	before, merkleProof := mh.photon.paymentProofBeforeUnlock(ch.TokenAddress, lockSecretHash, ch.PartnerState)
 	err = ch.RegisterTransfer(mh.photon.GetBlockNumber(), msg)
 	if err != nil {
 		log.Error(fmt.Sprintf("messageUnlock RegisterTransfer err=%s", err))
 		return err
 	}
+	mh.photon.keepUnlockProof(ch.TokenAddress, msg, before, merkleProof)
 	/*
 		验证过消息是有效的,然后通知相应的 stateMana 该结束的结束,
 	*/
@@ -503,9 +516,13 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 	if ch == nil {
 		return rerr.ChannelNotFound(fmt.Sprintf("token:%s,partner:%s", utils.APex2(token), utils.APex2(msg.Sender)))
 	}
-	if !ch.CanTransfer() {
+	if !ch.CanReceiveTransfer() {
 		return rerr.TransferWhenClosed(fmt.Sprintf("Mediated transfer received but the channel is  can not accept any transfer %s", ch.ChannelIdentifier.String()))
 	}
+	//we already know the secret, an attacker may want a free reveal
+	if mh.photon.dao.IsLockSecretHashLearned(msg.LockSecretHash) {
+		return rerr.ErrLockSecretHashReused
+	}
 	err := ch.RegisterTransfer(mh.photon.GetBlockNumber(), msg)
 	if err != nil {
 		return err
@@ -538,6 +555,7 @@ func (mh *photonMessageHandler) messageMediatedTransfer(msg *encoding.MediatedTr
 	//mh.updateChannelAndSaveAck(ch, msg.Tag())
 	if msg.Target == mh.photon.NodeAddress {
 		mh.photon.targetMediatedTransfer(msg, ch)
+		mh.photon.keepLockedTransferProof(token, msg, msg.Sender, mh.photon.NodeAddress)
 	} else {
 		mh.photon.mediateMediatedTransfer(msg, ch)
 	}
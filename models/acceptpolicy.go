@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//AcceptMode how an accept rule treats initiators in its list
+type AcceptMode string
+
+const (
+	//AcceptModeAll accept transfers from anyone, the list is ignored
+	AcceptModeAll AcceptMode = ""
+	//AcceptModeWhitelist accept transfers only from initiators in the list
+	AcceptModeWhitelist AcceptMode = "whitelist"
+	//AcceptModeBlacklist refuse transfers from initiators in the list
+	AcceptModeBlacklist AcceptMode = "blacklist"
+)
+
+//AcceptRule which initiators we accept transfers from
+type AcceptRule struct {
+	Mode       AcceptMode       `json:"mode"`
+	Initiators []common.Address `json:"initiators"`
+}
+
+//Accept true if transfers from initiator are accepted by this rule
+func (r *AcceptRule) Accept(initiator common.Address) bool {
+	listed := false
+	for _, a := range r.Initiators {
+		if a == initiator {
+			listed = true
+			break
+		}
+	}
+	switch r.Mode {
+	case AcceptModeWhitelist:
+		return listed
+	case AcceptModeBlacklist:
+		return !listed
+	}
+	return true
+}
+
+/*
+AcceptPolicy 作为接收方, 只接受(或者拒绝)某些发起方的交易.
+可以为整个节点设置, 也可以按 token 设置, 优先级: token > 节点.
+发起方地址来自 MediatedTransfer, 没有发起方的签名, 不能用来做安全控制.
+*/
+/*
+ *	AcceptPolicy : as target, accept (or refuse) transfers only from some initiators.
+ *	It can be set for the whole node and overridden per token.
+ *	Priority: token > node
+ *	The initiator comes from MediatedTransfer, which is not signed by the initiator, so it is not a security control.
+ */
+type AcceptPolicy struct {
+	Key          string                         `storm:"id"`
+	NodeRule     *AcceptRule                    `json:"node_rule"`
+	TokenRuleMap map[common.Address]*AcceptRule `json:"token_rule_map"`
+}
+
+//NewDefaultAcceptPolicy accept transfers from anyone
+func NewDefaultAcceptPolicy() *AcceptPolicy {
+	return &AcceptPolicy{
+		NodeRule:     &AcceptRule{},
+		TokenRuleMap: make(map[common.Address]*AcceptRule),
+	}
+}
+
+//Accept true if we accept transfers of tokenAddress from initiator
+func (p *AcceptPolicy) Accept(tokenAddress, initiator common.Address) bool {
+	if r, ok := p.TokenRuleMap[tokenAddress]; ok && r != nil {
+		return r.Accept(initiator)
+	}
+	if p.NodeRule != nil {
+		return p.NodeRule.Accept(initiator)
+	}
+	return true
+}
+
+func init() {
+	gob.Register(&AcceptPolicy{})
+}
@@ -49,6 +49,16 @@ const (
 	BucketSentTransfer             = "SentTransfer"
 	BucketReceivedTransfer         = "ReceivedTransfer"
 	BucketTransferStatus           = "TransferStatus"
+	BucketTransferIdempotency      = "TransferIdempotency"
+	BucketTransferRecord           = "TransferRecord"
+	BucketMonitor                  = "Monitor"
+	BucketMonitorDelegation        = "MonitorDelegation"
+	BucketTopUpPolicy              = "TopUpPolicy"
+	BucketTopUpRecord              = "TopUpRecord"
+	BucketRevealTimeoutPolicy      = "RevealTimeoutPolicy"
+	BucketLearnedSecretHash        = "LearnedSecretHash"
+	BucketAcceptPolicy             = "AcceptPolicy"
+	BucketPendingSecret            = "PendingSecret"
 )
 
 /*
@@ -70,6 +80,12 @@ const (
 
 	// keys of BucketFeePolicy
 	KeyFeePolicy string = "feePolicy"
+	// keys of BucketRevealTimeoutPolicy
+	KeyRevealTimeoutPolicy = "revealTimeoutPolicy"
+	// keys of BucketAcceptPolicy
+	KeyAcceptPolicy = "acceptPolicy"
+	// keys of BucketMonitor
+	KeyMonitorFee = "monitorFee"
 	// keys of BucketToken
 	KeyToken = "tokens"
 )
@@ -106,6 +106,18 @@ type FeePolicyDao interface {
 	GetFeePolicy() (fp *FeePolicy)
 }
 
+// RevealTimeoutPolicyDao :
+type RevealTimeoutPolicyDao interface {
+	SaveRevealTimeoutPolicy(p *RevealTimeoutPolicy) (err error)
+	GetRevealTimeoutPolicy() (p *RevealTimeoutPolicy)
+}
+
+// AcceptPolicyDao :
+type AcceptPolicyDao interface {
+	SaveAcceptPolicy(p *AcceptPolicy) (err error)
+	GetAcceptPolicy() (p *AcceptPolicy)
+}
+
 // NonParticipantChannelDao :
 type NonParticipantChannelDao interface {
 	NewNonParticipantChannel(token common.Address, channelIdentifier common.Hash, participant1, participant2 common.Address) error
@@ -167,6 +179,52 @@ type TransferStatusDao interface {
 	GetTransferStatus(tokenAddress common.Address, lockSecretHash common.Hash) (*TransferStatus, error)
 }
 
+// TransferIdempotencyDao :
+type TransferIdempotencyDao interface {
+	SaveTransferIdempotency(r *TransferIdempotency) error
+	GetTransferIdempotency(tokenAddress, target common.Address, identifier string) (*TransferIdempotency, error)
+	RemoveTransferIdempotencyBefore(timestamp int64) (n int, err error)
+}
+
+// LearnedSecretHashDao :
+type LearnedSecretHashDao interface {
+	MarkLockSecretHashLearned(lockSecretHash common.Hash) error
+	IsLockSecretHashLearned(lockSecretHash common.Hash) bool
+}
+
+// PendingSecretDao :
+type PendingSecretDao interface {
+	SavePendingSecret(p *PendingSecret) error
+	RemovePendingSecret(secret common.Hash) error
+	GetAllPendingSecrets() (ps []*PendingSecret, err error)
+}
+
+// TransferRecordDao :
+type TransferRecordDao interface {
+	SaveTransferRecord(r *TransferRecord) error
+	GetTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash) (*TransferRecord, error)
+	GetTransferRecordsInPhase(phase TransferPhase) ([]*TransferRecord, error)
+}
+
+// MonitorDao :
+type MonitorDao interface {
+	SaveMonitorDelegation(d *MonitorDelegation) error
+	GetMonitorDelegation(channelIdentifier common.Hash) (*MonitorDelegation, error)
+	GetAllMonitorDelegation() (ds []*MonitorDelegation, err error)
+	SaveMonitorFee(fee *big.Int) error
+	GetMonitorFee() *big.Int
+}
+
+// TopUpDao :
+type TopUpDao interface {
+	SaveTopUpPolicy(p *TopUpPolicy) error
+	GetTopUpPolicy(channelIdentifier common.Hash) (*TopUpPolicy, error)
+	GetAllTopUpPolicy() (ps []*TopUpPolicy, err error)
+	RemoveTopUpPolicy(channelIdentifier common.Hash) error
+	SaveTopUpRecord(r *TopUpRecord) error
+	GetTopUpRecords(channelIdentifier common.Hash) (rs []*TopUpRecord, err error)
+}
+
 // XMPPSubDao :
 type XMPPSubDao interface {
 	XMPPMarkAddrSubed(addr common.Address)
@@ -187,6 +245,8 @@ type Dao interface {
 	SentEnvelopMessagerDao
 	FeeChargeRecordDao
 	FeePolicyDao
+	RevealTimeoutPolicyDao
+	AcceptPolicyDao
 	NonParticipantChannelDao
 	SentAnnounceDisposedDao
 	ReceivedAnnounceDisposedDao
@@ -195,6 +255,12 @@ type Dao interface {
 	SentTransferDao
 	ReceivedTransferDao
 	TransferStatusDao
+	TransferIdempotencyDao
+	LearnedSecretHashDao
+	PendingSecretDao
+	TransferRecordDao
+	MonitorDao
+	TopUpDao
 	XMPPSubDao
 
 	StartTx() (tx TX)
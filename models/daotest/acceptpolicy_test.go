@@ -0,0 +1,40 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_AcceptPolicy(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+
+	token := utils.NewRandomAddress()
+	friend, stranger := utils.NewRandomAddress(), utils.NewRandomAddress()
+	p := dao.GetAcceptPolicy()
+	assert.True(t, p.Accept(token, stranger))
+
+	p.NodeRule = &models.AcceptRule{Mode: models.AcceptModeWhitelist, Initiators: []common.Address{friend}}
+	err := dao.SaveAcceptPolicy(p)
+	assert.Empty(t, err)
+	p = dao.GetAcceptPolicy()
+	assert.True(t, p.Accept(token, friend))
+	assert.False(t, p.Accept(token, stranger))
+
+	//token overrides node
+	if p.TokenRuleMap == nil {
+		p.TokenRuleMap = make(map[common.Address]*models.AcceptRule)
+	}
+	p.TokenRuleMap[token] = &models.AcceptRule{Mode: models.AcceptModeBlacklist, Initiators: []common.Address{friend}}
+	err = dao.SaveAcceptPolicy(p)
+	assert.Empty(t, err)
+	p = dao.GetAcceptPolicy()
+	assert.False(t, p.Accept(token, friend))
+	assert.True(t, p.Accept(token, stranger))
+	assert.False(t, p.Accept(utils.NewRandomAddress(), stranger))
+}
@@ -0,0 +1,24 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_LearnedSecretHash(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	lockSecretHash := utils.NewRandomHash()
+	assert.False(t, dao.IsLockSecretHashLearned(lockSecretHash))
+	err := dao.MarkLockSecretHashLearned(lockSecretHash)
+	assert.Empty(t, err)
+	assert.True(t, dao.IsLockSecretHashLearned(lockSecretHash))
+	assert.False(t, dao.IsLockSecretHashLearned(utils.NewRandomHash()))
+	//mark again is ok
+	err = dao.MarkLockSecretHashLearned(lockSecretHash)
+	assert.Empty(t, err)
+	assert.True(t, dao.IsLockSecretHashLearned(lockSecretHash))
+}
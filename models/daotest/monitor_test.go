@@ -0,0 +1,50 @@
+package daotest
+
+import (
+	"testing"
+
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_MonitorDelegation(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+
+	assert.Nil(t, dao.GetMonitorFee())
+	err := dao.SaveMonitorFee(big.NewInt(10))
+	assert.Empty(t, err)
+	assert.EqualValues(t, big.NewInt(10), dao.GetMonitorFee())
+
+	ds, err := dao.GetAllMonitorDelegation()
+	assert.Empty(t, err)
+	assert.Empty(t, ds)
+	channelIdentifier := utils.NewRandomHash()
+	d := &models.MonitorDelegation{
+		ChannelIdentifier: channelIdentifier,
+		OpenBlockNumber:   3,
+		TokenAddress:      utils.NewRandomAddress(),
+		Client:            utils.NewRandomAddress(),
+		Partner:           utils.NewRandomAddress(),
+		Fee:               big.NewInt(10),
+		Status:            models.MonitorDelegationWaiting,
+	}
+	err = dao.SaveMonitorDelegation(d)
+	assert.Empty(t, err)
+	d.Status = models.MonitorDelegationClosed
+	err = dao.SaveMonitorDelegation(d)
+	assert.Empty(t, err)
+	d2, err := dao.GetMonitorDelegation(channelIdentifier)
+	assert.Empty(t, err)
+	assert.EqualValues(t, models.MonitorDelegationClosed, d2.Status)
+	assert.EqualValues(t, d.Client, d2.Client)
+	ds, err = dao.GetAllMonitorDelegation()
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(ds))
+	_, err = dao.GetMonitorDelegation(utils.NewRandomHash())
+	assert.NotEmpty(t, err)
+}
@@ -0,0 +1,49 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_PendingSecret(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	ps, err := dao.GetAllPendingSecrets()
+	assert.Empty(t, err)
+	assert.Empty(t, ps)
+	p := &models.PendingSecret{
+		Secret:         utils.NewRandomHash(),
+		LockExpiration: 100,
+		RevealTimeout:  10,
+	}
+	err = dao.SavePendingSecret(p)
+	assert.Empty(t, err)
+	//earlier expiration saved again
+	p.LockExpiration = 90
+	err = dao.SavePendingSecret(p)
+	assert.Empty(t, err)
+	err = dao.SavePendingSecret(&models.PendingSecret{Secret: utils.NewRandomHash()})
+	assert.Empty(t, err)
+	ps, err = dao.GetAllPendingSecrets()
+	assert.Empty(t, err)
+	assert.Len(t, ps, 2)
+	for _, p2 := range ps {
+		if p2.Secret == p.Secret {
+			assert.EqualValues(t, 90, p2.LockExpiration)
+			assert.EqualValues(t, 10, p2.RevealTimeout)
+		}
+	}
+	err = dao.RemovePendingSecret(p.Secret)
+	assert.Empty(t, err)
+	ps, err = dao.GetAllPendingSecrets()
+	assert.Empty(t, err)
+	assert.Len(t, ps, 1)
+	assert.NotEqual(t, p.Secret, ps[0].Secret)
+	//removing an unknown secret is ok
+	err = dao.RemovePendingSecret(utils.NewRandomHash())
+	assert.Empty(t, err)
+}
@@ -0,0 +1,38 @@
+package daotest
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_RevealTimeoutPolicy(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+
+	token := utils.NewRandomAddress()
+	channelIdentifier := utils.NewRandomHash()
+	p := dao.GetRevealTimeoutPolicy()
+	assert.EqualValues(t, 0, p.RevealTimeout(token, channelIdentifier))
+
+	p.TokenRevealTimeoutMap[token] = 20
+	err := dao.SaveRevealTimeoutPolicy(p)
+	assert.Empty(t, err)
+	p = dao.GetRevealTimeoutPolicy()
+	assert.EqualValues(t, 20, p.RevealTimeout(token, channelIdentifier))
+	assert.EqualValues(t, 0, p.RevealTimeout(utils.NewRandomAddress(), channelIdentifier))
+
+	//channel overrides token
+	if p.ChannelRevealTimeoutMap == nil {
+		p.ChannelRevealTimeoutMap = make(map[common.Hash]int)
+	}
+	p.ChannelRevealTimeoutMap[channelIdentifier] = 15
+	err = dao.SaveRevealTimeoutPolicy(p)
+	assert.Empty(t, err)
+	p = dao.GetRevealTimeoutPolicy()
+	assert.EqualValues(t, 15, p.RevealTimeout(token, channelIdentifier))
+	assert.EqualValues(t, 20, p.RevealTimeout(token, utils.NewRandomHash()))
+}
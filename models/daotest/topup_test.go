@@ -0,0 +1,76 @@
+package daotest
+
+import (
+	"testing"
+
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_TopUpPolicy(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+
+	ps, err := dao.GetAllTopUpPolicy()
+	assert.Empty(t, err)
+	assert.Empty(t, ps)
+	channelIdentifier := utils.NewRandomHash()
+	p := &models.TopUpPolicy{
+		ChannelIdentifier: channelIdentifier,
+		TokenAddress:      utils.NewRandomAddress(),
+		PartnerAddress:    utils.NewRandomAddress(),
+		LowWatermark:      big.NewInt(10),
+		Target:            big.NewInt(100),
+		DailyLimit:        big.NewInt(500),
+	}
+	err = dao.SaveTopUpPolicy(p)
+	assert.Empty(t, err)
+	p.Paused = true
+	err = dao.SaveTopUpPolicy(p)
+	assert.Empty(t, err)
+	p2, err := dao.GetTopUpPolicy(channelIdentifier)
+	assert.Empty(t, err)
+	assert.EqualValues(t, true, p2.Paused)
+	assert.EqualValues(t, p.Target, p2.Target)
+	ps, err = dao.GetAllTopUpPolicy()
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(ps))
+	err = dao.RemoveTopUpPolicy(channelIdentifier)
+	assert.Empty(t, err)
+	_, err = dao.GetTopUpPolicy(channelIdentifier)
+	assert.NotEmpty(t, err)
+}
+
+func TestModelDB_TopUpRecord(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+
+	channelIdentifier := utils.NewRandomHash()
+	rs, err := dao.GetTopUpRecords(channelIdentifier)
+	assert.Empty(t, err)
+	assert.Empty(t, rs)
+	r := &models.TopUpRecord{
+		ChannelIdentifier: channelIdentifier,
+		Amount:            big.NewInt(90),
+		Status:            models.TopUpPending,
+	}
+	err = dao.SaveTopUpRecord(r)
+	assert.Empty(t, err)
+	r.Status = models.TopUpSuccess
+	err = dao.SaveTopUpRecord(r)
+	assert.Empty(t, err)
+	err = dao.SaveTopUpRecord(&models.TopUpRecord{
+		ChannelIdentifier: utils.NewRandomHash(),
+		Amount:            big.NewInt(1),
+	})
+	assert.Empty(t, err)
+	rs, err = dao.GetTopUpRecords(channelIdentifier)
+	assert.Empty(t, err)
+	assert.EqualValues(t, 1, len(rs))
+	assert.EqualValues(t, models.TopUpSuccess, rs[0].Status)
+	assert.EqualValues(t, big.NewInt(90), rs[0].Amount)
+}
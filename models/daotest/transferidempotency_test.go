@@ -0,0 +1,59 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_TransferIdempotency(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	target := utils.NewRandomAddress()
+	now := time.Now().Unix()
+	r := &models.TransferIdempotency{
+		TokenAddress:   token,
+		Target:         target,
+		Identifier:     "order-1",
+		Amount:         big.NewInt(10),
+		LockSecretHash: utils.NewRandomHash(),
+		Timestamp:      now - 100,
+	}
+	err := dao.SaveTransferIdempotency(r)
+	assert.Empty(t, err)
+	r2, err := dao.GetTransferIdempotency(token, target, "order-1")
+	assert.Empty(t, err)
+	assert.EqualValues(t, r.LockSecretHash, r2.LockSecretHash)
+	assert.EqualValues(t, r.Amount, r2.Amount)
+	// scoped per (token, target)
+	_, err = dao.GetTransferIdempotency(token, utils.NewRandomAddress(), "order-1")
+	assert.NotEmpty(t, err)
+	_, err = dao.GetTransferIdempotency(utils.NewRandomAddress(), target, "order-1")
+	assert.NotEmpty(t, err)
+
+	err = dao.SaveTransferIdempotency(&models.TransferIdempotency{
+		TokenAddress:   token,
+		Target:         target,
+		Identifier:     "order-2",
+		Amount:         big.NewInt(20),
+		LockSecretHash: utils.NewRandomHash(),
+		Timestamp:      now,
+	})
+	assert.Empty(t, err)
+	n, err := dao.RemoveTransferIdempotencyBefore(now - 10)
+	assert.Empty(t, err)
+	assert.Equal(t, 1, n)
+	_, err = dao.GetTransferIdempotency(token, target, "order-1")
+	assert.NotEmpty(t, err)
+	_, err = dao.GetTransferIdempotency(token, target, "order-2")
+	assert.Empty(t, err)
+	n, err = dao.RemoveTransferIdempotencyBefore(now - 10)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,63 @@
+package daotest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_TransferRecord(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	mediator := utils.NewRandomAddress()
+	r := &models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Initiator:      utils.NewRandomAddress(),
+		Target:         utils.NewRandomAddress(),
+		Amount:         big.NewInt(10),
+		Fee:            big.NewInt(1),
+		Phase:          models.TransferPhaseRouting,
+	}
+	err := dao.SaveTransferRecord(r)
+	assert.Empty(t, err)
+	_, err = dao.GetTransferRecord(utils.NewRandomAddress(), lockSecretHash)
+	assert.NotEmpty(t, err)
+	r2, err := dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.EqualValues(t, r.Amount, r2.Amount)
+	assert.Equal(t, models.TransferPhaseRouting, r2.Phase)
+	assert.False(t, r2.Finished())
+	rs, err := dao.GetTransferRecordsInPhase(models.TransferPhaseRouting)
+	assert.Empty(t, err)
+	if assert.Len(t, rs, 1) {
+		assert.Equal(t, lockSecretHash, rs[0].LockSecretHash)
+	}
+	rs, err = dao.GetTransferRecordsInPhase(models.TransferPhaseQueued)
+	assert.Empty(t, err)
+	assert.Len(t, rs, 0)
+
+	r2.Route = []common.Address{r.Initiator, mediator, r.Target}
+	r2.HopFees = []models.HopFee{{Hop: mediator, Fee: big.NewInt(1)}}
+	r2.Phase = models.TransferPhaseFailed
+	r2.FailureReason = models.TransferFailureLockExpired
+	err = dao.SaveTransferRecord(r2)
+	assert.Empty(t, err)
+	r3, err := dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.True(t, r3.Finished())
+	assert.Equal(t, models.TransferFailureLockExpired, r3.FailureReason)
+	assert.EqualValues(t, r2.Route, r3.Route)
+	assert.Equal(t, mediator, r3.HopFees[0].Hop)
+	rs, err = dao.GetTransferRecordsInPhase(models.TransferPhaseRouting)
+	assert.Empty(t, err)
+	assert.Len(t, rs, 0)
+}
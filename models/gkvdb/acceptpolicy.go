@@ -0,0 +1,28 @@
+package gkvdb
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+)
+
+// SaveAcceptPolicy :
+func (dao *GkvDB) SaveAcceptPolicy(p *models.AcceptPolicy) (err error) {
+	p.Key = models.KeyAcceptPolicy
+	return dao.saveKeyValueToBucket(models.BucketAcceptPolicy, p.Key, p)
+}
+
+// GetAcceptPolicy :
+func (dao *GkvDB) GetAcceptPolicy() (p *models.AcceptPolicy) {
+	p = &models.AcceptPolicy{}
+	err := dao.getKeyValueToBucket(models.BucketAcceptPolicy, models.KeyAcceptPolicy, &p)
+	if err == ErrorNotFound {
+		return models.NewDefaultAcceptPolicy()
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("GetAcceptPolicy err %s, use default", err))
+		return models.NewDefaultAcceptPolicy()
+	}
+	return
+}
@@ -0,0 +1,24 @@
+package gkvdb
+
+import (
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MarkLockSecretHashLearned :
+func (dao *GkvDB) MarkLockSecretHashLearned(lockSecretHash common.Hash) error {
+	r := &models.LearnedSecretHash{
+		LockSecretHash: lockSecretHash[:],
+		Timestamp:      time.Now().Unix(),
+	}
+	return dao.saveKeyValueToBucket(models.BucketLearnedSecretHash, r.LockSecretHash, r)
+}
+
+// IsLockSecretHashLearned :
+func (dao *GkvDB) IsLockSecretHashLearned(lockSecretHash common.Hash) bool {
+	var r models.LearnedSecretHash
+	err := dao.getKeyValueToBucket(models.BucketLearnedSecretHash, lockSecretHash[:], &r)
+	return err == nil
+}
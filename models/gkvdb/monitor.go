@@ -0,0 +1,58 @@
+package gkvdb
+
+import (
+	"fmt"
+	"math/big"
+
+	"gitee.com/johng/gkvdb/gkvdb"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveMonitorDelegation :
+func (dao *GkvDB) SaveMonitorDelegation(d *models.MonitorDelegation) error {
+	d.Key = d.ChannelIdentifier[:]
+	return dao.saveKeyValueToBucket(models.BucketMonitorDelegation, d.ChannelIdentifier[:], d)
+}
+
+// GetMonitorDelegation :
+func (dao *GkvDB) GetMonitorDelegation(channelIdentifier common.Hash) (*models.MonitorDelegation, error) {
+	var d models.MonitorDelegation
+	err := dao.getKeyValueToBucket(models.BucketMonitorDelegation, channelIdentifier[:], &d)
+	return &d, err
+}
+
+// GetAllMonitorDelegation :
+func (dao *GkvDB) GetAllMonitorDelegation() (ds []*models.MonitorDelegation, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketMonitorDelegation)
+	if err != nil {
+		return
+	}
+	buf := tb.Values(-1)
+	if buf == nil || len(buf) == 0 {
+		return
+	}
+	for _, v := range buf {
+		var d models.MonitorDelegation
+		gobDecode(v, &d)
+		ds = append(ds, &d)
+	}
+	return
+}
+
+// SaveMonitorFee :
+func (dao *GkvDB) SaveMonitorFee(fee *big.Int) error {
+	return dao.saveKeyValueToBucket(models.BucketMonitor, models.KeyMonitorFee, fee)
+}
+
+// GetMonitorFee : nil means i'm not a monitor
+func (dao *GkvDB) GetMonitorFee() *big.Int {
+	var fee *big.Int
+	err := dao.getKeyValueToBucket(models.BucketMonitor, models.KeyMonitorFee, &fee)
+	if err != nil && err != ErrorNotFound {
+		log.Error(fmt.Sprintf("GetMonitorFee err %s", err))
+	}
+	return fee
+}
@@ -0,0 +1,37 @@
+package gkvdb
+
+import (
+	"gitee.com/johng/gkvdb/gkvdb"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SavePendingSecret :
+func (dao *GkvDB) SavePendingSecret(p *models.PendingSecret) error {
+	p.Key = p.Secret[:]
+	return dao.saveKeyValueToBucket(models.BucketPendingSecret, p.Key, p)
+}
+
+// RemovePendingSecret :
+func (dao *GkvDB) RemovePendingSecret(secret common.Hash) error {
+	return dao.removeKeyValueFromBucket(models.BucketPendingSecret, secret[:])
+}
+
+// GetAllPendingSecrets :
+func (dao *GkvDB) GetAllPendingSecrets() (ps []*models.PendingSecret, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketPendingSecret)
+	if err != nil {
+		return
+	}
+	buf := tb.Values(-1)
+	if buf == nil || len(buf) == 0 {
+		return
+	}
+	for _, v := range buf {
+		var p models.PendingSecret
+		gobDecode(v, &p)
+		ps = append(ps, &p)
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package gkvdb
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+)
+
+// SaveRevealTimeoutPolicy :
+func (dao *GkvDB) SaveRevealTimeoutPolicy(p *models.RevealTimeoutPolicy) (err error) {
+	p.Key = models.KeyRevealTimeoutPolicy
+	return dao.saveKeyValueToBucket(models.BucketRevealTimeoutPolicy, p.Key, p)
+}
+
+// GetRevealTimeoutPolicy :
+func (dao *GkvDB) GetRevealTimeoutPolicy() (p *models.RevealTimeoutPolicy) {
+	p = &models.RevealTimeoutPolicy{}
+	err := dao.getKeyValueToBucket(models.BucketRevealTimeoutPolicy, models.KeyRevealTimeoutPolicy, &p)
+	if err == ErrorNotFound {
+		return models.NewDefaultRevealTimeoutPolicy()
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("GetRevealTimeoutPolicy err %s, use default", err))
+		return models.NewDefaultRevealTimeoutPolicy()
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package gkvdb
+
+import (
+	"gitee.com/johng/gkvdb/gkvdb"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTopUpPolicy :
+func (dao *GkvDB) SaveTopUpPolicy(p *models.TopUpPolicy) error {
+	p.Key = p.ChannelIdentifier[:]
+	return dao.saveKeyValueToBucket(models.BucketTopUpPolicy, p.ChannelIdentifier[:], p)
+}
+
+// GetTopUpPolicy :
+func (dao *GkvDB) GetTopUpPolicy(channelIdentifier common.Hash) (*models.TopUpPolicy, error) {
+	var p models.TopUpPolicy
+	err := dao.getKeyValueToBucket(models.BucketTopUpPolicy, channelIdentifier[:], &p)
+	return &p, err
+}
+
+// GetAllTopUpPolicy :
+func (dao *GkvDB) GetAllTopUpPolicy() (ps []*models.TopUpPolicy, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketTopUpPolicy)
+	if err != nil {
+		return
+	}
+	buf := tb.Values(-1)
+	if buf == nil || len(buf) == 0 {
+		return
+	}
+	for _, v := range buf {
+		var p models.TopUpPolicy
+		gobDecode(v, &p)
+		ps = append(ps, &p)
+	}
+	return
+}
+
+// RemoveTopUpPolicy :
+func (dao *GkvDB) RemoveTopUpPolicy(channelIdentifier common.Hash) error {
+	return dao.removeKeyValueFromBucket(models.BucketTopUpPolicy, channelIdentifier[:])
+}
+
+// SaveTopUpRecord :
+func (dao *GkvDB) SaveTopUpRecord(r *models.TopUpRecord) error {
+	if len(r.Key) == 0 {
+		key := utils.NewRandomHash()
+		r.Key = key[:]
+	}
+	return dao.saveKeyValueToBucket(models.BucketTopUpRecord, r.Key, r)
+}
+
+// GetTopUpRecords :
+func (dao *GkvDB) GetTopUpRecords(channelIdentifier common.Hash) (rs []*models.TopUpRecord, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketTopUpRecord)
+	if err != nil {
+		return
+	}
+	buf := tb.Values(-1)
+	if buf == nil || len(buf) == 0 {
+		return
+	}
+	for _, v := range buf {
+		var r models.TopUpRecord
+		gobDecode(v, &r)
+		if r.ChannelIdentifier == channelIdentifier {
+			rs = append(rs, &r)
+		}
+	}
+	return
+}
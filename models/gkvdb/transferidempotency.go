@@ -0,0 +1,43 @@
+package gkvdb
+
+import (
+	"gitee.com/johng/gkvdb/gkvdb"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTransferIdempotency :
+func (dao *GkvDB) SaveTransferIdempotency(r *models.TransferIdempotency) error {
+	r.Key = models.TransferIdempotencyKey(r.TokenAddress, r.Target, r.Identifier)
+	return dao.saveKeyValueToBucket(models.BucketTransferIdempotency, r.Key, r)
+}
+
+// GetTransferIdempotency :
+func (dao *GkvDB) GetTransferIdempotency(tokenAddress, target common.Address, identifier string) (*models.TransferIdempotency, error) {
+	var r models.TransferIdempotency
+	err := dao.getKeyValueToBucket(models.BucketTransferIdempotency, models.TransferIdempotencyKey(tokenAddress, target, identifier), &r)
+	return &r, err
+}
+
+// RemoveTransferIdempotencyBefore :
+func (dao *GkvDB) RemoveTransferIdempotencyBefore(timestamp int64) (n int, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketTransferIdempotency)
+	if err != nil {
+		return
+	}
+	buf := tb.Values(-1)
+	for _, v := range buf {
+		var r models.TransferIdempotency
+		gobDecode(v, &r)
+		//Values may still return removed ones
+		if r.Timestamp < timestamp && len(tb.Get(gobEncode(r.Key))) > 0 {
+			err = dao.removeKeyValueFromBucket(models.BucketTransferIdempotency, r.Key)
+			if err != nil {
+				return
+			}
+			n++
+		}
+	}
+	return
+}
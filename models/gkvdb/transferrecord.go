@@ -0,0 +1,42 @@
+package gkvdb
+
+import (
+	"gitee.com/johng/gkvdb/gkvdb"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTransferRecord :
+func (dao *GkvDB) SaveTransferRecord(r *models.TransferRecord) error {
+	r.Key = models.TransferRecordKey(r.TokenAddress, r.LockSecretHash)
+	return dao.saveKeyValueToBucket(models.BucketTransferRecord, r.Key, r)
+}
+
+// GetTransferRecord :
+func (dao *GkvDB) GetTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash) (*models.TransferRecord, error) {
+	var r models.TransferRecord
+	err := dao.getKeyValueToBucket(models.BucketTransferRecord, models.TransferRecordKey(tokenAddress, lockSecretHash), &r)
+	return &r, err
+}
+
+// GetTransferRecordsInPhase :
+func (dao *GkvDB) GetTransferRecordsInPhase(phase models.TransferPhase) (rs []*models.TransferRecord, err error) {
+	var tb *gkvdb.Table
+	tb, err = dao.db.Table(models.BucketTransferRecord)
+	if err != nil {
+		return
+	}
+	//Values may return an older copy of a record changed recently, so get every record by its key
+	for _, k := range tb.Keys(-1) {
+		v := tb.Get([]byte(k))
+		if len(v) == 0 {
+			continue
+		}
+		var r models.TransferRecord
+		gobDecode(v, &r)
+		if r.Phase == phase {
+			rs = append(rs, &r)
+		}
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/gob"
+)
+
+/*
+LearnedSecretHash 我们已经知道密码的 lockSecretHash, 包括作为发起方生成的密码, 以及通过 RevealSecret, unlock 或者链上注册得到的密码.
+再收到使用这个 lockSecretHash 的交易时必须拒绝, 否则对方不用付出任何代价就能让我们把密码交出去.
+*/
+/*
+ *	LearnedSecretHash : a lock secret hash whose secret we already know, either generated by us as initiator,
+ *	or learned from RevealSecret, unlock or registration on chain.
+ *	Transfers reusing such lock secret hash must be refused, otherwise anyone can get a free reveal from us.
+ */
+type LearnedSecretHash struct {
+	LockSecretHash []byte `storm:"id"`
+	Timestamp      int64  //unix seconds when we learned it
+}
+
+func init() {
+	gob.Register(&LearnedSecretHash{})
+}
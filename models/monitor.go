@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+MonitorDelegationStatus 委托监控的状态
+*/
+type MonitorDelegationStatus int
+
+const (
+	// MonitorDelegationWaiting 通道还没有关闭
+	MonitorDelegationWaiting = iota
+
+	// MonitorDelegationClosed partner 已经关闭通道,等待可以 updateBalanceProofDelegate 的块
+	MonitorDelegationClosed
+
+	// MonitorDelegationDone 已经在链上为委托人提交了证据,委托服务完成,Delegation 就是手续费的凭证
+	MonitorDelegationDone
+
+	// MonitorDelegationFailed 链上操作失败
+	MonitorDelegationFailed
+)
+
+/*
+MonitorDelegation :
+	delegation received by a monitor node, the client asks monitor to call
+	updateBalanceProofDelegate/unlockDelegate/punishObsoleteUnlock for him when his partner closes the channel.
+*/
+type MonitorDelegation struct {
+	Key               []byte `storm:"id"`
+	ChannelIdentifier common.Hash
+	OpenBlockNumber   int64
+	TokenAddress      common.Address
+	Client            common.Address //who delegates
+	Partner           common.Address
+	Fee               *big.Int //fee client promised
+	Delegation        []byte   //signed MonitorDelegate message,it's also client's fee commitment
+	ClosingAddress    common.Address
+	SettleBlockNumber int64
+	SettleTimeout     int64
+	Status            MonitorDelegationStatus
+	StatusMessage     string
+}
+
+func init() {
+	gob.Register(&MonitorDelegation{})
+}
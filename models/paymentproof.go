@@ -0,0 +1,241 @@
+package models
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+//PaymentProofVersion version of PaymentProof format
+const PaymentProofVersion = 1
+
+//SignedBalanceProof a balance proof with the signature of its sender, the same as what the contract accepts
+type SignedBalanceProof struct {
+	Nonce             uint64        `json:"nonce"`
+	ChannelIdentifier common.Hash   `json:"channel_identifier"`
+	OpenBlockNumber   int64         `json:"open_block_number"`
+	TransferAmount    *big.Int      `json:"transfer_amount"`
+	Locksroot         common.Hash   `json:"locksroot"`
+	MessageHash       common.Hash   `json:"message_hash"`
+	Signature         hexutil.Bytes `json:"signature"`
+}
+
+func (bp *SignedBalanceProof) balanceProof() *encoding.BalanceProof {
+	return &encoding.BalanceProof{
+		Nonce:             bp.Nonce,
+		ChannelIdentifier: bp.ChannelIdentifier,
+		OpenBlockNumber:   bp.OpenBlockNumber,
+		TransferAmount:    bp.TransferAmount,
+		Locksroot:         bp.Locksroot,
+	}
+}
+
+/*
+PaymentProof 一个交易已经完成的证明, 不依赖任何一方的日志和数据库, 离线就可以验证, 用于客服, 对账等协议以外的纠纷.
+证明的是通道上付款方(Payer)给收款方(Payee)的那一跳: 发起方保存的是它发给第一跳的, 接收方保存的是上一跳发给它的.
+1. LockedTransfer: 付款方签名的 MediatedTransfer, 包含锁
+2. BalanceProofBefore: Unlock 之前付款方最新的 balance proof, MerkleProof 证明锁在它的 locksroot 中
+3. Unlock: 付款方签名的 Unlock, 转账金额比 BalanceProofBefore 多出锁的金额
+4. RevealSecret: 对方发来的 RevealSecret, 可能没有
+5. Receipt: 接收方签名的 PaymentReceipt, 只有发起方有, 可能没有
+用 VerifyPaymentProof 验证.
+*/
+/*
+ *	PaymentProof : proof that a transfer completed, verifiable offline without trusting logs or databases of either party,
+ *	for disputes outside the protocol like customer support and accounting.
+ *	It proves the hop from Payer to Payee on their channel: initiator keeps the one to the first hop,
+ *	target keeps the one from the previous hop.
+ *	1. LockedTransfer: MediatedTransfer carrying the lock, signed by payer.
+ *	2. BalanceProofBefore: latest balance proof of payer before Unlock, MerkleProof proves the lock is in its locksroot.
+ *	3. Unlock: Unlock signed by payer, its transfer amount is BalanceProofBefore's plus amount of the lock.
+ *	4. RevealSecret: RevealSecret from the other party, optional.
+ *	5. Receipt: PaymentReceipt signed by target, only initiator has it, optional.
+ *	Verify it by VerifyPaymentProof.
+ */
+type PaymentProof struct {
+	Version            int                 `json:"version"`
+	ChainID            *big.Int            `json:"chain_id"`
+	TokenAddress       common.Address      `json:"token_address"`
+	LockSecretHash     common.Hash         `json:"lock_secret_hash"`
+	Secret             common.Hash         `json:"secret"`
+	Initiator          common.Address      `json:"initiator_address"`
+	Target             common.Address      `json:"target_address"`
+	Amount             *big.Int            `json:"amount"`
+	Payer              common.Address      `json:"payer"`
+	Payee              common.Address      `json:"payee"`
+	LockedTransfer     hexutil.Bytes       `json:"locked_transfer"`
+	BalanceProofBefore *SignedBalanceProof `json:"balance_proof_before"`
+	MerkleProof        []common.Hash       `json:"merkle_proof"`
+	Unlock             hexutil.Bytes       `json:"unlock"`
+	RevealSecret       hexutil.Bytes       `json:"reveal_secret,omitempty"`
+	Receipt            hexutil.Bytes       `json:"receipt,omitempty"`
+}
+
+//errPaymentProofIncomplete transfer record doesn't have all messages of a payment proof
+var errPaymentProofIncomplete = errors.New("payment proof is incomplete, only transfers completed as initiator or target have it")
+
+/*
+ExportPaymentProof 导出成功完成的交易的证明, 只有发起方和接收方在交易过程中保存了证明需要的消息.
+*/
+/*
+ *	ExportPaymentProof : export proof of a transfer which completed successfully,
+ *	only initiator and target keep messages needed by the proof while the transfer goes on.
+ */
+func (r *TransferRecord) ExportPaymentProof(chainID *big.Int) (*PaymentProof, error) {
+	if r.Phase != TransferPhaseSuccess {
+		return nil, fmt.Errorf("transfer is %s, not success", r.Phase)
+	}
+	p := r.Proof
+	if p == nil || len(p.LockedTransfer) == 0 || len(p.Unlock) == 0 || p.BalanceProofBefore == nil {
+		return nil, errPaymentProofIncomplete
+	}
+	proof := *p
+	proof.Version = PaymentProofVersion
+	proof.ChainID = new(big.Int).Set(chainID)
+	proof.TokenAddress = r.TokenAddress
+	proof.Initiator = r.Initiator
+	proof.Target = r.Target
+	proof.Amount = r.Amount
+	proof.Receipt = r.Receipt
+	return &proof, nil
+}
+
+//PaymentProofCheck result of one check of VerifyPaymentProof
+type PaymentProofCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+//PaymentProofVerdict result of VerifyPaymentProof, Valid only if every check passed
+type PaymentProofVerdict struct {
+	Valid  bool                 `json:"valid"`
+	Checks []*PaymentProofCheck `json:"checks"`
+}
+
+//check records result of a check, err is appended to detail if not nil
+func (v *PaymentProofVerdict) check(name string, ok bool, err error, format string, a ...interface{}) bool {
+	detail := fmt.Sprintf(format, a...)
+	if err != nil {
+		ok = false
+		detail = fmt.Sprintf("%s, err=%s", detail, err)
+	}
+	v.Checks = append(v.Checks, &PaymentProofCheck{
+		Name:   name,
+		OK:     ok,
+		Detail: detail,
+	})
+	if !ok {
+		v.Valid = false
+	}
+	return ok
+}
+
+//String human readable verdict, one line per check
+func (v *PaymentProofVerdict) String() string {
+	buf := new(bytes.Buffer)
+	for _, c := range v.Checks {
+		status := "OK  "
+		if !c.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(buf, "[%s] %-22s %s\n", status, c.Name, c.Detail)
+	}
+	if v.Valid {
+		fmt.Fprintf(buf, "verdict: VALID, the payment occurred\n")
+	} else {
+		fmt.Fprintf(buf, "verdict: INVALID, the payment is not proven\n")
+	}
+	return buf.String()
+}
+
+/*
+VerifyPaymentProof 离线验证 PaymentProof, 检查:
+1. LockedTransfer, BalanceProofBefore 和 Unlock 都是 Payer 在同一个通道上签名的
+2. 锁的 LockSecretHash 和 Secret 一致, 锁通过 MerkleProof 包含在 BalanceProofBefore 的 locksroot 中
+3. Unlock 紧接着 BalanceProofBefore, 转账金额正好增加了锁的金额, 锁的金额不少于交易金额
+4. 如果有 RevealSecret 和 Receipt, 它们的签名和内容也必须正确
+任何一项不通过, 证明无效.
+*/
+/*
+ *	VerifyPaymentProof : verify a PaymentProof offline, it checks
+ *	1. LockedTransfer, BalanceProofBefore and Unlock are all signed by Payer on the same channel.
+ *	2. LockSecretHash of the lock matches Secret, and MerkleProof proves the lock is in locksroot of BalanceProofBefore.
+ *	3. Unlock comes right after BalanceProofBefore and increases transfer amount by exactly amount of the lock,
+ *		which is not less than amount of the transfer.
+ *	4. RevealSecret and Receipt, if present, are correctly signed and match too.
+ *	The proof is invalid if any check fails.
+ */
+func VerifyPaymentProof(p *PaymentProof) *PaymentProofVerdict {
+	v := &PaymentProofVerdict{Valid: true}
+	if !v.check("format", p.Version == PaymentProofVersion && p.ChainID != nil && p.Amount != nil && p.BalanceProofBefore != nil, nil,
+		"version=%d, chain id=%s", p.Version, p.ChainID) {
+		return v
+	}
+	//locked transfer
+	mtr := new(encoding.MediatedTransfer)
+	err := mtr.UnPack(p.LockedTransfer)
+	if !v.check("locked transfer", true, err, "MediatedTransfer with nonce %d", mtr.Nonce) {
+		return v
+	}
+	signer, err := encoding.RecoverEnvelopMessageSigner(mtr, p.LockedTransfer, p.ChainID)
+	v.check("locked transfer signer", signer == p.Payer, err, "signed by %s, payer %s", signer.String(), p.Payer.String())
+	lock := mtr.GetLock()
+	v.check("locked transfer content", mtr.LockSecretHash == p.LockSecretHash && mtr.Initiator == p.Initiator && mtr.Target == p.Target, nil,
+		"lock secret hash=%s, initiator=%s, target=%s", mtr.LockSecretHash.String(), mtr.Initiator.String(), mtr.Target.String())
+	v.check("secret", lock.MatchSecret(p.Secret), nil, "secret %s of lock secret hash %s", p.Secret.String(), lock.LockSecretHash.String())
+	v.check("amount", lock.Amount != nil && lock.Amount.Cmp(p.Amount) >= 0, nil, "lock amount %s, transfer amount %s", lock.Amount, p.Amount)
+	//balance proof before unlock
+	before := p.BalanceProofBefore
+	bp := before.balanceProof()
+	signer, err = encoding.RecoverBalanceProofSigner(bp, before.MessageHash, before.Signature, p.ChainID)
+	v.check("balance proof before", signer == p.Payer && before.Nonce >= mtr.Nonce &&
+		before.ChannelIdentifier == mtr.ChannelIdentifier && before.OpenBlockNumber == mtr.OpenBlockNumber, err,
+		"nonce=%d, transfer amount=%s, signed by %s", before.Nonce, before.TransferAmount, signer.String())
+	v.check("merkle proof", mtree.VerifyProof(before.Locksroot, p.MerkleProof, lock.Hash()), nil,
+		"lock %s in locksroot %s", lock.Hash().String(), before.Locksroot.String())
+	//unlock
+	unlock := new(encoding.UnLock)
+	err = unlock.UnPack(p.Unlock)
+	if v.check("unlock", true, err, "Unlock with nonce %d", unlock.Nonce) {
+		signer, err = encoding.RecoverEnvelopMessageSigner(unlock, p.Unlock, p.ChainID)
+		v.check("unlock signer", signer == p.Payer && unlock.ChannelIdentifier == mtr.ChannelIdentifier &&
+			unlock.OpenBlockNumber == mtr.OpenBlockNumber, err, "signed by %s", signer.String())
+		v.check("unlock secret", unlock.LockSecret == p.Secret, nil, "secret %s", unlock.LockSecret.String())
+		expected := new(big.Int)
+		if before.TransferAmount != nil && lock.Amount != nil {
+			expected.Add(before.TransferAmount, lock.Amount)
+		}
+		v.check("unlock amount", unlock.Nonce == before.Nonce+1 && unlock.TransferAmount.Cmp(expected) == 0, nil,
+			"nonce %d->%d, transfer amount %s + %s = %s", before.Nonce, unlock.Nonce, before.TransferAmount, lock.Amount, unlock.TransferAmount)
+	}
+	//optional
+	if len(p.RevealSecret) > 0 {
+		rs := new(encoding.RevealSecret)
+		err = rs.UnPack(p.RevealSecret)
+		v.check("reveal secret", rs.LockSecret == p.Secret && (rs.Sender == p.Payer || rs.Sender == p.Payee), err,
+			"secret revealed by %s", rs.Sender.String())
+	}
+	if len(p.Receipt) > 0 {
+		receipt, err := encoding.VerifyPaymentReceipt(p.Receipt)
+		if err != nil {
+			v.check("receipt", false, err, "invalid PaymentReceipt")
+		} else {
+			v.check("receipt", receipt.Sender == p.Target && receipt.Initiator == p.Initiator &&
+				receipt.TokenAddress == p.TokenAddress && receipt.Amount.Cmp(p.Amount) == 0, nil,
+				"target %s received %s of token %s", receipt.Sender.String(), receipt.Amount, receipt.TokenAddress.String())
+		}
+	}
+	return v
+}
+
+func init() {
+	gob.Register(&PaymentProof{})
+}
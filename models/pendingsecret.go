@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+PendingSecret 等待在链上注册的密码, 收到的锁快要过期而上家一直不给 balance proof 时产生,
+注册成功或者锁过期以后删除. 保存下来是为了重启以后继续注册.
+*/
+/*
+ *	PendingSecret : a secret waiting to be registered on chain, because the incoming lock is about to expire
+ *	and the payer still doesn't send the balance proof. It's removed after registered or the lock expired,
+ *	and kept in db so registration goes on after restart.
+ */
+type PendingSecret struct {
+	Key            []byte      `storm:"id"`
+	Secret         common.Hash //secret to register
+	LockExpiration int64       //expiration of the incoming lock, 0 means unknown
+	RevealTimeout  int         //reveal timeout of the payer channel
+}
+
+func init() {
+	gob.Register(&PendingSecret{})
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/gob"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+RevealTimeoutPolicy :
+不同 token 的风险不同, reveal timeout 可以按 token 设置, 也可以为单个通道设置.
+优先级: 通道 > token > 启动参数 --reveal-timeout
+*/
+/*
+ *	RevealTimeoutPolicy : tokens have different risk profiles, reveal timeout can be set per token,
+ *	and overridden per channel.
+ *	Priority: channel > token > startup param --reveal-timeout
+ */
+type RevealTimeoutPolicy struct {
+	Key                     string                 `storm:"id"`
+	TokenRevealTimeoutMap   map[common.Address]int `json:"token_reveal_timeout_map"`
+	ChannelRevealTimeoutMap map[common.Hash]int    `json:"channel_reveal_timeout_map"`
+}
+
+//NewDefaultRevealTimeoutPolicy use startup param for all channels
+func NewDefaultRevealTimeoutPolicy() *RevealTimeoutPolicy {
+	return &RevealTimeoutPolicy{
+		TokenRevealTimeoutMap:   make(map[common.Address]int),
+		ChannelRevealTimeoutMap: make(map[common.Hash]int),
+	}
+}
+
+//RevealTimeout returns reveal timeout for this channel, 0 means not set
+func (p *RevealTimeoutPolicy) RevealTimeout(tokenAddress common.Address, channelIdentifier common.Hash) int {
+	if t, ok := p.ChannelRevealTimeoutMap[channelIdentifier]; ok {
+		return t
+	}
+	return p.TokenRevealTimeoutMap[tokenAddress]
+}
+
+func init() {
+	gob.Register(&RevealTimeoutPolicy{})
+}
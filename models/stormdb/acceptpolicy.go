@@ -0,0 +1,30 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+)
+
+// SaveAcceptPolicy :
+func (model *StormDB) SaveAcceptPolicy(p *models.AcceptPolicy) (err error) {
+	p.Key = models.KeyAcceptPolicy
+	err = model.db.Save(p)
+	return
+}
+
+// GetAcceptPolicy :
+func (model *StormDB) GetAcceptPolicy() (p *models.AcceptPolicy) {
+	p = &models.AcceptPolicy{}
+	err := model.db.One("Key", models.KeyAcceptPolicy, p)
+	if err == storm.ErrNotFound {
+		return models.NewDefaultAcceptPolicy()
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("GetAcceptPolicy err %s, use default", err))
+		return models.NewDefaultAcceptPolicy()
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package stormdb
+
+import (
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MarkLockSecretHashLearned :
+func (model *StormDB) MarkLockSecretHashLearned(lockSecretHash common.Hash) error {
+	return model.db.Save(&models.LearnedSecretHash{
+		LockSecretHash: lockSecretHash[:],
+		Timestamp:      time.Now().Unix(),
+	})
+}
+
+// IsLockSecretHashLearned :
+func (model *StormDB) IsLockSecretHashLearned(lockSecretHash common.Hash) bool {
+	var r models.LearnedSecretHash
+	err := model.db.One("LockSecretHash", lockSecretHash[:], &r)
+	return err == nil
+}
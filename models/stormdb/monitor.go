@@ -0,0 +1,48 @@
+package stormdb
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveMonitorDelegation :
+func (model *StormDB) SaveMonitorDelegation(d *models.MonitorDelegation) error {
+	d.Key = d.ChannelIdentifier[:]
+	return model.db.Save(d)
+}
+
+// GetMonitorDelegation :
+func (model *StormDB) GetMonitorDelegation(channelIdentifier common.Hash) (*models.MonitorDelegation, error) {
+	var d models.MonitorDelegation
+	err := model.db.One("Key", channelIdentifier[:], &d)
+	return &d, err
+}
+
+// GetAllMonitorDelegation :
+func (model *StormDB) GetAllMonitorDelegation() (ds []*models.MonitorDelegation, err error) {
+	err = model.db.All(&ds)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// SaveMonitorFee :
+func (model *StormDB) SaveMonitorFee(fee *big.Int) error {
+	return model.db.Set(models.BucketMonitor, models.KeyMonitorFee, fee)
+}
+
+// GetMonitorFee : nil means i'm not a monitor
+func (model *StormDB) GetMonitorFee() *big.Int {
+	var fee *big.Int
+	err := model.db.Get(models.BucketMonitor, models.KeyMonitorFee, &fee)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(fmt.Sprintf("GetMonitorFee err %s", err))
+	}
+	return fee
+}
@@ -0,0 +1,31 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SavePendingSecret :
+func (model *StormDB) SavePendingSecret(p *models.PendingSecret) error {
+	p.Key = p.Secret[:]
+	return model.db.Save(p)
+}
+
+// RemovePendingSecret :
+func (model *StormDB) RemovePendingSecret(secret common.Hash) error {
+	err := model.db.DeleteStruct(&models.PendingSecret{Key: secret[:]})
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
+// GetAllPendingSecrets :
+func (model *StormDB) GetAllPendingSecrets() (ps []*models.PendingSecret, err error) {
+	err = model.db.All(&ps)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
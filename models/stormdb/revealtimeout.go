@@ -0,0 +1,30 @@
+package stormdb
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+)
+
+// SaveRevealTimeoutPolicy :
+func (model *StormDB) SaveRevealTimeoutPolicy(p *models.RevealTimeoutPolicy) (err error) {
+	p.Key = models.KeyRevealTimeoutPolicy
+	err = model.db.Save(p)
+	return
+}
+
+// GetRevealTimeoutPolicy :
+func (model *StormDB) GetRevealTimeoutPolicy() (p *models.RevealTimeoutPolicy) {
+	p = &models.RevealTimeoutPolicy{}
+	err := model.db.One("Key", models.KeyRevealTimeoutPolicy, p)
+	if err == storm.ErrNotFound {
+		return models.NewDefaultRevealTimeoutPolicy()
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("GetRevealTimeoutPolicy err %s, use default", err))
+		return models.NewDefaultRevealTimeoutPolicy()
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTopUpPolicy :
+func (model *StormDB) SaveTopUpPolicy(p *models.TopUpPolicy) error {
+	p.Key = p.ChannelIdentifier[:]
+	return model.db.Save(p)
+}
+
+// GetTopUpPolicy :
+func (model *StormDB) GetTopUpPolicy(channelIdentifier common.Hash) (*models.TopUpPolicy, error) {
+	var p models.TopUpPolicy
+	err := model.db.One("Key", channelIdentifier[:], &p)
+	return &p, err
+}
+
+// GetAllTopUpPolicy :
+func (model *StormDB) GetAllTopUpPolicy() (ps []*models.TopUpPolicy, err error) {
+	err = model.db.All(&ps)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+// RemoveTopUpPolicy :
+func (model *StormDB) RemoveTopUpPolicy(channelIdentifier common.Hash) error {
+	return model.db.DeleteStruct(&models.TopUpPolicy{Key: channelIdentifier[:]})
+}
+
+// SaveTopUpRecord :
+func (model *StormDB) SaveTopUpRecord(r *models.TopUpRecord) error {
+	if len(r.Key) == 0 {
+		key := utils.NewRandomHash()
+		r.Key = key[:]
+	}
+	return model.db.Save(r)
+}
+
+// GetTopUpRecords :
+func (model *StormDB) GetTopUpRecords(channelIdentifier common.Hash) (rs []*models.TopUpRecord, err error) {
+	var all []*models.TopUpRecord
+	err = model.db.All(&all)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	for _, r := range all {
+		if r.ChannelIdentifier == channelIdentifier {
+			rs = append(rs, r)
+		}
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTransferIdempotency :
+func (model *StormDB) SaveTransferIdempotency(r *models.TransferIdempotency) error {
+	r.Key = models.TransferIdempotencyKey(r.TokenAddress, r.Target, r.Identifier)
+	return model.db.Save(r)
+}
+
+// GetTransferIdempotency :
+func (model *StormDB) GetTransferIdempotency(tokenAddress, target common.Address, identifier string) (*models.TransferIdempotency, error) {
+	var r models.TransferIdempotency
+	err := model.db.One("Key", models.TransferIdempotencyKey(tokenAddress, target, identifier), &r)
+	return &r, err
+}
+
+// RemoveTransferIdempotencyBefore :
+func (model *StormDB) RemoveTransferIdempotencyBefore(timestamp int64) (n int, err error) {
+	var rs []*models.TransferIdempotency
+	err = model.db.Select(q.Lt("Timestamp", timestamp)).Find(&rs)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, r := range rs {
+		err = model.db.DeleteStruct(r)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
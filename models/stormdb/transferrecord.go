@@ -0,0 +1,29 @@
+package stormdb
+
+import (
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SaveTransferRecord :
+func (model *StormDB) SaveTransferRecord(r *models.TransferRecord) error {
+	r.Key = models.TransferRecordKey(r.TokenAddress, r.LockSecretHash)
+	return model.db.Save(r)
+}
+
+// GetTransferRecord :
+func (model *StormDB) GetTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash) (*models.TransferRecord, error) {
+	var r models.TransferRecord
+	err := model.db.One("Key", models.TransferRecordKey(tokenAddress, lockSecretHash), &r)
+	return &r, err
+}
+
+// GetTransferRecordsInPhase :
+func (model *StormDB) GetTransferRecordsInPhase(phase models.TransferPhase) (rs []*models.TransferRecord, err error) {
+	err = model.db.Find("Phase", phase, &rs)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
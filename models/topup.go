@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+TopUpPolicy 通道自动充值策略:
+可用余额低于 LowWatermark 时, 自动存入押金使可用余额达到 Target, 每天自动存入的总额不超过 DailyLimit.
+*/
+/*
+ *	TopUpPolicy : automatic deposit policy of a channel.
+ *	When distributable drops below LowWatermark, node deposits to bring it up to Target,
+ *	the total amount deposited automatically in one day (UTC) never exceeds DailyLimit.
+ */
+type TopUpPolicy struct {
+	Key               []byte         `json:"-" storm:"id"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	LowWatermark      *big.Int       `json:"low_watermark"`
+	Target            *big.Int       `json:"target"`
+	DailyLimit        *big.Int       `json:"daily_limit"`
+	Paused            bool           `json:"paused"`        //funding account has not enough token
+	PauseReason       string         `json:"pause_reason"` //why paused
+}
+
+/*
+TopUpStatus 自动充值的状态
+*/
+type TopUpStatus int
+
+const (
+	// TopUpPending deposit tx is sent, waiting to be mined
+	TopUpPending TopUpStatus = iota
+	// TopUpSuccess deposit success
+	TopUpSuccess
+	// TopUpFailed deposit tx failed
+	TopUpFailed
+)
+
+//TopUpRecord is the history of automatic deposits of a channel
+type TopUpRecord struct {
+	Key               []byte         `json:"-" storm:"id"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	Amount            *big.Int       `json:"amount"`
+	Distributable     *big.Int       `json:"distributable"` //distributable before deposit
+	BlockNumber       int64          `json:"block_number"`
+	Timestamp         int64          `json:"timestamp"`
+	Status            TopUpStatus    `json:"status"`
+	StatusMessage     string         `json:"status_message"`
+}
+
+func init() {
+	gob.Register(&TopUpPolicy{})
+	gob.Register(&TopUpRecord{})
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+TransferIdempotency 客户端超时重试时可能重复付款, 所以交易请求可以携带客户端生成的标识.
+标识在 (token, target) 范围内唯一, 同一个标识的请求只发起一次交易, 之后的请求返回原交易的状态.
+保留一段时间以后从去重索引中删除.
+*/
+/*
+ *	TransferIdempotency : clients retrying after timeout may pay twice, so a transfer request can carry a client generated identifier.
+ *	Identifier is scoped per (token, target), only the first request with it starts a transfer,
+ *	later ones get status of that transfer. It expires from the dedup index after retention period.
+ */
+type TransferIdempotency struct {
+	Key            []byte `storm:"id"`
+	TokenAddress   common.Address
+	Target         common.Address
+	Identifier     string
+	Amount         *big.Int
+	LockSecretHash common.Hash //FakeLockSecretHash for direct transfer
+	DirectTransfer bool
+	Timestamp      int64 //unix seconds when transfer is started
+}
+
+//TransferIdempotencyKey key of identifier in dedup index
+func TransferIdempotencyKey(tokenAddress, target common.Address, identifier string) []byte {
+	key := utils.Sha3(tokenAddress[:], target[:], []byte(identifier))
+	return key[:]
+}
+
+func init() {
+	gob.Register(&TransferIdempotency{})
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/gob"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+//TransferRole role of this node in a transfer
+type TransferRole string
+
+const (
+	//TransferRoleInitiator we started the transfer
+	TransferRoleInitiator TransferRole = "initiator"
+	//TransferRoleMediator we mediate the transfer
+	TransferRoleMediator TransferRole = "mediator"
+	//TransferRoleTarget we receive the transfer
+	TransferRoleTarget TransferRole = "target"
+)
+
+//TransferPhase where a transfer is in its life
+type TransferPhase string
+
+const (
+	//TransferPhaseQueued initiator is waiting for locks on the channel of the best route to be resolved
+	TransferPhaseQueued TransferPhase = "queued"
+	//TransferPhaseRouting initiator is looking for a route
+	TransferPhaseRouting TransferPhase = "routing"
+	//TransferPhaseWaitingSecretRequest initiator sent the lock, waiting for SecretRequest of target
+	TransferPhaseWaitingSecretRequest TransferPhase = "waiting_secret_request"
+	//TransferPhaseWaitingReveal waiting for RevealSecret
+	TransferPhaseWaitingReveal TransferPhase = "waiting_reveal"
+	//TransferPhaseWaitingUnlock secret is known, waiting for the lock to be unlocked
+	TransferPhaseWaitingUnlock TransferPhase = "waiting_unlock"
+	//TransferPhaseSuccess transfer success
+	TransferPhaseSuccess TransferPhase = "success"
+	//TransferPhaseFailed transfer failed, see FailureReason
+	TransferPhaseFailed TransferPhase = "failed"
+)
+
+//TransferFailureReason why a transfer failed
+type TransferFailureReason string
+
+const (
+	//TransferFailureNoRoute no route to target
+	TransferFailureNoRoute TransferFailureReason = "no_route"
+	//TransferFailureInsufficientCapacity there are routes, but none has enough balance
+	TransferFailureInsufficientCapacity TransferFailureReason = "insufficient_capacity"
+	//TransferFailureTargetOffline target is offline
+	TransferFailureTargetOffline TransferFailureReason = "target_offline"
+	//TransferFailureLockExpired lock expired before secret revealed or unlocked
+	TransferFailureLockExpired TransferFailureReason = "lock_expired"
+	//TransferFailureRefusedByTarget target sent back AnnounceDisposed
+	TransferFailureRefusedByTarget TransferFailureReason = "refused_by_target"
+	//TransferFailureDeadlineExceeded deadline of transfer passed
+	TransferFailureDeadlineExceeded TransferFailureReason = "deadline_exceeded"
+	//TransferFailureCanceled canceled by user
+	TransferFailureCanceled TransferFailureReason = "canceled"
+	//TransferFailureRefusedByMediator next hop sent back AnnounceDisposed, another route is tried
+	TransferFailureRefusedByMediator TransferFailureReason = "refused_by_mediator"
+	//TransferFailureAckTimeout first hop didn't ack the MediatedTransfer in time, the transfer is started again with a fresh secret
+	TransferFailureAckTimeout TransferFailureReason = "ack_timeout"
+	//TransferFailureMaxRouteAttempts too many routes refused the transfer
+	TransferFailureMaxRouteAttempts TransferFailureReason = "max_route_attempts"
+	//TransferFailureFeeCapExceeded every route charges more than max fee of the transfer
+	TransferFailureFeeCapExceeded TransferFailureReason = "fee_cap_exceeded"
+	//TransferFailureInitiatorNotAccepted target refused the transfer because of its accept policy, see AcceptPolicy
+	TransferFailureInitiatorNotAccepted TransferFailureReason = "initiator_not_accepted"
+	//TransferFailureRestarted photon restarted while the transfer was queued, it never started, see TransferPhaseQueued
+	TransferFailureRestarted TransferFailureReason = "restarted"
+)
+
+//HopFee fee charged by a mediator
+type HopFee struct {
+	Hop common.Address `json:"hop"`
+	Fee *big.Int       `json:"fee"`
+}
+
+//TransferAttempt one route tried by initiator, FailureReason is empty if it's still in use or succeeded
+type TransferAttempt struct {
+	Route          []common.Address      `json:"route"`
+	FailureReason  TransferFailureReason `json:"failure_reason,omitempty"`
+	FailureMessage string                `json:"failure_message,omitempty"`
+}
+
+/*
+TransferRecord 每个发起, 中转和接收的交易的记录, 由状态机的事件更新, 用于查询交易进行到哪一步, 失败的原因.
+Route 是本节点知道的那一段实际路径, 按从发起方到接收方的顺序; 发起方只有使用 PFS 时才知道完整路径,
+中转节点只知道上一跳和下一跳, 接收方只知道上一跳.
+HopFees 只包含本节点知道的每一跳手续费, PFS 只返回总手续费, 所以发起方一般只知道 Fee.
+Attempts 是发起方尝试过的每一条路由以及失败的原因.
+*/
+/*
+ *	TransferRecord : record of every initiated, mediated and received transfer, updated by events of state machines,
+ *	so users can find out where a transfer is and why it failed.
+ *	Route is the part of the path actually used known to this node, in order from initiator to target,
+ *	initiator knows the whole path only if it comes from PFS, mediators know previous and next hop, target knows previous hop.
+ *	HopFees has only fees of hops known to this node, PFS returns total fee only, so initiator usually knows only Fee.
+ *	Attempts are routes tried by initiator and why each of them failed.
+ */
+type TransferRecord struct {
+	Key            []byte                `json:"-" storm:"id"`
+	LockSecretHash common.Hash           `json:"lock_secret_hash"`
+	TokenAddress   common.Address        `json:"token_address"`
+	Role           TransferRole          `json:"role"`
+	Initiator      common.Address        `json:"initiator_address"`
+	Target         common.Address        `json:"target_address"`
+	Amount         *big.Int              `json:"amount"`
+	Fee            *big.Int              `json:"fee,omitempty"`
+	Route          []common.Address      `json:"route,omitempty"`
+	HopFees        []HopFee              `json:"hop_fees,omitempty"`
+	Attempts       []TransferAttempt     `json:"attempts,omitempty"`
+	Phase          TransferPhase         `json:"phase"`
+	QueuePosition  int                   `json:"queue_position,omitempty"` //position in the queue of the channel when Phase is queued, starts from 1
+	FailureReason  TransferFailureReason `json:"failure_reason,omitempty"`
+	FailureMessage string                `json:"failure_message,omitempty"`
+	Receipt        []byte                `json:"-"` //packed PaymentReceipt signed by target, initiator only
+	CreateTime     int64                 `json:"create_time"`
+	UpdateTime     int64                 `json:"update_time"`
+	Deadline       int64                 `json:"deadline,omitempty"` //unix time of wall-clock deadline, initiator only, 0 means no deadline
+	//Metadata carried from initiator to target, signed by initiator, mediators don't keep it
+	Metadata hexutil.Bytes `json:"metadata,omitempty"`
+	//Proof messages kept for ExportPaymentProof, initiator and target only
+	Proof *PaymentProof `json:"-"`
+}
+
+//TransferRecordKey key of transfer record
+func TransferRecordKey(tokenAddress common.Address, lockSecretHash common.Hash) []byte {
+	key := utils.Sha3(tokenAddress[:], lockSecretHash[:])
+	return key[:]
+}
+
+//Finished transfer is success or failed, won't change any more
+func (r *TransferRecord) Finished() bool {
+	return r.Phase == TransferPhaseSuccess || r.Phase == TransferPhaseFailed
+}
+
+func init() {
+	gob.Register(&TransferRecord{})
+}
@@ -0,0 +1,352 @@
+package photon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+委托第三方监控通道:
+1. 委托人生成 ChannelFor3rd, 连同承诺的手续费一起签名, 通过 MonitorDelegate 消息发给监控节点
+2. 监控节点验证以后保存, 收到 ack 以后委托人通过普通交易支付手续费
+3. 监控节点发现 partner 关闭通道, 在结算时间的后一半(合约要求)调用 updateBalanceProofDelegate 和 unlockDelegate
+4. partner 链上 unlock 了委托人声明放弃的锁, 监控节点调用 punishObsoleteUnlock
+5. 证据提交以后委托完成, 签名的 MonitorDelegate 消息就是手续费的凭证
+*/
+/*
+ *	Delegate channel monitoring to a monitor node:
+ *	1. client builds ChannelFor3rd, signs it together with the promised fee, and sends it with MonitorDelegate message.
+ *	2. monitor validates and stores it, after the ack client pays the fee with a normal transfer.
+ *	3. when partner closes the channel, monitor calls updateBalanceProofDelegate and unlockDelegate
+ *		in the second half of settle window, as contract requires.
+ *	4. when partner unlocks a lock client has disposed, monitor calls punishObsoleteUnlock.
+ *	5. after proofs are submitted, the delegation is done, the signed MonitorDelegate message is the receipt of fee.
+ */
+
+var errNotMonitor = errors.New("this node is not a monitor")
+var errMonitorNotAccepted = errors.New("monitor doesn't accept the delegation in time")
+
+//handleMonitorDelegate validates and saves a delegation from client
+func (rs *Service) handleMonitorDelegate(msg *encoding.MonitorDelegate) (err error) {
+	fee := rs.dao.GetMonitorFee()
+	if fee == nil {
+		return errNotMonitor
+	}
+	if msg.Fee == nil || msg.Fee.Cmp(fee) < 0 {
+		return fmt.Errorf("monitor fee too low, expect=%s,got=%s", fee, msg.Fee)
+	}
+	c3 := new(ChannelFor3rd)
+	err = json.Unmarshal(msg.Data, c3)
+	if err != nil {
+		return
+	}
+	if c3.ChannelIdentifier != msg.ChannelIdentifier || c3.OpenBlockNumber != msg.OpenBlockNumber ||
+		c3.TokenAddrss != msg.TokenAddress || c3.PartnerAddress != msg.PartnerAddress {
+		return fmt.Errorf("monitor delegate data mismatch, msg=%s", msg)
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(msg.TokenAddress)
+	if err != nil {
+		return
+	}
+	channelID, _, openBlockNumber, state, _, err := tokenNetwork.GetChannelInfo(msg.Sender, msg.PartnerAddress)
+	if err != nil {
+		return
+	}
+	//state 1 means opened
+	if channelID != msg.ChannelIdentifier || int64(openBlockNumber) != msg.OpenBlockNumber || state != 1 {
+		return fmt.Errorf("monitor delegate channel %s not match the one on chain", utils.HPex(msg.ChannelIdentifier))
+	}
+	if c3.UpdateTransfer.Nonce > 0 {
+		data := balanceProofDataFor3rd(c3.UpdateTransfer.TransferAmount, c3.UpdateTransfer.Locksroot,
+			c3.UpdateTransfer.Nonce, c3.ChannelIdentifier, c3.OpenBlockNumber)
+		signer, err2 := utils.Ecrecover(utils.Sha3(data), c3.UpdateTransfer.NonClosingSignature)
+		if err2 != nil || signer != msg.Sender {
+			return fmt.Errorf("monitor delegate non closing signature error, signer=%s,err=%v", utils.APex(signer), err2)
+		}
+	}
+	old, err := rs.dao.GetMonitorDelegation(msg.ChannelIdentifier)
+	if err == nil && old.OpenBlockNumber == msg.OpenBlockNumber && old.Status != models.MonitorDelegationWaiting {
+		return fmt.Errorf("channel %s already closed", utils.HPex(msg.ChannelIdentifier))
+	}
+	d := &models.MonitorDelegation{
+		ChannelIdentifier: msg.ChannelIdentifier,
+		OpenBlockNumber:   msg.OpenBlockNumber,
+		TokenAddress:      msg.TokenAddress,
+		Client:            msg.Sender,
+		Partner:           msg.PartnerAddress,
+		Fee:               msg.Fee,
+		Delegation:        msg.Pack(),
+		Status:            models.MonitorDelegationWaiting,
+	}
+	log.Info(fmt.Sprintf("receive monitor delegate %s", msg))
+	return rs.dao.SaveMonitorDelegation(d)
+}
+
+//monitorChannelClosed a monitored channel is closed, query when we can update balance proof for client.
+func (rs *Service) monitorChannelClosed(st *mediatedtransfer.ContractClosedStateChange) {
+	d, err := rs.dao.GetMonitorDelegation(st.ChannelIdentifier)
+	if err != nil || d.Status != models.MonitorDelegationWaiting {
+		return
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(d.TokenAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("monitorChannelClosed get token network %s err %s", utils.APex(d.TokenAddress), err))
+		return
+	}
+	_, settleBlockNumber, _, _, settleTimeout, err := tokenNetwork.GetChannelInfo(d.Client, d.Partner)
+	if err != nil {
+		log.Error(fmt.Sprintf("monitorChannelClosed GetChannelInfo %s err %s", utils.HPex(d.ChannelIdentifier), err))
+		return
+	}
+	d.ClosingAddress = st.ClosingAddress
+	d.SettleBlockNumber = int64(settleBlockNumber)
+	d.SettleTimeout = int64(settleTimeout)
+	d.Status = models.MonitorDelegationClosed
+	err = rs.dao.SaveMonitorDelegation(d)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveMonitorDelegation err %s", err))
+		return
+	}
+	if rs.monitorDue != nil {
+		rs.monitorDue[d.ChannelIdentifier] = monitorDueBlock(d)
+	}
+}
+
+//monitorDueBlock the first block we can submit proofs for client, contract requires the second half of settle window
+func monitorDueBlock(d *models.MonitorDelegation) int64 {
+	return d.SettleBlockNumber - d.SettleTimeout/2
+}
+
+/*
+monitorNewBlock 在结算时间的后一半为委托人提交证据.
+需要处理的通道保存在 monitorDue 中, 第一次调用时从数据库加载, 之后由 monitorChannelClosed 添加, 不用每个块都读数据库.
+只在处理事件的 goroutine 中调用.
+*/
+/*
+ *	monitorNewBlock : submit proofs for client when we are in the second half of settle window.
+ *	Channels to handle are kept in monitorDue, loaded from db at the first call, then added by monitorChannelClosed,
+ *	so db is not read every block.
+ *	Only called in the goroutine handling events.
+ */
+func (rs *Service) monitorNewBlock(blockNumber int64) {
+	if rs.monitorDue == nil {
+		rs.monitorDue = make(map[common.Hash]int64)
+		if rs.dao.GetMonitorFee() == nil {
+			return
+		}
+		ds, err := rs.dao.GetAllMonitorDelegation()
+		if err != nil {
+			log.Error(fmt.Sprintf("GetAllMonitorDelegation err %s", err))
+			return
+		}
+		for _, d := range ds {
+			if d.Status == models.MonitorDelegationClosed {
+				rs.monitorDue[d.ChannelIdentifier] = monitorDueBlock(d)
+			}
+		}
+	}
+	for channelIdentifier, due := range rs.monitorDue {
+		if blockNumber < due {
+			continue
+		}
+		delete(rs.monitorDue, channelIdentifier)
+		d, err := rs.dao.GetMonitorDelegation(channelIdentifier)
+		if err != nil || d.Status != models.MonitorDelegationClosed {
+			continue
+		}
+		if blockNumber > d.SettleBlockNumber {
+			d.Status = models.MonitorDelegationFailed
+			d.StatusMessage = "settle window missed"
+			err = rs.dao.SaveMonitorDelegation(d)
+			continue
+		}
+		d.Status = models.MonitorDelegationDone
+		err = rs.dao.SaveMonitorDelegation(d)
+		if err != nil {
+			log.Error(fmt.Sprintf("SaveMonitorDelegation err %s", err))
+			continue
+		}
+		go rs.monitorSubmitProofs(d)
+	}
+}
+
+func decodeMonitorDelegation(d *models.MonitorDelegation) (c3 *ChannelFor3rd, err error) {
+	msg := new(encoding.MonitorDelegate)
+	err = msg.UnPack(d.Delegation)
+	if err != nil {
+		return
+	}
+	c3 = new(ChannelFor3rd)
+	err = json.Unmarshal(msg.Data, c3)
+	return
+}
+
+//monitorSubmitProofs calls updateBalanceProofDelegate and unlockDelegate for client, it blocks until all tx mined.
+func (rs *Service) monitorSubmitProofs(d *models.MonitorDelegation) {
+	c3, err := decodeMonitorDelegation(d)
+	if err != nil {
+		log.Error(fmt.Sprintf("decodeMonitorDelegation err %s", err))
+		return
+	}
+	tokenNetwork, err := rs.Chain.TokenNetwork(d.TokenAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("monitor get token network %s err %s", utils.APex(d.TokenAddress), err))
+		return
+	}
+	u := c3.UpdateTransfer
+	//partner closed the channel, update client's balance proof. If client closed it himself, the proof is already on chain.
+	if d.ClosingAddress == d.Partner && u.Nonce > 0 {
+		err = tokenNetwork.UpdateBalanceProofDelegate(d.Partner, d.Client, u.TransferAmount, u.Locksroot, u.Nonce, u.ExtraHash, u.ClosingSignature, u.NonClosingSignature)
+		if err != nil {
+			rs.monitorFailed(d, fmt.Sprintf("UpdateBalanceProofDelegate err %s", err))
+			return
+		}
+	}
+	var failed []string
+	for _, l := range c3.Unlocks {
+		proof, err2 := mtree.BytesToProof(l.MerkleProof)
+		if err2 != nil || !mtree.VerifyProof(u.Locksroot, proof, l.Lock.Hash()) {
+			log.Error(fmt.Sprintf("UnlockDelegate %s skipped, proof doesn't match locksroot %s", utils.HPex(l.Lock.LockSecretHash), utils.HPex(u.Locksroot)))
+			continue
+		}
+		err = tokenNetwork.UnlockDelegate(d.Partner, d.Client, u.TransferAmount, l.Lock, l.MerkleProof, l.Signature)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s:%s", utils.HPex(l.Lock.LockSecretHash), err))
+		}
+	}
+	//other locks are unlocked already, a failed one is not retried, it may have expired or be unlocked by client
+	if len(failed) > 0 {
+		rs.monitorFailed(d, fmt.Sprintf("UnlockDelegate err %s", strings.Join(failed, ",")))
+		return
+	}
+	rs.NotifyHandler.Notify(notify.LevelInfo, fmt.Sprintf("委托监控通道 %s 证据已提交,手续费 %s", utils.HPex(d.ChannelIdentifier), d.Fee))
+}
+
+func (rs *Service) monitorFailed(d *models.MonitorDelegation, reason string) {
+	log.Error(fmt.Sprintf("monitor channel %s failed %s", utils.HPex(d.ChannelIdentifier), reason))
+	d.Status = models.MonitorDelegationFailed
+	d.StatusMessage = reason
+	err := rs.dao.SaveMonitorDelegation(d)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveMonitorDelegation err %s", err))
+	}
+}
+
+//monitorUnlockOnChain partner unlocked a lock that client has disposed, punish him.
+func (rs *Service) monitorUnlockOnChain(st *mediatedtransfer.ContractUnlockStateChange) {
+	d, err := rs.dao.GetMonitorDelegation(st.ChannelIdentifier)
+	if err != nil || st.Participant != d.Client {
+		return
+	}
+	c3, err := decodeMonitorDelegation(d)
+	if err != nil {
+		return
+	}
+	for _, p := range c3.Punishes {
+		if p.LockHash != st.LockHash {
+			continue
+		}
+		tokenNetwork, err := rs.Chain.TokenNetwork(d.TokenAddress)
+		if err != nil {
+			return
+		}
+		result := tokenNetwork.PunishObsoleteUnlockAsync(d.Client, d.Partner, p.LockHash, p.AdditionalHash, p.Signature)
+		go func() {
+			err2 := <-result.Result
+			if err2 != nil {
+				log.Error(fmt.Sprintf("monitor PunishObsoleteUnlock %s ,err %s", utils.HPex(st.LockHash), err2))
+			}
+		}()
+	}
+}
+
+/*
+RegisterAsMonitor 注册成为监控节点, fee 是每个通道最少收取的手续费
+*/
+/*
+ *	RegisterAsMonitor : register this node as a monitor, fee is the minimum fee for each channel.
+ */
+func (r *API) RegisterAsMonitor(fee *big.Int) error {
+	if fee == nil || fee.Sign() < 0 {
+		return errors.New("invalid monitor fee")
+	}
+	return r.Photon.dao.SaveMonitorFee(fee)
+}
+
+//GetMonitoredChannels returns all the delegations this monitor received
+func (r *API) GetMonitoredChannels() ([]*models.MonitorDelegation, error) {
+	if r.Photon.dao.GetMonitorFee() == nil {
+		return nil, errNotMonitor
+	}
+	return r.Photon.dao.GetAllMonitorDelegation()
+}
+
+/*
+DelegateMonitor 选择 monitor 来监控通道,并支付手续费
+monitor 在 params.MonitorDelegateTimeout 内没有接受, 或者支付手续费失败时 result 返回错误
+*/
+/*
+ *	DelegateMonitor : choose a monitor to watch channel and pay fee to it.
+ *	result fails if monitor doesn't ack in params.MonitorDelegateTimeout, or the fee cannot be paid.
+ */
+func (r *API) DelegateMonitor(channelIdentifier common.Hash, monitor common.Address, fee *big.Int) (result *utils.AsyncResult, err error) {
+	c3, err := r.ChannelInformationFor3rdParty(channelIdentifier, monitor)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(c3)
+	if err != nil {
+		return
+	}
+	id := &encoding.ChannelIDInMessage{
+		ChannelIdentifier: c3.ChannelIdentifier,
+		OpenBlockNumber:   c3.OpenBlockNumber,
+	}
+	msg := encoding.NewMonitorDelegate(id, c3.TokenAddrss, c3.PartnerAddress, fee, data)
+	err = msg.Sign(r.Photon.PrivateKey, msg)
+	if err != nil {
+		return
+	}
+	result = utils.NewAsyncResult()
+	go func() {
+		//monitor acks only if he accepts this delegation
+		select {
+		case err := <-r.Photon.Protocol.SendAsync(monitor, msg).Result:
+			if err != nil {
+				result.Result <- err
+				return
+			}
+		case <-time.After(params.MonitorDelegateTimeout):
+			result.Result <- errMonitorNotAccepted
+			return
+		}
+		if fee.Sign() <= 0 {
+			result.Result <- nil
+			return
+		}
+		feeResult, err := r.TransferAsync(c3.TokenAddrss, fee, big.NewInt(0), monitor, utils.EmptyHash, false,
+			fmt.Sprintf("monitor fee for %s", c3.ChannelIdentifier.String()))
+		if err == nil {
+			err = <-feeResult.Result
+		}
+		if err != nil {
+			err = fmt.Errorf("monitor accepted,but pay fee err %s", err)
+		}
+		result.Result <- err
+	}()
+	return
+}
@@ -0,0 +1,39 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorNewBlock(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	assert.Nil(t, dao.SaveMonitorFee(big.NewInt(1)))
+	d := &models.MonitorDelegation{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+		Fee:               big.NewInt(1),
+		SettleBlockNumber: 200,
+		SettleTimeout:     100,
+		Status:            models.MonitorDelegationClosed,
+	}
+	assert.Nil(t, dao.SaveMonitorDelegation(d))
+	// loaded from db at the first block, not due before the second half of settle window
+	rs.monitorNewBlock(149)
+	assert.EqualValues(t, 150, rs.monitorDue[d.ChannelIdentifier])
+	d2, err := dao.GetMonitorDelegation(d.ChannelIdentifier)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.MonitorDelegationClosed, d2.Status)
+	// settle window missed
+	rs.monitorNewBlock(201)
+	assert.Empty(t, rs.monitorDue)
+	d2, err = dao.GetMonitorDelegation(d.ChannelIdentifier)
+	assert.Nil(t, err)
+	assert.EqualValues(t, models.MonitorDelegationFailed, d2.Status)
+}
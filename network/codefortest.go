@@ -32,6 +32,9 @@ func (p *dummyProtocol) receive(data []byte) {
 	log.Debug(fmt.Sprintf("%s receive  data len=%d,data=\n%s", p.name, len(data), hex.Dump(data)))
 	p.data <- data
 }
+func (p *dummyProtocol) receiveFrom(from common.Address, data []byte) {
+	p.receive(data)
+}
 
 //MakeTestUDPTransport test only
 func MakeTestUDPTransport(name string, port int) *UDPTransport {
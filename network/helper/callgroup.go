@@ -0,0 +1,149 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+/*
+callGroup 合并同时进行的相同的 CallContract, 只有第一个调用会真正发出请求, 其他的等待并共享结果,
+和 golang.org/x/sync/singleflight 的思路相同.
+*/
+/*
+ *	callGroup : coalesces identical in-flight CallContract, only the first one sends the request,
+ *	others wait for and share its result, the same idea as golang.org/x/sync/singleflight.
+ *	The shared request runs on its own context, so one caller giving up doesn't fail the others,
+ *	every caller waits on its own context, the request is canceled only when all of them gave up.
+ */
+type callGroup struct {
+	lock    sync.Mutex
+	enabled bool
+	calls   map[string]*inFlightCall
+}
+
+//callGroupTimeout limit of a shared request, callers usually give up much earlier with their own context
+const callGroupTimeout = time.Minute
+
+type inFlightCall struct {
+	done    chan struct{} //closed when result and err are ready
+	cancel  context.CancelFunc
+	result  []byte
+	err     error
+	dups    int
+	waiting int //callers still waiting for the result
+}
+
+func newCallGroup() *callGroup {
+	return &callGroup{calls: make(map[string]*inFlightCall)}
+}
+
+/*
+callKey 以 (from, to, data, blockNumber) 作为 key, from 不同的调用结果可能不同, 所以也加入 key.
+带有 value, gas 或者 gasPrice 的调用不合并, 返回 false.
+*/
+// callKey : key is (from, to, data, blockNumber), from is included since result may depend on msg.sender.
+// Calls with value, gas or gasPrice are not coalesced, false is returned.
+func callKey(msg ethereum.CallMsg, blockNumber *big.Int) (key string, ok bool) {
+	if msg.Value != nil || msg.Gas != 0 || msg.GasPrice != nil {
+		return
+	}
+	key = msg.From.String()
+	if msg.To != nil {
+		key += msg.To.String()
+	}
+	key += string(msg.Data)
+	if blockNumber == nil {
+		key += "latest"
+	} else {
+		key += blockNumber.String()
+	}
+	return key, true
+}
+
+func (g *callGroup) setEnabled(enable bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.enabled = enable
+}
+
+/*
+do call fn once for all concurrent callers with the same key, each caller gets its own copy of result.
+fn runs on a context detached from the callers, each caller returns ctx.Err() when its own ctx is done.
+*/
+func (g *callGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if g == nil {
+		return fn(ctx)
+	}
+	g.lock.Lock()
+	if !g.enabled {
+		g.lock.Unlock()
+		return fn(ctx)
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.dups++
+	} else {
+		var callCtx context.Context
+		c = &inFlightCall{done: make(chan struct{})}
+		callCtx, c.cancel = context.WithTimeout(context.Background(), callGroupTimeout)
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiting++
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return copyBytes(c.result), c.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		c.waiting--
+		if c.waiting == 0 {
+			//nobody wants the result, later callers start a new request
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *callGroup) run(ctx context.Context, key string, c *inFlightCall, fn func(ctx context.Context) ([]byte, error)) {
+	c.result, c.err = fn(ctx)
+	c.cancel()
+	g.lock.Lock()
+	g.forget(key, c)
+	g.lock.Unlock()
+	close(c.done)
+}
+
+//forget must hold the lock, key may already belong to a newer call
+func (g *callGroup) forget(key string, c *inFlightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+//waiters how many callers are waiting for the in-flight call of key
+func (g *callGroup) waiters(key string) int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.dups
+	}
+	return 0
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallContractCoalescing(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	c.SetCallCoalescing(true)
+	to := common.HexToAddress("0x1")
+	msg := ethereum.CallMsg{To: &to, Data: []byte{0xaa, 0xbb}}
+	blockNumber := big.NewInt(100)
+	key, ok := callKey(msg, blockNumber)
+	assert.True(t, ok)
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			r, err := c.CallContract(context.Background(), msg, blockNumber)
+			assert.Nil(t, err)
+			assert.EqualValues(t, []byte{1, 2, 3}, r)
+		}()
+	}
+	// wait until every caller joined the in-flight call
+	deadline := time.Now().Add(5 * time.Second)
+	for c.callGroup.waiters(key) != n-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(s.release)
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&s.calls))
+
+	// a different block is a different call
+	_, err := c.CallContract(context.Background(), msg, big.NewInt(101))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&s.calls))
+
+	// disabled, every call goes to the node
+	c.SetCallCoalescing(false)
+	for i := 0; i < 3; i++ {
+		_, err = c.CallContract(context.Background(), msg, blockNumber)
+		assert.Nil(t, err)
+	}
+	assert.EqualValues(t, 5, atomic.LoadInt32(&s.calls))
+}
+
+func TestCallContractCoalescingCancel(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	c.SetCallCoalescing(true)
+	to := common.HexToAddress("0x1")
+	msg := ethereum.CallMsg{To: &to, Data: []byte{0xaa}}
+	key, _ := callKey(msg, nil)
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// the first caller gives up, the one waiting with it still gets the result
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	errs1 := make(chan error, 1)
+	go func() {
+		_, err := c.CallContract(ctx1, msg, nil)
+		errs1 <- err
+	}()
+	waitFor(func() bool { return atomic.LoadInt32(&s.calls) == 1 })
+	type result struct {
+		r   []byte
+		err error
+	}
+	results2 := make(chan result, 1)
+	go func() {
+		r, err := c.CallContract(context.Background(), msg, nil)
+		results2 <- result{r, err}
+	}()
+	waitFor(func() bool { return c.callGroup.waiters(key) == 1 })
+	cancel1()
+	assert.Equal(t, context.Canceled, <-errs1)
+	close(s.release)
+	r2 := <-results2
+	assert.Nil(t, r2.err)
+	assert.EqualValues(t, []byte{1, 2, 3}, r2.r)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&s.calls))
+
+	// every caller gives up, the request is forgotten and the next caller starts a new one
+	s = &FakeEthService{release: make(chan struct{})}
+	c = newFakeSafeClient(t, s)
+	c.SetCallCoalescing(true)
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	errs3 := make(chan error, 1)
+	go func() {
+		_, err := c.CallContract(ctx3, msg, nil)
+		errs3 <- err
+	}()
+	waitFor(func() bool { return atomic.LoadInt32(&s.calls) == 1 })
+	cancel3()
+	assert.Equal(t, context.Canceled, <-errs3)
+	c.callGroup.lock.Lock()
+	assert.Empty(t, c.callGroup.calls)
+	c.callGroup.lock.Unlock()
+	close(s.release)
+	_, err := c.CallContract(context.Background(), msg, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&s.calls))
+}
@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+)
+
+//ErrCircuitOpen returned without touching the network when too many consecutive rpc calls failed
+var ErrCircuitOpen = errors.New("eth rpc circuit open, too many consecutive connection errors")
+
+/*
+CircuitBreaker 在连续 Threshold 次连接错误以后打开, 打开期间所有调用直接返回 ErrCircuitOpen, 不再访问网络,
+避免节点长期断线时大量失败调用刷屏.
+经过 CoolDown 以后放过一次调用作为探测, 探测成功或者重新连上 geth 以后关闭.
+nil 表示不启用.
+*/
+/*
+ *	CircuitBreaker : opens after Threshold consecutive connection errors, while open, every call returns
+ *	ErrCircuitOpen without touching the network, so a permanently down node doesn't flood logs.
+ *	After CoolDown one call is let through as a probe, the breaker closes when the probe succeeds
+ *	or geth is reconnected.
+ *	nil means disabled.
+ */
+type CircuitBreaker struct {
+	Threshold int
+	CoolDown  time.Duration
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+//NewCircuitBreaker create a circuit breaker, threshold<=0 means disabled and nil is returned
+func NewCircuitBreaker(threshold int, coolDown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{
+		Threshold: threshold,
+		CoolDown:  coolDown,
+		now:       time.Now,
+	}
+}
+
+//IsOpen returns true when calls are refused
+func (cb *CircuitBreaker) IsOpen() bool {
+	if cb == nil {
+		return false
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.failures >= cb.Threshold
+}
+
+//Allow returns ErrCircuitOpen if this call should not touch the network
+func (cb *CircuitBreaker) Allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.failures < cb.Threshold {
+		return nil
+	}
+	if cb.probing || cb.now().Before(cb.openUntil) {
+		return ErrCircuitOpen
+	}
+	//cool-down passed, let this one go as a probe
+	cb.probing = true
+	return nil
+}
+
+//Done records result of a call allowed by Allow
+func (cb *CircuitBreaker) Done(err error) {
+	if cb == nil {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.probing = false
+	if !isConnectionError(err) {
+		if cb.failures >= cb.Threshold {
+			log.Info("eth rpc circuit closed")
+		}
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.Threshold {
+		if cb.failures == cb.Threshold {
+			log.Warn(fmt.Sprintf("eth rpc circuit open after %d consecutive errors, last err %s", cb.failures, err))
+		}
+		cb.openUntil = cb.now().Add(cb.CoolDown)
+	}
+}
+
+//Reset closes the circuit, for example after geth reconnected
+func (cb *CircuitBreaker) Reset() {
+	if cb == nil {
+		return
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.failures = 0
+	cb.probing = false
+}
+
+/*
+isConnectionError 只有连接相关的错误才计数, 合约执行失败, 找不到交易, ETH 不够支付 gas 等说明节点是正常响应的.
+*/
+// isConnectionError : only connection errors count, errors like reverted call, tx not found or insufficient funds for gas mean node is responding.
+func isConnectionError(err error) bool {
+	if err == nil || IsInsufficientFunds(err) {
+		return false
+	}
+	if err == errNotConnectd || err == context.DeadlineExceeded || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "no such host")
+}
@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(3, time.Minute)
+	cb.now = func() time.Time { return now }
+	connErr := errors.New("dial tcp 127.0.0.1:8545: connect: connection refused")
+	// other errors mean node is responding
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Done(errors.New("execution reverted"))
+	}
+	assert.False(t, cb.IsOpen())
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Done(connErr)
+	}
+	assert.True(t, cb.IsOpen())
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+	// cool-down passed, only one probe is allowed, probe fails
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.Allow())
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+	cb.Done(errNotConnectd)
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+	// probe succeeds
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.Allow())
+	cb.Done(nil)
+	assert.False(t, cb.IsOpen())
+	assert.Nil(t, cb.Allow())
+	// reconnected
+	for i := 0; i < 3; i++ {
+		cb.Done(connErr)
+	}
+	assert.True(t, cb.IsOpen())
+	cb.Reset()
+	assert.Nil(t, cb.Allow())
+	// disabled
+	var disabled *CircuitBreaker
+	assert.Nil(t, NewCircuitBreaker(0, time.Minute))
+	disabled.Done(connErr)
+	assert.Nil(t, disabled.Allow())
+	assert.False(t, disabled.IsOpen())
+}
+
+func TestInsufficientFundsNotConnectionError(t *testing.T) {
+	gethErr := errors.New("insufficient funds for gas * price + value")
+	assert.True(t, IsInsufficientFunds(gethErr))
+	assert.False(t, isConnectionError(gethErr))
+	wrapped := &ErrInsufficientFunds{Err: gethErr}
+	assert.True(t, IsInsufficientFunds(wrapped))
+	assert.False(t, isConnectionError(wrapped))
+	assert.False(t, IsConnectionFailed(wrapped))
+	assert.Contains(t, wrapped.Error(), "top up")
+	assert.False(t, IsInsufficientFunds(nil))
+	assert.False(t, IsInsufficientFunds(errors.New("dial tcp 127.0.0.1:8545: connect: connection refused")))
+	// running out of ETH never opens the circuit
+	cb := NewCircuitBreaker(1, time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Done(gethErr)
+	}
+	assert.False(t, cb.IsOpen())
+}
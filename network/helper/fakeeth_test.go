@@ -0,0 +1,158 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+//newFakeSafeClient serves `service` as namespace eth in process and connects a SafeEthClient to it, fake eth nodes of this package's tests live in this file
+func newFakeSafeClient(t *testing.T, service interface{}) *SafeEthClient {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	rpcClient := rpc.DialInProc(server)
+	return &SafeEthClient{
+		Client:    ethclient.NewClient(rpcClient),
+		rpcClient: rpcClient,
+		ReConnect: make(map[string]chan struct{}),
+		callGroup: newCallGroup(),
+	}
+}
+
+//FakeEthService serves eth_call, blocks until release is closed
+type FakeEthService struct {
+	calls   int32
+	sent    int32
+	release chan struct{}
+	//balanceBlock block number of the last eth_getBalance
+	balanceBlock string
+	//rawTx data of the last eth_sendRawTransaction
+	rawTx hexutil.Bytes
+	//accessListBlock block number of the last eth_createAccessList
+	accessListBlock string
+	//callArgs and callBlock of the last eth_call
+	callLock  sync.Mutex
+	callArgs  map[string]interface{}
+	callBlock string
+}
+
+//GetBalance serves eth_getBalance
+func (s *FakeEthService) GetBalance(ctx context.Context, account common.Address, blockNumber string) (*hexutil.Big, error) {
+	s.balanceBlock = blockNumber
+	return (*hexutil.Big)(big.NewInt(100)), nil
+}
+
+//SendRawTransaction serves eth_sendRawTransaction, hash of the bytes is returned as the tx hash, like legacy txs
+func (s *FakeEthService) SendRawTransaction(ctx context.Context, data hexutil.Bytes) (common.Hash, error) {
+	atomic.AddInt32(&s.sent, 1)
+	s.rawTx = data
+	return crypto.Keccak256Hash(data), nil
+}
+
+//CreateAccessList serves eth_createAccessList, the access list is the callee itself
+func (s *FakeEthService) CreateAccessList(ctx context.Context, args map[string]interface{}, blockNumber string) (map[string]interface{}, error) {
+	s.accessListBlock = blockNumber
+	return map[string]interface{}{
+		"accessList": []map[string]interface{}{{"address": args["to"], "storageKeys": []common.Hash{{1}}}},
+		"gasUsed":    "0x5208",
+	}, nil
+}
+
+func (s *FakeEthService) Call(ctx context.Context, args map[string]interface{}, blockNumber string) (hexutil.Bytes, error) {
+	atomic.AddInt32(&s.calls, 1)
+	s.callLock.Lock()
+	s.callArgs, s.callBlock = args, blockNumber
+	s.callLock.Unlock()
+	<-s.release
+	return hexutil.Bytes{1, 2, 3}, nil
+}
+
+//FakeChainService serves eth_getBlockByNumber
+type FakeChainService struct{}
+
+//ChainId serves eth_chainId
+func (s *FakeChainService) ChainId() hexutil.Uint64 {
+	return params.SpectrumChainID
+}
+
+func (s *FakeChainService) GetBlockByNumber(ctx context.Context, number string, fullTx bool) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1), Time: big.NewInt(1)}, nil
+}
+
+//FakeNetService serves net_version
+type FakeNetService struct {
+	version string
+}
+
+func (s *FakeNetService) Version() string {
+	return s.version
+}
+
+func newFakeChainServer(t *testing.T, networkID string) *httptest.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &FakeChainService{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("net", &FakeNetService{networkID}); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(server)
+}
+
+//FakeReceiptService serves eth_getBlockByHash and eth_getTransactionReceipt
+type FakeReceiptService struct {
+	receiptCalls int32
+	//release receipts are blocked until it's closed, nil means never blocked
+	release chan struct{}
+}
+
+func fakeLog(txHash common.Hash, index uint) map[string]interface{} {
+	return map[string]interface{}{
+		"address":          common.HexToAddress("0x1"),
+		"topics":           []common.Hash{{1}},
+		"data":             "0x",
+		"transactionHash":  txHash,
+		"transactionIndex": "0x0",
+		"logIndex":         hexutil.Uint(index),
+	}
+}
+
+//GetBlockByHash block with two transactions
+func (s *FakeReceiptService) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) map[string]interface{} {
+	return map[string]interface{}{"transactions": []common.Hash{{1}, {2}}}
+}
+
+//GetTransactionReceipt every receipt has one log
+func (s *FakeReceiptService) GetTransactionReceipt(ctx context.Context, txHash common.Hash) map[string]interface{} {
+	atomic.AddInt32(&s.receiptCalls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	return map[string]interface{}{"logs": []interface{}{fakeLog(txHash, uint(txHash[0])-1)}}
+}
+
+//FakeBlockReceiptsService also serves eth_getBlockReceipts
+type FakeBlockReceiptsService struct {
+	FakeReceiptService
+}
+
+//GetBlockReceipts two receipts with one log each
+func (s *FakeBlockReceiptsService) GetBlockReceipts(ctx context.Context, hash common.Hash) []map[string]interface{} {
+	return []map[string]interface{}{
+		{"logs": []interface{}{fakeLog(common.Hash{1}, 0)}},
+		{"logs": []interface{}{fakeLog(common.Hash{2}, 1)}},
+	}
+}
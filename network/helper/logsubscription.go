@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var errLogSubscriptionClosed = errors.New("log subscription already unsubscribed")
+
+/*
+LogSubscription 订阅日志, 过滤条件可以随时修改, 比如实时监控动态增加或者删除合约地址.
+修改时在锁内重新订阅, 新的订阅成功以后才关闭旧的, 失败时旧的订阅继续有效.
+所有日志都发送到同一个 channel, 切换的瞬间同时满足新旧条件的日志可能收到两次.
+*/
+/*
+ *	LogSubscription : log subscription whose filter can be changed at any time,
+ *	e.g. real-time monitors adding or removing contract addresses.
+ *	Changing the filter re-subscribes under the lock, the old subscription is closed only after the new one is opened,
+ *	so the old one keeps working if it fails.
+ *	Logs always go to the same channel, a log matching both filters may arrive twice while switching.
+ */
+type LogSubscription struct {
+	client *SafeEthClient
+	ch     chan<- types.Log
+	lock   sync.Mutex
+	query  ethereum.FilterQuery
+	sub    ethereum.Subscription
+	stop   chan struct{} //stops forwarding errors of sub
+	err    chan error
+	closed bool
+}
+
+//SubscribeLogs like SubscribeFilterLogs, but the filter can be changed later by UpdateFilter
+func (c *SafeEthClient) SubscribeLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (*LogSubscription, error) {
+	sub, err := c.SubscribeFilterLogs(ctx, q, ch)
+	if err != nil {
+		return nil, err
+	}
+	s := &LogSubscription{
+		client: c,
+		ch:     ch,
+		query:  q,
+		err:    make(chan error, 1),
+	}
+	s.use(sub)
+	return s, nil
+}
+
+//use sub from now on, errors of it are forwarded to Err, lock must be held except in SubscribeLogs
+func (s *LogSubscription) use(sub ethereum.Subscription) {
+	s.sub = sub
+	s.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		select {
+		case err, ok := <-sub.Err():
+			//closed channel means it's unsubscribed by us
+			if !ok {
+				return
+			}
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			//replaced or unsubscribed meanwhile, s.err may be closed
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case s.err <- err:
+			default:
+			}
+		case <-stop:
+		}
+	}(s.stop)
+}
+
+//UpdateFilter subscribe logs matching q instead, the old filter keeps working if it fails
+func (s *LogSubscription) UpdateFilter(q ethereum.FilterQuery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errLogSubscriptionClosed
+	}
+	sub, err := s.client.SubscribeFilterLogs(context.Background(), q, s.ch)
+	if err != nil {
+		return err
+	}
+	close(s.stop)
+	s.sub.Unsubscribe()
+	s.query = q
+	s.use(sub)
+	return nil
+}
+
+//Filter the filter currently in use
+func (s *LogSubscription) Filter() ethereum.FilterQuery {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.query
+}
+
+//Unsubscribe implements ethereum.Subscription, no more logs are sent to the channel after it returns
+func (s *LogSubscription) Unsubscribe() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.stop)
+	s.sub.Unsubscribe()
+	close(s.err)
+}
+
+//Err implements ethereum.Subscription, receives the error of the subscription in use, closed after Unsubscribe
+func (s *LogSubscription) Err() <-chan error {
+	return s.err
+}
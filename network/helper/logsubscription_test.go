@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLogSubscription struct {
+	notifier  *rpc.Notifier
+	sub       *rpc.Subscription
+	addresses []common.Address
+}
+
+//FakeLogsService serves eth_subscribe("logs")
+type FakeLogsService struct {
+	lock sync.Mutex
+	subs []*fakeLogSubscription
+}
+
+//Logs remember the subscription until it's unsubscribed
+func (s *FakeLogsService) Logs(ctx context.Context, crit struct {
+	Address []common.Address `json:"address"`
+}) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	fs := &fakeLogSubscription{notifier: notifier, sub: notifier.CreateSubscription(), addresses: crit.Address}
+	s.lock.Lock()
+	s.subs = append(s.subs, fs)
+	s.lock.Unlock()
+	go func() {
+		<-fs.sub.Err()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, f := range s.subs {
+			if f == fs {
+				s.subs = append(s.subs[:i], s.subs[i+1:]...)
+				break
+			}
+		}
+	}()
+	return fs.sub, nil
+}
+
+func (s *FakeLogsService) active() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.subs)
+}
+
+//emit a log of address to subscriptions whose filter matches it
+func (s *FakeLogsService) emit(address common.Address) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, f := range s.subs {
+		for _, a := range f.addresses {
+			if a == address {
+				f.notifier.Notify(f.sub.ID, map[string]interface{}{
+					"address":          address,
+					"topics":           []common.Hash{},
+					"data":             "0x",
+					"transactionHash":  common.Hash{},
+					"transactionIndex": "0x0",
+					"logIndex":         "0x0",
+				})
+			}
+		}
+	}
+}
+
+//emitUntilReceived emit logs of addresses until one arrives, notifications are dropped until the subscription is active
+func emitUntilReceived(s *FakeLogsService, ch chan types.Log, addresses ...common.Address) (l types.Log, ok bool) {
+	for i := 0; i < 50; i++ {
+		for _, a := range addresses {
+			s.emit(a)
+		}
+		select {
+		case l = <-ch:
+			return l, true
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	return
+}
+
+func TestLogSubscriptionUpdateFilter(t *testing.T) {
+	s := &FakeLogsService{}
+	c := newFakeSafeClient(t, s)
+	a1, a2 := common.HexToAddress("0x1"), common.HexToAddress("0x2")
+	ch := make(chan types.Log, 10)
+	sub, err := c.SubscribeLogs(context.Background(), ethereum.FilterQuery{Addresses: []common.Address{a1}}, ch)
+	if !assert.Nil(t, err) {
+		return
+	}
+	l, ok := emitUntilReceived(s, ch, a1)
+	assert.True(t, ok)
+	assert.Equal(t, a1, l.Address)
+
+	err = sub.UpdateFilter(ethereum.FilterQuery{Addresses: []common.Address{a2}})
+	assert.Nil(t, err)
+	assert.Equal(t, []common.Address{a2}, sub.Filter().Addresses)
+	//the old subscription is closed
+	for i := 0; i < 100 && s.active() != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, s.active())
+	//logs of the old filter emitted more than once
+	for len(ch) > 0 {
+		<-ch
+	}
+	l, ok = emitUntilReceived(s, ch, a1, a2)
+	assert.True(t, ok)
+	assert.Equal(t, a2, l.Address)
+	//replaced subscription doesn't report an error
+	select {
+	case err = <-sub.Err():
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+
+	sub.Unsubscribe()
+	_, ok = <-sub.Err()
+	assert.False(t, ok)
+	assert.Equal(t, errLogSubscriptionClosed, sub.UpdateFilter(ethereum.FilterQuery{}))
+	//unsubscribe twice is fine
+	sub.Unsubscribe()
+}
@@ -0,0 +1,21 @@
+package helper
+
+import "time"
+
+/*
+MetricsProvider 记录与 geth 连接相关的指标, 不设置时不记录.
+RegistryMetrics 把指标记录在 go-ethereum 的 metrics registry 中, photon 用 --metrics 启用, 通过 pprof 服务的 /debug/metrics 查看.
+*/
+/*
+ *	MetricsProvider : records metrics about connection with geth, nothing is recorded when not set.
+ *	RegistryMetrics records them in a go-ethereum metrics registry, photon enables it with --metrics,
+ *	and they are served at /debug/metrics of the pprof server.
+ */
+type MetricsProvider interface {
+	//ReconnectAttempt is called before every try to reconnect geth
+	ReconnectAttempt()
+	//ReconnectDuration is called when reconnected, d is from disconnection to reconnected
+	ReconnectDuration(d time.Duration)
+	//ConnectionStatus is called when connection status changes
+	ConnectionStatus(connected bool)
+}
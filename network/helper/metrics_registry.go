@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+//RegistryMetrics records connection metrics of SafeEthClient in a go-ethereum metrics registry
+type RegistryMetrics struct {
+	reconnectAttempt  metrics.Counter
+	reconnectDuration metrics.Timer
+	connectionStatus  metrics.Gauge
+}
+
+//NewRegistryMetrics create metrics and register them to r, use metrics.DefaultRegistry if r is nil.
+//metrics.Enabled must be true before, otherwise nothing is recorded.
+func NewRegistryMetrics(r metrics.Registry) *RegistryMetrics {
+	return &RegistryMetrics{
+		reconnectAttempt:  metrics.NewRegisteredCounter("eth/reconnect/attempt", r),
+		reconnectDuration: metrics.NewRegisteredTimer("eth/reconnect/duration", r),
+		connectionStatus:  metrics.NewRegisteredGauge("eth/connection/status", r),
+	}
+}
+
+//ReconnectAttempt implements MetricsProvider
+func (m *RegistryMetrics) ReconnectAttempt() {
+	m.reconnectAttempt.Inc(1)
+}
+
+//ReconnectDuration implements MetricsProvider
+func (m *RegistryMetrics) ReconnectDuration(d time.Duration) {
+	m.reconnectDuration.Update(d)
+}
+
+//ConnectionStatus implements MetricsProvider, 1 means connected
+func (m *RegistryMetrics) ConnectionStatus(connected bool) {
+	if connected {
+		m.connectionStatus.Update(1)
+	} else {
+		m.connectionStatus.Update(0)
+	}
+}
@@ -0,0 +1,50 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetrics struct {
+	attempts  int
+	durations []time.Duration
+	status    []bool
+}
+
+func (m *fakeMetrics) ReconnectAttempt()                 { m.attempts++ }
+func (m *fakeMetrics) ReconnectDuration(d time.Duration) { m.durations = append(m.durations, d) }
+func (m *fakeMetrics) ConnectionStatus(connected bool)   { m.status = append(m.status, connected) }
+
+func TestConnectionStatusMetrics(t *testing.T) {
+	c := &SafeEthClient{StatusChan: make(chan netshare.Status, 10)}
+	m := &fakeMetrics{}
+	c.SetMetricsProvider(m)
+	c.changeStatus(netshare.Connected)
+	c.changeStatus(netshare.Reconnecting)
+	c.changeStatus(netshare.Connected)
+	assert.EqualValues(t, []bool{false, true, false, true}, m.status)
+	c.SetMetricsProvider(nil)
+	c.changeStatus(netshare.Closed)
+	assert.EqualValues(t, 4, len(m.status))
+}
+
+func TestRegistryMetrics(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+	r := metrics.NewRegistry()
+	m := NewRegistryMetrics(r)
+	m.ReconnectAttempt()
+	m.ReconnectAttempt()
+	m.ReconnectDuration(time.Second)
+	m.ConnectionStatus(true)
+	assert.EqualValues(t, 2, r.Get("eth/reconnect/attempt").(metrics.Counter).Count())
+	assert.EqualValues(t, 1, r.Get("eth/reconnect/duration").(metrics.Timer).Count())
+	assert.EqualValues(t, 1, r.Get("eth/connection/status").(metrics.Gauge).Value())
+	m.ConnectionStatus(false)
+	assert.EqualValues(t, 0, r.Get("eth/connection/status").(metrics.Gauge).Value())
+}
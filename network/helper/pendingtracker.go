@@ -0,0 +1,239 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//DefaultPendingThreshold a tx not mined for this long is reported as stuck when not specified
+const DefaultPendingThreshold = 10 * time.Minute
+
+//DefaultPendingCheckInterval how often SafeEthClient checks txs it sent
+const DefaultPendingCheckInterval = time.Minute
+
+//PendingClient what PendingTracker needs, SafeEthClient implements it
+type PendingClient interface {
+	ReceiptClient
+	PendingTransactionCount(ctx context.Context) (uint, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+}
+
+//PendingTx a submitted tx which has no receipt yet
+type PendingTx struct {
+	Hash        common.Hash
+	Account     common.Address
+	Nonce       uint64
+	SubmittedAt time.Time
+}
+
+//PendingReport result of one PendingTracker.Check
+type PendingReport struct {
+	Pending  int                             //txs still without receipt after this check
+	Mined    []common.Hash                   //txs got their receipt in this check and are no longer tracked
+	Replaced []common.Hash                   //txs without receipt whose nonce is used by another tx of the account, never to be mined, no longer tracked
+	Stuck    map[common.Address][]*PendingTx //txs pending longer than threshold, by account, oldest first
+	PoolSize uint                            //PendingTransactionCount of the node, 0 if query failed
+	Errors   int                             //queries failed, e.g. during reconnecting, those txs are kept
+}
+
+//HasStuck true if any tx is stuck
+func (r *PendingReport) HasStuck() bool {
+	return len(r.Stuck) > 0
+}
+
+/*
+PendingTracker 按账户记录已经提交但是还没有 receipt 的交易, 定期查询 TransactionReceipt,
+把等待超过 Threshold 的交易报告为卡住, 供报警或者提高 gas price 重发.
+查询失败(比如正在重连 geth)时交易继续保留, 下次检查再查, 不会因为断线丢失或者误判为已打包.
+*/
+/*
+ *	PendingTracker : records submitted but not yet mined txs per account, periodically queries TransactionReceipt
+ *	and reports txs pending longer than Threshold as stuck, which feeds alerting and gas price bumping.
+ *	When a query fails, e.g. while geth is being reconnected, the tx is kept and queried again on next check,
+ *	so a disconnect never loses it nor takes it as mined.
+ */
+type PendingTracker struct {
+	Threshold time.Duration
+	client    PendingClient
+	lock      sync.Mutex
+	pending   map[common.Address]map[common.Hash]*PendingTx
+	now       func() time.Time
+}
+
+//NewPendingTracker create a tracker, threshold<=0 means DefaultPendingThreshold
+func NewPendingTracker(client PendingClient, threshold time.Duration) *PendingTracker {
+	if threshold <= 0 {
+		threshold = DefaultPendingThreshold
+	}
+	return &PendingTracker{
+		Threshold: threshold,
+		client:    client,
+		pending:   make(map[common.Address]map[common.Hash]*PendingTx),
+		now:       time.Now,
+	}
+}
+
+//Track starts tracking tx sent by account, tracking the same tx again keeps its original submit time
+func (pt *PendingTracker) Track(account common.Address, tx *types.Transaction) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	m, ok := pt.pending[account]
+	if !ok {
+		m = make(map[common.Hash]*PendingTx)
+		pt.pending[account] = m
+	}
+	if _, ok = m[tx.Hash()]; ok {
+		return
+	}
+	m[tx.Hash()] = &PendingTx{
+		Hash:        tx.Hash(),
+		Account:     account,
+		Nonce:       tx.Nonce(),
+		SubmittedAt: pt.now(),
+	}
+}
+
+//Forget stops tracking tx, for example it's replaced by one with higher gas price
+func (pt *PendingTracker) Forget(txHash common.Hash) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	for account, m := range pt.pending {
+		if _, ok := m[txHash]; ok {
+			delete(m, txHash)
+			if len(m) == 0 {
+				delete(pt.pending, account)
+			}
+			return
+		}
+	}
+}
+
+//Pending returns txs of account which have no receipt yet, oldest first
+func (pt *PendingTracker) Pending(account common.Address) []*PendingTx {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	return sortPendingTxs(pt.pending[account])
+}
+
+func sortPendingTxs(m map[common.Hash]*PendingTx) []*PendingTx {
+	var txs []*PendingTx
+	for _, p := range m {
+		cp := *p
+		txs = append(txs, &cp)
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].Nonce != txs[j].Nonce {
+			return txs[i].Nonce < txs[j].Nonce
+		}
+		return txs[i].SubmittedAt.Before(txs[j].SubmittedAt)
+	})
+	return txs
+}
+
+/*
+Check 查询每个交易的 receipt, 已经打包的不再跟踪, 返回报告.
+没有 receipt 但是 nonce 小于账户在最新块上的 nonce 的交易, 说明这个 nonce 已经被别的交易(比如提高 gas price 的替换交易)用掉了,
+永远不会被打包, 也不再跟踪. 账户 nonce 在查询 receipt 之前获取, 所以查询期间刚刚打包的交易不会被误判为被替换.
+查询时不持有锁, 所以查询期间可以继续 Track 新的交易.
+*/
+/*
+ *	Check : queries receipt of every tracked tx, mined ones are no longer tracked, and returns a report.
+ *	A tx without receipt whose nonce is below the nonce of its account at the latest block has its nonce used by another tx,
+ *	e.g. a replacement with higher gas price, it will never be mined and is no longer tracked either.
+ *	Account nonce is queried before receipts, so a tx mined in the meantime is never taken as replaced.
+ *	The lock is not held while querying, so new txs can be tracked in the meantime.
+ */
+func (pt *PendingTracker) Check(ctx context.Context) *PendingReport {
+	pt.lock.Lock()
+	var txs []*PendingTx
+	var accounts []common.Address
+	for account, m := range pt.pending {
+		txs = append(txs, sortPendingTxs(m)...)
+		accounts = append(accounts, account)
+	}
+	pt.lock.Unlock()
+	r := &PendingReport{
+		Stuck: make(map[common.Address][]*PendingTx),
+	}
+	var err error
+	r.PoolSize, err = pt.client.PendingTransactionCount(ctx)
+	if err != nil {
+		r.Errors++
+		log.Trace(fmt.Sprintf("PendingTracker PendingTransactionCount err %s", err))
+	}
+	accountNonces := make(map[common.Address]uint64)
+	for _, account := range accounts {
+		nonce, err := pt.client.NonceAt(ctx, account, nil)
+		if err != nil {
+			r.Errors++
+			log.Trace(fmt.Sprintf("PendingTracker NonceAt %s err %s", utils.APex2(account), err))
+			continue
+		}
+		accountNonces[account] = nonce
+	}
+	now := pt.now()
+	for _, p := range txs {
+		receipt, err := pt.client.TransactionReceipt(ctx, p.Hash)
+		if receipt != nil {
+			r.Mined = append(r.Mined, p.Hash)
+			pt.Forget(p.Hash)
+			continue
+		}
+		if err != nil && err != ethereum.NotFound {
+			r.Errors++
+			log.Trace(fmt.Sprintf("PendingTracker %s receipt retrieval failed %s", utils.HPex(p.Hash), err))
+		} else if nonce, ok := accountNonces[p.Account]; ok && p.Nonce < nonce {
+			log.Info(fmt.Sprintf("PendingTracker %s nonce=%d of %s is replaced, account nonce=%d",
+				utils.HPex(p.Hash), p.Nonce, utils.APex2(p.Account), nonce))
+			r.Replaced = append(r.Replaced, p.Hash)
+			pt.Forget(p.Hash)
+			continue
+		}
+		r.Pending++
+		if now.Sub(p.SubmittedAt) >= pt.Threshold {
+			r.Stuck[p.Account] = append(r.Stuck[p.Account], p)
+		}
+	}
+	return r
+}
+
+/*
+Run 每隔 interval 检查一次, 有交易卡住时调用 onStuck, 直到 ctx 被取消.
+reconnect 不为 nil 时(比如 SafeEthClient.RegisterReConnectNotify 的返回值), 重连成功以后立即检查一次.
+*/
+/*
+ *	Run : checks every interval and calls onStuck when some txs are stuck, until ctx is canceled.
+ *	If reconnect is not nil, e.g. returned by SafeEthClient.RegisterReConnectNotify, a check is made right after reconnecting.
+ */
+func (pt *PendingTracker) Run(ctx context.Context, interval time.Duration, reconnect <-chan struct{}, onStuck func(r *PendingReport)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-reconnect:
+		}
+		r := pt.Check(ctx)
+		if r.HasStuck() {
+			for account, txs := range r.Stuck {
+				log.Warn(fmt.Sprintf("PendingTracker %d txs of %s pending longer than %s, oldest nonce=%d",
+					len(txs), utils.APex2(account), pt.Threshold, txs[0].Nonce))
+			}
+			if onStuck != nil {
+				onStuck(r)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakePendingClient only txs in mined have receipts, every call fails while disconnected
+type fakePendingClient struct {
+	lock         sync.Mutex
+	mined        map[common.Hash]bool
+	nonces       map[common.Address]uint64
+	disconnected bool
+}
+
+func (c *fakePendingClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.disconnected {
+		return nil, errNotConnectd
+	}
+	if c.mined[txHash] {
+		return &types.Receipt{TxHash: txHash, Status: types.ReceiptStatusSuccessful}, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (c *fakePendingClient) PendingTransactionCount(ctx context.Context) (uint, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.disconnected {
+		return 0, errNotConnectd
+	}
+	return uint(len(c.mined)), nil
+}
+
+func (c *fakePendingClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.disconnected {
+		return 0, errNotConnectd
+	}
+	return c.nonces[account], nil
+}
+
+func (c *fakePendingClient) setNonce(account common.Address, nonce uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.nonces == nil {
+		c.nonces = make(map[common.Address]uint64)
+	}
+	c.nonces[account] = nonce
+}
+
+func (c *fakePendingClient) mine(txs ...*types.Transaction) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, tx := range txs {
+		c.mined[tx.Hash()] = true
+	}
+}
+
+func (c *fakePendingClient) setDisconnected(disconnected bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disconnected = disconnected
+}
+
+func newTestTxWithNonce(nonce uint64) *types.Transaction {
+	return types.NewTransaction(nonce, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+}
+
+func TestPendingTrackerStuck(t *testing.T) {
+	c := &fakePendingClient{mined: make(map[common.Hash]bool)}
+	pt := NewPendingTracker(c, time.Minute)
+	now := time.Now()
+	pt.now = func() time.Time { return now }
+	a1, a2 := common.HexToAddress("0xa1"), common.HexToAddress("0xa2")
+	tx0, tx1, tx2 := newTestTxWithNonce(0), newTestTxWithNonce(1), newTestTxWithNonce(2)
+	pt.Track(a1, tx1)
+	pt.Track(a1, tx0)
+	now = now.Add(30 * time.Second)
+	pt.Track(a2, tx2)
+	// tracking again keeps the submit time
+	pt.Track(a2, tx2)
+	assert.Equal(t, 2, len(pt.Pending(a1)))
+	assert.Equal(t, uint64(0), pt.Pending(a1)[0].Nonce)
+	// nothing is old enough
+	r := pt.Check(context.Background())
+	assert.Equal(t, 3, r.Pending)
+	assert.False(t, r.HasStuck())
+	assert.Equal(t, 0, r.Errors)
+
+	// tx0 is mined, tx1 stays pending and is stuck, tx2 is not old enough
+	c.mine(tx0)
+	now = now.Add(40 * time.Second)
+	r = pt.Check(context.Background())
+	assert.Equal(t, []common.Hash{tx0.Hash()}, r.Mined)
+	assert.Equal(t, 2, r.Pending)
+	assert.Equal(t, uint(1), r.PoolSize)
+	assert.True(t, r.HasStuck())
+	assert.Equal(t, 1, len(r.Stuck[a1]))
+	assert.Equal(t, tx1.Hash(), r.Stuck[a1][0].Hash)
+	assert.Equal(t, 0, len(r.Stuck[a2]))
+	assert.Equal(t, 1, len(pt.Pending(a1)))
+
+	// both stuck now
+	now = now.Add(time.Minute)
+	r = pt.Check(context.Background())
+	assert.Equal(t, 1, len(r.Stuck[a1]))
+	assert.Equal(t, 1, len(r.Stuck[a2]))
+
+	// forgotten tx, e.g. replaced with a higher gas price, is not reported
+	pt.Forget(tx1.Hash())
+	r = pt.Check(context.Background())
+	assert.Equal(t, 1, r.Pending)
+	assert.Equal(t, 0, len(r.Stuck[a1]))
+	assert.Equal(t, 0, len(pt.Pending(a1)))
+}
+
+func TestPendingTrackerReconnect(t *testing.T) {
+	c := &fakePendingClient{mined: make(map[common.Hash]bool)}
+	pt := NewPendingTracker(c, time.Minute)
+	now := time.Now()
+	pt.now = func() time.Time { return now }
+	a1 := common.HexToAddress("0xa1")
+	tx0, tx1 := newTestTxWithNonce(0), newTestTxWithNonce(1)
+	pt.Track(a1, tx0)
+	pt.Track(a1, tx1)
+	// mined while disconnected, the tx must be kept rather than taken as mined or dropped
+	c.mine(tx0)
+	c.setDisconnected(true)
+	now = now.Add(2 * time.Minute)
+	r := pt.Check(context.Background())
+	// PendingTransactionCount, NonceAt of a1 and receipts of both txs
+	assert.Equal(t, 4, r.Errors)
+	assert.Equal(t, 0, len(r.Mined))
+	assert.Equal(t, 2, r.Pending)
+	assert.Equal(t, 2, len(r.Stuck[a1]))
+	assert.Equal(t, 2, len(pt.Pending(a1)))
+	// after reconnecting, tx0 is found mined
+	c.setDisconnected(false)
+	r = pt.Check(context.Background())
+	assert.Equal(t, 0, r.Errors)
+	assert.Equal(t, []common.Hash{tx0.Hash()}, r.Mined)
+	assert.Equal(t, 1, len(r.Stuck[a1]))
+	assert.Equal(t, tx1.Hash(), r.Stuck[a1][0].Hash)
+}
+
+func TestPendingTrackerReplaced(t *testing.T) {
+	c := &fakePendingClient{mined: make(map[common.Hash]bool)}
+	pt := NewPendingTracker(c, time.Minute)
+	a1 := common.HexToAddress("0xa1")
+	tx0, tx1, tx2 := newTestTxWithNonce(0), newTestTxWithNonce(1), newTestTxWithNonce(2)
+	pt.Track(a1, tx0)
+	pt.Track(a1, tx1)
+	pt.Track(a1, tx2)
+	// tx0 is mined, nonce 1 is used by a replacement of tx1 we don't know about
+	c.mine(tx0)
+	c.setNonce(a1, 2)
+	r := pt.Check(context.Background())
+	assert.Equal(t, []common.Hash{tx0.Hash()}, r.Mined)
+	assert.Equal(t, []common.Hash{tx1.Hash()}, r.Replaced)
+	assert.Equal(t, 1, r.Pending)
+	pending := pt.Pending(a1)
+	if assert.Equal(t, 1, len(pending)) {
+		assert.Equal(t, tx2.Hash(), pending[0].Hash)
+	}
+	// account nonce unknown while disconnected, nothing is taken as replaced
+	c.setNonce(a1, 3)
+	c.setDisconnected(true)
+	r = pt.Check(context.Background())
+	assert.Equal(t, 0, len(r.Replaced))
+	assert.Equal(t, 1, r.Pending)
+}
+
+func TestTrackTransaction(t *testing.T) {
+	c := &fakePendingClient{mined: make(map[common.Hash]bool)}
+	pt := NewPendingTracker(c, time.Minute)
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	for _, signer := range []types.Signer{types.HomesteadSigner{}, types.NewEIP155Signer(big.NewInt(8888))} {
+		tx, err := types.SignTx(newTestTxWithNonce(uint64(len(pt.Pending(from)))), signer, key)
+		assert.Nil(t, err)
+		trackTransaction(pt, tx)
+	}
+	assert.Equal(t, 2, len(pt.Pending(from)))
+}
+
+func TestPendingTrackerRun(t *testing.T) {
+	c := &fakePendingClient{mined: make(map[common.Hash]bool), disconnected: true}
+	pt := NewPendingTracker(c, time.Millisecond)
+	a1 := common.HexToAddress("0xa1")
+	tx0 := newTestTxWithNonce(0)
+	pt.Track(a1, tx0)
+	time.Sleep(2 * time.Millisecond)
+	reports := make(chan *PendingReport, 10)
+	reconnect := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	// long interval, only reconnect can trigger a check in time
+	go func() {
+		pt.Run(ctx, time.Hour, reconnect, func(r *PendingReport) {
+			reports <- r
+		})
+		close(done)
+	}()
+	reconnect <- struct{}{}
+	select {
+	case r := <-reports:
+		assert.Equal(t, 1, len(r.Stuck[a1]))
+		assert.True(t, r.Errors > 0)
+	case <-time.After(time.Second):
+		t.Fatal("no report after reconnect")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run should return after ctx canceled")
+	}
+}
@@ -0,0 +1,45 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//ErrTransactionConfirmed the tx is already mined, there is nothing to replace
+var ErrTransactionConfirmed = errors.New("transaction already confirmed")
+
+//TransactionGetter what MinReplacementGasPrice needs
+type TransactionGetter interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+}
+
+//replacementPriceBump geth txpool requires a replacement tx to pay at least 12.5%(1/8) more
+const replacementPriceBump = 8
+
+/*
+MinReplacementGasPrice 根据 hash 查询我们发出的还在 pending 的 tx, 返回替换它所需的最小 gasprice,
+即原来的 gasprice*1.125,向上取整, 用于加速交易. tx 已经被打包则返回 ErrTransactionConfirmed.
+*/
+/*
+ *	MinReplacementGasPrice : look up our pending tx by hash, and return the minimum gas price
+ *	a replacement tx with the same nonce must pay, which is original price * 1.125 rounded up.
+ *	It's used to speed up a stuck tx. If the tx is already mined, ErrTransactionConfirmed is returned.
+ */
+func MinReplacementGasPrice(ctx context.Context, client TransactionGetter, txHash common.Hash) (*big.Int, error) {
+	tx, isPending, err := client.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("get tx %s err %s", utils.HPex(txHash), err)
+	}
+	if !isPending {
+		return nil, ErrTransactionConfirmed
+	}
+	price := tx.GasPrice()
+	bump := new(big.Int).Div(new(big.Int).Add(price, big.NewInt(replacementPriceBump-1)), big.NewInt(replacementPriceBump))
+	return bump.Add(bump, price), nil
+}
@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransactionGetter knows a few txs, mined or pending
+type fakeTransactionGetter struct {
+	txs     map[common.Hash]*types.Transaction
+	pending map[common.Hash]bool
+}
+
+func newFakeTransactionGetter() *fakeTransactionGetter {
+	return &fakeTransactionGetter{
+		txs:     make(map[common.Hash]*types.Transaction),
+		pending: make(map[common.Hash]bool),
+	}
+}
+
+func (f *fakeTransactionGetter) add(gasPrice int64, pending bool) common.Hash {
+	tx := types.NewTransaction(uint64(len(f.txs)), common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(gasPrice), nil)
+	f.txs[tx.Hash()] = tx
+	f.pending[tx.Hash()] = pending
+	return tx.Hash()
+}
+
+func (f *fakeTransactionGetter) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := f.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, f.pending[hash], nil
+}
+
+func TestMinReplacementGasPricePending(t *testing.T) {
+	f := newFakeTransactionGetter()
+	cases := map[int64]int64{
+		8:           9,
+		18000000000: 20250000000,
+		1:           2,
+		10:          12, //11.25 rounded up
+		0:           0,
+	}
+	for original, expect := range cases {
+		price, err := MinReplacementGasPrice(context.Background(), f, f.add(original, true))
+		assert.Nil(t, err)
+		assert.EqualValues(t, expect, price.Int64(), "original %d", original)
+	}
+}
+
+func TestMinReplacementGasPriceMined(t *testing.T) {
+	f := newFakeTransactionGetter()
+	_, err := MinReplacementGasPrice(context.Background(), f, f.add(18000000000, false))
+	assert.Equal(t, ErrTransactionConfirmed, err)
+	// unknown tx
+	_, err = MinReplacementGasPrice(context.Background(), f, common.HexToHash("0x1234"))
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrTransactionConfirmed, err)
+}
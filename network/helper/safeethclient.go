@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"math/big"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"fmt"
 
@@ -16,21 +18,87 @@ import (
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 var errNotConnectd = errors.New("eth not connected")
 
+/*
+ErrConnectionFailed 连不上 geth, 交易根本没有发出去, 和交易被链拒绝区分开.
+*/
+/*
+ *	ErrConnectionFailed : can't reach chain, tx is not sent at all,
+ *	so callers can distinguish it from tx rejected by chain.
+ */
+type ErrConnectionFailed struct {
+	URL string
+}
+
+func (e *ErrConnectionFailed) Error() string {
+	return fmt.Sprintf("can't reach chain, eth rpc server %s not connected", e.URL)
+}
+
+//IsConnectionFailed returns true if err is ErrConnectionFailed
+func IsConnectionFailed(err error) bool {
+	_, ok := err.(*ErrConnectionFailed)
+	return ok
+}
+
+/*
+ErrInsufficientFunds 账户的 ETH 不够支付 gas, 交易被 geth 拒绝. 这不是连接问题, 重连没有用, 需要给账户充值.
+*/
+/*
+ *	ErrInsufficientFunds : tx is rejected because the account doesn't have enough ETH to pay for gas.
+ *	It's not a connection problem and reconnecting doesn't help, the account must be topped up.
+ */
+type ErrInsufficientFunds struct {
+	Err error //error returned by geth
+}
+
+func (e *ErrInsufficientFunds) Error() string {
+	return fmt.Sprintf("account doesn't have enough ETH to pay for gas, please top up: %s", e.Err)
+}
+
+//IsInsufficientFunds returns true if err is ErrInsufficientFunds or geth's "insufficient funds for gas" error
+func IsInsufficientFunds(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(*ErrInsufficientFunds); ok {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "insufficient funds")
+}
+
 //SafeEthClient how to recover from a restart of geth
 type SafeEthClient struct {
 	*ethclient.Client
+	//rpcClient underlying Client, for rpc methods ethclient doesn't have
+	rpcClient  *rpc.Client
 	lock       sync.Mutex
 	url        string
 	ReConnect  map[string]chan struct{}
 	Status     netshare.Status
 	StatusChan chan netshare.Status
 	quitChan   chan struct{}
+	breaker    atomic.Value //*CircuitBreaker, read by every call without lock
+	callGroup  *callGroup
+	metrics    MetricsProvider
+	//fallbackURLs are tried in order after url when reconnecting
+	fallbackURLs []string
+	//strictChainID refuses endpoints whose network id differs from the one observed on url
+	strictChainID bool
+	//networkID observed on url, nil if never connected to it
+	networkID *big.Int
+	//noBlockReceipts node doesn't support eth_getBlockReceipts, don't try it again
+	noBlockReceipts bool
+	//callTimeout limit of every call to the node, 0 means only the context of caller
+	callTimeout time.Duration
+	//pendingTracker tracks every tx sent by SendTransaction, nil means disabled
+	pendingTracker *PendingTracker
 }
 
 //NewSafeClient create safeclient
@@ -40,12 +108,17 @@ func NewSafeClient(rawurl string) (*SafeEthClient, error) {
 		url:        rawurl,
 		StatusChan: make(chan netshare.Status, 10),
 		quitChan:   make(chan struct{}),
+		callGroup:  newCallGroup(),
 	}
 	var err error
 	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-	c.Client, err = ethclient.DialContext(ctx, rawurl)
+	c.rpcClient, err = rpc.DialContext(ctx, rawurl)
 	cancelFunc()
+	if err == nil {
+		c.Client = ethclient.NewClient(c.rpcClient)
+	}
 	if err == nil && checkConnectStatus(c.Client) == nil {
+		c.observeNetworkID(c.Client)
 		c.changeStatus(netshare.Connected)
 	} else {
 		go c.RecoverDisconnect()
@@ -67,6 +140,154 @@ func (c *SafeEthClient) IsConnected() bool {
 	return c.Status == netshare.Connected
 }
 
+//SetCircuitBreaker stop rpc calls during prolonged disconnection, nil means disabled
+func (c *SafeEthClient) SetCircuitBreaker(cb *CircuitBreaker) {
+	c.breaker.Store(cb)
+}
+
+//circuitBreaker returns nil when disabled, methods of CircuitBreaker accept nil receiver
+func (c *SafeEthClient) circuitBreaker() *CircuitBreaker {
+	cb, _ := c.breaker.Load().(*CircuitBreaker)
+	return cb
+}
+
+//SetMetricsProvider records reconnect and connection status metrics, nil means disabled
+func (c *SafeEthClient) SetMetricsProvider(m MetricsProvider) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metrics = m
+	if m != nil {
+		m.ConnectionStatus(c.Status == netshare.Connected)
+	}
+}
+
+func (c *SafeEthClient) metricsProvider() MetricsProvider {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.metrics
+}
+
+/*
+StartPendingTracker 跟踪之后 SendTransaction 发出的每个交易, 每隔 interval 检查一次, 有交易等待超过 threshold 时调用 onStuck,
+重连成功以后立即检查一次, Close 以后停止.
+*/
+/*
+ *	StartPendingTracker : tracks every tx sent by SendTransaction later, checks them every interval
+ *	and calls onStuck when some are pending longer than threshold, a check is made right after reconnecting.
+ *	It stops after Close.
+ */
+func (c *SafeEthClient) StartPendingTracker(threshold, interval time.Duration, onStuck func(r *PendingReport)) *PendingTracker {
+	pt := NewPendingTracker(c, threshold)
+	reconnect := c.RegisterReConnectNotify("PendingTracker")
+	c.lock.Lock()
+	c.pendingTracker = pt
+	c.lock.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.quitChan
+		cancel()
+	}()
+	go pt.Run(ctx, interval, reconnect, onStuck)
+	return pt
+}
+
+//SetCallCoalescing concurrent identical CallContract share one underlying request when enabled
+func (c *SafeEthClient) SetCallCoalescing(enable bool) {
+	c.callGroup.setEnabled(enable)
+}
+
+/*
+SetCallTimeout 每次调用节点最多等待 d, 超时的调用被取消, 防止卡住的调用(比如 websocket 没有响应)一直占着锁.
+超时从调用方的 context 派生, 调用方的 context 先结束时也会取消. 0 表示只使用调用方的 context.
+*/
+/*
+ *	SetCallTimeout : every call to the node waits at most d, so a stuck call, e.g. on a dead websocket,
+ *	can't hold the lock forever. The timeout is derived from the context of caller, which still cancels the call if it ends first.
+ *	0 means only the context of caller is used.
+ */
+func (c *SafeEthClient) SetCallTimeout(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.callTimeout = d
+}
+
+//withCallTimeout ctx with callTimeout, caller must hold the lock
+func (c *SafeEthClient) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+/*
+SetFallbackURLs 重连时主节点连不上就依次尝试这些备用节点.
+strictChainID 为 true 时, 备用节点的 NetworkID 必须和主节点上看到的一致, 否则跳过,
+防止配置错误的备用节点指向另一条链, 切换以后给错误的链签名交易.
+*/
+/*
+ *	SetFallbackURLs : when reconnecting, these endpoints are tried in order if the primary one can't be reached.
+ *	If strictChainID is true, NetworkID of a fallback must equal the one observed on the primary, otherwise it's skipped,
+ *	so a misconfigured backup pointing to another chain never gets transactions signed for the wrong network.
+ */
+func (c *SafeEthClient) SetFallbackURLs(urls []string, strictChainID bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fallbackURLs = urls
+	c.strictChainID = strictChainID
+}
+
+//observeNetworkID remember network id of the primary endpoint
+func (c *SafeEthClient) observeNetworkID(client *ethclient.Client) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
+	defer cancelFunc()
+	id, err := client.NetworkID(ctx)
+	if err != nil {
+		log.Warn(fmt.Sprintf("get network id of %s err %s", c.url, err))
+		return
+	}
+	c.networkID = id
+}
+
+//dial connect to one endpoint, a fallback with another network id is refused when strictChainID is set
+func (c *SafeEthClient) dial(parent context.Context, rawurl string, strictChainID bool) (rpcClient *rpc.Client, err error) {
+	ctx, cancelFunc := context.WithTimeout(parent, params.EthRPCTimeout)
+	rpcClient, err = rpc.DialContext(ctx, rawurl)
+	cancelFunc()
+	if err != nil {
+		return nil, err
+	}
+	client := ethclient.NewClient(rpcClient)
+	err = checkConnectStatus(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if rawurl == c.url {
+		if c.networkID == nil {
+			c.observeNetworkID(client)
+		}
+		return
+	}
+	if !strictChainID {
+		return
+	}
+	if c.networkID == nil {
+		client.Close()
+		return nil, fmt.Errorf("network id of %s is unknown, refuse fallback %s", c.url, rawurl)
+	}
+	ctx, cancelFunc = context.WithTimeout(parent, params.EthRPCTimeout)
+	id, err := client.NetworkID(ctx)
+	cancelFunc()
+	if err == nil && id.Cmp(c.networkID) != 0 {
+		err = fmt.Errorf("network id of fallback %s is %s, but %s is %s", rawurl, id, c.url, c.networkID)
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return
+}
+
 //RegisterReConnectNotify register notify when reconnect
 func (c *SafeEthClient) RegisterReConnectNotify(name string) <-chan struct{} {
 	c.lock.Lock()
@@ -83,6 +304,9 @@ func (c *SafeEthClient) RegisterReConnectNotify(name string) <-chan struct{} {
 func (c *SafeEthClient) changeStatus(newStatus netshare.Status) {
 	log.Info(fmt.Sprintf("ethclient connection status changed from %d to %d", c.Status, newStatus))
 	c.Status = newStatus
+	if m := c.metricsProvider(); m != nil {
+		m.ConnectionStatus(newStatus == netshare.Connected)
+	}
 	select {
 	case c.StatusChan <- c.Status:
 	default:
@@ -92,8 +316,24 @@ func (c *SafeEthClient) changeStatus(newStatus netshare.Status) {
 
 //RecoverDisconnect try to reconnect with geth after a restart of geth
 func (c *SafeEthClient) RecoverDisconnect() {
+	err := c.RecoverDisconnectContext(context.Background())
+	if err != nil {
+		log.Info(fmt.Sprintf("RecoverDisconnect quit: %s", err))
+	}
+}
+
+/*
+RecoverDisconnectContext 和 RecoverDisconnect 一样, 但是 ctx 取消以后立即退出重连循环, 返回 ctx.Err().
+Close 以后也会退出, 返回 errNotConnectd.
+*/
+/*
+ *	RecoverDisconnectContext : same as RecoverDisconnect, but the reconnect loop exits promptly when ctx is canceled,
+ *	returning ctx.Err(). It exits after Close too, returning errNotConnectd.
+ */
+func (c *SafeEthClient) RecoverDisconnectContext(ctx context.Context) error {
 	var err error
-	var client *ethclient.Client
+	var rpcClient *rpc.Client
+	start := time.Now()
 	c.changeStatus(netshare.Reconnecting)
 	if c.Client != nil {
 		c.Client.Close()
@@ -102,21 +342,43 @@ func (c *SafeEthClient) RecoverDisconnect() {
 		log.Info("tyring to reconnect geth ...")
 		select {
 		case <-c.quitChan:
-			return
+			return errNotConnectd
+		case <-ctx.Done():
+			return ctx.Err()
 		default:
 			//never block
 		}
-		ctx, cancelFunc := context.WithTimeout(context.Background(), params.EthRPCTimeout)
-		client, err = ethclient.DialContext(ctx, c.url)
-		cancelFunc()
-		if err == nil {
-			err = checkConnectStatus(client)
+		if m := c.metricsProvider(); m != nil {
+			m.ReconnectAttempt()
+		}
+		c.lock.Lock()
+		urls := append([]string{c.url}, c.fallbackURLs...)
+		strictChainID := c.strictChainID
+		c.lock.Unlock()
+		for _, u := range urls {
+			rpcClient, err = c.dial(ctx, u, strictChainID)
+			if err == nil {
+				if u != c.url {
+					log.Warn(fmt.Sprintf("%s not available, use fallback %s", c.url, u))
+				}
+				break
+			}
+			log.Info(fmt.Sprintf("reconnect to %s error: %s", u, err))
 		}
 		if err == nil {
 			//reconnect ok
-			c.Client = client
+			if m := c.metricsProvider(); m != nil {
+				m.ReconnectDuration(time.Since(start))
+			}
+			c.lock.Lock()
+			c.rpcClient = rpcClient
+			c.Client = ethclient.NewClient(rpcClient)
+			//the endpoint may have changed, it may support eth_getBlockReceipts
+			c.noBlockReceipts = false
+			c.lock.Unlock()
 			c.changeStatus(netshare.Connected)
 			c.lock.Lock()
+			c.circuitBreaker().Reset()
 			var keys []string
 			for name, c := range c.ReConnect {
 				keys = append(keys, name)
@@ -127,10 +389,15 @@ func (c *SafeEthClient) RecoverDisconnect() {
 				delete(c.ReConnect, name)
 			}
 			c.lock.Unlock()
-			return
+			return nil
+		}
+		select {
+		case <-c.quitChan:
+			return errNotConnectd
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 3):
 		}
-		log.Info(fmt.Sprintf("reconnect to geth error: %s", err))
-		time.Sleep(time.Second * 3)
 	}
 }
 
@@ -138,7 +405,13 @@ func (c *SafeEthClient) RecoverDisconnect() {
 func (c *SafeEthClient) BlockByHash(ctx context.Context, hash common.Hash) (r1 *types.Block, err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	if err = c.circuitBreaker().Allow(); err != nil {
+		return
+	}
 	r1, err = c.Client.BlockByHash(ctx, hash)
+	c.circuitBreaker().Done(err)
 	return
 }
 
@@ -146,20 +419,34 @@ func (c *SafeEthClient) BlockByHash(ctx context.Context, hash common.Hash) (r1 *
 func (c *SafeEthClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.BlockByNumber(ctx, number)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.BlockByNumber(ctx, number)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 // HeaderByHash returns the block header with the given hash.
 func (c *SafeEthClient) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.HeaderByHash(ctx, hash)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.HeaderByHash(ctx, hash)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 // HeaderByNumber returns a block header from the current canonical chain. If number is
@@ -167,180 +454,353 @@ func (c *SafeEthClient) HeaderByHash(ctx context.Context, hash common.Hash) (*ty
 func (c *SafeEthClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.HeaderByNumber(ctx, number)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.HeaderByNumber(ctx, number)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //TransactionByHash wrapper of TransactionByHash
 func (c *SafeEthClient) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, false, errNotConnectd
 	}
-	return c.Client.TransactionByHash(ctx, hash)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, false, err
+	}
+	r1, r2, err := c.Client.TransactionByHash(ctx, hash)
+	c.circuitBreaker().Done(err)
+	return r1, r2, err
 }
 
 //TransactionSender wrapper of TransactionSender
 func (c *SafeEthClient) TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return common.Address{}, errNotConnectd
 	}
-	return c.Client.TransactionSender(ctx, tx, block, index)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return common.Address{}, err
+	}
+	r, err := c.Client.TransactionSender(ctx, tx, block, index)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 // TransactionCount returns the total number of transactions in the given block.
 func (c *SafeEthClient) TransactionCount(ctx context.Context, blockHash common.Hash) (uint, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return 0, errNotConnectd
 	}
-	return c.Client.TransactionCount(ctx, blockHash)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return 0, err
+	}
+	r, err := c.Client.TransactionCount(ctx, blockHash)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //TransactionInBlock wrapper of TransactionInBlock
 func (c *SafeEthClient) TransactionInBlock(ctx context.Context, blockHash common.Hash, index uint) (*types.Transaction, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.TransactionInBlock(ctx, blockHash, index)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.TransactionInBlock(ctx, blockHash, index)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //TransactionReceipt wrappper of TransactionReceipt
 func (c *SafeEthClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.TransactionReceipt(ctx, txHash)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.TransactionReceipt(ctx, txHash)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //SyncProgress wrapper of SyncProgress
 func (c *SafeEthClient) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.SyncProgress(ctx)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.SyncProgress(ctx)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //SubscribeNewHead wrapper of SubscribeNewHead
 func (c *SafeEthClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.SubscribeNewHead(ctx, ch)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.SubscribeNewHead(ctx, ch)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //NetworkID wrapper of NetworkID
 func (c *SafeEthClient) NetworkID(ctx context.Context) (*big.Int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.NetworkID(ctx)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.NetworkID(ctx)
+	c.circuitBreaker().Done(err)
+	return r, err
+}
+
+/*
+ChainID 通过 eth_chainId 获取 EIP-155 的 chain id, 节点不支持 eth_chainId 时使用 NetworkID.
+*/
+/*
+ *	ChainID : EIP-155 chain id by eth_chainId, NetworkID is used if the node doesn't support eth_chainId.
+ */
+func (c *SafeEthClient) ChainID(ctx context.Context) (*big.Int, error) {
+	c.lock.Lock()
+	if c.rpcClient == nil {
+		c.lock.Unlock()
+		return nil, errNotConnectd
+	}
+	if err := c.circuitBreaker().Allow(); err != nil {
+		c.lock.Unlock()
+		return nil, err
+	}
+	var r hexutil.Big
+	callCtx, cancel := c.withCallTimeout(ctx)
+	err := c.rpcClient.CallContext(callCtx, &r, "eth_chainId")
+	cancel()
+	if rerr, ok := err.(rpc.Error); ok && rerr.ErrorCode() == errCodeMethodNotFound {
+		c.circuitBreaker().Done(nil)
+		c.lock.Unlock()
+		return c.NetworkID(ctx)
+	}
+	c.circuitBreaker().Done(err)
+	c.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return (*big.Int)(&r), nil
+}
+
+//LookupDeployment contracts deployed on the chain this client connects to, see params.Deployments
+func (c *SafeEthClient) LookupDeployment(ctx context.Context) (*params.DeploymentAddresses, error) {
+	chainID, err := c.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return params.Deployments.Lookup(chainID)
 }
 
 //BalanceAt wrapper of BalanceAt
 func (c *SafeEthClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.BalanceAt(ctx, account, blockNumber)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.BalanceAt(ctx, account, blockNumber)
+	c.circuitBreaker().Done(err)
+	return r, err
+}
+
+//GetBalance balance of account at the latest block, same as BalanceAt(ctx, account, nil)
+func (c *SafeEthClient) GetBalance(ctx context.Context, account common.Address) (*big.Int, error) {
+	return c.BalanceAt(ctx, account, nil)
 }
 
 //StorageAt wrapper of StorageAt
 func (c *SafeEthClient) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.StorageAt(ctx, account, key, blockNumber)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.StorageAt(ctx, account, key, blockNumber)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //CodeAt wrapper of CodeAt
 func (c *SafeEthClient) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.CodeAt(ctx, account, blockNumber)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.CodeAt(ctx, account, blockNumber)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //NonceAt wrapper of NonceAt
 func (c *SafeEthClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return 0, errNotConnectd
 	}
-	return c.Client.NonceAt(ctx, account, blockNumber)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return 0, err
+	}
+	r, err := c.Client.NonceAt(ctx, account, blockNumber)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //FilterLogs wrapper of FilterLogs
 func (c *SafeEthClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.FilterLogs(ctx, q)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.FilterLogs(ctx, q)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //SubscribeFilterLogs wrapper of SubscribeFilterLogs
 func (c *SafeEthClient) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.SubscribeFilterLogs(ctx, q, ch)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.SubscribeFilterLogs(ctx, q, ch)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //PendingBalanceAt wrapper of PendingBalanceAt
 func (c *SafeEthClient) PendingBalanceAt(ctx context.Context, account common.Address) (*big.Int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.PendingBalanceAt(ctx, account)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.PendingBalanceAt(ctx, account)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //PendingStorageAt wrapper of PendingStorageAt
 func (c *SafeEthClient) PendingStorageAt(ctx context.Context, account common.Address, key common.Hash) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.PendingStorageAt(ctx, account, key)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.PendingStorageAt(ctx, account, key)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //PendingCodeAt wrapper of PendingCodeAt
 func (c *SafeEthClient) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.PendingCodeAt(ctx, account)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.PendingCodeAt(ctx, account)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //PendingNonceAt wrapper of PendingNonceAt
@@ -348,10 +808,16 @@ func (c *SafeEthClient) PendingCodeAt(ctx context.Context, account common.Addres
 func (c *SafeEthClient) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return 0, errNotConnectd
 	}
+	if err = c.circuitBreaker().Allow(); err != nil {
+		return
+	}
 	nonce, err = c.Client.PendingNonceAt(ctx, account)
+	c.circuitBreaker().Done(err)
 	return
 }
 
@@ -359,60 +825,392 @@ func (c *SafeEthClient) PendingNonceAt(ctx context.Context, account common.Addre
 func (c *SafeEthClient) PendingTransactionCount(ctx context.Context) (uint, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return 0, errNotConnectd
 	}
-	return c.Client.PendingTransactionCount(ctx)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return 0, err
+	}
+	r, err := c.Client.PendingTransactionCount(ctx)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //CallContract wrapper of CallContract
 func (c *SafeEthClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if key, ok := callKey(msg, blockNumber); ok {
+		return c.callGroup.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+			return c.callContract(ctx, msg, blockNumber)
+		})
+	}
+	return c.callContract(ctx, msg, blockNumber)
+}
+
+/*
+CallContractAs 以 from 作为 msg.sender 调用合约, 用于结果和调用者有关的 getter, 比如有访问控制的 view 函数.
+CallContract 的调用者常常不设置 From, 这时 msg.sender 是零地址.
+*/
+/*
+ *	CallContractAs : call contract `to` with msg.sender `from`, for getters whose result depends on the caller,
+ *	e.g. access-controlled view functions. Callers of CallContract often leave From empty, then msg.sender is the zero address.
+ */
+func (c *SafeEthClient) CallContractAs(ctx context.Context, from, to common.Address, data []byte, blockNumber *big.Int) ([]byte, error) {
+	return c.CallContract(ctx, ethereum.CallMsg{From: from, To: &to, Data: data}, blockNumber)
+}
+
+func (c *SafeEthClient) callContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.CallContract(ctx, msg, blockNumber)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.CallContract(ctx, msg, blockNumber)
+	c.circuitBreaker().Done(err)
+	return r, err
+}
+
+//block tags of CallContractAt, the same as the standard eth_call blockNumber parameter
+const (
+	BlockTagLatest   = "latest"
+	BlockTagPending  = "pending"
+	BlockTagEarliest = "earliest"
+)
+
+/*
+CallContractAt 在 blockTag 指定的状态上调用合约, blockTag 可以是 "latest", "pending", "earliest" 或者十六进制块号(比如 "0x64"),
+空字符串等于 "latest". 调用方不用再区分 CallContract 传 nil 还是块号, 还是调用 PendingCallContract.
+*/
+/*
+ *	CallContractAt : call contract at the state blockTag specifies, which is "latest", "pending", "earliest"
+ *	or a hex block number such as "0x64", empty means "latest".
+ *	Callers no longer choose between CallContract with nil or a block number and PendingCallContract.
+ */
+func (c *SafeEthClient) CallContractAt(ctx context.Context, msg ethereum.CallMsg, blockTag string) ([]byte, error) {
+	switch blockTag {
+	case "", BlockTagLatest:
+		return c.CallContract(ctx, msg, nil)
+	case BlockTagPending:
+		return c.PendingCallContract(ctx, msg)
+	case BlockTagEarliest:
+		return c.CallContract(ctx, msg, big.NewInt(0))
+	}
+	blockNumber, err := hexutil.DecodeBig(blockTag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block tag %q: %s", blockTag, err)
+	}
+	return c.CallContract(ctx, msg, blockNumber)
 }
 
 //PendingCallContract wrapper of PendingCallContract
 func (c *SafeEthClient) PendingCallContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.PendingCallContract(ctx, msg)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.PendingCallContract(ctx, msg)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //SuggestGasPrice wrapper of SuggestGasPrice
 func (c *SafeEthClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return nil, errNotConnectd
 	}
-	return c.Client.SuggestGasPrice(ctx)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	r, err := c.Client.SuggestGasPrice(ctx)
+	c.circuitBreaker().Done(err)
+	return r, err
 }
 
 //EstimateGas wrapper of EstimateGas
 func (c *SafeEthClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return 0, errNotConnectd
 	}
-	return c.Client.EstimateGas(ctx, msg)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return 0, err
+	}
+	r, err := c.Client.EstimateGas(ctx, msg)
+	c.circuitBreaker().Done(err)
+	return r, err
+}
+
+/*
+waitConnected 正在重连时最多等待 timeout, 连接已经关闭或者等待超时返回 ErrConnectionFailed
+*/
+// waitConnected : wait at most timeout if reconnecting, returns ErrConnectionFailed when closed or timeout.
+func (c *SafeEthClient) waitConnected(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status := c.Status
+		if status == netshare.Connected {
+			return nil
+		}
+		if status == netshare.Closed || !time.Now().Before(deadline) {
+			return &ErrConnectionFailed{URL: c.url}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
 }
 
-//SendTransaction wrapper of SendTransaction
+//SendTransaction wrapper of SendTransaction, returns ErrConnectionFailed if geth can not be reached
 func (c *SafeEthClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := c.waitConnected(params.EthReconnectWaitTimeout); err != nil {
+		return err
+	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return errNotConnectd
 	}
-	return c.Client.SendTransaction(ctx, tx)
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return err
+	}
+	err := c.Client.SendTransaction(ctx, tx)
+	c.circuitBreaker().Done(err)
+	if IsInsufficientFunds(err) {
+		err = &ErrInsufficientFunds{Err: err}
+		log.Error(fmt.Sprintf("send tx %s failed, %s", tx.Hash().String(), err))
+	}
+	if err == nil && c.pendingTracker != nil {
+		trackTransaction(c.pendingTracker, tx)
+	}
+	return err
+}
+
+//trackTransaction track tx by its sender recovered from signature
+func trackTransaction(pt *PendingTracker, tx *types.Transaction) {
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.NewEIP155Signer(tx.ChainId())
+	}
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		log.Warn(fmt.Sprintf("PendingTracker cannot recover sender of tx %s, %s", tx.Hash().String(), err))
+		return
+	}
+	pt.Track(from, tx)
+}
+
+/*
+SendRawTransaction 广播一个已经签好名的交易, 比如硬件钱包或者多签离线签名的交易, 返回节点给出的交易 hash.
+原样交给 eth_sendRawTransaction, 不在本地解码, 所以 vendor 中的 go-ethereum 不认识的交易类型(比如 EIP-1559)也可以发送.
+和 SendTransaction 一样, 连不上 geth 时返回 ErrConnectionFailed.
+*/
+/*
+ *	SendRawTransaction : broadcast a tx signed elsewhere, e.g. by hardware wallet or multi-sig, returns hash of the tx given by the node.
+ *	Bytes are passed to eth_sendRawTransaction as they are without decoding,
+ *	so tx types vendored go-ethereum doesn't know, e.g. EIP-1559, can be sent too.
+ *	Same as SendTransaction, ErrConnectionFailed is returned if geth can not be reached.
+ */
+func (c *SafeEthClient) SendRawTransaction(ctx context.Context, rawTx []byte) (hash common.Hash, err error) {
+	if len(rawTx) == 0 {
+		return utils.EmptyHash, errors.New("empty raw transaction")
+	}
+	if err = c.waitConnected(params.EthReconnectWaitTimeout); err != nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	if c.rpcClient == nil {
+		return utils.EmptyHash, errNotConnectd
+	}
+	if err = c.circuitBreaker().Allow(); err != nil {
+		return
+	}
+	err = c.rpcClient.CallContext(ctx, &hash, "eth_sendRawTransaction", hexutil.Bytes(rawTx))
+	c.circuitBreaker().Done(err)
+	if IsInsufficientFunds(err) {
+		err = &ErrInsufficientFunds{Err: err}
+		log.Error(fmt.Sprintf("send raw tx failed, %s", err))
+	}
+	return
+}
+
+//AccessTuple one entry of an EIP-2930 access list
+type AccessTuple struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []common.Hash  `json:"storageKeys"`
+}
+
+//AccessList EIP-2930 access list, vendored go-ethereum doesn't have types.AccessList yet
+type AccessList []AccessTuple
+
+/*
+AccessListAt 调用 eth_createAccessList 预先计算一次调用会访问的地址和存储, 常用的合约调用可以用它节省 gas.
+blockNumber 为 nil 表示最新块.
+*/
+/*
+ *	AccessListAt : pre-compute addresses and storage keys touched by a call with eth_createAccessList,
+ *	so callers can save gas on frequently-called contracts. nil blockNumber means the latest block.
+ */
+func (c *SafeEthClient) AccessListAt(ctx context.Context, from, to common.Address, gas uint64, data []byte, blockNumber *big.Int) (AccessList, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	if c.rpcClient == nil {
+		return nil, errNotConnectd
+	}
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	arg := map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"data": hexutil.Bytes(data),
+	}
+	if gas != 0 {
+		arg["gas"] = hexutil.Uint64(gas)
+	}
+	block := "latest"
+	if blockNumber != nil {
+		block = hexutil.EncodeBig(blockNumber)
+	}
+	var result struct {
+		AccessList AccessList `json:"accessList"`
+		Error      string     `json:"error"`
+	}
+	err := c.rpcClient.CallContext(ctx, &result, "eth_createAccessList", arg, block)
+	c.circuitBreaker().Done(err)
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return result.AccessList, nil
+}
+
+//errCodeMethodNotFound json-rpc error code when the node doesn't have the method
+const errCodeMethodNotFound = -32601
+
+//IsMethodNotFound returns true if err is the json-rpc error of a method the node doesn't have
+func IsMethodNotFound(err error) bool {
+	rerr, ok := err.(rpc.Error)
+	return ok && rerr.ErrorCode() == errCodeMethodNotFound
+}
+
+//receiptLogs only the logs of a receipt are needed
+type receiptLogs struct {
+	Logs []types.Log `json:"logs"`
+}
+
+/*
+GetBlockReceiptsAsLogs 一次性获取一个块中所有交易产生的 log.
+优先使用 eth_getBlockReceipts(geth 1.12+), 节点不支持时改为先获取块中所有交易 hash, 再逐个查询 receipt.
+查询期间不持有锁, 逐个查询可能有很多次 rpc 调用, 不能阻塞其他调用.
+*/
+/*
+ *	GetBlockReceiptsAsLogs : all logs of all transactions in a block, in the order of transactions.
+ *	It uses eth_getBlockReceipts (geth 1.12+) and falls back to fetching receipts one by one
+ *	if the node doesn't support it. The lock isn't held during the calls, the fallback may make many of them.
+ */
+func (c *SafeEthClient) GetBlockReceiptsAsLogs(ctx context.Context, blockHash common.Hash) ([]types.Log, error) {
+	c.lock.Lock()
+	rpcClient := c.rpcClient
+	noBlockReceipts := c.noBlockReceipts
+	url := c.url
+	ctx, cancel := c.withCallTimeout(ctx)
+	c.lock.Unlock()
+	defer cancel()
+	if rpcClient == nil {
+		return nil, errNotConnectd
+	}
+	if !noBlockReceipts {
+		if err := c.circuitBreaker().Allow(); err != nil {
+			return nil, err
+		}
+		var receipts []*receiptLogs
+		err := rpcClient.CallContext(ctx, &receipts, "eth_getBlockReceipts", blockHash)
+		if IsMethodNotFound(err) {
+			log.Info(fmt.Sprintf("eth_getBlockReceipts not supported by %s, fetch receipts one by one", url))
+			c.lock.Lock()
+			//it's about this endpoint only, another one may have been connected meanwhile
+			if c.rpcClient == rpcClient {
+				c.noBlockReceipts = true
+			}
+			c.lock.Unlock()
+			c.circuitBreaker().Done(nil)
+		} else {
+			c.circuitBreaker().Done(err)
+			if err != nil {
+				return nil, err
+			}
+			if receipts == nil {
+				return nil, ethereum.NotFound
+			}
+			var logs []types.Log
+			for _, r := range receipts {
+				logs = append(logs, r.Logs...)
+			}
+			return logs, nil
+		}
+	}
+	return c.getBlockReceiptsAsLogsOneByOne(ctx, rpcClient, blockHash)
+}
+
+//getBlockReceiptsAsLogsOneByOne fallback of GetBlockReceiptsAsLogs
+func (c *SafeEthClient) getBlockReceiptsAsLogsOneByOne(ctx context.Context, rpcClient *rpc.Client, blockHash common.Hash) ([]types.Log, error) {
+	if err := c.circuitBreaker().Allow(); err != nil {
+		return nil, err
+	}
+	var block *struct {
+		Transactions []common.Hash `json:"transactions"`
+	}
+	err := rpcClient.CallContext(ctx, &block, "eth_getBlockByHash", blockHash, false)
+	c.circuitBreaker().Done(err)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, ethereum.NotFound
+	}
+	var logs []types.Log
+	for _, txHash := range block.Transactions {
+		if err = c.circuitBreaker().Allow(); err != nil {
+			return nil, err
+		}
+		var r *receiptLogs
+		err = rpcClient.CallContext(ctx, &r, "eth_getTransactionReceipt", txHash)
+		c.circuitBreaker().Done(err)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			return nil, ethereum.NotFound
+		}
+		logs = append(logs, r.Logs...)
+	}
+	return logs, nil
 }
 
 // GenesisBlockHash :
@@ -420,10 +1218,16 @@ func (c *SafeEthClient) GenesisBlockHash(ctx context.Context) (genesisBlockHash
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
 	if c.Client == nil {
 		return utils.EmptyHash, errNotConnectd
 	}
+	if err = c.circuitBreaker().Allow(); err != nil {
+		return
+	}
 	genesisBlockHead, err := c.Client.HeaderByNumber(ctx, big.NewInt(1))
+	c.circuitBreaker().Done(err)
 	if err != nil {
 		return
 	}
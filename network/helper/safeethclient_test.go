@@ -0,0 +1,305 @@
+package helper
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendTransactionConnectionFailed(t *testing.T) {
+	old := params.EthReconnectWaitTimeout
+	params.EthReconnectWaitTimeout = 300 * time.Millisecond
+	defer func() {
+		params.EthReconnectWaitTimeout = old
+	}()
+	s := &FakeEthService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	c.url = "http://127.0.0.1:8545"
+	tx := types.NewTransaction(0, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+
+	// closed, fail immediately without touching client
+	c.Status = netshare.Closed
+	start := time.Now()
+	err := c.SendTransaction(context.Background(), tx)
+	assert.True(t, IsConnectionFailed(err))
+	assert.Contains(t, err.Error(), c.url)
+	assert.True(t, time.Since(start) < params.EthReconnectWaitTimeout)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&s.sent))
+
+	// reconnecting and not recovered in time
+	c.Status = netshare.Reconnecting
+	start = time.Now()
+	err = c.SendTransaction(context.Background(), tx)
+	assert.True(t, IsConnectionFailed(err))
+	assert.True(t, time.Since(start) >= params.EthReconnectWaitTimeout)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&s.sent))
+
+	// connected
+	c.Status = netshare.Connected
+	err = c.SendTransaction(context.Background(), tx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&s.sent))
+}
+
+func TestGetBalance(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	b, err := c.GetBalance(context.Background(), common.HexToAddress("0x1"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, big.NewInt(100), b)
+	assert.Equal(t, "latest", s.balanceBlock)
+}
+
+func TestSendRawTransaction(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	c.Status = netshare.Connected
+	key, _ := crypto.GenerateKey()
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, err := types.SignTx(types.NewTransaction(0, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil), signer, key)
+	assert.Nil(t, err)
+	raw, err := rlp.EncodeToBytes(tx)
+	assert.Nil(t, err)
+
+	hash, err := c.SendRawTransaction(context.Background(), raw)
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Hash(), hash)
+	assert.EqualValues(t, raw, s.rawTx)
+
+	// bytes are passed as they are, tx types we can't decode are left to the node
+	typed := append([]byte{0x02}, raw...)
+	hash, err = c.SendRawTransaction(context.Background(), typed)
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.Keccak256Hash(typed), hash)
+	assert.EqualValues(t, typed, s.rawTx)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&s.sent))
+
+	_, err = c.SendRawTransaction(context.Background(), nil)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&s.sent))
+
+	c.Status = netshare.Closed
+	_, err = c.SendRawTransaction(context.Background(), raw)
+	assert.True(t, IsConnectionFailed(err))
+}
+
+func TestAccessListAt(t *testing.T) {
+	s := &FakeEthService{}
+	c := newFakeSafeClient(t, s)
+	to := common.HexToAddress("0x2")
+	al, err := c.AccessListAt(context.Background(), common.HexToAddress("0x1"), to, 21000, []byte{0xaa}, big.NewInt(100))
+	assert.Nil(t, err)
+	assert.EqualValues(t, AccessList{{Address: to, StorageKeys: []common.Hash{{1}}}}, al)
+	assert.Equal(t, "0x64", s.accessListBlock)
+	_, err = c.AccessListAt(context.Background(), common.HexToAddress("0x1"), to, 0, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "latest", s.accessListBlock)
+
+	c.rpcClient = nil
+	_, err = c.AccessListAt(context.Background(), common.HexToAddress("0x1"), to, 0, nil, nil)
+	assert.Equal(t, errNotConnectd, err)
+}
+
+func TestRecoverDisconnectSkipMismatchedFallback(t *testing.T) {
+	other := newFakeChainServer(t, "3")
+	defer other.Close()
+	same := newFakeChainServer(t, "8888")
+	defer same.Close()
+	c := &SafeEthClient{
+		ReConnect:  make(map[string]chan struct{}),
+		url:        "http://127.0.0.1:1",
+		StatusChan: make(chan netshare.Status, 10),
+		quitChan:   make(chan struct{}),
+		callGroup:  newCallGroup(),
+		networkID:  big.NewInt(8888),
+	}
+	c.SetFallbackURLs([]string{other.URL, same.URL}, true)
+	c.RecoverDisconnect()
+	assert.Equal(t, netshare.Connected, c.Status)
+	id, err := c.NetworkID(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 8888, id.Int64())
+
+	//without strict chain id, the first reachable fallback is used
+	c.SetFallbackURLs([]string{other.URL, same.URL}, false)
+	c.RecoverDisconnect()
+	id, err = c.NetworkID(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, id.Int64())
+}
+
+func TestRecoverDisconnectContextCancel(t *testing.T) {
+	c := &SafeEthClient{
+		ReConnect:  make(map[string]chan struct{}),
+		url:        "http://127.0.0.1:1",
+		StatusChan: make(chan netshare.Status, 10),
+		quitChan:   make(chan struct{}),
+		callGroup:  newCallGroup(),
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.RecoverDisconnectContext(ctx)
+	}()
+	//let it fail once and wait for the next round
+	time.Sleep(100 * time.Millisecond)
+	cancelFunc()
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("RecoverDisconnectContext should return promptly after cancel")
+	}
+	assert.Equal(t, netshare.Reconnecting, c.Status)
+}
+
+func TestGetBlockReceiptsAsLogs(t *testing.T) {
+	for _, s := range []*FakeBlockReceiptsService{{}, nil} {
+		var c *SafeEthClient
+		var fallback *FakeReceiptService
+		if s != nil {
+			c = newFakeSafeClient(t, s)
+		} else {
+			fallback = &FakeReceiptService{}
+			c = newFakeSafeClient(t, fallback)
+		}
+		logs, err := c.GetBlockReceiptsAsLogs(context.Background(), common.Hash{3})
+		if !assert.Nil(t, err) {
+			continue
+		}
+		assert.Len(t, logs, 2)
+		assert.Equal(t, common.Hash{1}, logs[0].TxHash)
+		assert.Equal(t, common.Hash{2}, logs[1].TxHash)
+		assert.EqualValues(t, 1, logs[1].Index)
+		if s != nil {
+			assert.False(t, c.noBlockReceipts)
+			assert.EqualValues(t, 0, atomic.LoadInt32(&s.receiptCalls))
+		} else {
+			assert.True(t, c.noBlockReceipts)
+			assert.EqualValues(t, 2, atomic.LoadInt32(&fallback.receiptCalls))
+		}
+	}
+}
+
+func TestGetBlockReceiptsAsLogsUnlocked(t *testing.T) {
+	s := &FakeReceiptService{release: make(chan struct{})}
+	c := newFakeSafeClient(t, s)
+	c.noBlockReceipts = true
+	done := make(chan error)
+	go func() {
+		_, err := c.GetBlockReceiptsAsLogs(context.Background(), common.Hash{3})
+		done <- err
+	}()
+	for atomic.LoadInt32(&s.receiptCalls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	//other calls are not blocked by receipts being fetched
+	locked := make(chan struct{})
+	go func() {
+		c.SetCallTimeout(time.Second)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock held while fetching receipts")
+	}
+	close(s.release)
+	assert.Nil(t, <-done)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&s.receiptCalls))
+}
+
+func TestLookupDeployment(t *testing.T) {
+	c := newFakeSafeClient(t, &FakeChainService{})
+	chainID, err := c.ChainID(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, params.SpectrumChainID, chainID.Int64())
+	d, err := c.LookupDeployment(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, common.HexToAddress("0x08b7d79ec4ebd53e5b89c7c062cc64bb09d063e3"), d.TokenNetworkRegistry)
+	}
+}
+
+func TestCallContractAs(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	close(s.release)
+	c := newFakeSafeClient(t, s)
+	c.SetCallCoalescing(true)
+	from, to := common.HexToAddress("0xa1"), common.HexToAddress("0x1")
+	r, err := c.CallContractAs(context.Background(), from, to, []byte{0xaa, 0xbb}, big.NewInt(100))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte{1, 2, 3}, r)
+	s.callLock.Lock()
+	args, block := s.callArgs, s.callBlock
+	s.callLock.Unlock()
+	assert.Equal(t, strings.ToLower(from.String()), strings.ToLower(args["from"].(string)))
+	assert.Equal(t, strings.ToLower(to.String()), strings.ToLower(args["to"].(string)))
+	assert.Equal(t, "0xaabb", args["data"])
+	assert.Equal(t, "0x64", block)
+	// calls from different senders are never coalesced
+	k1, _ := callKey(ethereum.CallMsg{From: from, To: &to}, nil)
+	k2, _ := callKey(ethereum.CallMsg{To: &to}, nil)
+	assert.NotEqual(t, k1, k2)
+}
+
+func TestCallContractAt(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	close(s.release)
+	c := newFakeSafeClient(t, s)
+	to := common.HexToAddress("0x1")
+	msg := ethereum.CallMsg{To: &to}
+	for tag, expect := range map[string]string{
+		"":               "latest",
+		BlockTagLatest:   "latest",
+		BlockTagPending:  "pending",
+		BlockTagEarliest: "0x0",
+		"0x64":           "0x64",
+	} {
+		_, err := c.CallContractAt(context.Background(), msg, tag)
+		assert.Nil(t, err, tag)
+		s.callLock.Lock()
+		assert.Equal(t, expect, s.callBlock, tag)
+		s.callLock.Unlock()
+	}
+	calls := atomic.LoadInt32(&s.calls)
+	for _, tag := range []string{"safe", "100", "0x", "0xzz", "0x064"} {
+		_, err := c.CallContractAt(context.Background(), msg, tag)
+		assert.NotNil(t, err, tag)
+	}
+	// invalid tags never reach the node
+	assert.Equal(t, calls, atomic.LoadInt32(&s.calls))
+}
+
+func TestCallTimeout(t *testing.T) {
+	s := &FakeEthService{release: make(chan struct{})}
+	defer close(s.release)
+	c := newFakeSafeClient(t, s)
+	c.SetCallTimeout(100 * time.Millisecond)
+	to := common.HexToAddress("0x1")
+	start := time.Now()
+	_, err := c.CallContract(context.Background(), ethereum.CallMsg{To: &to}, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	// the stuck call doesn't hold the lock
+	_, err = c.GetBalance(context.Background(), to)
+	assert.Nil(t, err)
+
+	// parent context still cancels earlier
+	c.SetCallTimeout(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.CallContract(ctx, ethereum.CallMsg{To: &to}, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
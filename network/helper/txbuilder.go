@@ -0,0 +1,164 @@
+package helper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//TransactionBuilderClient what TransactionBuilder needs to fill fields not set, SafeEthClient implements it.
+type TransactionBuilderClient interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	ethereum.GasPricer
+	ethereum.GasEstimator
+}
+
+/*
+TransactionBuilder 构造 from 发出的交易, 各个 Set 方法可以链式调用.
+没有设置的字段在每次 Build 时从链上获取: nonce 用 PendingNonceAt, gasprice 用 SuggestGasPrice, gas 用 EstimateGas,
+获取的值不会保存到 builder 中, 所以每次 Build 都反映当前设置的字段.
+to 为空表示创建合约. 交易总是使用 EIP155 签名, 没有设置 chainID 时用 ChainID 获取, 防止交易在其他链上被重放.
+*/
+/*
+ *	TransactionBuilder : builds a tx sent by from, Set methods can be chained.
+ *	Fields not set are fetched from the chain by every Build: nonce by PendingNonceAt, gas price by SuggestGasPrice
+ *	and gas by EstimateGas. Fetched values are not kept in the builder, so each Build reflects the fields currently set.
+ *	Nil to means contract creation.
+ *	The tx is always signed by EIP155, chain id is fetched by ChainID if not set, so the tx can't be replayed on other chains.
+ */
+type TransactionBuilder struct {
+	client   TransactionBuilderClient
+	from     common.Address
+	to       *common.Address
+	value    *big.Int
+	nonce    *uint64
+	gas      uint64
+	gasPrice *big.Int
+	data     []byte
+	chainID  *big.Int
+}
+
+//NewTransactionBuilder create a TransactionBuilder of tx sent by from
+func NewTransactionBuilder(client TransactionBuilderClient, from common.Address) *TransactionBuilder {
+	return &TransactionBuilder{
+		client: client,
+		from:   from,
+		value:  new(big.Int),
+	}
+}
+
+//NewTransactionBuilder create a TransactionBuilder of tx sent by from, fields not set are fetched by c
+func (c *SafeEthClient) NewTransactionBuilder(from common.Address) *TransactionBuilder {
+	return NewTransactionBuilder(c, from)
+}
+
+//SetTo set receiver of tx
+func (b *TransactionBuilder) SetTo(to common.Address) *TransactionBuilder {
+	b.to = &to
+	return b
+}
+
+//SetValue set eth amount to transfer
+func (b *TransactionBuilder) SetValue(value *big.Int) *TransactionBuilder {
+	b.value = value
+	return b
+}
+
+//SetNonce set nonce, instead of PendingNonceAt
+func (b *TransactionBuilder) SetNonce(nonce uint64) *TransactionBuilder {
+	b.nonce = &nonce
+	return b
+}
+
+//SetGas set gas limit, instead of EstimateGas
+func (b *TransactionBuilder) SetGas(gas uint64) *TransactionBuilder {
+	b.gas = gas
+	return b
+}
+
+//SetGasPrice set gas price, instead of SuggestGasPrice
+func (b *TransactionBuilder) SetGasPrice(gasPrice *big.Int) *TransactionBuilder {
+	b.gasPrice = gasPrice
+	return b
+}
+
+//SetData set input data of tx
+func (b *TransactionBuilder) SetData(data []byte) *TransactionBuilder {
+	b.data = data
+	return b
+}
+
+//SetChainID sign tx by EIP155 with chainID, instead of ChainID
+func (b *TransactionBuilder) SetChainID(chainID *big.Int) *TransactionBuilder {
+	b.chainID = chainID
+	return b
+}
+
+//Build fill fields not set and create the unsigned tx, fields filled are not kept, a later Build fetches them again
+func (b *TransactionBuilder) Build(ctx context.Context) (*types.Transaction, error) {
+	var nonce uint64
+	if b.nonce != nil {
+		nonce = *b.nonce
+	} else {
+		n, err := b.client.PendingNonceAt(ctx, b.from)
+		if err != nil {
+			return nil, fmt.Errorf("get nonce of %s err %s", utils.APex2(b.from), err)
+		}
+		nonce = n
+	}
+	gasPrice := b.gasPrice
+	if gasPrice == nil {
+		p, err := b.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("suggest gas price err %s", err)
+		}
+		gasPrice = p
+	}
+	gas := b.gas
+	if gas == 0 {
+		g, err := b.client.EstimateGas(ctx, ethereum.CallMsg{
+			From:     b.from,
+			To:       b.to,
+			GasPrice: gasPrice,
+			Value:    b.value,
+			Data:     b.data,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("estimate gas err %s", err)
+		}
+		gas = g
+	}
+	if b.to == nil {
+		return types.NewContractCreation(nonce, b.value, gas, gasPrice, b.data), nil
+	}
+	return types.NewTransaction(nonce, *b.to, b.value, gas, gasPrice, b.data), nil
+}
+
+//Sign build the tx and sign it with key, key must be the key of from
+func (b *TransactionBuilder) Sign(ctx context.Context, key *ecdsa.PrivateKey) (*types.Transaction, error) {
+	if addr := crypto.PubkeyToAddress(key.PublicKey); addr != b.from {
+		return nil, fmt.Errorf("key of %s cannot sign tx from %s", utils.APex2(addr), utils.APex2(b.from))
+	}
+	tx, err := b.Build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chainID := b.chainID
+	if chainID == nil {
+		chainID, err = b.client.ChainID(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get chain id err %s", err)
+		}
+	}
+	return types.SignTx(tx, types.NewEIP155Signer(chainID), key)
+}
+
+var _ TransactionBuilderClient = &SafeEthClient{}
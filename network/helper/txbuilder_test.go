@@ -0,0 +1,129 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBuilderClient counts calls, and fails all of them if err is set
+type fakeBuilderClient struct {
+	nonceCalls, priceCalls, estimateCalls, chainIDCalls int
+	msg                                                 ethereum.CallMsg
+	err                                                 error
+}
+
+func (f *fakeBuilderClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	f.nonceCalls++
+	return uint64(6 + f.nonceCalls), f.err
+}
+
+func (f *fakeBuilderClient) ChainID(ctx context.Context) (*big.Int, error) {
+	f.chainIDCalls++
+	return big.NewInt(8888), f.err
+}
+
+func (f *fakeBuilderClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	f.priceCalls++
+	return big.NewInt(18000000000), f.err
+}
+
+func (f *fakeBuilderClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	f.estimateCalls++
+	f.msg = msg
+	return 50000 + uint64(len(msg.Data)), f.err
+}
+
+func TestTransactionBuilderFetch(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("0x1")
+	f := &fakeBuilderClient{}
+	tx, err := NewTransactionBuilder(f, from).SetTo(to).SetData([]byte{1, 2}).Sign(context.Background(), key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, tx.Nonce())
+	assert.EqualValues(t, 18000000000, tx.GasPrice().Int64())
+	assert.EqualValues(t, 50002, tx.Gas())
+	assert.Equal(t, to, *tx.To())
+	assert.EqualValues(t, []byte{1, 2}, tx.Data())
+	assert.Equal(t, from, f.msg.From)
+	assert.EqualValues(t, []byte{1, 2}, f.msg.Data)
+	// chain id is fetched, never Homestead
+	assert.EqualValues(t, 8888, tx.ChainId().Int64())
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(8888)), tx)
+	assert.Nil(t, err)
+	assert.Equal(t, from, sender)
+	assert.Equal(t, 1, f.nonceCalls)
+	assert.Equal(t, 1, f.priceCalls)
+	assert.Equal(t, 1, f.estimateCalls)
+	assert.Equal(t, 1, f.chainIDCalls)
+}
+
+func TestTransactionBuilderBuildTwice(t *testing.T) {
+	f := &fakeBuilderClient{}
+	b := NewTransactionBuilder(f, common.HexToAddress("0x2")).SetTo(common.HexToAddress("0x1")).SetData([]byte{1})
+	tx1, err := b.Build(context.Background())
+	assert.Nil(t, err)
+	// fields fetched by the first Build are not reused
+	tx2, err := b.SetData([]byte{1, 2, 3}).Build(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 7, tx1.Nonce())
+	assert.EqualValues(t, 8, tx2.Nonce())
+	assert.EqualValues(t, 50001, tx1.Gas())
+	assert.EqualValues(t, 50003, tx2.Gas())
+	assert.Equal(t, 2, f.priceCalls)
+	assert.Equal(t, 2, f.estimateCalls)
+}
+
+func TestTransactionBuilderSet(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	f := &fakeBuilderClient{err: errors.New("should not be called")}
+	tx, err := NewTransactionBuilder(f, from).
+		SetTo(common.HexToAddress("0x1")).
+		SetValue(big.NewInt(3)).
+		SetNonce(2).
+		SetGas(21000).
+		SetGasPrice(big.NewInt(1)).
+		SetChainID(big.NewInt(8888)).
+		Sign(context.Background(), key)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, tx.Nonce())
+	assert.EqualValues(t, 21000, tx.Gas())
+	assert.EqualValues(t, 1, tx.GasPrice().Int64())
+	assert.EqualValues(t, 3, tx.Value().Int64())
+	assert.EqualValues(t, 8888, tx.ChainId().Int64())
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(8888)), tx)
+	assert.Nil(t, err)
+	assert.Equal(t, from, sender)
+	assert.Equal(t, 0, f.nonceCalls+f.priceCalls+f.estimateCalls+f.chainIDCalls)
+
+	// contract creation
+	tx, err = NewTransactionBuilder(f, from).SetNonce(0).SetGas(100000).SetGasPrice(big.NewInt(1)).Build(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, tx.To())
+}
+
+func TestTransactionBuilderErrors(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	f := &fakeBuilderClient{err: errors.New("connection refused")}
+	_, err := NewTransactionBuilder(f, from).Sign(context.Background(), key)
+	assert.NotNil(t, err)
+	_, err = NewTransactionBuilder(f, from).SetNonce(1).SetGasPrice(big.NewInt(1)).Sign(context.Background(), key)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, f.estimateCalls)
+	// key of another account
+	f.err = nil
+	_, err = NewTransactionBuilder(f, from).Sign(context.Background(), other)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, f.nonceCalls, "nothing fetched for a wrong key")
+}
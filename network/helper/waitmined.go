@@ -0,0 +1,274 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//DefaultReceiptPollInterval poll interval of WaitMined when not specified, the same as bind.WaitMined
+const DefaultReceiptPollInterval = time.Second
+
+//ErrWaitMinedCanceled returned by WaitMinedOrCancel when it's canceled before tx is mined
+var ErrWaitMinedCanceled = errors.New("wait for tx to be mined canceled")
+
+//ReceiptClient what WaitMined needs
+type ReceiptClient interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+//headSubscriber clients support SubscribeNewHead, only works over websocket or ipc
+type headSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+/*
+WaitMined 等待 tx 被打包, 返回 receipt, 和 bind.WaitMined 相同, 但是:
+1. pollInterval 可以在每次调用时指定, 0 表示 DefaultReceiptPollInterval
+2. useNewHead 为 true 并且 client 支持 SubscribeNewHead 时, 每个新块查询一次 receipt, 而不是定时查询, 延迟更小.
+同一个 client 上同时等待的所有交易共用一个新块订阅, 不会每个交易都订阅一次.
+订阅失败(比如 http 连接不支持订阅)或者订阅中断时, 改为定时查询.
+*/
+/*
+ *	WaitMined : wait for tx to be mined and return its receipt, like bind.WaitMined, but
+ *	1. pollInterval is specified per call, 0 means DefaultReceiptPollInterval.
+ *	2. if useNewHead is true and client supports SubscribeNewHead, receipt is queried on every new head
+ *	instead of on a timer, which reduces latency. All txs waited for on the same client share one new head subscription,
+ *	instead of subscribing once per tx.
+ *	If subscription fails, e.g. http doesn't support it, or is broken, it falls back to polling.
+ */
+func WaitMined(ctx context.Context, client ReceiptClient, tx *types.Transaction, pollInterval time.Duration, useNewHead bool) (*types.Receipt, error) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultReceiptPollInterval
+	}
+	receipt := queryReceipt(ctx, client, tx)
+	if receipt != nil {
+		return receipt, nil
+	}
+	if s, ok := client.(headSubscriber); ok && useNewHead {
+		receipt, err := waitMinedByNewHead(ctx, client, s, tx)
+		if receipt != nil || err != nil {
+			return receipt, err
+		}
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		receipt = queryReceipt(ctx, client, tx)
+		if receipt != nil {
+			return receipt, nil
+		}
+	}
+}
+
+/*
+WaitMinedOrCancel 和 WaitMined 相同, 但是 cancel 可读时放弃等待, 返回 ErrWaitMinedCanceled.
+比如等待 UpdateBalanceProof 打包时 settle 窗口就要结束了, 上层可以放弃等待去做别的事情.
+放弃的只是等待, 交易已经发出, 仍然可能被打包. cancel 和打包同时发生时返回 receipt.
+*/
+/*
+ *	WaitMinedOrCancel : the same as WaitMined, but gives up waiting with ErrWaitMinedCanceled once cancel is readable.
+ *	e.g. when the settle window is closing while waiting for UpdateBalanceProof to be mined, the caller can abandon
+ *	the wait and take another action. Only the wait is abandoned, tx is sent already and may still be mined.
+ *	If tx is mined at the same time as cancel, the receipt is returned.
+ */
+func WaitMinedOrCancel(ctx context.Context, client ReceiptClient, tx *types.Transaction, pollInterval time.Duration, useNewHead bool, cancel <-chan struct{}) (*types.Receipt, error) {
+	ctx, cancelCtx := context.WithCancel(ctx)
+	defer cancelCtx()
+	canceled := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			close(canceled)
+			cancelCtx()
+		case <-ctx.Done():
+		}
+	}()
+	receipt, err := WaitMined(ctx, client, tx, pollInterval, useNewHead)
+	if err != nil {
+		select {
+		case <-canceled:
+			log.Trace(fmt.Sprintf("WaitMined %s canceled", utils.HPex(tx.Hash())))
+			return nil, ErrWaitMinedCanceled
+		default:
+		}
+	}
+	return receipt, err
+}
+
+//waitMinedByNewHead returns nil receipt and nil error when subscription is not available, caller should poll instead.
+func waitMinedByNewHead(ctx context.Context, client ReceiptClient, s headSubscriber, tx *types.Transaction) (*types.Receipt, error) {
+	heads, leave := joinHeadHub(s)
+	if heads == nil {
+		return nil, nil
+	}
+	defer leave()
+	//the tx may be mined before subscription
+	receipt := queryReceipt(ctx, client, tx)
+	if receipt != nil {
+		return receipt, nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case _, ok := <-heads:
+			if !ok {
+				log.Trace(fmt.Sprintf("WaitMined %s subscription broken, fall back to polling", utils.HPex(tx.Hash())))
+				return nil, nil
+			}
+		}
+		receipt = queryReceipt(ctx, client, tx)
+		if receipt != nil {
+			return receipt, nil
+		}
+	}
+}
+
+//headSubscribeTimeout timeout of SubscribeNewHead shared by all waiters
+const headSubscribeTimeout = 10 * time.Second
+
+//headHub one new head subscription of a client shared by all WaitMined waiting on it
+type headHub struct {
+	s       headSubscriber
+	sub     ethereum.Subscription
+	heads   chan *types.Header
+	waiters map[chan struct{}]bool
+	quit    chan struct{}
+}
+
+//headHubs subscribed clients, guards waiters of every hub too
+var headHubs = struct {
+	sync.Mutex
+	m map[headSubscriber]*headHub
+}{m: make(map[headSubscriber]*headHub)}
+
+/*
+joinHeadHub 返回的 channel 在 s 的每个新块以后可读, 订阅中断时被关闭. 第一个等待者订阅, 最后一个等待者调用 leave 以后取消订阅.
+订阅失败时返回 nil.
+*/
+/*
+ *	joinHeadHub : returned channel is readable after every new head of s, it's closed if the subscription is broken.
+ *	The first waiter subscribes, the subscription is canceled after the last waiter calls leave.
+ *	nil is returned if subscription is not available.
+ */
+func joinHeadHub(s headSubscriber) (heads chan struct{}, leave func()) {
+	//clients which can't be map keys are never shared
+	if !reflect.TypeOf(s).Comparable() {
+		return nil, nil
+	}
+	var duplicate *headHub
+	headHubs.Lock()
+	h := headHubs.m[s]
+	if h == nil {
+		//subscribe out of the lock, it may take up to headSubscribeTimeout and would block waiters of all clients
+		headHubs.Unlock()
+		h = newHeadHub(s)
+		if h == nil {
+			return nil, nil
+		}
+		headHubs.Lock()
+		if installed := headHubs.m[s]; installed != nil {
+			//another waiter subscribed meanwhile, share its subscription
+			duplicate, h = h, installed
+		} else {
+			headHubs.m[s] = h
+			go h.loop()
+		}
+	}
+	heads = make(chan struct{}, 1)
+	h.waiters[heads] = true
+	headHubs.Unlock()
+	if duplicate != nil {
+		duplicate.sub.Unsubscribe()
+	}
+	leave = func() {
+		headHubs.Lock()
+		defer headHubs.Unlock()
+		//already removed if subscription is broken
+		if !h.waiters[heads] {
+			return
+		}
+		delete(h.waiters, heads)
+		if len(h.waiters) == 0 {
+			delete(headHubs.m, h.s)
+			close(h.quit)
+		}
+	}
+	return
+}
+
+//newHeadHub subscribe new heads of s, nil if subscription is not available
+func newHeadHub(s headSubscriber) *headHub {
+	h := &headHub{
+		s:       s,
+		heads:   make(chan *types.Header, 10),
+		waiters: make(map[chan struct{}]bool),
+		quit:    make(chan struct{}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), headSubscribeTimeout)
+	sub, err := s.SubscribeNewHead(ctx, h.heads)
+	cancel()
+	if err != nil {
+		log.Trace(fmt.Sprintf("WaitMined SubscribeNewHead err %s, fall back to polling", err))
+		return nil
+	}
+	h.sub = sub
+	return h
+}
+
+func (h *headHub) loop() {
+	defer h.sub.Unsubscribe()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case err := <-h.sub.Err():
+			log.Trace(fmt.Sprintf("WaitMined new head subscription err %v", err))
+			headHubs.Lock()
+			if headHubs.m[h.s] == h {
+				delete(headHubs.m, h.s)
+			}
+			for w := range h.waiters {
+				delete(h.waiters, w)
+				close(w)
+			}
+			headHubs.Unlock()
+			return
+		case <-h.heads:
+			headHubs.Lock()
+			for w := range h.waiters {
+				select {
+				case w <- struct{}{}:
+				default:
+				}
+			}
+			headHubs.Unlock()
+		}
+	}
+}
+
+func queryReceipt(ctx context.Context, client ReceiptClient, tx *types.Transaction) *types.Receipt {
+	receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+	if receipt != nil {
+		return receipt
+	}
+	if err != nil {
+		log.Trace(fmt.Sprintf("WaitMined %s receipt retrieval failed %s", utils.HPex(tx.Hash()), err))
+	}
+	return nil
+}
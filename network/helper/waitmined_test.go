@@ -0,0 +1,257 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeReceiptClient tx is mined after `minedAfter` queries
+type fakeReceiptClient struct {
+	lock       sync.Mutex
+	queries    int
+	minedAfter int
+}
+
+func (c *fakeReceiptClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.queries++
+	if c.queries > c.minedAfter {
+		return &types.Receipt{TxHash: txHash, Status: types.ReceiptStatusSuccessful}, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (c *fakeReceiptClient) queryCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.queries
+}
+
+//fakeHeadClient new heads are sent by test
+type fakeHeadClient struct {
+	fakeReceiptClient
+	feed       event.Feed
+	subErr     error
+	subscribes int
+	//subscribing SubscribeNewHead blocks until release is closed if it's not nil
+	subscribing chan struct{}
+	release     chan struct{}
+}
+
+func (c *fakeHeadClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if c.subErr != nil {
+		return nil, c.subErr
+	}
+	if c.release != nil {
+		close(c.subscribing)
+		<-c.release
+	}
+	c.lock.Lock()
+	c.subscribes++
+	c.lock.Unlock()
+	return c.feed.Subscribe(ch), nil
+}
+
+func newTestTx() *types.Transaction {
+	return types.NewTransaction(0, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+}
+
+func TestWaitMinedPolling(t *testing.T) {
+	c := &fakeReceiptClient{minedAfter: 3}
+	tx := newTestTx()
+	start := time.Now()
+	r, err := WaitMined(context.Background(), c, tx, 10*time.Millisecond, true)
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Hash(), r.TxHash)
+	assert.Equal(t, 4, c.queryCount())
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+	assert.True(t, time.Since(start) < DefaultReceiptPollInterval)
+
+	// canceled
+	c = &fakeReceiptClient{minedAfter: 1000}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = WaitMined(ctx, c, tx, 10*time.Millisecond, false)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestWaitMinedByNewHead(t *testing.T) {
+	// long poll interval, only new heads can make it return in time
+	c := &fakeHeadClient{fakeReceiptClient: fakeReceiptClient{minedAfter: 4}}
+	tx := newTestTx()
+	done := make(chan *types.Receipt)
+	go func() {
+		r, err := WaitMined(context.Background(), c, tx, time.Hour, true)
+		assert.Nil(t, err)
+		done <- r
+	}()
+	// first query and the one after subscription
+	for c.queryCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	var r *types.Receipt
+	for i := int64(0); r == nil; i++ {
+		c.feed.Send(&types.Header{Number: big.NewInt(i)})
+		select {
+		case r = <-done:
+		case <-time.After(10 * time.Millisecond):
+		}
+		if i > 100 {
+			t.Fatal("not woken up by new heads")
+		}
+	}
+	assert.Equal(t, tx.Hash(), r.TxHash)
+	assert.Equal(t, 5, c.queryCount())
+}
+
+func TestWaitMinedShareNewHead(t *testing.T) {
+	// mined after every waiter queried twice
+	c := &fakeHeadClient{fakeReceiptClient: fakeReceiptClient{minedAfter: 6}}
+	done := make(chan *types.Receipt)
+	for i := 0; i < 3; i++ {
+		go func() {
+			r, err := WaitMined(context.Background(), c, newTestTx(), time.Hour, true)
+			assert.Nil(t, err)
+			done <- r
+		}()
+	}
+	for c.queryCount() < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	//waiters may subscribe at the same time, but only one subscription is kept
+	assert.Equal(t, 1, c.feed.Send(&types.Header{Number: big.NewInt(1)}))
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-done:
+			assert.NotNil(t, r)
+		case <-time.After(time.Second):
+			t.Fatal("not woken up by new heads")
+		}
+	}
+	// the last waiter unsubscribes
+	for c.feed.Send(&types.Header{}) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	headHubs.Lock()
+	assert.Nil(t, headHubs.m[c])
+	headHubs.Unlock()
+}
+
+func TestWaitMinedSubscribeUnlocked(t *testing.T) {
+	slow := &fakeHeadClient{subscribing: make(chan struct{}), release: make(chan struct{})}
+	go func() {
+		_, leave := joinHeadHub(slow)
+		leave()
+	}()
+	<-slow.subscribing
+	// a slow subscription doesn't block waiters of other clients
+	c := &fakeHeadClient{}
+	joined := make(chan struct{})
+	go func() {
+		heads, leave := joinHeadHub(c)
+		assert.NotNil(t, heads)
+		leave()
+		close(joined)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by subscription of another client")
+	}
+	close(slow.release)
+}
+
+func TestWaitMinedSubscriptionBroken(t *testing.T) {
+	c := &fakeHeadClient{fakeReceiptClient: fakeReceiptClient{minedAfter: 4}}
+	done := make(chan *types.Receipt)
+	go func() {
+		r, err := WaitMined(context.Background(), c, newTestTx(), 10*time.Millisecond, true)
+		assert.Nil(t, err)
+		done <- r
+	}()
+	for c.queryCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	headHubs.Lock()
+	h := headHubs.m[c]
+	headHubs.Unlock()
+	// waiters poll after the subscription is broken
+	h.sub.Unsubscribe()
+	select {
+	case r := <-done:
+		assert.NotNil(t, r)
+	case <-time.After(time.Second):
+		t.Fatal("not fall back to polling")
+	}
+}
+
+func TestWaitMinedSubscribeFailed(t *testing.T) {
+	// http doesn't support subscription, fall back to polling
+	c := &fakeHeadClient{
+		fakeReceiptClient: fakeReceiptClient{minedAfter: 2},
+		subErr:            errors.New("notifications not supported"),
+	}
+	r, err := WaitMined(context.Background(), c, newTestTx(), 10*time.Millisecond, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, r)
+	assert.Equal(t, 3, c.queryCount())
+}
+
+func TestWaitMinedOrCancel(t *testing.T) {
+	tx := newTestTx()
+	// canceled while polling
+	c := &fakeReceiptClient{minedAfter: 1000}
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		close(cancel)
+	}()
+	start := time.Now()
+	r, err := WaitMinedOrCancel(context.Background(), c, tx, 10*time.Millisecond, false, cancel)
+	assert.Equal(t, ErrWaitMinedCanceled, err)
+	assert.Nil(t, r)
+	assert.True(t, time.Since(start) < DefaultReceiptPollInterval)
+
+	// canceled while waiting for new heads, which never come
+	hc := &fakeHeadClient{fakeReceiptClient: fakeReceiptClient{minedAfter: 1000}}
+	cancel = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := WaitMinedOrCancel(context.Background(), hc, tx, time.Hour, true, cancel)
+		done <- err
+	}()
+	for hc.queryCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(cancel)
+	select {
+	case err = <-done:
+		assert.Equal(t, ErrWaitMinedCanceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait for new heads not canceled")
+	}
+
+	// mined before cancel
+	c = &fakeReceiptClient{minedAfter: 2}
+	r, err = WaitMinedOrCancel(context.Background(), c, tx, 10*time.Millisecond, false, make(chan struct{}))
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Hash(), r.TxHash)
+
+	// ctx still works
+	c = &fakeReceiptClient{minedAfter: 1000}
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancelCtx()
+	_, err = WaitMinedOrCancel(ctx, c, tx, 10*time.Millisecond, false, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
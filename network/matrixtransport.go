@@ -230,7 +230,7 @@ func (m *MatrixTransport) HandleMessage(from common.Address, data []byte) {
 		return
 	}
 	if m.protocol != nil {
-		m.protocol.receive(data)
+		m.protocol.receiveFrom(from, data)
 	}
 }
 
@@ -137,11 +137,14 @@ type PhotonProtocol struct {
 	sendingQueueMap           map[string]*queueMessagesAndLock
 	receivedMessageSaver      ReceivedMessageSaver
 	ChannelStatusGetter       ChannelStatusGetter
-	onStop                    bool //flag for stop
+	RateLimiter               *PeerRateLimiter               //limits incoming messages of every partner, nil means no limit
+	peerCapabilities          map[common.Address]uint32      //capabilities of peers from their acks, guarded by mapLock
+	pingEchoes                map[common.Address]common.Hash //echo of the latest ping to every peer, guarded by mapLock
+	onStop                    bool                           //flag for stop
 	//notify quit
 	quitChan chan struct{}
 	//receive data
-	receiveChan chan []byte
+	receiveChan chan *receivedData
 	log         log.Logger
 	isReceiving bool
 }
@@ -160,8 +163,10 @@ func NewPhotonProtocol(transport Transporter, privKey *ecdsa.PrivateKey, channel
 		sendingQueueMap:           make(map[string]*queueMessagesAndLock),
 		ChannelStatusGetter:       channelStatusGetter,
 		quitChan:                  make(chan struct{}),
-		receiveChan:               make(chan []byte, 200),
+		receiveChan:               make(chan *receivedData, 200),
 		mapLock:                   sync.Mutex{},
+		peerCapabilities:          make(map[common.Address]uint32),
+		pingEchoes:                make(map[common.Address]common.Hash),
 	}
 	rp.nodeAddr = crypto.PubkeyToAddress(privKey.PublicKey)
 	transport.RegisterProtocol(rp)
@@ -211,9 +216,38 @@ func (p *PhotonProtocol) SendPing(receiver common.Address) error {
 		return err
 	}
 	data := ping.Pack()
+	p.mapLock.Lock()
+	p.pingEchoes[receiver] = utils.Sha3(data, receiver[:])
+	p.mapLock.Unlock()
 	return p.sendRawWitNoAck(receiver, data)
 }
 
+/*
+PeerSupports 节点 peer 是否支持 capability, 比如 encoding.CapabilityPaymentReceipt.
+节点的能力来自它的 Ack, 在收到它的 Ack 之前(比如刚启动)总是返回 false.
+Ack 没有签名, 所以只接受回复我们发给 peer 的消息或者 ping 的 Ack, 其他人无法预知 echo,
+但是能够窃听我们和 peer 之间通信的人仍然可以伪造, 最坏的结果是我们向 peer 发送它不认识的消息, 然后不断重发.
+*/
+/*
+ *	PeerSupports : whether peer supports capability, e.g. encoding.CapabilityPaymentReceipt.
+ *	Capabilities of a peer come from its acks, it's always false before an ack of peer is received, e.g. just after startup.
+ *	Acks are not signed, so only acks answering messages or pings we sent to peer are accepted, others cannot predict the echo,
+ *	but someone eavesdropping on us and peer can still forge it, the worst result is that we send peer messages it doesn't know
+ *	and keep resending them.
+ */
+func (p *PhotonProtocol) PeerSupports(peer common.Address, capability uint32) bool {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	return p.peerCapabilities[peer]&capability == capability
+}
+
+//updatePeerCapabilities must hold mapLock
+func (p *PhotonProtocol) updatePeerCapabilities(ackMsg *encoding.Ack, msgState *SentMessageState) {
+	if msgState != nil && msgState.ReceiverAddress == ackMsg.Sender || p.pingEchoes[ackMsg.Sender] == ackMsg.Echo {
+		p.peerCapabilities[ackMsg.Sender] = ackMsg.Capabilities
+	}
+}
+
 /*
 	message mediatedTransfer  can safely be discarded when channel not exist only more
 	当channel被移除后,可以安全的移除待发送的消息,否则会导致新channel无法使用
@@ -423,6 +457,66 @@ func (p *PhotonProtocol) sendWithResult(receiver common.Address,
 	return
 }
 
+/*
+Retransmit 立即发送 msg, 不经过通道的发送队列. 对方发现 nonce 不连续请求重发时使用,
+这时队列前面的消息在等待对方的 ack, 而对方在收到丢失的消息之前不会 ack, 经过队列就永远发不出去.
+如果 msg 已经在等待 ack, 只是在队列之外重发, 返回 nil, ack 仍然通知原来的发送者.
+*/
+/*
+ *	Retransmit : send msg at once, not through the sending queue of its channel. It's for retransmitting messages
+ *	partner asks for after a nonce gap, messages ahead in the queue are waiting for ack, which partner never sends
+ *	before it gets the lost messages, so msg would never leave the queue.
+ *	If msg is already waiting for ack, it's only resent out of the queue and nil is returned, the ack still goes to the original sender.
+ */
+func (p *PhotonProtocol) Retransmit(receiver common.Address, msg encoding.Messager) *utils.AsyncResult {
+	if p.onStop {
+		return utils.NewAsyncResult()
+	}
+	data := msg.Pack()
+	echohash := utils.Sha3(data, receiver[:])
+	p.mapLock.Lock()
+	msgState, ok := p.SentHashesToChannel[echohash]
+	if ok && !msgState.Success {
+		p.mapLock.Unlock()
+		go p.resendUntilAck(receiver, msgState)
+		return nil
+	}
+	msgState = &SentMessageState{
+		AsyncResult:     utils.NewAsyncResult(),
+		ReceiverAddress: receiver,
+		AckChannel:      make(chan error, 1),
+		Message:         msg,
+		Data:            data,
+		EchoHash:        echohash,
+	}
+	p.SentHashesToChannel[echohash] = msgState
+	p.mapLock.Unlock()
+	go p.sendMessage(receiver, msgState)
+	return msgState.AsyncResult
+}
+
+//resendUntilAck send a message waiting for ack again and again, but leave the ack to the goroutine waiting for it
+func (p *PhotonProtocol) resendUntilAck(receiver common.Address, msgState *SentMessageState) {
+	nextTimeout := timeoutExponentialBackoff(p.retryTimes, p.retryInterval, p.retryInterval*10)
+	for {
+		p.mapLock.Lock()
+		success := msgState.Success
+		p.mapLock.Unlock()
+		if success || !p.messageCanBeSent(msgState.Message) {
+			return
+		}
+		err := p.sendRawWitNoAck(receiver, msgState.Data)
+		if err != nil {
+			p.log.Info(fmt.Sprintf("resend msg echoHash=%s error %s", utils.HPex(msgState.EchoHash), err.Error()))
+		}
+		select {
+		case <-time.After(nextTimeout()):
+		case <-p.quitChan:
+			return
+		}
+	}
+}
+
 // SendAndWait send this packet and wait ack until timeout
 func (p *PhotonProtocol) SendAndWait(receiver common.Address, msg encoding.Messager, timeout time.Duration) error {
 	var err error
@@ -453,13 +547,23 @@ func (p *PhotonProtocol) GetNetworkStatus(addr common.Address) (deviceType strin
 	return p.Transport.NodeStatus(addr)
 }
 
+//receivedData data from transport, from is empty if transport doesn't know the sender, e.g. udp
+type receivedData struct {
+	from common.Address
+	data []byte
+}
+
 func (p *PhotonProtocol) receive(data []byte) {
+	p.receiveFrom(utils.EmptyAddress, data)
+}
+
+func (p *PhotonProtocol) receiveFrom(from common.Address, data []byte) {
 	//todo fix 使用可以反复使用的缓冲区,而不是每次都分配.
 	cdata := make([]byte, len(data))
 	copy(cdata, data)
 
 	//p.log.Trace(fmt.Sprintf("try to send receive data l=%d,message=%s", len(cdata), encoding.MessageType(cdata[0])))
-	p.receiveChan <- cdata
+	p.receiveChan <- &receivedData{from, cdata}
 	//p.log.Trace(fmt.Sprintf("receive complete l=%d", len(cdata)))
 }
 
@@ -469,13 +573,14 @@ func (p *PhotonProtocol) loop() {
 		select {
 		case <-p.quitChan:
 			return
-		case data := <-p.receiveChan:
-			p.receiveInternal(data)
+		case d := <-p.receiveChan:
+			p.receiveInternal(d.from, d.data)
 		}
 	}
 }
 
-func (p *PhotonProtocol) receiveInternal(data []byte) {
+//receiveInternal from is the sender known by transport, empty if unknown
+func (p *PhotonProtocol) receiveInternal(from common.Address, data []byte) {
 	if len(data) > params.UDPMaxMessageSize {
 		p.log.Error("receive packet larger than maximum size :", len(data))
 		return
@@ -491,21 +596,49 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 		return
 	}
 	messager = New(messager).(encoding.Messager)
+	isAck := cmdid == encoding.AckCmdID
+	echohash := utils.Sha3(data, p.nodeAddr[:])
+	/*
+		已经处理过的消息直接回复保存的 ack, 它们是对方没有收到 ack 的重试, 不受限速影响.
+		ack 是对我们的消息的回复, 也不限速.
+	*/
+	// messages already handled are answered with the saved ack, they are retries of partner missing our ack and never limited,
+	// neither are acks, which answer our own messages.
+	if p.receivedMessageSaver != nil && !isAck {
+		ackdata := p.receivedMessageSaver.GetAck(echohash)
+		if len(ackdata) > 0 {
+			if from == utils.EmptyAddress {
+				err := messager.UnPack(data)
+				if err != nil {
+					p.log.Warn(fmt.Sprintf("message unpack error : %s", err))
+					return
+				}
+				sm, ok := messager.(encoding.SignedMessager)
+				if !ok {
+					p.log.Error(fmt.Sprintf("received a message %s, not ack ,and don't signed", messager))
+					return
+				}
+				from = sm.GetSender()
+			}
+			p.sendRawAck(from, ackdata)
+			return
+		}
+	}
+	//transport knows the sender, check it before recovering the signature, which costs much more
+	if p.RateLimiter != nil && !isAck && from != utils.EmptyAddress && !p.RateLimiter.Allow(from) {
+		p.log.Trace(fmt.Sprintf("drop %s from %s, rate limited", encoding.MessageType(data[0]), utils.APex2(from)))
+		return
+	}
 	err := messager.UnPack(data)
 	if err != nil {
 		p.log.Warn(fmt.Sprintf("message unpack error : %s", err))
 		return
 	}
-	echohash := utils.Sha3(data, p.nodeAddr[:])
-	if p.receivedMessageSaver != nil && messager.Cmd() != encoding.AckCmdID {
-		ackdata := p.receivedMessageSaver.GetAck(echohash)
-		if len(ackdata) > 0 {
-			sm, ok := messager.(encoding.SignedMessager)
-			if !ok {
-				p.log.Error(fmt.Sprintf("received a message %s, not ack ,and don't signed", messager))
-				return
-			}
-			p.sendRawAck(sm.GetSender(), ackdata)
+	//otherwise sender is recovered from its signature when unpacking, so this is the earliest point to know it.
+	if p.RateLimiter != nil && !isAck && from == utils.EmptyAddress {
+		sm, ok := messager.(encoding.SignedMessager)
+		if ok && !p.RateLimiter.Allow(sm.GetSender()) {
+			p.log.Trace(fmt.Sprintf("drop %s from %s, rate limited", messager, utils.APex2(sm.GetSender())))
 			return
 		}
 	}
@@ -514,6 +647,7 @@ func (p *PhotonProtocol) receiveInternal(data []byte) {
 		p.log.Debug(fmt.Sprintf("receive ack ,EchoHash=%s", utils.HPex(ackMsg.Echo)))
 		p.mapLock.Lock()
 		msgState, ok := p.SentHashesToChannel[ackMsg.Echo]
+		p.updatePeerCapabilities(ackMsg, msgState)
 		if ok && msgState.Success == false {
 			msgState.AckChannel <- nil
 			close(msgState.AckChannel)
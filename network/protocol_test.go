@@ -15,12 +15,16 @@ import (
 
 	"fmt"
 
+	"net"
+
 	"github.com/SmartMeshFoundation/Photon/encoding"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func init() {
@@ -206,3 +210,61 @@ func TestPhotonProtocolSendMediatedTransferExpired(t *testing.T) {
 	}
 
 }
+
+func TestPhotonProtocolPeerSupports(t *testing.T) {
+	port1, port2 := randomPort(), randomPort()+1000
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	t1, t2 := MakeTestUDPTransport("p1", port1), MakeTestUDPTransport("p2", port2)
+	p1 := NewPhotonProtocol(t1, key1, &testChannelStatusGetter{})
+	p2 := NewPhotonProtocol(t2, key2, &testChannelStatusGetter{})
+	nodes := map[common.Address]*net.UDPAddr{
+		p1.nodeAddr: {IP: net.ParseIP("127.0.0.1"), Port: port1},
+		p2.nodeAddr: {IP: net.ParseIP("127.0.0.1"), Port: port2},
+	}
+	t1.setHostPort(nodes)
+	t2.setHostPort(nodes)
+	p1.Start(true)
+	p2.Start(true)
+	defer p1.StopAndWait()
+	defer p2.StopAndWait()
+	if p1.PeerSupports(p2.nodeAddr, encoding.CapabilityPaymentReceipt) {
+		t.Error("should not know capabilities before any ack")
+		return
+	}
+	//acks not answering our messages are ignored
+	forged := encoding.NewAck(p2.nodeAddr, utils.NewRandomHash())
+	p1.receiveInternal(utils.EmptyAddress, forged.Pack())
+	if p1.PeerSupports(p2.nodeAddr, encoding.CapabilityPaymentReceipt) {
+		t.Error("should ignore ack of unknown echo")
+		return
+	}
+	//ack of ping
+	err := p1.SendPing(p2.nodeAddr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 50 && !p1.PeerSupports(p2.nodeAddr, encoding.CapabilityPaymentReceipt); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !p1.PeerSupports(p2.nodeAddr, encoding.CapabilityPaymentReceipt) {
+		t.Error("should know capabilities from ack of ping")
+		return
+	}
+	//ack of message
+	revealSecretMsg := encoding.NewRevealSecret(utils.ShaSecret([]byte{12}))
+	revealSecretMsg.Sign(p2.privKey, revealSecretMsg)
+	go func() {
+		<-p1.ReceivedMessageChan
+		p1.ReceivedMessageResultChan <- nil
+	}()
+	err = p2.SendAndWait(p1.nodeAddr, revealSecretMsg, time.Minute)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !p2.PeerSupports(p1.nodeAddr, encoding.CapabilityPaymentReceipt) {
+		t.Error("should know capabilities from ack")
+	}
+}
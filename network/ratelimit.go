@@ -0,0 +1,205 @@
+package network
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//banWindow dropped messages of a partner are counted in windows of this length to decide a ban
+const banWindow = time.Minute
+
+//peerIdleTimeout a partner not heard from for this long is forgotten unless it's banned
+const peerIdleTimeout = 10 * time.Minute
+
+//maxRateLimitedPeers at most this many partners are remembered, the one heard from least recently is forgotten first
+const maxRateLimitedPeers = 10000
+
+/*
+PeerRateLimiter 对每个 partner 收到的消息分别限速, 每个 partner 一个 TokenBucket, 容量是 burst, 每秒补充 rate 个.
+没有 token 的消息直接丢弃, 不回复 ack, 对方会按照自己的重试策略重发.
+一个 banWindow 内被丢弃的消息达到 banThreshold 时, 在 banDuration 内丢弃这个 partner 的所有消息.
+空闲超过 peerIdleTimeout 的 partner 会被忘记, 最多记住 maxRateLimitedPeers 个.
+*/
+/*
+ *	PeerRateLimiter : limits incoming messages of every partner separately, a TokenBucket for each partner,
+ *	with capacity burst and filled by rate tokens every second.
+ *	Messages without token are dropped without ack, partner will resend them by its retry policy.
+ *	A partner gets banned for banDuration when banThreshold of its messages are dropped in a banWindow,
+ *	all its messages are dropped meanwhile.
+ *	Partners idle for peerIdleTimeout are forgotten, at most maxRateLimitedPeers are remembered.
+ */
+type PeerRateLimiter struct {
+	rate         float64
+	burst        int
+	banThreshold int
+	banDuration  time.Duration
+	timeFunc     timeFunc
+	lock         sync.Mutex
+	peers        map[common.Address]*peerLimit
+	lastExpire   time.Time
+	/*
+		OnThrottle is called when a partner starts being throttled, or is banned.
+		It's called once for every throttling, not for every dropped message.
+	*/
+	OnThrottle func(peer common.Address, banned bool)
+}
+
+type peerLimit struct {
+	bucket      *TokenBucket
+	allowed     int64
+	dropped     int64
+	throttled   bool
+	windowStart time.Time
+	windowDrops int
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+//PeerRateLimitStatus what PeerRateLimiter knows about a partner
+type PeerRateLimitStatus struct {
+	Peer        common.Address `json:"peer"`
+	Tokens      float64        `json:"tokens"`
+	Allowed     int64          `json:"allowed"`
+	Dropped     int64          `json:"dropped"`
+	Throttled   bool           `json:"throttled"`
+	BannedUntil *time.Time     `json:"banned_until,omitempty"`
+}
+
+//RateLimitStatus limits of PeerRateLimiter and status of partners
+type RateLimitStatus struct {
+	Rate         float64                `json:"rate"`
+	Burst        int                    `json:"burst"`
+	BanThreshold int                    `json:"ban_threshold"`
+	BanDuration  int64                  `json:"ban_duration"` //seconds
+	Peers        []*PeerRateLimitStatus `json:"peers"`
+}
+
+//NewPeerRateLimiter create a PeerRateLimiter, banThreshold 0 means never ban
+func NewPeerRateLimiter(rate float64, burst int, banThreshold int, banDuration time.Duration, timeFunc ...timeFunc) *PeerRateLimiter {
+	l := &PeerRateLimiter{
+		rate:         rate,
+		burst:        burst,
+		banThreshold: banThreshold,
+		banDuration:  banDuration,
+		peers:        make(map[common.Address]*peerLimit),
+		timeFunc:     time.Now,
+	}
+	if len(timeFunc) == 1 {
+		l.timeFunc = timeFunc[0]
+	}
+	return l
+}
+
+//Allow consume a token of peer, false if the message of peer should be dropped
+func (l *PeerRateLimiter) Allow(peer common.Address) bool {
+	l.lock.Lock()
+	allowed, throttle, banned := l.allow(peer)
+	l.lock.Unlock()
+	if throttle && l.OnThrottle != nil {
+		l.OnThrottle(peer, banned)
+	}
+	return allowed
+}
+
+//allow throttle is true when OnThrottle should be called
+func (l *PeerRateLimiter) allow(peer common.Address) (allowed, throttle, banned bool) {
+	now := l.timeFunc()
+	if now.Sub(l.lastExpire) > peerIdleTimeout {
+		l.expire(now)
+	}
+	p, ok := l.peers[peer]
+	if !ok {
+		if len(l.peers) >= maxRateLimitedPeers {
+			l.evictOldest()
+		}
+		p = &peerLimit{bucket: NewTokenBucket(float64(l.burst), l.rate, l.timeFunc)}
+		l.peers[peer] = p
+	}
+	p.lastSeen = now
+	if now.Before(p.bannedUntil) {
+		p.dropped++
+		return false, false, true
+	}
+	if p.bucket.Consume(1) <= 0 {
+		p.allowed++
+		p.throttled = false
+		return true, false, false
+	}
+	//give the token back, a dropped message costs nothing
+	p.bucket.Tokens++
+	p.dropped++
+	throttle = !p.throttled
+	p.throttled = true
+	if l.banThreshold <= 0 {
+		return
+	}
+	if now.Sub(p.windowStart) > banWindow {
+		p.windowStart = now
+		p.windowDrops = 0
+	}
+	p.windowDrops++
+	if p.windowDrops >= l.banThreshold {
+		p.bannedUntil = now.Add(l.banDuration)
+		p.windowDrops = 0
+		return false, true, true
+	}
+	return
+}
+
+//expire forget partners idle for peerIdleTimeout, a full bucket is the same as a new one, must hold lock
+func (l *PeerRateLimiter) expire(now time.Time) {
+	l.lastExpire = now
+	for peer, p := range l.peers {
+		if now.Sub(p.lastSeen) > peerIdleTimeout && !now.Before(p.bannedUntil) {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+//evictOldest forget the partner heard from least recently, must hold lock
+func (l *PeerRateLimiter) evictOldest() {
+	var oldest common.Address
+	var oldestSeen time.Time
+	for peer, p := range l.peers {
+		if oldestSeen.IsZero() || p.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = peer, p.lastSeen
+		}
+	}
+	delete(l.peers, oldest)
+}
+
+//Status limits and every partner whose messages have been received, in order of address
+func (l *PeerRateLimiter) Status() *RateLimitStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.timeFunc()
+	status := &RateLimitStatus{
+		Rate:         l.rate,
+		Burst:        l.burst,
+		BanThreshold: l.banThreshold,
+		BanDuration:  int64(l.banDuration / time.Second),
+		Peers:        []*PeerRateLimitStatus{},
+	}
+	for peer, p := range l.peers {
+		p.bucket.getTokens()
+		s := &PeerRateLimitStatus{
+			Peer:      peer,
+			Tokens:    p.bucket.Tokens,
+			Allowed:   p.allowed,
+			Dropped:   p.dropped,
+			Throttled: p.throttled,
+		}
+		if now.Before(p.bannedUntil) {
+			bannedUntil := p.bannedUntil
+			s.BannedUntil = &bannedUntil
+		}
+		status.Peers = append(status.Peers, s)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Peer.String() < status.Peers[j].Peer.String()
+	})
+	return status
+}
@@ -0,0 +1,187 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	timeFunc := func() time.Time {
+		return now
+	}
+	type throttle struct {
+		peer   common.Address
+		banned bool
+	}
+	var throttles []throttle
+	l := NewPeerRateLimiter(2, 3, 4, time.Minute, timeFunc)
+	l.OnThrottle = func(peer common.Address, banned bool) {
+		throttles = append(throttles, throttle{peer, banned})
+	}
+	p1, p2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	// burst
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow(p1))
+	}
+	assert.False(t, l.Allow(p1))
+	assert.False(t, l.Allow(p1))
+	// one event for a throttling, not for every dropped message
+	assert.EqualValues(t, []throttle{{p1, false}}, throttles)
+	// other partners are not affected
+	assert.True(t, l.Allow(p2))
+	// refilled by 2 every second
+	now = now.Add(time.Second)
+	assert.True(t, l.Allow(p1))
+	assert.True(t, l.Allow(p1))
+	assert.False(t, l.Allow(p1))
+	assert.EqualValues(t, []throttle{{p1, false}, {p1, false}}, throttles)
+	// 4 drops in a minute ban p1
+	assert.False(t, l.Allow(p1))
+	assert.EqualValues(t, []throttle{{p1, false}, {p1, false}, {p1, true}}, throttles)
+	status := l.Status()
+	assert.EqualValues(t, 2, status.Rate)
+	assert.EqualValues(t, 3, status.Burst)
+	assert.EqualValues(t, 60, status.BanDuration)
+	assert.Len(t, status.Peers, 2)
+	for _, s := range status.Peers {
+		if s.Peer == p1 {
+			assert.EqualValues(t, 5, s.Allowed)
+			assert.EqualValues(t, 4, s.Dropped)
+			assert.True(t, s.Throttled)
+			if assert.NotNil(t, s.BannedUntil) {
+				assert.Equal(t, now.Add(time.Minute), *s.BannedUntil)
+			}
+		} else {
+			assert.EqualValues(t, 1, s.Allowed)
+			assert.Nil(t, s.BannedUntil)
+		}
+	}
+	// banned even with tokens
+	now = now.Add(30 * time.Second)
+	assert.False(t, l.Allow(p1))
+	assert.Len(t, throttles, 3)
+	// ban expires
+	now = now.Add(31 * time.Second)
+	assert.True(t, l.Allow(p1))
+	assert.Nil(t, l.Status().Peers[0].BannedUntil)
+	assert.Nil(t, l.Status().Peers[1].BannedUntil)
+}
+
+func TestPeerRateLimiterNeverBan(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewPeerRateLimiter(1, 1, 0, time.Minute, func() time.Time {
+		return now
+	})
+	p := utils.NewRandomAddress()
+	assert.True(t, l.Allow(p))
+	for i := 0; i < 100; i++ {
+		assert.False(t, l.Allow(p))
+	}
+	now = now.Add(time.Second)
+	assert.True(t, l.Allow(p))
+}
+
+func TestPeerRateLimiterExpire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewPeerRateLimiter(1, 1, 1, time.Hour, func() time.Time {
+		return now
+	})
+	idle, banned := utils.NewRandomAddress(), utils.NewRandomAddress()
+	assert.True(t, l.Allow(idle))
+	assert.True(t, l.Allow(banned))
+	assert.False(t, l.Allow(banned))
+	now = now.Add(peerIdleTimeout + time.Second)
+	assert.True(t, l.Allow(utils.NewRandomAddress()))
+	// idle partner is forgotten, banned one is not
+	peers := l.Status().Peers
+	assert.Len(t, peers, 2)
+	for _, s := range peers {
+		assert.NotEqual(t, idle, s.Peer)
+	}
+	assert.False(t, l.Allow(banned))
+}
+
+func TestPeerRateLimiterCap(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewPeerRateLimiter(0.001, 1, 0, time.Minute, func() time.Time {
+		return now
+	})
+	first := utils.NewRandomAddress()
+	assert.True(t, l.Allow(first))
+	for i := 1; i < maxRateLimitedPeers; i++ {
+		now = now.Add(time.Millisecond)
+		l.Allow(utils.NewRandomAddress())
+	}
+	assert.Len(t, l.peers, maxRateLimitedPeers)
+	assert.False(t, l.Allow(first))
+	// first is the least recently seen now
+	now = now.Add(time.Millisecond)
+	l.Allow(utils.NewRandomAddress())
+	assert.Len(t, l.peers, maxRateLimitedPeers)
+	_, ok := l.peers[first]
+	assert.True(t, ok)
+}
+
+//recordingTransport records receivers of every message sent
+type recordingTransport struct {
+	sent []common.Address
+}
+
+func (t *recordingTransport) Send(receiver common.Address, data []byte) error {
+	t.sent = append(t.sent, receiver)
+	return nil
+}
+func (t *recordingTransport) Start()                                        {}
+func (t *recordingTransport) Stop()                                         {}
+func (t *recordingTransport) StopAccepting()                                {}
+func (t *recordingTransport) RegisterProtocol(protcol ProtocolReceiver)     {}
+func (t *recordingTransport) NodeStatus(addr common.Address) (string, bool) { return "", true }
+
+type mapMessageSaver map[common.Hash][]byte
+
+func (s mapMessageSaver) GetAck(echohash common.Hash) []byte {
+	return s[echohash]
+}
+func (s mapMessageSaver) SaveAck(echohash common.Hash, msg encoding.Messager, ack []byte) {
+	s[echohash] = ack
+}
+
+func TestPhotonProtocolRateLimit(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	partnerKey, _ := crypto.GenerateKey()
+	partner := crypto.PubkeyToAddress(partnerKey.PublicKey)
+	tr := &recordingTransport{}
+	p := NewPhotonProtocol(tr, key, &testChannelStatusGetter{})
+	saver := make(mapMessageSaver)
+	p.SetReceivedMessageSaver(saver)
+	p.RateLimiter = NewPeerRateLimiter(0.001, 1, 0, time.Minute)
+	newPing := func(nonce int64) []byte {
+		ping := encoding.NewPing(nonce)
+		err := ping.Sign(partnerKey, ping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ping.Pack()
+	}
+	// sender known by transport
+	p.receiveInternal(partner, newPing(1))
+	assert.Equal(t, []common.Address{partner}, tr.sent)
+	dropped := newPing(2)
+	p.receiveInternal(partner, dropped)
+	assert.Len(t, tr.sent, 1)
+	// sender recovered from signature
+	p.receiveInternal(utils.EmptyAddress, newPing(3))
+	assert.Len(t, tr.sent, 1)
+	// messages already handled are answered from the ack cache, even if partner is limited
+	saver[utils.Sha3(dropped, p.nodeAddr[:])] = encoding.NewAck(p.nodeAddr, utils.NewRandomHash()).Pack()
+	p.receiveInternal(partner, dropped)
+	p.receiveInternal(utils.EmptyAddress, dropped)
+	assert.Equal(t, []common.Address{partner, partner, partner}, tr.sent)
+}
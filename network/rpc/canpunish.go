@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//PunishReader is the part of token network needed by CanPunish, TokenNetworkProxy implements it.
+type PunishReader interface {
+	SettleBlockReader
+	QueryUnlockedLocks(participant, partner common.Address, lockHash common.Hash) (bool, error)
+}
+
+//PunishEvidenceReader is where AnnounceDisposed received from partner are kept, models.Dao implements it.
+type PunishEvidenceReader interface {
+	GetChannelAnnounceDisposed(channelIdentifier common.Hash) []*models.ReceivedAnnounceDisposed
+}
+
+var _ PunishReader = &TokenNetworkProxy{}
+var _ PunishEvidenceReader = models.Dao(nil)
+
+/*
+CanPunish 检查 beneficiary 现在能否惩罚 cheater, 不能的时候返回原因, 方便运维人员排查.
+需要同时满足:
+1. 通道已经关闭, 还没有 settle
+2. 还在惩罚窗口内, 即当前块不超过 settle_block_number + punish_block_number, 之后任何人都可以 settle 通道
+3. 我们持有 cheater 声明放弃某个锁的签名, 并且这个锁已经被 cheater 在链上 unlock
+*/
+/*
+ *	CanPunish : check whether beneficiary can punish cheater right now, reason is returned when it can't, which aids operators.
+ *	All these are required:
+ *	1. channel is closed and not settled yet
+ *	2. still within the punish window, latest block is not after settle_block_number + punish_block_number, after that anyone can settle the channel
+ *	3. we hold a disposal signature of some lock from cheater, and cheater has unlocked this lock on chain
+ */
+func CanPunish(ctx context.Context, client HeaderReader, tokenNetwork PunishReader, evidence PunishEvidenceReader, beneficiary, cheater common.Address) (ok bool, reason string, err error) {
+	channelID, settleBlockNumber, openBlockNumber, state, _, err := tokenNetwork.GetChannelInfo(beneficiary, cheater)
+	if err != nil {
+		return
+	}
+	//state 2 means closed
+	if state != 2 {
+		reason = fmt.Sprintf("channel is not closed, state=%d", state)
+		return
+	}
+	punishBlockNumber, err := tokenNetwork.PunishBlockNumber()
+	if err != nil {
+		return
+	}
+	latest, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	if latest.Number.Uint64() > settleBlockNumber+punishBlockNumber {
+		reason = fmt.Sprintf("punish window passed, latest block %d, window ends at %d", latest.Number.Uint64(), settleBlockNumber+punishBlockNumber)
+		return
+	}
+	disposed := evidence.GetChannelAnnounceDisposed(channelID)
+	if len(disposed) == 0 {
+		reason = fmt.Sprintf("no AnnounceDisposed received from %s", utils.APex2(cheater))
+		return
+	}
+	for _, ad := range disposed {
+		//AnnounceDisposed of previous channel between us
+		if ad.OpenBlockNumber != int64(openBlockNumber) {
+			continue
+		}
+		var unlocked bool
+		unlocked, err = tokenNetwork.QueryUnlockedLocks(beneficiary, cheater, common.BytesToHash(ad.LockHash))
+		if err != nil {
+			return
+		}
+		if unlocked {
+			ok = true
+			return
+		}
+	}
+	reason = fmt.Sprintf("none of %d locks disposed by %s is unlocked on chain", len(disposed), utils.APex2(cheater))
+	return
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePunishReader struct {
+	fakeSettleBlockReader
+	openBlockNumber uint64
+	unlocked        map[common.Hash]bool
+}
+
+func (f *fakePunishReader) GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error) {
+	return utils.EmptyHash, f.settleBlockNumber, f.openBlockNumber, f.state, 100, nil
+}
+
+func (f *fakePunishReader) QueryUnlockedLocks(participant, partner common.Address, lockHash common.Hash) (bool, error) {
+	return f.unlocked[lockHash], nil
+}
+
+type fakePunishEvidence []*models.ReceivedAnnounceDisposed
+
+func (f fakePunishEvidence) GetChannelAnnounceDisposed(channelIdentifier common.Hash) []*models.ReceivedAnnounceDisposed {
+	return f
+}
+
+func TestCanPunish(t *testing.T) {
+	ctx := context.Background()
+	// punish window is [1100, 1100+257]
+	chain := &syntheticChain{latest: 1200, genesisTime: 1500000000, blockTime: 15}
+	lockHash := utils.NewRandomHash()
+	tn := &fakePunishReader{
+		fakeSettleBlockReader: fakeSettleBlockReader{settleBlockNumber: 1100, state: 2},
+		openBlockNumber:       10,
+		unlocked:              map[common.Hash]bool{lockHash: true},
+	}
+	evidence := fakePunishEvidence{models.NewReceivedAnnounceDisposed(lockHash, utils.EmptyHash, utils.EmptyHash, 10, nil)}
+	beneficiary, cheater := utils.NewRandomAddress(), utils.NewRandomAddress()
+
+	ok, reason, err := CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.True(t, ok, reason)
+	assert.Empty(t, reason)
+	// last block of punish window
+	chain.latest = 1357
+	ok, _, err = CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// window passed
+	chain.latest = 1358
+	ok, reason, err = CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "punish window passed")
+	chain.latest = 1200
+
+	// channel not closed
+	tn.state = 1
+	ok, reason, err = CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "not closed")
+	tn.state = 2
+
+	// no evidence
+	ok, reason, err = CanPunish(ctx, chain, tn, fakePunishEvidence{}, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "no AnnounceDisposed")
+
+	// evidence of a previous channel between us
+	tn.openBlockNumber = 20
+	ok, reason, err = CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "unlocked on chain")
+	tn.openBlockNumber = 10
+
+	// disposed lock is not unlocked by cheater
+	delete(tn.unlocked, lockHash)
+	ok, reason, err = CanPunish(ctx, chain, tn, evidence, beneficiary, cheater)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "unlocked on chain")
+}
@@ -26,7 +26,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-//GetCallContext context for tx
+//GetCallContext context for tx, WaitMined queries the receipt every params.ReceiptPollInterval until its deadline
 func GetCallContext() context.Context {
 	ctx, cf := context.WithDeadline(context.Background(), time.Now().Add(params.DefaultTxTimeout))
 	if cf != nil {
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ErrChannelAlreadyClosed the channel has been closed on chain, usually by partner before us
+var ErrChannelAlreadyClosed = errors.New("channel already closed")
+
+//ChannelStateReader is the part of token network needed by ClassifyContractError, TokenNetworkProxy implements it.
+type ChannelStateReader interface {
+	GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error)
+}
+
+/*
+ClassifyContractError 合约 revert 时只返回一个笼统的错误, 看不出原因.
+根据链上通道的当前状态找出失败的原因, 通道已经关闭时返回 ErrChannelAlreadyClosed,
+找不到原因时原样返回 err.
+*/
+/*
+ *	ClassifyContractError : a reverted contract call only returns a generic error.
+ *	Find out the reason from the current channel state on chain,
+ *	ErrChannelAlreadyClosed is returned when the channel is closed, otherwise err is returned as is.
+ */
+func ClassifyContractError(err error, tokenNetwork ChannelStateReader, participant, partner common.Address) error {
+	if err == nil || err == ErrChannelAlreadyClosed {
+		return err
+	}
+	_, _, _, state, _, err2 := tokenNetwork.GetChannelInfo(participant, partner)
+	if err2 != nil {
+		log.Warn(fmt.Sprintf("ClassifyContractError GetChannelInfo err %s", err2))
+		return err
+	}
+	if state == contracts.ChannelStateClosed {
+		return ErrChannelAlreadyClosed
+	}
+	return err
+}
+
+//ChannelCloser is the part of token network needed by CloseOrUpdate, TokenNetworkProxy implements it.
+type ChannelCloser interface {
+	ChannelStateReader
+	CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error)
+	UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error)
+}
+
+/*
+CloseOrUpdate 用对方的 balance proof 关闭通道.
+如果对方抢先关闭了通道, 我们的 CloseChannel 会失败, 这时正确的做法是用同一个 balance proof 调用 UpdateBalanceProof.
+nonce 为0表示没有对方的 balance proof, 不需要更新.
+*/
+/*
+ *	CloseOrUpdate : close the channel with partner's balance proof.
+ *	If partner closed the channel first, our CloseChannel fails, the right response is
+ *	to submit the same balance proof by UpdateBalanceProof.
+ *	Nonce 0 means there is no balance proof of partner, nothing to update.
+ */
+func CloseOrUpdate(tokenNetwork ChannelCloser, participant, partner common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
+	err = tokenNetwork.CloseChannel(partner, transferAmount, locksRoot, nonce, extraHash, signature)
+	if err == nil {
+		return
+	}
+	err = ClassifyContractError(err, tokenNetwork, participant, partner)
+	if err != ErrChannelAlreadyClosed {
+		return
+	}
+	if nonce == 0 {
+		log.Info(fmt.Sprintf("channel with %s already closed by partner, no balance proof to update", utils.APex2(partner)))
+		return nil
+	}
+	log.Info(fmt.Sprintf("channel with %s already closed by partner, update balance proof instead", utils.APex2(partner)))
+	return tokenNetwork.UpdateBalanceProof(partner, transferAmount, locksRoot, nonce, extraHash, signature)
+}
+
+var _ ChannelCloser = (*TokenNetworkProxy)(nil)
@@ -32,6 +32,7 @@ func assertTxSuccess(t *testing.T, count *int, tx *types.Transaction, err error)
 		r, err := bind.WaitMined(context.Background(), env.Client, tx)
 		assert.Empty(t, err)
 		assert.EqualValues(t, 1, r.Status)
+		markTxBlock(tx, true)
 	}
 }
 
@@ -44,6 +45,7 @@ func assertTxFail(t *testing.T, count *int, tx *types.Transaction, err error) {
 		r, err := bind.WaitMined(context.Background(), env.Client, tx)
 		assert.Empty(t, err)
 		assert.EqualValues(t, 0, r.Status)
+		markTxBlock(tx, false)
 	}
 }
 
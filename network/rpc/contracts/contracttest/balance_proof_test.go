@@ -0,0 +1,39 @@
+package contracttest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestBalanceProofEncoding : 固定输入下balance proof的签名数据不能变,否则合约无法验证
+// TestBalanceProofEncoding : pins the message signed for the contract, it needs no chain.
+func TestBalanceProofEncoding(t *testing.T) {
+	key, err := crypto.HexToECDSA("2ddd679cb0f0754d0e20ef8206ea2210af3b51f159f55cfffbd8550f58daf779")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var channelID contracts.ChannelIdentifier
+	copy(channelID[:], utils.Sha3([]byte("channel")).Bytes())
+	bp := createBalanceProof(key, channelID, 3,
+		common.HexToAddress("0x2aac4fa2ac1c0c3b3ff1d4d7b5f3f7c7d5b7c2a9"), big.NewInt(8888),
+		big.NewInt(10), utils.Sha3([]byte("locksroot")), utils.Sha3([]byte("additional")), 7)
+	expectedHash := common.HexToHash("0x5eb614c6d0edba5d044f9bf6993b38e459b581c603d3179820159854437bb77f")
+	if bp.Hash() != expectedHash {
+		t.Fatalf("balance proof hash changed, expect %s, got %s", expectedHash.String(), bp.Hash().String())
+	}
+	sig := make([]byte, len(bp.Signature))
+	copy(sig, bp.Signature)
+	signer, err := utils.Ecrecover(bp.Hash(), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSigner := common.HexToAddress("0x1a9eC3b0b807464e6D3398a59d6b0a369Bf422fA")
+	if signer != expectedSigner {
+		t.Fatalf("signer expect %s, got %s", expectedSigner.String(), signer.String())
+	}
+}
@@ -0,0 +1,119 @@
+package contracttest
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+/*
+blockTrace 记录测试每个主要步骤(open, close, update, punish, settle 以及等待)时的块号, 测试结束时打印每步用了多少块,
+用来分析在出块时间不同的 CI 环境中和时间相关的失败. 测试不会并行执行, 所以同时只有一个 trace.
+*/
+/*
+ *	blockTrace : block number at every major step of a test, open, close, update, punish, settle and waits,
+ *	a report of blocks taken by every step is printed when the test ends, which helps diagnosing timing-sensitive
+ *	failures on CI chains with different block times. Tests never run in parallel, so there is only one trace at a time.
+ */
+type blockTrace struct {
+	t      *testing.T
+	steps  []string
+	blocks []*big.Int
+}
+
+var trace *blockTrace
+
+//stepNames names of steps for TokensNetwork methods, others are named by their method
+var stepNames = map[string]string{
+	"prepareSettle":              "close",
+	"updateBalanceProof":         "update",
+	"updateBalanceProofDelegate": "update",
+	"punishObsoleteUnlock":       "punish",
+}
+
+var traceABIs []abi.ABI
+
+func init() {
+	for _, s := range []string{contracts.TokensNetworkABI, contracts.TokenABI, contracts.SecretRegistryABI} {
+		parsed, err := abi.JSON(strings.NewReader(s))
+		if err != nil {
+			panic(err)
+		}
+		traceABIs = append(traceABIs, parsed)
+	}
+}
+
+//currentBlock number of the latest block
+func currentBlock(t *testing.T) *big.Int {
+	h, err := env.Client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("get latest block err %s", err)
+	}
+	return h.Number
+}
+
+//traceBlocks start tracing blocks of test t, call report when t ends to log the report
+func traceBlocks(t *testing.T) (report func()) {
+	bt := &blockTrace{t: t}
+	trace = bt
+	markBlock("begin")
+	return func() {
+		//a later trace of the same test replaces this one, and reports by itself
+		if trace == bt {
+			t.Log(bt.report())
+			trace = nil
+		}
+	}
+}
+
+//markBlock record current block as step of the traced test, nothing happens if no test is traced
+func markBlock(step string) {
+	if trace == nil || env == nil {
+		return
+	}
+	trace.steps = append(trace.steps, step)
+	trace.blocks = append(trace.blocks, currentBlock(trace.t))
+}
+
+//markTxBlock record the step which tx is for, named by the contract method it calls
+func markTxBlock(tx *types.Transaction, success bool) {
+	step := "tx"
+	if data := tx.Data(); len(data) >= 4 {
+		for _, a := range traceABIs {
+			if m, err := a.MethodById(data[:4]); err == nil {
+				step = m.Name
+				break
+			}
+		}
+	}
+	if name, ok := stepNames[step]; ok {
+		step = name
+	}
+	if !success {
+		step += " (fail)"
+	}
+	markBlock(step)
+}
+
+//report blocks of every step and blocks taken since the previous step
+func (bt *blockTrace) report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "block trace of %s:\n", bt.t.Name())
+	for i, step := range bt.steps {
+		delta := new(big.Int)
+		if i > 0 {
+			delta.Sub(bt.blocks[i], bt.blocks[i-1])
+		}
+		fmt.Fprintf(&sb, "\t%-24s block=%-10s +%s\n", step, bt.blocks[i], delta)
+	}
+	if len(bt.blocks) > 0 {
+		fmt.Fprintf(&sb, "\ttotal %s blocks", new(big.Int).Sub(bt.blocks[len(bt.blocks)-1], bt.blocks[0]))
+	}
+	return sb.String()
+}
@@ -1,16 +1,22 @@
 package contracttest
 
 import (
+	"context"
+	"errors"
 	"math/big"
 	"testing"
 
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
 // TestChannelCloseRight : 正确调用测试
 // TestChannelCloseRight : Test for correct function call.
 func TestChannelCloseRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
 	cooperativeSettleChannelIfExists(a1, a2)
@@ -44,7 +50,7 @@ func TestChannelCloseRight(t *testing.T) {
 // TestChannelCloseException : 异常调用测试
 // TestChannelCloseException : Test for abnormal function call.
 func TestChannelCloseException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -75,10 +81,45 @@ func TestChannelCloseException(t *testing.T) {
 
 }
 
+// TestCloseChannelByNonParticipant : 第三方拿着合法的 balance proof 也不能关闭别人的通道
+// TestCloseChannelByNonParticipant : a third account can never close a channel of others, even with valid balance proofs.
+func TestCloseChannelByNonParticipant(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
+	charlie := env.getRandomAccountExcept(t, a1, a2)
+	cooperativeSettleChannelIfExists(a1, a2)
+	depositA1 := big.NewInt(10)
+	depositA2 := big.NewInt(20)
+	testSettleTimeout := TestSettleTimeoutMin + 10
+	openChannelAndDeposit(a1, a2, depositA1, depositA2, testSettleTimeout)
+	bpA1 := createPartnerBalanceProof(a2, a1, big.NewInt(1), utils.EmptyHash, utils.EmptyHash, 1)
+	bpA2 := createPartnerBalanceProof(a1, a2, big.NewInt(2), utils.EmptyHash, utils.EmptyHash, 1)
+	// charlie submits balance proof of either participant, naming the other one as partner
+	tx, err := env.TokenNetwork.PrepareSettle(charlie.Auth, env.TokenAddress, a1.Address, bpA1.TransferAmount, bpA1.LocksRoot, bpA1.Nonce, bpA1.AdditionalHash, bpA1.Signature)
+	assertTxFail(t, &count, tx, err)
+	tx, err = env.TokenNetwork.PrepareSettle(charlie.Auth, env.TokenAddress, a2.Address, bpA2.TransferAmount, bpA2.LocksRoot, bpA2.Nonce, bpA2.AdditionalHash, bpA2.Signature)
+	assertTxFail(t, &count, tx, err)
+	// charlie signs the balance proof himself
+	bpCharlie := createPartnerBalanceProof(a1, a2, big.NewInt(2), utils.EmptyHash, utils.EmptyHash, 1)
+	bpCharlie.sign(charlie.Key)
+	tx, err = env.TokenNetwork.PrepareSettle(charlie.Auth, env.TokenAddress, a2.Address, bpCharlie.TransferAmount, bpCharlie.LocksRoot, bpCharlie.Nonce, bpCharlie.AdditionalHash, bpCharlie.Signature)
+	assertTxFail(t, &count, tx, err)
+	// without balance proof
+	tx, err = env.TokenNetwork.PrepareSettle(charlie.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxFail(t, &count, tx, err)
+	// the channel is still open
+	_, _, _, state, _, _ := getChannelInfo(a1, a2)
+	assertEqual(t, &count, ChannelStateOpened, state)
+	cooperativeSettleChannelIfExists(a1, a2)
+	t.Log(endMsg("ChannelClose 第三方关闭通道测试", count, a1, a2, charlie))
+}
+
 // TestChannelCloseEdge : 边界测试
 // TestChannelCloseEdge : Edge Test.
 func TestChannelCloseEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelClose 边界测试", count))
 }
@@ -86,7 +127,7 @@ func TestChannelCloseEdge(t *testing.T) {
 // TestChannelCloseAttack : 恶意调用测试
 // TestChannelCloseAttack : Test for Potential Attack.
 func TestChannelCloseAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -119,3 +160,67 @@ func TestChannelCloseAttack(t *testing.T) {
 
 	t.Log(endMsg("ChannelClose 恶意调用测试", count))
 }
+
+//accountTokenNetwork calls TokensNetwork as account, it implements rpc.ChannelCloser like TokenNetworkProxy
+type accountTokenNetwork struct {
+	account *Account
+}
+
+func (a *accountTokenNetwork) waitTx(tx *types.Transaction, err error) error {
+	if err != nil {
+		return err
+	}
+	r, err := bind.WaitMined(context.Background(), env.Client, tx)
+	if err != nil {
+		return err
+	}
+	if r.Status != types.ReceiptStatusSuccessful {
+		return errors.New("tx execution failed")
+	}
+	return nil
+}
+
+func (a *accountTokenNetwork) GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error) {
+	return env.TokenNetwork.GetChannelInfo(nil, env.TokenAddress, participant1, participant2)
+}
+
+func (a *accountTokenNetwork) CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
+	return a.waitTx(env.TokenNetwork.PrepareSettle(a.account.Auth, env.TokenAddress, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature))
+}
+
+func (a *accountTokenNetwork) UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error) {
+	return a.waitTx(env.TokenNetwork.UpdateBalanceProof(a.account.Auth, env.TokenAddress, partnerAddr, transferAmount, locksRoot, nonce, extraHash, signature))
+}
+
+// TestCloseOrUpdateAfterPartnerClosed : 双方同时关闭通道, 对方的交易先打包, 我们的 close 失败后改为 UpdateBalanceProof
+// TestCloseOrUpdateAfterPartnerClosed : both sides close at the same time and partner's tx lands first,
+// our close fails and updates the balance proof instead.
+func TestCloseOrUpdateAfterPartnerClosed(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	openChannelAndDeposit(a1, a2, big.NewInt(10), big.NewInt(20), TestSettleTimeoutMin)
+	// a2 closes first with a1's balance proof
+	bpA1 := createPartnerBalanceProof(a2, a1, big.NewInt(3), utils.EmptyHash, utils.EmptyHash, 1)
+	tx, err := env.TokenNetwork.PrepareSettle(a2.Auth, env.TokenAddress, a1.Address, bpA1.TransferAmount, bpA1.LocksRoot, bpA1.Nonce, bpA1.AdditionalHash, bpA1.Signature)
+	assertTxSuccess(t, &count, tx, err)
+	// a1's close reverts, it's classified as already closed
+	bpA2 := createPartnerBalanceProof(a1, a2, big.NewInt(5), utils.EmptyHash, utils.EmptyHash, 2)
+	tn := &accountTokenNetwork{a1}
+	err = tn.CloseChannel(a2.Address, bpA2.TransferAmount, bpA2.LocksRoot, bpA2.Nonce, bpA2.AdditionalHash, bpA2.Signature)
+	assertFail(t, &count, err)
+	assertEqual(t, &count, rpc.ErrChannelAlreadyClosed, rpc.ClassifyContractError(err, tn, a1.Address, a2.Address))
+	// CloseOrUpdate switches to UpdateBalanceProof, MUST SUCCESS
+	err = rpc.CloseOrUpdate(tn, a1.Address, a2.Address, bpA2.TransferAmount, bpA2.LocksRoot, bpA2.Nonce, bpA2.AdditionalHash, bpA2.Signature)
+	assertSuccess(t, &count, err)
+	_, balanceHashA2, nonceA2, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a2.Address, a1.Address)
+	assertSuccess(t, nil, err)
+	localBalanceHash := bpA2.BalanceData.Hash()
+	assertEqual(t, &count, localBalanceHash[:24], balanceHashA2[:])
+	assertEqual(t, &count, bpA2.Nonce, nonceA2)
+	// settle by both balance proofs
+	waitToSettle(a1, a2)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, bpA1.TransferAmount, bpA1.LocksRoot, a2.Address, bpA2.TransferAmount, bpA2.LocksRoot)
+	assertTxSuccess(t, &count, tx, err)
+	t.Log(endMsg("ChannelClose 对方先关闭通道测试", count, a1, a2))
+}
@@ -12,7 +12,7 @@ import (
 // TestCooperativeSettleRight : 正确调用测试
 // TestCooperativeSettleRight : normal function call
 func TestCooperativeSettleRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -66,7 +66,7 @@ func TestCooperativeSettleRight(t *testing.T) {
 
 // TestCooperativeSettleException : 异常调用测试
 func TestCooperativeSettleException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -108,7 +108,7 @@ func TestCooperativeSettleException(t *testing.T) {
 // TestCooperativeSettleEdge : 边界测试
 // TestCooperativeSettleEdge : Edge Test
 func TestCooperativeSettleEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -157,7 +157,7 @@ func TestCooperativeSettleEdge(t *testing.T) {
 // TestCooperativeSettleAttack : 恶意调用测试
 // TestCooperativeSettleAttack : Abnormal function call
 func TestCooperativeSettleAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -218,3 +218,45 @@ func TestCooperativeSettleAttack(t *testing.T) {
 
 	t.Log(endMsg("CooperativeSettle 恶意调用测试", count, a1, a2, a3))
 }
+
+// TestCooperativeSettleWithAsymmetricBalances : 自己给对方转了15以后合作关闭通道, 双方拿回的 token 按转账后的余额计算
+// TestCooperativeSettleWithAsymmetricBalances : cooperative settle after self transferred 15 to partner,
+// each side gets back its balance after the transfers.
+func TestCooperativeSettleWithAsymmetricBalances(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare
+	self, partner := env.Accounts[0], env.Accounts[1]
+	depositSelf := big.NewInt(25)
+	depositPartner := big.NewInt(20)
+	transferSelf := big.NewInt(15)
+	transferPartner := big.NewInt(0)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, TestSettleTimeoutMin)
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	// depositSelf - transferSelf + transferPartner = 10
+	balanceSelf := new(big.Int).Sub(depositSelf, transferSelf)
+	balanceSelf.Add(balanceSelf, transferPartner)
+	// depositPartner - transferPartner + transferSelf = 35
+	balancePartner := new(big.Int).Sub(depositPartner, transferPartner)
+	balancePartner.Add(balancePartner, transferSelf)
+	assertEqual(t, &count, big.NewInt(10), balanceSelf)
+	assertEqual(t, &count, big.NewInt(35), balancePartner)
+	// balances not adding up to the total deposit, MUST FAIL
+	cs := getCooperativeSettleParams(self, partner, nil, nil)
+	cs.Participant1Balance = new(big.Int).Add(balanceSelf, big.NewInt(1))
+	cs.Participant2Balance = balancePartner
+	tx, err := env.TokenNetwork.CooperativeSettle(
+		self.Auth, env.TokenAddress, self.Address, cs.Participant1Balance, partner.Address, cs.Participant2Balance, cs.sign(self.Key), cs.sign(partner.Key))
+	assertTxFail(t, &count, tx, err)
+	// MUST SUCCESS
+	cs.Participant1Balance = balanceSelf
+	cs.Participant2Balance = balancePartner
+	tx, err = env.TokenNetwork.CooperativeSettle(
+		self.Auth, env.TokenAddress, self.Address, cs.Participant1Balance, partner.Address, cs.Participant2Balance, cs.sign(self.Key), cs.sign(partner.Key))
+	assertTxSuccess(t, &count, tx, err)
+	_, _, _, state, _, _ := getChannelInfo(self, partner)
+	assertEqual(t, &count, ChannelStateSettledOrNotExist, state)
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceSelf, balanceSelf), getTokenBalance(self))
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalancePartner, balancePartner), getTokenBalance(partner))
+	t.Log(endMsg("CooperativeSettle 双方余额不对称测试", count, self, partner))
+}
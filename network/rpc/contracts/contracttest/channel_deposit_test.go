@@ -10,7 +10,7 @@ import (
 // TestChannelDepositRight : 正确调用测试
 // TestChannelDepositRight : normal function call
 func TestChannelDepositRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
 	a3 := env.getRandomAccountExcept(t, a1, a2)
@@ -49,10 +49,70 @@ func TestChannelDepositRight(t *testing.T) {
 	t.Log(endMsg("ChannelDeposit 正确调用测试", count, a1, a2, a3))
 }
 
+// TestChannelBalanceAfterMultipleDeposits : 多次存款以后余额应该是累加的,而不是最后一次存款
+// TestChannelBalanceAfterMultipleDeposits : balance must be the sum of all deposits, not the last one.
+func TestChannelBalanceAfterMultipleDeposits(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
+	cooperativeSettleChannelIfExists(a1, a2)
+	testSettleTimeout := TestSettleTimeoutMin + 10
+	deposits := []*big.Int{big.NewInt(100), big.NewInt(200), big.NewInt(300)}
+	total := big.NewInt(0)
+	for _, deposit := range deposits {
+		tx, err := env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, deposit, testSettleTimeout)
+		assertTxSuccess(t, &count, tx, err)
+		total.Add(total, deposit)
+		balanceA1, _, _, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a1.Address, a2.Address)
+		assertSuccess(t, nil, err)
+		assertEqual(t, nil, 0, total.Cmp(balanceA1))
+	}
+	// partner's balance is not affected
+	balanceA2, _, _, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a2.Address, a1.Address)
+	assertSuccess(t, nil, err)
+	assertEqual(t, nil, 0, big.NewInt(0).Cmp(balanceA2))
+	t.Log(endMsg("ChannelDeposit 多次存款测试", count, a1, a2))
+}
+
+// TestDepositLimitPerParticipant : 合约没有单个参与者的存款上限, 实际上限是参与者授权给 TokensNetwork 的 token 数量,
+// 存到刚好等于上限和差一个都应成功, 超过一个必须失败
+// TestDepositLimitPerParticipant : TokensNetwork has no per-participant deposit cap, the effective limit is the
+// allowance the participant approved to TokensNetwork. Deposits one below and exactly at the limit succeed, one above reverts.
+func TestDepositLimitPerParticipant(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	testSettleTimeout := TestSettleTimeoutMin + 10
+	limit, err := env.Token.Allowance(nil, a1.Address, env.TokenNetworkAddress)
+	assertSuccess(t, &count, err)
+	// 授权不能超过余额, 否则上限是余额
+	// allowance must not exceed the balance, otherwise the balance is the limit
+	assertEqual(t, &count, true, limit.Cmp(getTokenBalance(a1)) <= 0)
+	// 上限减一, 成功
+	// one token below the limit, MUST SUCCESS
+	tx, err := env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, new(big.Int).Sub(limit, big.NewInt(1)), testSettleTimeout)
+	assertTxSuccess(t, &count, tx, err)
+	// 再存一个, 刚好到上限, 成功
+	// one more token, exactly at the limit, MUST SUCCESS
+	tx, err = env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, big.NewInt(1), testSettleTimeout)
+	assertTxSuccess(t, &count, tx, err)
+	balanceA1, _, _, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a1.Address, a2.Address)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, 0, limit.Cmp(balanceA1))
+	// 超过上限一个, 失败
+	// one token above the limit, MUST FAIL
+	tx, err = env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, big.NewInt(1), testSettleTimeout)
+	assertTxFail(t, &count, tx, err)
+	balanceA1, _, _, err = env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a1.Address, a2.Address)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, 0, limit.Cmp(balanceA1))
+	t.Log(endMsg("ChannelDeposit 存款上限测试", count, a1, a2))
+}
+
 // TestChannelDepositException : 异常调用测试
 // TestChannelDepositException : abnormal function call
 func TestChannelDepositException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelDeposit 异常调用测试", count))
 
@@ -61,7 +121,7 @@ func TestChannelDepositException(t *testing.T) {
 // TestChannelDepositEdge : 边界测试
 // TestChannelDepositEdge : edge test
 func TestChannelDepositEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
 	cooperativeSettleChannelIfExists(a1, a2)
@@ -106,7 +166,7 @@ func TestChannelDepositEdge(t *testing.T) {
 // TestChannelDepositAttack : 恶意调用测试
 // TestChannelDepositAttack : test for potential attack.
 func TestChannelDepositAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelDeposit 恶意调用测试", count))
 }
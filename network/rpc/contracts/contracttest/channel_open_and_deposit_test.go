@@ -1,18 +1,23 @@
 package contracttest
 
 import (
+	"context"
 	"math/big"
 	"testing"
 
 	"encoding/hex"
 
+	"github.com/SmartMeshFoundation/Photon/network/rpc"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/test/tokens/tokenerc223approve"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // TestChannelOpenAndDepositRight : 正确调用测试
 // TestChannelOpenAndDepositRight : normal function call
 func TestChannelOpenAndDepositRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	settleTimeout := TestSettleTimeoutMin + 10
@@ -55,7 +60,7 @@ func TestChannelOpenAndDepositRight(t *testing.T) {
 // TestChannelOpenAndDepositException : 异常调用测试
 // TestChannelOpenAndDepositException : abnormal function call
 func TestChannelOpenAndDepositException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	settleTimeout := TestSettleTimeoutMin + 10
@@ -83,7 +88,7 @@ func TestChannelOpenAndDepositException(t *testing.T) {
 // TestChannelOpenAndDepositEdge : 边界测试
 // TestChannelOpenAndDepositEdge : edge test
 func TestChannelOpenAndDepositEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	settleTimeout := TestSettleTimeoutMin + 10
@@ -117,10 +122,123 @@ func TestChannelOpenAndDepositEdge(t *testing.T) {
 	t.Log(endMsg("ChannelOpenAndDeposit 边界测试", count))
 }
 
+// TestOpenSameChannelTwice : 通道打开以后再次打开同一个通道(包括对方打开),不会产生新通道,只是存款
+// TestOpenSameChannelTwice : opening an opened channel again, from either side, never creates a new channel, it's only a deposit.
+func TestOpenSameChannelTwice(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	settleTimeout := TestSettleTimeoutMin + 10
+	depositAmountA1 := big.NewInt(20)
+	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
+	cooperativeSettleChannelIfExists(a1, a2)
+	tx, err := env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, depositAmountA1, settleTimeout)
+	assertTxSuccess(t, &count, tx, err)
+	channelID, _, openBlockNumber, state, _, _ := getChannelInfo(a1, a2)
+	assertEqual(t, &count, ChannelStateOpened, state)
+
+	// open again with another settle timeout, channel keeps its identity and settle timeout
+	tx, err = env.TokenNetwork.Deposit(a1.Auth, env.TokenAddress, a1.Address, a2.Address, depositAmountA1, settleTimeout+1)
+	assertTxSuccess(t, &count, tx, err)
+	// partner opens the same channel
+	tx, err = env.TokenNetwork.Deposit(a2.Auth, env.TokenAddress, a2.Address, a1.Address, depositAmountA1, settleTimeout+2)
+	assertTxSuccess(t, &count, tx, err)
+	channelID2, settleBlockNumber, openBlockNumber2, state, settleTimeout2, _ := getChannelInfo(a2, a1)
+	assertEqual(t, &count, channelID, channelID2)
+	assertEqual(t, &count, openBlockNumber, openBlockNumber2)
+	assertEqual(t, &count, settleTimeout, settleTimeout2)
+	assertEqual(t, &count, ChannelStateOpened, state)
+	assertEqual(t, nil, uint64(0), settleBlockNumber)
+
+	// deposits accumulate in the one channel
+	deposit, _, _, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a1.Address, a2.Address)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, new(big.Int).Mul(depositAmountA1, big.NewInt(2)), deposit)
+	deposit, _, _, err = env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, a2.Address, a1.Address)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, depositAmountA1, deposit)
+	t.Log(endMsg("ChannelOpenAndDeposit 重复打开通道测试", count, a1, a2))
+}
+
 // TestChannelOpenAndDepositAttack : 恶意调用测试
 // TestChannelOpenAndDepositAttack : test for potential attack
 func TestChannelOpenAndDepositAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelOpenAndDeposit 恶意调用测试", count))
 }
+
+// TestOpenChannelWithDeposit : rpc.ChannelOpener 在模拟链上创建通道并存款, 模拟链的 token 不支持 approveAndCall, 使用 approve 和 deposit 两个交易,
+// 另外部署一个支持 approveAndCall 的 token, 一个交易完成
+// TestOpenChannelWithDeposit : rpc.ChannelOpener opens channels with deposit on the simulated chain, token of the simulated env
+// has no approveAndCall, so approve and deposit are two txs, another token supporting approveAndCall needs only one.
+func TestOpenChannelWithDeposit(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	settleTimeout := TestSettleTimeoutMin + 10
+	amount := big.NewInt(20)
+	checkChannel := func(token common.Address, expectDeposit *big.Int) {
+		_, _, _, state, timeout, err := env.TokenNetwork.GetChannelInfo(nil, token, a1.Address, a2.Address)
+		assertSuccess(t, nil, err)
+		assertEqual(t, &count, ChannelStateOpened, state)
+		assertEqual(t, &count, settleTimeout, timeout)
+		deposit, _, _, err := env.TokenNetwork.GetChannelParticipantInfo(nil, token, a1.Address, a2.Address)
+		assertSuccess(t, nil, err)
+		assertEqual(t, &count, expectDeposit, deposit)
+	}
+	// approve and deposit
+	opener := &rpc.ChannelOpener{
+		Client:              env.Client,
+		Token:               env.Token,
+		TokenAddress:        env.TokenAddress,
+		TokenNetwork:        env.TokenNetwork,
+		TokenNetworkAddress: env.TokenNetworkAddress,
+	}
+	preTokenBalance, preNonce := getTokenBalance(a1), getNonce(t, a1)
+	singleTx, err := opener.OpenChannelWithDeposit(a1.Auth, a2.Address, settleTimeout, amount)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, false, singleTx)
+	assertEqual(t, &count, preNonce+2, getNonce(t, a1))
+	assertEqual(t, &count, new(big.Int).Sub(preTokenBalance, amount), getTokenBalance(a1))
+	checkChannel(env.TokenAddress, amount)
+	// deposit again into the opened channel
+	_, err = opener.OpenChannelWithDeposit(a1.Auth, a2.Address, settleTimeout, amount)
+	assertSuccess(t, &count, err)
+	checkChannel(env.TokenAddress, new(big.Int).Mul(amount, big.NewInt(2)))
+	// not enough token, approve succeeds but deposit fails
+	_, err = opener.OpenChannelWithDeposit(a1.Auth, a2.Address, settleTimeout, new(big.Int).Add(getTokenBalance(a1), big.NewInt(1)))
+	assertFail(t, &count, err)
+	checkChannel(env.TokenAddress, new(big.Int).Mul(amount, big.NewInt(2)))
+
+	// approveAndCall
+	tokenAddress, _, _, err := tokenerc223approve.DeployHumanERC223Token(a1.Auth, env.Client, big.NewInt(50000000), "APV", 0)
+	assertSuccess(t, nil, err)
+	token, err := contracts.NewToken(tokenAddress, env.Client)
+	assertSuccess(t, nil, err)
+	opener = &rpc.ChannelOpener{
+		Client:              env.Client,
+		Token:               token,
+		TokenAddress:        tokenAddress,
+		TokenNetwork:        env.TokenNetwork,
+		TokenNetworkAddress: env.TokenNetworkAddress,
+	}
+	preNonce = getNonce(t, a1)
+	singleTx, err = opener.OpenChannelWithDeposit(a1.Auth, a2.Address, settleTimeout, amount)
+	assertSuccess(t, &count, err)
+	assertEqual(t, &count, true, singleTx)
+	assertEqual(t, &count, preNonce+1, getNonce(t, a1))
+	balance, err := token.BalanceOf(nil, a1.Address)
+	assertSuccess(t, nil, err)
+	assertEqual(t, &count, big.NewInt(50000000-20), balance)
+	checkChannel(tokenAddress, amount)
+	t.Log(endMsg("ChannelOpenAndDeposit ChannelOpener 测试", count, a1, a2))
+}
+
+func getNonce(t *testing.T, account *Account) uint64 {
+	nonce, err := env.Client.PendingNonceAt(context.Background(), account.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nonce
+}
@@ -12,12 +12,13 @@ import (
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
 // TestChannelPunishRight : 正确调用测试
 // TestChannelPunishRight : normal function call
 func TestChannelPunishRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	self, partner := env.getTwoAccountWithoutChannelClose(t)
@@ -78,12 +79,258 @@ func TestChannelPunishRight(t *testing.T) {
 	assertEqual(t, &count, preTokenBalancePartner.Sub(preTokenBalancePartner, depositPartner), tokenBalancePartner)
 	assertEqual(t, &count, preTokenBalanceContract, tokenBalanceContract)
 
+	// 被惩罚的锁在punish时已经过期
+	// punish a lock which has already expired at punish time
+	runPunishWithExpiredLock(self, partner, t, &count)
+
+	// unlock 时 MerkleProof 包含多余或者重叠的节点
+	// merkle proof of unlock contains redundant or overlapping nodes
+	runUnlockWithOverlappingMerkleProof(self, partner, t, &count)
+
 	t.Log(endMsg("ChannelPunish 正确调用测试", count, self, partner))
 }
 
+/*
+TestChannelPunishRightWithMultipleValidLocks : 对方的 balance proof 包含10个锁, 对方在链上 unlock 了全部10个锁, 我们用每个锁分别去 punish.
+合约的 punishObsoleteUnlock 在第一次惩罚成功时就把对方的押金全部转给我们, 并且把我们的 balance_hash 清零, nonce 设为最大值,
+所以只有第一次 punish 成功, 之后用其他锁的 punish 都必须失败, 不能重复惩罚. settle 以后我们得到对方的全部押金.
+*/
+/*
+ *	TestChannelPunishRightWithMultipleValidLocks : partner's balance proof has 10 locks, partner unlocks all of them on chain,
+ *	and self punishes with each lock hash.
+ *	punishObsoleteUnlock moves all deposit of the cheater to the beneficiary on the first success, and resets balance_hash
+ *	of the beneficiary to 0 and its nonce to max, so only the first punish succeeds, punishes with other locks MUST FAIL,
+ *	the cheater cannot be punished twice. After settle self gets all deposit of partner.
+ */
+func TestChannelPunishRightWithMultipleValidLocks(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare
+	self, partner := env.Accounts[0], env.Accounts[1]
+	depositSelf := big.NewInt(25)
+	depositPartner := big.NewInt(20)
+	testSettleTimeout := TestSettleTimeoutMin + 30
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	var selfLockAmounts []*big.Int
+	for i := 0; i < 10; i++ {
+		selfLockAmounts = append(selfLockAmounts, big.NewInt(1))
+	}
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, testSettleTimeout)
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	preTokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	locksSelf, secretsSelf := createLockByArray(expireBlockNumber, selfLockAmounts)
+	// every lock is punished, so register all secrets, registrySecrets registers only the first few
+	for _, secret := range secretsSelf {
+		tx, err := env.SecretRegistry.RegisterSecret(self.Auth, secret)
+		assertTxSuccess(t, nil, tx, err)
+	}
+	mpSelf := mtree.NewMerkleTree(locksSelf)
+
+	// self close channel
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(1), utils.EmptyHash, utils.EmptyHash, 1)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	// partner update proof with all 10 locks
+	bpSelf := createPartnerBalanceProof(partner, self, big.NewInt(3), mpSelf.MerkleRoot(), utils.EmptyHash, 2)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, bpSelf.LocksRoot, bpSelf.Nonce, bpSelf.AdditionalHash, bpSelf.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	// partner unlock all 10 locks, transfer amount grows after each unlock
+	var lockHashes []common.Hash
+	for _, lock := range locksSelf {
+		lockHashes = append(lockHashes, lock.Hash())
+	}
+	proofs := mpSelf.MakeProofs(lockHashes)
+	transferAmount := new(big.Int).Set(bpSelf.TransferAmount)
+	for _, lock := range locksSelf {
+		tx, err = env.TokenNetwork.Unlock(partner.Auth, env.TokenAddress, self.Address, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proofs[lock.Hash()]))
+		assertTxSuccess(t, &count, tx, err)
+		transferAmount.Add(transferAmount, lock.Amount)
+	}
+
+	// self punish partner with each lock, only the first one succeeds
+	for i, lock := range locksSelf {
+		ou := &ObseleteUnlockForContract{
+			ChannelIdentifier:  bpSelf.ChannelIdentifier,
+			OpenBlockNumber:    bpSelf.OpenBlockNumber,
+			ChainID:            bpSelf.ChainID,
+			BeneficiaryAddress: self.Address,
+			LockHash:           lock.Hash(),
+			AdditionalHash:     utils.EmptyHash,
+			MerkleProof:        mtree.Proof2Bytes(proofs[lock.Hash()]),
+		}
+		tx, err = env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+		if i == 0 {
+			assertTxSuccess(t, &count, tx, err)
+		} else {
+			assertTxFail(t, &count, tx, err)
+		}
+	}
+	// partner's deposit has been moved to self
+	depositSelfAfter, balanceHashSelf, nonceSelf, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, self.Address, partner.Address)
+	assertSuccess(t, nil, err)
+	assertEqual(t, &count, new(big.Int).Add(depositSelf, depositPartner), depositSelfAfter)
+	assertEqual(t, &count, utils.EmptyHash[:24], balanceHashSelf[:])
+	assertEqual(t, &count, uint64(0xffffffffffffffff), nonceSelf)
+
+	// settle after the punish window, self gets all token and partner gets 0
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, &count, tx, err)
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceSelf, new(big.Int).Add(depositSelf, depositPartner)), getTokenBalance(self))
+	assertEqual(t, &count, preTokenBalancePartner, getTokenBalance(partner))
+	assertEqual(t, &count, new(big.Int).Sub(preTokenBalanceContract, new(big.Int).Add(depositSelf, depositPartner)), getTokenBalanceByAddess(env.TokenNetworkAddress))
+	t.Log(endMsg("ChannelPunish 多个锁正确调用测试", count, self, partner))
+}
+
+/*
+runUnlockWithOverlappingMerkleProof :
+computeMerkleRoot 要求 merkle_proof 的长度是 32 的整数倍, 否则必须拒绝, 而不是按截断后的节点去计算.
+长度正确但是包含多余节点时, 计算出的 locksroot 不一致, 同样必须拒绝.
+只有正确的 proof 才能 unlock, 之后的 punish 照常成功.
+*/
+/*
+ *	runUnlockWithOverlappingMerkleProof : computeMerkleRoot requires the length of merkle_proof to be a multiple of 32,
+ *	a proof with a partial or overlapping node MUST be rejected instead of being verified with truncated nodes.
+ *	A proof of right length with a redundant node gives a different locksroot and MUST be rejected too.
+ *	Only the right proof can unlock, and punish after that succeeds as usual.
+ */
+func runUnlockWithOverlappingMerkleProof(self, partner *Account, t *testing.T, count *int) {
+	depositSelf := big.NewInt(25)
+	depositPartner := big.NewInt(20)
+	testSettleTimeout := TestSettleTimeoutMin + 30
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	// two locks, so the proof is not empty
+	selfLockAmounts := []*big.Int{big.NewInt(1), big.NewInt(2)}
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	cooperativeSettleChannelIfExists(self, partner)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, testSettleTimeout)
+	locksSelf, secretsSelf := createLockByArray(expireBlockNumber, selfLockAmounts)
+	registrySecrets(self, secretsSelf)
+	mpSelf := mtree.NewMerkleTree(locksSelf)
+	lock := locksSelf[0]
+	proof := mtree.Proof2Bytes(mpSelf.MakeProof(lock.Hash()))
+	assertEqual(t, nil, 32, len(proof))
+
+	// self close channel
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(0), utils.EmptyHash, utils.EmptyHash, 0)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, nil, tx, err)
+	// partner update proof with locks
+	bpSelf := createPartnerBalanceProof(partner, self, big.NewInt(3), mpSelf.MerkleRoot(), utils.EmptyHash, 2)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, bpSelf.LocksRoot, bpSelf.Nonce, bpSelf.AdditionalHash, bpSelf.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	unlock := func(merkleProof []byte) (*types.Transaction, error) {
+		return env.TokenNetwork.Unlock(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, merkleProof)
+	}
+	// half of a node appended, MUST FAIL
+	tx, err = unlock(append(append([]byte{}, proof...), proof[:16]...))
+	assertTxFail(t, count, tx, err)
+	// half of the node overlaps the node itself, MUST FAIL
+	tx, err = unlock(append(append([]byte{}, proof[16:]...), proof...))
+	assertTxFail(t, count, tx, err)
+	// the node repeated, length is right but locksroot is wrong, MUST FAIL
+	tx, err = unlock(append(append([]byte{}, proof...), proof...))
+	assertTxFail(t, count, tx, err)
+	// the right proof
+	tx, err = unlock(proof)
+	assertTxSuccess(t, count, tx, err)
+
+	// self punish partner
+	ou := &ObseleteUnlockForContract{
+		ChannelIdentifier:  bpSelf.ChannelIdentifier,
+		OpenBlockNumber:    bpSelf.OpenBlockNumber,
+		ChainID:            bpSelf.ChainID,
+		BeneficiaryAddress: self.Address,
+		LockHash:           lock.Hash(),
+		AdditionalHash:     utils.EmptyHash,
+		MerkleProof:        proof,
+	}
+	tx, err = env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxSuccess(t, count, tx, err)
+
+	// settle, self gets all deposit of partner
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+	assertEqual(t, count, preTokenBalanceSelf.Add(preTokenBalanceSelf, depositPartner), getTokenBalance(self))
+	assertEqual(t, count, preTokenBalancePartner.Sub(preTokenBalancePartner, depositPartner), getTokenBalance(partner))
+}
+
+/*
+runPunishWithExpiredLock :
+合约 punishObsoleteUnlock 只检查:通道处于关闭状态,受益人 balance_hash 不为空,cheater 对 lockhash 的放弃签名,
+以及该锁在当前 nonce 下已经被 unlock. 它并不检查锁的 expiration.
+所以即使锁在 punish 时已经过期, punish 依然应该成功, 只要 cheater 曾经用这个锁 unlock 过.
+*/
+/*
+ *	runPunishWithExpiredLock : the lock being punished has already expired when punish is submitted.
+ *
+ *	Per TokensNetwork.sol punishObsoleteUnlock only requires: channel closed, beneficiary balance_hash != 0,
+ *	a valid disposal signature of lockhash from cheater, and the lock unlocked under the current nonce.
+ *	Lock expiration is never checked there, the expiration matters only for unlock (reveal_block <= expiration).
+ *	So punishing an expired lock MUST still succeed.
+ */
+func runPunishWithExpiredLock(self, partner *Account, t *testing.T, count *int) {
+	depositSelf := big.NewInt(25)
+	depositPartner := big.NewInt(20)
+	testSettleTimeout := TestSettleTimeoutMin + 30
+	// expire soon, but late enough to register secret before expiration
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 10
+	selfLockAmounts := []*big.Int{big.NewInt(1)}
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	// open channel
+	cooperativeSettleChannelIfExists(self, partner)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, testSettleTimeout)
+	// register secret before lock expires
+	locksSelf, secretsSelf := createLockByArray(expireBlockNumber, selfLockAmounts)
+	registrySecrets(self, secretsSelf)
+	mpSelf := mtree.NewMerkleTree(locksSelf)
+	lock := locksSelf[0]
+	proof := mpSelf.MakeProof(lock.Hash())
+
+	// self close channel
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(0), utils.EmptyHash, utils.EmptyHash, 0)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	// partner update proof with locks and unlock
+	bpSelf := createPartnerBalanceProof(partner, self, big.NewInt(3), mpSelf.MerkleRoot(), utils.EmptyHash, 2)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, bpSelf.LocksRoot, bpSelf.Nonce, bpSelf.AdditionalHash, bpSelf.Signature)
+	assertTxSuccess(t, nil, tx, err)
+	tx, err = env.TokenNetwork.Unlock(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
+	assertTxSuccess(t, nil, tx, err)
+
+	// wait until the lock expired
+	waitUntilBlockNo(uint64(expireBlockNumber + 1))
+	assertEqual(t, count, true, getLatestBlockNumber().Number.Int64() > lock.Expiration)
+
+	// self punish partner with the expired lock, MUST SUCCESS
+	ou := &ObseleteUnlockForContract{
+		ChannelIdentifier:  bpSelf.ChannelIdentifier,
+		OpenBlockNumber:    bpSelf.OpenBlockNumber,
+		ChainID:            bpSelf.ChainID,
+		BeneficiaryAddress: self.Address,
+		LockHash:           lock.Hash(),
+		AdditionalHash:     utils.EmptyHash,
+		MerkleProof:        mtree.Proof2Bytes(proof),
+	}
+	tx, err = env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxSuccess(t, count, tx, err)
+
+	// settle, self gets all deposit of partner
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+	assertEqual(t, count, preTokenBalanceSelf.Add(preTokenBalanceSelf, depositPartner), getTokenBalance(self))
+	assertEqual(t, count, preTokenBalancePartner.Sub(preTokenBalancePartner, depositPartner), getTokenBalance(partner))
+}
+
 // TestChannelPunishException : 异常调用测试
 func TestChannelPunishException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	self, partner := env.getTwoAccountWithoutChannelClose(t)
 	// open channel
@@ -151,7 +398,7 @@ func TestChannelPunishException(t *testing.T) {
 
 // TestChannelPunishEdge : 边界测试
 func TestChannelPunishEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	self, partner := env.getTwoAccountWithoutChannelClose(t)
 	// open channel
@@ -248,7 +495,7 @@ func TestChannelPunishEdge(t *testing.T) {
 
 // TestChannelPunishAttack : 恶意调用测试
 func TestChannelPunishAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	self, partner := env.getTwoAccountWithoutChannelClose(t)
@@ -338,6 +585,110 @@ func TestChannelPunishAttack(t *testing.T) {
 	t.Log(endMsg("ChannelPunish 恶意调用测试", count))
 }
 
+// TestChannelPunishWithMismatchedTokenNetwork : 证据中的 TokenNetworkAddress 与实际合约不一致, 合约必须拒绝, 防止跨合约重放
+// TestChannelPunishWithMismatchedTokenNetwork : proof bound to another token network must be rejected, prevents cross-contract replay
+func TestChannelPunishWithMismatchedTokenNetwork(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	self, partner := env.getTwoAccountWithoutChannelClose(t)
+	ou, bpPartner := preparePunishableUnlock(t, self, partner)
+
+	// self punish partner with proof signed for another token network, MUST FAIL
+	channelIdentifier := ou.ChannelIdentifier
+	ou.TokenNetworkAddress = utils.NewRandomAddress()
+	ou.ChannelIdentifier = contracts.ChannelIdentifier(contracts.CalcChannelID(env.TokenAddress, ou.TokenNetworkAddress, self.Address, partner.Address))
+	tx, err := env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxFail(t, &count, tx, err)
+
+	// the same proof bound to this token network is accepted
+	ou.TokenNetworkAddress = env.TokenNetworkAddress
+	ou.ChannelIdentifier = channelIdentifier
+	tx, err = env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxSuccess(t, &count, tx, err)
+
+	// settled for cases after this
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+
+	t.Log(endMsg("ChannelPunish 不匹配的TokenNetwork测试", count))
+}
+
+// TestChannelPunishWithWrongChainID : 证据中的 ChainID 是其他链的, 合约必须拒绝, 防止跨链重放
+// TestChannelPunishWithWrongChainID : proof signed for another chain must be rejected, prevents cross-chain replay
+func TestChannelPunishWithWrongChainID(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	self, partner := env.getTwoAccountWithoutChannelClose(t)
+	ou, bpPartner := preparePunishableUnlock(t, self, partner)
+
+	// self punish partner with proof signed for ethereum mainnet, MUST FAIL
+	chainID := ou.ChainID
+	ou.ChainID = big.NewInt(1)
+	if chainID.Cmp(ou.ChainID) == 0 {
+		ou.ChainID = big.NewInt(2)
+	}
+	tx, err := env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxFail(t, &count, tx, err)
+
+	// the same proof signed for this chain is accepted
+	ou.ChainID = chainID
+	tx, err = env.TokenNetwork.PunishObsoleteUnlock(self.Auth, env.TokenAddress, self.Address, partner.Address, ou.LockHash, ou.AdditionalHash, ou.sign(partner.Key))
+	assertTxSuccess(t, &count, tx, err)
+
+	// settled for cases after this
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+
+	t.Log(endMsg("ChannelPunish 错误的ChainID测试", count))
+}
+
+// preparePunishableUnlock : self 关闭通道, partner unlock 了 self 的一个锁, 返回可以用来 punish partner 的证据
+// preparePunishableUnlock : self closes channel and partner unlocks a lock of self, returns proof to punish partner
+func preparePunishableUnlock(t *testing.T, self, partner *Account) (ou *ObseleteUnlockForContract, bpPartner *BalanceProofForContract) {
+	depositSelf := big.NewInt(25)
+	depositPartner := big.NewInt(20)
+	testSettleTimeout := TestSettleTimeoutMin + 30
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	selfLockAmounts := []*big.Int{big.NewInt(1)}
+	// open channel
+	cooperativeSettleChannelIfExists(self, partner)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, testSettleTimeout)
+
+	// self close channel
+	bpPartner = createPartnerBalanceProof(self, partner, big.NewInt(1), utils.EmptyHash, utils.EmptyHash, 1)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	// partner update proof with locks
+	locksSelf, secretsSelf := createLockByArray(expireBlockNumber, selfLockAmounts)
+	registrySecrets(self, secretsSelf)
+	mpSelf := mtree.NewMerkleTree(locksSelf)
+	bpSelf := createPartnerBalanceProof(partner, self, big.NewInt(3), mpSelf.MerkleRoot(), utils.EmptyHash, 2)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, bpSelf.LocksRoot, bpSelf.Nonce, bpSelf.AdditionalHash, bpSelf.Signature)
+	assertTxSuccess(t, nil, tx, err)
+
+	// partner unlock
+	lock := locksSelf[0]
+	proof := mpSelf.MakeProof(lock.Hash())
+	tx, err = env.TokenNetwork.Unlock(partner.Auth, env.TokenAddress, self.Address, bpSelf.TransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
+	assertTxSuccess(t, nil, tx, err)
+
+	ou = &ObseleteUnlockForContract{
+		ChannelIdentifier:   bpSelf.ChannelIdentifier,
+		OpenBlockNumber:     bpSelf.OpenBlockNumber,
+		ChainID:             bpSelf.ChainID,
+		BeneficiaryAddress:  self.Address,
+		LockHash:            lock.Hash(),
+		AdditionalHash:      utils.EmptyHash,
+		TokenNetworkAddress: env.TokenNetworkAddress,
+	}
+	return
+}
+
 // ObseleteUnlockForContract :
 type ObseleteUnlockForContract struct {
 	ChannelIdentifier            contracts.ChannelIdentifier
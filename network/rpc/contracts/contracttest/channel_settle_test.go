@@ -10,7 +10,7 @@ import (
 
 // TestChannelSettleRight : 正确调用测试
 func TestChannelSettleRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -24,22 +24,159 @@ func TestChannelSettleRight(t *testing.T) {
 
 // TestChannelSettleException : 异常调用测试
 func TestChannelSettleException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelSettle 异常调用测试", count))
 
 }
 
+// TestSettleBeforeTimeout : settle 时间边界测试
+// 合约要求 settle_block_number + punish_block_number < block.number, 只等过 settle timeout 是不够的
+func TestSettleBeforeTimeout(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	testSettleTimeout := TestSettleTimeoutMin + 1
+	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
+	cooperativeSettleChannelIfExists(a1, a2)
+	openChannelAndDeposit(a1, a2, big.NewInt(10), big.NewInt(20), testSettleTimeout)
+	// close
+	tx, err := env.TokenNetwork.PrepareSettle(a1.Auth, env.TokenAddress, a2.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// settle right after close, MUST FAIL
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// settle timeout passed, but still in punish window, MUST FAIL
+	_, settleBlockNum, _, _, _, _ := getChannelInfo(a1, a2)
+	waitUntilBlockNo(settleBlockNum + 1)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// after settle timeout and punish window, MUST SUCCESS
+	waitToSettle(a1, a2)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxSuccess(t, &count, tx, err)
+	t.Log(endMsg("ChannelSettle 时间边界测试", count, a1, a2))
+}
+
+// TestSettleTimeoutOnSimulatedBackend : 在模拟链上测试 settle timeout, 不依赖真实链
+func TestSettleTimeoutOnSimulatedBackend(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	openChannelAndDeposit(a1, a2, big.NewInt(10), big.NewInt(20), TestSettleTimeoutMin)
+	// close
+	tx, err := env.TokenNetwork.PrepareSettle(a1.Auth, env.TokenAddress, a2.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// settle right after close, MUST FAIL
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// settle timeout passed, but still in punish window, MUST FAIL
+	MineBlocks(TestSettleTimeoutMin + 1)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// after settle timeout and punish window, MUST SUCCESS
+	waitToSettle(a1, a2)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxSuccess(t, &count, tx, err)
+	assertEqual(t, &count, big.NewInt(50000000), getTokenBalance(a1))
+	assertEqual(t, &count, big.NewInt(50000000), getTokenBalance(a2))
+	t.Log(endMsg("ChannelSettle 模拟链 settle timeout 测试", count, a1, a2))
+}
+
+// TestSettleChannelByNonParticipant : 合约的 settle 不检查 msg.sender, 超时以后任何人都可以 settle 别人的通道, 但是 token 只会退给通道双方
+// TestSettleChannelByNonParticipant : settle doesn't check msg.sender, so anyone can settle a channel of others after the timeout,
+// but tokens only go back to the participants.
+func TestSettleChannelByNonParticipant(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare
+	a1, a2, charlie := env.Accounts[0], env.Accounts[1], env.Accounts[2]
+	depositA1 := big.NewInt(10)
+	depositA2 := big.NewInt(20)
+	openChannelAndDeposit(a1, a2, depositA1, depositA2, TestSettleTimeoutMin)
+	preTokenBalanceA1, preTokenBalanceA2, preTokenBalanceCharlie := getTokenBalance(a1), getTokenBalance(a2), getTokenBalance(charlie)
+	// charlie settles an open channel, MUST FAIL
+	tx, err := env.TokenNetwork.Settle(charlie.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// close
+	tx, err = env.TokenNetwork.PrepareSettle(a1.Auth, env.TokenAddress, a2.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// charlie settles before settle timeout, MUST FAIL
+	tx, err = env.TokenNetwork.Settle(charlie.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// charlie settles after settle timeout and punish window, MUST SUCCESS
+	waitToSettle(a1, a2)
+	tx, err = env.TokenNetwork.Settle(charlie.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxSuccess(t, &count, tx, err)
+	_, _, _, state, _, _ := getChannelInfo(a1, a2)
+	assertEqual(t, &count, ChannelStateSettledOrNotExist, state)
+	// deposits go back to participants, charlie gets nothing
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceA1, depositA1), getTokenBalance(a1))
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceA2, depositA2), getTokenBalance(a2))
+	assertEqual(t, &count, preTokenBalanceCharlie, getTokenBalance(charlie))
+	t.Log(endMsg("ChannelSettle 第三方settle通道测试", count, a1, a2, charlie))
+}
+
+// TestTransferAmountExceedsDeposit : 对方签名的 transferred_amount 超过双方押金之和, 合约在 settle 时不会 revert,
+// 而是把收款方的金额限制在双方押金之和以内, 所以合约里的 token 不会被取走比押金更多
+// TestTransferAmountExceedsDeposit : a balance proof transferring more than both deposits doesn't make settle revert,
+// the contract caps what the receiver gets at the total deposit, so it can never pay out more than was deposited.
+func TestTransferAmountExceedsDeposit(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	// a1 gets everything, not 10+100
+	settleWithTransferAmount(t, &count, a1, a2, big.NewInt(10), big.NewInt(20), big.NewInt(100), big.NewInt(30))
+	t.Log(endMsg("ChannelSettle 转账金额超过押金测试", count, a1, a2))
+}
+
+// TestTransferAmountOverflow : 已知的合约 bug, settle 时 a1 的押金加上 uint256 最大值的 transferred_amount 会溢出,
+// a1 只得到 9 而不是全部押金, 合约修复之前跳过
+// TestTransferAmountOverflow : known contract bug, depositA1 plus a transferred_amount of max uint256 overflows in settle,
+// a1 gets 9 instead of all deposits, skipped until the contract is fixed.
+func TestTransferAmountOverflow(t *testing.T) {
+	t.Skip("known contract bug: deposit plus transferred_amount overflows uint256 in settle")
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	settleWithTransferAmount(t, &count, a1, a2, big.NewInt(10), big.NewInt(20), maxUint256, big.NewInt(30))
+	t.Log(endMsg("ChannelSettle 转账金额溢出测试", count, a1, a2))
+}
+
+// settleWithTransferAmount : a1 closes with balance proof of a2 transferring transferAmountA2 and settles, a1 should get expectA1
+func settleWithTransferAmount(t *testing.T, count *int, a1, a2 *Account, depositA1, depositA2, transferAmountA2, expectA1 *big.Int) {
+	totalDeposit := new(big.Int).Add(depositA1, depositA2)
+	openChannelAndDeposit(a1, a2, depositA1, depositA2, TestSettleTimeoutMin)
+	preTokenBalanceA1, preTokenBalanceA2 := getTokenBalance(a1), getTokenBalance(a2)
+	preTokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	bp := createPartnerBalanceProof(a1, a2, transferAmountA2, utils.EmptyHash, utils.EmptyHash, 1)
+	tx, err := env.TokenNetwork.PrepareSettle(a1.Auth, env.TokenAddress, a2.Address, bp.TransferAmount, bp.LocksRoot, bp.Nonce, bp.AdditionalHash, bp.Signature)
+	assertTxSuccess(t, nil, tx, err)
+	waitToSettle(a1, a2)
+	tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress,
+		a1.Address, big.NewInt(0), utils.EmptyHash,
+		a2.Address, transferAmountA2, utils.EmptyHash)
+	assertTxSuccess(t, count, tx, err)
+	_, _, _, state, _, _ := getChannelInfo(a1, a2)
+	assertEqual(t, count, ChannelStateSettledOrNotExist, state)
+	// exactly the deposits leave the contract
+	assertEqual(t, count, new(big.Int).Sub(preTokenBalanceContract, totalDeposit), getTokenBalanceByAddess(env.TokenNetworkAddress))
+	assertEqual(t, count, new(big.Int).Add(preTokenBalanceA1, expectA1), getTokenBalance(a1))
+	assertEqual(t, count, new(big.Int).Add(preTokenBalanceA2, new(big.Int).Sub(totalDeposit, expectA1)), getTokenBalance(a2))
+}
+
 // TestChannelSettleEdge : 边界测试
 func TestChannelSettleEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelSettle 边界测试", count))
 }
 
 // TestChannelSettleAttack : 恶意调用测试
 func TestChannelSettleAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelSettle 恶意调用测试", count))
 }
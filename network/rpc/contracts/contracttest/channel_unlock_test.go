@@ -10,7 +10,7 @@ import (
 
 // TestChannelUnlockRight : 正确调用测试
 func TestChannelUnlockRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -21,7 +21,7 @@ func TestChannelUnlockRight(t *testing.T) {
 
 // TestChannelUnlockException : 异常调用测试
 func TestChannelUnlockException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -35,14 +35,14 @@ func TestChannelUnlockException(t *testing.T) {
 
 // TestChannelUnlockEdge : 边界测试
 func TestChannelUnlockEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("ChannelUnlock 边界测试", count))
 }
 
 // TestChannelUnlockAttack : 恶意调用测试
 func TestChannelUnlockAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
@@ -51,9 +51,90 @@ func TestChannelUnlockAttack(t *testing.T) {
 	t.Log(endMsg("ChannelUnlock 恶意调用测试", count))
 }
 
+// TestPunishWithMerkleProofForNonExistentLock : 用另一棵树给不存在的锁构造有效的 merkle proof 来 unlock
+func TestPunishWithMerkleProofForNonExistentLock(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	a1, a2 := env.getTwoAccountWithoutChannelClose(t)
+	//cases
+	runUnlockWithProofForNonExistentLockTest(a1, a2, t, &count) // 1. 包含原来所有锁和新锁的树 2. 只有新锁的树
+	t.Log(endMsg("ChannelUnlock 不存在的锁的 merkle proof 测试", count))
+}
+
+// TestChannelWithMultipleParallelUnlocks : 通道里有 5 个锁, 密码都已注册, partner 关闭通道, self 在 update 窗口提交 partner 的 balance proof,
+// 然后逐个 unlock, 每个锁都检查记账是否正确. 合约不允许在 settle timeout 以后 unlock, 所以 unlock 发生在 settle 之前.
+// TestChannelWithMultipleParallelUnlocks : a channel with 5 locks whose secrets are all registered, partner closes, self submits
+// the balance proof of partner in the update window, then unlocks locks one by one and checks what every lock credits.
+// The contract refuses unlocks after the settle timeout, so unlocks happen before settle.
+func TestChannelWithMultipleParallelUnlocks(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare
+	self, partner := env.Accounts[0], env.Accounts[1]
+	depositSelf := big.NewInt(20)
+	depositPartner := big.NewInt(50)
+	// different amounts, so a lock credited twice or credited to the wrong lock shows up
+	lockAmounts := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5)}
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	preTokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, TestSettleTimeoutMin+30)
+	// build locks and register all secrets
+	locks, secrets := createLockByArray(expireBlockNumber, lockAmounts)
+	mp := mtree.NewMerkleTree(locks)
+	registrySecrets(self, secrets)
+	assertSuccess(t, &count, verifySecretsRegistered(secrets))
+	// partner closes without any balance proof of self
+	tx, err := env.TokenNetwork.PrepareSettle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// self submits balance proof of partner with 5 locks in the update window
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(7), mp.MerkleRoot(), utils.EmptyHash, 6)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, &count, tx, err)
+	_, balanceHash, nonce, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, partner.Address, self.Address)
+	assertSuccess(t, nil, err)
+	expectBalanceHash := bpPartner.BalanceData.Hash()
+	assertEqual(t, &count, expectBalanceHash[:24], balanceHash[:])
+	assertEqual(t, &count, bpPartner.Nonce, nonce)
+	// unlock every lock, each one must add exactly its own amount
+	transferAmount := new(big.Int).Set(bpPartner.TransferAmount)
+	for _, lock := range locks {
+		unlocked, err := env.TokenNetwork.QueryUnlockedLocks(nil, env.TokenAddress, partner.Address, self.Address, lock.Hash())
+		assertSuccess(t, nil, err)
+		assertEqual(t, &count, false, unlocked)
+		proof := mtree.Proof2Bytes(mp.MakeProof(lock.Hash()))
+		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
+		assertTxSuccess(t, &count, tx, err)
+		transferAmount = new(big.Int).Add(transferAmount, lock.Amount)
+		// lock i is recorded as unlocked and balance hash of partner now carries its amount
+		unlocked, err = env.TokenNetwork.QueryUnlockedLocks(nil, env.TokenAddress, partner.Address, self.Address, lock.Hash())
+		assertSuccess(t, nil, err)
+		assertEqual(t, &count, true, unlocked)
+		_, balanceHash, nonce, err = env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, partner.Address, self.Address)
+		assertSuccess(t, nil, err)
+		expectBalanceHash = (&BalanceData{TransferAmount: transferAmount, LocksRoot: bpPartner.LocksRoot}).Hash()
+		assertEqual(t, &count, expectBalanceHash[:24], balanceHash[:])
+		assertEqual(t, &count, bpPartner.Nonce, nonce)
+		// unlocking the same lock again, MUST FAIL
+		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
+		assertTxFail(t, &count, tx, err)
+	}
+	assertEqual(t, &count, big.NewInt(7+1+2+3+4+5), transferAmount)
+	// settle
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(self.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, transferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, &count, tx, err)
+	// self gets its deposit and everything partner transferred, partner the rest of its deposit
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceSelf, transferAmount), getTokenBalance(self))
+	assertEqual(t, &count, new(big.Int).Sub(preTokenBalancePartner, transferAmount), getTokenBalance(partner))
+	assertEqual(t, &count, preTokenBalanceContract, getTokenBalanceByAddess(env.TokenNetworkAddress))
+	t.Log(endMsg("ChannelUnlock 多个锁逐个 unlock 测试", count, self, partner))
+}
+
 // TestChannelUnlockDelegateAttack : 授权调用测试
 func TestChannelUnlockDelegate(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	self, partner := env.getTwoAccountWithoutChannelClose(t)
@@ -275,6 +356,8 @@ func runRightUnlockTest(self, partner *Account, t *testing.T, count *int) {
 	partnerTransferAmount := bpPartner.TransferAmount
 	for _, lock := range locksPartner {
 		proof := mpPartner.MakeProof(lock.Hash())
+		// go side verification must agree with contract
+		assertEqual(t, nil, true, mtree.VerifyProof(bpPartner.LocksRoot, proof, lock.Hash()))
 		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, partnerTransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
 		assertTxSuccess(t, count, tx, err)
 		partnerTransferAmount = partnerTransferAmount.Add(partnerTransferAmount, lock.Amount)
@@ -284,6 +367,7 @@ func runRightUnlockTest(self, partner *Account, t *testing.T, count *int) {
 	selfTransferAmount := bpSelf.TransferAmount
 	for _, lock := range locksSelf {
 		proof := mpSelf.MakeProof(lock.Hash())
+		assertEqual(t, nil, true, mtree.VerifyProof(bpSelf.LocksRoot, proof, lock.Hash()))
 		tx, err = env.TokenNetwork.Unlock(partner.Auth, env.TokenAddress, self.Address, selfTransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
 		assertTxSuccess(t, count, tx, err)
 		selfTransferAmount = selfTransferAmount.Add(selfTransferAmount, lock.Amount)
@@ -342,6 +426,7 @@ func runUnlockWithWrongLocksrootTest(self, partner *Account, t *testing.T, count
 	registrySecrets(self, fakeSecrets)
 	for _, lock := range fakeLocks {
 		proof := mp.MakeProof(lock.Hash())
+		assertEqual(t, nil, false, mtree.VerifyProof(bpPartner.LocksRoot, proof, lock.Hash()))
 		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
 		assertTxFail(t, count, tx, err)
 	}
@@ -440,6 +525,7 @@ func runUnlockWithTamperedProofTest(self, partner *Account, t *testing.T, count
 	mp = mtree.NewMerkleTree(locks)
 	for _, lock := range locks {
 		proof := mp.MakeProof(lock.Hash())
+		assertEqual(t, nil, false, mtree.VerifyProof(bpPartner.LocksRoot, proof, lock.Hash()))
 		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(proof))
 		assertTxFail(t, count, tx, err)
 	}
@@ -456,6 +542,62 @@ func runUnlockWithTamperedProofTest(self, partner *Account, t *testing.T, count
 	assertEqual(t, count, preTokenBalanceContract, tokenBalanceContract)
 }
 
+// 1. 在包含原来所有锁的树中加入一个新锁,用这棵树的 proof unlock 新锁
+// 2. 只包含新锁的树, proof 为空, unlock 新锁
+func runUnlockWithProofForNonExistentLockTest(self, partner *Account, t *testing.T, count *int) {
+	// transaction data
+	depositSelf := big.NewInt(60)
+	depositPartner := big.NewInt(60)
+	lockAmounts := []*big.Int{big.NewInt(1), big.NewInt(3), big.NewInt(5)}
+	fakeLockAmounts := []*big.Int{big.NewInt(7)}
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	// get pre token balance
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	preTokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	// create new channel
+	cooperativeSettleChannelIfExists(self, partner)
+	testSettleTimeout := TestSettleTimeoutMin + 1
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, testSettleTimeout)
+	// build locks
+	locks, secrets := createLockByArray(expireBlockNumber, lockAmounts)
+	mp := mtree.NewMerkleTree(locks)
+	// the lock not in balance proof, its secret is registered too
+	fakeLocks, fakeSecrets := createLockByArray(expireBlockNumber, fakeLockAmounts)
+	fakeLock := fakeLocks[0]
+	registrySecrets(self, secrets)
+	registrySecrets(self, fakeSecrets)
+	// self close channel with right locks
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(0), mp.MerkleRoot(), utils.EmptyHash, 3)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, nil, tx, err)
+	// tree with all original locks and the fake one -------Case1
+	fakeTree := mtree.NewMerkleTree(append(append([]*mtree.Lock{}, locks...), fakeLock))
+	proof := fakeTree.MakeProof(fakeLock.Hash())
+	// proof is valid, but for another locksroot
+	assertEqual(t, nil, true, mtree.VerifyProof(fakeTree.MerkleRoot(), proof, fakeLock.Hash()))
+	assertEqual(t, nil, false, mtree.VerifyProof(bpPartner.LocksRoot, proof, fakeLock.Hash()))
+	tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, big.NewInt(fakeLock.Expiration), fakeLock.Amount, fakeLock.LockSecretHash, mtree.Proof2Bytes(proof))
+	assertTxFail(t, count, tx, err)
+	// tree with only the fake lock, proof is empty -------Case2
+	fakeTree = mtree.NewMerkleTree(fakeLocks)
+	proof = fakeTree.MakeProof(fakeLock.Hash())
+	assertEqual(t, nil, true, mtree.VerifyProof(fakeTree.MerkleRoot(), proof, fakeLock.Hash()))
+	assertEqual(t, nil, false, mtree.VerifyProof(bpPartner.LocksRoot, proof, fakeLock.Hash()))
+	tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, big.NewInt(fakeLock.Expiration), fakeLock.Amount, fakeLock.LockSecretHash, mtree.Proof2Bytes(proof))
+	assertTxFail(t, count, tx, err)
+	// settled for cases after this
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+	// get token balance
+	tokenBalanceSelf, tokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	tokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	// check balance
+	assertEqual(t, count, preTokenBalanceSelf, tokenBalanceSelf)
+	assertEqual(t, count, preTokenBalancePartner, tokenBalancePartner)
+	assertEqual(t, count, preTokenBalanceContract, tokenBalanceContract)
+}
+
 // 在锁过期之前,settleTimeout之后解锁
 func runUnlockAfterSettleTimeoutTest(self, partner *Account, t *testing.T, count *int) {
 	// transaction data
@@ -10,7 +10,7 @@ import (
 
 // TestUpdateBalanceProofRight : 正确调用测试
 func TestUpdateBalanceProofRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -59,7 +59,7 @@ func TestUpdateBalanceProofRight(t *testing.T) {
 
 // TestUpdateBalanceProofException : 异常调用测试
 func TestUpdateBalanceProofException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -116,7 +116,7 @@ func TestUpdateBalanceProofException(t *testing.T) {
 
 // TestUpdateBalanceProofEdge : 边界测试
 func TestUpdateBalanceProofEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -159,7 +159,7 @@ func TestUpdateBalanceProofEdge(t *testing.T) {
 
 // TestUpdateBalanceProofAttack : 恶意调用测试
 func TestUpdateBalanceProofAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -199,9 +199,50 @@ func TestUpdateBalanceProofAttack(t *testing.T) {
 	t.Log(endMsg("UpdateBalanceProof 恶意调用测试", count))
 }
 
+// TestUpdateBalanceProofByThirdParty : 非通道参与方调用测试
+func TestUpdateBalanceProofByThirdParty(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	testSettleTimeout := TestSettleTimeoutMin + 1
+	self, partner := env.getTwoAccountWithoutChannelClose(t)
+	third := env.getRandomAccountExcept(t, self, partner)
+	// open channel
+	cooperativeSettleChannelIfExists(self, partner)
+	openChannelAndDeposit(self, partner, big.NewInt(15), big.NewInt(10), testSettleTimeout)
+	// partner close channel
+	tx, err := env.TokenNetwork.PrepareSettle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// create balance proof
+	bpPartner := createPartnerBalanceProof(self, partner, big.NewInt(10), utils.EmptyHash, utils.EmptyHash, 5)
+
+	// third party update with partner's balance proof, MUST FAIL
+	tx, err = env.TokenNetwork.UpdateBalanceProof(third.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxFail(t, &count, tx, err)
+	// third party update on behalf of self, MUST FAIL
+	tx, err = env.TokenNetwork.UpdateBalanceProof(third.Auth, env.TokenAddress, self.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxFail(t, &count, tx, err)
+
+	// check partner state not changed
+	_, balanceHashPartner, noncePartner, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, partner.Address, self.Address)
+	assertSuccess(t, nil, err)
+	assertEqual(t, &count, utils.EmptyHash[:24], balanceHashPartner[:])
+	assertEqual(t, &count, uint64(0), noncePartner)
+
+	// self update right, MUST SUCCESS
+	tx, err = env.TokenNetwork.UpdateBalanceProof(self.Auth, env.TokenAddress, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot, bpPartner.Nonce, bpPartner.AdditionalHash, bpPartner.Signature)
+	assertTxSuccess(t, &count, tx, err)
+
+	// settle
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(self.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, bpPartner.TransferAmount, bpPartner.LocksRoot)
+	assertTxSuccess(t, nil, tx, err)
+	t.Log(endMsg("UpdateBalanceProof 非通道参与方调用测试", count, self, partner, third))
+}
+
 // TestChannelUnlockDelegateAttack : 授权调用测试
 func TestUpdateBalanceProofDelegate(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 30
@@ -255,3 +296,44 @@ func TestUpdateBalanceProofDelegate(t *testing.T) {
 
 	t.Log(endMsg("UpdateBalanceProof 授权调用测试", count, self, partner, third))
 }
+
+// TestUpdateBalanceProofWithHigherTransferAmount : 关闭通道时提交的 balance proof 可以被 nonce 更大的 balance proof 覆盖, settle 按最新的 balance proof 分配
+// TestUpdateBalanceProofWithHigherTransferAmount : the balance proof submitted when closing is superseded by one with a higher nonce,
+// and settle distributes tokens by the latest one.
+func TestUpdateBalanceProofWithHigherTransferAmount(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	// prepare, self pays partner
+	self, partner := env.Accounts[0], env.Accounts[1]
+	depositSelf := big.NewInt(30)
+	openChannelAndDeposit(self, partner, depositSelf, big.NewInt(0), TestSettleTimeoutMin)
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	// partner closes with self's balance proof of nonce=1 and transferAmount=10
+	bpOld := createPartnerBalanceProof(partner, self, big.NewInt(10), utils.EmptyHash, utils.EmptyHash, 1)
+	tx, err := env.TokenNetwork.PrepareSettle(partner.Auth, env.TokenAddress, self.Address, bpOld.TransferAmount, bpOld.LocksRoot, bpOld.Nonce, bpOld.AdditionalHash, bpOld.Signature)
+	assertTxSuccess(t, &count, tx, err)
+	// partner updates with self's balance proof of nonce=2 and transferAmount=20, MUST SUCCESS
+	bpNew := createPartnerBalanceProof(partner, self, big.NewInt(20), utils.EmptyHash, utils.EmptyHash, 2)
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpNew.TransferAmount, bpNew.LocksRoot, bpNew.Nonce, bpNew.AdditionalHash, bpNew.Signature)
+	assertTxSuccess(t, &count, tx, err)
+	// the old one cannot come back, MUST FAIL
+	tx, err = env.TokenNetwork.UpdateBalanceProof(partner.Auth, env.TokenAddress, self.Address, bpOld.TransferAmount, bpOld.LocksRoot, bpOld.Nonce, bpOld.AdditionalHash, bpOld.Signature)
+	assertTxFail(t, &count, tx, err)
+	// check self state after update
+	_, balanceHashSelf, nonceSelf, err := env.TokenNetwork.GetChannelParticipantInfo(nil, env.TokenAddress, self.Address, partner.Address)
+	assertSuccess(t, nil, err)
+	localBalanceHash := bpNew.BalanceData.Hash()
+	assertEqual(t, &count, localBalanceHash[:24], balanceHashSelf[:])
+	assertEqual(t, &count, bpNew.Nonce, nonceSelf)
+	// settle with the old transfer amount, MUST FAIL
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(self.Auth, env.TokenAddress, self.Address, bpOld.TransferAmount, bpOld.LocksRoot, partner.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxFail(t, &count, tx, err)
+	// settle with the latest transfer amount, MUST SUCCESS
+	tx, err = env.TokenNetwork.Settle(self.Auth, env.TokenAddress, self.Address, bpNew.TransferAmount, bpNew.LocksRoot, partner.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxSuccess(t, &count, tx, err)
+	// partner gets 20, not 10
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalancePartner, big.NewInt(20)), getTokenBalance(partner))
+	assertEqual(t, &count, new(big.Int).Add(preTokenBalanceSelf, big.NewInt(10)), getTokenBalance(self))
+	t.Log(endMsg("UpdateBalanceProof 更大 transferAmount 覆盖关闭时的 balance proof 测试", count, self, partner))
+}
@@ -9,7 +9,7 @@ import (
 
 // TestChannelWithdrawRight : 正确调用测试
 func TestChannelWithdrawRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -76,7 +76,7 @@ func TestChannelWithdrawRight(t *testing.T) {
 
 // TestChannelWithdrawException : 异常调用测试
 func TestChannelWithdrawException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -126,7 +126,7 @@ func TestChannelWithdrawException(t *testing.T) {
 
 // TestChannelWithdrawEdge : 边界测试
 func TestChannelWithdrawEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -240,7 +240,7 @@ func TestChannelWithdrawEdge(t *testing.T) {
 
 // TestChannelWithdrawAttack : 恶意调用测试
 func TestChannelWithdrawAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	testSettleTimeout := TestSettleTimeoutMin + 1
@@ -28,7 +28,7 @@ type Env struct {
 	Token                 *contracts.Token
 	TokenNetworkAddress   common.Address
 	TokenNetwork          *contracts.TokensNetwork
-	Client                *ethclient.Client
+	Client                Backend
 	SecretRegistryAddress common.Address
 	SecretRegistry        *contracts.SecretRegistry
 	Accounts              []*Account
@@ -45,8 +45,14 @@ type Account struct {
 var env *Env
 var globalPassword = "123"
 
-// InitEnv :
-func InitEnv(t *testing.T, configFilePath string) {
+// InitEnv : call teardown when the test ends, it logs blocks taken by every step
+func InitEnv(t *testing.T, configFilePath string) (teardown func()) {
+	teardown = func() {}
+	defer func() {
+		if env != nil {
+			teardown = traceBlocks(t)
+		}
+	}()
 	if env != nil {
 		env.isFirst = false
 		return
@@ -1,14 +1,22 @@
 package contracttest
 
 import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 )
 
 // TestSecretRegistryRight : 正确调用测试
 func TestSecretRegistryRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1 := env.getRandomAccountExcept(t)
@@ -39,7 +47,7 @@ func TestSecretRegistryRight(t *testing.T) {
 
 // TestSecretRegistryException : 异常调用测试
 func TestSecretRegistryException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	a1 := env.getRandomAccountExcept(t)
@@ -54,7 +62,7 @@ func TestSecretRegistryException(t *testing.T) {
 
 // TestSecretRegistryEdge : 边界测试
 func TestSecretRegistryEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	t.Log(endMsg("SecretRegistry 边界测试", count))
@@ -62,7 +70,140 @@ func TestSecretRegistryEdge(t *testing.T) {
 
 // TestSecretRegistryAttack : 恶意调用测试
 func TestSecretRegistryAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("SecretRegistry 恶意调用测试", count))
 }
+
+// TestSecretRegistryWrongChain : 用主网 chain id 签名的锁, 在测试链上注册密码以后也不能 unlock
+func TestSecretRegistryWrongChain(t *testing.T) {
+	defer InitEnv(t, "./env.INI")()
+	count := 0
+	// prepare
+	self, partner := env.getTwoAccountWithoutChannelClose(t)
+	mainnetChainID := big.NewInt(1)
+	_, _, _, _, _, chainID := getChannelInfo(self, partner)
+	if chainID.Cmp(mainnetChainID) == 0 {
+		t.Skip("test network uses mainnet chain id")
+	}
+	// transaction data
+	depositSelf := big.NewInt(60)
+	depositPartner := big.NewInt(60)
+	lockAmounts := []*big.Int{big.NewInt(1), big.NewInt(3)}
+	expireBlockNumber := getLatestBlockNumber().Number.Int64() + 100
+	// get pre token balance
+	preTokenBalanceSelf, preTokenBalancePartner := getTokenBalance(self), getTokenBalance(partner)
+	preTokenBalanceContract := getTokenBalanceByAddess(env.TokenNetworkAddress)
+	// create new channel
+	cooperativeSettleChannelIfExists(self, partner)
+	openChannelAndDeposit(self, partner, depositSelf, depositPartner, TestSettleTimeoutMin+1)
+	// build locks
+	locks, secrets := createLockByArray(expireBlockNumber, lockAmounts)
+	mp := mtree.NewMerkleTree(locks)
+	// SecretRegistry only takes the secret, nothing about chain can be bound to it, registration succeeds
+	registrySecrets(self, secrets)
+	for _, lock := range locks {
+		blockNo, err := env.SecretRegistry.GetSecretRevealBlockHeight(nil, lock.LockSecretHash)
+		assertSuccess(t, nil, err)
+		assertEqual(t, nil, true, blockNo.Int64() > 0)
+	}
+	// partner's balance proof with locks signed for mainnet, MUST FAIL
+	bpMainnet := createPartnerBalanceProof(self, partner, big.NewInt(0), mp.MerkleRoot(), utils.EmptyHash, 3)
+	bpMainnet.ChainID = mainnetChainID
+	bpMainnet.sign(partner.Key)
+	tx, err := env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, bpMainnet.TransferAmount, bpMainnet.LocksRoot, bpMainnet.Nonce, bpMainnet.AdditionalHash, bpMainnet.Signature)
+	assertTxFail(t, &count, tx, err)
+	// close without balance proof
+	tx, err = env.TokenNetwork.PrepareSettle(self.Auth, env.TokenAddress, partner.Address, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	assertTxSuccess(t, nil, tx, err)
+	// registered secrets don't unlock locks of mainnet balance proof, MUST FAIL
+	for _, lock := range locks {
+		tx, err = env.TokenNetwork.Unlock(self.Auth, env.TokenAddress, partner.Address, big.NewInt(0), big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, mtree.Proof2Bytes(mp.MakeProof(lock.Hash())))
+		assertTxFail(t, &count, tx, err)
+	}
+	// settle
+	waitToSettle(self, partner)
+	tx, err = env.TokenNetwork.Settle(partner.Auth, env.TokenAddress, self.Address, big.NewInt(0), utils.EmptyHash, partner.Address, big.NewInt(0), utils.EmptyHash)
+	assertTxSuccess(t, nil, tx, err)
+	// check balance
+	assertEqual(t, &count, preTokenBalanceSelf, getTokenBalance(self))
+	assertEqual(t, &count, preTokenBalancePartner, getTokenBalance(partner))
+	assertEqual(t, &count, preTokenBalanceContract, getTokenBalanceByAddess(env.TokenNetworkAddress))
+	t.Log(endMsg("SecretRegistry 错误 chain id 测试", count))
+}
+
+// TestVerifySecretsRegistered : registrySecrets 返回前确认密码已经注册, 没有注册的密码必须被发现
+// TestVerifySecretsRegistered : registrySecrets confirms secrets are registered before returning,
+// and a secret not registered must be detected.
+func TestVerifySecretsRegistered(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1 := env.Accounts[0]
+	_, secrets := createLock(getLatestBlockNumber().Number.Int64()+100, big.NewInt(1), big.NewInt(2))
+	registrySecrets(a1, secrets)
+	assertSuccess(t, &count, verifySecretsRegistered(secrets))
+	_, unregistered := createLock(getLatestBlockNumber().Number.Int64()+100, big.NewInt(1))
+	assertFail(t, &count, verifySecretsRegistered(unregistered))
+	assertFail(t, &count, verifySecretsRegistered(append(secrets, unregistered...)))
+	t.Log(endMsg("SecretRegistry 注册确认测试", count, a1))
+}
+
+/*
+TestSecretRegistryBatchRegister : 一个交易注册 N 个密码, 检查每个密码的注册块, 并和 N 个交易分别注册比较 gas.
+当前的 SecretRegistry 合约只有 registerSecret, 没有批量注册的函数, 所以这里只测试分别注册的 gas 作为基准,
+然后 Skip, 说明缺少这个功能. 合约增加批量注册以后在这里补上比较.
+*/
+/*
+ *	TestSecretRegistryBatchRegister : register N secrets in one tx, check block number of every secret,
+ *	and compare gas with registering them in N txs.
+ *	SecretRegistry has only registerSecret and no batch register function yet, so only gas of N separate
+ *	registrations is measured as the baseline and the test is skipped to report the missing feature.
+ *	Add the comparison here once the contract supports batch registration.
+ */
+func TestSecretRegistryBatchRegister(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	a1 := env.Accounts[0]
+	const n = 5
+	registryABI, err := abi.JSON(strings.NewReader(contracts.SecretRegistryABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batchMethods []string
+	for name := range registryABI.Methods {
+		if strings.Contains(strings.ToLower(name), "batch") {
+			batchMethods = append(batchMethods, name)
+		}
+	}
+	// baseline: n secrets in n txs
+	var amounts []*big.Int
+	for i := 0; i < n; i++ {
+		amounts = append(amounts, big.NewInt(int64(i+1)))
+	}
+	_, secrets := createLockByArray(getLatestBlockNumber().Number.Int64()+100, amounts)
+	var gasUsed uint64
+	for _, secret := range secrets {
+		tx, err := env.SecretRegistry.RegisterSecret(a1.Auth, secret)
+		assertTxSuccess(t, &count, tx, err)
+		receipt, err := bind.WaitMined(context.Background(), env.Client, tx)
+		assertSuccess(t, nil, err)
+		gasUsed += receipt.GasUsed
+		block, err := env.SecretRegistry.GetSecretRevealBlockHeight(nil, utils.ShaSecret(secret[:]))
+		assertSuccess(t, nil, err)
+		// the simulated chain mines a block for every tx
+		assertEqual(t, &count, getLatestBlockNumber().Number, block)
+	}
+	t.Logf("register %d secrets in %d txs, gas used %d, %d per secret", n, n, gasUsed, gasUsed/n)
+	if len(batchMethods) == 0 {
+		t.Skipf("missing feature: SecretRegistry has no batch register function, methods are %v", methodNames(registryABI))
+	}
+	t.Fatalf("SecretRegistry has batch register functions %v, compare their gas with %d here", batchMethods, gasUsed)
+}
+
+func methodNames(a abi.ABI) (names []string) {
+	for name := range a.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,181 @@
+package contracttest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/contracttest/backends"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/test/tokens/tokenstandard"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	ethparams "github.com/ethereum/go-ethereum/params"
+)
+
+//simulatedAccountNumber accounts created for a simulated env
+const simulatedAccountNumber = 4
+
+//Backend is what the contract tests need from a chain, both ethclient.Client and the simulated backend satisfy it
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+//simulatedBackend mines a block for every transaction,so bind.WaitMined returns at once
+type simulatedBackend struct {
+	*backends.SimulatedBackend
+}
+
+//SendTransaction send tx and mine it immediately
+func (s *simulatedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	err := s.SimulatedBackend.SendTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+	s.Commit()
+	return nil
+}
+
+//NetworkID chain id the simulated chain is built with
+func (s *simulatedBackend) NetworkID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(ethparams.AllEthashProtocolChanges.ChainId), nil
+}
+
+//HeaderByNumber nil means the latest block, ethereum.NotFound if the block isn't mined yet, the same as ethclient
+func (s *simulatedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	block, err := s.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, ethereum.NotFound
+	}
+	return block.Header(), nil
+}
+
+//simulatedTransactor the simulated backend recovers senders by HomesteadSigner, so txs are signed without chain id
+func simulatedTransactor(key *ecdsa.PrivateKey) *bind.TransactOpts {
+	auth := bind.NewKeyedTransactor(key)
+	sign := auth.Signer
+	auth.Signer = func(signer types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		return sign(types.HomesteadSigner{}, address, tx)
+	}
+	return auth
+}
+
+/*
+MineBlocks 在模拟链上立即产生n个空块,在真实链上则等待n个块
+*/
+/*
+ *	MineBlocks : mine n empty blocks on the simulated chain at once,
+ *	on a live chain it just waits for n blocks.
+ */
+func MineBlocks(n uint64) {
+	sim, ok := env.Client.(*simulatedBackend)
+	if !ok {
+		waitByBlocknum(n)
+		return
+	}
+	for i := uint64(0); i < n; i++ {
+		sim.Commit()
+	}
+}
+
+/*
+NewSimulatedEnv 创建一个基于 go-ethereum 模拟链的测试环境,部署 token 和 TokensNetwork,
+并给每个账户分配 ether 和 token, 不需要真实的链.
+*/
+/*
+ *	NewSimulatedEnv : create a test env on top of go-ethereum's simulated backend.
+ *	It deploys a token and a TokensNetwork, and funds every account with ether and tokens,
+ *	so no live chain is needed.
+ */
+func NewSimulatedEnv(t *testing.T) *Env {
+	alloc := make(core.GenesisAlloc)
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < simulatedAccountNumber; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil)}
+	}
+	sim := &simulatedBackend{backends.NewSimulatedBackend(alloc)}
+	//bind reads the header of block 1 before sending any tx
+	sim.Commit()
+	e := &Env{
+		EthRPCEndpoint: "simulated",
+		Client:         sim,
+	}
+	chainID, err := sim.NetworkID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployer := simulatedTransactor(keys[0])
+	tokenAddress, _, token, err := tokenstandard.DeployHumanStandardToken(deployer, sim, big.NewInt(50000000*simulatedAccountNumber), "SIM", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.TokenAddress = tokenAddress
+	e.Token, err = contracts.NewToken(e.TokenAddress, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.TokenNetworkAddress, _, e.TokenNetwork, err = contracts.DeployTokensNetwork(deployer, sim, chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SecretRegistryAddress, err = e.TokenNetwork.SecretRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SecretRegistry, err = contracts.NewSecretRegistry(e.SecretRegistryAddress, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		account := &Account{
+			Address: crypto.PubkeyToAddress(key.PublicKey),
+			Key:     key,
+			Auth:    simulatedTransactor(key),
+		}
+		if i > 0 {
+			_, err = token.Transfer(deployer, account.Address, big.NewInt(50000000))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = e.Token.Approve(account.Auth, e.TokenNetworkAddress, big.NewInt(50000000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Accounts = append(e.Accounts, account)
+	}
+	t.Logf("simulated env with [%d] accounts, TokenNetwork = %s", len(e.Accounts), e.TokenNetworkAddress.String())
+	return e
+}
+
+//useSimulatedEnv replace the global env with a simulated one, call the returned func to restore it
+func useSimulatedEnv(t *testing.T) (restore func()) {
+	old := env
+	env = NewSimulatedEnv(t)
+	report := traceBlocks(t)
+	return func() {
+		report()
+		env = old
+	}
+}
+
+//isSimulated true if the global env runs on the simulated backend
+func isSimulated() bool {
+	_, ok := env.Client.(*simulatedBackend)
+	return ok
+}
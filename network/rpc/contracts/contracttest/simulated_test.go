@@ -0,0 +1,27 @@
+package contracttest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+// TestSimulatedHeaderByNumber : 模拟链上查询还没产生的块返回 ethereum.NotFound, 和 ethclient 一样
+// TestSimulatedHeaderByNumber : headers not mined yet are ethereum.NotFound on the simulated chain, the same as ethclient
+func TestSimulatedHeaderByNumber(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	latest, err := env.Client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := env.Client.HeaderByNumber(context.Background(), latest.Number)
+	if err != nil || h.Hash() != latest.Hash() {
+		t.Fatalf("header of latest block err=%v", err)
+	}
+	_, err = env.Client.HeaderByNumber(context.Background(), new(big.Int).Add(latest.Number, big.NewInt(1)))
+	if err != ethereum.NotFound {
+		t.Fatalf("expect ethereum.NotFound, got %v", err)
+	}
+}
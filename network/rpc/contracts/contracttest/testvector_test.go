@@ -0,0 +1,98 @@
+package contracttest
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts/testvector"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TestTestVectorsOnChain : testvector 的黄金向量放到链上的通道里, go 计算的 balance proof, balance hash, merkle proof 必须被合约接受
+// TestTestVectorsOnChain : golden vectors of testvector are put into a channel on chain, balance proofs, balance hashes and
+// merkle proofs computed by go must be what the contract computes.
+// Channel identifier, open block number and chain id of every vector are replaced by those of the channel,
+// and expirations are moved after the current block so secrets can be registered in time.
+func TestTestVectorsOnChain(t *testing.T) {
+	defer useSimulatedEnv(t)()
+	count := 0
+	vectors, err := testvector.Load("../testvector/testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1, a2 := env.Accounts[0], env.Accounts[1]
+	chain := &testvector.OnChain{
+		Chain:         env.Client,
+		TokensNetwork: env.TokenNetworkAddress,
+		Token:         env.TokenAddress,
+	}
+	for _, golden := range vectors {
+		openChannelAndDeposit(a1, a2, big.NewInt(100), big.NewInt(100), TestSettleTimeoutMin+30)
+		channelID, _, openBlockNumber, _, _, chainID := getChannelInfo(a1, a2)
+		bp := *golden.BalanceProof
+		bp.ChannelIdentifier = common.Hash(channelID)
+		bp.OpenBlockNumber = int64(openBlockNumber)
+		bp.ChainID = chainID
+		v := &testvector.Vector{Name: golden.Name, BalanceProof: &bp}
+		currentBlockNumber := getLatestBlockNumber().Number.Int64()
+		for _, l := range golden.Locks {
+			lock := *l
+			lock.Expiration += currentBlockNumber
+			v.Locks = append(v.Locks, &lock)
+		}
+		r, err := testvector.Compute(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a1 closes with balance proof of a2
+		sig, err := utils.SignData(a2.Key, r.BalanceProofPacked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTxSuccess(t, &count, nil, chain.CheckBalanceProof(a1.Address, a2.Address, v, r, sig))
+		wrongSig, err := utils.SignData(a2.Key, r.BalanceProofPacked[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertTxFail(t, &count, nil, chain.CheckBalanceProof(a1.Address, a2.Address, v, r, wrongSig))
+		tx, err := env.TokenNetwork.PrepareSettle(a1.Auth, env.TokenAddress, a2.Address, bp.TransferredAmount, r.MerkleRoot, bp.Nonce, bp.AdditionalHash, sig)
+		assertTxSuccess(t, nil, tx, err)
+		assertTxSuccess(t, &count, nil, chain.CheckBalanceHash(a1.Address, a2.Address, v, r))
+		// a1 unlocks every lock of a2 whose secret is known
+		for i, l := range v.Locks {
+			if l.Secret == utils.EmptyHash {
+				continue
+			}
+			// vectors share secrets, a secret registered for an earlier vector is still in time
+			revealBlock, err := env.SecretRegistry.GetSecretRevealBlockHeight(nil, l.SecretHash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revealBlock.Sign() == 0 {
+				tx, err = env.SecretRegistry.RegisterSecret(a1.Auth, l.Secret)
+				assertTxSuccess(t, nil, tx, err)
+			}
+			unlocked := new(big.Int).Add(bp.TransferredAmount, l.Amount)
+			if unlocked.BitLen() > 256 {
+				// transferred amount would overflow uint256, the contract rejects the unlock
+				assertTxFail(t, &count, nil, chain.CheckUnlock(a1.Address, a2.Address, v, r, i))
+				continue
+			}
+			if len(r.Proofs[i]) > 0 {
+				wrongProof := common.CopyBytes(r.Proofs[i])
+				wrongProof[0] ^= 1
+				assertTxFail(t, &count, nil, chain.CheckUnlockWithProof(a1.Address, a2.Address, v, i, wrongProof))
+			}
+			assertTxSuccess(t, &count, nil, chain.CheckUnlock(a1.Address, a2.Address, v, r, i))
+			tx, err = env.TokenNetwork.Unlock(a1.Auth, env.TokenAddress, a2.Address, bp.TransferredAmount, big.NewInt(l.Expiration), l.Amount, l.SecretHash(), r.Proofs[i])
+			assertTxSuccess(t, nil, tx, err)
+			assertTxSuccess(t, &count, nil, chain.CheckUnlocked(a1.Address, a2.Address, v, r, i))
+			bp.TransferredAmount = unlocked
+		}
+		waitToSettle(a1, a2)
+		tx, err = env.TokenNetwork.Settle(a1.Auth, env.TokenAddress, a1.Address, big.NewInt(0), utils.EmptyHash, a2.Address, bp.TransferredAmount, r.MerkleRoot)
+		assertTxSuccess(t, nil, tx, err)
+	}
+	t.Log(endMsg("TestVector 链上对比测试", count, a1, a2))
+}
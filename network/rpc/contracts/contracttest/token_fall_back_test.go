@@ -12,7 +12,7 @@ import (
 
 // TestTokenFallbackRight : 正确调用测试
 func TestTokenFallbackRight(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	// prepare
 	depositAmountA1 := big.NewInt(10)
@@ -85,7 +85,7 @@ func TestTokenFallbackRight(t *testing.T) {
 
 // TestTokenFallbackException : 异常调用测试
 func TestTokenFallbackException(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("TokenFallback 异常调用测试", count))
 
@@ -93,14 +93,14 @@ func TestTokenFallbackException(t *testing.T) {
 
 // TestTokenFallbackEdge : 边界测试
 func TestTokenFallbackEdge(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("TokenFallback 边界测试", count))
 }
 
 // TestTokenFallbackAttack : 恶意调用测试
 func TestTokenFallbackAttack(t *testing.T) {
-	InitEnv(t, "./env.INI")
+	defer InitEnv(t, "./env.INI")()
 	count := 0
 	t.Log(endMsg("TokenFallback 恶意调用测试", count))
 }
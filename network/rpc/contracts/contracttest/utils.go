@@ -187,6 +187,7 @@ func openChannelAndDeposit(a1, a2 *Account, depositA1, depositA2 *big.Int, settl
 			panic(err)
 		}
 	}
+	markBlock("open")
 }
 
 func withdraw(a1 *Account, depositA1, withdrawA1 *big.Int, a2 *Account) {
@@ -264,7 +265,8 @@ type BalanceProofForContract struct {
 	BalanceData
 }
 
-func (b *BalanceProofForContract) sign(key *ecdsa.PrivateKey) {
+//signData is the message the contract rebuilds and recovers the signer from
+func (b *BalanceProofForContract) signData() []byte {
 	buf := new(bytes.Buffer)
 	_, err := buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte("176"))
@@ -276,7 +278,19 @@ func (b *BalanceProofForContract) sign(key *ecdsa.PrivateKey) {
 	err = binary.Write(buf, binary.BigEndian, b.OpenBlockNumber)
 	//buf.Write(b.TokenNetworkAddress[:])
 	_, err = buf.Write(utils.BigIntTo32Bytes(b.ChainID))
-	sig, err := utils.SignData(key, buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+//Hash is the hash signed by the partner
+func (b *BalanceProofForContract) Hash() common.Hash {
+	return utils.Sha3(b.signData())
+}
+
+func (b *BalanceProofForContract) sign(key *ecdsa.PrivateKey) {
+	sig, err := utils.SignData(key, b.signData())
 	if err != nil {
 		panic(err)
 	}
@@ -326,6 +340,11 @@ func (b *BalanceProofUpdateForContracts) sign(key *ecdsa.PrivateKey) {
 
 func createPartnerBalanceProof(self *Account, partner *Account, transferAmount *big.Int, locksroot common.Hash, additionalHash common.Hash, nonce uint64) *BalanceProofForContract {
 	channelID, _, openBlockNumber, _, _, ChainID := getChannelInfo(self, partner)
+	return createBalanceProof(partner.Key, channelID, openBlockNumber, env.TokenNetworkAddress, ChainID, transferAmount, locksroot, additionalHash, nonce)
+}
+
+//createBalanceProof builds a balance proof signed by key without touching the chain
+func createBalanceProof(key *ecdsa.PrivateKey, channelID contracts.ChannelIdentifier, openBlockNumber uint64, tokenNetwork common.Address, chainID *big.Int, transferAmount *big.Int, locksroot common.Hash, additionalHash common.Hash, nonce uint64) *BalanceProofForContract {
 	bd := &BalanceData{
 		TransferAmount: transferAmount,
 		LocksRoot:      locksroot,
@@ -335,11 +354,11 @@ func createPartnerBalanceProof(self *Account, partner *Account, transferAmount *
 		OpenBlockNumber:     openBlockNumber,
 		AdditionalHash:      additionalHash,
 		ChannelIdentifier:   channelID,
-		TokenNetworkAddress: env.TokenNetworkAddress,
-		ChainID:             ChainID,
+		TokenNetworkAddress: tokenNetwork,
+		ChainID:             chainID,
 		Nonce:               nonce,
 	}
-	bp.sign(partner.Key)
+	bp.sign(key)
 	return bp
 }
 
@@ -376,6 +395,7 @@ func registrySecrets(account *Account, secrets []common.Hash) {
 	for i := 0; i < len(secrets); i++ {
 		s := secrets[i]
 		if i > maxLocks { //最多注册前五个密码,否则太浪费时间了.
+			secrets = secrets[:i]
 			break
 		}
 		tx, err := env.SecretRegistry.RegisterSecret(account.Auth, s)
@@ -387,9 +407,29 @@ func registrySecrets(account *Account, secrets []common.Hash) {
 			panic(err)
 		}
 		if r.Status != types.ReceiptStatusSuccessful {
-			panic(err)
+			panic(fmt.Sprintf("register secret %s failed", s.String()))
+		}
+	}
+	// 确认密码在链上确实注册了, 否则后续 unlock/punish 的结果不确定
+	err := verifySecretsRegistered(secrets)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//verifySecretsRegistered read back reveal block of every secret, error if any of them is not registered on chain
+func verifySecretsRegistered(secrets []common.Hash) error {
+	for _, s := range secrets {
+		secretHash := utils.ShaSecret(s[:])
+		blockNo, err := env.SecretRegistry.GetSecretRevealBlockHeight(nil, secretHash)
+		if err != nil {
+			return err
+		}
+		if blockNo == nil || blockNo.Sign() <= 0 {
+			return fmt.Errorf("secret %s with hash %s is not registered on chain", s.String(), secretHash.String())
 		}
 	}
+	return nil
 }
 
 func getChannelInfo(a1 *Account, a2 *Account) (channelID [32]byte, settleBlockNum uint64, openBlockNumber uint64, state uint8, settleTimeout uint64, ChainID *big.Int) {
@@ -451,8 +491,13 @@ func waitUntilBlockNo(blockNo uint64) {
 		if h.Number.Uint64() >= blockNo {
 			break
 		}
+		if isSimulated() {
+			MineBlocks(blockNo - h.Number.Uint64())
+			continue
+		}
 		time.Sleep(time.Second)
 	}
+	markBlock(fmt.Sprintf("wait until %d", blockNo))
 }
 
 func waitByBlocknum(blocknum uint64) {
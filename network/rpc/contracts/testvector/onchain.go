@@ -0,0 +1,136 @@
+package testvector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//Chain what checking vectors against a deployed contract needs, *ethclient.Client and the simulated backend both are
+type Chain interface {
+	bind.ContractCaller
+	ethereum.GasEstimator
+}
+
+/*
+OnChain 用部署的 TokensNetwork 合约检查 vector 的计算结果.
+合约里的 computeMerkleRoot 和 calceBalanceHash 是 internal 的, 不能直接 eth_call,
+所以检查的方式是: 用 eth_estimateGas 判断 prepareSettle/unlock 会不会 revert (旧节点的 eth_call 不报告 revert),
+用 eth_call 读取合约保存的 balance hash 和已经 unlock 的锁.
+*/
+/*
+ *	OnChain : checks results of vectors with a deployed TokensNetwork contract.
+ *	computeMerkleRoot and calceBalanceHash of the contract are internal and can't be called by eth_call,
+ *	so prepareSettle and unlock are estimated by eth_estimateGas, which fails when they would revert
+ *	(eth_call of older nodes doesn't report reverts), and balance hashes and unlocked locks stored by the
+ *	contract are read by eth_call.
+ */
+type OnChain struct {
+	Chain         Chain
+	TokensNetwork common.Address
+	Token         common.Address
+}
+
+var tokensNetworkABI abi.ABI
+
+func init() {
+	var err error
+	tokensNetworkABI, err = abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c *OnChain) estimate(from common.Address, method string, args ...interface{}) error {
+	data, err := tokensNetworkABI.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	_, err = c.Chain.EstimateGas(context.Background(), ethereum.CallMsg{
+		From: from,
+		To:   &c.TokensNetwork,
+		Data: data,
+	})
+	return err
+}
+
+/*
+CheckBalanceProof closer 用 partner 签名的 balance proof 关闭通道会不会被合约接受,
+合约用 r.MerkleRoot 和 v 的字段重新打包, 只有和 r.BalanceProofPacked 一致时 signature 才能恢复出 partner.
+*/
+/*
+ *	CheckBalanceProof : whether the contract accepts closing the channel by closer with balance proof signed by partner,
+ *	the contract packs r.MerkleRoot and fields of v again, signature recovers partner only if that equals r.BalanceProofPacked.
+ */
+func (c *OnChain) CheckBalanceProof(closer, partner common.Address, v *Vector, r *Result, signature []byte) error {
+	bp := v.BalanceProof
+	err := c.estimate(closer, "prepareSettle", c.Token, partner, bp.TransferredAmount, r.MerkleRoot, bp.Nonce, bp.AdditionalHash, signature)
+	if err != nil {
+		return fmt.Errorf("vector %s: prepareSettle rejected: %s", v.Name, err)
+	}
+	return nil
+}
+
+//CheckBalanceHash balance hash the contract stored for partner after closer closed the channel equals r.BalanceHash
+func (c *OnChain) CheckBalanceHash(closer, partner common.Address, v *Vector, r *Result) error {
+	caller, err := contracts.NewTokensNetworkCaller(c.TokensNetwork, c.Chain)
+	if err != nil {
+		return err
+	}
+	_, balanceHash, _, err := caller.GetChannelParticipantInfo(nil, c.Token, partner, closer)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(balanceHash[:], r.BalanceHash) {
+		return fmt.Errorf("vector %s: balance hash on chain 0x%x, go computed %s", v.Name, balanceHash, r.BalanceHash)
+	}
+	return nil
+}
+
+/*
+CheckUnlock unlocker 用 r 里的 proof unlock 第 i 个锁会不会被合约接受, 合约用 proof 重新计算 merkle root,
+只有和 balance hash 一致时才接受. 锁的密码必须已经注册.
+*/
+/*
+ *	CheckUnlock : whether the contract accepts unlocking lock i by unlocker with the proof in r,
+ *	the contract computes the merkle root from the proof and accepts it only if it matches the balance hash.
+ *	The secret of the lock must have been registered.
+ */
+func (c *OnChain) CheckUnlock(unlocker, partner common.Address, v *Vector, r *Result, i int) error {
+	return c.CheckUnlockWithProof(unlocker, partner, v, i, r.Proofs[i])
+}
+
+//CheckUnlockWithProof the same as CheckUnlock but with any proof, a wrong proof must be rejected
+func (c *OnChain) CheckUnlockWithProof(unlocker, partner common.Address, v *Vector, i int, proof []byte) error {
+	l := v.Locks[i]
+	err := c.estimate(unlocker, "unlock", c.Token, partner, v.BalanceProof.TransferredAmount,
+		big.NewInt(l.Expiration), l.Amount, l.SecretHash(), proof)
+	if err != nil {
+		return fmt.Errorf("vector %s: unlock of lock %d rejected: %s", v.Name, i, err)
+	}
+	return nil
+}
+
+//CheckUnlocked the contract recorded lock i of partner as unlocked by unlocker, under the hash in r
+func (c *OnChain) CheckUnlocked(unlocker, partner common.Address, v *Vector, r *Result, i int) error {
+	caller, err := contracts.NewTokensNetworkCaller(c.TokensNetwork, c.Chain)
+	if err != nil {
+		return err
+	}
+	unlocked, err := caller.QueryUnlockedLocks(nil, c.Token, partner, unlocker, r.LockHashes[i])
+	if err != nil {
+		return err
+	}
+	if !unlocked {
+		return fmt.Errorf("vector %s: lock %d with hash %s not unlocked on chain", v.Name, i, r.LockHashes[i].String())
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package testvector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+/*
+Vector 描述一组锁和 balance proof 字段, 用来生成 merkle root, 锁的 hash, merkle proof 以及 balance proof 的打包数据和 hash,
+其他语言实现的客户端可以用同样的输入比较结果. Expected 是已知正确的结果, 为空表示还没有生成.
+*/
+/*
+ *	Vector : locks and balance proof fields, from which merkle root, lock hashes, merkle proofs,
+ *	and the packed and hashed balance proof are computed exactly as the TokensNetwork contract does,
+ *	so that clients in other languages can compare their results with the same input.
+ *	Expected is the known good result, nil if not generated yet.
+ */
+type Vector struct {
+	Name         string        `json:"name"`
+	Locks        []*LockInput  `json:"locks"`
+	BalanceProof *BalanceProof `json:"balance_proof"`
+	Expected     *Result       `json:"expected,omitempty"`
+}
+
+//LockInput a lock of a vector, LockSecretHash is sha256 of Secret when Secret is given
+type LockInput struct {
+	Expiration     int64       `json:"expiration"`
+	Amount         *big.Int    `json:"amount"`
+	Secret         common.Hash `json:"secret"`
+	LockSecretHash common.Hash `json:"lock_secret_hash"`
+}
+
+//BalanceProof fields of a balance proof except locksroot, which is the merkle root of the locks
+type BalanceProof struct {
+	Nonce             uint64      `json:"nonce"`
+	TransferredAmount *big.Int    `json:"transferred_amount"`
+	ChannelIdentifier common.Hash `json:"channel_identifier"`
+	OpenBlockNumber   int64       `json:"open_block_number"`
+	ChainID           *big.Int    `json:"chain_id"`
+	AdditionalHash    common.Hash `json:"additional_hash"`
+}
+
+//Result everything computed from a vector, proofs are serialized by mtree.Proof2Bytes in the order of the locks
+type Result struct {
+	LockHashes         []common.Hash   `json:"lock_hashes"`
+	MerkleRoot         common.Hash     `json:"merkle_root"`
+	Proofs             []hexutil.Bytes `json:"proofs"`
+	BalanceHash        hexutil.Bytes   `json:"balance_hash"` //bytes24 stored by the contract
+	BalanceProofPacked hexutil.Bytes   `json:"balance_proof_packed"`
+	BalanceProofHash   common.Hash     `json:"balance_proof_hash"`
+}
+
+//SecretHash lock secret hash of this lock
+func (l *LockInput) SecretHash() common.Hash {
+	if l.Secret != utils.EmptyHash {
+		return utils.ShaSecret(l.Secret[:])
+	}
+	return l.LockSecretHash
+}
+
+//MtreeLocks locks of this vector as they are in a channel
+func (v *Vector) MtreeLocks() []*mtree.Lock {
+	locks := make([]*mtree.Lock, len(v.Locks))
+	for i, l := range v.Locks {
+		locks[i] = &mtree.Lock{
+			Expiration:     l.Expiration,
+			Amount:         l.Amount,
+			LockSecretHash: l.SecretHash(),
+		}
+	}
+	return locks
+}
+
+/*
+Compute 按照合约的方式计算 vector 的结果, 锁不能重复, 金额不能为空.
+*/
+/*
+ *	Compute : compute result of v the way the contract does, locks must not repeat and amounts must be given.
+ */
+func Compute(v *Vector) (*Result, error) {
+	if v.BalanceProof == nil || v.BalanceProof.TransferredAmount == nil || v.BalanceProof.ChainID == nil {
+		return nil, fmt.Errorf("vector %s: balance proof, transferred_amount and chain_id are required", v.Name)
+	}
+	locks := v.MtreeLocks()
+	r := &Result{}
+	seen := make(map[common.Hash]bool)
+	for i, l := range locks {
+		if l.Amount == nil {
+			return nil, fmt.Errorf("vector %s: lock %d has no amount", v.Name, i)
+		}
+		h := l.Hash()
+		if seen[h] {
+			return nil, fmt.Errorf("vector %s: lock %d repeated", v.Name, i)
+		}
+		seen[h] = true
+		r.LockHashes = append(r.LockHashes, h)
+	}
+	tree := mtree.NewMerkleTree(locks)
+	r.MerkleRoot = tree.MerkleRoot()
+	for _, h := range r.LockHashes {
+		r.Proofs = append(r.Proofs, mtree.Proof2Bytes(tree.MakeProof(h)))
+	}
+	bp := v.BalanceProof
+	balanceHash := utils.BalanceHash(bp.TransferredAmount, r.MerkleRoot)
+	r.BalanceHash = balanceHash[len(balanceHash)-24:]
+	r.BalanceProofPacked = encoding.BalanceProofSignData(&encoding.BalanceProof{
+		Nonce:             bp.Nonce,
+		ChannelIdentifier: bp.ChannelIdentifier,
+		OpenBlockNumber:   bp.OpenBlockNumber,
+		TransferAmount:    bp.TransferredAmount,
+		Locksroot:         r.MerkleRoot,
+	}, bp.AdditionalHash, bp.ChainID)
+	r.BalanceProofHash = utils.Sha3(r.BalanceProofPacked)
+	return r, nil
+}
+
+//Diff fields of got different from expected, nil if they are the same
+func Diff(expected, got *Result) (diffs []string) {
+	if expected == nil || got == nil {
+		return []string{"missing result"}
+	}
+	if len(expected.LockHashes) != len(got.LockHashes) {
+		diffs = append(diffs, fmt.Sprintf("lock_hashes: expect %d, got %d", len(expected.LockHashes), len(got.LockHashes)))
+	} else {
+		for i := range expected.LockHashes {
+			if expected.LockHashes[i] != got.LockHashes[i] {
+				diffs = append(diffs, fmt.Sprintf("lock_hashes[%d]: expect %s, got %s", i, expected.LockHashes[i].String(), got.LockHashes[i].String()))
+			}
+		}
+	}
+	if expected.MerkleRoot != got.MerkleRoot {
+		diffs = append(diffs, fmt.Sprintf("merkle_root: expect %s, got %s", expected.MerkleRoot.String(), got.MerkleRoot.String()))
+	}
+	if len(expected.Proofs) != len(got.Proofs) {
+		diffs = append(diffs, fmt.Sprintf("proofs: expect %d, got %d", len(expected.Proofs), len(got.Proofs)))
+	} else {
+		for i := range expected.Proofs {
+			if !bytes.Equal(expected.Proofs[i], got.Proofs[i]) {
+				diffs = append(diffs, fmt.Sprintf("proofs[%d]: expect %s, got %s", i, expected.Proofs[i], got.Proofs[i]))
+			}
+		}
+	}
+	if !bytes.Equal(expected.BalanceHash, got.BalanceHash) {
+		diffs = append(diffs, fmt.Sprintf("balance_hash: expect %s, got %s", expected.BalanceHash, got.BalanceHash))
+	}
+	if !bytes.Equal(expected.BalanceProofPacked, got.BalanceProofPacked) {
+		diffs = append(diffs, fmt.Sprintf("balance_proof_packed: expect %s, got %s", expected.BalanceProofPacked, got.BalanceProofPacked))
+	}
+	if expected.BalanceProofHash != got.BalanceProofHash {
+		diffs = append(diffs, fmt.Sprintf("balance_proof_hash: expect %s, got %s", expected.BalanceProofHash.String(), got.BalanceProofHash.String()))
+	}
+	return
+}
+
+//Verify compute v and compare with v.Expected
+func Verify(v *Vector) error {
+	if v.Expected == nil {
+		return fmt.Errorf("vector %s has no expected result", v.Name)
+	}
+	r, err := Compute(v)
+	if err != nil {
+		return err
+	}
+	diffs := Diff(v.Expected, r)
+	if len(diffs) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("vector %s mismatch:", v.Name)
+	for _, d := range diffs {
+		msg += "\n\t" + d
+	}
+	return errors.New(msg)
+}
+
+//Load read vectors from a json file
+func Load(filename string) (vectors []*Vector, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &vectors)
+	return
+}
+
+//Save write vectors to a json file
+func Save(filename string, vectors []*Vector) error {
+	data, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
@@ -0,0 +1,101 @@
+package testvector
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const goldenFile = "testdata/vectors.json"
+
+func TestGoldenVectors(t *testing.T) {
+	vectors, err := Load(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no vectors")
+	}
+	for _, v := range vectors {
+		if err = Verify(v); err != nil {
+			t.Error(err)
+		}
+		r, err := Compute(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, h := range r.LockHashes {
+			proof, err := mtree.BytesToProof(r.Proofs[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !mtree.VerifyProof(r.MerkleRoot, proof, h) {
+				t.Errorf("vector %s: proof of lock %d doesn't verify", v.Name, i)
+			}
+		}
+	}
+}
+
+//TestTwoLocksByHand computes the two locks vector without mtree and encoding, the way the contract does
+func TestTwoLocksByHand(t *testing.T) {
+	vectors, err := Load(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v *Vector
+	for _, x := range vectors {
+		if x.Name == "two locks" {
+			v = x
+		}
+	}
+	if v == nil {
+		t.Fatal("vector two locks not found")
+	}
+	var hashes [][]byte
+	for _, l := range v.Locks {
+		hashes = append(hashes, crypto.Keccak256(utils.BigIntTo32Bytes(big.NewInt(l.Expiration)), utils.BigIntTo32Bytes(l.Amount), l.SecretHash().Bytes()))
+	}
+	first, second := hashes[0], hashes[1]
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+	root := crypto.Keccak256(first, second)
+	if !bytes.Equal(root, v.Expected.MerkleRoot[:]) {
+		t.Errorf("merkle root expect %x, got %s", root, v.Expected.MerkleRoot.String())
+	}
+	balanceHash := crypto.Keccak256(root, utils.BigIntTo32Bytes(v.BalanceProof.TransferredAmount))[:24]
+	if !bytes.Equal(balanceHash, v.Expected.BalanceHash) {
+		t.Errorf("balance hash expect %x, got %s", balanceHash, v.Expected.BalanceHash)
+	}
+	if !bytes.Equal(crypto.Keccak256(v.Expected.BalanceProofPacked), v.Expected.BalanceProofHash[:]) {
+		t.Error("balance proof hash is not keccak256 of packed balance proof")
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	bp := &BalanceProof{TransferredAmount: big.NewInt(1), ChainID: big.NewInt(1)}
+	l := &LockInput{Expiration: 1, Amount: big.NewInt(1), Secret: utils.Sha3([]byte("s"))}
+	if _, err := Compute(&Vector{Locks: []*LockInput{l, l}, BalanceProof: bp}); err == nil {
+		t.Error("repeated lock should fail")
+	}
+	if _, err := Compute(&Vector{Locks: []*LockInput{{Expiration: 1}}, BalanceProof: bp}); err == nil {
+		t.Error("lock without amount should fail")
+	}
+	if _, err := Compute(&Vector{Locks: []*LockInput{l}}); err == nil {
+		t.Error("vector without balance proof should fail")
+	}
+	v := &Vector{Locks: []*LockInput{l}, BalanceProof: bp}
+	r, err := Compute(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Expected = r
+	bp.Nonce++
+	if Verify(v) == nil {
+		t.Error("changed nonce should mismatch")
+	}
+}
@@ -1,6 +1,7 @@
 package contracts
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/SmartMeshFoundation/Photon/utils"
@@ -24,3 +25,11 @@ func (c *ChannelUniqueID) String() string {
 const ChannelStateOpened = 1
 const ChannelStateClosed = 2
 const ChannelStateSettledOrNotExist = 0
+
+//CalcChannelID channel identifier of p1 and p2 in tokensNetwork, 注意与合约上计算方式保持完全一致.
+func CalcChannelID(token, tokensNetwork, p1, p2 common.Address) common.Hash {
+	if bytes.Compare(p1[:], p2[:]) < 0 {
+		return utils.Sha3(p1[:], p2[:], token[:], tokensNetwork[:])
+	}
+	return utils.Sha3(p2[:], p1[:], token[:], tokensNetwork[:])
+}
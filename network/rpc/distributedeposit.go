@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var errInvalidWeights = errors.New("weights must not be empty and sum of weights must be positive")
+
+//PartnerWeight share of a partner in DistributeDeposit
+type PartnerWeight struct {
+	Partner       common.Address
+	Weight        uint64
+	SettleTimeout uint64 //only used when channel with partner doesn't exist yet
+}
+
+//DepositClient is the part of eth client needed by DistributeDeposit, SafeEthClient implements it.
+type DepositClient interface {
+	helper.ReceiptClient
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+//Depositor is the part of token network needed by DistributeDeposit, contracts.TokensNetwork implements it.
+type Depositor interface {
+	Deposit(opts *bind.TransactOpts, token common.Address, participant common.Address, partner common.Address, amount *big.Int, settleTimeout uint64) (*types.Transaction, error)
+}
+
+/*
+SplitDeposit 按权重把 total 分给每个 partner, 分配结果之和严格等于 total.
+先按比例向下取整, 余下的按最大余数法每人补 1, 余数相同时排在前面的优先, 所以相同输入的结果总是相同的.
+*/
+/*
+ *	SplitDeposit : split total among partners in proportion to weights, amounts sum to total exactly.
+ *	Every share is rounded down first, the remainder goes one by one to partners with the largest fractional parts,
+ *	ties are broken by position in partners, so the same input always gets the same result.
+ */
+func SplitDeposit(total *big.Int, partners []PartnerWeight) (amounts []*big.Int, err error) {
+	if total == nil || total.Sign() < 0 {
+		return nil, errors.New("invalid total")
+	}
+	sum := new(big.Int)
+	for _, p := range partners {
+		sum.Add(sum, new(big.Int).SetUint64(p.Weight))
+	}
+	if sum.Sign() == 0 {
+		return nil, errInvalidWeights
+	}
+	amounts = make([]*big.Int, len(partners))
+	remainders := make([]*big.Int, len(partners))
+	left := new(big.Int).Set(total)
+	for i, p := range partners {
+		n := new(big.Int).Mul(total, new(big.Int).SetUint64(p.Weight))
+		amounts[i], remainders[i] = n.QuoRem(n, sum, new(big.Int))
+		left.Sub(left, amounts[i])
+	}
+	//left < len(partners), because every share loses less than 1
+	order := make([]int, len(partners))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	one := big.NewInt(1)
+	for i := 0; left.Sign() > 0; i++ {
+		amounts[order[i]].Add(amounts[order[i]], one)
+		left.Sub(left, one)
+	}
+	return
+}
+
+/*
+DistributeDeposit 按权重把 total 存入和 partners 之间的通道, 通道不存在时会创建.
+所有 Deposit 交易使用连续的 nonce 一次发出, 然后等待全部打包, 不需要一个一个等.
+调用者需要事先 approve tokenNetwork 至少 total 的 token.
+分到 0 的 partner 不发交易. 某个交易发送失败时不再发送后面的, 已经发出的仍然等待打包.
+*/
+/*
+ *	DistributeDeposit : deposit total into channels with partners in proportion to weights, channels are created if not exist.
+ *	All Deposit txs are sent at once with consecutive nonces, then waited together, no need to wait one by one.
+ *	Caller should have approved at least total of token to tokenNetwork.
+ *	No tx is sent for partners whose share is 0. If sending a tx fails, the rest are not sent, txs already sent are still waited.
+ */
+func DistributeDeposit(auth *bind.TransactOpts, client DepositClient, tokenNetwork Depositor, token common.Address, total *big.Int, partners []PartnerWeight) (amounts []*big.Int, err error) {
+	amounts, err = SplitDeposit(total, partners)
+	if err != nil {
+		return
+	}
+	nonce, err := client.PendingNonceAt(GetQueryConext(), auth.From)
+	if err != nil {
+		return
+	}
+	var txs []*types.Transaction
+	for i, p := range partners {
+		if amounts[i].Sign() == 0 {
+			continue
+		}
+		opts := *auth
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+		var tx *types.Transaction
+		tx, err = tokenNetwork.Deposit(&opts, token, auth.From, p.Partner, amounts[i], p.SettleTimeout)
+		if err != nil {
+			err = fmt.Errorf("deposit %s to channel with %s err %s", amounts[i], utils.APex2(p.Partner), err)
+			break
+		}
+		log.Info(fmt.Sprintf("DistributeDeposit %s to channel with %s, nonce=%d txhash=%s", amounts[i], utils.APex2(p.Partner), nonce, tx.Hash().String()))
+		txs = append(txs, tx)
+		nonce++
+	}
+	for _, tx := range txs {
+		receipt, err2 := helper.WaitMined(GetCallContext(), client, tx, params.ReceiptPollInterval, true)
+		if err2 == nil && receipt.Status != types.ReceiptStatusSuccessful {
+			err2 = fmt.Errorf("deposit tx %s execution failed", tx.Hash().String())
+		}
+		if err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+var _ DepositClient = &helper.SafeEthClient{}
+var _ Depositor = &contracts.TokensNetwork{}
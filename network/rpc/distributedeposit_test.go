@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func sumAmounts(amounts []*big.Int) *big.Int {
+	sum := new(big.Int)
+	for _, a := range amounts {
+		sum.Add(sum, a)
+	}
+	return sum
+}
+
+func TestSplitDeposit(t *testing.T) {
+	weights := func(ws ...uint64) (ps []PartnerWeight) {
+		for _, w := range ws {
+			ps = append(ps, PartnerWeight{Partner: utils.NewRandomAddress(), Weight: w})
+		}
+		return
+	}
+	cases := []struct {
+		total   int64
+		weights []uint64
+		amounts []int64
+	}{
+		{100, []uint64{1, 1, 2}, []int64{25, 25, 50}},
+		// remainders 1/3 each, ties go to partners in front
+		{100, []uint64{1, 1, 1}, []int64{34, 33, 33}},
+		{2, []uint64{1, 1, 1}, []int64{1, 1, 0}},
+		// largest remainder first: 10*3/7=4.28, 10*2/7=2.85, 10*2/7=2.85
+		{10, []uint64{3, 2, 2}, []int64{4, 3, 3}},
+		{7, []uint64{0, 5, 0}, []int64{0, 7, 0}},
+		{0, []uint64{1, 2}, []int64{0, 0}},
+	}
+	for _, c := range cases {
+		ps := weights(c.weights...)
+		amounts, err := SplitDeposit(big.NewInt(c.total), ps)
+		assert.Nil(t, err)
+		assert.Equal(t, len(c.amounts), len(amounts))
+		for i := range c.amounts {
+			assert.EqualValues(t, c.amounts[i], amounts[i].Int64(), "total=%d,weights=%v", c.total, c.weights)
+		}
+		assert.EqualValues(t, c.total, sumAmounts(amounts).Int64())
+		// stable
+		amounts2, err := SplitDeposit(big.NewInt(c.total), ps)
+		assert.Nil(t, err)
+		assert.EqualValues(t, amounts, amounts2)
+	}
+	// big amounts sum exactly too
+	total, _ := new(big.Int).SetString("1000000000000000000000000000007", 10)
+	amounts, err := SplitDeposit(total, weights(3, 7, 11, 13, 17, 19, 1<<63))
+	assert.Nil(t, err)
+	assert.EqualValues(t, total, sumAmounts(amounts))
+
+	_, err = SplitDeposit(big.NewInt(10), nil)
+	assert.NotNil(t, err)
+	_, err = SplitDeposit(big.NewInt(10), weights(0, 0))
+	assert.NotNil(t, err)
+	_, err = SplitDeposit(big.NewInt(-1), weights(1))
+	assert.NotNil(t, err)
+}
+
+type fakeDepositChain struct {
+	nonce    uint64
+	failAt   int
+	deposits []*big.Int
+	nonces   []uint64
+	partners []common.Address
+}
+
+func (c *fakeDepositChain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return c.nonce, nil
+}
+
+func (c *fakeDepositChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	return &types.Receipt{TxHash: txHash, Status: types.ReceiptStatusSuccessful}, nil
+}
+
+func (c *fakeDepositChain) Deposit(opts *bind.TransactOpts, token common.Address, participant common.Address, partner common.Address, amount *big.Int, settleTimeout uint64) (*types.Transaction, error) {
+	if len(c.deposits) == c.failAt {
+		return nil, errors.New("nonce too low")
+	}
+	c.deposits = append(c.deposits, amount)
+	c.nonces = append(c.nonces, opts.Nonce.Uint64())
+	c.partners = append(c.partners, partner)
+	return types.NewTransaction(opts.Nonce.Uint64(), partner, amount, 0, big.NewInt(0), nil), nil
+}
+
+func TestDistributeDeposit(t *testing.T) {
+	auth := &bind.TransactOpts{From: utils.NewRandomAddress()}
+	ps := []PartnerWeight{
+		{Partner: utils.NewRandomAddress(), Weight: 1},
+		{Partner: utils.NewRandomAddress(), Weight: 0},
+		{Partner: utils.NewRandomAddress(), Weight: 2},
+	}
+	c := &fakeDepositChain{nonce: 5, failAt: -1}
+	amounts, err := DistributeDeposit(auth, c, c, utils.NewRandomAddress(), big.NewInt(10), ps)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{3, 0, 7}, []int64{amounts[0].Int64(), amounts[1].Int64(), amounts[2].Int64()})
+	// no tx for zero share, consecutive nonces
+	assert.EqualValues(t, []uint64{5, 6}, c.nonces)
+	assert.EqualValues(t, []common.Address{ps[0].Partner, ps[2].Partner}, c.partners)
+	assert.Nil(t, auth.Nonce)
+
+	c = &fakeDepositChain{nonce: 5, failAt: 1}
+	_, err = DistributeDeposit(auth, c, c, utils.NewRandomAddress(), big.NewInt(10), ps)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, []uint64{5}, c.nonces)
+}
@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"math/big"
+	"strings"
 
 	"context"
 
@@ -45,3 +46,92 @@ func ensureContext(ctx context.Context) context.Context {
 	}
 	return ctx
 }
+
+//DefaultLogPageSize is the largest block range FilterLogsPaged asks for in one query
+const DefaultLogPageSize uint64 = 100000
+
+/*
+logPageGrowAfter is how many successful queries FilterLogsPaged makes with a shrunk range before it tries a larger one again,
+so a dense block range does not fail every other query.
+*/
+const logPageGrowAfter = 8
+
+//logLimitErrors are the messages eth nodes use to refuse a query with too many results, all lower case
+var logLimitErrors = []string{
+	"query returned more than",   //geth, parity, infura: "query returned more than 10000 results"
+	"log limit exceeded",         //infura
+	"log response size exceeded", //alchemy
+	"exceed maximum block range", //bsc: "exceed maximum block range: 5000"
+	"block range is too wide",    //ankr
+}
+
+//LogFilterer is the part of eth client needed by FilterLogsPaged, SafeEthClient implements it.
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+/*
+isLogLimitExceeded returns true when eth node refuses a query because it would return too many logs,
+ for example geth/parity "query returned more than 10000 results" or infura "log limit exceeded".
+*/
+func isLogLimitExceeded(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, e := range logLimitErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+FilterLogsPaged query logs of `q` between fromBlock and toBlock (both included) page by page.
+The page size is adaptive: it starts with pageSize blocks, when eth node complains about too many results,
+the range is halved and the same sub-range is retried; after logPageGrowAfter successful queries in a row,
+the range grows back (doubled) until pageSize again. So it tunes itself to the log density of the chain.
+q.FromBlock and q.ToBlock are ignored.
+*/
+func FilterLogsPaged(ctx context.Context, client LogFilterer, q ethereum.FilterQuery, fromBlock, toBlock, pageSize uint64) (logs []types.Log, err error) {
+	if pageSize == 0 {
+		pageSize = DefaultLogPageSize
+	}
+	ctx = ensureContext(ctx)
+	size := pageSize
+	successes := 0
+	start := fromBlock
+	for start <= toBlock {
+		end := start + size - 1
+		if end > toBlock || end < start {
+			end = toBlock
+		}
+		q.FromBlock = new(big.Int).SetUint64(start)
+		q.ToBlock = new(big.Int).SetUint64(end)
+		var page []types.Log
+		page, err = client.FilterLogs(ctx, q)
+		if err != nil {
+			if isLogLimitExceeded(err) && size > 1 {
+				size = size / 2
+				successes = 0
+				continue
+			}
+			return nil, err
+		}
+		logs = append(logs, page...)
+		if end == toBlock {
+			break
+		}
+		start = end + 1
+		successes++
+		if size < pageSize && successes >= logPageGrowAfter {
+			successes = 0
+			size = size * 2
+			if size > pageSize {
+				size = pageSize
+			}
+		}
+	}
+	return logs, nil
+}
@@ -1,6 +1,8 @@
 package rpc
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 
@@ -9,7 +11,10 @@ import (
 	"github.com/SmartMeshFoundation/Photon/codefortest"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -37,3 +42,118 @@ func TestEventsGetInternal(t *testing.T) {
 	}
 	fmt.Printf("events num : %d\n", len(logs))
 }
+
+//limitedLogFilterer returns one log per block, and refuses any query covering more than maxRange blocks
+type limitedLogFilterer struct {
+	maxRange uint64
+	queries  [][2]uint64
+	failed   int
+}
+
+func (f *limitedLogFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	f.queries = append(f.queries, [2]uint64{from, to})
+	if to-from+1 > f.maxRange {
+		f.failed++
+		return nil, fmt.Errorf("query returned more than %d results", f.maxRange)
+	}
+	var logs []types.Log
+	for i := from; i <= to; i++ {
+		logs = append(logs, types.Log{BlockNumber: i})
+	}
+	return logs, nil
+}
+
+func TestFilterLogsPagedAdaptive(t *testing.T) {
+	f := &limitedLogFilterer{maxRange: 30}
+	logs, err := FilterLogsPaged(context.Background(), f, ethereum.FilterQuery{}, 1, 1000, 256)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// every block exactly once and in order
+	assert.EqualValues(t, 1000, len(logs))
+	for i, l := range logs {
+		assert.EqualValues(t, uint64(i+1), l.BlockNumber)
+	}
+	// 256 -> 128 -> 64 -> 32 -> 16 fails before first success
+	assert.True(t, f.failed >= 4)
+	for _, q := range f.queries {
+		assert.True(t, q[1]-q[0]+1 <= 256)
+	}
+}
+
+func TestFilterLogsPagedGrowBack(t *testing.T) {
+	f := &limitedLogFilterer{maxRange: 100}
+	_, err := FilterLogsPaged(context.Background(), f, ethereum.FilterQuery{}, 0, 999, 100)
+	assert.Empty(t, err)
+	// never exceeds the limit, so no query should be split
+	assert.EqualValues(t, 0, f.failed)
+	assert.EqualValues(t, 10, len(f.queries))
+	// after a failure the range grows back
+	f = &limitedLogFilterer{maxRange: 60}
+	_, err = FilterLogsPaged(context.Background(), f, ethereum.FilterQuery{}, 0, 999, 100)
+	assert.Empty(t, err)
+	grown := false
+	for i := 1; i < len(f.queries); i++ {
+		prev, cur := f.queries[i-1], f.queries[i]
+		if cur[1]-cur[0] > prev[1]-prev[0] {
+			grown = true
+		}
+	}
+	assert.True(t, grown)
+}
+
+func TestFilterLogsPagedHoldShrunkSize(t *testing.T) {
+	f := &limitedLogFilterer{maxRange: 60}
+	_, err := FilterLogsPaged(context.Background(), f, ethereum.FilterQuery{}, 0, 9999, 100)
+	assert.Empty(t, err)
+	// 100 fails, 50 succeeds, then it must stay at 50 for logPageGrowAfter queries before trying 100 again
+	var sizes []uint64
+	for _, q := range f.queries {
+		sizes = append(sizes, q[1]-q[0]+1)
+	}
+	for i := 0; i+1 < len(sizes); i++ {
+		if sizes[i] == 100 && sizes[i+1] == 50 {
+			for j := 1; j <= logPageGrowAfter && i+j < len(sizes); j++ {
+				assert.EqualValues(t, 50, sizes[i+j], "query %d", i+j)
+			}
+		}
+	}
+	// every failure costs one extra query, so failures must be rare
+	assert.True(t, f.failed <= len(f.queries)/logPageGrowAfter+1, "failed=%d queries=%d", f.failed, len(f.queries))
+}
+
+func TestIsLogLimitExceeded(t *testing.T) {
+	cases := []struct {
+		msg    string
+		expect bool
+	}{
+		{"query returned more than 10000 results", true},
+		{"Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range", true},
+		{"exceed maximum block range: 5000", true},
+		{"log limit exceeded", true},
+		{"block range is too wide", true},
+		{"connection refused", false},
+		{"gas limit exceeded", false},
+		{"more than one filter", false},
+	}
+	for _, c := range cases {
+		assert.EqualValues(t, c.expect, isLogLimitExceeded(errors.New(c.msg)), c.msg)
+	}
+	assert.False(t, isLogLimitExceeded(nil))
+}
+
+func TestFilterLogsPagedOtherError(t *testing.T) {
+	errOther := errors.New("connection refused")
+	_, err := FilterLogsPaged(context.Background(), errLogFilterer{errOther}, ethereum.FilterQuery{}, 0, 10, 5)
+	assert.EqualValues(t, errOther, err)
+}
+
+type errLogFilterer struct {
+	err error
+}
+
+func (f errLogFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return nil, f.err
+}
@@ -0,0 +1,154 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var tokensNetworkAbi abi.ABI
+
+func init() {
+	var err error
+	tokensNetworkAbi, err = abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	if err != nil {
+		panic(fmt.Sprintf("tokensNetworkAbi parse err %s", err))
+	}
+}
+
+//ChannelRecord is a channel found in history logs of token network
+type ChannelRecord struct {
+	TokenAddress      common.Address
+	ChannelIdentifier common.Hash
+	Participant1      common.Address
+	Participant2      common.Address
+	SettleTimeout     uint64
+	OpenBlockNumber   uint64 //block number of the latest open or withdraw, balance proofs must use it
+	OpenTimes         int    //how many times this channel has been opened
+	State             uint8  //latest state, contracts.ChannelStateOpened,ChannelStateClosed or ChannelStateSettledOrNotExist
+	StateBlockNumber  uint64 //block number of the event which changes channel to State
+}
+
+//ChannelLogFilterer is the part of eth client needed by ListChannels, SafeEthClient implements it.
+type ChannelLogFilterer interface {
+	LogFilterer
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+/*
+ListChannels 扫描 token network 从 fromBlock 开始的历史事件, 列出所有曾经打开过的通道.
+同一对参与方关闭以后再次打开的通道 ChannelIdentifier 相同, 只保留一条记录, 状态为最新的状态.
+withdraw 以后通道的 OpenBlockNumber 变为 withdraw 事件所在的块.
+*/
+/*
+ *	ListChannels : scan history logs of token network from fromBlock, list every channel ever opened.
+ *	A channel reopened by the same participants has the same identifier, only one record is kept
+ *	with its latest state. After a withdraw, OpenBlockNumber is the block of the withdraw event.
+ */
+func ListChannels(ctx context.Context, client ChannelLogFilterer, tokenNetwork common.Address, fromBlock uint64) (records []ChannelRecord, err error) {
+	ctx = ensureContext(ctx)
+	h, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	q := ethereum.FilterQuery{
+		Addresses: []common.Address{tokenNetwork},
+		Topics: [][]common.Hash{{
+			tokensNetworkAbi.Events[params.NameChannelOpenedAndDeposit].Id(),
+			tokensNetworkAbi.Events[params.NameChannelWithdraw].Id(),
+			tokensNetworkAbi.Events[params.NameChannelClosed].Id(),
+			tokensNetworkAbi.Events[params.NameChannelSettled].Id(),
+			tokensNetworkAbi.Events[params.NameChannelCooperativeSettled].Id(),
+		}},
+	}
+	logs, err := FilterLogsPaged(ctx, client, q, fromBlock, h.Number.Uint64(), DefaultLogPageSize)
+	if err != nil {
+		return
+	}
+	return channelRecordsFromLogs(logs)
+}
+
+//channelRecordsFromLogs logs must be in order of block number, as eth node returns
+func channelRecordsFromLogs(logs []types.Log) (records []ChannelRecord, err error) {
+	m := make(map[common.Hash]int) //channel identifier -> index of records
+	for i := range logs {
+		l := &logs[i]
+		if len(l.Topics) < 2 {
+			continue
+		}
+		switch l.Topics[0] {
+		case tokensNetworkAbi.Events[params.NameChannelOpenedAndDeposit].Id():
+			var r ChannelRecord
+			r, err = decodeChannelOpened(l)
+			if err != nil {
+				return nil, err
+			}
+			if idx, ok := m[r.ChannelIdentifier]; ok {
+				r.OpenTimes = records[idx].OpenTimes + 1
+				records[idx] = r
+			} else {
+				m[r.ChannelIdentifier] = len(records)
+				records = append(records, r)
+			}
+		case tokensNetworkAbi.Events[params.NameChannelWithdraw].Id():
+			//withdraw reopens the channel at the block of this event, the same as HandleWithdrawed does
+			if idx, ok := m[l.Topics[1]]; ok {
+				records[idx].OpenBlockNumber = l.BlockNumber
+				records[idx].State = contracts.ChannelStateOpened
+				records[idx].StateBlockNumber = l.BlockNumber
+			}
+		case tokensNetworkAbi.Events[params.NameChannelClosed].Id():
+			if idx, ok := m[l.Topics[1]]; ok {
+				records[idx].State = contracts.ChannelStateClosed
+				records[idx].StateBlockNumber = l.BlockNumber
+			}
+		case tokensNetworkAbi.Events[params.NameChannelSettled].Id(),
+			tokensNetworkAbi.Events[params.NameChannelCooperativeSettled].Id():
+			if idx, ok := m[l.Topics[1]]; ok {
+				records[idx].State = contracts.ChannelStateSettledOrNotExist
+				records[idx].StateBlockNumber = l.BlockNumber
+			}
+		}
+	}
+	return
+}
+
+//decodeChannelOpened event ChannelOpenedAndDeposit(address indexed token, address participant, address partner, uint64 settle_timeout, uint256 participant1_deposit)
+func decodeChannelOpened(l *types.Log) (r ChannelRecord, err error) {
+	vs, err := tokensNetworkAbi.Events[params.NameChannelOpenedAndDeposit].Inputs.UnpackValues(l.Data)
+	if err != nil {
+		return
+	}
+	if len(vs) != 4 {
+		err = fmt.Errorf("ChannelOpenedAndDeposit tx=%s has %d values", l.TxHash.String(), len(vs))
+		return
+	}
+	participant, ok1 := vs[0].(common.Address)
+	partner, ok2 := vs[1].(common.Address)
+	settleTimeout, ok3 := vs[2].(uint64)
+	if !ok1 || !ok2 || !ok3 {
+		err = fmt.Errorf("ChannelOpenedAndDeposit tx=%s decode error", l.TxHash.String())
+		return
+	}
+	token := common.BytesToAddress(l.Topics[1][:])
+	r = ChannelRecord{
+		TokenAddress:      token,
+		ChannelIdentifier: contracts.CalcChannelID(token, l.Address, participant, partner),
+		Participant1:      participant,
+		Participant2:      partner,
+		SettleTimeout:     settleTimeout,
+		OpenBlockNumber:   l.BlockNumber,
+		OpenTimes:         1,
+		State:             contracts.ChannelStateOpened,
+		StateBlockNumber:  l.BlockNumber,
+	}
+	return
+}
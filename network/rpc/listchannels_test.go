@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeChannelLogFilterer returns logs in the queried range
+type fakeChannelLogFilterer struct {
+	logs   []types.Log
+	header uint64
+}
+
+func (f *fakeChannelLogFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	for _, l := range f.logs {
+		if l.BlockNumber >= q.FromBlock.Uint64() && l.BlockNumber <= q.ToBlock.Uint64() {
+			logs = append(logs, l)
+		}
+	}
+	return
+}
+
+func (f *fakeChannelLogFilterer) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: new(big.Int).SetUint64(f.header)}, nil
+}
+
+func makeChannelOpenedLog(t *testing.T, tokenNetwork, token, p1, p2 common.Address, settleTimeout uint64, blockNumber uint64) types.Log {
+	ev := tokensNetworkAbi.Events[params.NameChannelOpenedAndDeposit]
+	data, err := ev.Inputs.NonIndexed().Pack(p1, p2, settleTimeout, big.NewInt(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.Log{
+		Address:     tokenNetwork,
+		Topics:      []common.Hash{ev.Id(), common.BytesToHash(token[:])},
+		Data:        data,
+		BlockNumber: blockNumber,
+	}
+}
+
+func makeChannelEventLog(tokenNetwork common.Address, name string, channelIdentifier common.Hash, blockNumber uint64) types.Log {
+	return types.Log{
+		Address:     tokenNetwork,
+		Topics:      []common.Hash{tokensNetworkAbi.Events[name].Id(), channelIdentifier},
+		BlockNumber: blockNumber,
+	}
+}
+
+func TestListChannels(t *testing.T) {
+	tokenNetwork := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	a1, a2, a3 := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch12 := contracts.CalcChannelID(token, tokenNetwork, a1, a2)
+	ch13 := contracts.CalcChannelID(token, tokenNetwork, a1, a3)
+	ch23 := contracts.CalcChannelID(token, tokenNetwork, a2, a3)
+	f := &fakeChannelLogFilterer{
+		header: 1000,
+		logs: []types.Log{
+			makeChannelOpenedLog(t, tokenNetwork, token, a1, a2, 100, 10),
+			makeChannelOpenedLog(t, tokenNetwork, token, a3, a1, 200, 20),
+			makeChannelEventLog(tokenNetwork, params.NameChannelClosed, ch12, 30),
+			makeChannelEventLog(tokenNetwork, params.NameChannelSettled, ch12, 140),
+			// a1,a2 reopen
+			makeChannelOpenedLog(t, tokenNetwork, token, a2, a1, 300, 150),
+			makeChannelOpenedLog(t, tokenNetwork, token, a2, a3, 100, 160),
+			makeChannelEventLog(tokenNetwork, params.NameChannelCooperativeSettled, ch23, 170),
+			makeChannelEventLog(tokenNetwork, params.NameChannelWithdraw, ch13, 175),
+			makeChannelEventLog(tokenNetwork, params.NameChannelClosed, ch13, 180),
+			// unknown channel is ignored
+			makeChannelEventLog(tokenNetwork, params.NameChannelClosed, utils.NewRandomHash(), 190),
+		},
+	}
+	records, err := ListChannels(context.Background(), f, tokenNetwork, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.EqualValues(t, 3, len(records)) {
+		return
+	}
+	r := records[0]
+	assert.EqualValues(t, ch12, r.ChannelIdentifier)
+	assert.EqualValues(t, token, r.TokenAddress)
+	assert.EqualValues(t, a2, r.Participant1)
+	assert.EqualValues(t, a1, r.Participant2)
+	assert.EqualValues(t, 300, r.SettleTimeout)
+	assert.EqualValues(t, 150, r.OpenBlockNumber)
+	assert.EqualValues(t, 2, r.OpenTimes)
+	assert.EqualValues(t, contracts.ChannelStateOpened, r.State)
+
+	r = records[1]
+	assert.EqualValues(t, ch13, r.ChannelIdentifier)
+	assert.EqualValues(t, 200, r.SettleTimeout)
+	assert.EqualValues(t, 1, r.OpenTimes)
+	// withdraw moves open block number
+	assert.EqualValues(t, 175, r.OpenBlockNumber)
+	assert.EqualValues(t, contracts.ChannelStateClosed, r.State)
+	assert.EqualValues(t, 180, r.StateBlockNumber)
+
+	r = records[2]
+	assert.EqualValues(t, ch23, r.ChannelIdentifier)
+	assert.EqualValues(t, contracts.ChannelStateSettledOrNotExist, r.State)
+
+	// channels opened before fromBlock are not listed
+	records, err = ListChannels(context.Background(), f, tokenNetwork, 155)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 1, len(records))
+	assert.EqualValues(t, ch23, records[0].ChannelIdentifier)
+}
+
+func TestListChannelsWithdraw(t *testing.T) {
+	tokenNetwork := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	a1, a2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch12 := contracts.CalcChannelID(token, tokenNetwork, a1, a2)
+	f := &fakeChannelLogFilterer{
+		header: 1000,
+		logs: []types.Log{
+			makeChannelOpenedLog(t, tokenNetwork, token, a1, a2, 100, 10),
+			makeChannelEventLog(tokenNetwork, params.NameChannelWithdraw, ch12, 50),
+			makeChannelEventLog(tokenNetwork, params.NameChannelWithdraw, ch12, 80),
+		},
+	}
+	records, err := ListChannels(context.Background(), f, tokenNetwork, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.EqualValues(t, 1, len(records)) {
+		return
+	}
+	r := records[0]
+	assert.EqualValues(t, 80, r.OpenBlockNumber)
+	assert.EqualValues(t, 1, r.OpenTimes)
+	assert.EqualValues(t, contracts.ChannelStateOpened, r.State)
+	assert.EqualValues(t, 80, r.StateBlockNumber)
+}
@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//OpenDepositClient is the part of eth client needed by ChannelOpener, SafeEthClient implements it.
+type OpenDepositClient interface {
+	helper.ReceiptClient
+	ethereum.GasEstimator
+}
+
+//OpenDepositToken is the part of token needed by ChannelOpener, contracts.Token implements it.
+type OpenDepositToken interface {
+	Approve(opts *bind.TransactOpts, _spender common.Address, _value *big.Int) (*types.Transaction, error)
+	ApproveAndCall(opts *bind.TransactOpts, _spender common.Address, _amount *big.Int, _extraData []byte) (*types.Transaction, error)
+}
+
+/*
+ChannelOpener 创建通道并存入押金.
+token 支持 approveAndCall 时, 合约的 receiveApproval 在同一个交易里创建通道并存入押金, 只需要一个交易,
+通道不会出现没有押金的状态. 否则需要 approve 和 deposit 两个交易, approve 发出以后紧接着用下一个 nonce 发出 deposit,
+然后一起等待打包, 而不是等 approve 打包以后再发 deposit.
+*/
+/*
+ *	ChannelOpener : opens a channel with deposit.
+ *	If token supports approveAndCall, receiveApproval of the contract opens the channel and deposits in the same tx,
+ *	only one tx is needed and the channel never exists without deposit. Otherwise approve and deposit are needed,
+ *	deposit is sent right after approve with the next nonce and both are waited together, instead of sending deposit after approve is mined.
+ */
+type ChannelOpener struct {
+	Client              OpenDepositClient
+	Token               OpenDepositToken
+	TokenAddress        common.Address
+	TokenNetwork        Depositor
+	TokenNetworkAddress common.Address
+}
+
+var tokenABI abi.ABI
+
+func init() {
+	var err error
+	tokenABI, err = abi.JSON(strings.NewReader(contracts.TokenABI))
+	if err != nil {
+		panic(err)
+	}
+}
+
+/*
+SupportApproveAndCall 用 eth_estimateGas 检查 from 能否通过 approveAndCall 一个交易创建通道并存入 amount,
+token 没有 approveAndCall, 或者余额不足等原因会导致交易 revert 时返回 false.
+*/
+/*
+ *	SupportApproveAndCall : checks by eth_estimateGas whether from can open the channel and deposit amount by approveAndCall in one tx,
+ *	false if token has no approveAndCall, or the tx would revert for any other reason such as not enough balance.
+ */
+func (o *ChannelOpener) SupportApproveAndCall(from, partner common.Address, settleTimeout uint64, amount *big.Int) bool {
+	data, err := tokenABI.Pack("approveAndCall", o.TokenNetworkAddress, amount, makeNewChannelAndDepositData(from, partner, int(settleTimeout)))
+	if err != nil {
+		log.Error(fmt.Sprintf("pack approveAndCall err %s", err))
+		return false
+	}
+	_, err = o.Client.EstimateGas(GetQueryConext(), ethereum.CallMsg{
+		From: from,
+		To:   &o.TokenAddress,
+		Data: data,
+	})
+	return err == nil
+}
+
+/*
+OpenChannelWithDeposit 创建和 partner 的通道并存入 amount, 通道已经存在时只存入押金, 两个交易都打包以后才返回.
+singleTx 表示是否使用了 approveAndCall 一个交易完成.
+*/
+/*
+ *	OpenChannelWithDeposit : open channel with partner and deposit amount, only deposit if channel exists,
+ *	returns after all txs are mined. singleTx tells whether it's done in one approveAndCall tx.
+ */
+func (o *ChannelOpener) OpenChannelWithDeposit(auth *bind.TransactOpts, partner common.Address, settleTimeout uint64, amount *big.Int) (singleTx bool, err error) {
+	if o.SupportApproveAndCall(auth.From, partner, settleTimeout, amount) {
+		return true, o.approveAndCall(auth, partner, settleTimeout, amount)
+	}
+	return false, o.approveAndDeposit(auth, partner, settleTimeout, amount)
+}
+
+func (o *ChannelOpener) approveAndCall(auth *bind.TransactOpts, partner common.Address, settleTimeout uint64, amount *big.Int) error {
+	data := makeNewChannelAndDepositData(auth.From, partner, int(settleTimeout))
+	tx, err := o.Token.ApproveAndCall(auth, o.TokenNetworkAddress, amount, data)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("OpenChannelWithDeposit by approveAndCall partner=%s,amount=%s,txhash=%s", utils.APex2(partner), amount, tx.Hash().String()))
+	return o.waitMined("approveAndCall", tx)
+}
+
+/*
+approveAndDeposit approve 以后不等打包就发出 deposit, 两个交易的 nonce 由 bind 连续分配, 然后一起等待打包.
+deposit 发出时 approve 还没有打包, 这时估算 gas 一定会失败, 所以使用固定的 gas limit.
+*/
+/*
+ *	approveAndDeposit : deposit is sent right after approve without waiting for it to be mined,
+ *	bind gives them consecutive nonces, then both are waited together.
+ *	Approve is not mined when deposit is sent, estimating gas of deposit would fail, so a fixed gas limit is used.
+ */
+func (o *ChannelOpener) approveAndDeposit(auth *bind.TransactOpts, partner common.Address, settleTimeout uint64, amount *big.Int) (err error) {
+	approveTx, err := o.Token.Approve(auth, o.TokenNetworkAddress, amount)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("OpenChannelWithDeposit approve amount=%s, txhash=%s", amount, approveTx.Hash().String()))
+	opts := *auth
+	if opts.GasLimit == 0 {
+		opts.GasLimit = params.DefaultGasLimit
+	}
+	depositTx, err := o.TokenNetwork.Deposit(&opts, o.TokenAddress, auth.From, partner, amount, settleTimeout)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("OpenChannelWithDeposit deposit partner=%s,amount=%s, txhash=%s", utils.APex2(partner), amount, depositTx.Hash().String()))
+	err = o.waitMined("approve", approveTx)
+	err2 := o.waitMined("deposit", depositTx)
+	if err == nil {
+		err = err2
+	}
+	return
+}
+
+func (o *ChannelOpener) waitMined(name string, tx *types.Transaction) error {
+	receipt, err := helper.WaitMined(GetCallContext(), o.Client, tx, params.ReceiptPollInterval, true)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("%s tx %s execution failed", name, tx.Hash().String())
+	}
+	return nil
+}
+
+var _ OpenDepositClient = &helper.SafeEthClient{}
+var _ OpenDepositToken = &contracts.Token{}
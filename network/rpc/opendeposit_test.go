@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOpenDepositChain struct {
+	fakeDepositChain
+	approveAndCall bool
+	sent           []string
+	gasLimits      []uint64
+}
+
+func (c *fakeOpenDepositChain) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	if !c.approveAndCall {
+		return 0, errors.New("gas required exceeds allowance or always failing transaction")
+	}
+	return 100000, nil
+}
+
+func (c *fakeOpenDepositChain) Approve(opts *bind.TransactOpts, _spender common.Address, _value *big.Int) (*types.Transaction, error) {
+	c.sent = append(c.sent, "approve")
+	c.gasLimits = append(c.gasLimits, opts.GasLimit)
+	c.nonce++
+	return types.NewTransaction(c.nonce, _spender, _value, 0, big.NewInt(0), nil), nil
+}
+
+func (c *fakeOpenDepositChain) ApproveAndCall(opts *bind.TransactOpts, _spender common.Address, _amount *big.Int, _extraData []byte) (*types.Transaction, error) {
+	c.sent = append(c.sent, "approveAndCall")
+	return types.NewTransaction(0, _spender, _amount, 0, big.NewInt(0), _extraData), nil
+}
+
+func (c *fakeOpenDepositChain) Deposit(opts *bind.TransactOpts, token common.Address, participant common.Address, partner common.Address, amount *big.Int, settleTimeout uint64) (*types.Transaction, error) {
+	c.sent = append(c.sent, "deposit")
+	c.gasLimits = append(c.gasLimits, opts.GasLimit)
+	if opts.Nonce != nil {
+		return nil, errors.New("nonce should be managed by bind")
+	}
+	if len(c.partners) == c.failAt {
+		return nil, errors.New("insufficient funds for gas * price + value")
+	}
+	c.partners = append(c.partners, partner)
+	c.nonce++
+	return types.NewTransaction(c.nonce, partner, amount, 0, big.NewInt(0), nil), nil
+}
+
+func TestChannelOpener(t *testing.T) {
+	auth := &bind.TransactOpts{From: utils.NewRandomAddress()}
+	partner := utils.NewRandomAddress()
+	newOpener := func(c *fakeOpenDepositChain) *ChannelOpener {
+		return &ChannelOpener{
+			Client:              c,
+			Token:               c,
+			TokenAddress:        utils.NewRandomAddress(),
+			TokenNetwork:        c,
+			TokenNetworkAddress: utils.NewRandomAddress(),
+		}
+	}
+	// token supports approveAndCall, one tx
+	c := &fakeOpenDepositChain{fakeDepositChain: fakeDepositChain{nonce: 5, failAt: -1}, approveAndCall: true}
+	singleTx, err := newOpener(c).OpenChannelWithDeposit(auth, partner, 100, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.True(t, singleTx)
+	assert.EqualValues(t, []string{"approveAndCall"}, c.sent)
+
+	// deposit right after approve, deposit doesn't estimate gas
+	c = &fakeOpenDepositChain{fakeDepositChain: fakeDepositChain{nonce: 5, failAt: -1}}
+	singleTx, err = newOpener(c).OpenChannelWithDeposit(auth, partner, 100, big.NewInt(10))
+	assert.Nil(t, err)
+	assert.False(t, singleTx)
+	assert.EqualValues(t, []string{"approve", "deposit"}, c.sent)
+	assert.EqualValues(t, []uint64{0, params.DefaultGasLimit}, c.gasLimits)
+	assert.EqualValues(t, []common.Address{partner}, c.partners)
+	assert.Nil(t, auth.Nonce)
+	assert.EqualValues(t, 0, auth.GasLimit)
+
+	// deposit fails to send, approve is still sent
+	c = &fakeOpenDepositChain{fakeDepositChain: fakeDepositChain{nonce: 5, failAt: 0}}
+	_, err = newOpener(c).OpenChannelWithDeposit(auth, partner, 100, big.NewInt(10))
+	assert.NotNil(t, err)
+	assert.EqualValues(t, []string{"approve", "deposit"}, c.sent)
+}
@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//SettleParticipant one side of settle, what it transferred to the other side and locks root of its pending locks
+type SettleParticipant struct {
+	Address           common.Address
+	TransferredAmount *big.Int
+	LocksRoot         common.Hash
+}
+
+/*
+CanonicalSettleOrder 按照合约 getChannelIdentifier 的顺序排列通道双方, 即地址小的在前.
+*/
+/*
+ *	CanonicalSettleOrder : order the two participants the way contract getChannelIdentifier does,
+ *	i.e. the smaller address first.
+ */
+func CanonicalSettleOrder(p1, p2 *SettleParticipant) (first, second *SettleParticipant) {
+	if bytes.Compare(p1.Address[:], p2.Address[:]) > 0 {
+		return p2, p1
+	}
+	return p1, p2
+}
+
+var (
+	tokensNetworkABIOnce sync.Once
+	tokensNetworkABI     abi.ABI
+	tokensNetworkABIErr  error
+)
+
+/*
+PackSettle 生成调用合约 settle 的 calldata, 参数按通道双方的规范顺序排列, 调用者不必关心谁是 participant1.
+*/
+/*
+ *	PackSettle : calldata of contract settle, with the two participants in canonical order,
+ *	so callers don't need to care which one is participant1.
+ */
+func PackSettle(token common.Address, p1, p2 *SettleParticipant) ([]byte, error) {
+	if p1 == nil || p2 == nil {
+		return nil, errors.New("settle needs both participants")
+	}
+	if p1.Address == p2.Address {
+		return nil, errors.New("settle participants must be different")
+	}
+	tokensNetworkABIOnce.Do(func() {
+		tokensNetworkABI, tokensNetworkABIErr = abi.JSON(strings.NewReader(contracts.TokensNetworkABI))
+	})
+	if tokensNetworkABIErr != nil {
+		return nil, tokensNetworkABIErr
+	}
+	first, second := CanonicalSettleOrder(p1, p2)
+	amount := func(p *SettleParticipant) *big.Int {
+		if p.TransferredAmount == nil {
+			return big.NewInt(0)
+		}
+		return p.TransferredAmount
+	}
+	return tokensNetworkABI.Pack("settle", token,
+		first.Address, amount(first), first.LocksRoot,
+		second.Address, amount(second), second.LocksRoot)
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackSettleCanonicalOrder(t *testing.T) {
+	token := utils.NewRandomAddress()
+	low := &SettleParticipant{
+		Address:           common.HexToAddress("0x1000000000000000000000000000000000000001"),
+		TransferredAmount: big.NewInt(10),
+		LocksRoot:         utils.NewRandomHash(),
+	}
+	high := &SettleParticipant{
+		Address:           common.HexToAddress("0x2000000000000000000000000000000000000002"),
+		TransferredAmount: big.NewInt(20),
+		LocksRoot:         utils.NewRandomHash(),
+	}
+	data1, err := PackSettle(token, low, high)
+	if !assert.Nil(t, err) {
+		return
+	}
+	data2, err := PackSettle(token, high, low)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, data1, data2)
+
+	selector := crypto.Keccak256([]byte("settle(address,address,uint256,bytes32,address,uint256,bytes32)"))[:4]
+	assert.Equal(t, selector, data1[:4])
+	if !assert.Len(t, data1, 4+7*32) {
+		return
+	}
+	word := func(i int) []byte {
+		return data1[4+i*32 : 4+(i+1)*32]
+	}
+	assert.Equal(t, common.LeftPadBytes(token[:], 32), word(0))
+	assert.Equal(t, common.LeftPadBytes(low.Address[:], 32), word(1))
+	assert.Equal(t, utils.BigIntTo32Bytes(low.TransferredAmount), word(2))
+	assert.Equal(t, low.LocksRoot[:], word(3))
+	assert.Equal(t, common.LeftPadBytes(high.Address[:], 32), word(4))
+	assert.Equal(t, utils.BigIntTo32Bytes(high.TransferredAmount), word(5))
+	assert.Equal(t, high.LocksRoot[:], word(6))
+}
+
+func TestPackSettleInvalid(t *testing.T) {
+	p := &SettleParticipant{Address: utils.NewRandomAddress()}
+	_, err := PackSettle(utils.NewRandomAddress(), p, nil)
+	assert.NotNil(t, err)
+	_, err = PackSettle(utils.NewRandomAddress(), p, &SettleParticipant{Address: p.Address})
+	assert.NotNil(t, err)
+	//no transferred amount means 0
+	data, err := PackSettle(utils.NewRandomAddress(), p, &SettleParticipant{Address: utils.NewRandomAddress()})
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(make([]byte, 32), data[4+2*32:4+3*32]))
+}
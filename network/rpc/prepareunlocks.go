@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//UnlockData everything needed to call unlock of a lock on chain
+type UnlockData struct {
+	Lock          *mtree.Lock
+	Secret        common.Hash
+	MerkleProof   []byte //proof of Lock in the tree, encoded by mtree.Proof2Bytes
+	RegisterBlock int64  //block number at which the secret was registered
+}
+
+//SecretRegistrar is the part of secret registry needed by PrepareUnlocks, contracts.SecretRegistry implements it.
+type SecretRegistrar interface {
+	GetSecretRevealBlockHeight(opts *bind.CallOpts, secrethash [32]byte) (*big.Int, error)
+	RegisterSecret(opts *bind.TransactOpts, secret [32]byte) (*types.Transaction, error)
+}
+
+/*
+PrepareUnlocks 为 tree 中所有知道密码的锁准备链上 unlock 需要的数据.
+还没有在链上注册的密码, 使用连续的 nonce 一次发出所有注册交易, 然后一起等待打包.
+返回结果和 tree.Leaves 顺序相同, 不知道密码的锁以及注册块在锁过期之后的锁不返回, 因为它们无法 unlock.
+*/
+/*
+ *	PrepareUnlocks : prepare data needed by unlock on chain for every lock in tree whose secret is known.
+ *	Secrets not registered on chain yet are registered by txs sent at once with consecutive nonces, then waited together.
+ *	Result is in the same order as tree.Leaves, locks without secret or whose secret is registered after expiration
+ *	are left out, since they cannot be unlocked.
+ */
+func PrepareUnlocks(auth *bind.TransactOpts, client DepositClient, secretRegistry SecretRegistrar, tree *mtree.Merkletree, secrets []common.Hash) (unlocks []*UnlockData, err error) {
+	secretByHash := make(map[common.Hash]common.Hash)
+	for _, s := range secrets {
+		secretByHash[utils.ShaSecret(s[:])] = s
+	}
+	registerBlock := make(map[common.Hash]int64)
+	var toRegister []common.Hash
+	for _, l := range tree.Leaves {
+		secret, ok := secretByHash[l.LockSecretHash]
+		if !ok {
+			continue
+		}
+		if _, ok = registerBlock[l.LockSecretHash]; ok {
+			continue
+		}
+		registerBlock[l.LockSecretHash], err = getSecretRevealBlock(secretRegistry, l.LockSecretHash)
+		if err != nil {
+			return
+		}
+		if registerBlock[l.LockSecretHash] == 0 {
+			toRegister = append(toRegister, secret)
+		}
+	}
+	if len(toRegister) > 0 {
+		err = registerSecrets(auth, client, secretRegistry, toRegister)
+		if err != nil {
+			return
+		}
+		for _, s := range toRegister {
+			lockSecretHash := utils.ShaSecret(s[:])
+			registerBlock[lockSecretHash], err = getSecretRevealBlock(secretRegistry, lockSecretHash)
+			if err != nil {
+				return
+			}
+			if registerBlock[lockSecretHash] == 0 {
+				return nil, fmt.Errorf("secret %s is still not registered", s.String())
+			}
+		}
+	}
+	var unlockable []*mtree.Lock
+	var lockHashes []common.Hash
+	for _, l := range tree.Leaves {
+		block, ok := registerBlock[l.LockSecretHash]
+		if !ok {
+			continue
+		}
+		if !mtree.IsUnlockable(l, uint64(block)) {
+			log.Warn(fmt.Sprintf("PrepareUnlocks lock %s registered at %d after expiration %d, ignore it",
+				utils.HPex(l.LockSecretHash), block, l.Expiration))
+			continue
+		}
+		unlockable = append(unlockable, l)
+		lockHashes = append(lockHashes, l.Hash())
+	}
+	proofs := tree.MakeProofs(lockHashes)
+	for i, l := range unlockable {
+		unlocks = append(unlocks, &UnlockData{
+			Lock:          l,
+			Secret:        secretByHash[l.LockSecretHash],
+			MerkleProof:   mtree.Proof2Bytes(proofs[lockHashes[i]]),
+			RegisterBlock: registerBlock[l.LockSecretHash],
+		})
+	}
+	return
+}
+
+func getSecretRevealBlock(secretRegistry SecretRegistrar, lockSecretHash common.Hash) (int64, error) {
+	block, err := secretRegistry.GetSecretRevealBlockHeight(&bind.CallOpts{Context: GetQueryConext()}, lockSecretHash)
+	if err != nil {
+		return 0, err
+	}
+	return block.Int64(), nil
+}
+
+//registerSecrets sends all txs with consecutive nonces and waits them together, like DistributeDeposit
+func registerSecrets(auth *bind.TransactOpts, client DepositClient, secretRegistry SecretRegistrar, secrets []common.Hash) (err error) {
+	nonce, err := client.PendingNonceAt(GetQueryConext(), auth.From)
+	if err != nil {
+		return
+	}
+	var txs []*types.Transaction
+	for _, s := range secrets {
+		opts := *auth
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+		var tx *types.Transaction
+		tx, err = secretRegistry.RegisterSecret(&opts, s)
+		if err != nil {
+			err = fmt.Errorf("register secret %s err %s", s.String(), err)
+			break
+		}
+		log.Info(fmt.Sprintf("register secret %s, nonce=%d txhash=%s", utils.HPex(s), nonce, tx.Hash().String()))
+		txs = append(txs, tx)
+		nonce++
+	}
+	for _, tx := range txs {
+		receipt, err2 := helper.WaitMined(GetCallContext(), client, tx, params.ReceiptPollInterval, true)
+		if err2 == nil && receipt.Status != types.ReceiptStatusSuccessful {
+			err2 = fmt.Errorf("register secret tx %s execution failed", tx.Hash().String())
+		}
+		if err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+var _ SecretRegistrar = &contracts.SecretRegistry{}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSecretRegistry struct {
+	block      int64
+	registered map[common.Hash]int64
+	nonces     []uint64
+}
+
+func (r *fakeSecretRegistry) GetSecretRevealBlockHeight(opts *bind.CallOpts, secrethash [32]byte) (*big.Int, error) {
+	return big.NewInt(r.registered[secrethash]), nil
+}
+
+func (r *fakeSecretRegistry) RegisterSecret(opts *bind.TransactOpts, secret [32]byte) (*types.Transaction, error) {
+	r.registered[utils.ShaSecret(secret[:])] = r.block
+	r.nonces = append(r.nonces, opts.Nonce.Uint64())
+	return types.NewTransaction(opts.Nonce.Uint64(), utils.NewRandomAddress(), big.NewInt(0), 0, big.NewInt(0), secret[:]), nil
+}
+
+func TestPrepareUnlocks(t *testing.T) {
+	var secrets []common.Hash
+	var locks []*mtree.Lock
+	for i := 0; i < 5; i++ {
+		s := utils.NewRandomHash()
+		secrets = append(secrets, s)
+		locks = append(locks, &mtree.Lock{
+			Expiration:     100,
+			Amount:         big.NewInt(int64(i + 1)),
+			LockSecretHash: utils.ShaSecret(s[:]),
+		})
+	}
+	tree := mtree.NewMerkleTree(locks)
+	registry := &fakeSecretRegistry{block: 50, registered: make(map[common.Hash]int64)}
+	// locks[0] registered before, locks[1] registered too late, secret of locks[4] is unknown
+	registry.registered[locks[0].LockSecretHash] = 10
+	registry.registered[locks[1].LockSecretHash] = 101
+	chain := &fakeDepositChain{nonce: 3, failAt: -1}
+	unlocks, err := PrepareUnlocks(&bind.TransactOpts{From: utils.NewRandomAddress()}, chain, registry, tree, secrets[:4])
+	assert.Nil(t, err)
+	// only new secrets are registered, with consecutive nonces
+	assert.EqualValues(t, []uint64{3, 4}, registry.nonces)
+	if assert.Len(t, unlocks, 3) {
+		assert.Equal(t, locks[0], unlocks[0].Lock)
+		assert.EqualValues(t, 10, unlocks[0].RegisterBlock)
+		assert.Equal(t, locks[2], unlocks[1].Lock)
+		assert.EqualValues(t, 50, unlocks[1].RegisterBlock)
+		assert.Equal(t, secrets[3], unlocks[2].Secret)
+	}
+	for _, u := range unlocks {
+		assert.Equal(t, mtree.Proof2Bytes(tree.MakeProof(u.Lock.Hash())), u.MerkleProof)
+	}
+
+	// all registered, nothing to send
+	registry.nonces = nil
+	unlocks, err = PrepareUnlocks(&bind.TransactOpts{From: utils.NewRandomAddress()}, chain, registry, tree, secrets[:4])
+	assert.Nil(t, err)
+	assert.Len(t, unlocks, 3)
+	assert.Empty(t, registry.nonces)
+}
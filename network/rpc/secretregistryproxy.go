@@ -3,12 +3,14 @@ package rpc
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"sync"
 
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -47,7 +49,7 @@ func (s *SecretRegistryProxy) RegisterSecret(secret common.Hash) (err error) {
 		return err
 	}
 	log.Trace(fmt.Sprintf("RegisterSecret on chain tx=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), s.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), s.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -70,6 +72,43 @@ func (s *SecretRegistryProxy) RegisterSecretAsync(secret common.Hash) (result *u
 	return result
 }
 
+/*
+RegisterSecretsAsync 一次注册多个密码, 交易使用连续的 nonce 同时发出, 然后一起等待打包.
+已经注册过的密码会被跳过, gasPrice 为 nil 时使用默认的 gas price.
+*/
+/*
+ *	RegisterSecretsAsync : register several secrets at once, txs are sent with consecutive nonces and waited together.
+ *	Secrets already registered are skipped, nil gasPrice means the default one.
+ */
+func (s *SecretRegistryProxy) RegisterSecretsAsync(secrets []common.Hash, gasPrice *big.Int) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	go func() {
+		var toRegister []common.Hash
+		for _, secret := range secrets {
+			registered, err := s.IsSecretRegistered(secret)
+			if err != nil {
+				result.Result <- err
+				return
+			}
+			if registered {
+				log.Info(fmt.Sprintf("Secret %s already registered", utils.HPex(secret)))
+				continue
+			}
+			toRegister = append(toRegister, secret)
+		}
+		if len(toRegister) == 0 {
+			result.Result <- nil
+			return
+		}
+		auth := *s.bcs.Auth
+		if gasPrice != nil {
+			auth.GasPrice = gasPrice
+		}
+		result.Result <- registerSecrets(&auth, s.bcs.Client, s.registry, toRegister)
+	}()
+	return result
+}
+
 //IsSecretRegistered 密码是否在合约上注册过,注册地址对不对
 // IsSecretRegistered : function to check whether this secret has been registered on chain, and whether the address is correct
 func (s *SecretRegistryProxy) IsSecretRegistered(secret common.Hash) (bool, error) {
@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//SettleDeadlineSampleBlocks how many recent blocks are used to calc average block time
+const SettleDeadlineSampleBlocks = 100
+
+//errChannelNotClosed settle deadline is known only after channel closed
+var errChannelNotClosed = errors.New("channel is not closed, settle deadline unknown")
+
+//HeaderReader is the part of eth client needed by SettleDeadlineTime, SafeEthClient implements it.
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+//SettleBlockReader is the part of token network needed by SettleDeadlineTime, TokenNetworkProxy implements it.
+type SettleBlockReader interface {
+	GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error)
+	PunishBlockNumber() (uint64, error)
+}
+
+/*
+SettleDeadlineTime 估算什么时候可以 settle 通道.
+合约要求 settle_block_number + punish_block_number < block.number,
+这个时间是根据最近 SettleDeadlineSampleBlocks 个块的平均出块时间推算出来的,只是一个估计值,
+实际能否 settle 仍然以块号为准.
+*/
+/*
+ *	SettleDeadlineTime : estimate when a closed channel can be settled.
+ *	Contract requires settle_block_number + punish_block_number < block.number,
+ *	the returned time is ONLY AN ESTIMATE extrapolated from the average block time of recent
+ *	SettleDeadlineSampleBlocks blocks, whether settle can be called is still decided by block number.
+ */
+func SettleDeadlineTime(ctx context.Context, client HeaderReader, tokenNetwork SettleBlockReader, p1, p2 common.Address) (time.Time, error) {
+	_, settleBlockNumber, _, state, _, err := tokenNetwork.GetChannelInfo(p1, p2)
+	if err != nil {
+		return time.Time{}, err
+	}
+	//state 2 means closed, for opened channel settleBlockNumber is settle timeout
+	if state != 2 {
+		return time.Time{}, errChannelNotClosed
+	}
+	punishBlockNumber, err := tokenNetwork.PunishBlockNumber()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return EstimateBlockTime(ctx, client, settleBlockNumber+punishBlockNumber+1)
+}
+
+/*
+EstimateBlockTime returns time of block `number`.
+If this block is already mined, its timestamp is returned,
+otherwise it's an estimate from the average block time of recent blocks.
+*/
+func EstimateBlockTime(ctx context.Context, client HeaderReader, number uint64) (time.Time, error) {
+	latest, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latestNumber := latest.Number.Uint64()
+	if number <= latestNumber {
+		h, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(int64(h.Time.Uint64()), 0), nil
+	}
+	sampleNumber := uint64(0)
+	if latestNumber > SettleDeadlineSampleBlocks {
+		sampleNumber = latestNumber - SettleDeadlineSampleBlocks
+	}
+	if sampleNumber == latestNumber {
+		return time.Time{}, fmt.Errorf("not enough blocks to estimate block time")
+	}
+	sample, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(sampleNumber))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return extrapolateBlockTime(latestNumber, latest.Time.Uint64(), sampleNumber, sample.Time.Uint64(), number), nil
+}
+
+//extrapolateBlockTime assume blocks after `latest` are mined at the average speed between `sample` and `latest`
+func extrapolateBlockTime(latestNumber, latestTime, sampleNumber, sampleTime, number uint64) time.Time {
+	elapsed := time.Duration(latestTime-sampleTime) * time.Second
+	avg := elapsed / time.Duration(latestNumber-sampleNumber)
+	return time.Unix(int64(latestTime), 0).Add(avg * time.Duration(number-latestNumber))
+}
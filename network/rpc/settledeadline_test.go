@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+//syntheticChain block n is mined at genesisTime+n*blockTime
+type syntheticChain struct {
+	latest      uint64
+	genesisTime uint64
+	blockTime   uint64
+}
+
+func (c *syntheticChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	n := c.latest
+	if number != nil {
+		n = number.Uint64()
+	}
+	return &types.Header{
+		Number: new(big.Int).SetUint64(n),
+		Time:   new(big.Int).SetUint64(c.genesisTime + n*c.blockTime),
+	}, nil
+}
+
+type fakeSettleBlockReader struct {
+	settleBlockNumber uint64
+	state             uint8
+}
+
+func (f *fakeSettleBlockReader) GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error) {
+	return utils.EmptyHash, f.settleBlockNumber, 1, f.state, 100, nil
+}
+
+func (f *fakeSettleBlockReader) PunishBlockNumber() (uint64, error) {
+	return 257, nil
+}
+
+func TestSettleDeadlineTime(t *testing.T) {
+	chain := &syntheticChain{latest: 1000, genesisTime: 1500000000, blockTime: 15}
+	tn := &fakeSettleBlockReader{settleBlockNumber: 1100, state: 2}
+	deadline, err := SettleDeadlineTime(context.Background(), chain, tn, utils.NewRandomAddress(), utils.NewRandomAddress())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//settle can be called at 1100+257+1
+	assert.EqualValues(t, time.Unix(int64(1500000000+1358*15), 0), deadline)
+	//already mined
+	tn.settleBlockNumber = 500
+	deadline, err = SettleDeadlineTime(context.Background(), chain, tn, utils.NewRandomAddress(), utils.NewRandomAddress())
+	assert.Equal(t, nil, err)
+	assert.EqualValues(t, time.Unix(int64(1500000000+758*15), 0), deadline)
+	tn.state = 1
+	_, err = SettleDeadlineTime(context.Background(), chain, tn, utils.NewRandomAddress(), utils.NewRandomAddress())
+	assert.Equal(t, errChannelNotClosed, err)
+}
+
+func TestExtrapolateBlockTime(t *testing.T) {
+	//blocks 900-1000 took 1000 seconds, 10s per block
+	tm := extrapolateBlockTime(1000, 20000, 900, 19000, 1060)
+	assert.EqualValues(t, time.Unix(20600, 0), tm)
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+//liveLogBuffer logs from subscription waiting while history logs are sent
+const liveLogBuffer = 1024
+
+//LogTailer is the part of eth client needed by TailLogs, SafeEthClient implements it.
+type LogTailer interface {
+	ChannelLogFilterer
+	SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+}
+
+//logPosition identifies a log on chain
+type logPosition struct {
+	blockNumber uint64
+	index       uint
+}
+
+/*
+TailLogs 先发送从 fromBlock 到当前块的历史日志, 然后继续发送新的日志, 直到 ctx 结束或者订阅出错.
+先订阅再查询历史, 所以边界上不会漏掉日志; 订阅开始以后的块的日志可能两边都收到, 按块号和日志序号去重.
+链重组时移除的日志(Removed 为 true)总是发送, 之后重新加入的同一位置的日志也会发送.
+q.FromBlock 和 q.ToBlock 被忽略. 返回时 out 不会被关闭.
+*/
+/*
+ *	TailLogs : send history logs from fromBlock to the current head to out, then keep sending new logs,
+ *	until ctx is done or the subscription fails.
+ *	It subscribes before querying history, so nothing is missed at the boundary;
+ *	logs of blocks after the subscription started may come both ways, they are deduplicated by block number and log index.
+ *	Logs removed by reorg (Removed is true) are always sent, so is a log added again at the same position later.
+ *	q.FromBlock and q.ToBlock are ignored. out is not closed when it returns.
+ */
+func TailLogs(ctx context.Context, client LogTailer, q ethereum.FilterQuery, fromBlock uint64, out chan<- types.Log) error {
+	ctx = ensureContext(ctx)
+	q.FromBlock, q.ToBlock = nil, nil
+	//logs of blocks up to this one never come from subscription
+	h, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	subscribed := h.Number.Uint64()
+	live := make(chan types.Log, liveLogBuffer)
+	sub, err := client.SubscribeFilterLogs(ctx, q, live)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	h, err = client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	head := h.Number.Uint64()
+	send := func(l types.Log) error {
+		select {
+		case out <- l:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	//history logs which may come from subscription too
+	sent := make(map[logPosition]bool)
+	if fromBlock <= head {
+		logs, err := FilterLogsPaged(ctx, client, q, fromBlock, head, DefaultLogPageSize)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			if l.BlockNumber > subscribed {
+				sent[logPosition{l.BlockNumber, l.Index}] = true
+			}
+			if err = send(l); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case l := <-live:
+			if l.BlockNumber < fromBlock {
+				continue
+			}
+			p := logPosition{l.BlockNumber, l.Index}
+			if !l.Removed && sent[p] {
+				delete(sent, p)
+				continue
+			}
+			delete(sent, p)
+			if l.BlockNumber > head {
+				//past history, nothing can be sent twice any more
+				sent = nil
+			}
+			if err = send(l); err != nil {
+				return err
+			}
+		case err, ok := <-sub.Err():
+			if !ok {
+				return errors.New("log subscription closed")
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//TailLogs send history logs of q from fromBlock and then new ones to out, see TailLogs of this package
+func (bcs *BlockChainService) TailLogs(ctx context.Context, q ethereum.FilterQuery, fromBlock uint64, out chan<- types.Log) error {
+	return TailLogs(ctx, bcs.Client, q, fromBlock, out)
+}
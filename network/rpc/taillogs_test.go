@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSubscription struct {
+	err chan error
+}
+
+func (s *fakeSubscription) Unsubscribe() {}
+
+func (s *fakeSubscription) Err() <-chan error {
+	return s.err
+}
+
+//fakeLogTailer two logs in every block up to the head, heads are returned one by one by HeaderByNumber
+type fakeLogTailer struct {
+	heads []uint64
+	live  []types.Log
+	sub   *fakeSubscription
+}
+
+func (f *fakeLogTailer) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	h := f.heads[0]
+	if len(f.heads) > 1 {
+		f.heads = f.heads[1:]
+	}
+	return &types.Header{Number: new(big.Int).SetUint64(h)}, nil
+}
+
+func (f *fakeLogTailer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	for b := q.FromBlock.Uint64(); b <= q.ToBlock.Uint64(); b++ {
+		logs = append(logs, types.Log{BlockNumber: b, Index: uint(2 * b)}, types.Log{BlockNumber: b, Index: uint(2*b + 1)})
+	}
+	return
+}
+
+func (f *fakeLogTailer) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	for _, l := range f.live {
+		ch <- l
+	}
+	f.sub = &fakeSubscription{err: make(chan error, 1)}
+	return f.sub, nil
+}
+
+func liveLogs(from, to uint64) (logs []types.Log) {
+	for b := from; b <= to; b++ {
+		logs = append(logs, types.Log{BlockNumber: b, Index: uint(2 * b)}, types.Log{BlockNumber: b, Index: uint(2*b + 1)})
+	}
+	return
+}
+
+func collectLogs(out chan types.Log, n int) (logs []types.Log) {
+	for len(logs) < n {
+		select {
+		case l := <-out:
+			logs = append(logs, l)
+		case <-time.After(time.Second):
+			return
+		}
+	}
+	return
+}
+
+func TestTailLogsHandoff(t *testing.T) {
+	//subscribed at block 8, head is 10 when history is queried, so blocks 9 and 10 come both ways
+	f := &fakeLogTailer{heads: []uint64{8, 10}, live: liveLogs(9, 12)}
+	removed := types.Log{BlockNumber: 12, Index: 25, Removed: true}
+	f.live = append(f.live, removed, types.Log{BlockNumber: 12, Index: 25})
+	out := make(chan types.Log)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- TailLogs(ctx, f, ethereum.FilterQuery{}, 3, out)
+	}()
+	logs := collectLogs(out, 22)
+	if !assert.Len(t, logs, 22) {
+		cancel()
+		return
+	}
+	//blocks 3 to 12 exactly once and in order
+	for i, l := range logs[:20] {
+		assert.EqualValues(t, 3+i/2, l.BlockNumber)
+		assert.EqualValues(t, 6+i, l.Index)
+		assert.False(t, l.Removed)
+	}
+	//reorg of the last log
+	assert.Equal(t, removed, logs[20])
+	assert.False(t, logs[21].Removed)
+	assert.EqualValues(t, 25, logs[21].Index)
+	assert.Empty(t, collectLogs(out, 1))
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestTailLogsFromFuture(t *testing.T) {
+	f := &fakeLogTailer{heads: []uint64{10}, live: liveLogs(11, 13)}
+	out := make(chan types.Log, 10)
+	done := make(chan error)
+	go func() {
+		done <- TailLogs(context.Background(), f, ethereum.FilterQuery{}, 12, out)
+	}()
+	logs := collectLogs(out, 4)
+	if assert.Len(t, logs, 4) {
+		assert.EqualValues(t, 12, logs[0].BlockNumber)
+		assert.EqualValues(t, 13, logs[3].BlockNumber)
+	}
+	errSub := context.DeadlineExceeded
+	f.sub.err <- errSub
+	assert.Equal(t, errSub, <-done)
+}
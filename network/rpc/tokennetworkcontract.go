@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"math/big"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+TokenNetworkContract 通道相关的合约操作, channel 只依赖这个接口,
+这样测试的时候可以用一个假的实现代替真正的合约.
+TokenNetworkProxy 是对合约 binding 的封装, 它实现了这个接口.
+*/
+/*
+ *	TokenNetworkContract : contract operations a channel needs.
+ *	Channel logic depends on this interface only, so it can be tested with a fake instead of a live chain.
+ *	TokenNetworkProxy wraps the generated binding and implements it.
+ */
+type TokenNetworkContract interface {
+	GetChannelInfo(participant1, participant2 common.Address) (channelID common.Hash, settleBlockNumber, openBlockNumber uint64, state uint8, settleTimeout uint64, err error)
+	GetChannelParticipantInfo(participant, partner common.Address) (deposit *big.Int, balanceHash common.Hash, nonce uint64, err error)
+
+	CloseChannel(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error)
+	CloseChannelAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (result *utils.AsyncResult)
+	UpdateBalanceProof(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (err error)
+	UpdateBalanceProofAsync(partnerAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, signature []byte) (result *utils.AsyncResult)
+	Unlock(partnerAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte) (err error)
+	SettleChannel(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (err error)
+	SettleChannelAsync(p1Addr, p2Addr common.Address, p1Amount, p2Amount *big.Int, p1Locksroot, p2Locksroot common.Hash) (result *utils.AsyncResult)
+	PunishObsoleteUnlock(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (err error)
+	PunishObsoleteUnlockAsync(beneficiary, cheater common.Address, lockhash, extraHash common.Hash, cheaterSignature []byte) (result *utils.AsyncResult)
+	NewChannelAndDepositAsync(participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (result *utils.AsyncResult)
+	CooperativeSettleAsync(p1Addr, p2Addr common.Address, p1Balance, p2Balance *big.Int, p1Signature, p2Signatue []byte) (result *utils.AsyncResult)
+	WithdrawAsync(p1Addr, p2Addr common.Address, p1Balance, p1Withdraw *big.Int, p1Signature, p2Signature []byte) (result *utils.AsyncResult)
+}
+
+var _ TokenNetworkContract = (*TokenNetworkProxy)(nil)
@@ -8,10 +8,11 @@ import (
 	"bytes"
 
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/utils"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -54,40 +55,10 @@ func makeNewChannelAndDepositData(participantAddress, partnerAddress common.Addr
 	}
 	return buf.Bytes()
 }
-func (t *TokenNetworkProxy) newChannelAndDepositByApproveAndCall(token *TokenProxy, participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (err error) {
-	data := makeNewChannelAndDepositData(participantAddress, partnerAddress, settleTimeout)
-	return token.ApproveAndCall(t.Address, amount, data)
-}
 func (t *TokenNetworkProxy) newChannelAndDepositByFallback(token *TokenProxy, participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (err error) {
 	data := makeNewChannelAndDepositData(participantAddress, partnerAddress, settleTimeout)
 	return token.TransferWithFallback(t.Address, amount, data)
 }
-func (t *TokenNetworkProxy) newChannelAndDepositByApprove(token *TokenProxy, participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (err error) {
-	//log.Trace(fmt.Sprintf("newChannelAndDepositByApprove participant=%s,partner=%s,settletimeout=%d,amount=%s,token=%s",
-	//	utils.APex2(participantAddress), utils.APex2(partnerAddress), settleTimeout, amount, utils.APex2(t.token),
-	//))
-	err = token.Approve(t.Address, amount)
-	if err != nil {
-		return err
-	}
-	//log.Trace(fmt.Sprintf("approve pass..."))
-	tx, err := t.GetContract().Deposit(t.bcs.Auth, t.token, participantAddress, partnerAddress, amount, uint64(settleTimeout))
-	if err != nil {
-		return
-	}
-	log.Info(fmt.Sprintf("OpenChannelWithDeposit  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
-	if err != nil {
-		return err
-	}
-	if receipt.Status != types.ReceiptStatusSuccessful {
-		log.Warn(fmt.Sprintf("OpenChannelWithDeposit failed %s", receipt))
-		return errors.New("OpenChannelWithDeposit tx execution failed")
-	}
-	log.Info(fmt.Sprintf("OpenChannelWithDeposit success %s txhash=%s", utils.APex(t.Address), tx.Hash().String()))
-	return nil
-}
-
 //NewChannelAndDeposit create new channel ,block until a new channel create
 func (t *TokenNetworkProxy) NewChannelAndDeposit(participantAddress, partnerAddress common.Address, settleTimeout int, amount *big.Int) (err error) {
 	log.Trace(fmt.Sprintf("NewChannelAndDeposit participant=%s,partner=%s,settletimeout=%d,amount=%s",
@@ -106,12 +77,19 @@ func (t *TokenNetworkProxy) NewChannelAndDeposit(participantAddress, partnerAddr
 		log.Trace(fmt.Sprintf("%s-%s newChannelAndDepositByFallback success", utils.APex(tokenAddr), utils.APex(participantAddress)))
 		return
 	}
-	err = t.newChannelAndDepositByApproveAndCall(token, participantAddress, partnerAddress, settleTimeout, amount)
-	if err == nil {
-		log.Trace(fmt.Sprintf("%s-%s newChannelAndDepositByApproveAndCall success", utils.APex(tokenAddr), utils.APex(participantAddress)))
+	opener := &ChannelOpener{
+		Client:              t.bcs.Client,
+		Token:               token.Token,
+		TokenAddress:        tokenAddr,
+		TokenNetwork:        t.GetContract(),
+		TokenNetworkAddress: t.Address,
+	}
+	singleTx, err := opener.OpenChannelWithDeposit(t.bcs.Auth, partnerAddress, uint64(settleTimeout), amount)
+	if err != nil {
 		return
 	}
-	return t.newChannelAndDepositByApprove(token, participantAddress, partnerAddress, settleTimeout, amount)
+	log.Info(fmt.Sprintf("OpenChannelWithDeposit success %s-%s singleTx=%v", utils.APex(tokenAddr), utils.APex(participantAddress), singleTx))
+	return
 }
 
 //NewChannelAndDepositAsync create channel async
@@ -134,6 +112,16 @@ func (t *TokenNetworkProxy) GetChannelInfo(participant1, participant2 common.Add
 	return t.ch.GetChannelInfo(t.bcs.getQueryOpts(), t.token, participant1, participant2)
 }
 
+//PunishBlockNumber returns how many blocks after settle block number a dishonest partner can be punished
+func (t *TokenNetworkProxy) PunishBlockNumber() (uint64, error) {
+	return t.ch.PunishBlockNumber(t.bcs.getQueryOpts())
+}
+
+//QueryUnlockedLocks returns true if partner has unlocked lock of participant on chain under current nonce of participant
+func (t *TokenNetworkProxy) QueryUnlockedLocks(participant, partner common.Address, lockHash common.Hash) (bool, error) {
+	return t.ch.QueryUnlockedLocks(t.bcs.getQueryOpts(), t.token, participant, partner, lockHash)
+}
+
 //GetChannelParticipantInfo Returns Info of this channel.
 //@return The address of the token.
 func (t *TokenNetworkProxy) GetChannelParticipantInfo(participant, partner common.Address) (deposit *big.Int, balanceHash common.Hash, nonce uint64, err error) {
@@ -154,7 +142,7 @@ func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmo
 		return
 	}
 	log.Info(fmt.Sprintf("CloseChannel  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -183,7 +171,7 @@ func (t *TokenNetworkProxy) UpdateBalanceProof(partnerAddr common.Address, trans
 		return
 	}
 	log.Info(fmt.Sprintf("UpdateBalanceProof  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -206,6 +194,55 @@ func (t *TokenNetworkProxy) UpdateBalanceProofAsync(partnerAddr common.Address,
 	return
 }
 
+/*
+PackUpdateBalanceProof 生成 UpdateBalanceProof 的 calldata, 不发送交易, 方便通过中继或者多签等其他方式提交.
+*/
+/*
+ *	PackUpdateBalanceProof : build the exact calldata of UpdateBalanceProof without sending it,
+ *	so the tx can be submitted through a relayer, a multisig or any other mechanism.
+ */
+func (t *TokenNetworkProxy) PackUpdateBalanceProof(partner common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, additionalHash common.Hash, signature []byte) ([]byte, error) {
+	return tokensNetworkAbi.Pack("updateBalanceProof", t.token, partner, transferAmount, locksRoot, nonce, additionalHash, signature)
+}
+
+//UpdateBalanceProofDelegate update balance proof of partner for participant,called by a third party
+func (t *TokenNetworkProxy) UpdateBalanceProofDelegate(partnerAddr, participantAddr common.Address, transferAmount *big.Int, locksRoot common.Hash, nonce uint64, extraHash common.Hash, partnerSignature, participantSignature []byte) (err error) {
+	tx, err := t.GetContract().UpdateBalanceProofDelegate(t.bcs.Auth, t.token, partnerAddr, participantAddr, transferAmount, locksRoot, nonce, extraHash, partnerSignature, participantSignature)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("UpdateBalanceProofDelegate  txhash=%s", tx.Hash().String()))
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		log.Info(fmt.Sprintf("UpdateBalanceProofDelegate failed %s", receipt))
+		return errors.New("UpdateBalanceProofDelegate tx execution failed")
+	}
+	log.Info(fmt.Sprintf("UpdateBalanceProofDelegate success %s ,partner=%s,participant=%s", utils.APex(t.Address), utils.APex(partnerAddr), utils.APex(participantAddr)))
+	return nil
+}
+
+//UnlockDelegate unlock a partner's lock for participant,called by a third party
+func (t *TokenNetworkProxy) UnlockDelegate(partnerAddr, participantAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte, participantSignature []byte) (err error) {
+	tx, err := t.GetContract().UnlockDelegate(t.bcs.Auth, t.token, partnerAddr, participantAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof, participantSignature)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("UnlockDelegate  txhash=%s", tx.Hash().String()))
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		log.Info(fmt.Sprintf("UnlockDelegate failed %s", receipt))
+		return errors.New("UnlockDelegate tx execution failed")
+	}
+	log.Info(fmt.Sprintf("UnlockDelegate success %s ,partner=%s,participant=%s", utils.APex(t.Address), utils.APex(partnerAddr), utils.APex(participantAddr)))
+	return nil
+}
+
 //Unlock a partner's lock
 func (t *TokenNetworkProxy) Unlock(partnerAddr common.Address, transferAmount *big.Int, lock *mtree.Lock, proof []byte) (err error) {
 	tx, err := t.GetContract().Unlock(t.bcs.Auth, t.token, partnerAddr, transferAmount, big.NewInt(lock.Expiration), lock.Amount, lock.LockSecretHash, proof)
@@ -213,7 +250,7 @@ func (t *TokenNetworkProxy) Unlock(partnerAddr common.Address, transferAmount *b
 		return
 	}
 	log.Info(fmt.Sprintf("Unlock  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -242,7 +279,7 @@ func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amoun
 		return
 	}
 	log.Info(fmt.Sprintf("SettleChannel  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -274,7 +311,7 @@ func (t *TokenNetworkProxy) Withdraw(p1Addr, p2Addr common.Address, p1Balance,
 		return
 	}
 	log.Info(fmt.Sprintf("Withdraw  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -304,7 +341,7 @@ func (t *TokenNetworkProxy) PunishObsoleteUnlock(beneficiary, cheater common.Add
 		return
 	}
 	log.Info(fmt.Sprintf("PunishObsoleteUnlock  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -333,7 +370,7 @@ func (t *TokenNetworkProxy) CooperativeSettle(p1Addr, p2Addr common.Address, p1B
 		return
 	}
 	log.Info(fmt.Sprintf("CooperativeSettle  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackUpdateBalanceProof(t *testing.T) {
+	token := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	locksRoot := utils.NewRandomHash()
+	additionalHash := utils.NewRandomHash()
+	signature := make([]byte, 65)
+	for i := range signature {
+		signature[i] = byte(i + 1)
+	}
+	tp := &TokenNetworkProxy{token: token}
+	data, err := tp.PackUpdateBalanceProof(partner, big.NewInt(300), locksRoot, 7, additionalHash, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := crypto.Keccak256([]byte("updateBalanceProof(address,address,uint256,bytes32,uint64,bytes32,bytes)"))[:4]
+	assert.EqualValues(t, selector, data[:4])
+	args := data[4:]
+	word := func(i int) []byte {
+		return args[i*32 : (i+1)*32]
+	}
+	assert.EqualValues(t, common.BytesToHash(token[:]).Bytes(), word(0))
+	assert.EqualValues(t, common.BytesToHash(partner[:]).Bytes(), word(1))
+	assert.EqualValues(t, utils.BigIntTo32Bytes(big.NewInt(300)), word(2))
+	assert.EqualValues(t, locksRoot[:], word(3))
+	assert.EqualValues(t, utils.BigIntTo32Bytes(big.NewInt(7)), word(4))
+	assert.EqualValues(t, additionalHash[:], word(5))
+	// dynamic bytes: offset, length, then data padded to 32 bytes
+	assert.EqualValues(t, utils.BigIntTo32Bytes(big.NewInt(7*32)), word(6))
+	assert.EqualValues(t, utils.BigIntTo32Bytes(big.NewInt(65)), word(7))
+	assert.EqualValues(t, signature, args[8*32:8*32+65])
+	assert.EqualValues(t, 4+11*32, len(data))
+}
@@ -6,9 +6,10 @@ import (
 	"math/big"
 
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/network/helper"
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/utils"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -26,6 +27,11 @@ func (t *TokenProxy) TotalSupply() (*big.Int, error) {
 	return t.Token.TotalSupply(t.bcs.getQueryOpts())
 }
 
+// Decimals number of decimals of the token, amounts are in units of 10^-decimals token
+func (t *TokenProxy) Decimals() (uint8, error) {
+	return t.Token.Decimals(t.bcs.getQueryOpts())
+}
+
 // BalanceOf The balance
 // @param _owner The address from which the balance will be retrieved
 func (t *TokenProxy) BalanceOf(addr common.Address) (*big.Int, error) {
@@ -57,7 +63,7 @@ func (t *TokenProxy) Approve(spender common.Address, value *big.Int) (err error)
 		return err
 	}
 	log.Info(fmt.Sprintf("Approve %s, txhash=%s", utils.APex(spender), tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -83,7 +89,7 @@ func (t *TokenProxy) Transfer(spender common.Address, value *big.Int) (err error
 	if err != nil {
 		return err
 	}
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -111,7 +117,7 @@ func (t *TokenProxy) TransferWithFallback(to common.Address, value *big.Int, ext
 	if err != nil {
 		return err
 	}
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -129,7 +135,7 @@ func (t *TokenProxy) ApproveAndCall(spender common.Address, value *big.Int, extr
 	if err != nil {
 		return err
 	}
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := helper.WaitMined(GetCallContext(), t.bcs.Client, tx, params.ReceiptPollInterval, true)
 	if err != nil {
 		return err
 	}
@@ -114,6 +114,8 @@ func (tb *TokenBucket) getTokens() {
 //ProtocolReceiver receive
 type ProtocolReceiver interface {
 	receive(data []byte)
+	//receiveFrom data from a transport which knows who sent it, e.g. xmpp authenticates the sender
+	receiveFrom(from common.Address, data []byte)
 }
 
 //
@@ -107,7 +107,7 @@ func (x *XMPPTransport) DataHandler(from common.Address, data []byte) {
 		return
 	}
 	if x.protocol != nil {
-		x.protocol.receive(data)
+		x.protocol.receiveFrom(from, data)
 	}
 }
 
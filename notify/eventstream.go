@@ -0,0 +1,308 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//EventType type of events in EventStream
+type EventType string
+
+const (
+	//EventChannelOpened a channel of this node is opened
+	EventChannelOpened EventType = "channel_opened"
+	//EventChannelDeposit deposit of a channel of this node changed
+	EventChannelDeposit EventType = "channel_deposit"
+	//EventChannelClosed a channel of this node is closed
+	EventChannelClosed EventType = "channel_closed"
+	//EventChannelSettled a channel of this node is settled, or cooperatively settled
+	EventChannelSettled EventType = "channel_settled"
+	//EventTransferInitiated this node initiated a transfer
+	EventTransferInitiated EventType = "transfer_initiated"
+	//EventTransferSucceeded a transfer this node takes part in succeeded
+	EventTransferSucceeded EventType = "transfer_succeeded"
+	//EventTransferFailed a transfer this node takes part in failed
+	EventTransferFailed EventType = "transfer_failed"
+	//EventSecretRevealed a secret is registered on chain
+	EventSecretRevealed EventType = "secret_revealed"
+	//EventChainConnection connection status to the chain changed
+	EventChainConnection EventType = "chain_connection"
+)
+
+//EventTypes all types of events in EventStream
+var EventTypes = []EventType{
+	EventChannelOpened, EventChannelDeposit, EventChannelClosed, EventChannelSettled,
+	EventTransferInitiated, EventTransferSucceeded, EventTransferFailed,
+	EventSecretRevealed, EventChainConnection,
+}
+
+//DefaultEventHistory how many latest events are kept for replay
+const DefaultEventHistory = 1024
+
+//eventSubscriptionBuffer live events more than this are not waited for, the subscription is closed
+const eventSubscriptionBuffer = 256
+
+//Event an event in EventStream, events without token or channel have no TokenAddress or ChannelIdentifier
+type Event struct {
+	Sequence          uint64          `json:"sequence"`
+	Type              EventType       `json:"type"`
+	Time              int64           `json:"time"`
+	TokenAddress      *common.Address `json:"token_address,omitempty"`
+	ChannelIdentifier *common.Hash    `json:"channel_identifier,omitempty"`
+	Data              json.RawMessage `json:"data"`
+}
+
+/*
+EventFilter 事件过滤条件, 空的条件不过滤.
+Types 必须匹配; Tokens 和 Channels 只对带有 token 或者 channel 的事件生效, 比如公链连接状态总是可以收到.
+*/
+/*
+ *	EventFilter : which events a subscription wants, an empty condition matches everything.
+ *	Types must match, Tokens and Channels only apply to events carrying a token or a channel,
+ *	e.g. chain connection status always matches them.
+ */
+type EventFilter struct {
+	Types    []EventType      `json:"types,omitempty"`
+	Tokens   []common.Address `json:"tokens,omitempty"`
+	Channels []common.Hash    `json:"channels,omitempty"`
+}
+
+//Check every type is known
+func (f *EventFilter) Check() error {
+	for _, t := range f.Types {
+		known := false
+		for _, t2 := range EventTypes {
+			if t == t2 {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %s", t)
+		}
+	}
+	return nil
+}
+
+//Match e is wanted by f
+func (f *EventFilter) Match(e *Event) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Tokens) > 0 && e.TokenAddress != nil {
+		found := false
+		for _, t := range f.Tokens {
+			if t == *e.TokenAddress {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Channels) > 0 && e.ChannelIdentifier != nil {
+		found := false
+		for _, c := range f.Channels {
+			if c == *e.ChannelIdentifier {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+EventStream 给上层(比如 websocket)推送的事件流, 每个事件有一个递增的序号, 从 1 开始.
+最近的 DefaultEventHistory 个事件保存在内存里, 订阅时可以从指定的序号开始重放, 断线重连以后不会丢失事件.
+序号只在这次运行中有效, 重启以后从 1 重新开始, 订阅者通过 Started 判断.
+Publish 从不阻塞, 订阅者来不及读取时它的订阅被关闭, 订阅者需要用下一个序号重新订阅.
+*/
+/*
+ *	EventStream : events pushed to upper app, e.g. websocket, every event has an increasing sequence starting from 1.
+ *	The latest DefaultEventHistory events are kept in memory, a subscription can replay from a given sequence,
+ *	so nothing is lost when a client reconnects.
+ *	Sequences are only valid in this run, they restart from 1 after restart, subscribers tell it by Started.
+ *	Publish never blocks, a subscription that doesn't keep up is closed, and should subscribe again from its next sequence.
+ */
+type EventStream struct {
+	//Started when this stream is created, in unix nano, identifies sequences of this run
+	Started  int64
+	lock     sync.Mutex
+	sequence uint64
+	history  []*Event
+	capacity int
+	subs     map[*EventSubscription]bool
+	stopped  bool
+}
+
+//NewEventStream create an EventStream which keeps capacity latest events
+func NewEventStream(capacity int) *EventStream {
+	return &EventStream{
+		Started:  time.Now().UnixNano(),
+		capacity: capacity,
+		subs:     make(map[*EventSubscription]bool),
+	}
+}
+
+//Publish an event, tokenAddress and channelIdentifier are empty if the event has no token or channel, never blocks
+func (s *EventStream) Publish(typ EventType, tokenAddress common.Address, channelIdentifier common.Hash, data interface{}) {
+	if s == nil {
+		return
+	}
+	//data may change later, e.g. a channel, keep what it is now
+	buf, err := json.Marshal(data)
+	if err != nil {
+		log.Error(fmt.Sprintf("marshal %s event err %s", typ, err))
+		return
+	}
+	e := &Event{
+		Type: typ,
+		Time: time.Now().Unix(),
+		Data: buf,
+	}
+	if tokenAddress != utils.EmptyAddress {
+		e.TokenAddress = &tokenAddress
+	}
+	if channelIdentifier != utils.EmptyHash {
+		e.ChannelIdentifier = &channelIdentifier
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return
+	}
+	s.sequence++
+	e.Sequence = s.sequence
+	if len(s.history) >= s.capacity {
+		copy(s.history, s.history[1:])
+		s.history = s.history[:len(s.history)-1]
+	}
+	s.history = append(s.history, e)
+	for sub := range s.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			log.Warn(fmt.Sprintf("event subscription is too slow, close it at sequence %d", e.Sequence))
+			sub.Overflowed = true
+			s.remove(sub)
+		}
+	}
+}
+
+/*
+Subscribe 订阅符合 filter 的事件, fromSequence 为 0 时只接收新的事件,
+否则先重放序号不小于 fromSequence 的事件, 然后接收新的事件, 重放和新事件之间不会有遗漏或者重复.
+fromSequence 之后的事件已经不在内存中, 或者 fromSequence 比下一个序号还大(节点重启过)时, Lost 为 true, 重放所有保存的事件.
+*/
+/*
+ *	Subscribe : subscribe events matching filter, only new events if fromSequence is 0,
+ *	otherwise events with sequence not less than fromSequence are replayed first, then new events follow,
+ *	there is no gap or duplicate between them.
+ *	If some events after fromSequence are not kept any more, or fromSequence is larger than the next sequence(node restarted),
+ *	Lost is true and all kept events are replayed.
+ */
+func (s *EventStream) Subscribe(filter *EventFilter, fromSequence uint64) *EventSubscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sub := &EventSubscription{
+		Started:      s.Started,
+		NextSequence: s.sequence + 1,
+		stream:       s,
+		filter:       filter,
+	}
+	var replay []*Event
+	if fromSequence > 0 {
+		first := s.sequence + 1
+		if len(s.history) > 0 {
+			first = s.history[0].Sequence
+		}
+		if fromSequence < first || fromSequence > s.sequence+1 {
+			sub.Lost = true
+			fromSequence = first
+		}
+		for _, e := range s.history {
+			if e.Sequence >= fromSequence && filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	sub.c = make(chan *Event, len(replay)+eventSubscriptionBuffer)
+	sub.Events = sub.c
+	for _, e := range replay {
+		sub.c <- e
+	}
+	if s.stopped {
+		close(sub.c)
+		return sub
+	}
+	s.subs[sub] = true
+	return sub
+}
+
+//remove must hold lock
+func (s *EventStream) remove(sub *EventSubscription) {
+	if s.subs[sub] {
+		delete(s.subs, sub)
+		close(sub.c)
+	}
+}
+
+//Stop close all subscriptions, events published later are dropped
+func (s *EventStream) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopped = true
+	for sub := range s.subs {
+		s.remove(sub)
+	}
+}
+
+//EventSubscription a subscription of EventStream
+type EventSubscription struct {
+	//Events replayed and new events, closed when unsubscribed, overflowed or stream stopped
+	Events <-chan *Event
+	//Started of the stream
+	Started int64
+	//NextSequence sequence of the next new event when subscribed
+	NextSequence uint64
+	//Lost some events asked for are not kept any more
+	Lost bool
+	//Overflowed Events is closed because it's not read in time, valid after Events is closed
+	Overflowed bool
+	c          chan *Event
+	stream     *EventStream
+	filter     *EventFilter
+}
+
+//Unsubscribe stop receiving events, Events is closed
+func (sub *EventSubscription) Unsubscribe() {
+	sub.stream.lock.Lock()
+	defer sub.stream.lock.Unlock()
+	sub.stream.remove(sub)
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveSequences(sub *EventSubscription, n int) (seqs []uint64) {
+	for i := 0; i < n; i++ {
+		seqs = append(seqs, (<-sub.Events).Sequence)
+	}
+	return
+}
+
+func TestEventStreamReplay(t *testing.T) {
+	s := NewEventStream(4)
+	live := s.Subscribe(&EventFilter{}, 0)
+	assert.EqualValues(t, 1, live.NextSequence)
+	for i := 0; i < 3; i++ {
+		s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, i)
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, receiveSequences(live, 3))
+	e := <-s.Subscribe(nil, 3).Events
+	assert.EqualValues(t, 3, e.Sequence)
+	assert.Equal(t, "2", string(e.Data))
+	assert.Nil(t, e.TokenAddress)
+	assert.Nil(t, e.ChannelIdentifier)
+
+	// resume from 2, replayed and new events without gap or duplicate
+	sub := s.Subscribe(nil, 2)
+	assert.False(t, sub.Lost)
+	assert.EqualValues(t, 4, sub.NextSequence)
+	s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, 3)
+	assert.Equal(t, []uint64{2, 3, 4}, receiveSequences(sub, 3))
+	// resume from next sequence, nothing to replay
+	sub = s.Subscribe(nil, 5)
+	assert.False(t, sub.Lost)
+	assert.Len(t, sub.Events, 0)
+
+	// only 4 latest events are kept
+	s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, 4)
+	sub = s.Subscribe(nil, 1)
+	assert.True(t, sub.Lost)
+	assert.Equal(t, []uint64{2, 3, 4, 5}, receiveSequences(sub, 4))
+	// node restarted, sequence of client is from last run
+	sub = s.Subscribe(nil, 100)
+	assert.True(t, sub.Lost)
+	assert.Len(t, sub.Events, 4)
+}
+
+func TestEventStreamFilter(t *testing.T) {
+	s := NewEventStream(DefaultEventHistory)
+	token1, token2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	ch1, ch2 := utils.NewRandomHash(), utils.NewRandomHash()
+	s.Publish(EventChannelOpened, token1, ch1, nil)
+	s.Publish(EventChannelOpened, token2, ch2, nil)
+	s.Publish(EventTransferSucceeded, token2, utils.EmptyHash, nil)
+	s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, nil)
+	cases := []struct {
+		filter *EventFilter
+		expect []uint64
+	}{
+		{&EventFilter{}, []uint64{1, 2, 3, 4}},
+		{&EventFilter{Types: []EventType{EventChannelOpened}}, []uint64{1, 2}},
+		{&EventFilter{Tokens: []common.Address{token2}}, []uint64{2, 3, 4}},
+		{&EventFilter{Channels: []common.Hash{ch1}}, []uint64{1, 3, 4}},
+		{&EventFilter{Types: []EventType{EventChannelOpened}, Tokens: []common.Address{token2}}, []uint64{2}},
+	}
+	for i, c := range cases {
+		sub := s.Subscribe(c.filter, 1)
+		assert.Equal(t, c.expect, receiveSequences(sub, len(c.expect)), "case %d", i)
+		assert.Len(t, sub.Events, 0, "case %d", i)
+	}
+	assert.Nil(t, (&EventFilter{Types: []EventType{EventChannelClosed}}).Check())
+	assert.NotNil(t, (&EventFilter{Types: []EventType{"channel_closd"}}).Check())
+}
+
+func TestEventStreamOverflow(t *testing.T) {
+	s := NewEventStream(DefaultEventHistory)
+	slow := s.Subscribe(nil, 0)
+	other := s.Subscribe(&EventFilter{Types: []EventType{EventChannelClosed}}, 0)
+	for i := 0; i < eventSubscriptionBuffer+1; i++ {
+		s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, i)
+	}
+	assert.Len(t, slow.Events, eventSubscriptionBuffer)
+	for range slow.Events {
+	}
+	assert.True(t, slow.Overflowed)
+	// the slow one resumes from where it stopped
+	sub := s.Subscribe(nil, eventSubscriptionBuffer+1)
+	assert.False(t, sub.Lost)
+	assert.Equal(t, []uint64{eventSubscriptionBuffer + 1}, receiveSequences(sub, 1))
+	// others are not affected
+	s.Publish(EventChannelClosed, utils.EmptyAddress, utils.EmptyHash, nil)
+	assert.EqualValues(t, eventSubscriptionBuffer+2, (<-other.Events).Sequence)
+
+	other.Unsubscribe()
+	_, ok := <-other.Events
+	assert.False(t, ok)
+	other.Unsubscribe()
+	assert.False(t, other.Overflowed)
+
+	s.Stop()
+	assert.EqualValues(t, eventSubscriptionBuffer+2, (<-sub.Events).Sequence)
+	_, ok = <-sub.Events
+	assert.False(t, ok)
+	_, ok = <-s.Subscribe(nil, 0).Events
+	assert.False(t, ok)
+	s.Publish(EventChainConnection, utils.EmptyAddress, utils.EmptyHash, nil)
+}
@@ -21,6 +21,8 @@ type Handler struct {
 	receivedTransferChan chan *models.ReceivedTransfer
 	//noticeChan should never close
 	noticeChan chan *Notice
+	//Events event stream of channels, transfers and chain connection, for websocket
+	Events *EventStream
 
 	// work status
 	stopped bool
@@ -32,6 +34,7 @@ func NewNotifyHandler() *Handler {
 		sentTransferChan:     make(chan *models.SentTransfer, 10),
 		receivedTransferChan: make(chan *models.ReceivedTransfer, 10),
 		noticeChan:           make(chan *Notice, 10),
+		Events:               NewEventStream(DefaultEventHistory),
 		stopped:              false,
 	}
 }
@@ -42,6 +45,7 @@ func (h *Handler) Stop() {
 	close(h.sentTransferChan)
 	close(h.receivedTransferChan)
 	close(h.noticeChan)
+	h.Events.Stop()
 }
 
 // GetNoticeChan :
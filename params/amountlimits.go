@@ -0,0 +1,54 @@
+package params
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//TransferAmountLimit bounds of amount of one transfer, in the smallest unit of the token, nil means no bound
+type TransferAmountLimit struct {
+	Min *big.Int
+	Max *big.Int
+}
+
+/*
+ParseTransferAmountLimits 解析每个 token 的交易金额上下限, 格式为逗号分隔的 token:min:max,
+金额是 token 的最小单位, min 或者 max 为空表示不限制, 例如 0x...:1000:, 0x...::5000000.
+*/
+/*
+ *	ParseTransferAmountLimits : parse bounds of transfer amount of each token, comma separated token:min:max,
+ *	amounts are in the smallest unit of the token, empty min or max means no bound, e.g. 0x...:1000:, 0x...::5000000.
+ */
+func ParseTransferAmountLimits(s string) (limits map[common.Address]*TransferAmountLimit, err error) {
+	limits = make(map[common.Address]*TransferAmountLimit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		fields := strings.Split(item, ":")
+		if len(fields) != 3 || !common.IsHexAddress(fields[0]) {
+			return nil, fmt.Errorf("invalid transfer amount limit %q, should be token:min:max", item)
+		}
+		limit := &TransferAmountLimit{}
+		for i, bound := range []**big.Int{&limit.Min, &limit.Max} {
+			f := fields[i+1]
+			if len(f) == 0 {
+				continue
+			}
+			v, ok := new(big.Int).SetString(f, 10)
+			if !ok || v.Sign() < 0 {
+				return nil, fmt.Errorf("invalid amount %q in transfer amount limit %q", f, item)
+			}
+			*bound = v
+		}
+		if limit.Min != nil && limit.Max != nil && limit.Min.Cmp(limit.Max) > 0 {
+			return nil, fmt.Errorf("min is larger than max in transfer amount limit %q", item)
+		}
+		limits[common.HexToAddress(fields[0])] = limit
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransferAmountLimits(t *testing.T) {
+	t1 := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	t2 := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	limits, err := ParseTransferAmountLimits(t1.String() + ":1000:, " + t2.String() + "::5000000")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, limits, 2)
+	assert.EqualValues(t, 1000, limits[t1].Min.Int64())
+	assert.Nil(t, limits[t1].Max)
+	assert.Nil(t, limits[t2].Min)
+	assert.EqualValues(t, 5000000, limits[t2].Max.Int64())
+
+	limits, err = ParseTransferAmountLimits("")
+	assert.Nil(t, err)
+	assert.Len(t, limits, 0)
+
+	for _, s := range []string{
+		t1.String(),
+		"0x12:1:2",
+		t1.String() + ":a:",
+		t1.String() + ":-1:",
+		t1.String() + ":10:9",
+	} {
+		_, err = ParseTransferAmountLimits(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -71,6 +71,22 @@ type Config struct {
 	PfsHost                   string // pathfinder server host
 	HTTPUsername              string
 	HTTPPassword              string
+	//TransferIdempotencyRetention how long identifiers of transfer requests are kept for dedup
+	TransferIdempotencyRetention time.Duration
+	//MaxRouteAttempts initiator gives up after this many routes refused a transfer, 0 means no limit
+	MaxRouteAttempts int
+	//MaxOutboundLocksPerChannel transfers we initiate are queued when a channel has this many unresolved locks we initiated, 0 means no limit
+	MaxOutboundLocksPerChannel int
+	//TransferAmountLimits bounds of amount of transfer requests of each token, tokens not in it are not limited
+	TransferAmountLimits map[common.Address]*TransferAmountLimit
+	//IncomingRateLimit messages per second accepted from every partner, 0 means no limit
+	IncomingRateLimit float64
+	//IncomingRateBurst messages accepted from a partner at once before IncomingRateLimit applies
+	IncomingRateBurst int
+	//IncomingBanThreshold a partner is banned when this many of its messages are dropped in a minute, 0 means never ban
+	IncomingBanThreshold int
+	//IncomingBanDuration how long messages of a banned partner are dropped
+	IncomingBanDuration time.Duration
 }
 
 //DefaultConfig default config
@@ -89,6 +105,11 @@ var DefaultConfig = Config{
 	MsgTimeout:        100 * time.Second,
 	EnableHealthCheck: false,
 	XMPPServer:        DefaultXMPPServer,
+
+	TransferIdempotencyRetention: DefaultTransferIdempotencyRetention,
+	MaxOutboundLocksPerChannel:   DefaultMaxOutboundLocksPerChannel,
+	IncomingRateBurst:            DefaultIncomingRateBurst,
+	IncomingBanDuration:          DefaultIncomingBanDuration,
 }
 
 //ConditionQuit is for test
@@ -0,0 +1,95 @@
+package params
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//DeploymentAddresses contracts deployed on one chain
+type DeploymentAddresses struct {
+	//TokenNetworkRegistry address of TokensNetwork, the same as RegistryAddress in config
+	TokenNetworkRegistry common.Address
+	//SecretRegistry zero address means read it from TokensNetwork.SecretRegistry() on chain
+	SecretRegistry common.Address
+}
+
+//ErrUnknownDeployment no contracts known for the chain
+type ErrUnknownDeployment struct {
+	ChainID *big.Int
+}
+
+func (e *ErrUnknownDeployment) Error() string {
+	return fmt.Sprintf("no known deployment for chain id %s, please specify registry-contract-address", e.ChainID)
+}
+
+/*
+DeploymentRegistry 记录每条链(chain ID)上部署的合约地址, 避免为每个部署手工配置合约地址.
+除了内置的已知部署, 也可以注册自己的私链部署.
+*/
+/*
+ *	DeploymentRegistry : contract addresses deployed on each chain, indexed by chain ID,
+ *	so contract addresses don't need to be configured by hand for every deployment.
+ *	Besides the known deployments, custom ones, e.g. private chains, can be registered.
+ */
+type DeploymentRegistry struct {
+	lock        sync.RWMutex
+	deployments map[string]*DeploymentAddresses
+}
+
+//NewDeploymentRegistry create a registry with deployments, key is the chain id
+func NewDeploymentRegistry(deployments map[int64]*DeploymentAddresses) *DeploymentRegistry {
+	r := &DeploymentRegistry{deployments: make(map[string]*DeploymentAddresses)}
+	for chainID, d := range deployments {
+		r.deployments[big.NewInt(chainID).String()] = d
+	}
+	return r
+}
+
+//Lookup contracts deployed on chainID, returns ErrUnknownDeployment if there is none
+func (r *DeploymentRegistry) Lookup(chainID *big.Int) (*DeploymentAddresses, error) {
+	if chainID == nil {
+		return nil, &ErrUnknownDeployment{}
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	d, ok := r.deployments[chainID.String()]
+	if !ok {
+		return nil, &ErrUnknownDeployment{ChainID: chainID}
+	}
+	c := *d
+	return &c, nil
+}
+
+//Register add or replace contracts deployed on chainID
+func (r *DeploymentRegistry) Register(chainID *big.Int, d *DeploymentAddresses) error {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return fmt.Errorf("invalid chain id %s", chainID)
+	}
+	if d == nil || d.TokenNetworkRegistry == (common.Address{}) {
+		return fmt.Errorf("deployment of chain %s has no token network registry", chainID)
+	}
+	c := *d
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.deployments[chainID.String()] = &c
+	return nil
+}
+
+//SpectrumChainID chain id of spectrum main net
+const SpectrumChainID = 20180430
+
+//SpectrumTestnetChainID chain id of spectrum test net, ropsten has the same one but is known by its genesis block first
+const SpectrumTestnetChainID = 3
+
+//Deployments known deployments, custom ones can be added by Register
+var Deployments = NewDeploymentRegistry(map[int64]*DeploymentAddresses{
+	SpectrumChainID: {
+		TokenNetworkRegistry: common.HexToAddress("0x08b7d79ec4ebd53e5b89c7c062cc64bb09d063e3"),
+	},
+	SpectrumTestnetChainID: {
+		TokenNetworkRegistry: common.HexToAddress("0xc479184abeb8c508ee96e4c093ee47af2256cbbf"),
+	},
+})
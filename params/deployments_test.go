@@ -0,0 +1,52 @@
+package params
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentsLookupKnown(t *testing.T) {
+	d, err := Deployments.Lookup(big.NewInt(SpectrumChainID))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, common.HexToAddress("0x08b7d79ec4ebd53e5b89c7c062cc64bb09d063e3"), d.TokenNetworkRegistry)
+	//callers can't modify the registry through the result
+	d.TokenNetworkRegistry = common.Address{}
+	d, _ = Deployments.Lookup(big.NewInt(SpectrumChainID))
+	assert.NotEqual(t, common.Address{}, d.TokenNetworkRegistry)
+	//the same registry as the default one of spectrum test net
+	d, err = Deployments.Lookup(big.NewInt(SpectrumTestnetChainID))
+	if !assert.Nil(t, err) {
+		return
+	}
+	testnetGenesis := common.HexToHash("0xd011e2cc7f241996a074e2c48307df3971f5f1fe9e1f00cfa704791465d5efc3")
+	assert.Equal(t, GenesisBlockHashToDefaultRegistryAddress[testnetGenesis], d.TokenNetworkRegistry)
+}
+
+func TestDeploymentsLookupUnknown(t *testing.T) {
+	r := NewDeploymentRegistry(nil)
+	_, err := r.Lookup(big.NewInt(SpectrumChainID))
+	assert.IsType(t, &ErrUnknownDeployment{}, err)
+	_, err = r.Lookup(nil)
+	assert.IsType(t, &ErrUnknownDeployment{}, err)
+	_, err = Deployments.Lookup(big.NewInt(123456789))
+	assert.IsType(t, &ErrUnknownDeployment{}, err)
+}
+
+func TestDeploymentsRegister(t *testing.T) {
+	r := NewDeploymentRegistry(nil)
+	custom := &DeploymentAddresses{
+		TokenNetworkRegistry: common.HexToAddress("0x1"),
+		SecretRegistry:       common.HexToAddress("0x2"),
+	}
+	assert.NotNil(t, r.Register(big.NewInt(0), custom))
+	assert.NotNil(t, r.Register(big.NewInt(TestPrivateChainID), &DeploymentAddresses{}))
+	assert.Nil(t, r.Register(big.NewInt(TestPrivateChainID), custom))
+	d, err := r.Lookup(big.NewInt(TestPrivateChainID))
+	assert.Nil(t, err)
+	assert.EqualValues(t, custom, d)
+}
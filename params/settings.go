@@ -44,6 +44,11 @@ const DefaultInitialChannelTarget = 3
 
 //DefaultTxTimeout args
 const DefaultTxTimeout = 5 * time.Minute //15seconds for one block,it may take sever minutes
+
+//ReceiptPollInterval how often receipts of txs we sent are queried while waiting for them to be mined,
+//new heads are used instead if geth supports subscription. Set by --eth-receipt-poll-interval
+var ReceiptPollInterval = time.Second
+
 //MaxRequestTimeout args
 const MaxRequestTimeout = 20 * time.Minute //longest time for a request ,for example ,settle all channles?
 
@@ -182,6 +187,24 @@ var TestPrivateChainID2 int64 = 7888
 // EthRPCTimeout :
 var EthRPCTimeout = 3 * time.Second
 
+// EthReconnectWaitTimeout : 发送交易时如果正在重连 geth, 最多等待多久
+var EthReconnectWaitTimeout = 3 * time.Second
+
+// DefaultEthCircuitBreakerCoolDown : 连续出错导致 eth rpc 熔断以后,多久再尝试一次
+var DefaultEthCircuitBreakerCoolDown = 60 * time.Second
+
+// DefaultMaxOutboundLocksPerChannel : 一个通道上我们发起的未完成的锁最多有多少个, 更多的交易排队等待, 0 表示不限制
+var DefaultMaxOutboundLocksPerChannel = 0
+
+// DefaultTransferIdempotencyRetention : 交易请求的客户端标识保留多久,超过以后同一个标识会发起新的交易
+var DefaultTransferIdempotencyRetention = 24 * time.Hour
+
+// DefaultIncomingRateBurst : 限速时一个 partner 最多可以一次发来多少个消息
+var DefaultIncomingRateBurst = 50
+
+// DefaultIncomingBanDuration : partner 被禁止以后多久以内丢弃它的所有消息
+var DefaultIncomingBanDuration = 10 * time.Minute
+
 // ContractVersionPrefix :
 var ContractVersionPrefix = "0.6"
 
@@ -193,3 +216,24 @@ var ForkConfirmNumber int64 = 17
 
 // MaxTransferDataLen : 交易附件信息最大长度
 var MaxTransferDataLen = 256
+
+// MaxTransferMetadataLen : mediated transfer 携带的 metadata 最大长度
+var MaxTransferMetadataLen = 256
+
+// MaxTransferIdentifierLen : 交易请求客户端标识最大长度
+var MaxTransferIdentifierLen = 128
+
+// TransferWebhookMaxAttempts : 交易完成通知 webhook 最多尝试投递几次
+var TransferWebhookMaxAttempts = 5
+
+// TransferWebhookRetryInterval : webhook 第一次重试的间隔,之后每次加倍
+var TransferWebhookRetryInterval = 2 * time.Second
+
+// TransferWebhookTimeout : 每次投递 webhook 的超时时间
+var TransferWebhookTimeout = 10 * time.Second
+
+// MonitorDelegateTimeout : 委托监控时等待 monitor 接受委托的最长时间
+var MonitorDelegateTimeout = 2 * time.Minute
+
+// InitiatorAckTimeout : 发起方等待第一跳确认 MediatedTransfer 的最长时间, 超时以后可以换新密码重新发起的交易不再等待
+var InitiatorAckTimeout = 30 * time.Second
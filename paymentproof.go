@@ -0,0 +1,118 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+交易证明:
+发起方和接收方在交易过程中把证明需要的消息保存在交易记录中, 交易成功以后可以通过 models.TransferRecord.ExportPaymentProof 导出,
+任何人都可以用 models.VerifyPaymentProof 离线验证. 中转节点不保存.
+1. 发送/收到 MediatedTransfer 时保存 LockedTransfer, 发起方换路由时覆盖
+2. 发送/收到 Unlock 时保存 Unlock 之前付款方的 balance proof, 锁的 merkle proof 和 Unlock
+3. 收到对方的 RevealSecret 时保存
+*/
+/*
+ *	Payment proofs:
+ *	initiator and target keep messages needed by the proof in the transfer record while the transfer goes on,
+ *	after success it's exported by models.TransferRecord.ExportPaymentProof and anyone can verify it offline
+ *	by models.VerifyPaymentProof. Mediators don't keep them.
+ *	1. LockedTransfer is kept when MediatedTransfer is sent or received, it's replaced when initiator tries another route.
+ *	2. balance proof of payer before Unlock, merkle proof of the lock and Unlock are kept when Unlock is sent or received.
+ *	3. RevealSecret from the other party is kept when received.
+ */
+
+//updatePaymentProof apply f to proof of an unfinished transfer of which we are initiator or target
+func (rs *Service) updatePaymentProof(tokenAddress common.Address, lockSecretHash common.Hash, f func(p *models.PaymentProof)) {
+	lockSecretHash = rs.relocks.originOf(lockSecretHash)
+	r, err := rs.getTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil || r.Finished() || (r.Role != models.TransferRoleInitiator && r.Role != models.TransferRoleTarget) {
+		return
+	}
+	if r.Proof == nil {
+		r.Proof = &models.PaymentProof{}
+	}
+	f(r.Proof)
+	r.UpdateTime = time.Now().Unix()
+	err = rs.saveTransferRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTransferRecord %s err %s", utils.HPex(lockSecretHash), err))
+	}
+}
+
+//keepLockedTransferProof mtr is sent from payer to payee, previous route is forgotten
+func (rs *Service) keepLockedTransferProof(tokenAddress common.Address, mtr *encoding.MediatedTransfer, payer, payee common.Address) {
+	rs.updatePaymentProof(tokenAddress, mtr.LockSecretHash, func(p *models.PaymentProof) {
+		*p = models.PaymentProof{
+			LockSecretHash: mtr.LockSecretHash,
+			Payer:          payer,
+			Payee:          payee,
+			LockedTransfer: mtr.Pack(),
+		}
+	})
+}
+
+/*
+paymentProofBeforeUnlock 在 Unlock 注册到通道之前调用, 返回付款方当前的 balance proof 和锁的 merkle proof.
+payerState 是付款方在通道中的状态, 发起方是 OurState, 接收方是 PartnerState.
+*/
+/*
+ *	paymentProofBeforeUnlock : called before Unlock is registered to the channel,
+ *	returns current balance proof of payer and merkle proof of the lock.
+ *	payerState is the end state of payer, OurState for initiator and PartnerState for target.
+ */
+func (rs *Service) paymentProofBeforeUnlock(tokenAddress common.Address, lockSecretHash common.Hash, payerState *channel.EndState) (before *models.SignedBalanceProof, merkleProof []common.Hash) {
+	r, err := rs.getTransferRecord(tokenAddress, rs.relocks.originOf(lockSecretHash))
+	if err != nil || r.Proof == nil || len(r.Proof.LockedTransfer) == 0 {
+		return
+	}
+	mtr := new(encoding.MediatedTransfer)
+	err = mtr.UnPack(r.Proof.LockedTransfer)
+	if err != nil {
+		log.Error(fmt.Sprintf("unpack LockedTransfer of payment proof %s err %s", utils.HPex(lockSecretHash), err))
+		return
+	}
+	merkleProof = channel.ComputeProofForLock(mtr.GetLock(), payerState.Tree).MerkleProof
+	bp := payerState.BalanceProofState
+	before = &models.SignedBalanceProof{
+		Nonce:             bp.Nonce,
+		ChannelIdentifier: bp.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:   bp.ChannelIdentifier.OpenBlockNumber,
+		TransferAmount:    new(big.Int).Set(bp.TransferAmount),
+		Locksroot:         bp.LocksRoot,
+		MessageHash:       bp.MessageHash,
+		Signature:         bp.Signature,
+	}
+	return
+}
+
+//keepUnlockProof unlock has been registered, before and merkleProof are returned by paymentProofBeforeUnlock
+func (rs *Service) keepUnlockProof(tokenAddress common.Address, unlock *encoding.UnLock, before *models.SignedBalanceProof, merkleProof []common.Hash) {
+	if before == nil {
+		return
+	}
+	rs.updatePaymentProof(tokenAddress, unlock.LockSecretHash(), func(p *models.PaymentProof) {
+		p.Secret = unlock.LockSecret
+		p.BalanceProofBefore = before
+		p.MerkleProof = merkleProof
+		p.Unlock = unlock.Pack()
+	})
+}
+
+//keepRevealSecretProof RevealSecret from the other party of the channel
+func (rs *Service) keepRevealSecretProof(tokenAddress common.Address, msg *encoding.RevealSecret) {
+	rs.updatePaymentProof(tokenAddress, msg.LockSecretHash(), func(p *models.PaymentProof) {
+		if msg.Sender != rs.NodeAddress && (msg.Sender == p.Payer || msg.Sender == p.Payee) {
+			p.RevealSecret = msg.Pack()
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package photon
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentProof(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:         dao,
+		NodeAddress: utils.NewRandomAddress(),
+	}
+	api := NewPhotonAPI(rs)
+	payerKey, _ := crypto.GenerateKey()
+	payer := crypto.PubkeyToAddress(payerKey.PublicKey)
+	initiator := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	secret := utils.NewRandomHash()
+	lock := &mtree.Lock{
+		Expiration:     1000,
+		Amount:         big.NewInt(30),
+		LockSecretHash: utils.ShaSecret(secret[:]),
+	}
+	otherLock := &mtree.Lock{
+		Expiration:     1000,
+		Amount:         big.NewInt(7),
+		LockSecretHash: utils.NewRandomHash(),
+	}
+	tree := mtree.NewMerkleTree([]*mtree.Lock{otherLock, lock})
+	channelID := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	// payer has transferred 100 before this transfer
+	bp := encoding.NewBalanceProof(5, big.NewInt(100), tree.MerkleRoot(), channelID)
+	mtr := encoding.NewMediatedTransfer(bp, lock, rs.NodeAddress, initiator, big.NewInt(0))
+	assert.Nil(t, mtr.Sign(payerKey, mtr))
+
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lock.LockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleTarget,
+		Initiator:      initiator,
+		Target:         rs.NodeAddress,
+		Amount:         big.NewInt(30),
+		Phase:          models.TransferPhaseWaitingReveal,
+	})
+	rs.keepLockedTransferProof(token, mtr, payer, rs.NodeAddress)
+	reveal := encoding.NewRevealSecret(secret)
+	assert.Nil(t, reveal.Sign(payerKey, reveal))
+	rs.keepRevealSecretProof(token, reveal)
+	payerState := channel.NewChannelEndState(payer, big.NewInt(1000), transfer.NewBalanceProofStateFromEnvelopMessage(mtr), tree)
+	before, merkleProof := rs.paymentProofBeforeUnlock(token, lock.LockSecretHash, payerState)
+	treeWithoutLock, err := tree.ComputeMerkleRootWithout(lock)
+	assert.Nil(t, err)
+	unlock := encoding.NewUnlock(encoding.NewBalanceProof(6, big.NewInt(130), treeWithoutLock.MerkleRoot(), channelID), secret)
+	assert.Nil(t, unlock.Sign(payerKey, unlock))
+	rs.keepUnlockProof(token, unlock, before, merkleProof)
+
+	// not finished yet
+	_, err = api.ExportPaymentProof(token, rs.NodeAddress, lock.LockSecretHash.String())
+	assert.Equal(t, rerr.ErrPaymentProofNotFound, err)
+	rs.setTransferPhase(token, lock.LockSecretHash, models.TransferPhaseSuccess)
+	proof, err := api.ExportPaymentProof(token, rs.NodeAddress, lock.LockSecretHash.String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	// survives a round trip through the exported file
+	data, err := json.Marshal(proof)
+	assert.Nil(t, err)
+	var p models.PaymentProof
+	assert.Nil(t, json.Unmarshal(data, &p))
+	verdict := models.VerifyPaymentProof(&p)
+	assert.True(t, verdict.Valid, verdict.String())
+	assert.Equal(t, payer, p.Payer)
+	assert.Equal(t, 0, params.ChainID.Cmp(p.ChainID))
+	assert.NotEmpty(t, p.RevealSecret)
+
+	tamper := func(f func(p *models.PaymentProof)) *models.PaymentProofVerdict {
+		cp := p
+		f(&cp)
+		return models.VerifyPaymentProof(&cp)
+	}
+	// more than the lock
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.Amount = big.NewInt(31) }).Valid)
+	// signed for another chain
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.ChainID = big.NewInt(8888) }).Valid)
+	// someone else claims to be payer
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.Payer = initiator }).Valid)
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.Secret = utils.NewRandomHash() }).Valid)
+	// lock is not in locksroot
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.MerkleProof = []common.Hash{utils.NewRandomHash()} }).Valid)
+	// unlock which doesn't move the lock amount
+	wrongUnlock := encoding.NewUnlock(encoding.NewBalanceProof(6, big.NewInt(129), treeWithoutLock.MerkleRoot(), channelID), secret)
+	assert.Nil(t, wrongUnlock.Sign(payerKey, wrongUnlock))
+	verdict = tamper(func(p *models.PaymentProof) { p.Unlock = wrongUnlock.Pack() })
+	assert.False(t, verdict.Valid)
+	// unlock signed by someone else
+	otherKey, _ := crypto.GenerateKey()
+	assert.Nil(t, unlock.Sign(otherKey, unlock))
+	assert.False(t, tamper(func(p *models.PaymentProof) { p.Unlock = unlock.Pack() }).Valid)
+	// a mediator keeps no proof
+	lockSecretHash := utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleMediator,
+		Target:         rs.NodeAddress,
+		Amount:         big.NewInt(30),
+		Phase:          models.TransferPhaseSuccess,
+	})
+	_, err = api.ExportPaymentProof(token, rs.NodeAddress, lockSecretHash.String())
+	assert.Equal(t, rerr.ErrPaymentProofNotFound, err)
+}
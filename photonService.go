@@ -8,6 +8,7 @@ import (
 
 	"time"
 
+	"sync"
 	"sync/atomic"
 
 	"math/big"
@@ -16,6 +17,8 @@ import (
 
 	"os"
 
+	"path/filepath"
+
 	"runtime/debug"
 
 	"github.com/SmartMeshFoundation/Photon/blockchain"
@@ -97,6 +100,7 @@ type Service struct {
 	Transfer2StateManager map[common.Hash]*transfer.StateManager
 	Transfer2Result       map[common.Hash]*utils.AsyncResult
 	SwapKey2TokenSwap     map[swapKey]*TokenSwap
+	LockSecretHash2Offer  map[common.Hash]*TokenSwap //maker's token swap offers not started yet
 	/*
 		   This is a map from a hashlock to a list of channels, the same
 			 hashlock can be used in more than one token (for tokenswaps), a
@@ -130,6 +134,19 @@ type Service struct {
 	StopCreateNewTransfers                bool // 是否停止接收新交易,默认false,目前仅在用户调用prepare-update接口的时候,会被置为true,直到重启		// boolean to check whether stop receiving new transfers, default to false. Currently it sets to true when clients invoke prepare-update, till it reconnects.
 	EthConnectionStatus                   chan netshare.Status
 	ChanHistoryContractEventsDealComplete chan struct{}
+	topUpLock                             sync.Mutex
+	topUpInFlight                         map[common.Hash]bool                          //channels waiting for an automatic deposit
+	topUpPolicyCache                      map[common.Hash]*models.TopUpPolicy           //all top-up policies, nil before loaded, guarded by topUpLock
+	monitorDue                            map[common.Hash]int64                         //closed channels we monitor for others, to the first block we can submit proofs
+	updateWatcher                         *updateWatcher                                //watches UpdateBalanceProof we submitted
+	expirationQueue                       *expirationQueue                              //when to dispatch new block to state managers
+	transferWatchers                      map[common.Hash][]chan *models.TransferRecord //status updates of transfers, key is same as Transfer2StateManager
+	transferRecords                       *transferRecordCache                          //unfinished transfer records
+	outboundQueue                         *outboundQueue                                //transfers we initiate waiting for busy channels
+	secretRegistrar                       *secretRegistrar                              //secrets to register on chain before incoming locks expire
+	tokenDecimals                         *tokenDecimals                                //decimals of registered tokens
+	relocks                               *relockTracker                                //transfers which can be started again with a fresh secret
+	invariantConfig                       *channel.InvariantConfig                      //shared by all channels, how to check and report invariant violations
 }
 
 //NewPhotonService create photon service
@@ -149,6 +166,7 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		Transfer2Result:                       make(map[common.Hash]*utils.AsyncResult),
 		Token2LockSecretHash2Channels:         make(map[common.Address]map[common.Hash][]*channel.Channel),
 		SwapKey2TokenSwap:                     make(map[swapKey]*TokenSwap),
+		LockSecretHash2Offer:                  make(map[common.Hash]*TokenSwap),
 		UserReqChan:                           make(chan *apiReq, 10),
 		BlockNumber:                           new(atomic.Value),
 		ProtocolMessageSendComplete:           make(chan *protocolMessage, 10),
@@ -162,11 +180,34 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		StopCreateNewTransfers:                false,
 		EthConnectionStatus:                   make(chan netshare.Status, 10),
 		ChanHistoryContractEventsDealComplete: make(chan struct{}),
+		topUpInFlight:                         make(map[common.Hash]bool),
+		outboundQueue:                         newOutboundQueue(),
+		relocks:                               newRelockTracker(),
+		transferWatchers:                      make(map[common.Hash][]chan *models.TransferRecord),
+		transferRecords:                       newTransferRecordCache(),
 	}
 	rs.BlockNumber.Store(int64(0))
+	rs.updateWatcher = newUpdateWatcher(rs.balanceProofNonceOnChain, rs.onBalanceProofReplaced)
+	rs.expirationQueue = newExpirationQueue()
+	rs.secretRegistrar = newSecretRegistrar(dao, func(secrets []common.Hash, gasPrice *big.Int) *utils.AsyncResult {
+		return rs.Chain.SecretRegistryProxy.RegisterSecretsAsync(secrets, gasPrice)
+	}, func() (*big.Int, error) {
+		return rs.Chain.Client.SuggestGasPrice(rpc.GetQueryConext())
+	})
+	rs.tokenDecimals = newTokenDecimals(rs.fetchTokenDecimals)
 	rs.MessageHandler = newPhotonMessageHandler(rs)
 	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
 	rs.Protocol = network.NewPhotonProtocol(transport, privateKey, rs)
+	if config.IncomingRateLimit > 0 {
+		rs.Protocol.RateLimiter = network.NewPeerRateLimiter(config.IncomingRateLimit, config.IncomingRateBurst, config.IncomingBanThreshold, config.IncomingBanDuration)
+		rs.Protocol.RateLimiter.OnThrottle = func(peer common.Address, banned bool) {
+			if banned {
+				rs.NotifyHandler.Notify(notify.LevelWarn, fmt.Sprintf("节点 %s 发送消息过多,%s 内丢弃它的所有消息", peer.String(), config.IncomingBanDuration))
+				return
+			}
+			rs.NotifyHandler.Notify(notify.LevelWarn, fmt.Sprintf("节点 %s 发送消息过快,超出的消息被丢弃", peer.String()))
+		}
+	}
 	//todo fixme MatrixTransport should have a better contructor function
 	mtransport, ok := rs.Transport.(*network.MatrixMixTransport)
 	if ok {
@@ -205,6 +246,14 @@ func NewPhotonService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 	} else {
 		rs.FeePolicy = &NoFeePolicy{}
 	}
+	/*
+		通道状态检查失败时,保存冻结状态并通知用户
+	*/
+	rs.invariantConfig = &channel.InvariantConfig{
+		FullCheck:   config.Debug,
+		SnapshotDir: filepath.Dir(config.DataBasePath),
+		OnViolation: rs.onChannelFrozen,
+	}
 	return rs, nil
 }
 
@@ -226,6 +275,7 @@ func (rs *Service) Start() (err error) {
 	rs.Protocol.Start(false)
 	//restore 一定要在历史事件处理之前进行,比如链上注册密码事件,需要相应的statemanager发送unlock消息
 	rs.restore()
+	rs.removeExpiredTransferIdentifiers()
 	go func() {
 		if rs.Config.ConditionQuit.RandomQuit {
 			go func() {
@@ -392,6 +442,7 @@ func (rs *Service) loop() {
 			default:
 				//never block
 			}
+			rs.publishChainConnectionEvent(s)
 			if s == netshare.Connected {
 				rs.handleEthRPCConnectionOK()
 			} else {
@@ -401,6 +452,8 @@ func (rs *Service) loop() {
 			log.Info(fmt.Sprintf("%s quit now", utils.APex2(rs.NodeAddress)))
 			return
 		}
+		rs.drainTransferQueues()
+		rs.registerSecrets()
 	}
 }
 
@@ -446,7 +499,8 @@ func (rs *Service) newChannelFromEvent(tokenNetwork *rpc.TokenNetworkProxy, toke
 	partenerState := channel.NewChannelEndState(partnerAddress, big.NewInt(0), nil, mtree.NewMerkleTree(nil))
 
 	externState := channel.NewChannelExternalState(rs.registerChannelForHashlock, tokenNetwork, channelIdentifier, rs.PrivateKey, rs.Chain.Client, rs.dao, 0, rs.NodeAddress, partnerAddress)
-	ch, err = channel.NewChannel(ourState, partenerState, externState, tokenAddress, channelIdentifier, rs.Config.RevealTimeout, settleTimeout)
+	externState.Invariant = rs.invariantConfig
+	ch, err = channel.NewChannel(ourState, partenerState, externState, tokenAddress, channelIdentifier, rs.revealTimeoutFor(tokenAddress, channelIdentifier.ChannelIdentifier, settleTimeout), settleTimeout)
 	return
 }
 
@@ -456,7 +510,8 @@ it's the core of HTLC
 */
 func (rs *Service) handleBlockNumber(st *transfer.BlockStateChange) {
 	rs.BlockNumber.Store(st.BlockNumber)
-	rs.StateMachineEventHandler.dispatchToAllTasks(st)
+	rs.StateMachineEventHandler.dispatchToExpiredTasks(st)
+	rs.expireQueuedTransfers(st.BlockNumber)
 	for _, cg := range rs.Token2ChannelGraph {
 		for _, c := range cg.ChannelIdentifier2Channel {
 			err := rs.StateMachineEventHandler.ChannelStateTransition(c, st)
@@ -558,14 +613,20 @@ Register the secret with any channel that has a hashlock on it.
        and ignoring the tokens.
 */
 func (rs *Service) registerSecret(secret common.Hash) {
-	hashlock := utils.ShaSecret(secret[:])
-	for _, hashchannel := range rs.Token2LockSecretHash2Channels {
-		for _, ch := range hashchannel[hashlock] {
-			err := ch.RegisterSecret(secret)
-			err = rs.dao.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
-			if err != nil {
-				log.Error(fmt.Sprintf("RegisterSecret %s to channel %s  err: %s",
-					utils.HPex(secret), ch.ChannelIdentifier.String(), err))
+	//channel checks the hash algorithm of its lock, so try every algorithm here
+	for _, a := range utils.HashAlgorithms {
+		hashlock := a.HashSecret(secret[:])
+		for _, hashchannel := range rs.Token2LockSecretHash2Channels {
+			if len(hashchannel[hashlock]) > 0 {
+				rs.markSecretLearned(hashlock)
+			}
+			for _, ch := range hashchannel[hashlock] {
+				err := ch.RegisterSecret(secret)
+				err = rs.dao.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
+				if err != nil {
+					log.Error(fmt.Sprintf("RegisterSecret %s to channel %s  err: %s",
+						utils.HPex(secret), ch.ChannelIdentifier.String(), err))
+				}
 			}
 		}
 	}
@@ -577,6 +638,9 @@ func (rs *Service) registerSecret(secret common.Hash) {
 // The secret of this lock has been registered on-chain.
 func (rs *Service) registerRevealedLockSecretHash(lockSecretHash, secret common.Hash, blockNumber int64) {
 	for _, hashchannel := range rs.Token2LockSecretHash2Channels {
+		if len(hashchannel[lockSecretHash]) > 0 {
+			rs.markSecretLearned(lockSecretHash)
+		}
 		for _, ch := range hashchannel[lockSecretHash] {
 			err := ch.RegisterRevealedSecretHash(lockSecretHash, secret, blockNumber)
 			if err != nil {
@@ -616,14 +680,15 @@ func (rs *Service) registerChannelForHashlock(netchannel *channel.Channel, lockS
 }
 func (rs *Service) channelSerilization2Channel(c *channeltype.Serialization, tokenNetwork *rpc.TokenNetworkProxy) (ch *channel.Channel, err error) {
 	OurState := channel.NewChannelEndState(c.OurAddress, c.OurContractBalance,
-		c.OurBalanceProof, mtree.NewMerkleTree(c.OurLeaves))
+		c.OurBalanceProof, mtree.RestoreMerkleTree(c.OurTree, c.OurLeaves, locksRootOf(c.OurBalanceProof)))
 	PartnerState := channel.NewChannelEndState(c.PartnerAddress(),
 		c.PartnerContractBalance,
-		c.PartnerBalanceProof, mtree.NewMerkleTree(c.PartnerLeaves))
+		c.PartnerBalanceProof, mtree.RestoreMerkleTree(c.PartnerTree, c.PartnerLeaves, locksRootOf(c.PartnerBalanceProof)))
 	ExternState := channel.NewChannelExternalState(rs.registerChannelForHashlock, tokenNetwork,
 		c.ChannelIdentifier, rs.PrivateKey,
 		rs.Chain.Client, rs.dao, c.ClosedBlock,
 		c.OurAddress, c.PartnerAddress())
+	ExternState.Invariant = rs.invariantConfig
 	ch, err = channel.NewChannel(OurState, PartnerState, ExternState, c.TokenAddress(), c.ChannelIdentifier, c.RevealTimeout, c.SettleTimeout)
 	if err != nil {
 		return
@@ -638,9 +703,32 @@ func (rs *Service) channelSerilization2Channel(c *channeltype.Serialization, tok
 	ch.PartnerState.ContractBalance = c.PartnerContractBalance
 	ch.ExternState.ClosedBlock = c.ClosedBlock
 	ch.ExternState.SettledBlock = c.SettledBlock
+	ch.RestoreNonceGap(c.PartnerNonceGap)
+	ch.RestoreFrozen(c.FrozenReason)
 	return
 }
 
+/*
+onChannelFrozen 通道状态检查失败,立即保存冻结状态,重启以后通道依然是冻结的,然后通知用户
+*/
+/*
+ *	onChannelFrozen : channel violates invariants, save the freeze at once so it survives restart, then notify user.
+ */
+func (rs *Service) onChannelFrozen(c *channel.Channel, err error) {
+	err2 := rs.dao.UpdateChannelNoTx(channel.NewChannelSerialization(c))
+	if err2 != nil {
+		log.Error(fmt.Sprintf("save frozen channel %s err %s", c.ChannelIdentifier.String(), err2))
+	}
+	rs.NotifyHandler.Notify(notify.LevelError, fmt.Sprintf("通道 %s 状态错误,已冻结: %s", c.ChannelIdentifier.String(), err))
+}
+
+func locksRootOf(bp *transfer.BalanceProofState) common.Hash {
+	if bp == nil {
+		return utils.EmptyHash
+	}
+	return bp.LocksRoot
+}
+
 //read a token network info from dao
 func (rs *Service) registerTokenNetwork(tokenAddress common.Address) (err error) {
 	log.Trace(fmt.Sprintf("registerTokenNetwork tokenaddress=%s ", tokenAddress.String()))
@@ -739,8 +827,36 @@ Do a direct tranfer with target.
        are required to complete the transfer (from the payer's perspective),
        whereas the mediated transfer requires 6 messages.
 */
+/*
+checkDirectChannel 检查能否直接通过与 target 的通道完成这笔交易,
+不能的话, 用户没有要求只走直接通道时, 会改为在其他通道上找路由进行 mediated transfer.
+*/
+/*
+ *	checkDirectChannel : check whether the direct channel with target can cover this transfer,
+ *	if not, and user doesn't force direct-only, mediated transfer is used instead.
+ */
+func (rs *Service) checkDirectChannel(tokenAddress, target common.Address, amount *big.Int) error {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return errors.New("token not exist")
+	}
+	c := g.GetPartenerAddress2Channel(target)
+	if c == nil || !c.CanTransfer() {
+		return errors.New("no available direct channel")
+	}
+	if c.Distributable().Cmp(amount) < 0 {
+		return fmt.Errorf("direct channel has not enough balance, distributable=%s,amount=%s", c.Distributable(), amount)
+	}
+	if _, isOnline := rs.Protocol.GetNetworkStatus(target); !isOnline {
+		return errors.New("partner is offline")
+	}
+	return nil
+}
+
 func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amount *big.Int, data string) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
+	//set before any result is sent, callers may read it as soon as Result is ready
+	result.DirectTransfer = true
 	g := rs.getToken2ChannelGraph(tokenAddress)
 	if g == nil {
 		result.Result <- errors.New("token not exist")
@@ -779,6 +895,17 @@ func (rs *Service) directTransferAsync(tokenAddress, target common.Address, amou
 	tr.FakeLockSecretHash = utils.NewRandomHash()
 	log.Trace(fmt.Sprintf("send direct transfer, use fake lockSecertHash %s to trace transfer status", tr.FakeLockSecretHash.String()))
 	rs.dao.NewTransferStatus(tokenAddress, tr.FakeLockSecretHash)
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: tr.FakeLockSecretHash,
+		TokenAddress:   tokenAddress,
+		Role:           models.TransferRoleInitiator,
+		Initiator:      rs.NodeAddress,
+		Target:         target,
+		Amount:         amount,
+		Fee:            utils.BigInt0,
+		Route:          []common.Address{rs.NodeAddress, target},
+		Phase:          models.TransferPhaseWaitingUnlock,
+	})
 	err = rs.sendAsync(directChannel.PartnerState.Address, tr)
 	if err != nil {
 		result.Result <- err
@@ -827,32 +954,69 @@ Calls:
  *			2.1 taker should contain lockSecretHash, but no secret.
  *			2.2 maker should contain lockSecretHash and secret.
  */
-func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Address, amount *big.Int, fee *big.Int, lockSecretHash common.Hash, expiration int64, secret common.Hash, data string) (result *utils.AsyncResult, stateManager *transfer.StateManager) {
+func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Address, amount *big.Int, fee, maxFee *big.Int, lockSecretHash common.Hash, expiration, deadline int64, secret common.Hash, data string) (result *utils.AsyncResult, stateManager *transfer.StateManager) {
+	t := &outboundTransfer{
+		tokenAddress:   tokenAddress,
+		target:         target,
+		amount:         amount,
+		fee:            fee,
+		maxFee:         maxFee,
+		lockSecretHash: lockSecretHash,
+		secret:         secret,
+		expiration:     expiration,
+		deadline:       deadline,
+		data:           data,
+		result:         utils.NewAsyncResult(),
+	}
+	return t.result, rs.initiateTransfer(t, false)
+}
+
+/*
+initiateTransfer 为 t 选择路由并启动发起方状态机.
+queueable 为 true 时, 如果所有路由的第一跳通道上我们发起的未完成的锁都已经达到上限, t 排到最优路由的通道的队列中,
+等这个通道上的锁完成以后再启动, 此时返回 nil.
+*/
+/*
+ *	initiateTransfer : choose routes for t and start initiator state machine.
+ *	If queueable is true and every route's first channel already has as many locks we initiated as allowed,
+ *	t is queued on the channel of the best route and started when locks on it are resolved, nil is returned then.
+ */
+func (rs *Service) initiateTransfer(t *outboundTransfer, queueable bool) (stateManager *transfer.StateManager) {
 	var availableRoutes []*route.State
 	var err error
+	tokenAddress, target, amount, fee, lockSecretHash := t.tokenAddress, t.target, t.amount, t.fee, t.lockSecretHash
 	targetAmount := new(big.Int).Sub(amount, fee)
-	result = utils.NewAsyncResult()
+	result := t.result
 	if rs.PfsProxy != nil {
 		availableRoutes, err = rs.getBestRoutesFromPfs(rs.NodeAddress, target, tokenAddress, targetAmount, true)
 		if err != nil {
 			result.Result <- errors.New("get route from pathfinder failed")
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureNoRoute, "get route from pathfinder failed")
 			return
 		}
+		availableRoutes = excludeRoutes(availableRoutes, t.excludeHops)
 	} else {
 		g := rs.getToken2ChannelGraph(tokenAddress)
 		if g == nil {
 			result.Result <- errors.New("token not exist")
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureNoRoute, "token not exist")
 			return
 		}
-		availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, targetAmount, graph.EmptyExlude, rs)
+		excludeHops := graph.EmptyExlude
+		if t.excludeHops != nil {
+			excludeHops = t.excludeHops
+		}
+		availableRoutes = g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, targetAmount, excludeHops, rs)
 	}
 	//log.Trace(fmt.Sprintf("availableRoutes=%s", utils.StringInterface(availableRoutes, 3)))
 	if len(availableRoutes) <= 0 {
 		result.Result <- errors.New("no available route")
+		rs.failTransferRecord(tokenAddress, lockSecretHash, rs.noRouteReason(tokenAddress, target, amount), "no available route")
 		return
 	}
 	if rs.Config.IsMeshNetwork {
 		result.Result <- errors.New("no mediated transfer on mesh only network")
+		rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureNoRoute, "no mediated transfer on mesh only network")
 		return
 	}
 	/*
@@ -863,31 +1027,56 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 			r.TotalFee = fee //use the user's fee to replace algorithm's
 		}
 	}
+	if t.maxFee != nil {
+		availableRoutes, err = filterRoutesByMaxFee(availableRoutes, t.maxFee)
+		if err != nil {
+			result.Result <- err
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureFeeCapExceeded, err.Error())
+			return
+		}
+	}
+	if queueable && rs.Config.MaxOutboundLocksPerChannel > 0 {
+		routes := rs.routesWithRoom(availableRoutes)
+		if len(routes) == 0 {
+			rs.queueTransfer(availableRoutes[0].ChannelIdentifier, t)
+			return
+		}
+		availableRoutes = routes
+	}
 	routesState := route.NewRoutesState(availableRoutes)
+	//routes tried by earlier attempts count too
+	maxRouteAttempts := rs.Config.MaxRouteAttempts
+	if maxRouteAttempts > 0 {
+		maxRouteAttempts -= t.attempts
+	}
 	transferState := &mediatedtransfer.LockedTransferState{
 		TargetAmount:   new(big.Int).Set(amount),
 		Amount:         new(big.Int).Set(amount),
 		Token:          tokenAddress,
 		Initiator:      rs.NodeAddress,
 		Target:         target,
-		Expiration:     expiration,
+		Expiration:     t.expiration,
 		LockSecretHash: lockSecretHash,
-		Secret:         secret,
+		Secret:         t.secret,
 		Fee:            utils.BigInt0,
-		Data:           data,
+		Data:           t.data,
+		Metadata:       t.metadata,
 	}
 	/*
 		发起方每次切换路径不再切换密码,不切换依然可以保证安全
 	*/
 	// Initiator has no need to switch secret, every time he switches the route, and security can be ensured.
 	initInitiator := &mediatedtransfer.ActionInitInitiatorStateChange{
-		OurAddress:     rs.NodeAddress,
-		Tranfer:        transferState,
-		Routes:         routesState,
-		BlockNumber:    rs.GetBlockNumber(),
-		Secret:         secret,
-		LockSecretHash: lockSecretHash,
-		Db:             rs.dao,
+		OurAddress:       rs.NodeAddress,
+		Tranfer:          transferState,
+		Routes:           routesState,
+		BlockNumber:      rs.GetBlockNumber(),
+		Secret:           t.secret,
+		LockSecretHash:   lockSecretHash,
+		Db:               rs.dao,
+		Deadline:         t.deadline,
+		MaxRouteAttempts: maxRouteAttempts,
+		Relock:           t.relock,
 	}
 	//log.Trace(fmt.Sprintf("start mediated transfer availableRoutes=%s", utils.StringInterface(availableRoutes, 2)))
 	stateManager = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, transferState.Token)
@@ -898,6 +1087,9 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 	}
 	rs.Transfer2StateManager[smkey] = stateManager
 	rs.Transfer2Result[smkey] = result
+	if t.relock {
+		rs.relocks.track(t)
+	}
 	//rs.dao.AddStateManager(stateManager)
 	rs.StateMachineEventHandler.dispatch(stateManager, initInitiator)
 	return
@@ -906,10 +1098,12 @@ func (rs *Service) startMediatedTransferInternal(tokenAddress, target common.Add
 /*
 1. user start a mediated transfer
 2. user start a mediated transfer with secret
+3. user start a mediated transfer with deadline
 */
-func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, fee *big.Int, secret common.Hash, data string) (result *utils.AsyncResult) {
+func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, fee, maxFee *big.Int, secret common.Hash, data string, metadata []byte, deadline TransferDeadline) (result *utils.AsyncResult) {
 	lockSecretHash := utils.EmptyHash
-	if secret != utils.EmptyHash {
+	randomSecret := secret == utils.EmptyHash
+	if !randomSecret {
 		lockSecretHash = utils.ShaSecret(secret.Bytes())
 		/*用户使用指定的密码来进行交易,那么:
 		1. 注册SecretRequestPredictor,防止在用户允许之前发送密码出去
@@ -930,15 +1124,57 @@ func (rs *Service) startMediatedTransfer(tokenAddress, target common.Address, am
 			普通交易，随机生成密码
 		*/
 		// Normal transfer, generate random secret.
-		secret = utils.NewRandomHash()
-		lockSecretHash = utils.ShaSecret(secret[:])
+		secret, lockSecretHash = rs.newSecret()
 	}
 	/*
 		发起方在这里记录发起的交易状态,后续UpdateTransferStatus会更新DB中的值
 	*/
 	rs.dao.NewTransferStatus(tokenAddress, lockSecretHash)
-	result, _ = rs.startMediatedTransferInternal(tokenAddress, target, amount, fee, lockSecretHash, 0, secret, data)
+	var deadlineTime time.Time
+	if deadline.Timeout > 0 {
+		deadlineTime = time.Now().Add(deadline.Timeout)
+	}
+	record := &models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   tokenAddress,
+		Role:           models.TransferRoleInitiator,
+		Initiator:      rs.NodeAddress,
+		Target:         target,
+		Amount:         amount,
+		Fee:            fee,
+		Phase:          models.TransferPhaseRouting,
+		Metadata:       metadata,
+	}
+	if !deadlineTime.IsZero() {
+		record.Deadline = deadlineTime.Unix()
+	}
+	rs.newTransferRecord(record)
+	var deadlineBlock int64
+	if deadline.Blocks > 0 {
+		deadlineBlock = rs.GetBlockNumber() + deadline.Blocks
+	}
+	t := &outboundTransfer{
+		tokenAddress:   tokenAddress,
+		target:         target,
+		amount:         amount,
+		fee:            fee,
+		maxFee:         maxFee,
+		lockSecretHash: lockSecretHash,
+		secret:         secret,
+		deadline:       deadlineBlock,
+		deadlineTime:   deadlineTime,
+		data:           data,
+		metadata:       metadata,
+		result:         utils.NewAsyncResult(),
+	}
+	//only random secrets never known to anyone else can be replaced, and only until the deadline
+	t.relock = randomSecret && (t.deadline > 0 || !t.deadlineTime.IsZero())
+	result = t.result
 	result.LockSecretHash = lockSecretHash
+	stateManager := rs.initiateTransfer(t, true)
+	if !deadlineTime.IsZero() && (stateManager != nil || rs.outboundQueue.contains(t.key())) {
+		rs.armTransferDeadline(tokenAddress, lockSecretHash, deadlineTime)
+	}
 	return
 }
 
@@ -1008,6 +1244,17 @@ func (rs *Service) mediateMediatedTransfer(msg *encoding.MediatedTransfer, ch *c
 			Db:          rs.dao,
 		}
 		stateManager = transfer.NewStateManager(mediator.StateTransition, nil, mediator.NameMediatorTransition, fromTransfer.LockSecretHash, fromTransfer.Token)
+		rs.newTransferRecord(&models.TransferRecord{
+			LockSecretHash: fromTransfer.LockSecretHash,
+			TokenAddress:   fromTransfer.Token,
+			Role:           models.TransferRoleMediator,
+			Initiator:      fromTransfer.Initiator,
+			Target:         fromTransfer.Target,
+			Amount:         amount,
+			Fee:            utils.BigInt0,
+			Route:          []common.Address{msg.Sender, rs.NodeAddress},
+			Phase:          models.TransferPhaseRouting,
+		})
 		//rs.dao.AddStateManager(stateManager)
 		rs.Transfer2StateManager[smkey] = stateManager //for path A-B-C-F-B-D-E ,node B will have two StateManagers for one identifier
 		rs.StateMachineEventHandler.dispatch(stateManager, initMediator)
@@ -1051,6 +1298,7 @@ func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *ch
 	}
 	fromRoute := graph.Channel2RouteState(fromChannel, msg.Sender, msg.PaymentAmount, rs)
 	fromTransfer := mediatedtransfer.LockedTransferFromMessage(msg, ch.TokenAddress)
+	refused := !rs.acceptTransferFrom(fromTransfer.Token, fromTransfer.Initiator)
 	initTarget := &mediatedtransfer.ActionInitTargetStateChange{
 		OurAddress:  rs.NodeAddress,
 		FromRoute:   fromRoute,
@@ -1058,11 +1306,35 @@ func (rs *Service) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *ch
 		BlockNumber: rs.GetBlockNumber(),
 		Message:     msg,
 		Db:          rs.dao,
+		Refused:     refused,
 	}
 	stateManager = transfer.NewStateManager(target.StateTransiton, nil, target.NameTargetTransition, fromTransfer.LockSecretHash, fromTransfer.Token)
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: fromTransfer.LockSecretHash,
+		TokenAddress:   fromTransfer.Token,
+		Role:           models.TransferRoleTarget,
+		Initiator:      fromTransfer.Initiator,
+		Target:         rs.NodeAddress,
+		Amount:         fromTransfer.Amount,
+		Fee:            utils.BigInt0,
+		Route:          []common.Address{msg.Sender, rs.NodeAddress},
+		Phase:          models.TransferPhaseWaitingReveal,
+		Metadata:       msg.Metadata,
+	})
+	if refused {
+		reason := fmt.Sprintf("initiator %s is not accepted by accept policy", fromTransfer.Initiator.String())
+		log.Warn(fmt.Sprintf("refuse transfer %s of token %s, %s", utils.HPex(fromTransfer.LockSecretHash), utils.APex2(fromTransfer.Token), reason))
+		rs.failTransferRecord(fromTransfer.Token, fromTransfer.LockSecretHash, models.TransferFailureInitiatorNotAccepted, reason)
+		if r, err := rs.getTransferRecord(fromTransfer.Token, fromTransfer.LockSecretHash); err == nil {
+			rs.NotifyHandler.Notify(notify.LevelWarn, r)
+		}
+	}
 	//rs.dao.AddStateManager(stateManager)
 	rs.Transfer2StateManager[smkey] = stateManager
 	rs.StateMachineEventHandler.dispatch(stateManager, initTarget)
+	if refused {
+		return
+	}
 	// notify upper
 	rs.NotifyHandler.NotifyReceiveMediatedTransfer(msg, ch)
 }
@@ -1354,7 +1626,7 @@ func (rs *Service) tokenSwapMaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 	}
 	rs.SentMediatedTransferListenerMap[&sentMtrHook] = true
 	rs.ReceivedMediatedTrasnferListenerMap[&receiveMtrHook] = true
-	result, _ = rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, utils.BigInt0, tokenswap.LockSecretHash, 0, tokenswap.Secret, "")
+	result, _ = rs.startMediatedTransferInternal(tokenswap.FromToken, tokenswap.ToNodeAddress, tokenswap.FromAmount, utils.BigInt0, nil, tokenswap.LockSecretHash, 0, 0, tokenswap.Secret, "")
 	return
 }
 
@@ -1408,7 +1680,7 @@ func (rs *Service) messageTokenSwapTaker(msg *encoding.MediatedTransfer, tokensw
 		taker and maker may have direct channels on these two tokens.
 	*/
 	takerExpiration := msg.Expiration - int64(rs.Config.RevealTimeout)
-	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, utils.BigInt0, tokenswap.LockSecretHash, takerExpiration, utils.EmptyHash, "")
+	result, stateManager := rs.startMediatedTransferInternal(tokenswap.ToToken, tokenswap.FromNodeAddress, tokenswap.ToAmount, utils.BigInt0, nil, tokenswap.LockSecretHash, takerExpiration, 0, utils.EmptyHash, "")
 	if stateManager == nil {
 		log.Error(fmt.Sprintf("taker tokenwap error %s", <-result.Result))
 		return false
@@ -1434,41 +1706,134 @@ func (rs *Service) tokenSwapTaker(tokenswap *TokenSwap) (result *utils.AsyncResu
 	return
 }
 
+/*
+maker 的 offer 只保存在内存中, 密码由本节点生成, 只把 LockSecretHash 作为 id 告诉 taker.
+taker 用这个 id 调用 taker 接口以后, maker 再通过 id 启动, 之后和 tokenSwapMaker 完全相同.
+*/
+/*
+ *	tokenSwapOffer : maker's offer is kept in memory only, secret is generated by this node,
+ *	only LockSecretHash is given to taker as the id of the offer.
+ *	After taker registered the swap with this id, maker starts it by id, from then on it's the same as tokenSwapMaker.
+ */
+func (rs *Service) tokenSwapOffer(tokenswap *TokenSwap) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	if _, ok := rs.LockSecretHash2Offer[tokenswap.LockSecretHash]; ok {
+		result.Result <- errors.New("token swap offer already exists")
+		return
+	}
+	rs.LockSecretHash2Offer[tokenswap.LockSecretHash] = tokenswap
+	result.Result <- nil
+	return
+}
+
+//startTokenSwapOffer start the offer created by tokenSwapOffer, an offer can only be started once
+func (rs *Service) startTokenSwapOffer(lockSecretHash common.Hash) (result *utils.AsyncResult) {
+	tokenswap, ok := rs.LockSecretHash2Offer[lockSecretHash]
+	if !ok {
+		result = utils.NewAsyncResult()
+		result.Result <- rerr.ErrTokenSwapOfferNotFound
+		return
+	}
+	delete(rs.LockSecretHash2Offer, lockSecretHash)
+	return rs.tokenSwapMaker(tokenswap)
+}
+
 /*
 cancel a transfer before secret send
 only initiator can call
+发起方还没有把密码告诉任何人时才能撤销, 撤销以后不再响应 SecretRequest, 锁过期以后通过 RemoveExpiredHashlock 移除.
+重启以后没有发起方的状态机, 密码不会再发出去, 撤销依然有效.
 */
+/*
+ *	cancelTransfer : only when secret hasn't left our node, after that SecretRequest is never answered,
+ *	locks are removed by RemoveExpiredHashlock after they expired.
+ *	After restart there is no initiator state machine to send the secret, so the transfer stays cancelled.
+ */
 func (rs *Service) cancelTransfer(req *cancelTransferReq) (result *utils.AsyncResult) {
 	result = utils.NewAsyncResult()
-	// get transfer info and check
-	smKey := utils.Sha3(req.LockSecretHash[:], req.TokenAddress[:])
+	// get transfer info and check, a transfer started again after its lock expired goes by its latest lock
+	lockSecretHash := rs.relocks.latestOf(req.LockSecretHash)
+	smKey := utils.Sha3(lockSecretHash[:], req.TokenAddress[:])
 	manager := rs.Transfer2StateManager[smKey]
 	if manager == nil {
-		result.Result <- errors.New("can not found transfer")
+		if rs.failQueuedTransfer(smKey, models.TransferFailureCanceled, errors.New("canceled by user while queued")) {
+			result.Result <- nil
+			return
+		}
+		result.Result <- rerr.ErrTransferNotFound
 		return
 	}
 	if manager.Name != initiator.NameInitiatorTransition {
 		result.Result <- errors.New("you can only cancel transfers you send")
 		return
 	}
-	transferStatus, err := rs.dao.GetTransferStatus(req.TokenAddress, req.LockSecretHash)
-	if err != nil {
-		result.Result <- errors.New("can not found transfer status")
+	state, ok := manager.CurrentState.(*mediatedtransfer.InitiatorState)
+	if !ok {
+		result.Result <- errors.New("transfer already finished")
+		return
+	}
+	if state.Canceled || state.DeadlineExceeded {
+		result.Result <- errors.New("transfer already failed")
 		return
 	}
-	if transferStatus.Status != models.TransferStatusCanCancel {
-		result.Result <- errors.New("transfer already can not cancel now")
+	if state.RevealSecret != nil || rs.transferSecretReleased(req.TokenAddress, req.LockSecretHash) {
+		result.Result <- rerr.ErrTransferCannotCancel
 		return
 	}
 	stateChange := &transfer.ActionCancelTransferStateChange{
-		LockSecretHash: req.LockSecretHash,
+		LockSecretHash: lockSecretHash,
 	}
 	rs.StateMachineEventHandler.dispatch(manager, stateChange)
-	rs.dao.UpdateTransferStatus(req.TokenAddress, req.LockSecretHash, models.TransferStatusCanceled, "交易撤销")
+	rs.updateTransferStatus(req.TokenAddress, req.LockSecretHash, models.TransferStatusCanceled, "交易撤销")
+	result.Result <- nil
+	return
+}
+
+//transferSecretReleased true if secret of the transfer is known outside, e.g. registered on chain
+func (rs *Service) transferSecretReleased(tokenAddress common.Address, lockSecretHash common.Hash) bool {
+	r, err := rs.getTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil {
+		return false
+	}
+	return r.Phase == models.TransferPhaseWaitingReveal || r.Phase == models.TransferPhaseWaitingUnlock || r.Phase == models.TransferPhaseSuccess
+}
+
+/*
+wall-clock deadline of a transfer passed, initiator gives up if secret is not revealed to target yet.
+nothing to do if the transfer is already finished.
+after restart there is no initiator state machine, the secret is never revealed, so only the record is marked failed,
+the lock is removed after it expired.
+*/
+func (rs *Service) transferDeadline(req *transferDeadlineReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	lockSecretHash := rs.relocks.latestOf(req.LockSecretHash)
+	smKey := utils.Sha3(lockSecretHash[:], req.TokenAddress[:])
+	manager := rs.Transfer2StateManager[smKey]
+	if manager != nil && manager.Name == initiator.NameInitiatorTransition {
+		rs.StateMachineEventHandler.dispatch(manager, &mediatedtransfer.ActionTransferDeadlineStateChange{
+			LockSecretHash: lockSecretHash,
+		})
+	} else if !rs.failQueuedTransfer(smKey, models.TransferFailureDeadlineExceeded, errQueuedDeadlineExceeded) &&
+		!rs.transferSecretReleased(req.TokenAddress, req.LockSecretHash) {
+		rs.updateTransferStatus(req.TokenAddress, req.LockSecretHash, models.TransferStatusFailed, "交易超过 deadline")
+		rs.failTransferRecord(req.TokenAddress, req.LockSecretHash, models.TransferFailureDeadlineExceeded, initiator.ReasonDeadlineExceeded)
+	}
 	result.Result <- nil
 	return
 }
 
+//armTransferDeadline dispatch deadline of transfer when deadline passes, at once if it has passed already
+func (rs *Service) armTransferDeadline(tokenAddress common.Address, lockSecretHash common.Hash, deadline time.Time) {
+	time.AfterFunc(time.Until(deadline), func() {
+		select {
+		case <-rs.quitChan:
+			return
+		default:
+		}
+		rs.transferDeadlineClient(lockSecretHash, tokenAddress)
+	})
+}
+
 //recieve a ack from
 func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 	data := sentMessage.Message.Pack()
@@ -1489,6 +1854,7 @@ func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 			r.Result <- nil
 		}
 		rs.dao.UpdateTransferStatus(ch.TokenAddress, msg.FakeLockSecretHash, models.TransferStatusSuccess, "DirectTransfer 发送成功,交易成功")
+		rs.setTransferPhase(ch.TokenAddress, msg.FakeLockSecretHash, models.TransferPhaseSuccess)
 	case *encoding.MediatedTransfer:
 		ch, err := rs.findChannelByIdentifier(msg.ChannelIdentifier)
 		if err != nil {
@@ -1496,6 +1862,7 @@ func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 			return
 		}
 		rs.dao.UpdateTransferStatusMessage(ch.TokenAddress, msg.LockSecretHash, "MediatedTransfer 发送成功")
+		rs.relocks.acked(utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:]))
 	case *encoding.RevealSecret:
 		// save log to dao
 		channels := rs.findAllChannelsByLockSecretHash(msg.LockSecretHash())
@@ -1508,7 +1875,7 @@ func (rs *Service) handleSentMessage(sentMessage *protocolMessage) {
 			log.Error(err.Error())
 			return
 		}
-		rs.dao.UpdateTransferStatus(ch.TokenAddress, msg.LockSecretHash(), models.TransferStatusSuccess, "UnLock 发送成功,交易成功.")
+		rs.updateTransferStatus(ch.TokenAddress, msg.LockSecretHash(), models.TransferStatusSuccess, "UnLock 发送成功,交易成功.")
 	case *encoding.AnnounceDisposedResponse:
 		ch, err := rs.findChannelByIdentifier(msg.ChannelIdentifier)
 		if err != nil {
@@ -1579,10 +1946,26 @@ func (rs *Service) handleReq(req *apiReq) {
 	switch req.Name {
 	case transferReqName: //mediated transfer only
 		r := req.Req.(*transferReq)
-		if r.IsDirectTransfer {
-			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Data)
+		if len(r.Identifier) > 0 {
+			result = rs.findTransferByIdentifier(r)
+			if result != nil {
+				break
+			}
+		}
+		//metadata is carried only by mediated transfer
+		if r.IsDirectTransfer && len(r.Metadata) == 0 {
+			err := rs.checkDirectChannel(r.TokenAddress, r.Target, r.Amount)
+			if err == nil || r.DirectOnly {
+				result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Data)
+			} else {
+				log.Info(fmt.Sprintf("direct transfer to %s not available, fall back to mediated transfer, %s", utils.APex2(r.Target), err))
+				result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Fee, r.MaxFee, r.Secret, r.Data, r.Metadata, r.Deadline)
+			}
 		} else {
-			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Fee, r.Secret, r.Data)
+			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Fee, r.MaxFee, r.Secret, r.Data, r.Metadata, r.Deadline)
+		}
+		if len(r.Identifier) > 0 {
+			rs.saveTransferIdentifier(r, result)
 		}
 	case newChannelReqName:
 		r := req.Req.(*newChannelReq)
@@ -1603,6 +1986,12 @@ func (rs *Service) handleReq(req *apiReq) {
 	case tokenSwapTakerReqName:
 		r := req.Req.(*tokenSwapTakerReq)
 		result = rs.tokenSwapTaker(r.tokenSwap)
+	case tokenSwapOfferReqName:
+		r := req.Req.(*tokenSwapOfferReq)
+		result = rs.tokenSwapOffer(r.tokenSwap)
+	case startTokenSwapOfferReqName:
+		r := req.Req.(*startTokenSwapOfferReq)
+		result = rs.startTokenSwapOffer(r.LockSecretHash)
 	case cooperativeSettleChannelReqName:
 		r := req.Req.(*closeSettleChannelReq)
 		result = rs.cooperativeSettleChannel(r.addr)
@@ -1636,6 +2025,17 @@ func (rs *Service) handleReq(req *apiReq) {
 	case forceUnlockReqName:
 		r := req.Req.(*forceUnlockReq)
 		result = rs.forceUnlock(r)
+	case updateRevealTimeoutReqName:
+		result = rs.updateRevealTimeout()
+	case transferDeadlineReqName:
+		r := req.Req.(*transferDeadlineReq)
+		result = rs.transferDeadline(r)
+	case transferAckTimeoutReqName:
+		r := req.Req.(*transferAckTimeoutReq)
+		result = rs.transferAckTimeout(r)
+	case watchTransferReqName:
+		r := req.Req.(*watchTransferReq)
+		result = rs.watchTransfer(r)
 	default:
 		panic("unkown req")
 	}
@@ -1735,6 +2135,9 @@ func (rs *Service) getBestRoutesFromPfs(peerFrom, peerTo, token common.Address,
 		r := route.NewState(ch)
 		r.Fee = rs.FeePolicy.GetNodeChargeFee(partnerAddress, token, amount)
 		r.TotalFee = path.Fee
+		for _, hop := range path.Result {
+			r.Path = append(r.Path, common.HexToAddress(hop))
+		}
 		routes = append(routes, r)
 	}
 	return
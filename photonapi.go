@@ -14,6 +14,7 @@ import (
 
 	"math/big"
 
+	"strings"
 	"sync"
 
 	"errors"
@@ -23,10 +24,12 @@ import (
 
 	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
 	"github.com/SmartMeshFoundation/Photon/dto"
+	"github.com/SmartMeshFoundation/Photon/encoding"
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/network"
 	"github.com/SmartMeshFoundation/Photon/network/netshare"
+	"github.com/SmartMeshFoundation/Photon/notify"
 	"github.com/SmartMeshFoundation/Photon/pfsproxy"
 	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/transfer"
@@ -241,6 +244,45 @@ func (r *API) ExpectTokenSwap(lockSecretHash string, makerToken, takerToken, mak
 	return nil
 }
 
+/*
+OfferTokenSwap maker creates a token swap offer with a secret generated by this node,
+nothing is sent until StartTokenSwapOffer is called.
+The returned LockSecretHash is the id of the offer, taker should register the swap with it by ExpectTokenSwap.
+*/
+func (r *API) OfferTokenSwap(makerToken, takerToken, takerAddress common.Address, makerAmount, takerAmount *big.Int) (lockSecretHash common.Hash, err error) {
+	chs, err := r.Photon.dao.GetChannelList(takerToken, utils.EmptyAddress)
+	if err != nil || len(chs) == 0 {
+		err = errors.New("unkown taker token")
+		return
+	}
+	chs, err = r.Photon.dao.GetChannelList(makerToken, utils.EmptyAddress)
+	if err != nil || len(chs) == 0 {
+		err = errors.New("unkown maker token")
+		return
+	}
+	secret := utils.NewRandomHash()
+	tokenSwap := &TokenSwap{
+		LockSecretHash:  utils.ShaSecret(secret[:]),
+		Secret:          secret,
+		FromToken:       makerToken,
+		FromAmount:      new(big.Int).Set(makerAmount),
+		FromNodeAddress: r.Photon.NodeAddress,
+		ToToken:         takerToken,
+		ToAmount:        new(big.Int).Set(takerAmount),
+		ToNodeAddress:   takerAddress,
+	}
+	err = <-r.Photon.tokenSwapOfferClient(tokenSwap).Result
+	return tokenSwap.LockSecretHash, err
+}
+
+/*
+StartTokenSwapOffer maker starts the offer created by OfferTokenSwap after taker has registered it,
+and waits like TokenSwapAndWait. If either leg fails, the secret is never revealed and both locks expire.
+*/
+func (r *API) StartTokenSwapOffer(lockSecretHash common.Hash) error {
+	return <-r.Photon.startTokenSwapOfferClient(lockSecretHash).Result
+}
+
 //GetNodeNetworkState Returns the currently network status of `node_address
 func (r *API) GetNodeNetworkState(nodeAddress common.Address) (deviceType string, isOnline bool) {
 	return r.Photon.Protocol.GetNetworkStatus(nodeAddress)
@@ -278,7 +320,12 @@ func (r *API) GetTokenTokenNetorks() (tokens []string) {
 
 //Transfer transfer and wait
 func (r *API) Transfer(token common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, data string) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(token, amount, fee, target, secret, isDirectTransfer, data)
+	result, err = r.TransferInternal(token, target, amount, &TransferOptions{
+		Fee:              fee,
+		Secret:           secret,
+		IsDirectTransfer: isDirectTransfer,
+		Data:             data,
+	})
 	if err != nil {
 		return
 	}
@@ -297,7 +344,12 @@ func (r *API) Transfer(token common.Address, amount *big.Int, fee *big.Int, targ
 
 // TransferAsync :
 func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string) (result *utils.AsyncResult, err error) {
-	result, err = r.TransferInternal(tokenAddress, amount, fee, target, secret, isDirectTransfer, data)
+	result, err = r.TransferInternal(tokenAddress, target, amount, &TransferOptions{
+		Fee:              fee,
+		Secret:           secret,
+		IsDirectTransfer: isDirectTransfer,
+		Data:             data,
+	})
 	if err != nil {
 		return
 	}
@@ -310,8 +362,241 @@ func (r *API) TransferAsync(tokenAddress common.Address, amount *big.Int, fee *b
 	return result, err
 }
 
-//TransferInternal :
-func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string) (result *utils.AsyncResult, err error) {
+/*
+TransferDirectOnly 只通过与 target 的直接通道转账, 直接通道不可用时直接失败, 不会改走 mediated transfer, 所以不需要支付手续费.
+*/
+/*
+ *	TransferDirectOnly : transfer only through the direct channel with target, fails when it's not usable
+ *	instead of falling back to mediated transfer, so no mediation fee is paid.
+ */
+func (r *API) TransferDirectOnly(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, sync bool, data string) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(tokenAddress, target, amount, &TransferOptions{
+		Fee:              fee,
+		Secret:           secret,
+		IsDirectTransfer: true,
+		DirectOnly:       true,
+		Data:             data,
+	})
+	if err != nil {
+		return
+	}
+	timeout := 300 * time.Millisecond
+	if sync {
+		timeout = params.MaxRequestTimeout
+	}
+	select {
+	case <-time.After(timeout):
+		if sync {
+			err = errors.New("timeout")
+		}
+	case err = <-result.Result:
+	}
+	return
+}
+
+//TransferDeadline give up a mediated transfer if secret is not revealed to target within Blocks blocks or Timeout, zero means no deadline
+type TransferDeadline struct {
+	Blocks  int64
+	Timeout time.Duration
+}
+
+//TransferOptions optional parameters of a transfer, the zero value is a mediated transfer with a random secret and no fee
+type TransferOptions struct {
+	Fee              *big.Int
+	MaxFee           *big.Int    //cap of total mediation fee, nil means no cap
+	Secret           common.Hash //empty means a random secret
+	IsDirectTransfer bool        //prefer direct transfer, fall back to mediated transfer if direct channel is not usable
+	DirectOnly       bool        //never fall back to mediated transfer
+	Data             string
+	Metadata         []byte //carried by MediatedTransfer to target, signed by us
+	Deadline         TransferDeadline
+	Identifier       string //client generated identifier for dedup, scoped per (token, target), empty means no dedup
+}
+
+//check validates options which don't depend on state of channels
+func (opts *TransferOptions) check() error {
+	if opts.Deadline.Blocks < 0 || opts.Deadline.Timeout < 0 {
+		return errors.New("invalid deadline")
+	}
+	if len(opts.Identifier) > params.MaxTransferIdentifierLen {
+		return errors.New("invalid identifier")
+	}
+	if len(opts.Metadata) > params.MaxTransferMetadataLen {
+		return fmt.Errorf("metadata too long, length must <= %d", params.MaxTransferMetadataLen)
+	}
+	if opts.DirectOnly && len(opts.Metadata) > 0 {
+		return errors.New("metadata is carried only by mediated transfer")
+	}
+	return nil
+}
+
+/*
+TransferWithOptions 和 Transfer 一样, 但是可以指定 TransferOptions 中的所有参数.
+超过 deadline 还没有把密码告诉接收方时放弃这次交易, 不再尝试新的路由, 等待锁过期以后移除, 交易失败原因是 deadline_exceeded.
+使用随机密码时, 如果锁过期了接收方还没有要过密码, 在 deadline 之内会换一个新的密码重新发起, 对用户来说仍然是同一笔交易;
+使用指定密码时 deadline 不会超过所选路由上锁的过期时间. 直接通道转账会立即完成, 不受 deadline 影响.
+以秒为单位的 deadline 会保存下来, 重启以后继续有效.
+identifier 不为空时, 同一个标识的交易已经存在(进行中或者已完成)时不会发起新的交易, 返回的 result.Duplicate 为 true.
+sync 为 true 时等待交易完成.
+*/
+/*
+ *	TransferWithOptions : same as Transfer, but takes every optional parameter of TransferOptions.
+ *	Transfer is given up if secret is not revealed to target before deadline, no more routes will be tried,
+ *	lock is removed after it expired, and transfer fails with reason deadline_exceeded.
+ *	With a random secret, if the lock expires before target asks for the secret, the transfer is started again with a fresh secret
+ *	within the deadline, it's still the same transfer to users, with another attempt in its record.
+ *	With a given secret, deadline never exceeds lock expiration of the chosen route. Direct transfer completes at once, so deadline doesn't apply.
+ *	Deadline in seconds is persisted and still applies after restart.
+ *	If identifier is not empty and a transfer with the same identifier exists, no new transfer is started and result.Duplicate is true.
+ *	If sync is true, wait for the transfer to finish.
+ */
+func (r *API) TransferWithOptions(tokenAddress, target common.Address, amount *big.Int, opts *TransferOptions, sync bool) (result *utils.AsyncResult, err error) {
+	result, err = r.TransferInternal(tokenAddress, target, amount, opts)
+	if err != nil || result.Duplicate {
+		return
+	}
+	timeout := 300 * time.Millisecond
+	if sync {
+		timeout = params.MaxRequestTimeout
+	}
+	select {
+	case <-time.After(timeout):
+		if sync {
+			err = errors.New("timeout")
+		}
+	case err = <-result.Result:
+	}
+	return
+}
+
+/*
+TransferIdempotent 和 TransferWithOptions 一样, 但是请求必须携带客户端生成的标识 opts.Identifier, 标识在 (token, target) 范围内唯一.
+同一个标识的交易已经存在(进行中或者已完成)时不会发起新的交易, 返回的 result.Duplicate 为 true, result.LockSecretHash 是原交易的.
+标识保留 Config.TransferIdempotencyRetention 以后过期.
+*/
+/*
+ *	TransferIdempotent : same as TransferWithOptions, but request must carry a client generated identifier in opts.Identifier, scoped per (token, target).
+ *	If a transfer with the same identifier exists, pending or completed, no new transfer is started,
+ *	result.Duplicate is true and result.LockSecretHash is that of the existing transfer.
+ *	Identifiers expire after Config.TransferIdempotencyRetention.
+ */
+func (r *API) TransferIdempotent(tokenAddress, target common.Address, amount *big.Int, opts *TransferOptions, sync bool) (result *utils.AsyncResult, err error) {
+	if opts == nil || len(opts.Identifier) == 0 {
+		err = errors.New("invalid identifier")
+		return
+	}
+	return r.TransferWithOptions(tokenAddress, target, amount, opts, sync)
+}
+
+/*
+TransferWatch 发起交易后立即返回, 不等待交易完成.
+updates 依次收到交易记录的变化, 第一个是当前状态, 交易成功或者失败以后 updates 被关闭.
+callbackURL 不为空时, 交易结束后会把交易记录 POST 到这个地址, 请求用节点私钥签名, 失败会有限次重试.
+opts.Identifier 不为空时和 TransferIdempotent 一样去重.
+*/
+/*
+ *	TransferWatch : start a transfer and return immediately without waiting for it to finish.
+ *	updates receives every change of the transfer record, the first one is current status,
+ *	it is closed after transfer succeeded or failed.
+ *	If callbackURL is not empty, the record is POSTed to it after transfer finished, request is signed by key of this node,
+ *	delivery is retried a bounded number of times.
+ *	Transfers with the same non-empty opts.Identifier are deduplicated as TransferIdempotent does.
+ */
+func (r *API) TransferWatch(tokenAddress, target common.Address, amount *big.Int, opts *TransferOptions, callbackURL string) (lockSecretHash common.Hash, updates <-chan *models.TransferRecord, err error) {
+	if len(callbackURL) > 0 {
+		err = checkCallbackURL(callbackURL)
+		if err != nil {
+			return
+		}
+	}
+	result, err := r.TransferInternal(tokenAddress, target, amount, opts)
+	if err != nil {
+		return
+	}
+	lockSecretHash = result.LockSecretHash
+	if lockSecretHash == utils.EmptyHash {
+		//failed before transfer started
+		err = <-result.Result
+		if err == nil {
+			err = errors.New("transfer not started")
+		}
+		return
+	}
+	w := r.Photon.watchTransferClient(tokenAddress, lockSecretHash, callbackURL)
+	err = <-w.Result
+	if err != nil {
+		return
+	}
+	updates = w.Tag.(<-chan *models.TransferRecord)
+	return
+}
+
+/*
+GetTransferRecord 查询交易记录, id 可以是 0x 开头的 lockSecretHash, 也可以是发起交易时客户端提供的 identifier.
+发起方, 中转节点和接收方都会记录交易, target 必须和记录的接收方一致.
+*/
+/*
+ *	GetTransferRecord : query transfer record, id is either a 0x prefixed lockSecretHash or the identifier provided by client when the transfer was started.
+ *	Initiator, mediator and target all keep records of transfer, target must match target of the record.
+ */
+func (r *API) GetTransferRecord(tokenAddress, target common.Address, id string) (record *models.TransferRecord, err error) {
+	lockSecretHash, err := r.transferLockSecretHash(tokenAddress, target, id)
+	if err != nil {
+		return
+	}
+	record, err = r.Photon.dao.GetTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil {
+		return
+	}
+	if record.Target != target {
+		record = nil
+		err = errors.New("transfer record not found")
+	}
+	return
+}
+
+//GetTransferReceipt returns the receipt signed by target of a transfer we sent, id is the same as GetTransferRecord
+func (r *API) GetTransferReceipt(tokenAddress, target common.Address, id string) (receipt *encoding.PaymentReceipt, err error) {
+	record, err := r.GetTransferRecord(tokenAddress, target, id)
+	if err != nil {
+		return nil, rerr.ErrTransferNotFound
+	}
+	if len(record.Receipt) == 0 {
+		return nil, rerr.ErrTransferReceiptNotFound
+	}
+	return encoding.VerifyPaymentReceipt(record.Receipt)
+}
+
+//ExportPaymentProof returns proof of a transfer we sent or received successfully, id is the same as GetTransferRecord
+func (r *API) ExportPaymentProof(tokenAddress, target common.Address, id string) (proof *models.PaymentProof, err error) {
+	record, err := r.GetTransferRecord(tokenAddress, target, id)
+	if err != nil {
+		return nil, rerr.ErrTransferNotFound
+	}
+	proof, err = record.ExportPaymentProof(params.ChainID)
+	if err != nil {
+		log.Info(fmt.Sprintf("ExportPaymentProof %s err %s", utils.HPex(record.LockSecretHash), err))
+		return nil, rerr.ErrPaymentProofNotFound
+	}
+	return
+}
+
+/*
+TransferInternal :
+opts 为 nil 时和零值一样.
+opts.IsDirectTransfer 为 true 时优先使用直接通道, 直接通道余额不足或者对方不在线时改走 mediated transfer,
+opts.DirectOnly 为 true 时不允许改走 mediated transfer.
+*/
+/*
+ *	TransferInternal :
+ *	nil opts is the same as its zero value.
+ *	when opts.IsDirectTransfer is true, direct channel is preferred, if it has not enough balance or partner is offline,
+ *	mediated transfer is used instead, unless opts.DirectOnly is true.
+ */
+func (r *API) TransferInternal(tokenAddress, target common.Address, amount *big.Int, opts *TransferOptions) (result *utils.AsyncResult, err error) {
+	if opts == nil {
+		opts = &TransferOptions{}
+	}
 	//tokens := r.Tokens()
 	//found := false
 	//for _, t := range tokens {
@@ -340,9 +625,13 @@ func (r *API) TransferInternal(tokenAddress common.Address, amount *big.Int, fee
 	//	err = rerr.ErrInvalidAmount
 	//	return
 	//}
+	err = opts.check()
+	if err != nil {
+		return
+	}
 	log.Debug(fmt.Sprintf("initiating transfer initiator=%s target=%s token=%s amount=%d secret=%s,currentblock=%d",
-		r.Photon.NodeAddress.String(), target.String(), tokenAddress.String(), amount, secret.String(), r.Photon.GetBlockNumber()))
-	result = r.Photon.transferAsyncClient(tokenAddress, amount, fee, target, secret, isDirectTransfer, data)
+		r.Photon.NodeAddress.String(), target.String(), tokenAddress.String(), amount, opts.Secret.String(), r.Photon.GetBlockNumber()))
+	result = r.Photon.transferAsyncClient(tokenAddress, target, amount, opts)
 	return
 }
 
@@ -845,20 +1134,27 @@ func signBalanceProofFor3rd(c *channeltype.Serialization, privkey *ecdsa.Private
 		log.Error(fmt.Sprintf("PartnerBalanceProof is nil,must ber a error"))
 		return nil, errors.New("empty PartnerBalanceProof")
 	}
+	dataToSign := balanceProofDataFor3rd(c.PartnerBalanceProof.TransferAmount, c.PartnerBalanceProof.LocksRoot,
+		c.PartnerBalanceProof.Nonce, c.ChannelIdentifier.ChannelIdentifier, c.ChannelIdentifier.OpenBlockNumber)
+	return utils.SignData(privkey, dataToSign)
+}
+
+//balanceProofDataFor3rd is the data non closing participant signs for updateBalanceProofDelegate
+func balanceProofDataFor3rd(transferAmount *big.Int, locksroot common.Hash, nonce uint64, channelIdentifier common.Hash, openBlockNumber int64) []byte {
+	var err error
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractBalanceProofDelegateMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(c.PartnerBalanceProof.TransferAmount))
-	_, err = buf.Write(c.PartnerBalanceProof.LocksRoot[:])
-	err = binary.Write(buf, binary.BigEndian, c.PartnerBalanceProof.Nonce)
-	_, err = buf.Write(c.ChannelIdentifier.ChannelIdentifier[:])
-	err = binary.Write(buf, binary.BigEndian, c.ChannelIdentifier.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(transferAmount))
+	_, err = buf.Write(locksroot[:])
+	err = binary.Write(buf, binary.BigEndian, nonce)
+	_, err = buf.Write(channelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, openBlockNumber)
 	_, err = buf.Write(utils.BigIntTo32Bytes(params.ChainID))
 	if err != nil {
 		log.Error(fmt.Sprintf("buf write error %s", err))
 	}
-	dataToSign := buf.Bytes()
-	return utils.SignData(privkey, dataToSign)
+	return buf.Bytes()
 }
 
 func signUnlockFor3rd(c *channeltype.Serialization, u *unlock, thirdAddress common.Address, privkey *ecdsa.PrivateKey) (sig []byte, err error) {
@@ -978,6 +1274,56 @@ func (r *API) CancelTransfer(lockSecretHash common.Hash, tokenAddress common.Add
 	return <-result.Result
 }
 
+//transferLockSecretHash id is either a 0x prefixed lockSecretHash or the identifier provided by client when the transfer was started
+func (r *API) transferLockSecretHash(tokenAddress, target common.Address, id string) (lockSecretHash common.Hash, err error) {
+	if len(id) == 2*common.HashLength+2 && strings.HasPrefix(id, "0x") {
+		return common.HexToHash(id), nil
+	}
+	ti, err := r.Photon.dao.GetTransferIdempotency(tokenAddress, target, id)
+	if err != nil {
+		return
+	}
+	return ti.LockSecretHash, nil
+}
+
+/*
+CancelTransferByID 撤销我发起的, 还在锁定阶段的交易, id 和 GetTransferRecord 一样.
+只要密码还没有离开本节点就可以撤销: 交易标记为撤销, 不再响应接收方的 SecretRequest, 锁过期以后被移除.
+密码已经发出去时返回 rerr.ErrTransferCannotCancel, 找不到交易返回 rerr.ErrTransferNotFound.
+*/
+/*
+ *	CancelTransferByID : cancel a transfer started by us which is still in the locked phase, id is the same as GetTransferRecord.
+ *	As long as the secret hasn't left our node, the transfer is marked cancelled, SecretRequest of target is never answered,
+ *	and locks are removed after they expired.
+ *	It returns rerr.ErrTransferCannotCancel if the secret is already sent, rerr.ErrTransferNotFound if there is no such transfer.
+ */
+func (r *API) CancelTransferByID(tokenAddress, target common.Address, id string) (lockSecretHash common.Hash, err error) {
+	lockSecretHash, err = r.transferLockSecretHash(tokenAddress, target, id)
+	if err != nil {
+		err = rerr.ErrTransferNotFound
+		return
+	}
+	record, err := r.Photon.dao.GetTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil || record.Target != target {
+		err = rerr.ErrTransferNotFound
+		return
+	}
+	if record.Role != models.TransferRoleInitiator {
+		err = errors.New("you can only cancel transfers you send")
+		return
+	}
+	if record.Phase == models.TransferPhaseSuccess {
+		err = rerr.ErrTransferCannotCancel
+		return
+	}
+	if record.Phase == models.TransferPhaseFailed {
+		err = errors.New("transfer already failed")
+		return
+	}
+	err = r.CancelTransfer(lockSecretHash, tokenAddress)
+	return
+}
+
 type balanceProof struct {
 	Nonce             uint64      `json:"nonce"`
 	TransferAmount    *big.Int    `json:"transfer_amount"`
@@ -1099,6 +1445,23 @@ func (r *API) GetAllFeeChargeRecord() (resp *dto.APIResponse) {
 	return dto.NewSuccessAPIResponse(data)
 }
 
+//IncomingRateLimitStatus limits of incoming messages and status of every partner, nil if incoming messages are not limited
+func (r *API) IncomingRateLimitStatus() *network.RateLimitStatus {
+	if r.Photon.Protocol.RateLimiter == nil {
+		return nil
+	}
+	return r.Photon.Protocol.RateLimiter.Status()
+}
+
+//SubscribeEvents subscribe events of channels, transfers and chain connection matching filter, see notify.EventStream.Subscribe
+func (r *API) SubscribeEvents(filter *notify.EventFilter, fromSequence uint64) (*notify.EventSubscription, error) {
+	err := filter.Check()
+	if err != nil {
+		return nil, err
+	}
+	return r.Photon.NotifyHandler.Events.Subscribe(filter, fromSequence), nil
+}
+
 // SystemStatus :
 func (r *API) SystemStatus() (resp *dto.APIResponse) {
 	type transfers struct {
@@ -0,0 +1,83 @@
+package photon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+收据:
+1. 收款方收到 Unlock 以后, 签名一个 PaymentReceipt 直接发给发起方, 不经过中间节点
+2. 发起方验证收款方, 金额和 LockSecretHash 与自己的交易记录一致以后保存在交易记录中
+3. 商户或者第三方拿到收据以后, 通过 encoding.VerifyPaymentReceipt 恢复出收款方地址, 不需要相信任何一方的日志
+收据只发送一次, 发送失败或者重启以后不会再发.
+老节点不会回复 PaymentReceipt 的 ack, 所以只发给在 ack 中声明了 encoding.CapabilityPaymentReceipt 的发起方, 比如它对 SecretRequest 的 ack.
+发起方对无法匹配的收据也回复 ack, 否则收款方会一直重发.
+*/
+/*
+ *	Payment receipts:
+ *	1. after Unlock received, target signs a PaymentReceipt and sends it to initiator directly, not along the route.
+ *	2. initiator checks target, amount and LockSecretHash against its transfer record, then saves it in the record.
+ *	3. anyone holding the receipt recovers target's address by encoding.VerifyPaymentReceipt,
+ *		no need to trust logs of either party.
+ *	A receipt is sent only once, it's not resent if sending fails or photon restarts.
+ *	Old nodes never ack a PaymentReceipt, so it's only sent to an initiator which declared encoding.CapabilityPaymentReceipt
+ *	in its acks, e.g. the ack of our SecretRequest. An initiator acks every receipt, even the ones it cannot match,
+ *	otherwise target would resend it forever.
+ */
+
+//sendPaymentReceipt target tells initiator that it has received the payment
+func (rs *Service) sendPaymentReceipt(tokenAddress common.Address, e *transfer.EventTransferReceivedSuccess) {
+	if e.LockSecretHash == utils.EmptyHash || e.Initiator == rs.NodeAddress {
+		return
+	}
+	if !rs.Protocol.PeerSupports(e.Initiator, encoding.CapabilityPaymentReceipt) {
+		log.Info(fmt.Sprintf("initiator %s doesn't support PaymentReceipt, no receipt for %s", utils.APex2(e.Initiator), utils.HPex(e.LockSecretHash)))
+		return
+	}
+	msg := encoding.NewPaymentReceipt(tokenAddress, e.LockSecretHash, e.Amount, e.Initiator, rs.GetBlockNumber())
+	if r, err := rs.getTransferRecord(tokenAddress, e.LockSecretHash); err == nil {
+		msg.Metadata = r.Metadata
+	}
+	err := msg.Sign(rs.PrivateKey, msg)
+	if err != nil {
+		log.Error(fmt.Sprintf("sign PaymentReceipt err %s", err))
+		return
+	}
+	result := rs.Protocol.SendAsync(e.Initiator, msg)
+	go func() {
+		err := <-result.Result
+		if err != nil {
+			log.Warn(fmt.Sprintf("send %s to %s err %s", msg, utils.APex2(e.Initiator), err))
+		}
+	}()
+}
+
+//handlePaymentReceipt initiator saves receipt of a transfer it sent, receipts it cannot match are only logged, e.g. legs of a token swap
+func (rs *Service) handlePaymentReceipt(msg *encoding.PaymentReceipt) (err error) {
+	r, err := rs.getTransferRecord(msg.TokenAddress, rs.relocks.originOf(msg.LockSecretHash))
+	if err != nil {
+		log.Info(fmt.Sprintf("receive %s, but there is no such transfer", msg))
+		return nil
+	}
+	if r.Role != models.TransferRoleInitiator || r.Initiator != rs.NodeAddress || msg.Initiator != rs.NodeAddress {
+		log.Warn(fmt.Sprintf("receive %s, but i'm not initiator of the transfer", msg))
+		return nil
+	}
+	if r.Target != msg.Sender || r.Amount == nil || r.Amount.Cmp(msg.Amount) != 0 {
+		log.Warn(fmt.Sprintf("receive %s, but it doesn't match transfer target=%s,amount=%s", msg, utils.APex2(r.Target), r.Amount))
+		return nil
+	}
+	log.Info(fmt.Sprintf("receive %s", msg))
+	//receipt may arrive after the record is finished
+	r.Receipt = msg.Pack()
+	r.UpdateTime = time.Now().Unix()
+	return rs.saveTransferRecord(r)
+}
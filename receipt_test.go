@@ -0,0 +1,68 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePaymentReceipt(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:         dao,
+		NodeAddress: utils.NewRandomAddress(),
+	}
+	api := NewPhotonAPI(rs)
+	targetKey, _ := crypto.GenerateKey()
+	target := crypto.PubkeyToAddress(targetKey.PublicKey)
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	sign := func(amount int64, initiator bool) *encoding.PaymentReceipt {
+		from := utils.NewRandomAddress()
+		if initiator {
+			from = rs.NodeAddress
+		}
+		msg := encoding.NewPaymentReceipt(token, lockSecretHash, big.NewInt(amount), from, 100)
+		assert.Nil(t, msg.Sign(targetKey, msg))
+		return msg
+	}
+	// unmatched receipts are acked but not saved, so target doesn't resend them
+	assert.Nil(t, rs.handlePaymentReceipt(sign(10, true)))
+
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Initiator:      rs.NodeAddress,
+		Target:         target,
+		Amount:         big.NewInt(10),
+		Phase:          models.TransferPhaseSuccess,
+	})
+	_, err := api.GetTransferReceipt(token, target, lockSecretHash.String())
+	assert.Equal(t, rerr.ErrTransferReceiptNotFound, err)
+	// wrong amount, wrong initiator, signed by someone else
+	assert.Nil(t, rs.handlePaymentReceipt(sign(9, true)))
+	assert.Nil(t, rs.handlePaymentReceipt(sign(10, false)))
+	otherKey, _ := crypto.GenerateKey()
+	msg := encoding.NewPaymentReceipt(token, lockSecretHash, big.NewInt(10), rs.NodeAddress, 100)
+	assert.Nil(t, msg.Sign(otherKey, msg))
+	assert.Nil(t, rs.handlePaymentReceipt(msg))
+	_, err = api.GetTransferReceipt(token, target, lockSecretHash.String())
+	assert.Equal(t, rerr.ErrTransferReceiptNotFound, err)
+
+	// saved even though the record is finished
+	assert.Nil(t, rs.handlePaymentReceipt(sign(10, true)))
+	receipt, err := api.GetTransferReceipt(token, target, lockSecretHash.String())
+	assert.Nil(t, err)
+	assert.Equal(t, target, receipt.Sender)
+	assert.EqualValues(t, 100, receipt.BlockNumber)
+	assert.EqualValues(t, 10, receipt.Amount.Int64())
+}
@@ -0,0 +1,254 @@
+package photon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+relockTracker 记录可以换新密码重新发起的交易.
+每次重新发起都会用新的 lock secret hash, 但是用户看到的始终是同一笔交易, 即最初的 lock secret hash,
+交易记录, 状态, 撤销和 deadline 都按最初的 lock secret hash 处理.
+只保存在内存里, 重启以后锁过期的交易直接失败.
+交易结束以后别名依然保留, 因为接收方的收据可能晚到.
+*/
+/*
+ *	relockTracker : transfers which can be started again with a fresh secret after their lock expired.
+ *	Every attempt uses a new lock secret hash, but users always see one transfer, identified by the first lock secret hash,
+ *	records, status, cancel and deadline of the transfer all go by it.
+ *	It's kept in memory only, transfers whose lock expires after restart just fail.
+ *	Aliases are kept after transfers finish, because receipts of target may come later.
+ */
+type relockTracker struct {
+	transfers map[common.Hash]*outboundTransfer //key of the current attempt -> transfer
+	origin    map[common.Hash]common.Hash       //lock secret hash of a later attempt -> the first one
+	latest    map[common.Hash]common.Hash       //the first lock secret hash -> lock secret hash of the latest attempt
+	unacked   map[common.Hash]time.Time         //key of the current attempt -> when its MediatedTransfer was sent
+}
+
+func newRelockTracker() *relockTracker {
+	return &relockTracker{
+		transfers: make(map[common.Hash]*outboundTransfer),
+		origin:    make(map[common.Hash]common.Hash),
+		latest:    make(map[common.Hash]common.Hash),
+		unacked:   make(map[common.Hash]time.Time),
+	}
+}
+
+//originOf the lock secret hash users know for lockSecretHash, Service created by tests may have no tracker
+func (r *relockTracker) originOf(lockSecretHash common.Hash) common.Hash {
+	if r == nil {
+		return lockSecretHash
+	}
+	if o, ok := r.origin[lockSecretHash]; ok {
+		return o
+	}
+	return lockSecretHash
+}
+
+//latestOf lock secret hash of the latest attempt of the transfer users know as lockSecretHash
+func (r *relockTracker) latestOf(lockSecretHash common.Hash) common.Hash {
+	if r == nil {
+		return lockSecretHash
+	}
+	if l, ok := r.latest[lockSecretHash]; ok {
+		return l
+	}
+	return lockSecretHash
+}
+
+//track t until its lock is resolved
+func (r *relockTracker) track(t *outboundTransfer) {
+	if r == nil {
+		return
+	}
+	r.transfers[t.key()] = t
+}
+
+//take transfer of key out, nil if it's not tracked
+func (r *relockTracker) take(key common.Hash) *outboundTransfer {
+	if r == nil {
+		return nil
+	}
+	t := r.transfers[key]
+	delete(r.transfers, key)
+	delete(r.unacked, key)
+	return t
+}
+
+//sent MediatedTransfer of key is sent, false if the transfer can't be started again
+func (r *relockTracker) sent(key common.Hash, now time.Time) bool {
+	if r == nil || r.transfers[key] == nil {
+		return false
+	}
+	r.unacked[key] = now
+	return true
+}
+
+//acked MediatedTransfer of key is acked by the first hop
+func (r *relockTracker) acked(key common.Hash) {
+	if r == nil {
+		return
+	}
+	delete(r.unacked, key)
+}
+
+//ackTimeout true if MediatedTransfer of key has been waiting for ack longer than timeout
+func (r *relockTracker) ackTimeout(key common.Hash, now time.Time, timeout time.Duration) bool {
+	if r == nil {
+		return false
+	}
+	sent, ok := r.unacked[key]
+	return ok && now.Sub(sent) >= timeout
+}
+
+//alias lockSecretHash is a new attempt of the transfer users know as origin
+func (r *relockTracker) alias(lockSecretHash, origin common.Hash) {
+	r.origin[lockSecretHash] = origin
+	r.latest[origin] = lockSecretHash
+}
+
+//deadlinePassed true if the deadline in blocks or seconds of t has passed
+func (t *outboundTransfer) deadlinePassed(blockNumber int64) bool {
+	return t.deadline > 0 && blockNumber >= t.deadline ||
+		!t.deadlineTime.IsZero() && !time.Now().Before(t.deadlineTime)
+}
+
+//updateTransferStatus the same as dao.UpdateTransferStatus, status of later attempts goes to the transfer users know
+func (rs *Service) updateTransferStatus(tokenAddress common.Address, lockSecretHash common.Hash, status models.TransferStatusCode, statusMessage string) {
+	rs.dao.UpdateTransferStatus(tokenAddress, rs.relocks.originOf(lockSecretHash), status, statusMessage)
+}
+
+/*
+relockTransfer 发起方的锁过期了, 或者第一跳没有及时确认 MediatedTransfer, 密码从来没有发出去过,
+在 deadline 之内换一个新的密码, 重新选择路由发起交易.
+旧的密码被丢弃, 不会再告诉任何人, 所以接收方最多只能拿到一个密码, 不会收到两次钱.
+旧的锁由状态机按照正常流程在过期以后移除. 没有确认的第一跳不再使用, 因为通道上的消息按顺序发送, 新的交易也会被卡住.
+之前所有尝试过的路由都计入 --max-route-attempts.
+*/
+/*
+ *	relockTransfer : lock of initiator expired, or first hop didn't ack the MediatedTransfer in time,
+ *	and its secret never left our node, start the transfer again with a fresh secret and new routes before its deadline.
+ *	The old secret is dropped and never revealed to anyone, so target can learn at most one secret and never gets paid twice.
+ *	The old lock is removed by the state machine the usual way after it expired.
+ *	First hops which didn't ack are not used again, messages of a channel are sent in order, so a new attempt would get stuck too.
+ *	Routes tried by all earlier attempts count against --max-route-attempts.
+ */
+func (rs *Service) relockTransfer(e *mediatedtransfer.EventRelockTransfer) {
+	key := utils.Sha3(e.LockSecretHash[:], e.Token[:])
+	t := rs.relocks.take(key)
+	result := rs.Transfer2Result[key]
+	delete(rs.Transfer2Result, key)
+	attempts := 0
+	if r, err := rs.getTransferRecord(e.Token, rs.relocks.originOf(e.LockSecretHash)); err == nil {
+		attempts = len(r.Attempts)
+	}
+	var reason models.TransferFailureReason
+	var err error
+	if t == nil {
+		//restarted, nothing to start again with
+		reason, err = models.TransferFailureLockExpired, errors.New(initiator.ReasonLockExpired)
+	} else if t.deadlinePassed(rs.GetBlockNumber()) {
+		reason, err = models.TransferFailureDeadlineExceeded, errors.New(initiator.ReasonDeadlineExceeded)
+	} else if rs.Config.MaxRouteAttempts > 0 && attempts >= rs.Config.MaxRouteAttempts {
+		reason, err = models.TransferFailureMaxRouteAttempts, errors.New(initiator.ReasonMaxRouteAttempts)
+	}
+	attemptReason, attemptMessage := models.TransferFailureLockExpired, "lock expired before target asked for the secret"
+	if e.AckTimeoutHop != utils.EmptyAddress {
+		attemptReason, attemptMessage = models.TransferFailureAckTimeout, "MediatedTransfer is not acked in time"
+	}
+	if err != nil {
+		rs.updateTransferStatus(e.Token, e.LockSecretHash, models.TransferStatusFailed, fmt.Sprintf("交易失败 err=%s", err))
+		rs.updateTransferRecord(e.Token, e.LockSecretHash, func(r *models.TransferRecord) {
+			r.Phase = models.TransferPhaseFailed
+			r.FailureReason = reason
+			r.FailureMessage = err.Error()
+			if len(r.Route) > 1 {
+				failAttempt(r, r.Route[1], attemptReason, attemptMessage)
+			}
+		})
+		if result != nil {
+			result.Result <- err
+		}
+		return
+	}
+	secret, lockSecretHash := rs.newSecret()
+	origin := rs.relocks.originOf(e.LockSecretHash)
+	rs.relocks.alias(lockSecretHash, origin)
+	log.Info(fmt.Sprintf("%s, transfer %s with lock %s is started again with lock %s",
+		attemptMessage, utils.HPex(origin), utils.HPex(e.LockSecretHash), utils.HPex(lockSecretHash)))
+	rs.updateTransferStatus(e.Token, lockSecretHash, models.TransferStatusCanCancel, "换新的密码重新发起交易")
+	rs.updateTransferRecord(e.Token, lockSecretHash, func(r *models.TransferRecord) {
+		r.Phase = models.TransferPhaseRouting
+		if len(r.Route) > 1 {
+			failAttempt(r, r.Route[1], attemptReason, attemptMessage)
+		}
+	})
+	t2 := *t
+	t2.secret = secret
+	t2.lockSecretHash = lockSecretHash
+	t2.attempts = attempts
+	if e.AckTimeoutHop != utils.EmptyAddress {
+		t2.excludeHops = make(map[common.Address]bool)
+		for hop := range t.excludeHops {
+			t2.excludeHops[hop] = true
+		}
+		t2.excludeHops[e.AckTimeoutHop] = true
+	}
+	rs.initiateTransfer(&t2, true)
+}
+
+//waitMediatedTransferAck check ack of the MediatedTransfer we just sent after InitiatorAckTimeout, only for transfers which can be started again
+func (rs *Service) waitMediatedTransferAck(tokenAddress common.Address, lockSecretHash common.Hash) {
+	if !rs.relocks.sent(utils.Sha3(lockSecretHash[:], tokenAddress[:]), time.Now()) {
+		return
+	}
+	time.AfterFunc(params.InitiatorAckTimeout, func() {
+		select {
+		case <-rs.quitChan:
+			return
+		default:
+		}
+		rs.transferAckTimeoutClient(lockSecretHash, tokenAddress)
+	})
+}
+
+//transferAckTimeout MediatedTransfer we initiated may have not been acked in time, nothing to do if it's acked or the transfer is finished
+func (rs *Service) transferAckTimeout(req *transferAckTimeoutReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	smKey := utils.Sha3(req.LockSecretHash[:], req.TokenAddress[:])
+	manager := rs.Transfer2StateManager[smKey]
+	if manager != nil && manager.Name == initiator.NameInitiatorTransition &&
+		rs.relocks.ackTimeout(smKey, time.Now(), params.InitiatorAckTimeout) {
+		rs.relocks.acked(smKey)
+		rs.StateMachineEventHandler.dispatch(manager, &mediatedtransfer.ActionAckTimeoutStateChange{
+			LockSecretHash: req.LockSecretHash,
+		})
+	}
+	result.Result <- nil
+	return
+}
+
+//excludeRoutes routes whose first hop is not in hops
+func excludeRoutes(routes []*route.State, hops map[common.Address]bool) []*route.State {
+	if len(hops) == 0 {
+		return routes
+	}
+	var rs []*route.State
+	for _, r := range routes {
+		if !hops[r.HopNode()] {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
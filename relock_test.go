@@ -0,0 +1,183 @@
+package photon
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/SmartMeshFoundation/Photon/utils/utest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRelockService(blockNumber int64) (rs *Service, closeDB func()) {
+	dao := codefortest.NewTestDB("")
+	rs = &Service{
+		dao:             dao,
+		Config:          &params.Config{},
+		NodeAddress:     utils.NewRandomAddress(),
+		BlockNumber:     new(atomic.Value),
+		Transfer2Result: make(map[common.Hash]*utils.AsyncResult),
+		relocks:         newRelockTracker(),
+	}
+	rs.BlockNumber.Store(blockNumber)
+	return rs, dao.CloseDB
+}
+
+func newTestRelockTransfer(rs *Service, deadline int64) *outboundTransfer {
+	secret, lockSecretHash := rs.newSecret()
+	t := &outboundTransfer{
+		tokenAddress:   utils.NewRandomAddress(),
+		target:         utils.NewRandomAddress(),
+		amount:         big.NewInt(10),
+		fee:            big.NewInt(0),
+		lockSecretHash: lockSecretHash,
+		secret:         secret,
+		deadline:       deadline,
+		relock:         true,
+		result:         utils.NewAsyncResult(),
+	}
+	rs.dao.NewTransferStatus(t.tokenAddress, lockSecretHash)
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   t.tokenAddress,
+		Role:           models.TransferRoleInitiator,
+		Target:         t.target,
+		Amount:         t.amount,
+		Route:          []common.Address{rs.NodeAddress, t.target},
+		Attempts:       []models.TransferAttempt{{Route: []common.Address{rs.NodeAddress, t.target}}},
+		Phase:          models.TransferPhaseWaitingSecretRequest,
+	})
+	rs.Transfer2Result[t.key()] = t.result
+	rs.relocks.track(t)
+	return t
+}
+
+func relockEventOf(t *outboundTransfer) *mediatedtransfer.EventRelockTransfer {
+	return &mediatedtransfer.EventRelockTransfer{
+		LockSecretHash: t.lockSecretHash,
+		Token:          t.tokenAddress,
+		Target:         t.target,
+	}
+}
+
+func TestRelockTransferWithFreshSecret(t *testing.T) {
+	rs, closeDB := newTestRelockService(100)
+	defer closeDB()
+	tr := newTestRelockTransfer(rs, 200)
+	rs.relockTransfer(relockEventOf(tr))
+	latest := rs.relocks.latestOf(tr.lockSecretHash)
+	assert.NotEqual(t, tr.lockSecretHash, latest)
+	assert.Equal(t, tr.lockSecretHash, rs.relocks.originOf(latest))
+	assert.True(t, rs.dao.IsLockSecretHashLearned(latest))
+	assert.Nil(t, rs.Transfer2Result[tr.key()])
+
+	//token has no channel graph, so the new attempt fails, and the failure goes to the transfer users know
+	assert.NotNil(t, <-tr.result.Result)
+	r, err := rs.dao.GetTransferRecord(tr.tokenAddress, tr.lockSecretHash)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.Equal(t, models.TransferFailureNoRoute, r.FailureReason)
+	if assert.Len(t, r.Attempts, 1) {
+		assert.Equal(t, models.TransferFailureLockExpired, r.Attempts[0].FailureReason)
+	}
+	_, err = rs.dao.GetTransferRecord(tr.tokenAddress, latest)
+	assert.NotNil(t, err)
+}
+
+func TestRelockTransferAfterDeadline(t *testing.T) {
+	rs, closeDB := newTestRelockService(200)
+	defer closeDB()
+	tr := newTestRelockTransfer(rs, 200)
+	rs.relockTransfer(relockEventOf(tr))
+	assert.Equal(t, tr.lockSecretHash, rs.relocks.latestOf(tr.lockSecretHash))
+	assert.NotNil(t, <-tr.result.Result)
+	r, err := rs.dao.GetTransferRecord(tr.tokenAddress, tr.lockSecretHash)
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+		assert.Equal(t, models.TransferFailureDeadlineExceeded, r.FailureReason)
+	}
+	ts, err := rs.dao.GetTransferStatus(tr.tokenAddress, tr.lockSecretHash)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, models.TransferStatusFailed, ts.Status)
+	}
+}
+
+func TestRelockTransferNotTracked(t *testing.T) {
+	rs, closeDB := newTestRelockService(100)
+	defer closeDB()
+	tr := newTestRelockTransfer(rs, 200)
+	//e.g. restarted, the transfer just fails
+	rs.relocks.take(tr.key())
+	rs.relockTransfer(relockEventOf(tr))
+	assert.NotNil(t, <-tr.result.Result)
+	r, err := rs.dao.GetTransferRecord(tr.tokenAddress, tr.lockSecretHash)
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.TransferFailureLockExpired, r.FailureReason)
+	}
+}
+
+func TestRelockTransferAfterAckTimeout(t *testing.T) {
+	rs, closeDB := newTestRelockService(100)
+	defer closeDB()
+	tr := newTestRelockTransfer(rs, 200)
+	e := relockEventOf(tr)
+	e.AckTimeoutHop = tr.target
+	rs.relockTransfer(e)
+	latest := rs.relocks.latestOf(tr.lockSecretHash)
+	assert.NotEqual(t, tr.lockSecretHash, latest)
+	assert.NotNil(t, <-tr.result.Result)
+	r, err := rs.dao.GetTransferRecord(tr.tokenAddress, tr.lockSecretHash)
+	if assert.Nil(t, err) && assert.Len(t, r.Attempts, 1) {
+		assert.Equal(t, models.TransferFailureAckTimeout, r.Attempts[0].FailureReason)
+	}
+}
+
+func TestRelockTransferMaxRouteAttempts(t *testing.T) {
+	rs, closeDB := newTestRelockService(100)
+	defer closeDB()
+	rs.Config.MaxRouteAttempts = 1
+	tr := newTestRelockTransfer(rs, 200)
+	rs.relockTransfer(relockEventOf(tr))
+	//the only route allowed has been tried
+	assert.Equal(t, tr.lockSecretHash, rs.relocks.latestOf(tr.lockSecretHash))
+	assert.NotNil(t, <-tr.result.Result)
+	r, err := rs.dao.GetTransferRecord(tr.tokenAddress, tr.lockSecretHash)
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.TransferFailureMaxRouteAttempts, r.FailureReason)
+		assert.Equal(t, models.TransferFailureLockExpired, r.Attempts[0].FailureReason)
+	}
+}
+
+func TestRelockTrackerAckTimeout(t *testing.T) {
+	rs, closeDB := newTestRelockService(100)
+	defer closeDB()
+	tr := newTestRelockTransfer(rs, 200)
+	now := time.Now()
+	assert.False(t, rs.relocks.sent(utils.NewRandomHash(), now), "transfers which can't be started again are not watched")
+	assert.True(t, rs.relocks.sent(tr.key(), now))
+	assert.False(t, rs.relocks.ackTimeout(tr.key(), now.Add(time.Second), time.Minute))
+	assert.True(t, rs.relocks.ackTimeout(tr.key(), now.Add(time.Minute), time.Minute))
+	rs.relocks.acked(tr.key())
+	assert.False(t, rs.relocks.ackTimeout(tr.key(), now.Add(time.Minute), time.Minute))
+	//sent again to another route
+	rs.relocks.sent(tr.key(), now.Add(time.Minute))
+	assert.False(t, rs.relocks.ackTimeout(tr.key(), now.Add(time.Minute), time.Minute))
+}
+
+func TestExcludeRoutes(t *testing.T) {
+	hop1, hop2 := utils.NewRandomAddress(), utils.NewRandomAddress()
+	r1 := utest.MakeRoute(hop1, big.NewInt(10), utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())
+	r2 := utest.MakeRoute(hop2, big.NewInt(10), utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())
+	assert.EqualValues(t, []*route.State{r1, r2}, excludeRoutes([]*route.State{r1, r2}, nil))
+	assert.EqualValues(t, []*route.State{r2}, excludeRoutes([]*route.State{r1, r2}, map[common.Address]bool{hop1: true}))
+}
@@ -41,11 +41,17 @@ const prepareWithdrawReqName = "mark withdraw"
 const cancelPrepareWithdrawReqName = "cancel mark withdraw"
 const tokenSwapMakerReqName = "tokenswapmaker"
 const tokenSwapTakerReqName = "tokenswaptaker"
+const tokenSwapOfferReqName = "tokenswapoffer"
+const startTokenSwapOfferReqName = "starttokenswapoffer"
 const cancelTransfer = "canceltransfer"
 const allowRevealSecretReqName = "AllowRevealSecret"
 const registerSecretReqName = "RegisterSecret"
 const getUnfinishedReceviedTransferReqName = "GetUnfinishedReceivedTransfer"
 const forceUnlockReqName = "ForceUnlock"
+const updateRevealTimeoutReqName = "UpdateRevealTimeout"
+const transferDeadlineReqName = "TransferDeadline"
+const watchTransferReqName = "WatchTransfer"
+const transferAckTimeoutReqName = "TransferAckTimeout"
 
 /*
 transfer api
@@ -55,9 +61,14 @@ type transferReq struct {
 	Amount           *big.Int
 	Target           common.Address
 	Fee              *big.Int
+	MaxFee           *big.Int //cap of total mediation fee, nil means no cap
 	Secret           common.Hash
-	IsDirectTransfer bool
+	IsDirectTransfer bool //prefer direct transfer, fall back to mediated transfer if direct channel is not usable
+	DirectOnly       bool //never fall back to mediated transfer
 	Data             string
+	Metadata         []byte //carried by MediatedTransfer to target, signed by us
+	Deadline         TransferDeadline
+	Identifier       string //client generated identifier for dedup, empty means no dedup
 }
 
 /*
@@ -106,6 +117,20 @@ type tokenSwapTakerReq struct {
 	tokenSwap *TokenSwap
 }
 
+/*
+maker's token swap offer, not sent until started by id
+*/
+type tokenSwapOfferReq struct {
+	tokenSwap *TokenSwap
+}
+
+/*
+start maker's token swap offer
+*/
+type startTokenSwapOfferReq struct {
+	LockSecretHash common.Hash
+}
+
 /*
 cancel transfer api
 */
@@ -135,7 +160,11 @@ Transfer `amount` between this node and `target`.
            - Network speed, making the transfer sufficiently fast so it doesn't
              expire.
 */
-func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, data string) *utils.AsyncResult {
+func (rs *Service) transferAsyncClient(tokenAddress, target common.Address, amount *big.Int, opts *TransferOptions) *utils.AsyncResult {
+	fee := opts.Fee
+	if fee == nil {
+		fee = utils.BigInt0
+	}
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
 		Name:  transferReqName,
@@ -143,10 +172,15 @@ func (rs *Service) transferAsyncClient(tokenAddress common.Address, amount *big.
 			TokenAddress:     tokenAddress,
 			Amount:           amount,
 			Target:           target,
-			Secret:           secret,
+			Secret:           opts.Secret,
 			Fee:              fee,
-			IsDirectTransfer: isDirectTransfer,
-			Data:             data,
+			MaxFee:           opts.MaxFee,
+			IsDirectTransfer: opts.IsDirectTransfer,
+			DirectOnly:       opts.DirectOnly,
+			Data:             opts.Data,
+			Metadata:         opts.Metadata,
+			Deadline:         opts.Deadline,
+			Identifier:       opts.Identifier,
 		},
 	}
 	return rs.sendReqClient(req)
@@ -269,6 +303,22 @@ func (rs *Service) tokenSwapTakerClient(tokenswap *TokenSwap) *utils.AsyncResult
 	}
 	return rs.sendReqClient(req)
 }
+func (rs *Service) tokenSwapOfferClient(tokenswap *TokenSwap) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  tokenSwapOfferReqName,
+		Req:   &tokenSwapOfferReq{tokenswap},
+	}
+	return rs.sendReqClient(req)
+}
+func (rs *Service) startTokenSwapOfferClient(lockSecretHash common.Hash) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  startTokenSwapOfferReqName,
+		Req:   &startTokenSwapOfferReq{lockSecretHash},
+	}
+	return rs.sendReqClient(req)
+}
 func (rs *Service) cancelTransferClient(lockSecretHash common.Hash, tokenAddress common.Address) *utils.AsyncResult {
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
@@ -350,3 +400,67 @@ func (rs *Service) forceUnlockClient(lockSecretHash, secret, channelIdentifier c
 	}
 	return rs.sendReqClient(req)
 }
+
+func (rs *Service) updateRevealTimeoutClient() *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  updateRevealTimeoutReqName,
+	}
+	return rs.sendReqClient(req)
+}
+
+type transferDeadlineReq struct {
+	LockSecretHash common.Hash
+	TokenAddress   common.Address
+}
+
+//transferDeadlineClient called when wall-clock deadline of a transfer passed
+func (rs *Service) transferDeadlineClient(lockSecretHash common.Hash, tokenAddress common.Address) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  transferDeadlineReqName,
+		Req: &transferDeadlineReq{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   tokenAddress,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type transferAckTimeoutReq struct {
+	LockSecretHash common.Hash
+	TokenAddress   common.Address
+}
+
+//transferAckTimeoutClient called when the MediatedTransfer we initiated may have not been acked in time
+func (rs *Service) transferAckTimeoutClient(lockSecretHash common.Hash, tokenAddress common.Address) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  transferAckTimeoutReqName,
+		Req: &transferAckTimeoutReq{
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   tokenAddress,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+
+type watchTransferReq struct {
+	TokenAddress   common.Address
+	LockSecretHash common.Hash
+	CallbackURL    string
+}
+
+//watchTransferClient subscribe status updates of a transfer, result.Tag is the update chan
+func (rs *Service) watchTransferClient(tokenAddress common.Address, lockSecretHash common.Hash, callbackURL string) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  watchTransferReqName,
+		Req: &watchTransferReq{
+			TokenAddress:   tokenAddress,
+			LockSecretHash: lockSecretHash,
+			CallbackURL:    callbackURL,
+		},
+	}
+	return rs.sendReqClient(req)
+}
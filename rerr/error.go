@@ -118,6 +118,13 @@ func InvalidNonce(msg string) error {
 	return newrerr("InvalidNonce", msg)
 }
 
+/*
+ErrNonceGap Raised when the received message has a nonce ahead of the expected one.
+
+    Messages in between are lost, the partner is asked to retransmit them.
+*/
+var ErrNonceGap = errors.New("NonceGap")
+
 /*
 ErrTransferUnwanted Raised when the node is not receiving new transfers.
 */
@@ -162,3 +169,21 @@ var ErrTransferTimeout = errors.New("TransferTimeout")
 
 // ErrStopCreateNewTransfer reject new transactions
 var ErrStopCreateNewTransfer = errors.New("new transactions are not allowed")
+
+//ErrTransferNotFound no such transfer
+var ErrTransferNotFound = errors.New("transfer not found")
+
+//ErrTransferCannotCancel secret of the transfer has left our node, it can no longer be cancelled
+var ErrTransferCannotCancel = errors.New("secret already revealed, transfer can no longer be cancelled")
+
+//ErrTokenSwapOfferNotFound no token swap offer with this id, or it has been started
+var ErrTokenSwapOfferNotFound = errors.New("token swap offer not found")
+
+//ErrLockSecretHashReused we already know the secret of this lock, accepting it would give the secret away for free
+var ErrLockSecretHashReused = errors.New("lock secret hash reused, its secret is already known")
+
+//ErrTransferReceiptNotFound target hasn't sent back receipt of the transfer
+var ErrTransferReceiptNotFound = errors.New("transfer has no receipt yet")
+
+//ErrPaymentProofNotFound transfer didn't succeed with us as initiator or target, so it has no payment proof
+var ErrPaymentProofNotFound = errors.New("transfer has no payment proof")
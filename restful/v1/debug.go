@@ -99,7 +99,7 @@ func EthBalance(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	v, err := API.Photon.Chain.Client.BalanceAt(context.Background(), addr, nil)
+	v, err := API.Photon.Chain.Client.GetBalance(context.Background(), addr)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusConflict)
 		return
@@ -160,3 +160,18 @@ func ForceUnlock(w rest.ResponseWriter, r *rest.Request) {
 		log.Warn(fmt.Sprintf("writejson err %s", err))
 	}
 }
+
+/*
+IncomingRateLimit limits of incoming messages of every partner, and which partners are throttled or banned
+*/
+func IncomingRateLimit(w rest.ResponseWriter, r *rest.Request) {
+	status := API.IncomingRateLimitStatus()
+	if status == nil {
+		rest.Error(w, "incoming messages are not rate limited", http.StatusNotFound)
+		return
+	}
+	err := w.WriteJson(status)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
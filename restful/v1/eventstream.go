@@ -0,0 +1,162 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/ant0ine/go-json-rest/rest"
+	"golang.org/x/net/websocket"
+)
+
+/*
+websocket 事件流:
+1. 客户端连接 /api/1/ws 以后发送订阅消息 eventSubscribeRequest, 收到 subscribed 以后开始接收事件
+2. 每个事件带有递增的 sequence, 客户端记录下一个要收的序号(最后一个事件的 sequence+1, 或者 subscribed 中的 next_sequence),
+	断线重连以后用它作为 from_sequence 订阅, 不会遗漏事件. subscribed 中 lost 为 true 时表示有事件已经丢失,
+	需要通过 restful 接口重新获取状态. stream_started 变化表示节点重启过, 序号重新开始.
+3. 随时可以发送新的订阅消息替换原来的订阅
+4. 客户端读取太慢时收到 closed(reason=overflow) 然后连接被关闭, 重连即可
+*/
+/*
+ *	websocket event stream:
+ *	1. client connects to /api/1/ws and sends eventSubscribeRequest, events follow after subscribed is received.
+ *	2. every event carries an increasing sequence, client keeps the next sequence to receive(sequence of the last event+1,
+ *		or next_sequence of subscribed), and subscribes with it as from_sequence after reconnecting, nothing is lost.
+ *		lost of subscribed is true if some events are lost already, state should be fetched again by restful api.
+ *		A different stream_started means node restarted and sequences started again.
+ *	3. a new subscribe message replaces the subscription at any time.
+ *	4. a client reading too slowly receives closed(reason=overflow) and is disconnected, it just reconnects.
+ */
+
+//eventSubscribeRequest subscribe message of client, from_sequence 0 means only new events
+type eventSubscribeRequest struct {
+	notify.EventFilter
+	FromSequence uint64 `json:"from_sequence"`
+}
+
+//eventStreamMessage messages other than events sent to client
+type eventStreamMessage struct {
+	Type          string `json:"type"` //subscribed, closed or error
+	StreamStarted int64  `json:"stream_started,omitempty"`
+	NextSequence  uint64 `json:"next_sequence,omitempty"`
+	Lost          bool   `json:"lost,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+/*
+EventStream upgrades to websocket and streams events of channels, transfers and chain connection
+*/
+func EventStream(w rest.ResponseWriter, r *rest.Request) {
+	s := websocket.Server{
+		Handshake: checkEventStreamOrigin,
+		Handler:   serveEventStream,
+	}
+	s.ServeHTTP(w.(http.ResponseWriter), r.Request)
+}
+
+//checkEventStreamOrigin clients other than browsers send no origin, browsers must be on the same host, so other sites cannot read our events
+func checkEventStreamOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.ParseRequestURI(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != req.Host {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
+
+func serveEventStream(conn *websocket.Conn) {
+	defer conn.Close()
+	reqs := make(chan string)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(reqs)
+		for {
+			var msg string
+			err := websocket.Message.Receive(conn, &msg)
+			if err != nil {
+				return
+			}
+			select {
+			case reqs <- msg:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var sub *notify.EventSubscription
+	var events <-chan *notify.Event
+	defer func() {
+		if sub != nil {
+			sub.Unsubscribe()
+		}
+	}()
+	for {
+		select {
+		case msg, ok := <-reqs:
+			if !ok {
+				return
+			}
+			s, err := subscribeEvents(msg)
+			if err != nil {
+				err = websocket.JSON.Send(conn, &eventStreamMessage{Type: "error", Reason: err.Error()})
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if sub != nil {
+				sub.Unsubscribe()
+			}
+			sub, events = s, s.Events
+			err = websocket.JSON.Send(conn, &eventStreamMessage{
+				Type:          "subscribed",
+				StreamStarted: sub.Started,
+				NextSequence:  sub.NextSequence,
+				Lost:          sub.Lost,
+			})
+			if err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				reason := "stopped"
+				if sub.Overflowed {
+					reason = "overflow"
+				}
+				err := websocket.JSON.Send(conn, &eventStreamMessage{Type: "closed", Reason: reason})
+				if err != nil {
+					log.Trace(fmt.Sprintf("send to event stream %s err %s", conn.Request().RemoteAddr, err))
+				}
+				sub = nil
+				return
+			}
+			err := websocket.JSON.Send(conn, e)
+			if err != nil {
+				log.Trace(fmt.Sprintf("send to event stream %s err %s", conn.Request().RemoteAddr, err))
+				return
+			}
+		}
+	}
+}
+
+func subscribeEvents(msg string) (*notify.EventSubscription, error) {
+	req := &eventSubscribeRequest{}
+	err := json.Unmarshal([]byte(msg), req)
+	if err != nil {
+		return nil, errors.New("invalid subscribe message")
+	}
+	return API.SubscribeEvents(&req.EventFilter, req.FromSequence)
+}
@@ -63,7 +63,11 @@ func Start() {
 		rest.Get("/api/1/queryreceivedtransfer", GetReceivedTransfers),
 		rest.Post("/api/1/transfers/:token/:target", Transfers),
 		rest.Get("/api/1/transferstatus/:token/:locksecrethash", GetTransferStatus),
+		rest.Get("/api/1/transfers/:token/:target/:id", GetTransferRecord),
+		rest.Get("/api/1/transfers/:token/:target/:id/receipt", GetTransferReceipt),
+		rest.Get("/api/1/transfers/:token/:target/:id/proof", GetPaymentProof),
 		rest.Post("/api/1/transfercancel/:token/:locksecrethash", CancelTransfer),
+		rest.Delete("/api/1/transfers/:token/:target/:id", CancelTransferByID),
 		/*
 			transfer with specified secret
 		*/
@@ -74,6 +78,8 @@ func Start() {
 			token swap
 		*/
 		rest.Put("/api/1/token_swaps/:target/:locksecrethash", TokenSwap),
+		rest.Post("/api/1/token_swap_offers/:target", OfferTokenSwap),
+		rest.Put("/api/1/token_swap_offers/:locksecrethash", StartTokenSwapOffer),
 		/*
 			accounts
 		*/
@@ -105,7 +111,25 @@ func Start() {
 		rest.Get("/api/1/secret", GetRandomSecret), // api to provide random secret and lockSecretHash pair
 		rest.Get("/api/1/fee_policy", GetFeePolicy),
 		rest.Post("/api/1/fee_policy", SetFeePolicy),
+		rest.Put("/api/1/fee_policy", SetFeePolicy),
+		rest.Get("/api/1/reveal_timeout_policy", GetRevealTimeoutPolicy),
+		rest.Post("/api/1/reveal_timeout_policy", SetRevealTimeoutPolicy),
+		rest.Get("/api/1/accept_policy", GetAcceptPolicy),
+		rest.Post("/api/1/accept_policy", SetAcceptPolicy),
 		rest.Get("/api/1/fee", GetAllFeeChargeRecord),
+		/*
+			monitor
+		*/
+		rest.Post("/api/1/monitor/register", RegisterAsMonitor),
+		rest.Get("/api/1/monitor/channels", GetMonitoredChannels),
+		rest.Post("/api/1/monitor/delegate/:channel/:monitor", DelegateMonitor),
+		/*
+			automatic top-up
+		*/
+		rest.Get("/api/1/topup", GetAllTopUpPolicy),
+		rest.Get("/api/1/topup/:channel", GetTopUpPolicy),
+		rest.Put("/api/1/topup/:channel", SetTopUpPolicy),
+		rest.Delete("/api/1/topup/:channel", RemoveTopUpPolicy),
 
 		/*
 			test
@@ -136,6 +160,7 @@ func Start() {
 		//rest.Get("/api/1/events/network", EventNetwork),
 		//rest.Get("/api/1/events/tokens/:token", EventTokens),
 		//rest.Get("/api/1/events/channels/:channel", EventChannels),
+		rest.Get("/api/1/ws", EventStream), //websocket stream of channel, transfer and chain connection events
 		/*
 			for debug only
 		*/
@@ -147,6 +172,7 @@ func Start() {
 		rest.Get("/api/1/debug/force-unlock/:channel/:locksecrethash/:secrethash", ForceUnlock),
 		rest.Get("/api/1/debug/pfs/:channel", BalanceUpdateForPFS),
 		rest.Post("/api/1/debug/notify_network_down", NotifyNetworkDown), // notify photon network down
+		rest.Get("/api/1/debug/incoming-rate-limit", IncomingRateLimit),
 		rest.Get("/api/1/debug/shutdown", func(writer rest.ResponseWriter, request *rest.Request) {
 			API.Photon.Stop()
 			utils.SystemExit(0)
@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type monitorFeePayload struct {
+	Fee *big.Int `json:"fee"`
+}
+
+/*
+RegisterAsMonitor register this node as a monitor
+{"fee":10}
+fee is the minimum fee for each monitored channel
+*/
+func RegisterAsMonitor(w rest.ResponseWriter, r *rest.Request) {
+	req := &monitorFeePayload{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = API.RegisterAsMonitor(req.Fee)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = w.(http.ResponseWriter).Write([]byte("ok"))
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetMonitoredChannels list all channels this monitor watches for others
+*/
+func GetMonitoredChannels(w rest.ResponseWriter, r *rest.Request) {
+	ds, err := API.GetMonitoredChannels()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(ds)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+DelegateMonitor choose a monitor to watch channel, and pay fee to it
+/api/1/monitor/delegate/:channel/:monitor
+{"fee":10}
+*/
+func DelegateMonitor(w rest.ResponseWriter, r *rest.Request) {
+	channelIdentifier := common.HexToHash(r.PathParam("channel"))
+	monitor, err := utils.HexToAddress(r.PathParam("monitor"))
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if channelIdentifier == utils.EmptyHash || monitor == utils.EmptyAddress {
+		rest.Error(w, "argument error", http.StatusBadRequest)
+		return
+	}
+	req := &monitorFeePayload{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil || req.Fee == nil || req.Fee.Sign() < 0 {
+		rest.Error(w, "invalid fee", http.StatusBadRequest)
+		return
+	}
+	result, err := API.DelegateMonitor(channelIdentifier, monitor, req.Fee)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = <-result.Result
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	_, err = w.(http.ResponseWriter).Write([]byte("ok"))
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -124,6 +124,62 @@ func SetFeePolicy(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 
+// GetRevealTimeoutPolicy :
+func GetRevealTimeoutPolicy(w rest.ResponseWriter, r *rest.Request) {
+	err := w.WriteJson(API.GetRevealTimeoutPolicy())
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+// SetRevealTimeoutPolicy :
+func SetRevealTimeoutPolicy(w rest.ResponseWriter, r *rest.Request) {
+	req := &models.RevealTimeoutPolicy{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = API.SetRevealTimeoutPolicy(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = w.(http.ResponseWriter).Write([]byte("ok"))
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+// GetAcceptPolicy :
+func GetAcceptPolicy(w rest.ResponseWriter, r *rest.Request) {
+	err := w.WriteJson(API.GetAcceptPolicy())
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+// SetAcceptPolicy :
+func SetAcceptPolicy(w rest.ResponseWriter, r *rest.Request) {
+	req := &models.AcceptPolicy{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = API.SetAcceptPolicy(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = w.(http.ResponseWriter).Write([]byte("ok"))
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
 // FindPath :
 func FindPath(w rest.ResponseWriter, r *rest.Request) {
 	targetAddressStr := r.PathParam("target_address")
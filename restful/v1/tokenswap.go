@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ethereum/go-ethereum/common"
@@ -79,6 +80,16 @@ func TokenSwap(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if req.SendingAmount == nil || req.SendingAmount.Sign() <= 0 || req.ReceivingAmount == nil || req.ReceivingAmount.Sign() <= 0 {
+		err = fmt.Errorf("sending_amount and receiving_amount must be positive")
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = validateTokenSwapAmounts(makerToken, req.SendingAmount, takerToken, req.ReceivingAmount)
+	if err != nil {
+		writeTransferAmountError(w, err)
+		return
+	}
 	if req.Role == "maker" {
 		// 校验secret和lockSecretHash是否匹配
 		// check whether secret and lockSecretHash match.
@@ -105,3 +116,115 @@ func TokenSwap(w rest.ResponseWriter, r *rest.Request) {
 		}
 	}
 }
+
+/*
+OfferTokenSwap is the api of POST /api/1/token_swap_offers/:target
+maker creates an offer, the secret is generated by this node and only lock_secret_hash is returned as the id of the offer.
+*/
+func OfferTokenSwap(w rest.ResponseWriter, r *rest.Request) {
+	var err error
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> OfferTokenSwap ,err=%v", err))
+	}()
+	if API.Photon.StopCreateNewTransfers {
+		rest.Error(w, "Stop create new transfers, please restart Photon", http.StatusBadRequest)
+		return
+	}
+	type Req struct {
+		SendingAmount   *big.Int `json:"sending_amount"`
+		SendingToken    string   `json:"sending_token"`
+		ReceivingAmount *big.Int `json:"receiving_amount"`
+		ReceivingToken  string   `json:"receiving_token"`
+	}
+	type Offer struct {
+		LockSecretHash  common.Hash    `json:"lock_secret_hash"`
+		Target          common.Address `json:"target_address"`
+		SendingAmount   *big.Int       `json:"sending_amount"`
+		SendingToken    common.Address `json:"sending_token"`
+		ReceivingAmount *big.Int       `json:"receiving_amount"`
+		ReceivingToken  common.Address `json:"receiving_token"`
+	}
+	target, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &Req{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.SendingAmount == nil || req.SendingAmount.Sign() <= 0 || req.ReceivingAmount == nil || req.ReceivingAmount.Sign() <= 0 {
+		err = fmt.Errorf("sending_amount and receiving_amount must be positive")
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	makerToken, err := utils.HexToAddress(req.SendingToken)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	takerToken, err := utils.HexToAddress(req.ReceivingToken)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = validateTokenSwapAmounts(makerToken, req.SendingAmount, takerToken, req.ReceivingAmount)
+	if err != nil {
+		writeTransferAmountError(w, err)
+		return
+	}
+	lockSecretHash, err := API.OfferTokenSwap(makerToken, takerToken, target, req.SendingAmount, req.ReceivingAmount)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = w.WriteJson(&Offer{
+		LockSecretHash:  lockSecretHash,
+		Target:          target,
+		SendingAmount:   req.SendingAmount,
+		SendingToken:    makerToken,
+		ReceivingAmount: req.ReceivingAmount,
+		ReceivingToken:  takerToken,
+	})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+StartTokenSwapOffer is the api of PUT /api/1/token_swap_offers/:locksecrethash
+maker starts the offer after taker has registered it, returns when the swap finishes.
+*/
+func StartTokenSwapOffer(w rest.ResponseWriter, r *rest.Request) {
+	var err error
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> StartTokenSwapOffer ,err=%v", err))
+	}()
+	lockSecretHash := common.HexToHash(r.PathParam("locksecrethash"))
+	err = API.StartTokenSwapOffer(lockSecretHash)
+	if err == rerr.ErrTokenSwapOfferNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.(http.ResponseWriter).WriteHeader(http.StatusCreated)
+	_, err = w.(http.ResponseWriter).Write(nil)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+//validateTokenSwapAmounts both legs of a swap are transfers, so both amounts are checked against limits of their token
+func validateTokenSwapAmounts(sendingToken common.Address, sendingAmount *big.Int, receivingToken common.Address, receivingAmount *big.Int) error {
+	err := API.ValidateTransferAmount(sendingToken, sendingAmount)
+	if err != nil {
+		return err
+	}
+	return API.ValidateTransferAmount(receivingToken, receivingAmount)
+}
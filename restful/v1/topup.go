@@ -0,0 +1,103 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type topUpPolicyPayload struct {
+	LowWatermark *big.Int `json:"low_watermark"`
+	Target       *big.Int `json:"target"`
+	DailyLimit   *big.Int `json:"daily_limit"`
+}
+
+type topUpPolicyResponse struct {
+	Policy  *models.TopUpPolicy   `json:"policy"`
+	History []*models.TopUpRecord `json:"history"`
+}
+
+/*
+SetTopUpPolicy set automatic top-up policy of a channel
+/api/1/topup/:channel
+{"low_watermark":10,"target":100,"daily_limit":500}
+*/
+func SetTopUpPolicy(w rest.ResponseWriter, r *rest.Request) {
+	channelIdentifier := common.HexToHash(r.PathParam("channel"))
+	if channelIdentifier == utils.EmptyHash {
+		rest.Error(w, "argument error", http.StatusBadRequest)
+		return
+	}
+	req := &topUpPolicyPayload{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := API.SetTopUpPolicy(channelIdentifier, req.LowWatermark, req.Target, req.DailyLimit)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(p)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetTopUpPolicy returns top-up policy and automatic deposit history of a channel
+*/
+func GetTopUpPolicy(w rest.ResponseWriter, r *rest.Request) {
+	channelIdentifier := common.HexToHash(r.PathParam("channel"))
+	p, history, err := API.GetTopUpPolicy(channelIdentifier)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = w.WriteJson(&topUpPolicyResponse{
+		Policy:  p,
+		History: history,
+	})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetAllTopUpPolicy list all channels with automatic top-up
+*/
+func GetAllTopUpPolicy(w rest.ResponseWriter, r *rest.Request) {
+	ps, err := API.GetAllTopUpPolicy()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(ps)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+RemoveTopUpPolicy stop automatic top-up of a channel
+*/
+func RemoveTopUpPolicy(w rest.ResponseWriter, r *rest.Request) {
+	channelIdentifier := common.HexToHash(r.PathParam("channel"))
+	err := API.RemoveTopUpPolicy(channelIdentifier)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = w.(http.ResponseWriter).Write([]byte("ok"))
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
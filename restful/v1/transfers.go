@@ -5,12 +5,17 @@ import (
 	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/SmartMeshFoundation/Photon"
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
 	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 //TransferData post for transfers
@@ -19,12 +24,29 @@ type TransferData struct {
 	Target         string   `json:"target_address"`
 	Token          string   `json:"token_address"`
 	Amount         *big.Int `json:"amount"`
-	Secret         string   `json:"secret,omitempty"` // 当用户想使用自己指定的密码,而非随机密码时使用	// client can assign specific secret
+	AmountDecimal  string   `json:"amount_decimal,omitempty"` //以 token 为单位的金额,例如 "1.5",不能和 amount 同时指定	// amount in token units, e.g. "1.5", exclusive with amount
+	Secret         string   `json:"secret,omitempty"`         // 当用户想使用自己指定的密码,而非随机密码时使用	// client can assign specific secret
 	LockSecretHash string   `json:"lockSecretHash"`
 	Fee            *big.Int `json:"fee,omitempty"`
 	IsDirect       bool     `json:"is_direct,omitempty"`
+	DirectOnly     bool     `json:"direct_only,omitempty"` //只走直接通道,不会改走 mediated transfer	// never fall back to mediated transfer
+	Path           string   `json:"path,omitempty"`        //交易实际走的路径 direct 或者 mediated	// which path is used, direct or mediated
 	Sync           bool     `json:"sync,omitempty"` //是否同步
 	Data           string   `json:"data"`           // 交易附加信息,长度不超过256
+	// 总手续费上限,没有路由满足时交易失败	// cap of total mediation fee, transfer fails if no route fits
+	MaxFee *big.Int `json:"max_fee,omitempty"`
+	// 超过这么多块或者秒以后还没有完成就放弃交易	// give up the transfer if it's not done after so many blocks or seconds
+	DeadlineBlocks  int64 `json:"deadline_blocks,omitempty"`
+	DeadlineSeconds int64 `json:"deadline_seconds,omitempty"`
+	// 客户端生成的请求标识,也可以用 Idempotency-Key header 指定,同一个标识只会发起一次交易	// client generated identifier, or header Idempotency-Key, only one transfer is started for it
+	Identifier string                 `json:"identifier,omitempty"`
+	Duplicate  bool                   `json:"duplicate,omitempty"` //交易已经存在,没有发起新的交易	// transfer exists, no new one is started
+	Status     *models.TransferStatus `json:"status,omitempty"`    //已经存在的交易的状态	// status of the existing transfer
+	// 发起交易后立即返回,交易结束时通过 notice 通知,提供了 callback_url 时同时 POST 到这个地址	// return as soon as transfer started, notify by notice when finished, and POST to callback_url if provided
+	Async       bool   `json:"async,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	// 随 mediated transfer 一起发给接收方的 metadata(比如订单号),hex 编码,不超过256字节,由发起方签名	// opaque metadata sent to target with mediated transfer, e.g. an order id, hex, at most 256 bytes, signed by initiator
+	Metadata hexutil.Bytes `json:"metadata,omitempty"`
 }
 
 /*
@@ -96,10 +118,26 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.Amount.Cmp(utils.BigInt0) <= 0 {
+	if len(req.AmountDecimal) > 0 {
+		if req.Amount != nil {
+			rest.Error(w, "amount and amount_decimal are exclusive", http.StatusBadRequest)
+			return
+		}
+		req.Amount, err = API.ParseTransferAmount(tokenAddr, req.AmountDecimal)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Amount == nil || req.Amount.Cmp(utils.BigInt0) <= 0 {
 		rest.Error(w, "Invalid amount", http.StatusBadRequest)
 		return
 	}
+	err = API.ValidateTransferAmount(tokenAddr, req.Amount)
+	if err != nil {
+		writeTransferAmountError(w, err)
+		return
+	}
 	if req.Fee == nil {
 		req.Fee = utils.BigInt0
 	}
@@ -107,6 +145,10 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, "Invalid fee", http.StatusBadRequest)
 		return
 	}
+	if req.MaxFee != nil && req.MaxFee.Cmp(utils.BigInt0) < 0 {
+		rest.Error(w, "Invalid max_fee", http.StatusBadRequest)
+		return
+	}
 	if len(req.Secret) != 0 && len(req.Secret) != 64 && (strings.HasPrefix(req.Secret, "0x") && len(req.Secret) != 66) {
 		rest.Error(w, "Invalid secret", http.StatusBadRequest)
 		return
@@ -115,8 +157,37 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, "Invalid data, length must < 256", http.StatusBadRequest)
 		return
 	}
+	if len(req.Metadata) > params.MaxTransferMetadataLen {
+		rest.Error(w, fmt.Sprintf("Invalid metadata, length must <= %d", params.MaxTransferMetadataLen), http.StatusBadRequest)
+		return
+	}
+	if req.DirectOnly && len(req.Metadata) > 0 {
+		rest.Error(w, "metadata is carried only by mediated transfer, can't be used with direct_only", http.StatusBadRequest)
+		return
+	}
+	if req.DeadlineBlocks < 0 || req.DeadlineSeconds < 0 {
+		rest.Error(w, "Invalid deadline", http.StatusBadRequest)
+		return
+	}
+	if len(req.Identifier) == 0 {
+		req.Identifier = r.Header.Get("Idempotency-Key")
+	}
+	if len(req.Identifier) > params.MaxTransferIdentifierLen {
+		rest.Error(w, "Invalid identifier", http.StatusBadRequest)
+		return
+	}
+	if req.Async || len(req.CallbackURL) > 0 {
+		transferAsyncWithCallback(w, req, tokenAddr, targetAddr)
+		return
+	}
 	var result *utils.AsyncResult
-	if req.Sync {
+	if len(req.Identifier) > 0 {
+		result, err = API.TransferIdempotent(tokenAddr, targetAddr, req.Amount, req.options(), req.Sync)
+	} else if req.DirectOnly {
+		result, err = API.TransferDirectOnly(tokenAddr, req.Amount, req.Fee, targetAddr, common.HexToHash(req.Secret), req.Sync, req.Data)
+	} else if req.DeadlineBlocks > 0 || req.DeadlineSeconds > 0 || req.MaxFee != nil || len(req.Metadata) > 0 {
+		result, err = API.TransferWithOptions(tokenAddr, targetAddr, req.Amount, req.options(), req.Sync)
+	} else if req.Sync {
 		result, err = API.Transfer(tokenAddr, req.Amount, req.Fee, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Data)
 	} else {
 		result, err = API.TransferAsync(tokenAddr, req.Amount, req.Fee, targetAddr, common.HexToHash(req.Secret), req.IsDirect, req.Data)
@@ -132,6 +203,78 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 	req.Target = target
 	req.Token = token
 	req.LockSecretHash = result.LockSecretHash.String()
+	req.Path = "mediated"
+	if result.DirectTransfer {
+		req.Path = "direct"
+	}
+	if result.Duplicate {
+		req.Duplicate = true
+		req.Status, err = API.Photon.GetDao().GetTransferStatus(tokenAddr, result.LockSecretHash)
+		if err != nil {
+			log.Warn(fmt.Sprintf("GetTransferStatus of duplicate transfer %s err %s", req.LockSecretHash, err))
+			req.Status = nil
+		}
+	}
+	err = w.WriteJson(req)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+//options of the transfer, direct_only implies is_direct
+func (req *TransferData) options() *photon.TransferOptions {
+	return &photon.TransferOptions{
+		Fee:              req.Fee,
+		MaxFee:           req.MaxFee,
+		Secret:           common.HexToHash(req.Secret),
+		IsDirectTransfer: req.IsDirect || req.DirectOnly,
+		DirectOnly:       req.DirectOnly,
+		Data:             req.Data,
+		Metadata:         req.Metadata,
+		Deadline: photon.TransferDeadline{
+			Blocks:  req.DeadlineBlocks,
+			Timeout: time.Duration(req.DeadlineSeconds) * time.Second,
+		},
+		Identifier: req.Identifier,
+	}
+}
+
+//writeTransferAmountError besides the error message, tells which bound is violated and decimals of the token
+func writeTransferAmountError(w rest.ResponseWriter, err error) {
+	e, ok := err.(*photon.TransferAmountError)
+	if !ok {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	err = w.WriteJson(&struct {
+		Message string `json:"Error"`
+		*photon.TransferAmountError
+	}{e.Error(), e})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+//transferAsyncWithCallback start transfer and return immediately, result is delivered by notice and webhook
+func transferAsyncWithCallback(w rest.ResponseWriter, req *TransferData, tokenAddr, targetAddr common.Address) {
+	lockSecretHash, updates, err := API.TransferWatch(tokenAddr, targetAddr, req.Amount, req.options(), req.CallbackURL)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	// nobody waits for updates here
+	go func() {
+		for range updates {
+		}
+	}()
+	if req.Fee.Cmp(utils.BigInt0) == 0 {
+		req.Fee = nil
+	}
+	req.Initiator = API.Photon.NodeAddress.String()
+	req.Target = targetAddr.String()
+	req.Token = tokenAddr.String()
+	req.LockSecretHash = lockSecretHash.String()
 	err = w.WriteJson(req)
 	if err != nil {
 		log.Warn(fmt.Sprintf("writejson err %s", err))
@@ -161,6 +304,102 @@ func GetTransferStatus(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 
+// GetTransferRecord : query transfer record by lockSecretHash or identifier provided when the transfer was started
+func GetTransferRecord(w rest.ResponseWriter, r *rest.Request) {
+	tokenAddr, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetAddr, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	record, err := API.GetTransferRecord(tokenAddr, targetAddr, r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = w.WriteJson(record)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetTransferReceipt is the api of GET /api/1/transfers/:token/:target/:id/receipt
+returns the receipt signed by target, receipt is the packed message anyone can verify by encoding.VerifyPaymentReceipt.
+*/
+func GetTransferReceipt(w rest.ResponseWriter, r *rest.Request) {
+	type Receipt struct {
+		LockSecretHash common.Hash    `json:"lock_secret_hash"`
+		TokenAddress   common.Address `json:"token_address"`
+		Initiator      common.Address `json:"initiator_address"`
+		Target         common.Address `json:"target_address"`
+		Amount         *big.Int       `json:"amount"`
+		BlockNumber    int64          `json:"block_number"`
+		Signature      hexutil.Bytes  `json:"signature"`
+		Metadata       hexutil.Bytes  `json:"metadata,omitempty"`
+		Receipt        hexutil.Bytes  `json:"receipt"`
+	}
+	tokenAddr, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetAddr, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receipt, err := API.GetTransferReceipt(tokenAddr, targetAddr, r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = w.WriteJson(&Receipt{
+		LockSecretHash: receipt.LockSecretHash,
+		TokenAddress:   receipt.TokenAddress,
+		Initiator:      receipt.Initiator,
+		Target:         receipt.Sender,
+		Amount:         receipt.Amount,
+		BlockNumber:    receipt.BlockNumber,
+		Signature:      receipt.Signature,
+		Metadata:       receipt.Metadata,
+		Receipt:        receipt.Pack(),
+	})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetPaymentProof is the api of GET /api/1/transfers/:token/:target/:id/proof
+returns the payment proof of a transfer we sent or received successfully, anyone can verify it offline by models.VerifyPaymentProof.
+*/
+func GetPaymentProof(w rest.ResponseWriter, r *rest.Request) {
+	tokenAddr, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetAddr, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proof, err := API.ExportPaymentProof(tokenAddr, targetAddr, r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = w.WriteJson(proof)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
 // CancelTransfer : cancel a transfer when haven't send secret
 func CancelTransfer(w rest.ResponseWriter, r *rest.Request) {
 	var err error
@@ -182,3 +421,41 @@ func CancelTransfer(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 }
+
+/*
+CancelTransferByID cancel a transfer started by us before its secret left our node, id is the same as GetTransferRecord
+*/
+func CancelTransferByID(w rest.ResponseWriter, r *rest.Request) {
+	var err error
+	defer func() {
+		log.Trace(fmt.Sprintf("Restful Api Call ----> CancelTransferByID ,err=%v", err))
+	}()
+	tokenAddr, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetAddr, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = API.CancelTransferByID(tokenAddr, targetAddr, r.PathParam("id"))
+	if err == rerr.ErrTransferNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	record, err := API.GetTransferRecord(tokenAddr, targetAddr, r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = w.WriteJson(record)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
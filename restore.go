@@ -35,6 +35,12 @@ func (rs *Service) restore() {
 	//2. 为发送成功的 EnvelopMessage 继续发送
 	// 2. keep sending EnvelopMessage that failed previously.
 	rs.reSendEnvelopMessage()
+	//3. 排队的交易只在内存中, 重启以后不存在了
+	// 3. queued transfers are only kept in memory, they are gone after restart
+	rs.failQueuedTransferRecords()
+	//4. 以秒为单位的 deadline 重新开始计时
+	// 4. wall-clock deadlines of transfers start counting again
+	rs.rearmTransferDeadlines()
 }
 func (rs *Service) reSendEnvelopMessage() {
 	msgs := rs.dao.GetAllOrderedSentEnvelopMessager()
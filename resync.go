@@ -0,0 +1,126 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+nonce 不连续时的重新同步:
+1. 收到对方 nonce 跳过了一些的 balance proof, 通道记录 NonceGap, 不 ack 这个消息, 并且在补齐之前不能发起新交易
+2. 向对方发送 ResyncRequest, 带上收到的对方最后一个 balance proof 的 nonce, 一个 gap 只请求一次
+3. 对方从保存的还没有收到 ack 的消息中找出这个通道 nonce 更大的消息, 不经过发送队列按顺序重发
+4. 重发的消息和正常消息一样处理, 已经处理过的消息由 protocol 直接回复保存的 ack, 所以重复应用是安全的
+5. 丢失的消息补齐以后, 对方一直在重试的那个消息就可以被接受了, gap 解除
+*/
+/*
+ *	resync after a nonce gap:
+ *	1. a balance proof of partner skips some nonces, the channel records a NonceGap, the message is not acked,
+ *		and no new transfer can be initiated until the gap is filled.
+ *	2. send ResyncRequest to partner, carrying nonce of the last balance proof received from partner, only once for a gap.
+ *	3. partner finds messages of this channel with larger nonce among its saved messages not acked yet,
+ *		and resends them in order, not through the sending queue.
+ *	4. retransmitted messages are handled as usual, protocol answers already handled ones with the saved ack,
+ *		so applying them again is safe.
+ *	5. after the lost messages are filled, the message partner keeps retrying is accepted and the gap is resolved.
+ */
+
+//requestResync ask partner to retransmit balance proofs missing in channel
+func (rs *Service) requestResync(channelIdentifier common.Hash) {
+	ch, err := rs.findChannelByIdentifier(channelIdentifier)
+	if err != nil {
+		log.Error(fmt.Sprintf("requestResync %s", err))
+		return
+	}
+	//the gap is not saved with the message, which is refused
+	err = rs.dao.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
+	if err != nil {
+		log.Error(fmt.Sprintf("save nonce gap of channel %s err %s", ch.ChannelIdentifier.String(), err))
+	}
+	//old nodes never ack ResyncRequest, it would be resent forever, ask again when partner declares it
+	if !rs.Protocol.PeerSupports(ch.PartnerState.Address, encoding.CapabilityResync) {
+		log.Warn(fmt.Sprintf("channel %s has nonce gap %s, but %s doesn't support ResyncRequest",
+			ch.ChannelIdentifier.String(), ch.NonceGap(), utils.APex2(ch.PartnerState.Address)))
+		return
+	}
+	nonce, need := ch.NeedResync()
+	if !need {
+		return
+	}
+	msg := encoding.NewResyncRequest(&encoding.ChannelIDInMessage{
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		OpenBlockNumber:   ch.ChannelIdentifier.OpenBlockNumber,
+	}, nonce)
+	err = msg.Sign(rs.PrivateKey, msg)
+	if err != nil {
+		log.Error(fmt.Sprintf("sign ResyncRequest err %s", err))
+		return
+	}
+	log.Info(fmt.Sprintf("send %s to %s", msg, utils.APex2(ch.PartnerState.Address)))
+	result := rs.Protocol.SendAsync(ch.PartnerState.Address, msg)
+	go func() {
+		err := <-result.Result
+		if err != nil {
+			log.Warn(fmt.Sprintf("send %s err %s", msg, err))
+		}
+	}()
+}
+
+//handleResyncRequest partner lost some of our messages, retransmit them
+func (rs *Service) handleResyncRequest(msg *encoding.ResyncRequest) error {
+	ch, err := rs.findChannelByIdentifier(msg.ChannelIdentifier)
+	if err != nil {
+		return err
+	}
+	if ch.PartnerState.Address != msg.Sender || ch.ChannelIdentifier.OpenBlockNumber != msg.OpenBlockNumber {
+		return rerr.ChannelNotFound(fmt.Sprintf("receive %s, but it doesn't match channel %s with %s",
+			msg, ch.ChannelIdentifier.String(), utils.APex2(ch.PartnerState.Address)))
+	}
+	msgs := resyncMessages(rs.dao.GetAllOrderedSentEnvelopMessager(), msg)
+	log.Info(fmt.Sprintf("receive %s, retransmit %d messages, our nonce=%d", msg, len(msgs), ch.OurState.BalanceProofState.Nonce))
+	for _, m := range msgs {
+		rs.retransmit(m.Receiver, m.Message)
+	}
+	return nil
+}
+
+//resyncMessages messages not acked yet which req asks for, in nonce order
+func resyncMessages(msgs []*models.SentEnvelopMessager, req *encoding.ResyncRequest) (result []*models.SentEnvelopMessager) {
+	for _, m := range msgs {
+		evMsg := m.Message.GetEnvelopMessage()
+		if m.Receiver != req.Sender || evMsg.ChannelIdentifier != req.ChannelIdentifier ||
+			evMsg.OpenBlockNumber != req.OpenBlockNumber || evMsg.Nonce <= req.Nonce {
+			continue
+		}
+		result = append(result, m)
+	}
+	models.SortEnvelopMessager(result)
+	return
+}
+
+//retransmit a saved message out of the sending queue, the saved message is removed after ack as usual
+func (rs *Service) retransmit(recipient common.Address, msg encoding.EnvelopMessager) {
+	result := rs.Protocol.Retransmit(recipient, msg)
+	if result == nil {
+		//it's still being sent, whoever sending it handles the ack
+		return
+	}
+	go func() {
+		err := <-result.Result
+		if err == nil {
+			rs.ProtocolMessageSendComplete <- &protocolMessage{
+				receiver: recipient,
+				Message:  msg,
+			}
+		} else {
+			log.Error(fmt.Sprintf("retransmit %s finished ,but err=%s", utils.StringInterface(msg, 3), err))
+		}
+	}()
+}
@@ -0,0 +1,51 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResyncMessages(t *testing.T) {
+	partner := utils.NewRandomAddress()
+	id := &encoding.ChannelIDInMessage{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	}
+	newMsg := func(receiver common.Address, channel common.Hash, openBlockNumber int64, nonce uint64) *models.SentEnvelopMessager {
+		return &models.SentEnvelopMessager{
+			Message: encoding.NewDirectTransfer(&encoding.BalanceProof{
+				Nonce:             nonce,
+				ChannelIdentifier: channel,
+				OpenBlockNumber:   openBlockNumber,
+				TransferAmount:    big.NewInt(int64(nonce)),
+			}),
+			Receiver: receiver,
+		}
+	}
+	msgs := []*models.SentEnvelopMessager{
+		newMsg(partner, id.ChannelIdentifier, id.OpenBlockNumber, 5),
+		newMsg(partner, id.ChannelIdentifier, id.OpenBlockNumber, 2),
+		newMsg(partner, id.ChannelIdentifier, id.OpenBlockNumber, 4),
+		//another channel
+		newMsg(partner, utils.NewRandomHash(), id.OpenBlockNumber, 6),
+		//the same channel opened again
+		newMsg(partner, id.ChannelIdentifier, id.OpenBlockNumber+1, 7),
+		//not sent to requester
+		newMsg(utils.NewRandomAddress(), id.ChannelIdentifier, id.OpenBlockNumber, 8),
+	}
+	req := encoding.NewResyncRequest(id, 2)
+	req.Sender = partner
+	result := resyncMessages(msgs, req)
+	if assert.Len(t, result, 2) {
+		assert.EqualValues(t, 4, result[0].Message.GetEnvelopMessage().Nonce)
+		assert.EqualValues(t, 5, result[1].Message.GetEnvelopMessage().Nonce)
+	}
+	req.Nonce = 5
+	assert.Len(t, resyncMessages(msgs, req), 0)
+}
@@ -0,0 +1,124 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+reveal timeout 可以按 token 设置, 也可以为单个通道设置.
+修改以后只影响新发起的交易, 已经在进行中的交易使用的是发起时路由中保存的 reveal timeout.
+*/
+/*
+ *	Reveal timeout can be set per token and overridden per channel.
+ *	A change only affects transfers initiated after it, transfers in flight keep using
+ *	the reveal timeout saved in their routes when they started.
+ */
+
+//checkRevealTimeout reveal timeout must be positive and at most half of settle timeout,
+//so there is enough time to register secret and unlock on chain after channel closed.
+func checkRevealTimeout(revealTimeout, settleTimeout int) error {
+	if revealTimeout <= 0 {
+		return fmt.Errorf("reveal timeout must be positive, got %d", revealTimeout)
+	}
+	if revealTimeout*2 > settleTimeout {
+		return fmt.Errorf("reveal timeout %d is too large for settle timeout %d, must be at most half of it", revealTimeout, settleTimeout)
+	}
+	return nil
+}
+
+//revealTimeoutFor returns reveal timeout should be used by this channel, fallback to --reveal-timeout
+func (rs *Service) revealTimeoutFor(tokenAddress common.Address, channelIdentifier common.Hash, settleTimeout int) int {
+	t := rs.dao.GetRevealTimeoutPolicy().RevealTimeout(tokenAddress, channelIdentifier)
+	if t == 0 {
+		return rs.Config.RevealTimeout
+	}
+	if err := checkRevealTimeout(t, settleTimeout); err != nil {
+		log.Warn(fmt.Sprintf("channel %s ignore reveal timeout policy, %s", utils.HPex(channelIdentifier), err))
+		return rs.Config.RevealTimeout
+	}
+	return t
+}
+
+//updateRevealTimeout apply reveal timeout policy to all channels, must be called in main loop
+func (rs *Service) updateRevealTimeout() (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	for token, g := range rs.Token2ChannelGraph {
+		for _, c := range g.ChannelIdentifier2Channel {
+			t := rs.revealTimeoutFor(token, c.ChannelIdentifier.ChannelIdentifier, c.SettleTimeout)
+			if t == c.RevealTimeout {
+				continue
+			}
+			log.Info(fmt.Sprintf("channel %s reveal timeout changed from %d to %d",
+				utils.HPex(c.ChannelIdentifier.ChannelIdentifier), c.RevealTimeout, t))
+			c.RevealTimeout = t
+			err := rs.dao.UpdateChannelNoTx(channel.NewChannelSerialization(c))
+			if err != nil {
+				log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
+			}
+		}
+	}
+	result.Result <- nil
+	return
+}
+
+/*
+SetRevealTimeoutPolicy 设置 token 和通道的 reveal timeout, 0 表示使用默认值.
+每个值都必须满足所在通道 settle timeout 的要求.
+*/
+/*
+ *	SetRevealTimeoutPolicy : set reveal timeout of tokens and channels, 0 means use default.
+ *	Every value must be valid for settle timeout of channels it applies to.
+ */
+func (r *API) SetRevealTimeoutPolicy(p *models.RevealTimeoutPolicy) (err error) {
+	if p.TokenRevealTimeoutMap == nil {
+		p.TokenRevealTimeoutMap = make(map[common.Address]int)
+	}
+	if p.ChannelRevealTimeoutMap == nil {
+		p.ChannelRevealTimeoutMap = make(map[common.Hash]int)
+	}
+	for channelIdentifier, t := range p.ChannelRevealTimeoutMap {
+		if t == 0 {
+			continue
+		}
+		c, err := r.Photon.dao.GetChannelByAddress(channelIdentifier)
+		if err != nil {
+			return fmt.Errorf("channel %s not found", channelIdentifier.String())
+		}
+		if err = checkRevealTimeout(t, c.SettleTimeout); err != nil {
+			return fmt.Errorf("channel %s %s", channelIdentifier.String(), err)
+		}
+	}
+	for token, t := range p.TokenRevealTimeoutMap {
+		if t == 0 {
+			continue
+		}
+		cs, err := r.Photon.dao.GetChannelList(token, utils.EmptyAddress)
+		if err != nil {
+			return err
+		}
+		for _, c := range cs {
+			if _, ok := p.ChannelRevealTimeoutMap[c.ChannelIdentifier.ChannelIdentifier]; ok {
+				continue
+			}
+			if err = checkRevealTimeout(t, c.SettleTimeout); err != nil {
+				return fmt.Errorf("token %s channel %s %s", token.String(), c.ChannelIdentifier.ChannelIdentifier.String(), err)
+			}
+		}
+	}
+	err = r.Photon.dao.SaveRevealTimeoutPolicy(p)
+	if err != nil {
+		return
+	}
+	return <-r.Photon.updateRevealTimeoutClient().Result
+}
+
+//GetRevealTimeoutPolicy :
+func (r *API) GetRevealTimeoutPolicy() *models.RevealTimeoutPolicy {
+	return r.Photon.dao.GetRevealTimeoutPolicy()
+}
@@ -0,0 +1,242 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//maxUrgentGasPriceFactor gas price when the incoming lock is about to expire, times of the suggested one
+const maxUrgentGasPriceFactor = 2
+
+/*
+urgentGasPrice 离收到的锁过期越近, 注册密码的 gas price 越高, 保证交易能在过期前打包.
+剩余块数不少于 revealTimeout 时使用建议的 gas price, 之后线性增加, 到过期时达到建议值的 maxUrgentGasPriceFactor 倍.
+*/
+/*
+ *	urgentGasPrice : the closer the incoming lock is to its expiration, the higher the gas price to register its secret,
+ *	so the tx is mined in time. The suggested price is used while at least revealTimeout blocks are left,
+ *	then it grows linearly up to maxUrgentGasPriceFactor times of the suggested one at the expiration.
+ */
+func urgentGasPrice(suggested *big.Int, blocksLeft int64, revealTimeout int) *big.Int {
+	if suggested == nil {
+		return nil
+	}
+	timeout := int64(revealTimeout)
+	if timeout <= 0 || blocksLeft >= timeout {
+		return new(big.Int).Set(suggested)
+	}
+	if blocksLeft < 0 {
+		blocksLeft = 0
+	}
+	//suggested * (timeout + (factor-1)*(timeout-blocksLeft)) / timeout
+	p := new(big.Int).Mul(suggested, big.NewInt(timeout+(maxUrgentGasPriceFactor-1)*(timeout-blocksLeft)))
+	return p.Div(p, big.NewInt(timeout))
+}
+
+//pendingSecret a secret waiting to be registered on chain
+type pendingSecret struct {
+	lockExpiration int64 //0 means unknown, register as soon as possible
+	revealTimeout  int
+	lastAttempt    int64 //block number of the last attempt, -1 means never tried
+	inFlight       bool
+}
+
+/*
+secretRegistrar 收到的锁快要过期而上家一直不给 balance proof 时, 在链上注册密码.
+同一个块上需要注册的密码一次性发出, 注册失败的会在下一个块重试, 直到锁过期.
+等待注册的密码保存在数据库里, 重启以后继续注册.
+*/
+/*
+ *	secretRegistrar : registers secrets on chain when the incoming lock is about to expire
+ *	and the payer still doesn't send the balance proof.
+ *	Secrets due at the same block are sent in one batch, failed ones are retried on the next block until the lock expires.
+ *	Secrets waiting to be registered are kept in db, so registration goes on after restart.
+ */
+type secretRegistrar struct {
+	lock            sync.Mutex
+	secrets         map[common.Hash]*pendingSecret
+	db              models.PendingSecretDao //nil means not persisted
+	register        func(secrets []common.Hash, gasPrice *big.Int) *utils.AsyncResult
+	suggestGasPrice func() (*big.Int, error)
+}
+
+//newSecretRegistrar secrets saved in db are loaded, db may be nil
+func newSecretRegistrar(db models.PendingSecretDao, register func(secrets []common.Hash, gasPrice *big.Int) *utils.AsyncResult, suggestGasPrice func() (*big.Int, error)) *secretRegistrar {
+	r := &secretRegistrar{
+		secrets:         make(map[common.Hash]*pendingSecret),
+		db:              db,
+		register:        register,
+		suggestGasPrice: suggestGasPrice,
+	}
+	if db == nil {
+		return r
+	}
+	ps, err := db.GetAllPendingSecrets()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetAllPendingSecrets err %s", err))
+	}
+	for _, p := range ps {
+		r.secrets[p.Secret] = &pendingSecret{
+			lockExpiration: p.LockExpiration,
+			revealTimeout:  p.RevealTimeout,
+			lastAttempt:    -1,
+		}
+	}
+	if len(ps) > 0 {
+		log.Info(fmt.Sprintf("%d secrets are waiting to be registered on chain", len(ps)))
+	}
+	return r
+}
+
+//save p to db, must hold lock
+func (r *secretRegistrar) save(secret common.Hash, p *pendingSecret) {
+	if r.db == nil {
+		return
+	}
+	err := r.db.SavePendingSecret(&models.PendingSecret{
+		Secret:         secret,
+		LockExpiration: p.lockExpiration,
+		RevealTimeout:  p.revealTimeout,
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("SavePendingSecret %s err %s", utils.HPex(secret), err))
+	}
+}
+
+//remove secret from memory and db, must hold lock
+func (r *secretRegistrar) remove(secret common.Hash) {
+	delete(r.secrets, secret)
+	if r.db == nil {
+		return
+	}
+	err := r.db.RemovePendingSecret(secret)
+	if err != nil {
+		log.Error(fmt.Sprintf("RemovePendingSecret %s err %s", utils.HPex(secret), err))
+	}
+}
+
+//add secret to be registered before lockExpiration, the earliest expiration wins if it's added again
+func (r *secretRegistrar) add(secret common.Hash, lockExpiration int64, revealTimeout int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	p, ok := r.secrets[secret]
+	if !ok {
+		p = &pendingSecret{
+			lockExpiration: lockExpiration,
+			revealTimeout:  revealTimeout,
+			lastAttempt:    -1,
+		}
+		r.secrets[secret] = p
+		r.save(secret, p)
+		return
+	}
+	if lockExpiration > 0 && (p.lockExpiration == 0 || lockExpiration < p.lockExpiration) {
+		p.lockExpiration = lockExpiration
+		p.revealTimeout = revealTimeout
+		r.save(secret, p)
+	}
+}
+
+//Len number of secrets not registered yet, Service created by tests may have no registrar
+func (r *secretRegistrar) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.secrets)
+}
+
+/*
+flush 把所有没有在注册中, 且在这个块上还没尝试过的密码一次性注册.
+gas price 由最紧急的那个锁决定.
+*/
+/*
+ *	flush : register all secrets which are not in flight and not tried at blockNumber yet in one batch.
+ *	The gas price is decided by the most urgent lock.
+ */
+func (r *secretRegistrar) flush(blockNumber int64) {
+	if r.Len() == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var batch []common.Hash
+	var urgent *pendingSecret
+	for secret, p := range r.secrets {
+		if p.lockExpiration > 0 && p.lockExpiration <= blockNumber {
+			log.Error(fmt.Sprintf("lock of secret %s expired at %d before its secret is registered, you may lose your token",
+				secret.String(), p.lockExpiration))
+			r.remove(secret)
+			continue
+		}
+		if p.inFlight || p.lastAttempt >= blockNumber {
+			continue
+		}
+		batch = append(batch, secret)
+		if urgent == nil || p.lockExpiration > 0 && (urgent.lockExpiration == 0 || p.lockExpiration < urgent.lockExpiration) {
+			urgent = p
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	var gasPrice *big.Int
+	suggested, err := r.suggestGasPrice()
+	if err != nil {
+		log.Warn(fmt.Sprintf("SuggestGasPrice err %s, register secrets with the default gas price", err))
+	} else if urgent.lockExpiration > 0 {
+		gasPrice = urgentGasPrice(suggested, urgent.lockExpiration-blockNumber, urgent.revealTimeout)
+	} else {
+		gasPrice = suggested
+	}
+	for _, secret := range batch {
+		r.secrets[secret].inFlight = true
+		r.secrets[secret].lastAttempt = blockNumber
+	}
+	log.Info(fmt.Sprintf("register %d secrets on chain at block %d, gasPrice=%s", len(batch), blockNumber, gasPrice))
+	result := r.register(batch, gasPrice)
+	go func() {
+		err := <-result.Result
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		for _, secret := range batch {
+			p := r.secrets[secret]
+			if p == nil {
+				continue
+			}
+			if err == nil {
+				r.remove(secret)
+			} else {
+				//try again on the next block
+				p.inFlight = false
+			}
+		}
+		if err != nil {
+			log.Error(fmt.Sprintf("register secrets on chain err %s, will retry on the next block", err))
+		}
+	}()
+}
+
+/*
+registerSecrets 注册到期的密码, 每次处理完消息, 链上事件和用户请求以后调用.
+使用最新块减去 ForkConfirmNumber 判断锁是否过期, 最新的几个块可能因为分叉回退, 不能因为它们过早放弃注册.
+*/
+/*
+ *	registerSecrets : register secrets due on chain, called after every message, chain event and user request.
+ *	Whether a lock expired is judged by the latest block minus ForkConfirmNumber,
+ *	the latest few blocks may be reverted by a fork, registration must not be given up too early because of them.
+ */
+func (rs *Service) registerSecrets() {
+	if rs.secretRegistrar.Len() == 0 {
+		return
+	}
+	rs.secretRegistrar.flush(rs.GetBlockNumber() - params.ForkConfirmNumber)
+}
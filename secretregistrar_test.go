@@ -0,0 +1,137 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUrgentGasPrice(t *testing.T) {
+	suggested := big.NewInt(1000)
+	assert.EqualValues(t, 1000, urgentGasPrice(suggested, 20, 10).Int64())
+	assert.EqualValues(t, 1000, urgentGasPrice(suggested, 10, 10).Int64())
+	assert.EqualValues(t, 1500, urgentGasPrice(suggested, 5, 10).Int64())
+	assert.EqualValues(t, 2000, urgentGasPrice(suggested, 0, 10).Int64())
+	assert.EqualValues(t, 2000, urgentGasPrice(suggested, -3, 10).Int64())
+	assert.EqualValues(t, 1000, urgentGasPrice(suggested, 0, 0).Int64())
+	assert.Nil(t, urgentGasPrice(nil, 0, 10))
+	//suggested must not be changed
+	assert.EqualValues(t, 1000, suggested.Int64())
+}
+
+type registerCall struct {
+	secrets  []common.Hash
+	gasPrice *big.Int
+	result   *utils.AsyncResult
+}
+
+func newTestSecretRegistrar() (r *secretRegistrar, calls chan *registerCall) {
+	return newTestSecretRegistrarWithDB(nil)
+}
+
+func newTestSecretRegistrarWithDB(db models.PendingSecretDao) (r *secretRegistrar, calls chan *registerCall) {
+	calls = make(chan *registerCall, 10)
+	r = newSecretRegistrar(db, func(secrets []common.Hash, gasPrice *big.Int) *utils.AsyncResult {
+		c := &registerCall{secrets, gasPrice, utils.NewAsyncResult()}
+		calls <- c
+		return c.result
+	}, func() (*big.Int, error) {
+		return big.NewInt(1000), nil
+	})
+	return
+}
+
+//waitInFlight wait for the result of a register call to be handled
+func waitInFlight(t *testing.T, r *secretRegistrar, secret common.Hash, inFlight bool) {
+	for i := 0; i < 100; i++ {
+		r.lock.Lock()
+		p := r.secrets[secret]
+		r.lock.Unlock()
+		if (p != nil && p.inFlight) == inFlight {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("secret %s inFlight should be %v", secret.String(), inFlight)
+}
+
+func TestSecretRegistrarBatch(t *testing.T) {
+	r, calls := newTestSecretRegistrar()
+	s1, s2 := utils.NewRandomHash(), utils.NewRandomHash()
+	r.add(s1, 110, 10)
+	r.add(s2, 105, 10)
+	//the earliest expiration wins
+	r.add(s1, 120, 10)
+	r.flush(100)
+	c := <-calls
+	assert.Len(t, c.secrets, 2)
+	assert.Contains(t, c.secrets, s1)
+	assert.Contains(t, c.secrets, s2)
+	//decided by s2, 5 blocks left
+	assert.EqualValues(t, 1500, c.gasPrice.Int64())
+	//nothing is sent again while in flight
+	r.flush(101)
+	assert.Len(t, calls, 0)
+	c.result.Result <- nil
+	waitInFlight(t, r, s1, false)
+	assert.Equal(t, 0, r.Len())
+	r.flush(102)
+	assert.Len(t, calls, 0)
+	var nilRegistrar *secretRegistrar
+	assert.Equal(t, 0, nilRegistrar.Len())
+}
+
+func TestSecretRegistrarRetryUntilExpired(t *testing.T) {
+	r, calls := newTestSecretRegistrar()
+	s := utils.NewRandomHash()
+	r.add(s, 105, 10)
+	r.flush(100)
+	c := <-calls
+	c.result.Result <- errors.New("tx failed")
+	waitInFlight(t, r, s, false)
+	//retried only once per block
+	r.flush(100)
+	assert.Len(t, calls, 0)
+	r.flush(102)
+	c = <-calls
+	assert.Equal(t, []common.Hash{s}, c.secrets)
+	assert.EqualValues(t, 1700, c.gasPrice.Int64())
+	c.result.Result <- errors.New("tx failed")
+	waitInFlight(t, r, s, false)
+	//too late
+	r.flush(105)
+	assert.Len(t, calls, 0)
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestSecretRegistrarRestore(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	r, _ := newTestSecretRegistrarWithDB(dao)
+	s1, s2 := utils.NewRandomHash(), utils.NewRandomHash()
+	r.add(s1, 110, 10)
+	r.add(s2, 105, 10)
+	r.add(s1, 108, 10)
+	//restart
+	r, calls := newTestSecretRegistrarWithDB(dao)
+	assert.Equal(t, 2, r.Len())
+	assert.EqualValues(t, 108, r.secrets[s1].lockExpiration)
+	assert.EqualValues(t, 10, r.secrets[s1].revealTimeout)
+	//s2 expired, s1 is registered
+	r.flush(106)
+	c := <-calls
+	assert.Equal(t, []common.Hash{s1}, c.secrets)
+	c.result.Result <- nil
+	waitInFlight(t, r, s1, false)
+	assert.Equal(t, 0, r.Len())
+	ps, err := dao.GetAllPendingSecrets()
+	assert.Nil(t, err)
+	assert.Len(t, ps, 0)
+}
@@ -0,0 +1,41 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//markSecretLearned remember we know the secret of lockSecretHash, transfers reusing it will be refused
+func (rs *Service) markSecretLearned(lockSecretHash common.Hash) {
+	if rs.dao.IsLockSecretHashLearned(lockSecretHash) {
+		return
+	}
+	err := rs.dao.MarkLockSecretHashLearned(lockSecretHash)
+	if err != nil {
+		log.Error(fmt.Sprintf("MarkLockSecretHashLearned %s err %s", utils.HPex(lockSecretHash), err))
+	}
+}
+
+/*
+newSecret 为我们发起的交易生成随机密码, 来自 crypto/rand.
+保证和我们知道的任何密码都不相同, 否则别人可以用同一个 lockSecretHash 的交易从我们这里得到密码.
+*/
+/*
+ *	newSecret : random secret from crypto/rand for a transfer we initiate.
+ *	It never equals any secret we know, otherwise others could get it from us by a transfer of the same lock secret hash.
+ */
+func (rs *Service) newSecret() (secret, lockSecretHash common.Hash) {
+	for {
+		secret = utils.NewRandomHash()
+		lockSecretHash = utils.ShaSecret(secret[:])
+		if !rs.dao.IsLockSecretHashLearned(lockSecretHash) {
+			break
+		}
+		log.Error(fmt.Sprintf("random secret collides with a known one, lockSecretHash=%s", lockSecretHash.String()))
+	}
+	rs.markSecretLearned(lockSecretHash)
+	return
+}
@@ -0,0 +1,59 @@
+package photon
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/encoding"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecretIsLearned(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{dao: dao}
+	secret, lockSecretHash := rs.newSecret()
+	assert.Equal(t, utils.ShaSecret(secret[:]), lockSecretHash)
+	assert.True(t, dao.IsLockSecretHashLearned(lockSecretHash))
+	secret2, lockSecretHash2 := rs.newSecret()
+	assert.NotEqual(t, secret, secret2)
+	assert.NotEqual(t, lockSecretHash, lockSecretHash2)
+}
+
+func TestRefuseTransferWithLearnedSecretHash(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	token, partner := utils.NewRandomAddress(), utils.NewRandomAddress()
+	channelIdentifier := utils.NewRandomHash()
+	ch := &channel.Channel{TokenAddress: token, State: channeltype.StateOpened}
+	rs := &Service{
+		dao:         dao,
+		Config:      &params.Config{},
+		NodeAddress: utils.NewRandomAddress(),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{
+			token: {
+				ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{channelIdentifier: ch},
+				PartenerAddress2Channel:   map[common.Address]*channel.Channel{partner: ch},
+			},
+		},
+	}
+	mh := newPhotonMessageHandler(rs)
+	_, lockSecretHash := rs.newSecret()
+	msg := &encoding.MediatedTransfer{
+		LockSecretHash: lockSecretHash,
+		Target:         rs.NodeAddress,
+	}
+	msg.Sender = partner
+	msg.ChannelIdentifier = channelIdentifier
+	assert.Equal(t, rerr.ErrLockSecretHashReused, mh.messageMediatedTransfer(msg))
+	//the same for mediated ones
+	msg.Target = utils.NewRandomAddress()
+	assert.Equal(t, rerr.ErrLockSecretHashReused, mh.messageMediatedTransfer(msg))
+}
@@ -0,0 +1,123 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//tokenDecimals decimals of tokens, they never change, so each token is fetched from chain only once
+type tokenDecimals struct {
+	lock     sync.Mutex
+	decimals map[common.Address]uint8
+	fetch    func(token common.Address) (uint8, error)
+}
+
+func newTokenDecimals(fetch func(token common.Address) (uint8, error)) *tokenDecimals {
+	return &tokenDecimals{
+		decimals: make(map[common.Address]uint8),
+		fetch:    fetch,
+	}
+}
+
+//get decimals of token, fetch it if it's not cached yet, failures are not cached.
+//fetching is out of the lock, so a slow chain never blocks tokens already cached
+func (d *tokenDecimals) get(token common.Address) (uint8, error) {
+	if d == nil {
+		return 0, rerr.UnknownTokenAddress(token.String())
+	}
+	d.lock.Lock()
+	v, ok := d.decimals[token]
+	d.lock.Unlock()
+	if ok {
+		return v, nil
+	}
+	//decimals never change, it doesn't matter if another lookup fetches the same token meanwhile
+	v, err := d.fetch(token)
+	if err != nil {
+		return 0, err
+	}
+	d.lock.Lock()
+	d.decimals[token] = v
+	d.lock.Unlock()
+	return v, nil
+}
+
+//fetchTokenDecimals decimals of a registered token from chain
+func (rs *Service) fetchTokenDecimals(token common.Address) (uint8, error) {
+	tokens, err := rs.dao.GetAllTokens()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := tokens[token]; !ok {
+		return 0, rerr.UnknownTokenAddress(token.String())
+	}
+	t, err := rs.Chain.Token(token)
+	if err != nil {
+		return 0, err
+	}
+	return t.Decimals()
+}
+
+//TransferAmountError amount of a transfer request is out of the bounds configured for its token
+type TransferAmountError struct {
+	Token    common.Address `json:"token_address"`
+	Amount   *big.Int       `json:"amount"`
+	Bound    string         `json:"bound"` //min or max, which bound is violated
+	Limit    *big.Int       `json:"limit"`
+	Decimals *uint8         `json:"decimals,omitempty"` //nil if decimals of the token can't be fetched
+}
+
+func (e *TransferAmountError) Error() string {
+	if e.Decimals == nil {
+		return fmt.Sprintf("amount %s of token %s violates %s limit %s", e.Amount, e.Token.String(), e.Bound, e.Limit)
+	}
+	return fmt.Sprintf("amount %s of token %s violates %s limit %s, token has %d decimals",
+		utils.FormatDecimalAmount(e.Amount, *e.Decimals), e.Token.String(), e.Bound, utils.FormatDecimalAmount(e.Limit, *e.Decimals), *e.Decimals)
+}
+
+//TokenDecimals decimals of a registered token, amounts are in units of 10^-decimals token
+func (r *API) TokenDecimals(token common.Address) (uint8, error) {
+	return r.Photon.tokenDecimals.get(token)
+}
+
+//ParseTransferAmount convert amount in human units, e.g. "1.5", to the smallest unit of token
+func (r *API) ParseTransferAmount(token common.Address, amount string) (*big.Int, error) {
+	decimals, err := r.TokenDecimals(token)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseDecimalAmount(amount, decimals)
+}
+
+/*
+ValidateTransferAmount 检查交易金额是否在配置的这个 token 的上下限之内, 没有配置的 token 不检查.
+超出时返回 *TransferAmountError, 说明违反了哪个限制以及 token 的 decimals, 方便用户发现单位用错了.
+*/
+/*
+ *	ValidateTransferAmount : check amount is within bounds configured for token, tokens not configured are not checked.
+ *	If it's out of bounds, *TransferAmountError tells which bound is violated and decimals of the token,
+ *	so users can find out they used the wrong unit.
+ */
+func (r *API) ValidateTransferAmount(token common.Address, amount *big.Int) error {
+	limit := r.Photon.Config.TransferAmountLimits[token]
+	if limit == nil {
+		return nil
+	}
+	e := &TransferAmountError{Token: token, Amount: amount}
+	if limit.Min != nil && amount.Cmp(limit.Min) < 0 {
+		e.Bound, e.Limit = "min", limit.Min
+	} else if limit.Max != nil && amount.Cmp(limit.Max) > 0 {
+		e.Bound, e.Limit = "max", limit.Max
+	} else {
+		return nil
+	}
+	if decimals, err := r.TokenDecimals(token); err == nil {
+		e.Decimals = &decimals
+	}
+	return e
+}
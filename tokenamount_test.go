@@ -0,0 +1,106 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAmountAPI(limits map[common.Address]*params.TransferAmountLimit, fetches *int) *API {
+	return &API{Photon: &Service{
+		Config: &params.Config{TransferAmountLimits: limits},
+		tokenDecimals: newTokenDecimals(func(token common.Address) (uint8, error) {
+			*fetches++
+			if token == utils.EmptyAddress {
+				return 0, errors.New("no such token")
+			}
+			return 3, nil
+		}),
+	}}
+}
+
+func TestTokenDecimalsCached(t *testing.T) {
+	fetches := 0
+	api := newTestAmountAPI(nil, &fetches)
+	token := utils.NewRandomAddress()
+	for i := 0; i < 2; i++ {
+		d, err := api.TokenDecimals(token)
+		assert.Nil(t, err)
+		assert.EqualValues(t, 3, d)
+	}
+	assert.Equal(t, 1, fetches)
+	//failures are not cached
+	_, err := api.TokenDecimals(utils.EmptyAddress)
+	assert.NotNil(t, err)
+	_, err = api.TokenDecimals(utils.EmptyAddress)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, fetches)
+
+	a, err := api.ParseTransferAmount(token, "1.25")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1250, a.Int64())
+	_, err = api.ParseTransferAmount(token, "1.2345")
+	assert.NotNil(t, err)
+}
+
+func TestTokenDecimalsFetchUnlocked(t *testing.T) {
+	slow, cached := utils.NewRandomAddress(), utils.NewRandomAddress()
+	fetching, release := make(chan struct{}), make(chan struct{})
+	d := newTokenDecimals(func(token common.Address) (uint8, error) {
+		if token == slow {
+			close(fetching)
+			<-release
+		}
+		return 3, nil
+	})
+	_, err := d.get(cached)
+	assert.Nil(t, err)
+	go d.get(slow)
+	<-fetching
+	//a token being fetched doesn't block others
+	done := make(chan struct{})
+	go func() {
+		d.get(cached)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lookup blocked by fetching another token")
+	}
+	close(release)
+}
+
+func TestValidateTransferAmount(t *testing.T) {
+	fetches := 0
+	token := utils.NewRandomAddress()
+	api := newTestAmountAPI(map[common.Address]*params.TransferAmountLimit{
+		token: {Min: big.NewInt(100), Max: big.NewInt(5000)},
+	}, &fetches)
+	assert.Nil(t, api.ValidateTransferAmount(token, big.NewInt(100)))
+	assert.Nil(t, api.ValidateTransferAmount(token, big.NewInt(5000)))
+	//tokens not configured are not limited
+	assert.Nil(t, api.ValidateTransferAmount(utils.NewRandomAddress(), big.NewInt(1)))
+	assert.Equal(t, 0, fetches)
+
+	err := api.ValidateTransferAmount(token, big.NewInt(99))
+	e, ok := err.(*TransferAmountError)
+	if assert.True(t, ok, err) {
+		assert.Equal(t, "min", e.Bound)
+		assert.EqualValues(t, 100, e.Limit.Int64())
+		assert.EqualValues(t, 3, *e.Decimals)
+		assert.Contains(t, e.Error(), "0.099")
+	}
+	err = api.ValidateTransferAmount(token, big.NewInt(5001))
+	e, ok = err.(*TransferAmountError)
+	if assert.True(t, ok, err) {
+		assert.Equal(t, "max", e.Bound)
+		assert.EqualValues(t, 5000, e.Limit.Int64())
+	}
+}
@@ -0,0 +1,31 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSwapOffer(t *testing.T) {
+	rs := &Service{LockSecretHash2Offer: make(map[common.Hash]*TokenSwap)}
+	secret := utils.NewRandomHash()
+	offer := &TokenSwap{
+		LockSecretHash: utils.ShaSecret(secret[:]),
+		Secret:         secret,
+		FromToken:      utils.NewRandomAddress(),
+		FromAmount:     big.NewInt(10),
+		ToToken:        utils.NewRandomAddress(),
+		ToAmount:       big.NewInt(100),
+		ToNodeAddress:  utils.NewRandomAddress(),
+	}
+	assert.Nil(t, <-rs.tokenSwapOffer(offer).Result)
+	assert.NotNil(t, <-rs.tokenSwapOffer(offer).Result)
+	assert.Equal(t, offer, rs.LockSecretHash2Offer[offer.LockSecretHash])
+
+	assert.Equal(t, rerr.ErrTokenSwapOfferNotFound, <-rs.startTokenSwapOffer(utils.NewRandomHash()).Result)
+	assert.Len(t, rs.LockSecretHash2Offer, 1)
+}
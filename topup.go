@@ -0,0 +1,279 @@
+package photon
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+通道自动充值:
+每个新块检查设置了策略的通道, 可用余额低于 LowWatermark 时, 用本节点账户存入押金使可用余额达到 Target.
+合约只允许通道参与方为自己存款, 所以出资账户就是本节点账户.
+每天(UTC)自动存入的总额不超过 DailyLimit, 账户余额不足时暂停, 余额足够以后自动恢复.
+每次自动充值都会记录在通道的充值历史中,并通知用户.
+*/
+/*
+ *	Automatic channel top-up:
+ *	On every new block, channels with a policy are checked, when distributable drops below LowWatermark,
+ *	node deposits from its own account to bring distributable up to Target.
+ *	Contract only allows a participant to deposit for himself, so the funding account is the node account.
+ *	Total automatic deposit in one day (UTC) never exceeds DailyLimit. Policy is paused when the account
+ *	has not enough token, and resumed automatically once it has.
+ *	Every automatic deposit is recorded in channel top-up history and notified to user.
+ *	Policies are cached in memory, db is not read every block. A channel stays in topUpInFlight from the deposit
+ *	until its ChannelNewBalance event is handled, so its distributable is already updated when checked again.
+ */
+
+//topUpPolicies copies of all policies, loaded from db only once
+func (rs *Service) topUpPolicies() (ps []*models.TopUpPolicy, err error) {
+	rs.topUpLock.Lock()
+	defer rs.topUpLock.Unlock()
+	if rs.topUpPolicyCache == nil {
+		ps, err = rs.dao.GetAllTopUpPolicy()
+		if err != nil {
+			return
+		}
+		rs.topUpPolicyCache = make(map[common.Hash]*models.TopUpPolicy)
+		for _, p := range ps {
+			rs.topUpPolicyCache[p.ChannelIdentifier] = p
+		}
+	}
+	ps = nil
+	for _, p := range rs.topUpPolicyCache {
+		p2 := *p
+		ps = append(ps, &p2)
+	}
+	return
+}
+
+//autoTopUpNewBlock checks all top-up policies, called in main loop
+func (rs *Service) autoTopUpNewBlock(blockNumber int64) {
+	ps, err := rs.topUpPolicies()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetAllTopUpPolicy err %s", err))
+		return
+	}
+	for _, p := range ps {
+		c, err := rs.findChannelByIdentifier(p.ChannelIdentifier)
+		if err != nil || c.State != channeltype.StateOpened {
+			continue
+		}
+		distributable := c.Distributable()
+		if distributable.Cmp(p.LowWatermark) >= 0 {
+			continue
+		}
+		amount := new(big.Int).Sub(p.Target, distributable)
+		rs.topUpLock.Lock()
+		if rs.topUpInFlight[p.ChannelIdentifier] {
+			rs.topUpLock.Unlock()
+			continue
+		}
+		rs.topUpInFlight[p.ChannelIdentifier] = true
+		rs.topUpLock.Unlock()
+		//query token balance and wait deposit tx outside of main loop
+		go rs.autoTopUp(p, new(big.Int).Set(distributable), amount, blockNumber)
+	}
+}
+
+//topUpUsedToday how much has been deposited automatically today(UTC), failed deposits excluded
+func (rs *Service) topUpUsedToday(channelIdentifier common.Hash) (used *big.Int, err error) {
+	used = big.NewInt(0)
+	rs2, err := rs.dao.GetTopUpRecords(channelIdentifier)
+	if err != nil {
+		return
+	}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Unix()
+	for _, r := range rs2 {
+		if r.Status != models.TopUpFailed && r.Timestamp >= today {
+			used.Add(used, r.Amount)
+		}
+	}
+	return
+}
+
+//autoTopUpDeposited our deposit to channel is handled, it can be topped up again
+func (rs *Service) autoTopUpDeposited(st *mediatedtransfer.ContractBalanceStateChange) {
+	if st.ParticipantAddress != rs.NodeAddress {
+		return
+	}
+	rs.topUpLock.Lock()
+	delete(rs.topUpInFlight, st.ChannelIdentifier)
+	rs.topUpLock.Unlock()
+}
+
+//autoTopUp deposits for channel, it stays in flight if deposit succeeds, see autoTopUpDeposited
+func (rs *Service) autoTopUp(p *models.TopUpPolicy, distributable, amount *big.Int, blockNumber int64) {
+	deposited := false
+	defer func() {
+		if deposited {
+			return
+		}
+		rs.topUpLock.Lock()
+		delete(rs.topUpInFlight, p.ChannelIdentifier)
+		rs.topUpLock.Unlock()
+	}()
+	used, err := rs.topUpUsedToday(p.ChannelIdentifier)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetTopUpRecords %s err %s", utils.HPex(p.ChannelIdentifier), err))
+		return
+	}
+	remain := new(big.Int).Sub(p.DailyLimit, used)
+	if remain.Sign() <= 0 {
+		log.Trace(fmt.Sprintf("channel %s reaches daily top-up limit %s", utils.HPex(p.ChannelIdentifier), p.DailyLimit))
+		return
+	}
+	if amount.Cmp(remain) > 0 {
+		amount = remain
+	}
+	token, err := rs.Chain.Token(p.TokenAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("get token %s err %s", utils.APex(p.TokenAddress), err))
+		return
+	}
+	balance, err := token.BalanceOf(rs.NodeAddress)
+	if err != nil {
+		log.Error(fmt.Sprintf("BalanceOf %s err %s", utils.APex(rs.NodeAddress), err))
+		return
+	}
+	if balance.Cmp(amount) < 0 {
+		if !p.Paused {
+			p.Paused = true
+			p.PauseReason = fmt.Sprintf("insufficient balance, need %s,have %s", amount, balance)
+			rs.saveTopUpPolicy(p)
+			rs.NotifyHandler.Notify(notify.LevelWarn, fmt.Sprintf("通道 %s 自动充值暂停,账户余额不足 need %s,have %s",
+				utils.HPex(p.ChannelIdentifier), amount, balance))
+		}
+		return
+	}
+	if p.Paused {
+		p.Paused = false
+		p.PauseReason = ""
+		rs.saveTopUpPolicy(p)
+		rs.NotifyHandler.Notify(notify.LevelInfo, fmt.Sprintf("通道 %s 自动充值恢复", utils.HPex(p.ChannelIdentifier)))
+	}
+	r := &models.TopUpRecord{
+		ChannelIdentifier: p.ChannelIdentifier,
+		TokenAddress:      p.TokenAddress,
+		Amount:            amount,
+		Distributable:     distributable,
+		BlockNumber:       blockNumber,
+		Timestamp:         time.Now().Unix(),
+		Status:            models.TopUpPending,
+	}
+	err = rs.dao.SaveTopUpRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTopUpRecord err %s", err))
+		return
+	}
+	log.Info(fmt.Sprintf("auto top-up channel %s amount=%s,distributable=%s", utils.HPex(p.ChannelIdentifier), amount, distributable))
+	rs.NotifyHandler.Notify(notify.LevelInfo, fmt.Sprintf("通道 %s 自动充值 %s", utils.HPex(p.ChannelIdentifier), amount))
+	err = <-rs.newChannelAndDeposit(p.TokenAddress, p.PartnerAddress, 0, amount, false).Result
+	if err != nil {
+		r.Status = models.TopUpFailed
+		r.StatusMessage = err.Error()
+		rs.NotifyHandler.Notify(notify.LevelError, fmt.Sprintf("通道 %s 自动充值 %s 失败 %s", utils.HPex(p.ChannelIdentifier), amount, err))
+	} else {
+		deposited = true
+		r.Status = models.TopUpSuccess
+		rs.NotifyHandler.Notify(notify.LevelInfo, fmt.Sprintf("通道 %s 自动充值 %s 成功", utils.HPex(p.ChannelIdentifier), amount))
+	}
+	err = rs.dao.SaveTopUpRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTopUpRecord err %s", err))
+	}
+}
+
+//saveTopUpPolicy saves pause state of p, policy may be changed or removed by user meanwhile
+func (rs *Service) saveTopUpPolicy(p *models.TopUpPolicy) {
+	rs.topUpLock.Lock()
+	defer rs.topUpLock.Unlock()
+	cached, ok := rs.topUpPolicyCache[p.ChannelIdentifier]
+	if !ok {
+		return
+	}
+	p2 := *cached
+	p2.Paused = p.Paused
+	p2.PauseReason = p.PauseReason
+	err := rs.dao.SaveTopUpPolicy(&p2)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTopUpPolicy err %s", err))
+		return
+	}
+	rs.topUpPolicyCache[p.ChannelIdentifier] = &p2
+}
+
+/*
+SetTopUpPolicy 设置通道自动充值策略, 可用余额低于 lowWatermark 时自动充值到 target, 每天最多充值 dailyLimit
+*/
+/*
+ *	SetTopUpPolicy : set automatic top-up policy of a channel, when distributable drops below lowWatermark,
+ *	deposit to bring it up to target, at most dailyLimit a day.
+ */
+func (r *API) SetTopUpPolicy(channelIdentifier common.Hash, lowWatermark, target, dailyLimit *big.Int) (p *models.TopUpPolicy, err error) {
+	if lowWatermark == nil || target == nil || dailyLimit == nil ||
+		lowWatermark.Sign() < 0 || target.Cmp(lowWatermark) <= 0 || dailyLimit.Sign() <= 0 {
+		err = errors.New("invalid top-up policy, must 0<=low_watermark<target and daily_limit>0")
+		return
+	}
+	c, err := r.Photon.dao.GetChannelByAddress(channelIdentifier)
+	if err != nil {
+		return
+	}
+	if c.State != channeltype.StateOpened {
+		err = errors.New("channel is not open")
+		return
+	}
+	p = &models.TopUpPolicy{
+		ChannelIdentifier: channelIdentifier,
+		TokenAddress:      c.TokenAddress(),
+		PartnerAddress:    c.PartnerAddress(),
+		LowWatermark:      lowWatermark,
+		Target:            target,
+		DailyLimit:        dailyLimit,
+	}
+	r.Photon.topUpLock.Lock()
+	defer r.Photon.topUpLock.Unlock()
+	err = r.Photon.dao.SaveTopUpPolicy(p)
+	if err == nil && r.Photon.topUpPolicyCache != nil {
+		p2 := *p
+		r.Photon.topUpPolicyCache[channelIdentifier] = &p2
+	}
+	return
+}
+
+//GetTopUpPolicy returns top-up policy and history of a channel
+func (r *API) GetTopUpPolicy(channelIdentifier common.Hash) (p *models.TopUpPolicy, history []*models.TopUpRecord, err error) {
+	p, err = r.Photon.dao.GetTopUpPolicy(channelIdentifier)
+	if err != nil {
+		return
+	}
+	history, err = r.Photon.dao.GetTopUpRecords(channelIdentifier)
+	return
+}
+
+//GetAllTopUpPolicy returns all channels with a top-up policy
+func (r *API) GetAllTopUpPolicy() ([]*models.TopUpPolicy, error) {
+	return r.Photon.dao.GetAllTopUpPolicy()
+}
+
+//RemoveTopUpPolicy stop automatic top-up of a channel, history is kept
+func (r *API) RemoveTopUpPolicy(channelIdentifier common.Hash) error {
+	r.Photon.topUpLock.Lock()
+	defer r.Photon.topUpLock.Unlock()
+	err := r.Photon.dao.RemoveTopUpPolicy(channelIdentifier)
+	if err == nil {
+		delete(r.Photon.topUpPolicyCache, channelIdentifier)
+	}
+	return err
+}
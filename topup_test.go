@@ -0,0 +1,66 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopUpPolicyCache(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:           dao,
+		NodeAddress:   utils.NewRandomAddress(),
+		topUpInFlight: make(map[common.Hash]bool),
+	}
+	api := NewPhotonAPI(rs)
+	p := &models.TopUpPolicy{
+		ChannelIdentifier: utils.NewRandomHash(),
+		LowWatermark:      big.NewInt(10),
+		Target:            big.NewInt(20),
+		DailyLimit:        big.NewInt(30),
+	}
+	assert.Nil(t, dao.SaveTopUpPolicy(p))
+	ps, err := rs.topUpPolicies()
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1)
+	// db is not read again
+	assert.Nil(t, dao.RemoveTopUpPolicy(p.ChannelIdentifier))
+	ps, err = rs.topUpPolicies()
+	assert.Nil(t, err)
+	assert.Len(t, ps, 1)
+	// pause state is saved, but a removed policy is not saved again
+	ps[0].Paused = true
+	rs.saveTopUpPolicy(ps[0])
+	p2, err := dao.GetTopUpPolicy(p.ChannelIdentifier)
+	if assert.Nil(t, err) {
+		assert.True(t, p2.Paused)
+	}
+	assert.Nil(t, api.RemoveTopUpPolicy(p.ChannelIdentifier))
+	rs.saveTopUpPolicy(ps[0])
+	_, err = dao.GetTopUpPolicy(p.ChannelIdentifier)
+	assert.NotNil(t, err)
+	ps, err = rs.topUpPolicies()
+	assert.Nil(t, err)
+	assert.Len(t, ps, 0)
+
+	// in flight until our own deposit is handled
+	rs.topUpInFlight[p.ChannelIdentifier] = true
+	rs.autoTopUpDeposited(&mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  p.ChannelIdentifier,
+		ParticipantAddress: utils.NewRandomAddress(),
+	})
+	assert.True(t, rs.topUpInFlight[p.ChannelIdentifier])
+	rs.autoTopUpDeposited(&mediatedtransfer.ContractBalanceStateChange{
+		ChannelIdentifier:  p.ChannelIdentifier,
+		ParticipantAddress: rs.NodeAddress,
+	})
+	assert.False(t, rs.topUpInFlight[p.ChannelIdentifier])
+}
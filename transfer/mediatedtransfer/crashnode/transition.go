@@ -84,11 +84,13 @@ func handleBlock(state *mt.CrashState, stateChange *transfer.BlockStateChange) *
 			// Maybe I received related code after start up
 			secret, found := l.Channel.PartnerState.GetSecret(state.LockSecretHash)
 			//临近过期了,或者通道已经关闭了,就立即注册密码
-			if found && (l.Lock.Expiration >= stateChange.BlockNumber && l.Lock.Expiration < stateChange.BlockNumber+int64(l.Channel.RevealTimeout) ||
+			if found && l.Lock.HashAlgorithm.CanRegisterOnChain() && (l.Lock.Expiration >= stateChange.BlockNumber && l.Lock.Expiration < stateChange.BlockNumber+int64(l.Channel.RevealTimeout) ||
 				l.Channel.State == channeltype.StateClosed) {
 				//临近过期了,需要通知链上注册
 				events = append(events, &mt.EventContractSendRegisterSecret{
-					Secret: secret,
+					Secret:         secret,
+					LockExpiration: l.Lock.Expiration,
+					RevealTimeout:  l.Channel.RevealTimeout,
 				})
 				//要等unlock之后才能移除
 			}
@@ -5,6 +5,7 @@ import (
 
 	"math/big"
 
+	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -26,20 +27,26 @@ type EventSendMediatedTransfer struct {
 	// no matter which channel received a mediated transfer, I have to send another mediated transfer,
 	// because which channel receives MediatedTransfer and leads me to send a new Transfer
 	// If I am the transfer initiator, then FromChannel should be null.
-	FromChannel common.Hash
+	FromChannel       common.Hash
+	HashAlgorithm     utils.HashAlgorithm
+	Metadata          []byte
+	MetadataSignature []byte //empty if we are the initiator, it's signed when the message is created
 }
 
 //NewEventSendMediatedTransfer create EventSendMediatedTransfer
 func NewEventSendMediatedTransfer(transfer *LockedTransferState, receiver common.Address) *EventSendMediatedTransfer {
 	return &EventSendMediatedTransfer{
-		Token:          transfer.Token,
-		Amount:         new(big.Int).Set(transfer.Amount),
-		LockSecretHash: transfer.LockSecretHash,
-		Initiator:      transfer.Initiator,
-		Target:         transfer.Target,
-		Expiration:     transfer.Expiration,
-		Receiver:       receiver,
-		Fee:            transfer.Fee,
+		Token:             transfer.Token,
+		Amount:            new(big.Int).Set(transfer.Amount),
+		LockSecretHash:    transfer.LockSecretHash,
+		Initiator:         transfer.Initiator,
+		Target:            transfer.Target,
+		Expiration:        transfer.Expiration,
+		Receiver:          receiver,
+		Fee:               transfer.Fee,
+		HashAlgorithm:     transfer.HashAlgorithm,
+		Metadata:          transfer.Metadata,
+		MetadataSignature: transfer.MetadataSignature,
 	}
 }
 
@@ -158,6 +165,10 @@ EventContractSendRegisterSecret emitted to register a secret on chain
 */
 type EventContractSendRegisterSecret struct {
 	Secret common.Hash
+	//LockExpiration expiration of the incoming lock, the secret must be registered before it
+	LockExpiration int64
+	//RevealTimeout of the incoming channel, used to decide how urgent the registration is
+	RevealTimeout int
 }
 
 /*
@@ -215,6 +226,24 @@ type EventWithdrawFailed struct {
 	Reason            string
 }
 
+/*
+EventRelockTransfer 发起方的锁过期了, 密码从来没有告诉过任何人, 接收方也就不可能拿到这笔钱.
+过期的锁照常移除, 交易不算失败, 由 Service 换一个新的密码重新发起.
+第一跳没有及时确认 MediatedTransfer 时也会重新发起, 这时锁还没有过期, 新的交易不再经过 AckTimeoutHop.
+*/
+/*
+ *	EventRelockTransfer : lock of initiator expired and its secret was never revealed to anyone, so target can't claim it.
+ *	The expired lock is removed as usual, the transfer doesn't fail, Service starts it again with a fresh secret.
+ *	It's started again too when the first hop doesn't ack the MediatedTransfer in time, the lock has not expired then,
+ *	and the new attempt doesn't go through AckTimeoutHop.
+ */
+type EventRelockTransfer struct {
+	LockSecretHash common.Hash
+	Token          common.Address
+	Target         common.Address
+	AckTimeoutHop  common.Address //empty if the lock expired
+}
+
 // EventSaveFeeChargeRecord :
 // 记录本次中转收取手续费的流水
 type EventSaveFeeChargeRecord struct {
@@ -241,4 +270,5 @@ func init() {
 	gob.Register(&EventUnlockFailed{})
 	gob.Register(&EventWithdrawSuccess{})
 	gob.Register(&EventWithdrawFailed{})
+	gob.Register(&EventRelockTransfer{})
 }
@@ -3,13 +3,14 @@ package initiator
 import (
 	"testing"
 
-	"github.com/SmartMeshFoundation/Photon/params"
 
 	"math/big"
 
 	"os"
 
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
 	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/params"
 	"github.com/SmartMeshFoundation/Photon/transfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
@@ -117,7 +118,7 @@ func TestInitWithUsableRoutes(t *testing.T) {
 	mtr := mtrs[0]
 	assert(t, mtr.Token, utest.UnitTokenAddress)
 	assert(t, mtr.Amount, amount, "transfer amount mismatch")
-	assert(t, mtr.Expiration, expiration-int64(params.DefaultRevealTimeout), "transfer expiration mismatch")
+	assert(t, mtr.Expiration, expiration-int64(utest.UnitRevealTimeout), "transfer expiration mismatch")
 	assert(t, mtr.LockSecretHash != utils.EmptyHash, true)
 	assert(t, mtr.Receiver, mediatorAddress)
 	assert(t, initiatorState.Route, routes[0])
@@ -317,6 +318,84 @@ func TestRefundTransferNoMoreRoutes(t *testing.T) {
 	assert(t, ok, true)
 	assert(t, sm.CurrentState == nil, true)
 }
+func TestRefundTransferMaxRouteAttempts(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	mediatorAddress := utest.HOP1
+	targetAddress := utest.HOP2
+	ourAddress := utest.ADDR
+	token := utest.UnitTokenAddress
+
+	routes := []*route.State{
+		utest.MakeRoute(mediatorAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+		utest.MakeRoute(utest.HOP3, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	currentState := makeInitiatorState(routes, targetAddress, utest.UnitTransferAmount, blockNumber, ourAddress, token)
+	currentState.MaxRouteAttempts = 1
+	stateChange := &mediatedtransfer.ReceiveAnnounceDisposedStateChange{
+		Sender:  mediatorAddress,
+		Token:   token,
+		Message: nil,
+		Lock: &mtree.Lock{
+			Expiration:     currentState.Transfer.Expiration,
+			LockSecretHash: currentState.LockSecretHash,
+			Amount:         amount,
+		},
+	}
+	sm := transfer.NewStateManager(StateTransition, currentState, NameInitiatorTransition, utils.ShaSecret([]byte("3")), utils.NewRandomAddress())
+
+	events := sm.Dispatch(stateChange)
+	assert(t, len(events), 3)
+	failed, ok := events[0].(*transfer.EventTransferSentFailed)
+	assert(t, ok, true, "the second route should not be tried")
+	assert(t, failed.Reason, ReasonMaxRouteAttempts)
+	_, ok = events[2].(*mediatedtransfer.EventSendAnnounceDisposedResponse)
+	assert(t, ok, true)
+	assert(t, sm.CurrentState == nil, true)
+}
+func TestExceptionSecretRequestRefusedByTarget(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	targetAddress := utest.HOP1
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(targetAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	initStateChange := makeInitStateChange(routes, targetAddress, amount, utest.UnitBlockNumber, utest.ADDR, token)
+	initStateChange.Db = channeltype.NewMockChannelDb()
+	sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, initStateChange.LockSecretHash, token)
+	sm.Dispatch(initStateChange)
+	state := sm.CurrentState.(*mediatedtransfer.InitiatorState)
+	// target asks for another amount
+	events := sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         new(big.Int).Add(amount, big.NewInt(1)),
+		LockSecretHash: state.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	assert(t, len(events), 0)
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	var failed *transfer.EventTransferSentFailed
+	for _, e := range events {
+		if f, ok := e.(*transfer.EventTransferSentFailed); ok {
+			failed = f
+		}
+	}
+	assert(t, failed != nil, true)
+	assert(t, failed.Reason, ReasonRefusedByTarget)
+}
+
+func TestInitWithInsufficientCapacity(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	routes := []*route.State{
+		utest.MakeRoute(utest.HOP1, new(big.Int).Sub(amount, big.NewInt(1)), utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	initStateChange := makeInitStateChange(routes, utest.HOP2, amount, utest.UnitBlockNumber, utest.ADDR, utest.UnitTokenAddress)
+	sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, utils.ShaSecret([]byte("3")), utils.NewRandomAddress())
+	events := sm.Dispatch(initStateChange)
+	failed, ok := events[0].(*transfer.EventTransferSentFailed)
+	assert(t, ok, true)
+	assert(t, failed.Reason, ReasonInsufficientCapacity)
+}
+
 func TestRefundTransferInvalidSender(t *testing.T) {
 	amount := utest.UnitTransferAmount
 	blockNumber := utest.UnitBlockNumber
@@ -374,6 +453,264 @@ func TestCancelTransfer(t *testing.T) {
 	assert(t, len(events), 1)
 	_, ok := events[0].(*transfer.EventTransferSentFailed)
 	assert(t, true, ok)
+	//secret is never revealed after canceled
+	events = sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         currentState.Transfer.TargetAmount,
+		LockSecretHash: currentState.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	assert(t, len(events), 0)
+	assert(t, currentState.RevealSecret == nil, true)
+}
+
+func TestTransferDeadline(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	targetAddress := utest.HOP2
+	ourAddress := utest.ADDR
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(utest.HOP1, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+		utest.MakeRoute(utest.HOP3, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	// deadline is capped by lock expiration
+	initStateChange := makeInitStateChange(routes, targetAddress, amount, blockNumber, ourAddress, token)
+	initStateChange.Deadline = blockNumber + 100000
+	state := StateTransition(nil, initStateChange).NewState.(*mediatedtransfer.InitiatorState)
+	assert(t, state.Deadline, state.Transfer.Expiration)
+
+	initStateChange = makeInitStateChange(routes, targetAddress, amount, blockNumber, ourAddress, token)
+	initStateChange.Deadline = blockNumber + 5
+	sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, initStateChange.LockSecretHash, token)
+	sm.Dispatch(initStateChange)
+	state = sm.CurrentState.(*mediatedtransfer.InitiatorState)
+	assert(t, state.Deadline, blockNumber+5)
+
+	events := sm.Dispatch(&transfer.BlockStateChange{BlockNumber: blockNumber + 4})
+	assert(t, len(events), 0)
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: blockNumber + 5})
+	assert(t, len(events), 1)
+	failed, ok := events[0].(*transfer.EventTransferSentFailed)
+	assert(t, ok, true)
+	assert(t, failed.Reason, ReasonDeadlineExceeded)
+	assert(t, state.DeadlineExceeded, true)
+	// only once
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: blockNumber + 6})
+	assert(t, len(events), 0)
+
+	// secret is never revealed after deadline
+	events = sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         amount,
+		LockSecretHash: state.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	assert(t, len(events), 0)
+	assert(t, state.RevealSecret == nil, true)
+
+	// no more routes are tried
+	events = sm.Dispatch(&mediatedtransfer.ActionCancelRouteStateChange{
+		LockSecretHash: state.LockSecretHash,
+	})
+	assert(t, len(events), 1)
+	_, ok = events[0].(*mediatedtransfer.EventRemoveStateManager)
+	assert(t, ok, true)
+	assert(t, sm.CurrentState == nil, true)
+}
+
+func TestTransferWallClockDeadline(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(utest.HOP1, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	state := makeInitiatorState(routes, utest.HOP2, amount, blockNumber, utest.ADDR, token)
+	sm := transfer.NewStateManager(StateTransition, state, NameInitiatorTransition, state.LockSecretHash, token)
+	// not this transfer
+	events := sm.Dispatch(&mediatedtransfer.ActionTransferDeadlineStateChange{LockSecretHash: utils.NewRandomHash()})
+	assert(t, len(events), 0)
+	events = sm.Dispatch(&mediatedtransfer.ActionTransferDeadlineStateChange{LockSecretHash: state.LockSecretHash})
+	assert(t, len(events), 1)
+	failed, ok := events[0].(*transfer.EventTransferSentFailed)
+	assert(t, ok, true)
+	assert(t, failed.Reason, ReasonDeadlineExceeded)
+	// state is kept to remove the lock after it expired
+	assert(t, sm.CurrentState != nil, true)
+	events = sm.Dispatch(&mediatedtransfer.ActionTransferDeadlineStateChange{LockSecretHash: state.LockSecretHash})
+	assert(t, len(events), 0)
+}
+
+func relockEvent(events []transfer.Event) (relock *mediatedtransfer.EventRelockTransfer, failed *transfer.EventTransferSentFailed) {
+	for _, e := range events {
+		switch e2 := e.(type) {
+		case *mediatedtransfer.EventRelockTransfer:
+			relock = e2
+		case *transfer.EventTransferSentFailed:
+			failed = e2
+		}
+	}
+	return
+}
+
+func TestRelockAfterLockExpired(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	targetAddress := utest.HOP1
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(targetAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	initStateChange := makeInitStateChange(routes, targetAddress, amount, blockNumber, utest.ADDR, token)
+	initStateChange.Db = channeltype.NewMockChannelDb()
+	initStateChange.Deadline = blockNumber + 100000
+	initStateChange.Relock = true
+	sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, initStateChange.LockSecretHash, token)
+	sm.Dispatch(initStateChange)
+	state := sm.CurrentState.(*mediatedtransfer.InitiatorState)
+	// deadline is not capped by lock expiration, there will be more locks
+	assert(t, state.Deadline, blockNumber+100000)
+	// target never asks for the secret
+	events := sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	relock, failed := relockEvent(events)
+	assert(t, failed == nil, true, "transfer should not fail")
+	if !assert(t, relock != nil, true) {
+		return
+	}
+	assert(t, relock.LockSecretHash, state.LockSecretHash)
+	assert(t, relock.Token, token)
+	assert(t, relock.Target, targetAddress)
+	// the expired lock is removed as usual
+	var unlockFailed, removed bool
+	for _, e := range events {
+		switch e.(type) {
+		case *mediatedtransfer.EventUnlockFailed:
+			unlockFailed = true
+		case *mediatedtransfer.EventRemoveStateManager:
+			removed = true
+		}
+	}
+	assert(t, unlockFailed, true)
+	assert(t, removed, true)
+}
+
+func TestNoRelockAfterSecretRevealed(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	targetAddress := utest.HOP1
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(targetAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	newMachine := func() (*transfer.StateManager, *mediatedtransfer.InitiatorState) {
+		initStateChange := makeInitStateChange(routes, targetAddress, amount, blockNumber, utest.ADDR, token)
+		initStateChange.Db = channeltype.NewMockChannelDb()
+		initStateChange.Deadline = blockNumber + 100000
+		initStateChange.Relock = true
+		sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, initStateChange.LockSecretHash, token)
+		sm.Dispatch(initStateChange)
+		return sm, sm.CurrentState.(*mediatedtransfer.InitiatorState)
+	}
+	// secret revealed to target, it may claim this lock, another secret would let it claim twice
+	sm, state := newMachine()
+	events := sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         amount,
+		LockSecretHash: state.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	assert(t, len(events), 1)
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	relock, failed := relockEvent(events)
+	assert(t, relock == nil, true)
+	assert(t, failed != nil && failed.Reason == ReasonLockExpired, true)
+
+	// refused by target
+	sm, state = newMachine()
+	sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         new(big.Int).Add(amount, big.NewInt(1)),
+		LockSecretHash: state.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	relock, failed = relockEvent(events)
+	assert(t, relock == nil, true)
+	assert(t, failed != nil && failed.Reason == ReasonRefusedByTarget, true)
+
+	// canceled by user
+	sm, state = newMachine()
+	sm.Dispatch(&transfer.ActionCancelTransferStateChange{LockSecretHash: state.LockSecretHash})
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	relock, _ = relockEvent(events)
+	assert(t, relock == nil, true)
+
+	// secret registered on chain after the lock expired
+	sm, state = newMachine()
+	state.BlockNumber = state.Transfer.Expiration + params.ForkConfirmNumber + 1
+	events = sm.Dispatch(&mediatedtransfer.ContractSecretRevealOnChainStateChange{
+		LockSecretHash: state.LockSecretHash,
+		BlockNumber:    state.Transfer.Expiration + 1,
+	})
+	relock, failed = relockEvent(events)
+	assert(t, relock == nil, true)
+	assert(t, failed != nil, true)
+}
+
+func TestRelockAfterAckTimeout(t *testing.T) {
+	amount := utest.UnitTransferAmount
+	blockNumber := utest.UnitBlockNumber
+	targetAddress := utest.HOP1
+	token := utest.UnitTokenAddress
+	routes := []*route.State{
+		utest.MakeRoute(targetAddress, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash()),
+	}
+	newMachine := func(relock bool) (*transfer.StateManager, *mediatedtransfer.InitiatorState) {
+		initStateChange := makeInitStateChange(routes, targetAddress, amount, blockNumber, utest.ADDR, token)
+		initStateChange.Db = channeltype.NewMockChannelDb()
+		initStateChange.Deadline = blockNumber + 100000
+		initStateChange.Relock = relock
+		sm := transfer.NewStateManager(StateTransition, nil, NameInitiatorTransition, initStateChange.LockSecretHash, token)
+		sm.Dispatch(initStateChange)
+		return sm, sm.CurrentState.(*mediatedtransfer.InitiatorState)
+	}
+	// first hop doesn't ack, start again with a fresh secret avoiding it
+	sm, state := newMachine(true)
+	events := sm.Dispatch(&mediatedtransfer.ActionAckTimeoutStateChange{LockSecretHash: state.LockSecretHash})
+	relock, failed := relockEvent(events)
+	assert(t, failed == nil, true, "transfer should not fail")
+	if !assert(t, relock != nil, true) {
+		return
+	}
+	assert(t, relock.LockSecretHash, state.LockSecretHash)
+	assert(t, relock.AckTimeoutHop, targetAddress)
+	assert(t, state.Relocked, true)
+	// the dropped secret is never revealed
+	events = sm.Dispatch(&mediatedtransfer.ReceiveSecretRequestStateChange{
+		Amount:         amount,
+		LockSecretHash: state.LockSecretHash,
+		Sender:         targetAddress,
+	})
+	assert(t, len(events), 0)
+	// deadline and expiration only remove the lock, the new attempt decides how the transfer ends
+	events = sm.Dispatch(&transfer.BlockStateChange{BlockNumber: state.Transfer.Expiration + params.ForkConfirmNumber + 1})
+	relock, failed = relockEvent(events)
+	assert(t, relock == nil, true)
+	assert(t, failed == nil, true)
+	var unlockFailed, removed bool
+	for _, e := range events {
+		switch e.(type) {
+		case *mediatedtransfer.EventUnlockFailed:
+			unlockFailed = true
+		case *mediatedtransfer.EventRemoveStateManager:
+			removed = true
+		}
+	}
+	assert(t, unlockFailed, true)
+	assert(t, removed, true)
+
+	// transfers which can't be started again keep waiting for the ack
+	sm, state = newMachine(false)
+	events = sm.Dispatch(&mediatedtransfer.ActionAckTimeoutStateChange{LockSecretHash: state.LockSecretHash})
+	assert(t, len(events), 0)
+	assert(t, state.Relocked, false)
 }
 
 func assertStateEqual(t *testing.T, currentState, beforeState *mediatedtransfer.InitiatorState) {
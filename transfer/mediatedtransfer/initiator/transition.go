@@ -17,6 +17,24 @@ import (
 //NameInitiatorTransition name for state manager
 const NameInitiatorTransition = "InitiatorTransition"
 
+//reasons of EventTransferSentFailed
+const (
+	//ReasonDeadlineExceeded the deadline of transfer passed
+	ReasonDeadlineExceeded = "deadline_exceeded"
+	//ReasonNoRoute every route is tried
+	ReasonNoRoute = "no route available"
+	//ReasonInsufficientCapacity the rest routes have not enough balance
+	ReasonInsufficientCapacity = "insufficient capacity"
+	//ReasonRefusedByTarget target asked for the secret with unexpected SecretRequest, lock expired after that
+	ReasonRefusedByTarget = "refused by target"
+	//ReasonLockExpired lock expired before secret is revealed
+	ReasonLockExpired = "lock expired"
+	//ReasonUserCanceled user canceled transfer
+	ReasonUserCanceled = "user canceled transfer"
+	//ReasonMaxRouteAttempts too many routes refused the transfer
+	ReasonMaxRouteAttempts = "max route attempts reached"
+)
+
 /*
 Clear current state and try a new route.
 
@@ -41,6 +59,7 @@ func userCancelTransfer(state *mt.InitiatorState) *transfer.TransitionResult {
 	if state.RevealSecret != nil {
 		panic("cannot cancel a transfer with a RevealSecret in flight")
 	}
+	state.Canceled = true
 	state.Transfer.Secret = utils.EmptyHash
 	//state.Transfer.LockSecretHash = utils.EmptyHash // need by remove
 	state.Message = nil
@@ -49,7 +68,7 @@ func userCancelTransfer(state *mt.InitiatorState) *transfer.TransitionResult {
 	state.RevealSecret = nil
 	cancel := &transfer.EventTransferSentFailed{
 		LockSecretHash: state.Transfer.LockSecretHash,
-		Reason:         "user canceled transfer",
+		Reason:         ReasonUserCanceled,
 		Target:         state.Transfer.Target,
 		Token:          state.Transfer.Token,
 	}
@@ -62,15 +81,57 @@ func userCancelTransfer(state *mt.InitiatorState) *transfer.TransitionResult {
 	}
 }
 
+/*
+超过 deadline 还没有把密码告诉接收方,放弃这次交易:不再尝试新的路由,等待锁过期以后移除.
+*/
+/*
+ *	deadlineExceeded : secret is not revealed to target before deadline, give up this transfer.
+ *	No more routes will be tried, lock is removed after it expired.
+ */
+func deadlineExceeded(state *mt.InitiatorState) []transfer.Event {
+	state.DeadlineExceeded = true
+	state.Message = nil
+	state.SecretRequest = nil
+	log.Info(fmt.Sprintf("transfer %s deadline %d exceeded at block %d", utils.HPex(state.LockSecretHash), state.Deadline, state.BlockNumber))
+	/*
+		need state exist to send remove msg after expired
+	*/
+	return []transfer.Event{&transfer.EventTransferSentFailed{
+		LockSecretHash: state.Transfer.LockSecretHash,
+		Reason:         ReasonDeadlineExceeded,
+		Target:         state.Transfer.Target,
+		Token:          state.Transfer.Token,
+	}}
+}
+
 func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 	if state.Route != nil {
 		panic("cannot try a new route while one is being used")
 	}
+	if state.DeadlineExceeded || state.Relocked {
+		//transfer failed already or a new attempt took over, don't retry, nothing locked on canceled routes
+		return &transfer.TransitionResult{
+			NewState: nil,
+			Events: []transfer.Event{&mt.EventRemoveStateManager{
+				Key: utils.Sha3(state.LockSecretHash[:], state.Transfer.Token[:]),
+			}},
+		}
+	}
 	var tryRoute *route.State
+	reason := ReasonNoRoute
+	if state.MaxRouteAttempts > 0 && len(state.Routes.CanceledRoutes) >= state.MaxRouteAttempts {
+		//every attempt of this transfer shares the same secret, don't try more routes
+		reason = ReasonMaxRouteAttempts
+		state.Routes.IgnoredRoutes = append(state.Routes.IgnoredRoutes, state.Routes.AvailableRoutes...)
+		state.Routes.AvailableRoutes = nil
+	}
 	for len(state.Routes.AvailableRoutes) > 0 {
 		r := state.Routes.AvailableRoutes[0]
 		state.Routes.AvailableRoutes = state.Routes.AvailableRoutes[1:]
 		if !r.CanTransfer() || r.AvailableBalance().Cmp(new(big.Int).Add(state.Transfer.TargetAmount, r.Fee)) < 0 {
+			if r.CanTransfer() {
+				reason = ReasonInsufficientCapacity
+			}
 			state.Routes.IgnoredRoutes = append(state.Routes.IgnoredRoutes, r)
 		} else {
 			tryRoute = r
@@ -88,7 +149,7 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		*/
 		transferFailed := &transfer.EventTransferSentFailed{
 			LockSecretHash: state.Transfer.LockSecretHash,
-			Reason:         "no route available",
+			Reason:         reason,
 			Target:         state.Transfer.Target,
 			Token:          state.Transfer.Token,
 		}
@@ -113,10 +174,14 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		         The two nodes will most likely disagree on latest block, as far as
 		         the expiration goes this is no problem.
 	*/
-	lockExpiration := state.BlockNumber + int64(tryRoute.SettleTimeout()) - int64(params.DefaultRevealTimeout) // - revealTimeout for test
+	lockExpiration := state.BlockNumber + int64(tryRoute.SettleTimeout()) - int64(tryRoute.RevealTimeout())
 	if lockExpiration > state.Transfer.Expiration && state.Transfer.Expiration != 0 {
 		lockExpiration = state.Transfer.Expiration
 	}
+	//deadline never exceeds lock expiration of the chosen route, unless the transfer is started again after the lock expired
+	if state.Deadline > lockExpiration && !state.Relock {
+		state.Deadline = lockExpiration
+	}
 	tr := &mt.LockedTransferState{
 		TargetAmount:   state.Transfer.TargetAmount,
 		Amount:         new(big.Int).Add(state.Transfer.TargetAmount, tryRoute.TotalFee),
@@ -128,6 +193,7 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		Secret:         state.Secret,
 		Fee:            tryRoute.TotalFee,
 		Data:           state.Transfer.Data,
+		Metadata:       state.Transfer.Metadata,
 	}
 	msg := mt.NewEventSendMediatedTransfer(tr, tryRoute.HopNode())
 	if len(state.Routes.CanceledRoutes) > 0 {
@@ -147,21 +213,50 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 		Events:   events,
 	}
 }
-func expiredHashLockEvents(state *mt.InitiatorState) (events []transfer.Event) {
+/*
+expiredHashLockEvents 锁过期以后移除锁, 交易失败.
+如果 relock 为真, 并且密码从来没有发出去过, 接收方不可能拿到这笔钱, 交易不算失败, 换一个新的密码重新发起.
+收到无效的 SecretRequest 或者用户撤销的交易不会重新发起.
+*/
+/*
+ *	expiredHashLockEvents : remove the expired lock and fail the transfer.
+ *	If relock is true and the secret never left our node, target can't claim the lock,
+ *	so the transfer doesn't fail and is started again with a fresh secret instead.
+ *	Transfers refused by an unexpected SecretRequest or canceled by user are never started again.
+ */
+func expiredHashLockEvents(state *mt.InitiatorState, relock bool) (events []transfer.Event) {
 	if state.BlockNumber-params.ForkConfirmNumber > state.Transfer.Expiration {
 		if state.Route != nil && !state.Db.IsThisLockRemoved(state.Route.ChannelIdentifier, state.OurAddress, state.Transfer.LockSecretHash) {
 			unlockFailed := &mt.EventUnlockFailed{
 				LockSecretHash:    state.Transfer.LockSecretHash,
 				ChannelIdentifier: state.Route.ChannelIdentifier,
-				Reason:            "lock expired",
+				Reason:            ReasonLockExpired,
 			}
-			transferFailed := &transfer.EventTransferSentFailed{
-				LockSecretHash: state.Transfer.LockSecretHash,
-				Reason:         "no route available",
-				Target:         state.Transfer.Target,
-				Token:          state.Transfer.Token,
+			events = append(events, unlockFailed)
+			//already failed when deadline exceeded, or a new attempt took over
+			if state.Relocked {
+				return
+			}
+			if !state.DeadlineExceeded && relock && state.Relock && state.RevealSecret == nil &&
+				!state.CancelByExceptionSecretRequest && !state.Canceled {
+				events = append(events, &mt.EventRelockTransfer{
+					LockSecretHash: state.Transfer.LockSecretHash,
+					Token:          state.Transfer.Token,
+					Target:         state.Transfer.Target,
+				})
+			} else if !state.DeadlineExceeded {
+				reason := ReasonLockExpired
+				if state.CancelByExceptionSecretRequest {
+					reason = ReasonRefusedByTarget
+				}
+				transferFailed := &transfer.EventTransferSentFailed{
+					LockSecretHash: state.Transfer.LockSecretHash,
+					Reason:         reason,
+					Target:         state.Transfer.Target,
+					Token:          state.Transfer.Token,
+				}
+				events = append(events, transferFailed)
 			}
-			events = append(events, unlockFailed, transferFailed)
 		}
 	}
 	return
@@ -175,6 +270,9 @@ func handleBlock(state *mt.InitiatorState, stateChange *transfer.BlockStateChang
 	if state.BlockNumber < stateChange.BlockNumber {
 		state.BlockNumber = stateChange.BlockNumber
 	}
+	if state.Deadline > 0 && state.BlockNumber >= state.Deadline && state.RevealSecret == nil && !state.DeadlineExceeded && !state.Relocked {
+		events = deadlineExceeded(state)
+	}
 	// 考虑到分叉攻击,延迟一定块数之后才发送remove
 	if state.BlockNumber-params.ForkConfirmNumber > state.Transfer.Expiration {
 		// 超时
@@ -183,7 +281,7 @@ func handleBlock(state *mt.InitiatorState, stateChange *transfer.BlockStateChang
 		// timeout
 		// If I have not sent secret, then just send removeExpiredLock, and remove stateManager.
 		// If I have already sent secret, then assume transfer timeout failure, send remove expired, and remove state manager.
-		events = expiredHashLockEvents(state)
+		events = append(events, expiredHashLockEvents(state, true)...)
 		events = append(events, &mt.EventRemoveStateManager{
 			Key: utils.Sha3(state.LockSecretHash[:], state.Transfer.Token[:]),
 		})
@@ -194,6 +292,60 @@ func handleBlock(state *mt.InitiatorState, stateChange *transfer.BlockStateChang
 	}
 }
 
+func handleTransferDeadline(state *mt.InitiatorState, st *mt.ActionTransferDeadlineStateChange) *transfer.TransitionResult {
+	var events []transfer.Event
+	if st.LockSecretHash == state.LockSecretHash && state.RevealSecret == nil && !state.DeadlineExceeded && !state.Relocked {
+		events = deadlineExceeded(state)
+	}
+	return &transfer.TransitionResult{
+		NewState: state,
+		Events:   events,
+	}
+}
+
+/*
+handleAckTimeout 第一跳没有及时确认 MediatedTransfer.
+这个 MediatedTransfer 可能已经在对方的 balance proof 里, 不能撤销, 也不能用同一个密码尝试别的路由,
+否则接收方可能收到两次钱. 所以丢弃这个密码, 不再响应 SecretRequest, 等待锁过期以后移除,
+同时由 Service 换一个新的密码, 不经过这个第一跳重新发起.
+只有随机密码并且有 deadline 的交易才会这样处理, 其他交易继续等待确认.
+*/
+/*
+ *	handleAckTimeout : first hop doesn't ack the MediatedTransfer in time.
+ *	The MediatedTransfer may be in the balance proof of our partner already, so it can't be canceled,
+ *	and the same secret can't be used on another route, or target may get paid twice.
+ *	So the secret is dropped, SecretRequest is never answered, the lock is removed after it expired,
+ *	and Service starts the transfer again with a fresh secret avoiding this first hop.
+ *	Only transfers with a random secret and a deadline do this, others keep waiting for the ack.
+ */
+func handleAckTimeout(state *mt.InitiatorState, st *mt.ActionAckTimeoutStateChange) *transfer.TransitionResult {
+	if st.LockSecretHash != state.LockSecretHash || !state.Relock || state.Relocked || state.Route == nil ||
+		state.RevealSecret != nil || state.DeadlineExceeded || state.Canceled || state.CancelByExceptionSecretRequest {
+		return &transfer.TransitionResult{
+			NewState: state,
+			Events:   nil,
+		}
+	}
+	state.Relocked = true
+	state.Transfer.Secret = utils.EmptyHash
+	state.Message = nil
+	state.SecretRequest = nil
+	log.Info(fmt.Sprintf("transfer %s is not acked by %s in time, start again with a fresh secret",
+		utils.HPex(state.LockSecretHash), utils.APex(state.Route.HopNode())))
+	/*
+		need state exist to send remove msg after expired
+	*/
+	return &transfer.TransitionResult{
+		NewState: state,
+		Events: []transfer.Event{&mt.EventRelockTransfer{
+			LockSecretHash: state.Transfer.LockSecretHash,
+			Token:          state.Transfer.Token,
+			Target:         state.Transfer.Target,
+			AckTimeoutHop:  state.Route.HopNode(),
+		}},
+	}
+}
+
 func handleRefund(state *mt.InitiatorState, stateChange *mt.ReceiveAnnounceDisposedStateChange) *transfer.TransitionResult {
 	if mediator.IsValidRefund(state.Transfer, state.Route, stateChange) {
 		it := cancelCurrentRoute(state)
@@ -230,7 +382,14 @@ func handleSecretRequest(state *mt.InitiatorState, stateChange *mt.ReceiveSecret
 		stateChange.LockSecretHash == state.Transfer.LockSecretHash &&
 		stateChange.Amount.Cmp(state.Transfer.TargetAmount) == 0
 	//如果收到secret request时候已经过期了,应该让这个交易失败,而不是告诉对方密码
-	if isValid && !state.CancelByExceptionSecretRequest && state.BlockNumber < state.Transfer.Expiration {
+	if state.Canceled || state.Relocked {
+		//canceled by user or taken over by a new attempt, let the lock expire
+		return &transfer.TransitionResult{
+			NewState: state,
+			Events:   nil,
+		}
+	}
+	if isValid && !state.CancelByExceptionSecretRequest && !state.DeadlineExceeded && state.BlockNumber < state.Transfer.Expiration {
 		/*
 		   Reveal the secret to the target node and wait for its confirmation,
 		   at this point the transfer is not cancellable anymore either the lock
@@ -290,7 +449,8 @@ func handleSecretRevealOnChain(state *mt.InitiatorState, st *mt.ContractSecretRe
 	if state.Transfer.Expiration < st.BlockNumber {
 		//对于我来说这笔交易已经超期了. 应该发出 移除此锁消息.
 		// As to me this transfer expired, should send RemoveExpiredLock message.
-		events := expiredHashLockEvents(state)
+		// The secret is known on chain, never start again with another one.
+		events := expiredHashLockEvents(state, false)
 		events = append(events, &mt.EventRemoveStateManager{
 			Key: utils.Sha3(state.LockSecretHash[:], state.Transfer.Token[:]),
 		})
@@ -400,6 +560,9 @@ func StateTransition(originalState transfer.State, st transfer.StateChange) *tra
 				Secret:                         staii.Secret,
 				Db:                             staii.Db,
 				CancelByExceptionSecretRequest: false,
+				Deadline:                       staii.Deadline,
+				MaxRouteAttempts:               staii.MaxRouteAttempts,
+				Relock:                         staii.Relock,
 			}
 			return tryNewRoute(state)
 		}
@@ -461,6 +624,10 @@ func StateTransition(originalState transfer.State, st transfer.StateChange) *tra
 			} else {
 				panic(fmt.Sprintf("secret already revealed,transfer cannot canceled"))
 			}
+		case *mt.ActionTransferDeadlineStateChange:
+			it = handleTransferDeadline(state, st2)
+		case *mt.ActionAckTimeoutStateChange:
+			it = handleAckTimeout(state, st2)
 		case *mt.ContractCooperativeSettledStateChange:
 			it = cancelCurrentRoute(state)
 		case *mt.ContractChannelWithdrawStateChange:
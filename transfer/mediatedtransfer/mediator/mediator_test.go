@@ -281,22 +281,22 @@ func TestNextRouteAmount(t *testing.T) {
 	}
 	fromRoute := utest.MakeRoute(utest.HOP6, amount, 0, revealTimeout, 0, utils.NewRandomHash())
 	routesState := route.NewRoutesState(routes)
-	route1 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0)
+	route1 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0)
 	assert(t, route1, routes[0])
 	assert(t, routesState.AvailableRoutes, routes[1:])
 	assert(t, len(routesState.IgnoredRoutes), 0)
 
-	route2 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0)
+	route2 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0)
 	assert(t, route2, routes[1])
 	assert(t, routesState.AvailableRoutes, routes[2:])
 	assert(t, len(routesState.IgnoredRoutes), 0)
 
-	route3 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0)
+	route3 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0)
 	assert(t, route3, routes[3])
 	assert(t, len(routesState.AvailableRoutes), 0)
 	assert(t, routesState.IgnoredRoutes, []*route.State{routes[2]})
 
-	assert(t, nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0) == nil, true)
+	assert(t, nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0) == nil, true)
 
 }
 
@@ -314,16 +314,43 @@ func TestNextRouteRevealTimeout(t *testing.T) {
 	}
 	fromRoute := utest.MakeRoute(utest.HOP6, amount, 0, 10, 0, utils.NewRandomHash())
 	routesState := route.NewRoutesState(routes)
-	route1 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0)
+	route1 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0)
 	assert(t, route1, routes[2])
 	assert(t, routesState.AvailableRoutes, routes[3:])
 	assert(t, routesState.IgnoredRoutes, routes[0:2])
-	route2 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, utils.BigInt0)
+	route2 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, amount, utils.BigInt0)
 	assert(t, route2 == nil, true)
 	assert(t, len(routesState.AvailableRoutes), 0)
 	assert(t, routesState.IgnoredRoutes, append(routes[0:2], routes[3]))
 }
 
+//Routes whose fee is not covered by the incoming amount or the fee offered must be ignored.
+func TestNextRouteFee(t *testing.T) {
+	var targetAmount = big.NewInt(10)
+	var fee = big.NewInt(3)
+	var amount = new(big.Int).Add(targetAmount, fee)
+	revealTimeout := 10
+	timeoutBlocks := 40
+	routes := []*route.State{
+		utest.MakeRoute(utest.HOP2, amount, 0, revealTimeout, 0, utils.NewRandomHash()),
+		utest.MakeRoute(utest.HOP1, amount, 0, revealTimeout, 0, utils.NewRandomHash()),
+		utest.MakeRoute(utest.HOP3, amount, 0, revealTimeout, 0, utils.NewRandomHash()),
+	}
+	routes[0].Fee = big.NewInt(4)
+	routes[1].Fee = big.NewInt(3)
+	routes[2].Fee = big.NewInt(2)
+	fromRoute := utest.MakeRoute(utest.HOP6, amount, 0, revealTimeout, 0, utils.NewRandomHash())
+	routesState := route.NewRoutesState(routes)
+	// fee offered is not enough for the first one
+	route1 := nextRoute(fromRoute, routesState, timeoutBlocks, amount, targetAmount, fee)
+	assert(t, route1, routes[1])
+	assert(t, routesState.IgnoredRoutes, routes[0:1])
+	// payer offers enough fee, but the amount it pays cannot cover the fee and target amount
+	route2 := nextRoute(fromRoute, routesState, timeoutBlocks, new(big.Int).Sub(amount, big.NewInt(2)), targetAmount, fee)
+	assert(t, route2 == nil, true)
+	assert(t, routesState.IgnoredRoutes, []*route.State{routes[0], routes[2]})
+}
+
 func TestNextTransferPair(t *testing.T) {
 	timeoutBlocks := 47
 	var blockNumber int64 = 3
@@ -641,6 +668,7 @@ func TestEventsForRegisterSecret(t *testing.T) {
 		assert(t, len(events), 1)
 		ev := events[0].(*mediatedtransfer.EventContractSendRegisterSecret)
 		assert(t, ev != nil, true)
+		assert(t, ev.LockExpiration, pair.PayerTransfer.Expiration)
 		assert(t, pair.PayerState, mediatedtransfer.StatePayerWaitingRegisterSecret)
 	}
 }
@@ -740,6 +768,65 @@ func TestMediateTransfer(t *testing.T) {
 
 }
 
+//lock whose secret cannot be registered on chain is never mediated, and a secret of another algorithm is ignored
+func TestMediateTransferOffChainHashAlgorithm(t *testing.T) {
+	var amount = big.NewInt(10)
+	var blockNumber int64 = 5
+	var expiration int64 = 30
+	var routes = []*route.State{utest.MakeRoute(utest.HOP2, utest.UnitTransferAmount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())}
+	state := &mediatedtransfer.MediatorState{
+		OurAddress:  utest.ADDR,
+		Routes:      route.NewRoutesState(routes),
+		BlockNumber: blockNumber,
+		Hashlock:    utest.UnitHashLock,
+	}
+	payerroute, payertransfer := utest.MakeFrom(amount, utest.HOP6, expiration, utils.NewRandomAddress(), utils.EmptyHash)
+	payertransfer.HashAlgorithm = utils.HashAlgorithmKeccak256
+	it := mediateTransfer(state, payerroute, payertransfer)
+	for _, e := range it.Events {
+		_, ok := e.(*mediatedtransfer.EventSendMediatedTransfer)
+		assert(t, ok, false)
+	}
+	assert(t, len(state.TransfersPair), 0)
+	assert(t, len(routes), len(state.Routes.AvailableRoutes))
+
+	// keccak256 secret whose hash is not our sha256 lock
+	it = handleSecretReveal(state, &mediatedtransfer.ReceiveSecretRevealStateChange{
+		Secret: utils.NewRandomHash(),
+		Sender: utest.HOP2,
+	})
+	assert(t, len(it.Events), 0)
+	assert(t, state.Secret, utils.EmptyHash)
+}
+
+//payee transfer must use the same hash algorithm as payer transfer
+func TestNextTransferPairKeepHashAlgorithm(t *testing.T) {
+	var balance = big.NewInt(10)
+	var blockNumber int64 = 3
+	timeoutBlocks := 47
+	payerRoute, payerTransfer := utest.MakeFrom(balance, utest.HOP3, 50, utest.HOP1, utils.EmptyHash)
+	payerTransfer.HashAlgorithm = utils.HashAlgorithmKeccak256
+	routes := []*route.State{utest.MakeRoute(utest.HOP2, balance, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())}
+	pair, events := nextTransferPair(payerRoute, payerTransfer, route.NewRoutesState(routes), timeoutBlocks, blockNumber)
+	assert(t, pair.PayeeTransfer.HashAlgorithm, utils.HashAlgorithmKeccak256)
+	assert(t, events[0].(*mediatedtransfer.EventSendMediatedTransfer).HashAlgorithm, utils.HashAlgorithmKeccak256)
+}
+
+//metadata of initiator is forwarded unchanged
+func TestNextTransferPairKeepMetadata(t *testing.T) {
+	var balance = big.NewInt(10)
+	var blockNumber int64 = 3
+	timeoutBlocks := 47
+	payerRoute, payerTransfer := utest.MakeFrom(balance, utest.HOP3, 50, utest.HOP1, utils.EmptyHash)
+	payerTransfer.Metadata = []byte("order-1")
+	payerTransfer.MetadataSignature = []byte("signature of initiator")
+	routes := []*route.State{utest.MakeRoute(utest.HOP2, balance, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())}
+	_, events := nextTransferPair(payerRoute, payerTransfer, route.NewRoutesState(routes), timeoutBlocks, blockNumber)
+	e := events[0].(*mediatedtransfer.EventSendMediatedTransfer)
+	assert(t, e.Metadata, payerTransfer.Metadata)
+	assert(t, e.MetadataSignature, payerTransfer.MetadataSignature)
+}
+
 func TestInitMediator(t *testing.T) {
 	fromRoute, FromTransfer := utest.MakeFrom(utest.UnitTransferAmount, utest.HOP2, int64(utest.Hop1Timeout), utils.NewRandomAddress(), utils.EmptyHash)
 	var routes = []*route.State{utest.MakeRoute(utest.HOP2, utest.UnitTransferAmount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())}
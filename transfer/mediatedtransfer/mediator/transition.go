@@ -260,9 +260,10 @@ Finds the first route available that may be used.
 1.通道金额足够
 2.上家给出的 费用足够
 3.时间还足够安全
+4.上家给的金额扣除我的手续费以后仍然够付给收款人的金额
 */
 
-func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int, transferAmount, fee *big.Int) *route.State {
+func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int, transferAmount, targetAmount, fee *big.Int) *route.State {
 	for len(rss.AvailableRoutes) > 0 {
 		route := rss.AvailableRoutes[0]
 		rss.AvailableRoutes = rss.AvailableRoutes[1:]
@@ -274,8 +275,10 @@ func nextRoute(fromRoute *route.State, rss *route.RoutesState, timeoutBlocks int
 				4. 通道可以发起交易
 				5. 不能使用再次使用上家做下一跳.
 			 有可能形成环路的时候,上家已经在我认为可用的路由节点中,但是实际上就是从他发过来的 lockedTransfer
+				6. 上家给的金额扣除手续费以后要够 target 收款
 		*/
-		if route.CanTransfer() && route.AvailableBalance().Cmp(transferAmount) >= 0 && lockTimeout > 0 && fee.Cmp(route.Fee) >= 0 && route.HopNode() != fromRoute.HopNode() {
+		if route.CanTransfer() && route.AvailableBalance().Cmp(transferAmount) >= 0 && lockTimeout > 0 && fee.Cmp(route.Fee) >= 0 && route.HopNode() != fromRoute.HopNode() &&
+			new(big.Int).Sub(transferAmount, route.Fee).Cmp(targetAmount) >= 0 {
 			return route
 		}
 		rss.IgnoredRoutes = append(rss.IgnoredRoutes, route)
@@ -306,7 +309,7 @@ func nextTransferPair(payerRoute *route.State, payerTransfer *mediatedtransfer.L
 	if int64(timeoutBlocks) > payerTransfer.Expiration-blockNumber {
 		panic("timeoutBlocks >payerTransfer.Expiration-blockNumber")
 	}
-	payeeRoute := nextRoute(payerRoute, routesState, timeoutBlocks, payerTransfer.Amount, payerTransfer.Fee)
+	payeeRoute := nextRoute(payerRoute, routesState, timeoutBlocks, payerTransfer.Amount, payerTransfer.TargetAmount, payerTransfer.Fee)
 	if payeeRoute != nil {
 		/*
 					有可能 payeeroute 的 settle timeout 比较小,从而导致我指定的lockexpiration 特别大,从而对我不利.
@@ -330,13 +333,17 @@ func nextTransferPair(payerRoute *route.State, payerTransfer *mediatedtransfer.L
 			LockSecretHash: payerTransfer.LockSecretHash,
 			Secret:         payerTransfer.Secret,
 			Fee:            big.NewInt(0).Sub(payerTransfer.Fee, payeeRoute.Fee),
+			//payee must use the same hash algorithm, otherwise the secret revealed by payee cannot unlock payer's lock
+			HashAlgorithm: payerTransfer.HashAlgorithm,
+			//opaque to us, forwarded as it is so target can check signature of initiator
+			Metadata:          payerTransfer.Metadata,
+			MetadataSignature: payerTransfer.MetadataSignature,
 		}
 		if payeeRoute.HopNode() == payeeTransfer.Target {
 			//i'm the last hop,so take the rest of the fee
 			payeeTransfer.Fee = utils.BigInt0
 			payeeTransfer.Amount = payerTransfer.TargetAmount
 		}
-		//todo log how many tokens fee for this transfer .
 		transferPair = mediatedtransfer.NewMediationPairState(payerRoute, payeeRoute, payerTransfer, payeeTransfer)
 		eventSendMediatedTransfer := mediatedtransfer.NewEventSendMediatedTransfer(payeeTransfer, payeeRoute.HopNode())
 		eventSendMediatedTransfer.FromChannel = payerRoute.ChannelIdentifier
@@ -562,7 +569,7 @@ func eventsForRevealSecret(transfersPair []*mediatedtransfer.MediationPairState,
 			}
 			events = append(events, revealSecret)
 		}
-		if tr.Fee.Cmp(big.NewInt(0)) > 0 {
+		if pair.RetainedFee().Sign() > 0 {
 			events = append(events, &mediatedtransfer.EventSaveFeeChargeRecord{
 				LockSecretHash: tr.LockSecretHash,
 				TokenAddress:   tr.Token,
@@ -571,7 +578,7 @@ func eventsForRevealSecret(transfersPair []*mediatedtransfer.MediationPairState,
 				TransferAmount: tr.TargetAmount,
 				InChannel:      pair.PayerRoute.ChannelIdentifier,
 				OutChannel:     pair.PayeeRoute.ChannelIdentifier,
-				Fee:            new(big.Int).Sub(pair.PayerTransfer.Fee, pair.PayeeTransfer.Fee),
+				Fee:            pair.RetainedFee(),
 				Timestamp:      time.Now().Unix(),
 			})
 		}
@@ -632,21 +639,24 @@ Close the channels that are in the unsafe region prior to an on-chain
  */
 func eventsForRegisterSecret(transfersPair []*mediatedtransfer.MediationPairState, blockNumber int64) (events []transfer.Event) {
 	pendings := getPendingTransferPairs(transfersPair)
-	needRegisterSecret := false
+	var registerSecretEvent *mediatedtransfer.EventContractSendRegisterSecret
 	for j := len(pendings) - 1; j >= 0; j-- {
 		pair := pendings[j]
 		if isSecretRegisterNeeded(pair, blockNumber) {
 			//只需发出一次注册请求,所有的 pair 状态都应该修改为StatePayerWaitingRegisterSecret
 			// we only need to send reveal secret once, all pairs state should switch to StatePayerWaitingRegisterSecret.
-			if needRegisterSecret {
-				pair.PayerState = mediatedtransfer.StatePayerWaitingRegisterSecret
-			} else {
-				needRegisterSecret = true
-				pair.PayerState = mediatedtransfer.StatePayerWaitingRegisterSecret
-				registerSecretEvent := &mediatedtransfer.EventContractSendRegisterSecret{
-					Secret: pair.PayeeTransfer.Secret,
+			pair.PayerState = mediatedtransfer.StatePayerWaitingRegisterSecret
+			if registerSecretEvent == nil {
+				registerSecretEvent = &mediatedtransfer.EventContractSendRegisterSecret{
+					Secret:         pair.PayeeTransfer.Secret,
+					LockExpiration: pair.PayerTransfer.Expiration,
+					RevealTimeout:  pair.PayerRoute.RevealTimeout(),
 				}
 				events = append(events, registerSecretEvent)
+			} else if pair.PayerTransfer.Expiration < registerSecretEvent.LockExpiration {
+				//the earliest incoming lock decides the deadline
+				registerSecretEvent.LockExpiration = pair.PayerTransfer.Expiration
+				registerSecretEvent.RevealTimeout = pair.PayerRoute.RevealTimeout()
 			}
 		}
 	}
@@ -694,6 +704,15 @@ func mediateTransfer(state *mediatedtransfer.MediatorState, payerRoute *route.St
 	var events []transfer.Event
 
 	timeoutBlocks := int(getTimeoutBlocks(payerRoute, payerTransfer, state.BlockNumber))
+	/*
+		密码不能在链上注册的锁(比如 keccak256), 下家给了我密码而上家不 unlock 的话, 我无法在链上取回上家的钱, 所以不做中转
+	*/
+	// if secret of the lock cannot be registered on chain, I cannot claim payer's lock on chain after paying payee, refuse to mediate.
+	if !payerTransfer.HashAlgorithm.CanRegisterOnChain() {
+		log.Warn(fmt.Sprintf("lock of %s uses %s, can only be completed off chain, refuse to mediate",
+			utils.HPex(payerTransfer.LockSecretHash), payerTransfer.HashAlgorithm))
+		timeoutBlocks = 0
+	}
 	//log.Trace(fmt.Sprintf("timeoutBlocks=%d,payerroute=%s,payertransfer=%s,blocknumber=%d",
 	//	timeoutBlocks, utils.StringInterface(payerRoute, 3), utils.StringInterface(payerTransfer, 3),
 	//	state.BlockNumber,
@@ -929,8 +948,12 @@ Validate and handle a ReceiveSecretReveal state change.
 */
 func handleSecretReveal(state *mediatedtransfer.MediatorState, st *mediatedtransfer.ReceiveSecretRevealStateChange) *transfer.TransitionResult {
 	secret := st.Secret
-	if utils.ShaSecret(secret[:]) != state.Hashlock {
-		panic("must a implementation error")
+	//mediator only accepts locks of sha256, a secret of other algorithm is not the secret of this transfer
+	if utils.HashAlgorithmSHA256.HashSecret(secret[:]) != state.Hashlock {
+		log.Warn(fmt.Sprintf("secret %s doesn't match lock %s, ignore", utils.HPex(secret), utils.HPex(state.Hashlock)))
+		return &transfer.TransitionResult{
+			NewState: state,
+		}
 	}
 	return secretLearned(state, secret, st.Sender, mediatedtransfer.StatePayeeSecretRevealed)
 }
@@ -979,7 +1002,7 @@ func handleSecretRevealOnChain(state *mediatedtransfer.MediatorState, st *mediat
 				// As for payer, he will not be impacted even he does not send BalanceProof, but cost gas to on-chain secret register.
 
 				// 没有超时即确认收到了手续费,记录流水
-				if tr.Fee.Cmp(big.NewInt(0)) > 0 {
+				if pair.RetainedFee().Sign() > 0 {
 					events = append(events, &mediatedtransfer.EventSaveFeeChargeRecord{
 						LockSecretHash: tr.LockSecretHash,
 						TokenAddress:   tr.Token,
@@ -988,7 +1011,7 @@ func handleSecretRevealOnChain(state *mediatedtransfer.MediatorState, st *mediat
 						TransferAmount: tr.TargetAmount,
 						InChannel:      pair.PayerRoute.ChannelIdentifier,
 						OutChannel:     pair.PayeeRoute.ChannelIdentifier,
-						Fee:            pair.PayerRoute.Fee,
+						Fee:            pair.RetainedFee(),
 						Timestamp:      time.Now().Unix(),
 					})
 				}
@@ -10,6 +10,7 @@ import (
 	"github.com/SmartMeshFoundation/Photon/encoding"
 	"github.com/SmartMeshFoundation/Photon/transfer/mtree"
 	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -27,6 +28,15 @@ type LockedTransferState struct {
 	Secret         common.Hash    //The secret that unlocks the lock, may be None.
 	Fee            *big.Int       // how much fee left for other hop node.
 	Data           string
+	HashAlgorithm  utils.HashAlgorithm // how Secret is hashed into LockSecretHash, must be the same for all hops
+	//Metadata opaque data from initiator to target, mediators forward it and MetadataSignature unchanged
+	Metadata          []byte
+	MetadataSignature []byte //signature of initiator on Metadata, initiator signs when sending the message
+}
+
+//SecretMatches true if secret is the secret of this transfer under its own hash algorithm
+func (l *LockedTransferState) SecretMatches(secret common.Hash) bool {
+	return l.HashAlgorithm.IsValid() && l.HashAlgorithm.HashSecret(secret[:]) == l.LockSecretHash
 }
 
 //AlmostEqual if two state equals?
@@ -42,14 +52,17 @@ func (l *LockedTransferState) AlmostEqual(other *LockedTransferState) bool {
 //LockedTransferFromMessage Create LockedTransferState from a MediatedTransfer message.
 func LockedTransferFromMessage(msg *encoding.MediatedTransfer, tokenAddress common.Address) *LockedTransferState {
 	return &LockedTransferState{
-		TargetAmount:   new(big.Int).Sub(msg.PaymentAmount, msg.Fee),
-		Amount:         new(big.Int).Set(msg.PaymentAmount),
-		Initiator:      msg.Initiator,
-		Target:         msg.Target,
-		Expiration:     msg.Expiration,
-		LockSecretHash: msg.LockSecretHash,
-		Fee:            msg.Fee,
-		Token:          tokenAddress,
+		TargetAmount:      new(big.Int).Sub(msg.PaymentAmount, msg.Fee),
+		Amount:            new(big.Int).Set(msg.PaymentAmount),
+		Initiator:         msg.Initiator,
+		Target:            msg.Target,
+		Expiration:        msg.Expiration,
+		LockSecretHash:    msg.LockSecretHash,
+		Fee:               msg.Fee,
+		Token:             tokenAddress,
+		HashAlgorithm:     msg.HashAlgorithm,
+		Metadata:          msg.Metadata,
+		MetadataSignature: msg.MetadataSignature,
 	}
 }
 
@@ -88,7 +101,13 @@ type InitiatorState struct {
 	RevealSecret                   *EventSendRevealSecret
 	CanceledTransfers              []*EventSendMediatedTransfer
 	Db                             channeltype.Db
-	CancelByExceptionSecretRequest bool // set true when receive exception SecretRequest
+	CancelByExceptionSecretRequest bool  // set true when receive exception SecretRequest
+	Deadline                       int64 // give up if secret is not revealed to target before this block, 0 means no deadline
+	DeadlineExceeded               bool  // set true when deadline passed, no more routes will be tried
+	MaxRouteAttempts               int   // give up after this many routes refused the transfer, 0 means no limit
+	Canceled                       bool  // set true when user canceled the transfer, SecretRequest is never answered after that
+	Relock                         bool  // start again with a fresh secret if the lock expires before the secret is revealed, deadline may exceed lock expiration then
+	Relocked                       bool  // first hop didn't ack in time and a new attempt with a fresh secret took over, this one only waits to remove its lock
 }
 
 /*
@@ -247,6 +266,14 @@ func NewMediationPairState(payerRoute, payeeRoute *route.State, payerTransfer, p
 	}
 }
 
+/*
+RetainedFee 中间节点在这次中转中留下的手续费,即收到的金额减去转出的金额,
+是在中转时按照当时的收费策略决定的,之后修改收费策略不影响这笔收入.
+*/
+func (p *MediationPairState) RetainedFee() *big.Int {
+	return new(big.Int).Sub(p.PayerTransfer.Amount, p.PayeeTransfer.Amount)
+}
+
 func init() {
 	gob.Register(&LockedTransferState{})
 	gob.Register(&InitiatorState{})
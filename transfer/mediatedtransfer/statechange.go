@@ -31,13 +31,16 @@ ActionInitInitiatorStateChange start a mediated transfer
  useful work, ie. there must /not/ be an event for requesting new data.
 */
 type ActionInitInitiatorStateChange struct {
-	OurAddress     common.Address       //This node address.
-	Tranfer        *LockedTransferState //A state object containing the transfer details.
-	Routes         *route.RoutesState   //The current available routes.
-	BlockNumber    int64                //The current block number.
-	Db             channeltype.Db       //get the latest channel state
-	LockSecretHash common.Hash
-	Secret         common.Hash
+	OurAddress       common.Address       //This node address.
+	Tranfer          *LockedTransferState //A state object containing the transfer details.
+	Routes           *route.RoutesState   //The current available routes.
+	BlockNumber      int64                //The current block number.
+	Db               channeltype.Db       //get the latest channel state
+	LockSecretHash   common.Hash
+	Secret           common.Hash
+	Deadline         int64 //give up if secret is not revealed to target before this block, 0 means no deadline
+	MaxRouteAttempts int   //give up after this many routes refused the transfer, 0 means no limit
+	Relock           bool  //start again with a fresh secret if the lock expires before the secret is revealed
 }
 
 //ActionInitMediatorStateChange  Initial state for a new mediator.
@@ -67,6 +70,7 @@ type ActionInitTargetStateChange struct {
 	BlockNumber int64
 	Message     *encoding.MediatedTransfer //the message trigger this statechange
 	Db          channeltype.Db             //get the latest channel state
+	Refused     bool                       //initiator is not accepted by our accept policy, dispose the lock without asking for the secret
 }
 
 /*
@@ -79,6 +83,22 @@ type ActionCancelRouteStateChange struct {
 	LockSecretHash common.Hash
 }
 
+/*
+ActionTransferDeadlineStateChange wall-clock deadline of the transfer passed.
+ Initiator gives up if the secret is not revealed to target yet.
+*/
+type ActionTransferDeadlineStateChange struct {
+	LockSecretHash common.Hash
+}
+
+/*
+ActionAckTimeoutStateChange the MediatedTransfer we initiated is not acked by the first hop in time.
+ Initiator drops the secret and starts again with a fresh one if the transfer can be relocked.
+*/
+type ActionAckTimeoutStateChange struct {
+	LockSecretHash common.Hash
+}
+
 //ReceiveSecretRequestStateChange A SecretRequest message received.
 type ReceiveSecretRequestStateChange struct {
 	Amount         *big.Int
@@ -291,6 +311,8 @@ func init() {
 	gob.Register(&ActionInitMediatorStateChange{})
 	gob.Register(&ActionInitTargetStateChange{})
 	gob.Register(&ActionCancelRouteStateChange{})
+	gob.Register(&ActionTransferDeadlineStateChange{})
+	gob.Register(&ActionAckTimeoutStateChange{})
 	gob.Register(&ReceiveSecretRequestStateChange{})
 	gob.Register(&ReceiveSecretRevealStateChange{})
 	gob.Register(&ReceiveAnnounceDisposedStateChange{})
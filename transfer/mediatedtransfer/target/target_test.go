@@ -77,6 +77,8 @@ func TestEventsForClose(t *testing.T) {
 	assert(t, ok, true)
 	assert(t, fromTransfer.Secret != utils.EmptyHash, true)
 	assert(t, ev.Secret, fromTransfer.Secret)
+	assert(t, ev.LockExpiration, fromTransfer.Expiration)
+	assert(t, ev.RevealTimeout, fromRoute.RevealTimeout())
 }
 
 /*
@@ -125,6 +127,32 @@ func TestHandleInitTarget(t *testing.T) {
 	assert(t, ev.Receiver, initiator)
 }
 
+// Init transfer refused by accept policy must give the lock back without asking for the secret.
+func TestHandleInitTargetRefused(t *testing.T) {
+	var blockNumber int64 = 1
+	var amount int64 = 1
+	var expire = int64(utest.UnitRevealTimeout) + blockNumber + 1
+	initiator := utest.HOP1
+
+	st := makeInitStateChange(utest.ADDR, amount, blockNumber, initiator, expire)
+	st.Refused = true
+	fromTransfer := st.FromTranfer
+	it := StateTransiton(nil, st)
+	assert(t, it.NewState == nil, true)
+	assert(t, len(it.Events), 2)
+	ev, ok := it.Events[0].(*mediatedtransfer.EventSendAnnounceDisposed)
+	assert(t, ok, true)
+	assert(t, ev.LockSecretHash, fromTransfer.LockSecretHash)
+	assert(t, ev.Amount, fromTransfer.Amount)
+	assert(t, ev.Receiver, st.FromRoute.HopNode())
+	_, ok = it.Events[1].(*mediatedtransfer.EventRemoveStateManager)
+	assert(t, ok, true)
+	for _, e := range it.Events {
+		_, isSecretRequest := e.(*mediatedtransfer.EventSendSecretRequest)
+		assert(t, isSecretRequest, false)
+	}
+}
+
 // Init transfer must do nothing if the expiration is bad.
 func TestHandleInitTargetBadExpiration(t *testing.T) {
 	var blockNumber int64 = 1
@@ -174,6 +202,43 @@ func TestHandleSecretReveal(t *testing.T) {
 
 }
 
+//secret must match under the hash algorithm of the received lock, the same secret hashed by another algorithm is not accepted.
+func TestHandleSecretRevealMixedHashAlgorithm(t *testing.T) {
+	var blockNumber int64 = 1
+	var amount = big.NewInt(1)
+	var expire = int64(utest.UnitRevealTimeout) + blockNumber
+	initiator := utest.HOP1
+	ourAddress := utest.ADDR
+	secret := utest.UnitSecret
+	newStateChange := func() *mediatedtransfer.ReceiveSecretRevealStateChange {
+		return &mediatedtransfer.ReceiveSecretRevealStateChange{
+			Secret:  secret,
+			Sender:  initiator,
+			Message: &encoding.RevealSecret{},
+		}
+	}
+	// lock hash is sha256(secret), but the lock claims keccak256
+	state := makeTargetState(ourAddress, amount.Int64(), blockNumber, initiator, expire)
+	state.FromRoute = utest.MakeRoute(utest.HOP2, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())
+	state.FromTransfer.HashAlgorithm = utils.HashAlgorithmKeccak256
+	it := handleSecretReveal(state, newStateChange())
+	assert(t, len(it.Events), 0)
+	assert(t, state.FromTransfer.Secret, utils.EmptyHash)
+
+	// lock of keccak256 accepts the secret
+	state = makeTargetState(ourAddress, amount.Int64(), blockNumber, initiator, expire)
+	state.FromRoute = utest.MakeRoute(utest.HOP2, amount, utest.UnitSettleTimeout, utest.UnitRevealTimeout, 0, utils.NewRandomHash())
+	state.FromTransfer.HashAlgorithm = utils.HashAlgorithmKeccak256
+	state.FromTransfer.LockSecretHash = utils.HashAlgorithmKeccak256.HashSecret(secret[:])
+	it = handleSecretReveal(state, newStateChange())
+	assert(t, len(it.Events), 1)
+	assert(t, state.State, mediatedtransfer.StateRevealSecret)
+
+	// but its secret can never be registered on chain, even in the unsafe region
+	state.BlockNumber = expire
+	assert(t, len(eventsForRegisterSecret(state)), 0)
+}
+
 func TestHandleBlock(t *testing.T) {
 	initiator := utest.HOP6
 	ourAddress := utest.ADDR
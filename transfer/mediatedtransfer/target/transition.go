@@ -2,6 +2,7 @@ package target
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
 
@@ -31,10 +32,16 @@ func eventsForRegisterSecret(state *mediatedtransfer.TargetState) (events []tran
 	if safeToWait {
 		safeToWait = !payerClosed //只要通道关闭,就应该立即注册密码,不要等过期了.
 	}
+	//secret of such lock cannot be registered on chain, the transfer can only be completed off chain
+	if !fromTransfer.HashAlgorithm.CanRegisterOnChain() {
+		return
+	}
 	if !safeToWait && secretKnown {
 		state.State = mediatedtransfer.StateWaitingRegisterSecret
 		channelClose := &mediatedtransfer.EventContractSendRegisterSecret{
-			Secret: fromTransfer.Secret,
+			Secret:         fromTransfer.Secret,
+			LockExpiration: fromTransfer.Expiration,
+			RevealTimeout:  fromRoute.RevealTimeout(),
 		}
 		events = append(events, channelClose)
 	}
@@ -53,6 +60,25 @@ func handleInitTraget(st *mediatedtransfer.ActionInitTargetStateChange) *transfe
 		BlockNumber:  blockNumber,
 		Db:           st.Db,
 	}
+	/*
+		不接受这个发起方的交易, 不发送 SecretRequest, 直接把锁还给对方, 整个交易没有任何密码相关的消息.
+	*/
+	// Initiator is not accepted, give the lock back at once instead of sending SecretRequest, so no secret material is exchanged.
+	if st.Refused {
+		disposed := &mediatedtransfer.EventSendAnnounceDisposed{
+			Token:          tr.Token,
+			Amount:         new(big.Int).Set(tr.Amount),
+			LockSecretHash: tr.LockSecretHash,
+			Expiration:     tr.Expiration,
+			Receiver:       route.HopNode(),
+		}
+		return &transfer.TransitionResult{
+			NewState: nil,
+			Events: []transfer.Event{disposed, &mediatedtransfer.EventRemoveStateManager{
+				Key: utils.Sha3(tr.LockSecretHash[:], tr.Token[:]),
+			}},
+		}
+	}
 	safeToWait := mediator.IsSafeToWait(tr, route.RevealTimeout(), blockNumber)
 	/*
 			  if there is not enough time to safely withdraw the token on-chain
@@ -118,7 +144,8 @@ func handleSecretRegisteredOnChain(state *mediatedtransfer.TargetState, st *medi
 
 // Validate and handle a ReceiveSecretReveal state change.
 func handleSecretReveal(state *mediatedtransfer.TargetState, st *mediatedtransfer.ReceiveSecretRevealStateChange) (it *transfer.TransitionResult) {
-	validSecret := utils.ShaSecret(st.Secret[:]) == state.FromTransfer.LockSecretHash
+	//secret must match under the hash algorithm of the lock, not any algorithm
+	validSecret := state.FromTransfer.SecretMatches(st.Secret)
 	// 判断是否超时,如果已经该锁已经超时,不发送secret给上家
 	isExpired := state.BlockNumber > state.FromTransfer.Expiration
 	var events []transfer.Event
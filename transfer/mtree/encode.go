@@ -0,0 +1,214 @@
+package mtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//lockEncodedLength length of Lock.AsBytes
+const lockEncodedLength = 96
+
+var errInvalidEncodedTree = errors.New("invalid encoded merkle tree")
+
+/*
+Encode 把整棵树编码成紧凑的二进制格式, 叶子顺序保持不变, 解码以后 root 和 proof 与原来完全一致.
+第 0 层就是叶子的 hash, 可以从叶子算出来, 所以不保存.
+格式: 叶子数(uint32) + 每个叶子 Lock.AsBytes + 第 0 层以上的层数(uint32) + 每层 hash 数(uint32) 和 hash.
+*/
+/*
+ *	Encode : encode the whole tree in a compact binary format.
+ *	Order of leaves is kept, so roots and proofs of the decoded tree are exactly the same.
+ *	Layer 0 are hashes of leaves, it's computed from leaves and not saved.
+ *	Format: leaves count (uint32) + Lock.AsBytes of every leaf + count of layers above layer 0 (uint32)
+ *	+ hashes count (uint32) and hashes of every such layer.
+ */
+func (m *Merkletree) Encode() []byte {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian, uint32(len(m.Leaves)))
+	for _, l := range m.Leaves {
+		_, err = buf.Write(l.AsBytes())
+	}
+	var upper [][]common.Hash
+	if len(m.Layers) > 1 {
+		upper = m.Layers[1:]
+	}
+	err = binary.Write(buf, binary.BigEndian, uint32(len(upper)))
+	for _, layer := range upper {
+		err = binary.Write(buf, binary.BigEndian, uint32(len(layer)))
+		for _, h := range layer {
+			_, err = buf.Write(h[:])
+		}
+	}
+	if err != nil {
+		log.Crit(fmt.Sprintf("Merkletree Encode err %s", err))
+	}
+	return buf.Bytes()
+}
+
+/*
+DecodeMerkleTree 解码 Encode 的结果. 第 0 层从叶子计算, 上面每一层的每个节点都和它的两个子节点核对,
+所以解码成功的树和用叶子重建的树完全一样, 只是省去了分配内存.
+*/
+/*
+ *	DecodeMerkleTree : decode a tree encoded by Encode. Layer 0 is computed from leaves,
+ *	and every node of upper layers is checked against its two children,
+ *	so a decoded tree is exactly the tree rebuilt from its leaves.
+ */
+func DecodeMerkleTree(data []byte) (m *Merkletree, err error) {
+	r := bytes.NewReader(data)
+	var n uint32
+	if err = binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, errInvalidEncodedTree
+	}
+	if uint64(n)*lockEncodedLength > uint64(r.Len()) {
+		return nil, errInvalidEncodedTree
+	}
+	m = new(Merkletree)
+	h := newHasher()
+	var elements []common.Hash
+	seen := make(map[common.Hash]bool, n)
+	for i := uint32(0); i < n; i++ {
+		l := new(Lock)
+		if err = l.FromReader(r); err != nil {
+			return nil, errInvalidEncodedTree
+		}
+		e := h.lockHash(l)
+		if seen[e] {
+			return nil, errorDuplicateElement
+		}
+		seen[e] = true
+		m.Leaves = append(m.Leaves, l)
+		elements = append(elements, e)
+	}
+	m.Layers = append(m.Layers, elements)
+	var layers uint32
+	if err = binary.Read(r, binary.BigEndian, &layers); err != nil {
+		return nil, errInvalidEncodedTree
+	}
+	for i := uint32(0); i < layers; i++ {
+		if err = binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, errInvalidEncodedTree
+		}
+		//every layer is half of the one below
+		below := m.Layers[len(m.Layers)-1]
+		if len(below) <= 1 || int(n) != lenDiv2(len(below)) || uint64(n)*common.HashLength > uint64(r.Len()) {
+			return nil, errInvalidEncodedTree
+		}
+		layer := make([]common.Hash, n)
+		for j := range layer {
+			if _, err = r.Read(layer[j][:]); err != nil {
+				return nil, errInvalidEncodedTree
+			}
+			expected := below[2*j]
+			if 2*j+1 < len(below) {
+				expected = m.hashPair(h, below[2*j], below[2*j+1])
+			}
+			if layer[j] != expected {
+				return nil, errInvalidEncodedTree
+			}
+		}
+		m.Layers = append(m.Layers, layer)
+	}
+	//top layer is root
+	if r.Len() != 0 || len(m.Layers[len(m.Layers)-1]) > 1 {
+		return nil, errInvalidEncodedTree
+	}
+	return m, nil
+}
+
+/*
+RestoreMerkleTree 从 Encode 的结果恢复树.
+解码失败, 叶子和 leaves 不一致或者 root 和 locksroot 不一致时, 用 leaves 重建.
+*/
+/*
+ *	RestoreMerkleTree : restore tree from result of Encode.
+ *	If decoding fails, its leaves differ from `leaves` or its root is not `locksroot`, the tree is rebuilt from `leaves`.
+ */
+func RestoreMerkleTree(data []byte, leaves []*Lock, locksroot common.Hash) *Merkletree {
+	if len(data) > 0 {
+		m, err := DecodeMerkleTree(data)
+		if err == nil && sameLeaves(m.Leaves, leaves) && m.MerkleRoot() == locksroot {
+			//share locks with caller like NewMerkleTree does
+			m.Leaves = leaves
+			return m
+		}
+		log.Warn(fmt.Sprintf("encoded merkle tree doesn't match locksroot %s, rebuild it, err=%v", utils.HPex(locksroot), err))
+	}
+	return NewMerkleTree(leaves)
+}
+
+func sameLeaves(a, b []*Lock) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Serialize 只编码叶子的 hash, 用于在节点间(比如路径查找时)传输树, 比 Encode 小得多.
+格式: 叶子数(uvarint) + 每个叶子的 32 字节 hash, 叶子顺序保持不变.
+*/
+/*
+ *	Serialize : encode only hashes of leaves, for transmitting trees between nodes, e.g. during pathfinding,
+ *	much smaller than Encode.
+ *	Format: leaves count (uvarint) + 32 bytes hash of every leaf, order of leaves is kept.
+ */
+func (m *Merkletree) Serialize() []byte {
+	hashes := m.leafHashes()
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(hashes)*common.HashLength)
+	buf = buf[:binary.PutUvarint(buf, uint64(len(hashes)))]
+	for _, h := range hashes {
+		buf = append(buf, h[:]...)
+	}
+	return buf
+}
+
+/*
+Deserialize 从 Serialize 的结果重建所有层, m 原来的内容被替换, WithSortedPairs 选项保持不变.
+只知道叶子的 hash, 所以 Leaves 为空, 只能用来计算 root 和 proof, 不能 AddLock 或 RemoveLock.
+叶子数不是最短编码, 长度不符或者有重复的 hash 时返回错误.
+*/
+/*
+ *	Deserialize : rebuild all layers from result of Serialize, replacing what m had, the WithSortedPairs option is kept.
+ *	Only hashes of leaves are known, so Leaves is empty and the tree is only good for roots and proofs,
+ *	not for AddLock or RemoveLock.
+ *	It fails if the count is not minimally encoded, the length doesn't match or hashes are duplicated.
+ */
+func (m *Merkletree) Deserialize(data []byte) error {
+	n, size := binary.Uvarint(data)
+	var minimal [binary.MaxVarintLen64]byte
+	if size <= 0 || size != binary.PutUvarint(minimal[:], n) {
+		return errInvalidEncodedTree
+	}
+	data = data[size:]
+	if n > uint64(len(data))/common.HashLength || uint64(len(data)) != n*common.HashLength {
+		return errInvalidEncodedTree
+	}
+	elements := make([]common.Hash, n)
+	seen := make(map[common.Hash]bool, n)
+	for i := range elements {
+		copy(elements[i][:], data[i*common.HashLength:])
+		if seen[elements[i]] {
+			return errorDuplicateElement
+		}
+		seen[elements[i]] = true
+	}
+	if n == 0 {
+		elements = nil
+	}
+	m.Leaves = nil
+	m.Layers = nil
+	m.buildMerkleTreeLayers(elements)
+	return nil
+}
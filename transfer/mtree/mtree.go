@@ -2,6 +2,8 @@ package mtree
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash"
 
 	"errors"
 
@@ -14,6 +16,8 @@ import (
 	"github.com/SmartMeshFoundation/Photon/log"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
 )
 
 var errorDuplicateElement = errors.New("Duplicated element")
@@ -25,11 +29,34 @@ const LayerLeaves = 0
 const LayerMerkleRoot = -1
 
 /*
-Merkletree is hash tree
+Merkletree is hash tree, the zero value is an empty tree ready to use
 */
 type Merkletree struct {
 	Layers [][]common.Hash
 	Leaves []*Lock
+	//positional hash pairs in positional order instead of sorting them, see WithSortedPairs
+	positional bool
+}
+
+//MerkleTreeOption option of NewMerkleTree
+type MerkleTreeOption func(m *Merkletree)
+
+/*
+WithSortedPairs 指定计算父节点时是否先把两个子节点排序.
+true 是默认值, 和部署的 TokensNetwork 合约 computeMerkleRoot 一致, 通道里的树必须用它;
+false 按位置顺序(左, 右)计算, 只用于和其他按位置顺序实现的 merkle tree 对比, VerifyProof 不能验证这种树的 proof,
+而且 Encode 不保存这个选项.
+*/
+/*
+ *	WithSortedPairs : whether sibling pairs are sorted before hashing.
+ *	true is the default and matches computeMerkleRoot of the deployed TokensNetwork contract, trees of channels must use it.
+ *	false hashes pairs in positional order (left, right), only for comparing with positional implementations,
+ *	VerifyProof can't verify proofs of such trees, and Encode doesn't keep this option.
+ */
+func WithSortedPairs(sorted bool) MerkleTreeOption {
+	return func(m *Merkletree) {
+		m.positional = !sorted
+	}
 }
 
 // EmptyTree contains no locks
@@ -50,6 +77,12 @@ type Lock struct {
 	Expiration     int64 // expiration block number
 	Amount         *big.Int
 	LockSecretHash common.Hash
+	HashAlgorithm  utils.HashAlgorithm // how secret is hashed into LockSecretHash, not part of the lock on chain
+}
+
+//MatchSecret true if secret is the secret of this lock under the lock's own hash algorithm
+func (l *Lock) MatchSecret(secret common.Hash) bool {
+	return l.HashAlgorithm.IsValid() && l.HashAlgorithm.HashSecret(secret[:]) == l.LockSecretHash
 }
 
 //AsBytes serialize Lock
@@ -98,6 +131,19 @@ func (l *Lock) Equal(l2 *Lock) bool {
 	return false
 }
 
+/*
+IsUnlockable 锁能否在链上 unlock: 密码必须已经注册, 并且注册块不晚于锁的过期块, 和合约 unlock 的检查相同.
+secretRevealBlock 为 0 表示没有注册.
+*/
+/*
+ *	IsUnlockable : whether lock can be unlocked on chain, the secret must be registered at or before
+ *	the expiration block of the lock, the same check as unlock of the contract.
+ *	secretRevealBlock 0 means not registered.
+ */
+func IsUnlockable(lock *Lock, secretRevealBlock uint64) bool {
+	return secretRevealBlock > 0 && lock.Expiration >= 0 && secretRevealBlock <= uint64(lock.Expiration)
+}
+
 /*
 NewMerkleTree create merkle tree from locks
 保证不要包含重复的锁,否则会panic
@@ -107,21 +153,16 @@ NewMerkleTree create merkle tree from locks
  *
  *	Note that do not contain repeated locks, otherwise panic will occur.
  */
-func NewMerkleTree(leaves []*Lock) (m *Merkletree) {
-	var err error
+func NewMerkleTree(leaves []*Lock, opts ...MerkleTreeOption) (m *Merkletree) {
 	elements := make([]common.Hash, len(leaves))
-	for i := 0; i < len(elements); i++ {
-		l := leaves[i]
-		buf := new(bytes.Buffer)
-		_, err = buf.Write(utils.BigIntTo32Bytes(big.NewInt(l.Expiration)))
-		_, err = buf.Write(utils.BigIntTo32Bytes(l.Amount))
-		_, err = buf.Write(l.LockSecretHash[:])
-		elements[i] = utils.Sha3(buf.Bytes())
-	}
-	if err != nil {
-		log.Crit(fmt.Sprintf("NewMerkleTree err %s", err))
+	h := newHasher()
+	for i, l := range leaves {
+		elements[i] = h.lockHash(l)
 	}
 	m = new(Merkletree)
+	for _, opt := range opts {
+		opt(m)
+	}
 	m.buildMerkleTreeLayers(elements)
 	m.Leaves = leaves
 	return m
@@ -141,7 +182,7 @@ func (m *Merkletree) buildMerkleTreeLayers(elements []common.Hash) {
 		m.Layers = append(m.Layers, []common.Hash(nil)) //make sure has one empty layer
 		return
 	}
-	elementsMap := make(map[common.Hash]bool)
+	elementsMap := make(map[common.Hash]bool, len(elements))
 	for _, e := range elements {
 		if elementsMap[e] {
 			panic(fmt.Sprintf("elements %s duplicated", e.String()))
@@ -152,18 +193,29 @@ func (m *Merkletree) buildMerkleTreeLayers(elements []common.Hash) {
 	//	return bytes.Compare(elements[i][:], elements[j][:]) == -1
 	//})
 	//m.Layers = append(m.Layers, elements)
+	//all upper layers share one array, every layer is capped so that appending to it never overwrites the next one
+	depth, upper := 1, 0
+	for n := len(elements); n > 1; n = lenDiv2(n) {
+		depth++
+		upper += lenDiv2(n)
+	}
+	nodes := make([]common.Hash, upper)
+	m.Layers = make([][]common.Hash, 0, depth)
+	h := newHasher()
 	prevLayer := elements
-	for i := 0; ; i++ {
+	for {
 		m.Layers = append(m.Layers, prevLayer)
 		if len(prevLayer) == 1 {
 			break
 		}
-		curLayer := make([]common.Hash, lenDiv2(len(prevLayer)))
+		n := lenDiv2(len(prevLayer))
+		curLayer := nodes[:n:n]
+		nodes = nodes[n:]
 		for j := 0; j < len(prevLayer); j += 2 {
 			if j == len(prevLayer)-1 {
 				curLayer[j/2] = prevLayer[j]
 			} else {
-				curLayer[j/2] = HashPair(prevLayer[j], prevLayer[j+1])
+				curLayer[j/2] = m.hashPair(h, prevLayer[j], prevLayer[j+1])
 			}
 		}
 		prevLayer = curLayer
@@ -193,23 +245,73 @@ gets the root.
 */
 func (m *Merkletree) MakeProof(element common.Hash) []common.Hash {
 	idx := 0
-	for i := range m.Layers[0] {
-		if bytes.Equal(element[:], m.Layers[0][i][:]) {
+	for i, e := range m.leafHashes() {
+		if e == element {
 			idx = i
 		}
 	}
-	var proof []common.Hash
+	return m.proofAt(idx)
+}
+
+//proofAt proof of the leaf at idx of layer 0
+func (m *Merkletree) proofAt(idx int) []common.Hash {
+	if len(m.Layers) <= 1 {
+		return nil
+	}
+	//at most one node of every layer below root
+	return m.appendProof(make([]common.Hash, 0, len(m.Layers)-1), idx)
+}
+
+//appendProof appends proof of the leaf at idx of layer 0 to dst
+func (m *Merkletree) appendProof(dst []common.Hash, idx int) []common.Hash {
 	for _, layer := range m.Layers {
 		pairidx := idx - 1
 		if idx%2 == 0 {
 			pairidx = idx + 1
 		}
 		if pairidx < len(layer) {
-			proof = append(proof, layer[pairidx])
+			dst = append(dst, layer[pairidx])
 		}
 		idx = idx / 2
 	}
-	return proof
+	return dst
+}
+
+/*
+MakeProofs 一次生成多个锁的 proof, 和对每个锁调用 MakeProof 结果相同.
+MakeProof 每次都要在 layer 0 中线性查找, n 个锁就是 O(n^2), 这里只遍历一次 layer 0, 共享已经算好的各层, 每个 proof 只需要 O(log n).
+不在树中的 lockHash 没有 proof.
+*/
+/*
+ *	MakeProofs : proofs of many locks at once, the same as calling MakeProof for each of them.
+ *	MakeProof searches layer 0 linearly every time, which is O(n^2) for n locks,
+ *	here layer 0 is walked only once and the computed layers are shared, so every proof costs O(log n).
+ *	All proofs share one array, which is capped per proof, so appending to one of them doesn't affect others.
+ *	lockHashes not in the tree have no proof.
+ */
+func (m *Merkletree) MakeProofs(lockHashes []common.Hash) map[common.Hash][]common.Hash {
+	leaves := m.leafHashes()
+	index := make(map[common.Hash]int, len(leaves))
+	for i, h := range leaves {
+		index[h] = i //the last one wins, the same as MakeProof
+	}
+	proofs := make(map[common.Hash][]common.Hash, len(lockHashes))
+	var buf []common.Hash
+	if len(m.Layers) > 1 {
+		buf = make([]common.Hash, 0, len(lockHashes)*(len(m.Layers)-1))
+	}
+	for _, h := range lockHashes {
+		if idx, ok := index[h]; ok {
+			start := len(buf)
+			buf = m.appendProof(buf, idx)
+			if len(buf) > start {
+				proofs[h] = buf[start:len(buf):len(buf)]
+			} else {
+				proofs[h] = nil //a single leaf has an empty proof, the same as MakeProof
+			}
+		}
+	}
+	return proofs
 }
 
 //Leaves2Byets get bytes of locks
@@ -238,56 +340,262 @@ ComputeMerkleRootWith 创建包含新 lock 的 merkleTree
  *	Note that make sure `include` is not contained original by the merkle tree.
  */
 func (m *Merkletree) ComputeMerkleRootWith(include *Lock) (newm *Merkletree) {
-	//我们并不会更改锁的内容,只会进行不同的排列组合.
-	leaves := make([]*Lock, len(m.Leaves))
-	copy(leaves, m.Leaves)
-	leaves = append(leaves, include)
-	newm = NewMerkleTree(leaves)
+	newm = m.Clone()
+	newm.AddLock(include)
+	return
+}
+
+/*
+ComputeMerkleRootWithout Compute the resulting merkle root if the lock `without` is exclude from the tree
+*/
+func (m *Merkletree) ComputeMerkleRootWithout(without *Lock) (newm *Merkletree, err error) {
+	newm = m.Clone()
+	_, err = newm.RemoveLock(without.Hash())
+	if err != nil {
+		err = fmt.Errorf("no such lock %s", utils.HPex(without.LockSecretHash))
+		newm = nil
+	}
 	return
 }
 
+//Clone returns a copy of m, changes on the copy don't affect m. Locks are shared since we never change them.
+func (m *Merkletree) Clone() *Merkletree {
+	newm := &Merkletree{
+		Layers:     make([][]common.Hash, len(m.Layers)),
+		positional: m.positional,
+	}
+	//layer 0 gets its own array since AddLock appends to it, the other layers share one
+	var upper int
+	for i := 1; i < len(m.Layers); i++ {
+		upper += len(m.Layers[i])
+	}
+	nodes := make([]common.Hash, upper)
+	for i, layer := range m.Layers {
+		if layer == nil {
+			continue
+		}
+		if i == 0 {
+			newm.Layers[i] = append([]common.Hash{}, layer...)
+			continue
+		}
+		n := copy(nodes, layer)
+		newm.Layers[i] = nodes[:n:n]
+		nodes = nodes[n:]
+	}
+	if m.Leaves != nil {
+		newm.Leaves = append([]*Lock{}, m.Leaves...)
+	}
+	return newm
+}
+
 /*
-返回移除without 的数组,如果不包含 without 直接返回本身
+AddLock 增量更新: 新锁追加在最后,只需要重新计算从新叶子到 root 路径上的节点,结果和 NewMerkleTree 重建完全一致.
+保证不要包含重复的锁,否则会panic
 */
 /*
- *	removeHash : function to remove array of locks that have removed `without`.
+ *	AddLock : add lock incrementally, it's appended as the last leaf, so only nodes on the path
+ *	from it to root are recomputed, result is exactly the same as rebuilding with NewMerkleTree.
  *
- *	Note that if `without` does not include in array, then return the array.
+ *	Note that lock must not be contained already, otherwise panic will occur.
  */
-func removeHash(leaves []*Lock, without *Lock) ([]*Lock, bool) {
-	var r bool
-	i := -1
-	for j := 0; j < len(leaves); j++ {
-		if leaves[j].Expiration == without.Expiration &&
-			leaves[j].Amount.Cmp(without.Amount) == 0 &&
-			leaves[j].LockSecretHash == without.LockSecretHash {
-			i = j
-			r = true
-			break
+func (m *Merkletree) AddLock(lock *Lock) (root common.Hash) {
+	h := lock.Hash()
+	for _, e := range m.leafHashes() {
+		if e == h {
+			panic(fmt.Sprintf("elements %s duplicated", h.String()))
 		}
 	}
-	if i >= 0 {
-		leaves = append(leaves[:i], leaves[i+1:]...)
+	if len(m.Layers) == 0 {
+		m.Layers = [][]common.Hash{nil}
 	}
-	return leaves, r
+	//never write into the array of leaves passed to NewMerkleTree
+	m.Leaves = append(m.Leaves[:len(m.Leaves):len(m.Leaves)], lock)
+	m.Layers[0] = append(m.Layers[0], h)
+	m.updateLayersFrom(len(m.Layers[0]) - 1)
+	return m.MerkleRoot()
 }
 
 /*
-ComputeMerkleRootWithout Compute the resulting merkle root if the lock `without` is exclude from the tree
+RemoveLock 增量更新: 移除 hash 为 lockHash 的锁, 保持其他锁的顺序, 只需要重新计算被移除位置之后的节点.
 */
-func (m *Merkletree) ComputeMerkleRootWithout(without *Lock) (newm *Merkletree, err error) {
-	leaves := make([]*Lock, len(m.Leaves))
-	copy(leaves, m.Leaves)
-	leaves, hasLock := removeHash(leaves, without)
-	if !hasLock {
-		err = fmt.Errorf("no such lock %s", utils.HPex(without.LockSecretHash))
+/*
+ *	RemoveLock : remove lock whose hash is lockHash incrementally, order of other locks is kept,
+ *	only nodes after the removed position are recomputed.
+ */
+func (m *Merkletree) RemoveLock(lockHash common.Hash) (root common.Hash, err error) {
+	idx := m.leafIndex(lockHash)
+	if idx < 0 {
+		err = fmt.Errorf("no such lock %s", utils.HPex(lockHash))
 		return
 	}
-	if len(leaves) > 0 {
-		newm = NewMerkleTree(leaves)
+	leaves := make([]*Lock, 0, len(m.Leaves)-1)
+	leaves = append(leaves, m.Leaves[:idx]...)
+	m.Leaves = append(leaves, m.Leaves[idx+1:]...)
+	m.Layers[0] = append(m.Layers[0][:idx], m.Layers[0][idx+1:]...)
+	if len(m.Leaves) == 0 {
+		m.Leaves = nil
+		m.Layers = [][]common.Hash{nil}
+		return utils.EmptyHash, nil
+	}
+	m.updateLayersFrom(idx)
+	return m.MerkleRoot(), nil
+}
+
+/*
+RootWith 计算加入 lock 以后的 root, 不修改 m, 也不复制整棵树. 新锁追加在最后, 每层只有最后一个节点变化,
+所以只需要沿着路径计算 O(log n) 个节点, 结果和 AddLock 以后的 MerkleRoot 相同.
+*/
+/*
+ *	RootWith : root after lock is added, m is neither changed nor copied. The new lock is appended as the last leaf,
+ *	only the last node of every layer changes, so O(log n) nodes on its path are computed,
+ *	the result is the same as MerkleRoot after AddLock.
+ */
+func (m *Merkletree) RootWith(lock *Lock) common.Hash {
+	h := newHasher()
+	node := h.lockHash(lock)
+	leaves := m.leafHashes()
+	idx, n := len(leaves), len(leaves)+1
+	for k := 0; n > 1; k++ {
+		if idx%2 == 1 {
+			//left sibling covers old leaves only, it's unchanged
+			node = m.hashPair(h, m.Layers[k][idx-1], node)
+		}
+		//otherwise node is the last one of this layer and moves up alone
+		idx, n = idx/2, lenDiv2(n)
+	}
+	return node
+}
+
+/*
+RootWithout 计算移除 hash 为 lockHash 的锁以后的 root, 不修改 m, 也不复制整棵树.
+被移除位置之前的节点不变, 每层只计算之后的部分, 结果和 RemoveLock 以后的 MerkleRoot 相同.
+*/
+/*
+ *	RootWithout : root after the lock whose hash is lockHash is removed, m is neither changed nor copied.
+ *	Nodes before the removed position don't change, only the rest of every layer is computed,
+ *	the result is the same as MerkleRoot after RemoveLock.
+ */
+func (m *Merkletree) RootWithout(lockHash common.Hash) (root common.Hash, err error) {
+	idx := m.leafIndex(lockHash)
+	if idx < 0 {
+		err = fmt.Errorf("no such lock %s", utils.HPex(lockHash))
 		return
 	}
-	newm = NewMerkleTree(nil)
+	leaves := m.leafHashes()
+	n := len(leaves) - 1
+	if n == 0 {
+		return utils.EmptyHash, nil
+	}
+	h := newHasher()
+	//suffix is the changed part of the current layer, from position start on
+	suffix := append([]common.Hash{}, leaves[idx+1:]...)
+	start := idx
+	at := func(k, i int) common.Hash {
+		if i >= start {
+			return suffix[i-start]
+		}
+		return m.Layers[k][i]
+	}
+	k := 0
+	for ; n > 1; k++ {
+		next := start / 2
+		parentN := lenDiv2(n)
+		parents := make([]common.Hash, parentN-next)
+		for j := next; j < parentN; j++ {
+			if 2*j == n-1 {
+				parents[j-next] = at(k, 2*j)
+			} else {
+				parents[j-next] = m.hashPair(h, at(k, 2*j), at(k, 2*j+1))
+			}
+		}
+		suffix, start, n = parents, next, parentN
+	}
+	return at(k, 0), nil
+}
+
+//leafHashes layer 0, nil for the zero value
+func (m *Merkletree) leafHashes() []common.Hash {
+	if len(m.Layers) == 0 {
+		return nil
+	}
+	return m.Layers[LayerLeaves]
+}
+
+//leafIndex position of lockHash in layer 0, -1 if not found
+func (m *Merkletree) leafIndex(lockHash common.Hash) int {
+	for i, e := range m.leafHashes() {
+		if e == lockHash {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+updateLayersFrom layer 0 从 idx 开始发生了变化, 重新计算上面各层受影响的节点.
+*/
+// updateLayersFrom : layer 0 has changed from idx on, recompute affected nodes of upper layers.
+func (m *Merkletree) updateLayersFrom(idx int) {
+	h := newHasher()
+	prevLayer := m.Layers[0]
+	layers := m.Layers[:1]
+	for k := 1; len(prevLayer) > 1; k++ {
+		idx = idx / 2
+		n := lenDiv2(len(prevLayer))
+		var curLayer []common.Hash
+		if k < len(m.Layers) {
+			curLayer = m.Layers[k]
+		}
+		if cap(curLayer) >= n {
+			curLayer = curLayer[:n]
+		} else {
+			curLayer = append(curLayer, make([]common.Hash, n-len(curLayer))...)
+		}
+		for j := idx; j < n; j++ {
+			if 2*j == len(prevLayer)-1 {
+				curLayer[j] = prevLayer[2*j]
+			} else {
+				curLayer[j] = m.hashPair(h, prevLayer[2*j], prevLayer[2*j+1])
+			}
+		}
+		layers = append(layers, curLayer)
+		prevLayer = curLayer
+	}
+	m.Layers = layers
+}
+
+/*
+Diff 比较两棵树的叶子(不是 root),返回 other 相对于 m 新增的和移除的锁的 hash
+*/
+/*
+ *	Diff : compare leaves of two trees, not their roots.
+ *	added are hashes of locks in `other` but not in `m`, removed are hashes of locks in `m` but not in `other`.
+ */
+func (m *Merkletree) Diff(other *Merkletree) (added, removed []common.Hash) {
+	oldLeaves := make(map[common.Hash]bool)
+	newLeaves := make(map[common.Hash]bool)
+	if m != nil {
+		for _, l := range m.Leaves {
+			oldLeaves[l.Hash()] = true
+		}
+	}
+	if other != nil {
+		for _, l := range other.Leaves {
+			h := l.Hash()
+			newLeaves[h] = true
+			if !oldLeaves[h] {
+				added = append(added, h)
+			}
+		}
+	}
+	if m != nil {
+		for _, l := range m.Leaves {
+			h := l.Hash()
+			if !newLeaves[h] {
+				removed = append(removed, h)
+			}
+		}
+	}
 	return
 }
 
@@ -295,8 +603,80 @@ func (m *Merkletree) String() string {
 	return fmt.Sprintf("MerkleTreeState{root:%s,layer level:%d}", m.MerkleRoot(), len(m.Layers))
 }
 
+//hashPair hash two children to their parent, as WithSortedPairs specified
+func (m *Merkletree) hashPair(h *hasher, first, second common.Hash) common.Hash {
+	if first == utils.EmptyHash {
+		return second
+	}
+	if second == utils.EmptyHash {
+		return first
+	}
+	if !m.positional && bytes.Compare(first[:], second[:]) > 0 {
+		first, second = second, first
+	}
+	return h.sum2(first, second)
+}
+
+/*
+hasher 复用 keccak256 的状态和缓冲区, 计算一棵树的所有节点只需要分配一次内存,
+而 utils.Sha3 每次都要新建状态, Sum 还会复制一次状态. 不能并发使用.
+*/
+/*
+ *	hasher : keccak256 reusing its state and buffer, so hashing all nodes of a tree allocates only once,
+ *	while utils.Sha3 creates a new state every time and Sum copies it once more. Not safe for concurrent use.
+ */
+type hasher struct {
+	state  hash.Hash
+	out    io.Reader //the same state, reading squeezes without copying it like Sum does
+	buf    [lockEncodedLength]byte
+	digest common.Hash
+}
+
+func newHasher() *hasher {
+	state := sha3.NewKeccak256()
+	return &hasher{state: state, out: state.(io.Reader)}
+}
+
+func (h *hasher) sum(data []byte) common.Hash {
+	h.state.Reset()
+	_, err := h.state.Write(data)
+	if err == nil {
+		//read into h rather than a local which would escape to heap
+		_, err = h.out.Read(h.digest[:])
+	}
+	if err != nil {
+		log.Crit(fmt.Sprintf("keccak256 err %s", err))
+	}
+	return h.digest
+}
+
+//sum2 keccak256 of first and second
+func (h *hasher) sum2(first, second common.Hash) common.Hash {
+	copy(h.buf[:], first[:])
+	copy(h.buf[common.HashLength:], second[:])
+	return h.sum(h.buf[:2*common.HashLength])
+}
+
+//lockHash the same as Lock.Hash
+func (h *hasher) lockHash(l *Lock) common.Hash {
+	b := h.buf[:]
+	//Lock.AsBytes encodes absolute value of expiration, uint64 is exact even for math.MinInt64
+	exp := l.Expiration
+	if exp < 0 {
+		exp = -exp
+	}
+	for i := 0; i < 64; i++ {
+		b[i] = 0
+	}
+	binary.BigEndian.PutUint64(b[24:32], uint64(exp))
+	//amounts are at most 32 bytes, ReadBits pads them like PaddedBigBytes without allocating
+	math.ReadBits(l.Amount, b[32:64])
+	copy(b[64:], l.LockSecretHash[:])
+	return h.sum(b)
+}
+
 /*
-HashPair makes first and secod ordered,then hash them
+HashPair makes first and secod ordered,then hash them, the same as the deployed contract
 */
 func HashPair(first, second common.Hash) common.Hash {
 	if first == utils.EmptyHash {
@@ -311,9 +691,22 @@ func HashPair(first, second common.Hash) common.Hash {
 	return utils.Sha3(first[:], second[:])
 }
 
-func checkProof(proof []common.Hash, root, hash common.Hash) bool {
-	for _, x := range proof {
-		hash = HashPair(hash, x)
+/*
+VerifyProof 验证 leaf 和 proof 能否得到 root, 计算方式和合约 computeMerkleRoot 完全一致,
+这样在提交 Unlock 之前就能发现错误的 proof, 而不是等到交易失败.
+*/
+/*
+ *	VerifyProof : check that leaf together with proof yields root, hashing and ordering are exactly the same as
+ *	computeMerkleRoot of the contract, so a wrong proof is found before Unlock is submitted instead of a failed tx.
+ */
+func VerifyProof(root common.Hash, proof []common.Hash, leaf common.Hash) bool {
+	hash := leaf
+	for _, el := range proof {
+		if bytes.Compare(hash[:], el[:]) < 0 {
+			hash = utils.Sha3(hash[:], el[:])
+		} else {
+			hash = utils.Sha3(el[:], hash[:])
+		}
 	}
 	return hash == root
 }
@@ -329,3 +722,15 @@ func Proof2Bytes(proof []common.Hash) []byte {
 	}
 	return buf.Bytes()
 }
+
+//BytesToProof convert bytes to proof, the inverse of Proof2Bytes
+func BytesToProof(b []byte) (proof []common.Hash, err error) {
+	if len(b)%32 != 0 {
+		err = fmt.Errorf("proof length %d is not a multiple of 32", len(b))
+		return
+	}
+	for i := 0; i < len(b); i += 32 {
+		proof = append(proof, common.BytesToHash(b[i:i+32]))
+	}
+	return
+}
@@ -2,13 +2,19 @@ package mtree
 
 import (
 	"bytes"
+	"encoding/json"
 	"testing"
 
 	"errors"
 
+	"math"
 	"math/big"
+	"math/rand"
+	"strconv"
+	"time"
 
 	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,7 +65,7 @@ func TestMerkleTreeOne(t *testing.T) {
 	tree := NewMerkleTree([]*Lock{lock0})
 	root := tree.MerkleRoot()
 	proof := tree.MakeProof(lock0.Hash())
-	if !checkProof(proof, root, lock0.Hash()) {
+	if !VerifyProof(root, proof, lock0.Hash()) {
 		t.Error("check proof error")
 	}
 }
@@ -71,12 +77,12 @@ func TestMerkleTreeTwo(t *testing.T) {
 	tree := NewMerkleTree(leaves)
 	root := tree.MerkleRoot()
 	proof0 := tree.MakeProof(lock0.Hash())
-	if !checkProof(proof0, root, lock0.Hash()) {
+	if !VerifyProof(root, proof0, lock0.Hash()) {
 		t.Error(errors.New("proof0 error"))
 		return
 	}
 	proof1 := tree.MakeProof(lock1.Hash())
-	if !checkProof(proof1, root, lock1.Hash()) {
+	if !VerifyProof(root, proof1, lock1.Hash()) {
 		t.Error(errors.New("proof1 error"))
 	}
 }
@@ -92,16 +98,16 @@ func TestMerkleTreeThree(t *testing.T) {
 	proof0 := tree.MakeProof(lock0.Hash())
 	//spew.Dump("layers:", tree.Layers)
 	//spew.Dump(proof0)
-	if !checkProof(proof0, root, lock0.Hash()) {
+	if !VerifyProof(root, proof0, lock0.Hash()) {
 		t.Error(errors.New("proof0 error"))
 		return
 	}
 	proof1 := tree.MakeProof(lock1.Hash())
-	if !checkProof(proof1, root, lock1.Hash()) {
+	if !VerifyProof(root, proof1, lock1.Hash()) {
 		t.Error(errors.New("proof1 error"))
 	}
 	proof2 := tree.MakeProof(lock2.Hash())
-	if !checkProof(proof2, root, lock2.Hash()) {
+	if !VerifyProof(root, proof2, lock2.Hash()) {
 		t.Error(errors.New("proof2 error"))
 	}
 }
@@ -114,9 +120,584 @@ func TestMerkleTreeMany(t *testing.T) {
 	tree := NewMerkleTree(leaves)
 	for _, l := range leaves {
 		proof := tree.MakeProof(l.Hash())
-		if !checkProof(proof, tree.MerkleRoot(), l.Hash()) {
+		if !VerifyProof(tree.MerkleRoot(), proof, l.Hash()) {
 			t.Error(errors.New("proof many error"))
 		}
 	}
 
 }
+
+func TestMerkleTreeDiff(t *testing.T) {
+	lock0 := newTestLock(0)
+	lock1 := newTestLock(1)
+	lock2 := newTestLock(2)
+	tree1 := NewMerkleTree([]*Lock{lock0, lock1})
+	tree2 := NewMerkleTree([]*Lock{lock2, lock1})
+	added, removed := tree1.Diff(tree2)
+	assert.EqualValues(t, added, []common.Hash{lock2.Hash()})
+	assert.EqualValues(t, removed, []common.Hash{lock0.Hash()})
+	//order of leaves doesn't matter
+	added, removed = tree1.Diff(NewMerkleTree([]*Lock{lock1, lock0}))
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	added, removed = NewMerkleTree(nil).Diff(tree1)
+	assert.EqualValues(t, added, []common.Hash{lock0.Hash(), lock1.Hash()})
+	assert.Empty(t, removed)
+}
+
+//assertSameAsRebuild incremental tree must be exactly the same as a tree rebuilt from its leaves
+func assertSameAsRebuild(t *testing.T, tree *Merkletree) bool {
+	rebuilt := NewMerkleTree(tree.Leaves)
+	if !assert.EqualValues(t, rebuilt.Layers, tree.Layers) {
+		return false
+	}
+	root := tree.MerkleRoot()
+	for _, l := range tree.Leaves {
+		if !VerifyProof(root, tree.MakeProof(l.Hash()), l.Hash()) {
+			t.Errorf("proof of %s error", l)
+			return false
+		}
+	}
+	return true
+}
+
+func TestMerkleTreeIncremental(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	for round := 0; round < 20; round++ {
+		tree := NewMerkleTree(nil)
+		var locks []*Lock
+		exp := 0
+		for step := 0; step < 100; step++ {
+			if len(locks) == 0 || rand.Intn(3) != 0 {
+				exp++
+				l := newTestLock(exp)
+				root := tree.AddLock(l)
+				assert.EqualValues(t, tree.MerkleRoot(), root)
+				locks = append(locks, l)
+			} else {
+				i := rand.Intn(len(locks))
+				root, err := tree.RemoveLock(locks[i].Hash())
+				assert.Nil(t, err)
+				assert.EqualValues(t, tree.MerkleRoot(), root)
+				locks = append(locks[:i], locks[i+1:]...)
+			}
+			if !assert.EqualValues(t, len(locks), len(tree.Leaves)) || !assertSameAsRebuild(t, tree) {
+				return
+			}
+		}
+		// remove all
+		for len(locks) > 0 {
+			i := rand.Intn(len(locks))
+			_, err := tree.RemoveLock(locks[i].Hash())
+			assert.Nil(t, err)
+			locks = append(locks[:i], locks[i+1:]...)
+			if !assertSameAsRebuild(t, tree) {
+				return
+			}
+		}
+		assert.EqualValues(t, utils.EmptyHash, tree.MerkleRoot())
+	}
+}
+
+func TestMerkleTreeRootWithWithout(t *testing.T) {
+	for n := 0; n < 40; n++ {
+		var leaves []*Lock
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, newTestLock(i+1))
+		}
+		tree := NewMerkleTree(leaves)
+		encoded := tree.Encode()
+		l := newTestLock(n + 1)
+		expected := NewMerkleTree(append(append([]*Lock{}, leaves...), l)).MerkleRoot()
+		assert.EqualValues(t, expected, tree.RootWith(l), "n=%d", n)
+		for i := 0; i < n; i++ {
+			var rest []*Lock
+			rest = append(rest, leaves[:i]...)
+			rest = append(rest, leaves[i+1:]...)
+			root, err := tree.RootWithout(leaves[i].Hash())
+			assert.Nil(t, err)
+			assert.EqualValues(t, NewMerkleTree(rest).MerkleRoot(), root, "n=%d,i=%d", n, i)
+		}
+		_, err := tree.RootWithout(newTestLock(n + 2).Hash())
+		assert.NotNil(t, err)
+		// m is never changed
+		assert.EqualValues(t, encoded, tree.Encode())
+	}
+}
+
+func TestMerkleTreeZeroValue(t *testing.T) {
+	var tree Merkletree
+	assert.EqualValues(t, utils.EmptyHash, tree.MerkleRoot())
+	assert.Empty(t, tree.MakeProof(utils.EmptyHash))
+	assert.Empty(t, tree.MakeProofs([]common.Hash{utils.EmptyHash}))
+	assert.EqualValues(t, NewMerkleTree(nil).Encode(), tree.Encode())
+	assert.EqualValues(t, NewMerkleTree(nil).Serialize(), tree.Serialize())
+	_, err := tree.RemoveLock(utils.EmptyHash)
+	assert.NotNil(t, err)
+	_, err = tree.RootWithout(utils.EmptyHash)
+	assert.NotNil(t, err)
+	l := newTestLock(1)
+	assert.EqualValues(t, l.Hash(), tree.RootWith(l))
+	assert.EqualValues(t, l.Hash(), tree.AddLock(l))
+	assertSameAsRebuild(t, &tree)
+	assert.EqualValues(t, tree.Layers, tree.Clone().Layers)
+}
+
+func TestMerkleTreeIncrementalNotShared(t *testing.T) {
+	leaves := make([]*Lock, 0, 10)
+	leaves = append(leaves, newTestLock(1), newTestLock(2), newTestLock(3))
+	tree := NewMerkleTree(leaves)
+	root := tree.MerkleRoot()
+	tree2 := tree.ComputeMerkleRootWith(newTestLock(4))
+	tree3 := tree.ComputeMerkleRootWith(newTestLock(5))
+	assert.EqualValues(t, root, tree.MerkleRoot())
+	assert.EqualValues(t, 3, len(tree.Leaves))
+	assert.EqualValues(t, NewMerkleTree([]*Lock{newTestLock(1), newTestLock(2), newTestLock(3), newTestLock(4)}).MerkleRoot(), tree2.MerkleRoot())
+	assert.EqualValues(t, NewMerkleTree([]*Lock{newTestLock(1), newTestLock(2), newTestLock(3), newTestLock(5)}).MerkleRoot(), tree3.MerkleRoot())
+	tree4, err := tree.ComputeMerkleRootWithout(newTestLock(1))
+	assert.Nil(t, err)
+	assert.EqualValues(t, root, tree.MerkleRoot())
+	assert.EqualValues(t, NewMerkleTree([]*Lock{newTestLock(2), newTestLock(3)}).MerkleRoot(), tree4.MerkleRoot())
+	_, err = tree.ComputeMerkleRootWithout(newTestLock(6))
+	assert.NotNil(t, err)
+	_, err = tree.RemoveLock(newTestLock(6).Hash())
+	assert.NotNil(t, err)
+}
+
+func TestVerifyProof(t *testing.T) {
+	var leaves []*Lock
+	for i := 0; i < 7; i++ {
+		leaves = append(leaves, newTestLock(i))
+	}
+	tree := NewMerkleTree(leaves)
+	root := tree.MerkleRoot()
+	for _, l := range leaves {
+		proof := tree.MakeProof(l.Hash())
+		proof2, err := BytesToProof(Proof2Bytes(proof))
+		assert.Nil(t, err)
+		assert.EqualValues(t, proof, proof2)
+		assert.True(t, VerifyProof(root, proof2, l.Hash()))
+		//wrong leaf
+		assert.False(t, VerifyProof(root, proof2, newTestLock(100).Hash()))
+		//tampered proof
+		if len(proof2) > 0 {
+			proof2[0][0] ^= 1
+			assert.False(t, VerifyProof(root, proof2, l.Hash()))
+		}
+	}
+	//single lock, root is the lock hash and proof is empty
+	proof, err := BytesToProof(nil)
+	assert.Nil(t, err)
+	assert.True(t, VerifyProof(leaves[0].Hash(), proof, leaves[0].Hash()))
+	_, err = BytesToProof(make([]byte, 33))
+	assert.NotNil(t, err)
+}
+
+func TestMerkleTreeEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 16} {
+		var leaves []*Lock
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, newTestLock(i))
+		}
+		tree := NewMerkleTree(leaves)
+		decoded, err := DecodeMerkleTree(tree.Encode())
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.EqualValues(t, tree.Layers, decoded.Layers)
+		assert.EqualValues(t, tree.MerkleRoot(), decoded.MerkleRoot())
+		for i, l := range leaves {
+			assert.True(t, l.Equal(decoded.Leaves[i]))
+			assert.EqualValues(t, tree.MakeProof(l.Hash()), decoded.MakeProof(l.Hash()))
+		}
+		restored := RestoreMerkleTree(tree.Encode(), leaves, tree.MerkleRoot())
+		assert.EqualValues(t, tree.Layers, restored.Layers)
+	}
+	leaves := []*Lock{newTestLock(0), newTestLock(1), newTestLock(2)}
+	tree := NewMerkleTree(leaves)
+	data := tree.Encode()
+	_, err := DecodeMerkleTree(data[:len(data)-1])
+	assert.NotNil(t, err)
+	_, err = DecodeMerkleTree(append(data, 0))
+	assert.NotNil(t, err)
+	//layer 0 is not saved
+	assert.Equal(t, 4+3*lockEncodedLength+4+(4+2*32)+(4+32), len(data))
+	//every node is checked against its children, even if the root is kept
+	tampered := append([]byte{}, data...)
+	tampered[4+3*lockEncodedLength+4+4] ^= 1
+	_, err = DecodeMerkleTree(tampered)
+	assert.NotNil(t, err)
+	//duplicated leaves
+	dup := NewMerkleTree([]*Lock{leaves[0]}).Encode()
+	dup[3] = 2
+	dup = append(dup[:4+lockEncodedLength], append(leaves[0].AsBytes(), dup[4+lockEncodedLength:]...)...)
+	_, err = DecodeMerkleTree(dup)
+	assert.Equal(t, errorDuplicateElement, err)
+	//tampered root, rebuild from leaves
+	data[len(data)-1] ^= 1
+	_, err = DecodeMerkleTree(data)
+	assert.NotNil(t, err)
+	restored := RestoreMerkleTree(data, leaves, tree.MerkleRoot())
+	assert.EqualValues(t, tree.Layers, restored.Layers)
+	//leaves changed since the tree was encoded, rebuild
+	leaves2 := []*Lock{leaves[0], leaves[1], newTestLock(3)}
+	tree2 := NewMerkleTree(leaves2)
+	restored = RestoreMerkleTree(tree.Encode(), leaves2, tree2.MerkleRoot())
+	assert.EqualValues(t, tree2.Layers, restored.Layers)
+	//old data without encoded tree
+	restored = RestoreMerkleTree(nil, leaves, tree.MerkleRoot())
+	assert.EqualValues(t, tree.Layers, restored.Layers)
+}
+
+func TestMerkleTreeSerialize(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 16} {
+		var leaves []*Lock
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, newTestLock(i))
+		}
+		tree := NewMerkleTree(leaves)
+		data := tree.Serialize()
+		assert.Len(t, data, 1+n*common.HashLength)
+		decoded := new(Merkletree)
+		if !assert.Nil(t, decoded.Deserialize(data)) {
+			return
+		}
+		assert.EqualValues(t, tree.Layers, decoded.Layers)
+		assert.EqualValues(t, tree.MerkleRoot(), decoded.MerkleRoot())
+		assert.Empty(t, decoded.Leaves)
+		for _, l := range leaves {
+			assert.EqualValues(t, tree.MakeProof(l.Hash()), decoded.MakeProof(l.Hash()))
+		}
+	}
+	tree := NewMerkleTree([]*Lock{newTestLock(0), newTestLock(1), newTestLock(2)})
+	data := tree.Serialize()
+	m := new(Merkletree)
+	assert.NotNil(t, m.Deserialize(nil))
+	assert.NotNil(t, m.Deserialize(data[:len(data)-1]))
+	assert.NotNil(t, m.Deserialize(append(data, 0)))
+	//count not minimally encoded
+	assert.NotNil(t, m.Deserialize(append([]byte{0x83, 0x00}, data[1:]...)))
+	//duplicated leaf
+	dup := append(data[:1+2*common.HashLength:1+2*common.HashLength], data[1:1+common.HashLength]...)
+	assert.Equal(t, errorDuplicateElement, m.Deserialize(dup))
+	//positional option is kept
+	positional := NewMerkleTree(tree.Leaves, WithSortedPairs(false))
+	m = NewMerkleTree(nil, WithSortedPairs(false))
+	assert.Nil(t, m.Deserialize(data))
+	assert.Equal(t, positional.MerkleRoot(), m.MerkleRoot())
+}
+
+//TestMerkleTreeSerializeRandom round trips valid and mutated encodings, every accepted input must encode back to itself
+func TestMerkleTreeSerializeRandom(t *testing.T) {
+	/* #nosec */
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var inputs [][]byte
+	for _, n := range []int{0, 1, 2, 3, 5, 8, 13} {
+		tree, _ := newBenchmarkTree(n)
+		data := tree.Serialize()
+		inputs = append(inputs, data)
+		for i := 0; i < 20; i++ {
+			mutated := append([]byte{}, data...)
+			switch r.Intn(4) {
+			case 0:
+				mutated = mutated[:r.Intn(len(mutated)+1)]
+			case 1:
+				mutated[r.Intn(len(mutated))] ^= byte(1 + r.Intn(255))
+			case 2:
+				mutated = append(mutated, byte(r.Intn(256)))
+			case 3:
+				mutated = append([]byte{byte(0x80 | r.Intn(128))}, mutated...)
+			}
+			inputs = append(inputs, mutated)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		random := make([]byte, r.Intn(3*common.HashLength))
+		r.Read(random)
+		inputs = append(inputs, random)
+	}
+	for _, data := range inputs {
+		m := new(Merkletree)
+		if m.Deserialize(data) != nil {
+			continue
+		}
+		if !bytes.Equal(data, m.Serialize()) {
+			t.Fatalf("round trip of %x gives %x", data, m.Serialize())
+		}
+		m2 := new(Merkletree)
+		if err := m2.Deserialize(m.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+		assert.EqualValues(t, m.Layers, m2.Layers)
+		for i, h := range m.Layers[LayerLeaves] {
+			if !VerifyProof(m.MerkleRoot(), m.proofAt(i), h) {
+				t.Fatalf("proof of leaf %d doesn't verify", i)
+			}
+		}
+	}
+}
+
+func TestMerkleTreeSortedPairs(t *testing.T) {
+	var leaves []*Lock
+	for i := 1; i <= 3; i++ {
+		leaves = append(leaves, &Lock{
+			Expiration:     int64(100 * i),
+			Amount:         big.NewInt(int64(i)),
+			LockSecretHash: utils.ShaSecret([]byte{byte(i)}),
+		})
+	}
+	//the same root as computeMerkleRoot of TokensNetwork
+	sortedRoot := common.HexToHash("0x856f1c077b9634da994896fdaf26f98a084a22346e28499e362d31fa97aac5fc")
+	positionalRoot := common.HexToHash("0x0e8cef5e04056cddfb812d6d343405885a8b4ae4aaa61d0d03bae8c4320533c7")
+	tree := NewMerkleTree(leaves)
+	assert.Equal(t, sortedRoot, tree.MerkleRoot())
+	assert.Equal(t, sortedRoot, NewMerkleTree(leaves, WithSortedPairs(true)).MerkleRoot())
+	for _, l := range leaves {
+		assert.True(t, VerifyProof(sortedRoot, tree.MakeProof(l.Hash()), l.Hash()))
+	}
+	positional := NewMerkleTree(leaves, WithSortedPairs(false))
+	assert.Equal(t, positionalRoot, positional.MerkleRoot())
+	//incremental updates keep the option
+	incremental := NewMerkleTree(leaves[:1], WithSortedPairs(false)).Clone()
+	incremental.AddLock(leaves[1])
+	incremental.AddLock(leaves[2])
+	assert.Equal(t, positionalRoot, incremental.MerkleRoot())
+}
+
+func TestIsUnlockable(t *testing.T) {
+	lock := newTestLock(100)
+	// on time
+	assert.True(t, IsUnlockable(lock, 1))
+	assert.True(t, IsUnlockable(lock, 99))
+	// registered exactly at expiration block is still valid
+	assert.True(t, IsUnlockable(lock, 100))
+	// late
+	assert.False(t, IsUnlockable(lock, 101))
+	// not registered
+	assert.False(t, IsUnlockable(lock, 0))
+}
+
+func TestMakeProofs(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 35} {
+		var leaves []*Lock
+		var hashes []common.Hash
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, newTestLock(i))
+			hashes = append(hashes, leaves[i].Hash())
+		}
+		tree := NewMerkleTree(leaves)
+		unknown := newTestLock(1000).Hash()
+		proofs := tree.MakeProofs(append(hashes, unknown))
+		assert.Len(t, proofs, n)
+		assert.NotContains(t, proofs, unknown)
+		for _, h := range hashes {
+			assert.EqualValues(t, tree.MakeProof(h), proofs[h])
+			assert.True(t, VerifyProof(tree.MerkleRoot(), proofs[h], h))
+		}
+	}
+}
+
+//referenceLayers the straightforward way the tree used to be built, with utils.Sha3 and HashPair
+func referenceLayers(leaves []*Lock) (layers [][]common.Hash) {
+	var layer []common.Hash
+	for _, l := range leaves {
+		layer = append(layer, utils.Sha3(l.AsBytes()))
+	}
+	for {
+		layers = append(layers, layer)
+		if len(layer) <= 1 {
+			return
+		}
+		var next []common.Hash
+		for j := 0; j < len(layer); j += 2 {
+			if j == len(layer)-1 {
+				next = append(next, layer[j])
+			} else {
+				next = append(next, HashPair(layer[j], layer[j+1]))
+			}
+		}
+		layer = next
+	}
+}
+
+func TestMerkleTreeSameAsReference(t *testing.T) {
+	h := newHasher()
+	for _, l := range []*Lock{
+		{Expiration: -5, Amount: big.NewInt(3), LockSecretHash: utils.NewRandomHash()},
+		{Expiration: math.MinInt64, Amount: big.NewInt(0), LockSecretHash: utils.NewRandomHash()},
+		{Expiration: math.MaxInt64, Amount: new(big.Int).Lsh(big.NewInt(1), 255), LockSecretHash: utils.NewRandomHash()},
+	} {
+		assert.EqualValues(t, l.Hash(), h.lockHash(l), l.String())
+	}
+	for _, n := range []int{0, 1, 2, 3, 7, 64, 65, 100} {
+		var leaves []*Lock
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, &Lock{Expiration: int64(i), Amount: big.NewInt(int64(i * 1000)), LockSecretHash: utils.NewRandomHash()})
+		}
+		tree := NewMerkleTree(leaves)
+		layers := referenceLayers(leaves)
+		if n == 0 {
+			assert.EqualValues(t, utils.EmptyHash, tree.MerkleRoot())
+			continue
+		}
+		assert.EqualValues(t, layers, tree.Layers)
+		for i, l := range leaves {
+			// bytes of proof sent to the contract
+			var proof []common.Hash
+			for idx, k := i, 0; k < len(layers); idx, k = idx/2, k+1 {
+				pair := idx + 1
+				if idx%2 == 1 {
+					pair = idx - 1
+				}
+				if pair < len(layers[k]) {
+					proof = append(proof, layers[k][pair])
+				}
+			}
+			assert.EqualValues(t, Proof2Bytes(proof), Proof2Bytes(tree.MakeProof(l.Hash())))
+		}
+	}
+}
+
+//TestMerkleTreeAddAfterBuild layers of a tree built at once share an array, growing one of them must not overwrite the next
+func TestMerkleTreeAddAfterBuild(t *testing.T) {
+	var leaves []*Lock
+	for i := 0; i < 37; i++ {
+		leaves = append(leaves, newTestLock(i))
+	}
+	tree := NewMerkleTree(leaves)
+	clone := tree.Clone()
+	for i := 37; i < 80; i++ {
+		tree.AddLock(newTestLock(i))
+		clone.AddLock(newTestLock(i))
+		if !assertSameAsRebuild(t, tree) || !assertSameAsRebuild(t, clone) {
+			return
+		}
+	}
+	_, err := tree.RemoveLock(leaves[3].Hash())
+	assert.Nil(t, err)
+	assertSameAsRebuild(t, tree)
+	assertSameAsRebuild(t, clone)
+}
+
+func TestMakeProofsNotShared(t *testing.T) {
+	tree, hashes := newBenchmarkTree(10)
+	proofs := tree.MakeProofs(hashes)
+	want := append([]common.Hash{}, proofs[hashes[1]]...)
+	_ = append(proofs[hashes[0]], utils.NewRandomHash())
+	assert.EqualValues(t, want, proofs[hashes[1]])
+}
+
+var benchmarkSizes = []int{100, 1000, 10000}
+
+//runBenchmarkSizes run f with trees of 100, 1k and 10k leaves, e.g. go test -bench . -benchmem ./transfer/mtree/
+func runBenchmarkSizes(b *testing.B, f func(b *testing.B, tree *Merkletree, hashes []common.Hash)) {
+	for _, n := range benchmarkSizes {
+		tree, hashes := newBenchmarkTree(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			f(b, tree, hashes)
+		})
+	}
+}
+
+func newBenchmarkTree(n int) (*Merkletree, []common.Hash) {
+	var leaves []*Lock
+	var hashes []common.Hash
+	for i := 0; i < n; i++ {
+		l := newTestLock(i)
+		leaves = append(leaves, l)
+		hashes = append(hashes, l.Hash())
+	}
+	return NewMerkleTree(leaves), hashes
+}
+
+func BenchmarkNewMerkleTree(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		for i := 0; i < b.N; i++ {
+			NewMerkleTree(tree.Leaves)
+		}
+	})
+}
+
+//BenchmarkMakeProofEach proofs of all locks one by one, compare with BenchmarkMakeProofs
+func BenchmarkMakeProofEach(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		for i := 0; i < b.N; i++ {
+			for _, h := range hashes {
+				tree.MakeProof(h)
+			}
+		}
+	})
+}
+
+func BenchmarkMakeProofs(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		for i := 0; i < b.N; i++ {
+			tree.MakeProofs(hashes)
+		}
+	})
+}
+
+//BenchmarkAddRemoveLock one lock added to and removed from the end, what a channel does for every transfer
+func BenchmarkAddRemoveLock(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		tree = tree.Clone()
+		l := newTestLock(len(hashes))
+		for i := 0; i < b.N; i++ {
+			tree.AddLock(l)
+			if _, err := tree.RemoveLock(l.Hash()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+//BenchmarkRootWithWithout what a channel computes to verify a transfer before it's accepted
+func BenchmarkRootWithWithout(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		l := newTestLock(len(hashes))
+		for i := 0; i < b.N; i++ {
+			tree.RootWith(l)
+			if _, err := tree.RootWithout(hashes[len(hashes)-1]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMerkleTreeClone(b *testing.B) {
+	runBenchmarkSizes(b, func(b *testing.B, tree *Merkletree, hashes []common.Hash) {
+		for i := 0; i < b.N; i++ {
+			tree.Clone()
+		}
+	})
+}
+
+func BenchmarkMerkleTreeSerialize(b *testing.B) {
+	tree, _ := newBenchmarkTree(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := new(Merkletree)
+		if err := m.Deserialize(tree.Serialize()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMerkleTreeSerializeJSON(b *testing.B) {
+	tree, _ := newBenchmarkTree(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := json.Marshal(tree.Layers[LayerLeaves])
+		if err != nil {
+			b.Fatal(err)
+		}
+		var hashes []common.Hash
+		if err = json.Unmarshal(data, &hashes); err != nil {
+			b.Fatal(err)
+		}
+		m := new(Merkletree)
+		m.buildMerkleTreeLayers(hashes)
+	}
+}
@@ -25,13 +25,23 @@ type State struct {
 	IsSend            bool             //用这个 route 来发送还是接收?	// whether this route is used to send or receive.
 	Fee               *big.Int         // how much fee to this channel charge charge .
 	TotalFee          *big.Int         // how much fee for all path when initiator use this route
+	/*
+		创建路由时通道的 reveal timeout, 修改通道的 reveal timeout 只影响之后发起的交易
+	*/
+	/*
+	 *	reveal timeout of this channel when route created,
+	 *	changing reveal timeout of a channel only affects transfers initiated after the change.
+	 */
+	ChannelRevealTimeout int
+	Path                 []common.Address //hops from HopNode to target, nil if unknown, only routes from PFS know it
 }
 
 //NewState create route state
 func NewState(ch *channel.Channel) *State {
 	return &State{
-		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
-		ch:                ch,
+		ChannelIdentifier:    ch.ChannelIdentifier.ChannelIdentifier,
+		ch:                   ch,
+		ChannelRevealTimeout: ch.RevealTimeout,
 	}
 }
 
@@ -52,6 +62,9 @@ func (rs *State) SettleTimeout() int {
 
 //RevealTimeout reveal timeout of this channel
 func (rs *State) RevealTimeout() int {
+	if rs.ChannelRevealTimeout > 0 {
+		return rs.ChannelRevealTimeout
+	}
 	return rs.ch.RevealTimeout
 }
 
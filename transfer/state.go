@@ -12,7 +12,6 @@ import (
 	"github.com/SmartMeshFoundation/Photon/network/rpc/contracts"
 	"github.com/SmartMeshFoundation/Photon/utils"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 )
 
 //BalanceProofState is   proof need by contract
@@ -89,12 +88,9 @@ func (bpf *BalanceProofState) IsBalanceProofValid() bool {
 	dataToSign := buf.Bytes()
 
 	hash := utils.Sha3(dataToSign)
-	signature := make([]byte, len(bpf.Signature))
-	copy(signature, bpf.Signature)
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
-	//log.Trace(fmt.Sprintf("signer =%s",utils.APex(utils.PubkeyToAddress(pubkey))))
-	return err == nil && utils.PubkeyToAddress(pubkey) != utils.EmptyAddress
+	signer, err := utils.Ecrecover(hash, bpf.Signature)
+	//log.Trace(fmt.Sprintf("signer =%s",utils.APex(signer)))
+	return err == nil && signer != utils.EmptyAddress
 }
 
 //StateName name of state
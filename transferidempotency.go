@@ -0,0 +1,85 @@
+package photon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+)
+
+/*
+交易请求去重:
+客户端超时重试可能导致重复付款, 请求可以携带客户端生成的标识, 标识在 (token, target) 范围内唯一.
+检查和记录都在主循环中进行, 所以同时到达的两个相同请求也只会发起一次交易.
+交易发起以后(有了 LockSecretHash)才记录, 没有发起就失败的请求可以用同一个标识重试.
+*/
+/*
+ *	Dedup of transfer requests:
+ *	Clients retrying after timeout may pay twice, so a request can carry a client generated identifier, scoped per (token, target).
+ *	Both lookup and save happen in main loop, so even two identical requests arriving together start only one transfer.
+ *	Identifier is saved only after the transfer is started (it has a LockSecretHash),
+ *	a request failed before that can be retried with the same identifier.
+ */
+
+func (rs *Service) transferIdempotencyRetention() time.Duration {
+	if rs.Config.TransferIdempotencyRetention > 0 {
+		return rs.Config.TransferIdempotencyRetention
+	}
+	return params.DefaultTransferIdempotencyRetention
+}
+
+//findTransferByIdentifier returns result of the existing transfer, nil if there is none or it has expired
+func (rs *Service) findTransferByIdentifier(r *transferReq) *utils.AsyncResult {
+	ti, err := rs.dao.GetTransferIdempotency(r.TokenAddress, r.Target, r.Identifier)
+	if err != nil {
+		return nil
+	}
+	if time.Since(time.Unix(ti.Timestamp, 0)) > rs.transferIdempotencyRetention() {
+		log.Trace(fmt.Sprintf("transfer identifier %s expired", r.Identifier))
+		return nil
+	}
+	if ti.Amount.Cmp(r.Amount) != 0 {
+		return utils.NewAsyncResultWithError(fmt.Errorf("identifier %s is used by a transfer of amount %s", r.Identifier, ti.Amount))
+	}
+	log.Info(fmt.Sprintf("duplicate transfer request, identifier=%s,lockSecretHash=%s", r.Identifier, utils.HPex(ti.LockSecretHash)))
+	result := utils.NewAsyncResult()
+	result.LockSecretHash = ti.LockSecretHash
+	result.DirectTransfer = ti.DirectTransfer
+	result.Duplicate = true
+	result.Result <- nil
+	return result
+}
+
+//saveTransferIdentifier save identifier of a started transfer
+func (rs *Service) saveTransferIdentifier(r *transferReq, result *utils.AsyncResult) {
+	if result.LockSecretHash == utils.EmptyHash {
+		return
+	}
+	err := rs.dao.SaveTransferIdempotency(&models.TransferIdempotency{
+		TokenAddress:   r.TokenAddress,
+		Target:         r.Target,
+		Identifier:     r.Identifier,
+		Amount:         r.Amount,
+		LockSecretHash: result.LockSecretHash,
+		DirectTransfer: result.DirectTransfer,
+		Timestamp:      time.Now().Unix(),
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTransferIdempotency identifier=%s err %s", r.Identifier, err))
+	}
+}
+
+//removeExpiredTransferIdentifiers remove expired identifiers from dedup index
+func (rs *Service) removeExpiredTransferIdentifiers() {
+	n, err := rs.dao.RemoveTransferIdempotencyBefore(time.Now().Add(-rs.transferIdempotencyRetention()).Unix())
+	if err != nil {
+		log.Error(fmt.Sprintf("RemoveTransferIdempotencyBefore err %s", err))
+		return
+	}
+	if n > 0 {
+		log.Info(fmt.Sprintf("remove %d expired transfer identifiers", n))
+	}
+}
@@ -0,0 +1,67 @@
+package photon
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferIdentifierDedup(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:    dao,
+		Config: &params.Config{TransferIdempotencyRetention: time.Hour},
+	}
+	r := &transferReq{
+		TokenAddress: utils.NewRandomAddress(),
+		Target:       utils.NewRandomAddress(),
+		Amount:       big.NewInt(10),
+		Identifier:   "order-1",
+	}
+	assert.Nil(t, rs.findTransferByIdentifier(r))
+	// failed before started, can be retried
+	rs.saveTransferIdentifier(r, utils.NewAsyncResultWithError(errors.New("no available route")))
+	assert.Nil(t, rs.findTransferByIdentifier(r))
+
+	started := utils.NewAsyncResult()
+	started.LockSecretHash = utils.NewRandomHash()
+	rs.saveTransferIdentifier(r, started)
+	result := rs.findTransferByIdentifier(r)
+	assert.NotNil(t, result)
+	assert.True(t, result.Duplicate)
+	assert.EqualValues(t, started.LockSecretHash, result.LockSecretHash)
+	assert.Nil(t, <-result.Result)
+	// the same identifier with another amount is refused
+	r2 := *r
+	r2.Amount = big.NewInt(11)
+	result = rs.findTransferByIdentifier(&r2)
+	assert.False(t, result.Duplicate)
+	assert.NotNil(t, <-result.Result)
+	// another target
+	r2 = *r
+	r2.Target = utils.NewRandomAddress()
+	assert.Nil(t, rs.findTransferByIdentifier(&r2))
+
+	// expired
+	err := dao.SaveTransferIdempotency(&models.TransferIdempotency{
+		TokenAddress:   r.TokenAddress,
+		Target:         r.Target,
+		Identifier:     r.Identifier,
+		Amount:         r.Amount,
+		LockSecretHash: started.LockSecretHash,
+		Timestamp:      time.Now().Add(-2 * time.Hour).Unix(),
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, rs.findTransferByIdentifier(r))
+	rs.removeExpiredTransferIdentifiers()
+	_, err = dao.GetTransferIdempotency(r.TokenAddress, r.Target, r.Identifier)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,283 @@
+package photon
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//errQueuedDeadlineExceeded deadline passed while the transfer was waiting in the queue
+var errQueuedDeadlineExceeded = errors.New("deadline exceeded while waiting in the queue of the channel")
+
+//outboundTransfer a mediated transfer we initiate, everything needed to start it later
+type outboundTransfer struct {
+	tokenAddress   common.Address
+	target         common.Address
+	amount         *big.Int
+	fee            *big.Int
+	maxFee         *big.Int
+	lockSecretHash common.Hash
+	secret         common.Hash
+	expiration     int64
+	deadline       int64                   //block number, 0 means no deadline
+	deadlineTime   time.Time               //wall-clock deadline, zero means no deadline
+	relock         bool                    //start again with a fresh secret if the lock expires before secret is revealed
+	attempts       int                     //routes tried by earlier attempts with other secrets
+	excludeHops    map[common.Address]bool //first hops which didn't ack earlier attempts
+	data           string
+	metadata       []byte
+	result         *utils.AsyncResult
+}
+
+//key the same as key of Transfer2StateManager
+func (t *outboundTransfer) key() common.Hash {
+	return utils.Sha3(t.lockSecretHash[:], t.tokenAddress[:])
+}
+
+/*
+outboundQueue 每个通道上等待发起的交易, 先进先出.
+同一个通道上同时进行的交易太多时, 加锁和更新 balance proof 交错进行, 会造成 nonce 冲突, balance proof 被拒绝.
+所以我们发起的交易在一个通道上未完成的锁达到上限以后, 新的交易在这个通道上排队, 有锁完成以后再依次发起.
+*/
+/*
+ *	outboundQueue : transfers waiting to be initiated on each channel, first in first out.
+ *	Too many simultaneous transfers on one channel interleave lock additions and balance proof updates,
+ *	which causes nonce races and rejected balance proofs.
+ *	So once locks we initiated on a channel reach the limit, new transfers are queued on it
+ *	and started one by one as the locks are resolved.
+ */
+type outboundQueue struct {
+	queues    map[common.Hash][]*outboundTransfer //channel identifier -> transfers in order
+	channelOf map[common.Hash]common.Hash         //key of transfer -> channel identifier
+}
+
+func newOutboundQueue() *outboundQueue {
+	return &outboundQueue{
+		queues:    make(map[common.Hash][]*outboundTransfer),
+		channelOf: make(map[common.Hash]common.Hash),
+	}
+}
+
+//Len number of queued transfers on all channels, Service created by tests may have no queue
+func (q *outboundQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.channelOf)
+}
+
+//push t to the end of the queue of channel, returns its position, starts from 1
+func (q *outboundQueue) push(channelIdentifier common.Hash, t *outboundTransfer) int {
+	q.queues[channelIdentifier] = append(q.queues[channelIdentifier], t)
+	q.channelOf[t.key()] = channelIdentifier
+	return len(q.queues[channelIdentifier])
+}
+
+//pop the first transfer of channel, nil if there is none
+func (q *outboundQueue) pop(channelIdentifier common.Hash) *outboundTransfer {
+	ts := q.queues[channelIdentifier]
+	if len(ts) == 0 {
+		return nil
+	}
+	t := ts[0]
+	q.removeAt(channelIdentifier, 0)
+	return t
+}
+
+//remove transfer of key from its queue, nil if it's not queued
+func (q *outboundQueue) remove(key common.Hash) (t *outboundTransfer, channelIdentifier common.Hash) {
+	if q.Len() == 0 {
+		return
+	}
+	channelIdentifier, ok := q.channelOf[key]
+	if !ok {
+		return
+	}
+	for i, qt := range q.queues[channelIdentifier] {
+		if qt.key() == key {
+			q.removeAt(channelIdentifier, i)
+			return qt, channelIdentifier
+		}
+	}
+	return
+}
+
+func (q *outboundQueue) removeAt(channelIdentifier common.Hash, i int) {
+	ts := q.queues[channelIdentifier]
+	delete(q.channelOf, ts[i].key())
+	ts = append(ts[:i], ts[i+1:]...)
+	if len(ts) == 0 {
+		delete(q.queues, channelIdentifier)
+	} else {
+		q.queues[channelIdentifier] = ts
+	}
+}
+
+//contains true if transfer of key is queued
+func (q *outboundQueue) contains(key common.Hash) bool {
+	if q.Len() == 0 {
+		return false
+	}
+	_, ok := q.channelOf[key]
+	return ok
+}
+
+//expired transfers whose deadline is not after blockNumber
+func (q *outboundQueue) expired(blockNumber int64) (ts []*outboundTransfer) {
+	for _, queue := range q.queues {
+		for _, t := range queue {
+			if t.deadline > 0 && t.deadline <= blockNumber {
+				ts = append(ts, t)
+			}
+		}
+	}
+	return
+}
+
+/*
+failQueuedTransferRecords 重启时调用, 排队的交易只保存在内存中, 重启以后不会再发起, 它们的记录标记为失败,
+这些交易还没有发出任何锁, 所以发起方可以放心地重新发起.
+*/
+/*
+ *	failQueuedTransferRecords : called on startup, queued transfers are only kept in memory and never start after restart,
+ *	so their records are marked failed. They never sent any lock, so initiator can safely start them again.
+ */
+func (rs *Service) failQueuedTransferRecords() {
+	records, err := rs.dao.GetTransferRecordsInPhase(models.TransferPhaseQueued)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetTransferRecordsInPhase err %s", err))
+		return
+	}
+	for _, r := range records {
+		log.Info(fmt.Sprintf("transfer %s was queued before restart, fail it", utils.HPex(r.LockSecretHash)))
+		rs.failTransferRecord(r.TokenAddress, r.LockSecretHash, models.TransferFailureRestarted, "photon restarted while the transfer was queued")
+	}
+}
+
+//outboundLocks number of locks we initiated on c which are not resolved yet
+func (rs *Service) outboundLocks(c *channel.Channel) int {
+	n := 0
+	count := func(lockSecretHash common.Hash) {
+		smkey := utils.Sha3(lockSecretHash[:], c.TokenAddress[:])
+		manager := rs.Transfer2StateManager[smkey]
+		if manager != nil && manager.Name == initiator.NameInitiatorTransition {
+			n++
+		}
+	}
+	for lockSecretHash := range c.OurState.Lock2PendingLocks {
+		count(lockSecretHash)
+	}
+	for lockSecretHash := range c.OurState.Lock2UnclaimedLocks {
+		count(lockSecretHash)
+	}
+	return n
+}
+
+//channelHasRoom true if we can initiate one more transfer on channel
+func (rs *Service) channelHasRoom(channelIdentifier common.Hash) bool {
+	c, err := rs.findChannelByIdentifier(channelIdentifier)
+	return err != nil || rs.outboundLocks(c) < rs.Config.MaxOutboundLocksPerChannel
+}
+
+//routesWithRoom routes whose channel can take one more transfer we initiate, order is kept
+func (rs *Service) routesWithRoom(routes []*route.State) (r []*route.State) {
+	for _, rt := range routes {
+		if rs.channelHasRoom(rt.ChannelIdentifier) {
+			r = append(r, rt)
+		}
+	}
+	return
+}
+
+//queueTransfer wait on channel until locks we initiated on it are resolved
+func (rs *Service) queueTransfer(channelIdentifier common.Hash, t *outboundTransfer) {
+	position := rs.outboundQueue.push(channelIdentifier, t)
+	log.Info(fmt.Sprintf("channel %s is busy, transfer %s queued at %d", utils.HPex(channelIdentifier), utils.HPex(t.lockSecretHash), position))
+	rs.updateTransferRecord(t.tokenAddress, t.lockSecretHash, func(r *models.TransferRecord) {
+		r.Phase = models.TransferPhaseQueued
+		r.QueuePosition = position
+	})
+}
+
+//updateQueuePositions save current positions of transfers queued on channel to their records
+func (rs *Service) updateQueuePositions(channelIdentifier common.Hash) {
+	for i, t := range rs.outboundQueue.queues[channelIdentifier] {
+		position := i + 1
+		rs.updateTransferRecord(t.tokenAddress, t.lockSecretHash, func(r *models.TransferRecord) {
+			r.QueuePosition = position
+		})
+	}
+}
+
+/*
+drainTransferQueues 有通道上的锁完成以后, 按顺序发起排队的交易, 直到这个通道上的锁重新达到上限.
+每次处理完消息, 链上事件和用户请求以后调用, 队列为空时什么都不做.
+*/
+/*
+ *	drainTransferQueues : start queued transfers in order on channels whose locks have been resolved,
+ *	until the channel is full again. It's called after every message, chain event and user request,
+ *	and does nothing if nothing is queued.
+ */
+func (rs *Service) drainTransferQueues() {
+	if rs.outboundQueue.Len() == 0 {
+		return
+	}
+	var channels []common.Hash
+	for channelIdentifier := range rs.outboundQueue.queues {
+		channels = append(channels, channelIdentifier)
+	}
+	for _, channelIdentifier := range channels {
+		started := false
+		for rs.channelHasRoom(channelIdentifier) {
+			t := rs.outboundQueue.pop(channelIdentifier)
+			if t == nil {
+				break
+			}
+			started = true
+			rs.updateTransferRecord(t.tokenAddress, t.lockSecretHash, func(r *models.TransferRecord) {
+				r.Phase = models.TransferPhaseRouting
+				r.QueuePosition = 0
+			})
+			rs.initiateTransfer(t, true)
+		}
+		if started {
+			rs.updateQueuePositions(channelIdentifier)
+		}
+	}
+}
+
+//failQueuedTransfer give up the transfer of key before it leaves the queue, false if it's not queued
+func (rs *Service) failQueuedTransfer(key common.Hash, reason models.TransferFailureReason, err error) bool {
+	t, channelIdentifier := rs.outboundQueue.remove(key)
+	if t == nil {
+		return false
+	}
+	rs.updateQueuePositions(channelIdentifier)
+	var status models.TransferStatusCode = models.TransferStatusFailed
+	if reason == models.TransferFailureCanceled {
+		status = models.TransferStatusCanceled
+	}
+	rs.updateTransferStatus(t.tokenAddress, t.lockSecretHash, status, err.Error())
+	rs.failTransferRecord(t.tokenAddress, t.lockSecretHash, reason, err.Error())
+	t.result.Result <- err
+	return true
+}
+
+//expireQueuedTransfers give up queued transfers whose deadline in blocks passed
+func (rs *Service) expireQueuedTransfers(blockNumber int64) {
+	if rs.outboundQueue.Len() == 0 {
+		return
+	}
+	for _, t := range rs.outboundQueue.expired(blockNumber) {
+		rs.failQueuedTransfer(t.key(), models.TransferFailureDeadlineExceeded, errQueuedDeadlineExceeded)
+	}
+}
@@ -0,0 +1,184 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/channel"
+	"github.com/SmartMeshFoundation/Photon/channel/channeltype"
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/network/graph"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOutboundTransfer(token common.Address, deadline int64) *outboundTransfer {
+	return &outboundTransfer{
+		tokenAddress:   token,
+		target:         utils.NewRandomAddress(),
+		amount:         big.NewInt(10),
+		fee:            utils.BigInt0,
+		lockSecretHash: utils.NewRandomHash(),
+		deadline:       deadline,
+		result:         utils.NewAsyncResult(),
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	q := newOutboundQueue()
+	token := utils.NewRandomAddress()
+	ch1, ch2 := utils.NewRandomHash(), utils.NewRandomHash()
+	t1, t2, t3 := newTestOutboundTransfer(token, 0), newTestOutboundTransfer(token, 10), newTestOutboundTransfer(token, 20)
+	assert.Equal(t, 1, q.push(ch1, t1))
+	assert.Equal(t, 2, q.push(ch1, t2))
+	assert.Equal(t, 1, q.push(ch2, t3))
+	assert.Equal(t, 3, q.Len())
+	assert.True(t, q.contains(t2.key()))
+
+	assert.Empty(t, q.expired(9))
+	assert.EqualValues(t, []*outboundTransfer{t2}, q.expired(10))
+	removed, channelIdentifier := q.remove(t2.key())
+	assert.Equal(t, t2, removed)
+	assert.Equal(t, ch1, channelIdentifier)
+	assert.False(t, q.contains(t2.key()))
+	removed, _ = q.remove(t2.key())
+	assert.Nil(t, removed)
+
+	removed, _ = q.remove(t3.key())
+	assert.Equal(t, t3, removed)
+	assert.Nil(t, q.pop(ch2))
+	assert.Equal(t, t1, q.pop(ch1))
+	assert.Nil(t, q.pop(ch1))
+	assert.Equal(t, 0, q.Len())
+	assert.Empty(t, q.queues)
+}
+
+func TestOutboundLocksAndRoutesWithRoom(t *testing.T) {
+	token := utils.NewRandomAddress()
+	ourLocks := func(hashes ...common.Hash) *channel.EndState {
+		s := &channel.EndState{
+			Lock2PendingLocks:   make(map[common.Hash]channeltype.PendingLock),
+			Lock2UnclaimedLocks: make(map[common.Hash]channeltype.UnlockPartialProof),
+		}
+		for i, h := range hashes {
+			if i%2 == 0 {
+				s.Lock2PendingLocks[h] = channeltype.PendingLock{}
+			} else {
+				s.Lock2UnclaimedLocks[h] = channeltype.UnlockPartialProof{}
+			}
+		}
+		return s
+	}
+	initiated1, initiated2, mediated := utils.NewRandomHash(), utils.NewRandomHash(), utils.NewRandomHash()
+	busy := &channel.Channel{TokenAddress: token, OurState: ourLocks(initiated1, initiated2, mediated)}
+	idle := &channel.Channel{TokenAddress: token, OurState: ourLocks(mediated)}
+	busyID, idleID, unknownID := utils.NewRandomHash(), utils.NewRandomHash(), utils.NewRandomHash()
+	rs := &Service{
+		Config:                &params.Config{MaxOutboundLocksPerChannel: 2},
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{
+			token: {ChannelIdentifier2Channel: map[common.Hash]*channel.Channel{busyID: busy, idleID: idle}},
+		},
+	}
+	for _, h := range []common.Hash{initiated1, initiated2} {
+		rs.Transfer2StateManager[utils.Sha3(h[:], token[:])] = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, h, token)
+	}
+	rs.Transfer2StateManager[utils.Sha3(mediated[:], token[:])] = transfer.NewStateManager(mediator.StateTransition, nil, mediator.NameMediatorTransition, mediated, token)
+
+	assert.Equal(t, 2, rs.outboundLocks(busy))
+	assert.Equal(t, 0, rs.outboundLocks(idle))
+	routes := rs.routesWithRoom([]*route.State{{ChannelIdentifier: busyID}, {ChannelIdentifier: idleID}, {ChannelIdentifier: unknownID}})
+	assert.Len(t, routes, 2)
+	assert.Equal(t, idleID, routes[0].ChannelIdentifier)
+	assert.Equal(t, unknownID, routes[1].ChannelIdentifier)
+}
+
+func TestQueuedTransferPositionCancelAndDeadline(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:                   dao,
+		Config:                &params.Config{MaxOutboundLocksPerChannel: 1},
+		NodeAddress:           utils.NewRandomAddress(),
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+		Token2ChannelGraph:    make(map[common.Address]*graph.ChannelGraph),
+		outboundQueue:         newOutboundQueue(),
+	}
+	token := utils.NewRandomAddress()
+	ch := utils.NewRandomHash()
+	var ts []*outboundTransfer
+	for i := 0; i < 3; i++ {
+		tr := newTestOutboundTransfer(token, int64(100+i))
+		rs.dao.NewTransferStatus(token, tr.lockSecretHash)
+		rs.newTransferRecord(&models.TransferRecord{
+			LockSecretHash: tr.lockSecretHash,
+			TokenAddress:   token,
+			Role:           models.TransferRoleInitiator,
+			Phase:          models.TransferPhaseRouting,
+		})
+		rs.queueTransfer(ch, tr)
+		ts = append(ts, tr)
+	}
+	record := func(tr *outboundTransfer) *models.TransferRecord {
+		r, err := dao.GetTransferRecord(token, tr.lockSecretHash)
+		assert.Empty(t, err)
+		return r
+	}
+	assert.Equal(t, models.TransferPhaseQueued, record(ts[2]).Phase)
+	assert.Equal(t, 3, record(ts[2]).QueuePosition)
+
+	// cancel the first one, others move forward
+	result := rs.cancelTransfer(&cancelTransferReq{TokenAddress: token, LockSecretHash: ts[0].lockSecretHash})
+	assert.Nil(t, <-result.Result)
+	assert.NotNil(t, <-ts[0].result.Result)
+	assert.Equal(t, models.TransferFailureCanceled, record(ts[0]).FailureReason)
+	assert.Equal(t, 1, record(ts[1]).QueuePosition)
+	assert.Equal(t, 2, record(ts[2]).QueuePosition)
+
+	// deadline of the second one passed
+	rs.expireQueuedTransfers(101)
+	assert.Equal(t, errQueuedDeadlineExceeded, <-ts[1].result.Result)
+	assert.Equal(t, models.TransferFailureDeadlineExceeded, record(ts[1]).FailureReason)
+	assert.Equal(t, 1, record(ts[2]).QueuePosition)
+
+	// the channel is gone, the last one leaves the queue and is started, it fails because the token is unknown
+	rs.drainTransferQueues()
+	assert.Equal(t, 0, rs.outboundQueue.Len())
+	assert.NotNil(t, <-ts[2].result.Result)
+	r := record(ts[2])
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.Equal(t, 0, r.QueuePosition)
+}
+
+func TestFailQueuedTransferRecords(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:           dao,
+		NotifyHandler: notify.NewNotifyHandler(),
+	}
+	token := utils.NewRandomAddress()
+	queued, routing := utils.NewRandomHash(), utils.NewRandomHash()
+	for _, r := range []*models.TransferRecord{
+		{LockSecretHash: queued, TokenAddress: token, Role: models.TransferRoleInitiator, Amount: big.NewInt(1), Phase: models.TransferPhaseQueued},
+		{LockSecretHash: routing, TokenAddress: token, Role: models.TransferRoleInitiator, Amount: big.NewInt(1), Phase: models.TransferPhaseRouting},
+	} {
+		rs.newTransferRecord(r)
+	}
+	rs.failQueuedTransferRecords()
+	r, err := dao.GetTransferRecord(token, queued)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.Equal(t, models.TransferFailureRestarted, r.FailureReason)
+	r, err = dao.GetTransferRecord(token, routing)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TransferPhaseRouting, r.Phase)
+}
@@ -0,0 +1,390 @@
+package photon
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/target"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//transferFailureReasons reasons of EventTransferSentFailed to failure reasons of transfer record
+var transferFailureReasons = map[string]models.TransferFailureReason{
+	initiator.ReasonNoRoute:              models.TransferFailureNoRoute,
+	initiator.ReasonInsufficientCapacity: models.TransferFailureInsufficientCapacity,
+	initiator.ReasonRefusedByTarget:      models.TransferFailureRefusedByTarget,
+	initiator.ReasonLockExpired:          models.TransferFailureLockExpired,
+	initiator.ReasonDeadlineExceeded:     models.TransferFailureDeadlineExceeded,
+	initiator.ReasonUserCanceled:         models.TransferFailureCanceled,
+	initiator.ReasonMaxRouteAttempts:     models.TransferFailureMaxRouteAttempts,
+}
+
+/*
+transferRecordCache 未结束的交易记录保存在内存中, 状态机的每个事件不需要再从数据库读取, 记录没有变化时也不写数据库.
+所有对交易记录的修改都通过 saveTransferRecord, 所以内存中的记录和数据库一致. 记录结束以后从内存中移除.
+*/
+/*
+ *	transferRecordCache : unfinished transfer records kept in memory, so events of state machines don't read them from db,
+ *	and nothing is written if a record doesn't change. Every change goes through saveTransferRecord,
+ *	so records in memory are the same as those in db. Finished records are removed from memory.
+ */
+type transferRecordCache struct {
+	lock    sync.Mutex
+	records map[common.Hash]*models.TransferRecord
+}
+
+func newTransferRecordCache() *transferRecordCache {
+	return &transferRecordCache{
+		records: make(map[common.Hash]*models.TransferRecord),
+	}
+}
+
+//get a copy of the record, Service created by tests may have no cache
+func (c *transferRecordCache) get(key common.Hash) *models.TransferRecord {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r := c.records[key]
+	if r == nil {
+		return nil
+	}
+	return cloneTransferRecord(r)
+}
+
+//put keep a copy of unfinished record r, finished ones are removed
+func (c *transferRecordCache) put(key common.Hash, r *models.TransferRecord) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if r.Finished() {
+		delete(c.records, key)
+		return
+	}
+	c.records[key] = cloneTransferRecord(r)
+}
+
+//cloneTransferRecord copy of r, fields changed in place by updateTransferRecord are not shared
+func cloneTransferRecord(r *models.TransferRecord) *models.TransferRecord {
+	c := *r
+	if r.Route != nil {
+		c.Route = make([]common.Address, len(r.Route))
+		copy(c.Route, r.Route)
+	}
+	if r.HopFees != nil {
+		c.HopFees = make([]models.HopFee, len(r.HopFees))
+		copy(c.HopFees, r.HopFees)
+	}
+	if r.Attempts != nil {
+		c.Attempts = make([]models.TransferAttempt, len(r.Attempts))
+		copy(c.Attempts, r.Attempts)
+	}
+	if r.Proof != nil {
+		p := *r.Proof
+		c.Proof = &p
+	}
+	return &c
+}
+
+//getTransferRecord the same as dao.GetTransferRecord, but unfinished records come from memory
+func (rs *Service) getTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash) (r *models.TransferRecord, err error) {
+	key := common.BytesToHash(models.TransferRecordKey(tokenAddress, lockSecretHash))
+	r = rs.transferRecords.get(key)
+	if r != nil {
+		return
+	}
+	r, err = rs.dao.GetTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil {
+		return
+	}
+	rs.transferRecords.put(key, r)
+	return
+}
+
+//saveTransferRecord every change of transfer records must be saved by it, see transferRecordCache
+func (rs *Service) saveTransferRecord(r *models.TransferRecord) error {
+	err := rs.dao.SaveTransferRecord(r)
+	if err != nil {
+		return err
+	}
+	rs.transferRecords.put(common.BytesToHash(models.TransferRecordKey(r.TokenAddress, r.LockSecretHash)), r)
+	return nil
+}
+
+func (rs *Service) newTransferRecord(r *models.TransferRecord) {
+	r.CreateTime = time.Now().Unix()
+	r.UpdateTime = r.CreateTime
+	err := rs.saveTransferRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTransferRecord %s err %s", utils.HPex(r.LockSecretHash), err))
+	}
+	rs.publishTransferEvent(r)
+}
+
+//updateTransferRecord apply f to record of transfer, finished records never change, nothing happens if there is no record, e.g. token swap, or f changes nothing
+func (rs *Service) updateTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash, f func(r *models.TransferRecord)) {
+	lockSecretHash = rs.relocks.originOf(lockSecretHash)
+	r, err := rs.getTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil || r.Finished() {
+		return
+	}
+	old := cloneTransferRecord(r)
+	f(r)
+	if reflect.DeepEqual(old, r) {
+		return
+	}
+	r.UpdateTime = time.Now().Unix()
+	err = rs.saveTransferRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTransferRecord %s err %s", utils.HPex(lockSecretHash), err))
+	}
+	rs.notifyTransferWatchers(r)
+	if r.Finished() {
+		rs.publishTransferEvent(r)
+	}
+}
+
+func (rs *Service) setTransferPhase(tokenAddress common.Address, lockSecretHash common.Hash, phase models.TransferPhase) {
+	rs.updateTransferRecord(tokenAddress, lockSecretHash, func(r *models.TransferRecord) {
+		r.Phase = phase
+	})
+}
+
+func (rs *Service) failTransferRecord(tokenAddress common.Address, lockSecretHash common.Hash, reason models.TransferFailureReason, message string) {
+	rs.updateTransferRecord(tokenAddress, lockSecretHash, func(r *models.TransferRecord) {
+		r.Phase = models.TransferPhaseFailed
+		r.FailureReason = reason
+		r.FailureMessage = message
+	})
+}
+
+/*
+rearmTransferDeadlines 重启时调用, 以秒为单位的 deadline 保存在交易记录中, 密码还没有发出去的交易重新开始计时, 已经过了 deadline 的立即放弃.
+*/
+/*
+ *	rearmTransferDeadlines : called on startup, wall-clock deadlines are kept in transfer records,
+ *	they are armed again for transfers whose secret is not revealed yet, those passed already are given up at once.
+ */
+func (rs *Service) rearmTransferDeadlines() {
+	for _, phase := range []models.TransferPhase{models.TransferPhaseRouting, models.TransferPhaseWaitingSecretRequest} {
+		records, err := rs.dao.GetTransferRecordsInPhase(phase)
+		if err != nil {
+			log.Error(fmt.Sprintf("GetTransferRecordsInPhase err %s", err))
+			return
+		}
+		for _, r := range records {
+			if r.Role != models.TransferRoleInitiator || r.Deadline == 0 {
+				continue
+			}
+			log.Info(fmt.Sprintf("transfer %s has deadline %s, arm it again", utils.HPex(r.LockSecretHash), time.Unix(r.Deadline, 0)))
+			rs.armTransferDeadline(r.TokenAddress, r.LockSecretHash, time.Unix(r.Deadline, 0))
+		}
+	}
+}
+
+//failAttempt set reason of the latest unfinished attempt whose first hop is hop
+func failAttempt(r *models.TransferRecord, hop common.Address, reason models.TransferFailureReason, message string) bool {
+	for i := len(r.Attempts) - 1; i >= 0; i-- {
+		a := &r.Attempts[i]
+		if a.FailureReason == "" && len(a.Route) > 1 && a.Route[1] == hop {
+			a.FailureReason = reason
+			a.FailureMessage = message
+			return true
+		}
+	}
+	return false
+}
+
+/*
+failTransferAttempt 记录某条路由失败的原因. 已经结束的记录也会更新, 因为没有别的路由可用时, 交易失败的事件在 AnnounceDisposedResponse 之前.
+*/
+/*
+ *	failTransferAttempt : record why the route through hop failed. Finished records are updated too,
+ *	because when there is no more route, transfer failed event comes before AnnounceDisposedResponse.
+ */
+func (rs *Service) failTransferAttempt(tokenAddress common.Address, lockSecretHash common.Hash, hop common.Address, reason models.TransferFailureReason, message string) {
+	lockSecretHash = rs.relocks.originOf(lockSecretHash)
+	r, err := rs.getTransferRecord(tokenAddress, lockSecretHash)
+	if err != nil || !failAttempt(r, hop, reason, message) {
+		return
+	}
+	r.UpdateTime = time.Now().Unix()
+	err = rs.saveTransferRecord(r)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveTransferRecord %s err %s", utils.HPex(lockSecretHash), err))
+	}
+}
+
+//noRouteReason why no route is found to target
+func (rs *Service) noRouteReason(tokenAddress, targetAddress common.Address, amount *big.Int) models.TransferFailureReason {
+	if _, isOnline := rs.Protocol.GetNetworkStatus(targetAddress); !isOnline {
+		return models.TransferFailureTargetOffline
+	}
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return models.TransferFailureNoRoute
+	}
+	usable := false
+	for _, c := range g.PartenerAddress2Channel {
+		if !c.CanTransfer() {
+			continue
+		}
+		usable = true
+		if c.Distributable().Cmp(amount) >= 0 {
+			return models.TransferFailureNoRoute
+		}
+	}
+	if usable {
+		return models.TransferFailureInsufficientCapacity
+	}
+	return models.TransferFailureNoRoute
+}
+
+/*
+updateTransferRecordByEvent 根据状态机的事件更新交易记录.
+发起方: routing -> waiting_secret_request -> waiting_reveal -> waiting_unlock -> success
+中转节点: routing -> waiting_reveal -> waiting_unlock -> success
+接收方: waiting_reveal -> waiting_unlock -> success
+任何时候都可能 failed, 已经结束的记录不会再改变.
+*/
+/*
+ *	updateTransferRecordByEvent : update transfer record by events of state machines.
+ *	initiator: routing -> waiting_secret_request -> waiting_reveal -> waiting_unlock -> success
+ *	mediator: routing -> waiting_reveal -> waiting_unlock -> success
+ *	target: waiting_reveal -> waiting_unlock -> success
+ *	It may fail at any time, finished records never change.
+ */
+func (eh *stateMachineEventHandler) updateTransferRecordByEvent(event transfer.Event, stateManager *transfer.StateManager) {
+	if stateManager == nil {
+		return
+	}
+	rs := eh.photon
+	lockSecretHash := stateManager.Identifier
+	var tokenAddress common.Address
+	var pairs []*mediatedtransfer.MediationPairState
+	var initiatorState *mediatedtransfer.InitiatorState
+	switch s := stateManager.CurrentState.(type) {
+	case *mediatedtransfer.InitiatorState:
+		tokenAddress = s.Transfer.Token
+		initiatorState = s
+	case *mediatedtransfer.MediatorState:
+		tokenAddress = s.Token
+		pairs = s.TransfersPair
+	case *mediatedtransfer.TargetState:
+		tokenAddress = s.FromTransfer.Token
+	default:
+		//state machine has finished, events carrying token still count
+		switch e := event.(type) {
+		case *transfer.EventTransferSentFailed:
+			tokenAddress = e.Token
+		case *transfer.EventTransferSentSuccess:
+			tokenAddress = e.Token
+		case *mediatedtransfer.EventSendAnnounceDisposedResponse:
+			tokenAddress = e.Token
+		default:
+			return
+		}
+	}
+	switch stateManager.Name {
+	case initiator.NameInitiatorTransition:
+		switch e := event.(type) {
+		case *mediatedtransfer.EventSendMediatedTransfer:
+			rs.updateTransferRecord(tokenAddress, lockSecretHash, func(r *models.TransferRecord) {
+				r.Phase = models.TransferPhaseWaitingSecretRequest
+				r.Fee = e.Fee
+				r.Route = []common.Address{rs.NodeAddress}
+				if initiatorState != nil && initiatorState.Route != nil && len(initiatorState.Route.Path) > 0 {
+					r.Route = append(r.Route, initiatorState.Route.Path...)
+				} else {
+					r.Route = append(r.Route, e.Receiver)
+					if e.Receiver != e.Target {
+						r.Route = append(r.Route, e.Target)
+					}
+				}
+				r.HopFees = nil
+				//the only mediator charges all the fee
+				if len(r.Route) == 3 && e.Fee != nil && e.Fee.Sign() > 0 {
+					r.HopFees = []models.HopFee{{Hop: r.Route[1], Fee: e.Fee}}
+				}
+				r.Attempts = append(r.Attempts, models.TransferAttempt{Route: r.Route})
+			})
+		case *mediatedtransfer.EventSendAnnounceDisposedResponse:
+			//route canceled by next hop, the next route has been tried before this event
+			reason := models.TransferFailureRefusedByMediator
+			if r, err := rs.getTransferRecord(tokenAddress, rs.relocks.originOf(lockSecretHash)); err == nil && r.Target == e.Receiver {
+				reason = models.TransferFailureRefusedByTarget
+			}
+			rs.failTransferAttempt(tokenAddress, lockSecretHash, e.Receiver, reason, "AnnounceDisposed received")
+		case *mediatedtransfer.EventSendRevealSecret:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseWaitingReveal)
+		case *mediatedtransfer.EventSendBalanceProof:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseWaitingUnlock)
+		case *transfer.EventTransferSentSuccess:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseSuccess)
+		case *transfer.EventTransferSentFailed:
+			reason, ok := transferFailureReasons[e.Reason]
+			if !ok {
+				reason = models.TransferFailureNoRoute
+			}
+			rs.updateTransferRecord(tokenAddress, lockSecretHash, func(r *models.TransferRecord) {
+				r.Phase = models.TransferPhaseFailed
+				r.FailureReason = reason
+				r.FailureMessage = e.Reason
+				//route still in use, e.g. lock expired or deadline exceeded
+				if initiatorState != nil && initiatorState.Route != nil && len(r.Route) > 1 {
+					failAttempt(r, r.Route[1], reason, e.Reason)
+				}
+			})
+		}
+	case mediator.NameMediatorTransition:
+		switch e := event.(type) {
+		case *mediatedtransfer.EventSendMediatedTransfer:
+			rs.updateTransferRecord(tokenAddress, lockSecretHash, func(r *models.TransferRecord) {
+				r.Phase = models.TransferPhaseWaitingReveal
+				for _, pair := range pairs {
+					if pair.PayeeRoute.HopNode() != e.Receiver {
+						continue
+					}
+					fee := new(big.Int).Sub(pair.PayerTransfer.Amount, pair.PayeeTransfer.Amount)
+					r.Route = []common.Address{pair.PayerRoute.HopNode(), rs.NodeAddress, e.Receiver}
+					r.Fee = fee
+					r.HopFees = []models.HopFee{{Hop: rs.NodeAddress, Fee: fee}}
+				}
+			})
+		case *mediatedtransfer.EventSendAnnounceDisposed:
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureNoRoute, "no route available, AnnounceDisposed sent back")
+		case *mediatedtransfer.EventSendRevealSecret:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseWaitingUnlock)
+		case *mediatedtransfer.EventWithdrawSuccess:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseSuccess)
+		case *mediatedtransfer.EventWithdrawFailed:
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureLockExpired, e.Reason)
+		case *mediatedtransfer.EventUnlockFailed:
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureLockExpired, e.Reason)
+		}
+	case target.NameTargetTransition:
+		switch e := event.(type) {
+		case *mediatedtransfer.EventSendSecretRequest:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseWaitingReveal)
+		case *mediatedtransfer.EventSendRevealSecret:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseWaitingUnlock)
+		case *transfer.EventTransferReceivedSuccess:
+			rs.setTransferPhase(tokenAddress, lockSecretHash, models.TransferPhaseSuccess)
+		case *mediatedtransfer.EventWithdrawFailed:
+			rs.failTransferRecord(tokenAddress, lockSecretHash, models.TransferFailureLockExpired, e.Reason)
+		}
+	}
+}
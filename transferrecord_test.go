@@ -0,0 +1,245 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/rerr"
+	"github.com/SmartMeshFoundation/Photon/transfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/Photon/transfer/route"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateTransferRecordByEvent(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:         dao,
+		NodeAddress: utils.NewRandomAddress(),
+	}
+	eh := &stateMachineEventHandler{photon: rs}
+	token := utils.NewRandomAddress()
+	target := utils.NewRandomAddress()
+	hop1 := utils.NewRandomAddress()
+	hop2 := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Initiator:      rs.NodeAddress,
+		Target:         target,
+		Amount:         big.NewInt(10),
+		Phase:          models.TransferPhaseRouting,
+	})
+	state := &mediatedtransfer.InitiatorState{
+		Transfer: &mediatedtransfer.LockedTransferState{Token: token},
+		Route:    &route.State{Path: []common.Address{hop1, hop2, target}},
+	}
+	mgr := transfer.NewStateManager(initiator.StateTransition, state, initiator.NameInitiatorTransition, lockSecretHash, token)
+	phase := func() models.TransferPhase {
+		r, err := dao.GetTransferRecord(token, lockSecretHash)
+		assert.Empty(t, err)
+		return r.Phase
+	}
+
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendMediatedTransfer{Receiver: hop1, Target: target, Fee: big.NewInt(2)}, mgr)
+	r, err := dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, models.TransferPhaseWaitingSecretRequest, r.Phase)
+	assert.EqualValues(t, []common.Address{rs.NodeAddress, hop1, hop2, target}, r.Route)
+	assert.EqualValues(t, big.NewInt(2), r.Fee)
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendRevealSecret{}, mgr)
+	assert.Equal(t, models.TransferPhaseWaitingReveal, phase())
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendBalanceProof{}, mgr)
+	assert.Equal(t, models.TransferPhaseWaitingUnlock, phase())
+	eh.updateTransferRecordByEvent(&transfer.EventTransferSentSuccess{Token: token}, mgr)
+	assert.Equal(t, models.TransferPhaseSuccess, phase())
+	// finished record never changes
+	eh.updateTransferRecordByEvent(&transfer.EventTransferSentFailed{Token: token, Reason: initiator.ReasonLockExpired}, mgr)
+	assert.Equal(t, models.TransferPhaseSuccess, phase())
+
+	// state manager finished, reason is mapped
+	lockSecretHash = utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Target:         target,
+		Phase:          models.TransferPhaseRouting,
+	})
+	mgr = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, token)
+	eh.updateTransferRecordByEvent(&transfer.EventTransferSentFailed{Token: token, Reason: initiator.ReasonRefusedByTarget}, mgr)
+	r, err = dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.Equal(t, models.TransferFailureRefusedByTarget, r.FailureReason)
+	assert.Equal(t, initiator.ReasonRefusedByTarget, r.FailureMessage)
+
+	// every route tried is recorded with why it failed
+	lockSecretHash = utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Target:         target,
+		Phase:          models.TransferPhaseRouting,
+	})
+	state = &mediatedtransfer.InitiatorState{
+		Transfer: &mediatedtransfer.LockedTransferState{Token: token},
+	}
+	mgr = transfer.NewStateManager(initiator.StateTransition, state, initiator.NameInitiatorTransition, lockSecretHash, token)
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendMediatedTransfer{Receiver: hop1, Target: target}, mgr)
+	state.Route = &route.State{Path: []common.Address{hop2, target}}
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendMediatedTransfer{Receiver: hop2, Target: target}, mgr)
+	eh.updateTransferRecordByEvent(&mediatedtransfer.EventSendAnnounceDisposedResponse{Token: token, Receiver: hop1}, mgr)
+	assert.Equal(t, models.TransferPhaseWaitingSecretRequest, phase())
+	eh.updateTransferRecordByEvent(&transfer.EventTransferSentFailed{Token: token, Reason: initiator.ReasonDeadlineExceeded}, mgr)
+	r, err = dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.EqualValues(t, []models.TransferAttempt{
+		{Route: []common.Address{rs.NodeAddress, hop1, target}, FailureReason: models.TransferFailureRefusedByMediator, FailureMessage: "AnnounceDisposed received"},
+		{Route: []common.Address{rs.NodeAddress, hop2, target}, FailureReason: models.TransferFailureDeadlineExceeded, FailureMessage: initiator.ReasonDeadlineExceeded},
+	}, r.Attempts)
+}
+
+func TestCancelTransferAfterSecretReleased(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:                   dao,
+		NodeAddress:           utils.NewRandomAddress(),
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+	}
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	cancel := func() error {
+		return <-rs.cancelTransfer(&cancelTransferReq{LockSecretHash: lockSecretHash, TokenAddress: token}).Result
+	}
+	assert.Equal(t, rerr.ErrTransferNotFound, cancel())
+
+	state := &mediatedtransfer.InitiatorState{
+		Transfer:       &mediatedtransfer.LockedTransferState{Token: token},
+		LockSecretHash: lockSecretHash,
+		RevealSecret:   &mediatedtransfer.EventSendRevealSecret{},
+	}
+	rs.Transfer2StateManager[utils.Sha3(lockSecretHash[:], token[:])] = transfer.NewStateManager(initiator.StateTransition, state, initiator.NameInitiatorTransition, lockSecretHash, token)
+	assert.Equal(t, rerr.ErrTransferCannotCancel, cancel())
+
+	// secret registered on chain, unlock is being sent
+	state.RevealSecret = nil
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Phase:          models.TransferPhaseWaitingUnlock,
+	})
+	assert.Equal(t, rerr.ErrTransferCannotCancel, cancel())
+}
+
+func TestRearmTransferDeadlines(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:         dao,
+		UserReqChan: make(chan *apiReq),
+		quitChan:    make(chan struct{}),
+	}
+	token := utils.NewRandomAddress()
+	passed, later, noDeadline, released := utils.NewRandomHash(), utils.NewRandomHash(), utils.NewRandomHash(), utils.NewRandomHash()
+	for _, r := range []*models.TransferRecord{
+		{LockSecretHash: passed, Phase: models.TransferPhaseWaitingSecretRequest, Deadline: time.Now().Add(-time.Second).Unix()},
+		{LockSecretHash: later, Phase: models.TransferPhaseRouting, Deadline: time.Now().Add(time.Hour).Unix()},
+		{LockSecretHash: noDeadline, Phase: models.TransferPhaseRouting},
+		{LockSecretHash: released, Phase: models.TransferPhaseWaitingUnlock, Deadline: time.Now().Add(-time.Second).Unix()},
+	} {
+		r.TokenAddress = token
+		r.Role = models.TransferRoleInitiator
+		r.Amount = big.NewInt(1)
+		rs.dao.NewTransferStatus(token, r.LockSecretHash)
+		rs.newTransferRecord(r)
+	}
+	rs.rearmTransferDeadlines()
+	// only the passed one fires at once
+	var req *apiReq
+	select {
+	case req = <-rs.UserReqChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadline not armed")
+	}
+	assert.Equal(t, transferDeadlineReqName, req.Name)
+	r := req.Req.(*transferDeadlineReq)
+	assert.Equal(t, passed, r.LockSecretHash)
+	req.result <- rs.transferDeadline(r)
+	select {
+	case req = <-rs.UserReqChan:
+		t.Fatalf("unexpected deadline of %s", req.Req.(*transferDeadlineReq).LockSecretHash.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+	record, err := dao.GetTransferRecord(token, passed)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TransferPhaseFailed, record.Phase)
+	assert.Equal(t, models.TransferFailureDeadlineExceeded, record.FailureReason)
+	// secret is revealed already, deadline doesn't apply
+	<-rs.transferDeadline(&transferDeadlineReq{LockSecretHash: released, TokenAddress: token}).Result
+	record, err = dao.GetTransferRecord(token, released)
+	assert.Nil(t, err)
+	assert.Equal(t, models.TransferPhaseWaitingUnlock, record.Phase)
+	close(rs.quitChan)
+}
+
+func TestTransferRecordCache(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:             dao,
+		transferRecords: newTransferRecordCache(),
+	}
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Role:           models.TransferRoleInitiator,
+		Amount:         big.NewInt(1),
+		Phase:          models.TransferPhaseRouting,
+	})
+	// changed behind the cache, unfinished records are read from memory
+	r, err := dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	r.FailureMessage = "changed in db"
+	assert.Empty(t, dao.SaveTransferRecord(r))
+	r, err = rs.getTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, "", r.FailureMessage)
+	// nothing changes, nothing is written
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseRouting)
+	r, err = dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, "changed in db", r.FailureMessage)
+	// records returned are copies
+	r, _ = rs.getTransferRecord(token, lockSecretHash)
+	r.Attempts = append(r.Attempts, models.TransferAttempt{FailureReason: models.TransferFailureNoRoute})
+	r, _ = rs.getTransferRecord(token, lockSecretHash)
+	assert.Empty(t, r.Attempts)
+
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseWaitingSecretRequest)
+	r, err = dao.GetTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, models.TransferPhaseWaitingSecretRequest, r.Phase)
+	assert.Equal(t, "", r.FailureMessage)
+	// finished records leave memory
+	rs.failTransferRecord(token, lockSecretHash, models.TransferFailureLockExpired, "expired")
+	assert.Nil(t, rs.transferRecords.get(common.BytesToHash(models.TransferRecordKey(token, lockSecretHash))))
+	r, err = rs.getTransferRecord(token, lockSecretHash)
+	assert.Empty(t, err)
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+}
@@ -0,0 +1,200 @@
+package photon
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/params"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+交易状态订阅:
+调用者发起交易以后立即返回, 之后通过 chan 收到交易记录的每一次变化, 交易结束(成功或者失败)以后 chan 被关闭.
+如果提供了 webhook 地址, 交易结束时把交易记录 POST 到这个地址, 请求体用节点私钥签名, 失败时有限次重试.
+交易结束时同样会通过 NotifyHandler 通知上层.
+*/
+/*
+ *	Subscription of transfer status:
+ *	caller returns immediately after transfer is started, then every change of the transfer record is delivered by chan,
+ *	chan is closed when transfer is finished, success or failed.
+ *	If a webhook url is provided, the record is POSTed to it when transfer is finished, body is signed by key of this node,
+ *	delivery is retried a bounded number of times.
+ *	Finished transfers are also delivered to upper app by NotifyHandler.
+ */
+
+//TransferWebhookSignatureHeader header of webhook request, signature of body by this node
+const TransferWebhookSignatureHeader = "X-Photon-Signature"
+
+//TransferWebhookNodeHeader header of webhook request, address of this node
+const TransferWebhookNodeHeader = "X-Photon-Node"
+
+//transferWatcherBuffer updates more than this are dropped if watcher doesn't read, the final state is always delivered
+const transferWatcherBuffer = 16
+
+//checkCallbackURL webhook must be an absolute http or https url
+func checkCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback url %s", callbackURL)
+	}
+	return nil
+}
+
+func copyTransferRecord(r *models.TransferRecord) *models.TransferRecord {
+	r2 := *r
+	return &r2
+}
+
+//watchTransfer must be called in main loop, current record is the first update
+func (rs *Service) watchTransfer(req *watchTransferReq) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	r, err := rs.dao.GetTransferRecord(req.TokenAddress, req.LockSecretHash)
+	if err != nil {
+		result.Result <- fmt.Errorf("transfer record of %s not found", utils.HPex(req.LockSecretHash))
+		return
+	}
+	newWatcher := func() chan *models.TransferRecord {
+		ch := make(chan *models.TransferRecord, transferWatcherBuffer)
+		ch <- copyTransferRecord(r)
+		if r.Finished() {
+			close(ch)
+			return ch
+		}
+		if rs.transferWatchers == nil {
+			rs.transferWatchers = make(map[common.Hash][]chan *models.TransferRecord)
+		}
+		key := utils.Sha3(req.LockSecretHash[:], req.TokenAddress[:])
+		rs.transferWatchers[key] = append(rs.transferWatchers[key], ch)
+		return ch
+	}
+	if len(req.CallbackURL) > 0 {
+		ch := newWatcher()
+		go deliverTransferWebhook(&http.Client{Timeout: params.TransferWebhookTimeout}, req.CallbackURL, rs.PrivateKey, ch,
+			params.TransferWebhookMaxAttempts, params.TransferWebhookRetryInterval)
+	}
+	result.Tag = (<-chan *models.TransferRecord)(newWatcher())
+	result.Result <- nil
+	return
+}
+
+//notifyTransferWatchers must be called in main loop, never blocks
+func (rs *Service) notifyTransferWatchers(r *models.TransferRecord) {
+	key := utils.Sha3(r.LockSecretHash[:], r.TokenAddress[:])
+	watchers, ok := rs.transferWatchers[key]
+	if !ok {
+		return
+	}
+	for _, ch := range watchers {
+		select {
+		case ch <- copyTransferRecord(r):
+		default:
+			if !r.Finished() {
+				log.Warn(fmt.Sprintf("transfer %s status update %s dropped, watcher is too slow", utils.HPex(r.LockSecretHash), r.Phase))
+				break
+			}
+			/*
+				最终状态一定要送达, 丢掉最早的一个更新腾出位置, 只有主循环发送, 所以一定能放进去
+			*/
+			//the final state must be delivered, drop the oldest update to make room, only main loop sends, so it always fits
+			select {
+			case old := <-ch:
+				log.Warn(fmt.Sprintf("transfer %s status update %s dropped, watcher is too slow", utils.HPex(r.LockSecretHash), old.Phase))
+			default:
+			}
+			ch <- copyTransferRecord(r)
+		}
+		if r.Finished() {
+			close(ch)
+		}
+	}
+	if r.Finished() {
+		delete(rs.transferWatchers, key)
+		if rs.NotifyHandler != nil {
+			rs.NotifyHandler.Notify(notify.LevelInfo, r)
+		}
+	}
+}
+
+/*
+deliverTransferWebhook 等待交易结束, 然后把交易记录 POST 到 callbackURL,
+非 2xx 或者网络错误时重试, 最多尝试 maxAttempts 次, 重试间隔从 retryInterval 开始每次加倍.
+*/
+/*
+ *	deliverTransferWebhook : wait until transfer finished, then POST the record to callbackURL,
+ *	retry on error or non 2xx response, at most maxAttempts times, retry interval starts from retryInterval and doubles every time.
+ */
+func deliverTransferWebhook(client *http.Client, callbackURL string, key *ecdsa.PrivateKey, updates <-chan *models.TransferRecord, maxAttempts int, retryInterval time.Duration) error {
+	var r *models.TransferRecord
+	for u := range updates {
+		r = u
+	}
+	if r == nil || !r.Finished() {
+		//updates dropped, never happens unless watcher buffer is too small
+		return errors.New("transfer not finished")
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sig, err := utils.SignData(key, body)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(retryInterval)
+			retryInterval *= 2
+		}
+		err = postTransferWebhook(client, callbackURL, body, sig, crypto.PubkeyToAddress(key.PublicKey))
+		if err == nil {
+			return nil
+		}
+		log.Warn(fmt.Sprintf("deliver webhook of transfer %s to %s, attempt %d err %s", utils.HPex(r.LockSecretHash), callbackURL, i+1, err))
+	}
+	log.Error(fmt.Sprintf("give up webhook of transfer %s to %s after %d attempts", utils.HPex(r.LockSecretHash), callbackURL, maxAttempts))
+	return err
+}
+
+func postTransferWebhook(client *http.Client, callbackURL string, body, sig []byte, node common.Address) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TransferWebhookSignatureHeader, hexutil.Encode(sig))
+	req.Header.Set(TransferWebhookNodeHeader, node.String())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//VerifyTransferWebhook returns the node which signed body of a webhook request, receivers should compare it with the node they use
+func VerifyTransferWebhook(body []byte, signature string) (node common.Address, err error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return
+	}
+	return utils.Ecrecover(utils.Sha3(body), sig)
+}
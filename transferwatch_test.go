@@ -0,0 +1,148 @@
+package photon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/Photon/codefortest"
+	"github.com/SmartMeshFoundation/Photon/models"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchTransfer(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:           dao,
+		NotifyHandler: notify.NewNotifyHandler(),
+	}
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	req := &watchTransferReq{TokenAddress: token, LockSecretHash: lockSecretHash}
+	result := rs.watchTransfer(req)
+	assert.NotNil(t, <-result.Result)
+
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Phase:          models.TransferPhaseRouting,
+	})
+	result = rs.watchTransfer(req)
+	assert.Nil(t, <-result.Result)
+	updates := result.Tag.(<-chan *models.TransferRecord)
+	assert.Equal(t, models.TransferPhaseRouting, (<-updates).Phase)
+
+	rs.setTransferPhase(token, lockSecretHash, models.TransferPhaseWaitingSecretRequest)
+	assert.Equal(t, models.TransferPhaseWaitingSecretRequest, (<-updates).Phase)
+	rs.failTransferRecord(token, lockSecretHash, models.TransferFailureNoRoute, "no route")
+	r := <-updates
+	assert.Equal(t, models.TransferPhaseFailed, r.Phase)
+	assert.Equal(t, models.TransferFailureNoRoute, r.FailureReason)
+	_, ok := <-updates
+	assert.False(t, ok)
+	assert.Empty(t, rs.transferWatchers)
+	// finished transfer is notified
+	select {
+	case n := <-rs.NotifyHandler.GetNoticeChan():
+		assert.Contains(t, n.Info, string(models.TransferFailureNoRoute))
+	default:
+		t.Error("finished transfer should be notified")
+	}
+
+	// watch a finished transfer
+	result = rs.watchTransfer(req)
+	assert.Nil(t, <-result.Result)
+	updates = result.Tag.(<-chan *models.TransferRecord)
+	assert.Equal(t, models.TransferPhaseFailed, (<-updates).Phase)
+	_, ok = <-updates
+	assert.False(t, ok)
+}
+
+func TestWatchTransferSlowWatcher(t *testing.T) {
+	dao := codefortest.NewTestDB("")
+	defer dao.CloseDB()
+	rs := &Service{
+		dao:           dao,
+		NotifyHandler: notify.NewNotifyHandler(),
+	}
+	token := utils.NewRandomAddress()
+	lockSecretHash := utils.NewRandomHash()
+	rs.newTransferRecord(&models.TransferRecord{
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   token,
+		Phase:          models.TransferPhaseRouting,
+	})
+	result := rs.watchTransfer(&watchTransferReq{TokenAddress: token, LockSecretHash: lockSecretHash})
+	assert.Nil(t, <-result.Result)
+	updates := result.Tag.(<-chan *models.TransferRecord)
+	//nobody reads updates, the buffer is full, updates changing nothing are not sent
+	phases := []models.TransferPhase{models.TransferPhaseWaitingSecretRequest, models.TransferPhaseWaitingReveal}
+	for i := 0; i < transferWatcherBuffer; i++ {
+		rs.setTransferPhase(token, lockSecretHash, phases[i%2])
+	}
+	rs.failTransferRecord(token, lockSecretHash, models.TransferFailureNoRoute, "no route")
+	var last *models.TransferRecord
+	n := 0
+	for r := range updates {
+		last = r
+		n++
+	}
+	assert.Equal(t, transferWatcherBuffer, n)
+	if assert.NotNil(t, last) {
+		assert.Equal(t, models.TransferPhaseFailed, last.Phase)
+	}
+}
+
+func TestDeliverTransferWebhook(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	node := crypto.PubkeyToAddress(key.PublicKey)
+	attempts := 0
+	var received *models.TransferRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		signer, err := VerifyTransferWebhook(body, r.Header.Get(TransferWebhookSignatureHeader))
+		assert.Nil(t, err)
+		assert.Equal(t, node, signer)
+		assert.Equal(t, node.String(), r.Header.Get(TransferWebhookNodeHeader))
+		received = &models.TransferRecord{}
+		assert.Nil(t, json.Unmarshal(body, received))
+	}))
+	defer server.Close()
+
+	lockSecretHash := utils.NewRandomHash()
+	newUpdates := func() <-chan *models.TransferRecord {
+		ch := make(chan *models.TransferRecord, 2)
+		ch <- &models.TransferRecord{LockSecretHash: lockSecretHash, Phase: models.TransferPhaseWaitingUnlock}
+		ch <- &models.TransferRecord{LockSecretHash: lockSecretHash, Phase: models.TransferPhaseSuccess}
+		close(ch)
+		return ch
+	}
+	err := deliverTransferWebhook(server.Client(), server.URL, key, newUpdates(), 5, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, lockSecretHash, received.LockSecretHash)
+	assert.Equal(t, models.TransferPhaseSuccess, received.Phase)
+
+	// bounded retry
+	attempts = -100
+	err = deliverTransferWebhook(server.Client(), server.URL, key, newUpdates(), 4, time.Millisecond)
+	assert.NotNil(t, err)
+	assert.Equal(t, -96, attempts)
+
+	assert.Nil(t, checkCallbackURL("https://example.com/photon/callback"))
+	assert.NotNil(t, checkCallbackURL("ftp://example.com/callback"))
+	assert.NotNil(t, checkCallbackURL("/callback"))
+}
@@ -0,0 +1,129 @@
+package photon
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/SmartMeshFoundation/Photon/log"
+	"github.com/SmartMeshFoundation/Photon/notify"
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+UpdateBalanceProof 被抢先:
+我们作为非关闭方提交对方的 BalanceProof 以后, 对方可能抢先提交一个 nonce 更高的 BalanceProof.
+BalanceProofUpdated 事件中没有 nonce, 所以每次收到被监视通道的事件以后从链上读取 nonce,
+比我们提交的高就通知用户, 由用户决定如何应对.
+通道 settle 以后不再监视.
+*/
+/*
+ *	UpdateBalanceProof replaced by partner:
+ *	After we, the non-closing participant, submit partner's balance proof, partner may front-run it with a higher nonce.
+ *	BalanceProofUpdated event has no nonce, so on every such event of a watched channel the nonce is read from chain,
+ *	if it is higher than the one we submitted, user is alerted and decides how to respond.
+ *	Channel is no longer watched after settled.
+ */
+
+//balanceProofWatch an UpdateBalanceProof we submitted
+type balanceProofWatch struct {
+	ChannelIdentifier common.Hash
+	TokenAddress      common.Address
+	Participant       common.Address //whose balance proof we submitted
+	Partner           common.Address //that's us
+	Nonce             uint64
+}
+
+//updateWatcher watches channels where we submitted UpdateBalanceProof
+type updateWatcher struct {
+	lock    sync.Mutex
+	watches map[common.Hash]*balanceProofWatch
+	//nonceOnChain reads nonce of w.Participant from contract
+	nonceOnChain func(w *balanceProofWatch) (uint64, error)
+	//onReplaced called when the balance proof we submitted is replaced by one with higher nonce
+	onReplaced func(w *balanceProofWatch, nonce uint64)
+}
+
+func newUpdateWatcher(nonceOnChain func(w *balanceProofWatch) (uint64, error), onReplaced func(w *balanceProofWatch, nonce uint64)) *updateWatcher {
+	return &updateWatcher{
+		watches:      make(map[common.Hash]*balanceProofWatch),
+		nonceOnChain: nonceOnChain,
+		onReplaced:   onReplaced,
+	}
+}
+
+//watch start watching channel after we submitted UpdateBalanceProof
+func (u *updateWatcher) watch(w *balanceProofWatch) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.watches[w.ChannelIdentifier] = w
+}
+
+//unwatch channel is settled
+func (u *updateWatcher) unwatch(channelIdentifier common.Hash) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	delete(u.watches, channelIdentifier)
+}
+
+func (u *updateWatcher) getWatch(channelIdentifier common.Hash) *balanceProofWatch {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.watches[channelIdentifier]
+}
+
+/*
+handleBalanceProofUpdated 读取链上 nonce, 比我们提交的高就调用 onReplaced, 每个更高的 nonce 只通知一次.
+会访问链, 不要在主循环中调用.
+*/
+/*
+ *	handleBalanceProofUpdated : read nonce from chain, call onReplaced if it is higher than ours, only once for every higher nonce.
+ *	It queries the chain, don't call it in main loop.
+ */
+func (u *updateWatcher) handleBalanceProofUpdated(st *mediatedtransfer.ContractBalanceProofUpdatedStateChange) (replaced bool) {
+	w := u.getWatch(st.ChannelIdentifier)
+	if w == nil || w.Participant != st.Participant {
+		return false
+	}
+	nonce, err := u.nonceOnChain(w)
+	if err != nil {
+		log.Error(fmt.Sprintf("query nonce of %s on channel %s err %s", utils.APex2(w.Participant), utils.HPex(w.ChannelIdentifier), err))
+		return false
+	}
+	u.lock.Lock()
+	if u.watches[w.ChannelIdentifier] != w || nonce <= w.Nonce {
+		u.lock.Unlock()
+		return false
+	}
+	ourNonce := w.Nonce
+	//don't alert again for the same proof
+	u.watches[w.ChannelIdentifier] = &balanceProofWatch{
+		ChannelIdentifier: w.ChannelIdentifier,
+		TokenAddress:      w.TokenAddress,
+		Participant:       w.Participant,
+		Partner:           w.Partner,
+		Nonce:             nonce,
+	}
+	u.lock.Unlock()
+	log.Warn(fmt.Sprintf("balance proof we submitted on channel %s is replaced, our nonce=%d,nonce on chain=%d",
+		utils.HPex(w.ChannelIdentifier), ourNonce, nonce))
+	if u.onReplaced != nil {
+		u.onReplaced(w, nonce)
+	}
+	return true
+}
+
+func (rs *Service) balanceProofNonceOnChain(w *balanceProofWatch) (uint64, error) {
+	tokenNetwork, err := rs.Chain.TokenNetwork(w.TokenAddress)
+	if err != nil {
+		return 0, err
+	}
+	_, _, nonce, err := tokenNetwork.GetChannelParticipantInfo(w.Participant, w.Partner)
+	return nonce, err
+}
+
+func (rs *Service) onBalanceProofReplaced(w *balanceProofWatch, nonce uint64) {
+	rs.NotifyHandler.Notify(notify.LevelWarn, fmt.Sprintf("通道 %s 上我们提交的 BalanceProof(nonce=%d) 被 %s 用更高的 nonce=%d 替换,请检查是否需要用最新的证据重新提交",
+		utils.HPex(w.ChannelIdentifier), w.Nonce, utils.APex2(w.Participant), nonce))
+}
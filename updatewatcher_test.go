@@ -0,0 +1,62 @@
+package photon
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/Photon/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/Photon/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateWatcherReplacedByPartner(t *testing.T) {
+	var chainNonce uint64
+	var alerts []uint64
+	u := newUpdateWatcher(func(w *balanceProofWatch) (uint64, error) {
+		return chainNonce, nil
+	}, func(w *balanceProofWatch, nonce uint64) {
+		alerts = append(alerts, nonce)
+	})
+	w := &balanceProofWatch{
+		ChannelIdentifier: utils.NewRandomHash(),
+		TokenAddress:      utils.NewRandomAddress(),
+		Participant:       utils.NewRandomAddress(),
+		Partner:           utils.NewRandomAddress(),
+		Nonce:             5,
+	}
+	st := &mediatedtransfer.ContractBalanceProofUpdatedStateChange{
+		ChannelIdentifier: w.ChannelIdentifier,
+		Participant:       w.Participant,
+		TransferAmount:    big.NewInt(10),
+		LocksRoot:         utils.NewRandomHash(),
+	}
+	// not watched yet
+	chainNonce = 6
+	assert.False(t, u.handleBalanceProofUpdated(st))
+
+	u.watch(w)
+	// our own update
+	chainNonce = 5
+	assert.False(t, u.handleBalanceProofUpdated(st))
+	assert.Empty(t, alerts)
+	// update of the other participant is not competing
+	other := *st
+	other.Participant = w.Partner
+	chainNonce = 9
+	assert.False(t, u.handleBalanceProofUpdated(&other))
+	// competing update from partner with higher nonce
+	chainNonce = 7
+	assert.True(t, u.handleBalanceProofUpdated(st))
+	assert.EqualValues(t, []uint64{7}, alerts)
+	// the same event again, alert only once
+	assert.False(t, u.handleBalanceProofUpdated(st))
+	// an even higher one
+	chainNonce = 8
+	assert.True(t, u.handleBalanceProofUpdated(st))
+	assert.EqualValues(t, []uint64{7, 8}, alerts)
+	// settled
+	u.unwatch(w.ChannelIdentifier)
+	chainNonce = 10
+	assert.False(t, u.handleBalanceProofUpdated(st))
+	assert.EqualValues(t, []uint64{7, 8}, alerts)
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+/*
+ParseDecimalAmount 把人类习惯的金额, 例如 "1.5", 按 token 的 decimals 转换为最小单位的整数.
+小数位数超过 decimals 时报错, 而不是悄悄舍掉.
+*/
+/*
+ *	ParseDecimalAmount : convert amount in human units, e.g. "1.5", to integer in the smallest unit of a token with decimals.
+ *	It's an error if there are more fractional digits than decimals, instead of silently dropping them.
+ */
+func ParseDecimalAmount(s string, decimals uint8) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 || len(s) == 0 || s == "." {
+		return nil, fmt.Errorf("invalid decimal amount %q", s)
+	}
+	integer, fraction := parts[0], ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > int(decimals) {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", s, decimals)
+	}
+	digits := integer + fraction + strings.Repeat("0", int(decimals)-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("invalid decimal amount %q", s)
+		}
+	}
+	amount, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal amount %q", s)
+	}
+	return amount, nil
+}
+
+//FormatDecimalAmount amount in the smallest unit of a token with decimals to human units, reverse of ParseDecimalAmount
+func FormatDecimalAmount(amount *big.Int, decimals uint8) string {
+	if amount == nil {
+		return ""
+	}
+	s := new(big.Int).Abs(amount).String()
+	if decimals > 0 {
+		if len(s) <= int(decimals) {
+			s = strings.Repeat("0", int(decimals)-len(s)+1) + s
+		}
+		i := len(s) - int(decimals)
+		s = strings.TrimRight(s[:i]+"."+s[i:], "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	if amount.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
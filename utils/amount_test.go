@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseDecimalAmount(t *testing.T) {
+	cases := []struct {
+		s        string
+		decimals uint8
+		expect   string
+	}{
+		{"1", 18, "1000000000000000000"},
+		{"1.5", 18, "1500000000000000000"},
+		{" 0.000000000000000001 ", 18, "1"},
+		{".25", 2, "25"},
+		{"3.", 2, "300"},
+		{"42", 0, "42"},
+	}
+	for _, c := range cases {
+		a, err := ParseDecimalAmount(c.s, c.decimals)
+		if err != nil {
+			t.Errorf("%q: %s", c.s, err)
+			continue
+		}
+		if a.String() != c.expect {
+			t.Errorf("%q with %d decimals should be %s, got %s", c.s, c.decimals, c.expect, a)
+		}
+		if back, _ := ParseDecimalAmount(FormatDecimalAmount(a, c.decimals), c.decimals); back.Cmp(a) != 0 {
+			t.Errorf("%s does not survive format %s", a, FormatDecimalAmount(a, c.decimals))
+		}
+	}
+	for _, s := range []string{"", ".", "1.2.3", "-1", "1e18", "0x10", "1,5", "1.001"} {
+		if _, err := ParseDecimalAmount(s, 2); err == nil {
+			t.Errorf("%q should be refused", s)
+		}
+	}
+}
+
+func TestFormatDecimalAmount(t *testing.T) {
+	if s := FormatDecimalAmount(big.NewInt(1500), 3); s != "1.5" {
+		t.Errorf("expect 1.5, got %s", s)
+	}
+	if s := FormatDecimalAmount(big.NewInt(7), 3); s != "0.007" {
+		t.Errorf("expect 0.007, got %s", s)
+	}
+	if s := FormatDecimalAmount(big.NewInt(2000), 3); s != "2" {
+		t.Errorf("expect 2, got %s", s)
+	}
+	if s := FormatDecimalAmount(big.NewInt(-25), 1); s != "-2.5" {
+		t.Errorf("expect -2.5, got %s", s)
+	}
+}
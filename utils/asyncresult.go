@@ -10,6 +10,8 @@ type AsyncResult struct {
 	Result         chan error
 	Tag            interface{}
 	LockSecretHash common.Hash // only for /api/1/transfer use, return LockSecretHash to caller
+	DirectTransfer bool        // only for /api/1/transfer use, true if transfer is sent by direct channel, otherwise mediated
+	Duplicate      bool        // only for /api/1/transfer use, true if a transfer with the same identifier exists, no new transfer is started
 }
 
 //NewAsyncResult create a AsyncResult
@@ -33,20 +33,31 @@ func SignData(privKey *ecdsa.PrivateKey, data []byte) (sig []byte, err error) {
 	return
 }
 
-//Ecrecover is a wrapper for crypto.Ecrecover
+//Ecrecover is a wrapper for crypto.Ecrecover, the recovered address is cached if signature cache is enabled
 func Ecrecover(hash common.Hash, signature []byte) (addr common.Address, err error) {
 	if len(signature) != 65 {
 		err = fmt.Errorf("signature errr, len=%d,signature=%s", len(signature), hex.EncodeToString(signature))
 		return
 	}
-	signature[len(signature)-1] -= 27 //why?
-	pubkey, err := crypto.Ecrecover(hash[:], signature)
+	cache := GetSignatureCache()
+	if cache != nil {
+		var ok bool
+		if addr, ok = cache.Get(hash, signature); ok {
+			return
+		}
+	}
+	//should not change signature's content, it may be shared.
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
+	sig[len(sig)-1] -= 27 //why?
+	pubkey, err := crypto.Ecrecover(hash[:], sig)
 	if err != nil {
-		signature[len(signature)-1] += 27
 		return
 	}
 	addr = PubkeyToAddress(pubkey)
-	signature[len(signature)-1] += 27
+	if cache != nil {
+		cache.Add(hash, signature, addr)
+	}
 	return
 }
 
@@ -66,6 +77,24 @@ func ShaSecret(data []byte) common.Hash {
 	return sha256.Sum256(data)
 }
 
+/*
+SecretHash 锁的 secrethash, 和合约 SecretRegistry 的计算方式完全一致, 即 sha256(secret),
+注意不是 keccak256.
+*/
+// SecretHash : lock secret hash of secret, exactly the same as contract SecretRegistry, i.e. sha256(secret), not keccak256.
+func SecretHash(secret [32]byte) common.Hash {
+	return ShaSecret(secret[:])
+}
+
+//SecretHashes lock secret hashes of secrets, in the same order
+func SecretHashes(secrets []common.Hash) []common.Hash {
+	hashes := make([]common.Hash, len(secrets))
+	for i, s := range secrets {
+		hashes[i] = SecretHash(s)
+	}
+	return hashes
+}
+
 //HPex pex for hash
 func HPex(data common.Hash) string {
 	return common.Bytes2Hex(data[:2])
@@ -104,6 +133,35 @@ func BigIntTo32Bytes(i *big.Int) []byte {
 	return buf
 }
 
+/*
+BalanceHash 计算合约中保存的 balance_hash, 与合约 calceBalanceHash 一致:
+bytes24(keccak256(locksroot, transferred_amount)), 两者都为0时为0.
+合约中只有 transferred_amount 和 locksroot, 并没有 locked amount.
+返回值和 TokenNetworkProxy.GetChannelParticipantInfo 的 balanceHash 格式相同,即 24 字节右对齐.
+*/
+/*
+ *	BalanceHash : calculate balance_hash the way contract calceBalanceHash stores it,
+ *	bytes24(keccak256(locksroot, transferred_amount)), zero when both are zero.
+ *	Contract only hashes transferred_amount and locksroot, there is no locked amount.
+ *	Result has the same layout as balanceHash returned by TokenNetworkProxy.GetChannelParticipantInfo,
+ *	i.e. the 24 bytes right aligned.
+ */
+func BalanceHash(transferredAmount *big.Int, locksRoot common.Hash) common.Hash {
+	if transferredAmount == nil {
+		transferredAmount = BigInt0
+	}
+	if transferredAmount.Sign() == 0 && locksRoot == EmptyHash {
+		return EmptyHash
+	}
+	h := Sha3(locksRoot[:], BigIntTo32Bytes(transferredAmount))
+	return common.BytesToHash(h[:24])
+}
+
+//BalanceHashMatches returns true if balance hash stored on chain equals to this balance proof, so an update is redundant.
+func BalanceHashMatches(stored common.Hash, transferredAmount *big.Int, locksRoot common.Hash) bool {
+	return stored == BalanceHash(transferredAmount, locksRoot)
+}
+
 //ReadBigInt read big.Int from buffer
 func ReadBigInt(reader io.Reader) *big.Int {
 	bi := new(big.Int)
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+HashAlgorithm 锁的 secret->secrethash 的计算方式.
+默认是 sha256, 和合约 SecretRegistry 一致, 也和 Lightning 兼容, 可以在链上注册密码.
+keccak256 的锁只能在链下完成, 因为 SecretRegistry 无法注册这种密码.
+*/
+/*
+ *	HashAlgorithm : how the secret of a lock is hashed into its lock secret hash.
+ *	The default is sha256, the same as contract SecretRegistry and Lightning, such secret can be registered on chain.
+ *	Locks of keccak256 can only be completed off chain, because SecretRegistry cannot register them.
+ */
+type HashAlgorithm uint8
+
+const (
+	//HashAlgorithmSHA256 default, zero value for backwards compatibility
+	HashAlgorithmSHA256 HashAlgorithm = iota
+	//HashAlgorithmKeccak256 off chain only
+	HashAlgorithmKeccak256
+)
+
+//HashAlgorithms all supported algorithms
+var HashAlgorithms = []HashAlgorithm{HashAlgorithmSHA256, HashAlgorithmKeccak256}
+
+//SecretHasher computes lock secret hash from secret
+type SecretHasher interface {
+	HashSecret(secret []byte) common.Hash
+}
+
+//HashSecret lock secret hash of secret
+func (a HashAlgorithm) HashSecret(secret []byte) common.Hash {
+	switch a {
+	case HashAlgorithmSHA256:
+		return sha256.Sum256(secret)
+	case HashAlgorithmKeccak256:
+		return crypto.Keccak256Hash(secret)
+	}
+	panic(fmt.Sprintf("unknown hash algorithm %d", a))
+}
+
+//IsValid true if a is supported
+func (a HashAlgorithm) IsValid() bool {
+	return a == HashAlgorithmSHA256 || a == HashAlgorithmKeccak256
+}
+
+//CanRegisterOnChain true if secret of this algorithm can be registered to SecretRegistry
+func (a HashAlgorithm) CanRegisterOnChain() bool {
+	return a == HashAlgorithmSHA256
+}
+
+func (a HashAlgorithm) String() string {
+	switch a {
+	case HashAlgorithmSHA256:
+		return "sha256"
+	case HashAlgorithmKeccak256:
+		return "keccak256"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+//HashAlgorithmOf find out which algorithm hashes secret to lockSecretHash
+func HashAlgorithmOf(secret, lockSecretHash common.Hash) (a HashAlgorithm, ok bool) {
+	for _, a = range HashAlgorithms {
+		if a.HashSecret(secret[:]) == lockSecretHash {
+			return a, true
+		}
+	}
+	return HashAlgorithmSHA256, false
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/hashicorp/golang-lru"
+)
+
+//signatureCacheKey message hash followed by the 65 bytes signature
+type signatureCacheKey [common.HashLength + 65]byte
+
+/*
+SignatureCache 缓存签名恢复出的地址, 同一个 balance proof 被重复广播时不必再做 ecrecover.
+以 (消息 hash, 签名) 为键, 超过容量时淘汰最久未用的.
+*/
+/*
+ *	SignatureCache : caches addresses recovered from signatures, so verifying the same balance proof
+ *	again, e.g. when it's rebroadcast, doesn't need another ecrecover.
+ *	It's keyed by (message hash, signature) and evicts the least recently used one when full.
+ */
+type SignatureCache struct {
+	cache  *lru.Cache
+	hits   uint64
+	misses uint64
+}
+
+//NewSignatureCache create a cache holding at most size addresses
+func NewSignatureCache(size int) (*SignatureCache, error) {
+	c, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &SignatureCache{cache: c}, nil
+}
+
+func newSignatureCacheKey(hash common.Hash, signature []byte) (key signatureCacheKey) {
+	copy(key[:], hash[:])
+	copy(key[common.HashLength:], signature)
+	return
+}
+
+//Get address recovered from signature of hash before
+func (c *SignatureCache) Get(hash common.Hash, signature []byte) (addr common.Address, ok bool) {
+	v, ok := c.cache.Get(newSignatureCacheKey(hash, signature))
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return v.(common.Address), true
+}
+
+//Add address recovered from signature of hash
+func (c *SignatureCache) Add(hash common.Hash, signature []byte, addr common.Address) {
+	c.cache.Add(newSignatureCacheKey(hash, signature), addr)
+}
+
+//Len number of cached addresses
+func (c *SignatureCache) Len() int {
+	return c.cache.Len()
+}
+
+//Stats number of hits and misses so far
+func (c *SignatureCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+var (
+	signatureCacheLock sync.RWMutex
+	signatureCache     *SignatureCache
+)
+
+//EnableSignatureCache cache addresses recovered by Ecrecover, at most size of them, size <= 0 disables the cache
+func EnableSignatureCache(size int) (err error) {
+	var c *SignatureCache
+	if size > 0 {
+		c, err = NewSignatureCache(size)
+		if err != nil {
+			return
+		}
+	}
+	signatureCacheLock.Lock()
+	signatureCache = c
+	signatureCacheLock.Unlock()
+	return
+}
+
+//GetSignatureCache the cache used by Ecrecover, nil if it's disabled
+func GetSignatureCache() *SignatureCache {
+	signatureCacheLock.RLock()
+	defer signatureCacheLock.RUnlock()
+	return signatureCache
+}
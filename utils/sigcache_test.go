@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func signForTest(t testing.TB, data []byte) (key *ecdsa.PrivateKey, hash common.Hash, sig []byte) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = SignData(key, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, Sha3(data), sig
+}
+
+func TestEcrecoverHitsSignatureCache(t *testing.T) {
+	if err := EnableSignatureCache(10); err != nil {
+		t.Fatal(err)
+	}
+	defer EnableSignatureCache(0)
+	key, hash, sig := signForTest(t, []byte("balance proof"))
+	expect := crypto.PubkeyToAddress(key.PublicKey)
+	for i := 0; i < 2; i++ {
+		addr, err := Ecrecover(hash, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != expect {
+			t.Errorf("recovered %s, expect %s", addr.String(), expect.String())
+		}
+	}
+	hits, misses := GetSignatureCache().Stats()
+	if hits != 1 || misses != 1 {
+		t.Errorf("second verification should hit the cache, hits=%d,misses=%d", hits, misses)
+	}
+	//signature must not be changed
+	sig2, _ := SignData(key, []byte("balance proof"))
+	if string(sig) != string(sig2) {
+		t.Error("signature changed by Ecrecover")
+	}
+	//a different signature of the same hash is not a hit
+	sig[0]++
+	addr, err := Ecrecover(hash, sig)
+	if err == nil && addr == expect {
+		t.Error("tampered signature should not recover the signer")
+	}
+	hits, _ = GetSignatureCache().Stats()
+	if hits != 1 {
+		t.Errorf("tampered signature should miss the cache, hits=%d", hits)
+	}
+}
+
+func TestSignatureCacheEviction(t *testing.T) {
+	c, err := NewSignatureCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []common.Hash
+	sig := make([]byte, 65)
+	for i := 0; i < 3; i++ {
+		h := NewRandomHash()
+		hashes = append(hashes, h)
+		c.Add(h, sig, NewRandomAddress())
+		if i == 1 {
+			//make the first one recently used
+			c.Get(hashes[0], sig)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("cache size should be bounded to 2, got %d", c.Len())
+	}
+	if _, ok := c.Get(hashes[0], sig); !ok {
+		t.Error("recently used one should be kept")
+	}
+	if _, ok := c.Get(hashes[1], sig); ok {
+		t.Error("least recently used one should be evicted")
+	}
+	if _, err = NewSignatureCache(0); err == nil {
+		t.Error("size 0 should be refused")
+	}
+	if err = EnableSignatureCache(0); err != nil || GetSignatureCache() != nil {
+		t.Error("size 0 should disable the cache")
+	}
+}
+
+func benchmarkEcrecover(b *testing.B, cacheSize int) {
+	if err := EnableSignatureCache(cacheSize); err != nil {
+		b.Fatal(err)
+	}
+	defer EnableSignatureCache(0)
+	_, hash, sig := signForTest(b, []byte("balance proof"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Ecrecover(hash, sig); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEcrecover(b *testing.B) {
+	benchmarkEcrecover(b, 0)
+}
+
+func BenchmarkEcrecoverCached(b *testing.B) {
+	benchmarkEcrecover(b, 1000)
+}
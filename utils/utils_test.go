@@ -61,3 +61,43 @@ func TestWriteVarInt(t *testing.T) {
 		return
 	}
 }
+
+func TestBalanceHash(t *testing.T) {
+	//keccak256(abi.encodePacked(locksroot, transferred_amount)) truncated to bytes24, as contract calceBalanceHash does
+	locksroot := common.HexToHash("0x2b0b8b2d8b2b4d5ae8c3f1d0c7b0bfc0d1a1b4c5e5b9d0c1a2b3c4d5e6f70809")
+	expect := common.HexToHash("0x6b6a5e7ca8ea7b3fb3fa00a977ae3ac5ef1e8ca19a5352d2")
+	h := BalanceHash(big.NewInt(10), locksroot)
+	if h != expect {
+		t.Errorf("balance hash expect %s,got %s", expect.String(), h.String())
+	}
+	if !BalanceHashMatches(expect, big.NewInt(10), locksroot) {
+		t.Error("should match")
+	}
+	if BalanceHashMatches(expect, big.NewInt(11), locksroot) {
+		t.Error("should not match")
+	}
+	//no transfer and no locks, contract stores zero
+	if BalanceHash(big.NewInt(0), EmptyHash) != EmptyHash {
+		t.Error("balance hash of empty balance proof should be zero")
+	}
+	if BalanceHash(big.NewInt(1), EmptyHash) == EmptyHash {
+		t.Error("balance hash of non empty balance proof should not be zero")
+	}
+}
+
+func TestSecretHash(t *testing.T) {
+	// registerSecret of SecretRegistry stores sha256(abi.encodePacked(secret))
+	secret := common.HexToHash("0xb4e12862b4433c3eab351e07ccedd64cdd16273c057ca81ab2c4c7b93cdba2ce")
+	expect := common.HexToHash("0x9aa5bea8995976cb7ee6adeeafa51445b23ef199a22785c12ca968cd13774d7f")
+	if SecretHash(secret) != expect {
+		t.Errorf("SecretHash of %s should be %s", secret.String(), expect.String())
+	}
+	other := common.HexToHash("0x01")
+	hashes := SecretHashes([]common.Hash{secret, other})
+	if len(hashes) != 2 || hashes[0] != expect || hashes[1] != common.Hash(sha256.Sum256(other[:])) {
+		t.Errorf("SecretHashes wrong %v", hashes)
+	}
+	if len(SecretHashes(nil)) != 0 {
+		t.Error("SecretHashes of nil should be empty")
+	}
+}